@@ -0,0 +1,144 @@
+// Package activitypub holds the pieces of ActivityPub needed to present twtxt feeds as read-only actors:
+// the object types, HTTP signatures, and delivery of activities to followers' inboxes.
+package activitypub
+
+/*
+Copyright 2021 G. Benjamin Morrison
+
+This file is part of getwtxt-ng.
+
+getwtxt-ng is free software: you can redistribute it and/or modify
+it under the terms of the GNU Affero General Public License as
+published by the Free Software Foundation, either version 3 of the
+License, or (at your option) any later version.
+
+getwtxt-ng is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU Affero General Public License for more details.
+
+You should have received a copy of the GNU Affero General Public License
+along with getwtxt-ng.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"html"
+	"strings"
+	"time"
+)
+
+const (
+	// ContentType is the media type of ActivityPub requests and responses.
+	ContentType = `application/activity+json`
+
+	// AcceptHeader is sent when fetching objects from other servers.
+	AcceptHeader = `application/activity+json, application/ld+json; profile="https://www.w3.org/ns/activitystreams"`
+
+	// PublicCollection addresses an activity to everyone.
+	PublicCollection = "https://www.w3.org/ns/activitystreams#Public"
+)
+
+// Context is the JSON-LD context of the objects served by the bridge.
+var Context = []string{
+	"https://www.w3.org/ns/activitystreams",
+	"https://w3id.org/security/v1",
+}
+
+// Actor represents a feed as an ActivityPub Service, or the remote actor following it.
+type Actor struct {
+	Context           interface{} `json:"@context,omitempty"`
+	ID                string      `json:"id"`
+	Type              string      `json:"type"`
+	PreferredUsername string      `json:"preferredUsername,omitempty"`
+	Name              string      `json:"name,omitempty"`
+	Summary           string      `json:"summary,omitempty"`
+	URL               string      `json:"url,omitempty"`
+	Inbox             string      `json:"inbox"`
+	Outbox            string      `json:"outbox,omitempty"`
+	Followers         string      `json:"followers,omitempty"`
+	PublicKey         PublicKey   `json:"publicKey"`
+	Endpoints         *Endpoints  `json:"endpoints,omitempty"`
+}
+
+// Endpoints holds the optional endpoints of an actor.
+type Endpoints struct {
+	SharedInbox string `json:"sharedInbox,omitempty"`
+}
+
+// PublicKey is the key an actor signs its requests with.
+type PublicKey struct {
+	ID           string `json:"id"`
+	Owner        string `json:"owner"`
+	PublicKeyPem string `json:"publicKeyPem"`
+}
+
+// Activity is an activity sent to or received from another server. The object is left as
+// raw JSON when received, as its shape depends on the type of activity.
+type Activity struct {
+	Context interface{} `json:"@context,omitempty"`
+	ID      string      `json:"id"`
+	Type    string      `json:"type"`
+	Actor   string      `json:"actor"`
+	Object  interface{} `json:"object"`
+	To      []string    `json:"to,omitempty"`
+	CC      []string    `json:"cc,omitempty"`
+}
+
+// Note is a single twt.
+type Note struct {
+	Context      interface{} `json:"@context,omitempty"`
+	ID           string      `json:"id"`
+	Type         string      `json:"type"`
+	AttributedTo string      `json:"attributedTo"`
+	Content      string      `json:"content"`
+	Published    string      `json:"published"`
+	URL          string      `json:"url,omitempty"`
+	To           []string    `json:"to"`
+	CC           []string    `json:"cc,omitempty"`
+}
+
+// OrderedCollection is a collection of items, such as an actor's outbox or followers.
+type OrderedCollection struct {
+	Context      interface{}   `json:"@context,omitempty"`
+	ID           string        `json:"id"`
+	Type         string        `json:"type"`
+	TotalItems   int           `json:"totalItems"`
+	OrderedItems []interface{} `json:"orderedItems,omitempty"`
+}
+
+// WebFinger is the response to a WebFinger query for an actor's account.
+type WebFinger struct {
+	Subject string          `json:"subject"`
+	Aliases []string        `json:"aliases,omitempty"`
+	Links   []WebFingerLink `json:"links"`
+}
+
+// WebFingerLink is a single link in a WebFinger response.
+type WebFingerLink struct {
+	Rel  string `json:"rel"`
+	Type string `json:"type,omitempty"`
+	Href string `json:"href"`
+}
+
+// NewNote builds a public Note from a twt. The body is escaped and wrapped in a paragraph,
+// as receivers expect HTML content.
+func NewNote(id, actorID, followersID string, published time.Time, body string) Note {
+	return Note{
+		ID:           id,
+		Type:         "Note",
+		AttributedTo: actorID,
+		Content:      "<p>" + html.EscapeString(body) + "</p>",
+		Published:    published.UTC().Format(time.RFC3339),
+		To:           []string{PublicCollection},
+		CC:           []string{followersID},
+	}
+}
+
+// NewID returns a random ID for an activity, appended to base as a fragment.
+func NewID(base string) string {
+	b := make([]byte, 16)
+	_, _ = rand.Read(b)
+	return strings.TrimSuffix(base, "#") + "#" + hex.EncodeToString(b)
+}
@@ -0,0 +1,172 @@
+package activitypub
+
+/*
+Copyright 2021 G. Benjamin Morrison
+
+This file is part of getwtxt-ng.
+
+getwtxt-ng is free software: you can redistribute it and/or modify
+it under the terms of the GNU Affero General Public License as
+published by the Free Software Foundation, either version 3 of the
+License, or (at your option) any later version.
+
+getwtxt-ng is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU Affero General Public License for more details.
+
+You should have received a copy of the GNU Affero General Public License
+along with getwtxt-ng.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+import (
+	"bytes"
+	"crypto/rsa"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"sync"
+
+	"github.com/gbmor/getwtxt-ng/common"
+)
+
+// deliveryQueueSize is the number of deliveries that can wait for a worker before new ones are dropped.
+const deliveryQueueSize = 1024
+
+// Delivery is an activity to be posted to an inbox, signed as KeyID.
+type Delivery struct {
+	Inbox    string
+	KeyID    string
+	Activity interface{}
+}
+
+// Deliverer posts activities to inboxes in the background, so slow or unreachable servers don't hold up syncing.
+type Deliverer struct {
+	client *http.Client
+	key    *rsa.PrivateKey
+	logger common.Logger
+	queue  chan Delivery
+	wg     sync.WaitGroup
+
+	mu     sync.Mutex
+	closed bool
+}
+
+// NewDeliverer starts workers delivering activities signed with key. A nil logger discards everything.
+func NewDeliverer(client *http.Client, key *rsa.PrivateKey, workers int, logger common.Logger) *Deliverer {
+	if logger == nil {
+		logger = common.NopLogger{}
+	}
+	if workers < 1 {
+		workers = 1
+	}
+	d := &Deliverer{
+		client: client,
+		key:    key,
+		logger: logger,
+		queue:  make(chan Delivery, deliveryQueueSize),
+	}
+	for i := 0; i < workers; i++ {
+		d.wg.Add(1)
+		go func() {
+			defer d.wg.Done()
+			for del := range d.queue {
+				if err := d.Deliver(del); err != nil {
+					d.logger.Errorf("When delivering activity to %s: %s", del.Inbox, err)
+				}
+			}
+		}()
+	}
+
+	return d
+}
+
+// Enqueue schedules a delivery. It's dropped if the queue is full, rather than blocking the caller.
+func (d *Deliverer) Enqueue(del Delivery) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.closed {
+		return
+	}
+
+	select {
+	case d.queue <- del:
+	default:
+		d.logger.Errorf("Delivery queue full, dropping activity for %s", del.Inbox)
+	}
+}
+
+// Close stops accepting deliveries and waits for the queued ones to finish.
+func (d *Deliverer) Close() {
+	d.mu.Lock()
+	if !d.closed {
+		d.closed = true
+		close(d.queue)
+	}
+	d.mu.Unlock()
+	d.wg.Wait()
+}
+
+// Deliver posts a single activity to its inbox and waits for the response.
+func (d *Deliverer) Deliver(del Delivery) error {
+	body, err := json.Marshal(del.Activity)
+	if err != nil {
+		return fmt.Errorf("when encoding activity: %w", err)
+	}
+	req, err := http.NewRequest(http.MethodPost, del.Inbox, bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("when creating request: %w", err)
+	}
+	req.Header.Set("Content-Type", ContentType)
+	if err := SignRequest(req, del.KeyID, d.key, body); err != nil {
+		return err
+	}
+
+	resp, err := d.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		_ = resp.Body.Close()
+	}()
+	_, _ = io.Copy(io.Discard, io.LimitReader(resp.Body, 1<<16))
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("got status code %d", resp.StatusCode)
+	}
+
+	return nil
+}
+
+// FetchActor retrieves the actor at actorURL, signing the request as keyID, as some servers refuse unsigned fetches.
+func FetchActor(client *http.Client, actorURL, keyID string, key *rsa.PrivateKey) (*Actor, error) {
+	req, err := http.NewRequest(http.MethodGet, actorURL, nil)
+	if err != nil {
+		return nil, fmt.Errorf("when creating request for %s: %w", actorURL, err)
+	}
+	req.Header.Set("Accept", AcceptHeader)
+	if err := SignRequest(req, keyID, key, nil); err != nil {
+		return nil, err
+	}
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("when fetching %s: %w", actorURL, err)
+	}
+	defer func() {
+		_ = resp.Body.Close()
+	}()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("got status code %d from %s", resp.StatusCode, actorURL)
+	}
+
+	actor := Actor{}
+	if err := json.NewDecoder(io.LimitReader(resp.Body, 1<<20)).Decode(&actor); err != nil {
+		return nil, fmt.Errorf("when decoding actor from %s: %w", actorURL, err)
+	}
+	if actor.ID == "" || actor.Inbox == "" {
+		return nil, fmt.Errorf("actor at %s is missing its id or inbox", actorURL)
+	}
+
+	return &actor, nil
+}
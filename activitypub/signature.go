@@ -0,0 +1,237 @@
+package activitypub
+
+/*
+Copyright 2021 G. Benjamin Morrison
+
+This file is part of getwtxt-ng.
+
+getwtxt-ng is free software: you can redistribute it and/or modify
+it under the terms of the GNU Affero General Public License as
+published by the Free Software Foundation, either version 3 of the
+License, or (at your option) any later version.
+
+getwtxt-ng is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU Affero General Public License for more details.
+
+You should have received a copy of the GNU Affero General Public License
+along with getwtxt-ng.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+)
+
+// MaxClockSkew is how far the Date of a signed request may be from the current time.
+const MaxClockSkew = 12 * time.Hour
+
+// ErrInvalidSignature is returned when a request's HTTP signature is missing, malformed, or doesn't verify.
+var ErrInvalidSignature = errors.New("invalid http signature")
+
+// LoadOrCreateKey reads the PEM-encoded RSA private key at path, generating and storing a new one if it doesn't exist.
+func LoadOrCreateKey(path string) (*rsa.PrivateKey, error) {
+	b, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		key, err := rsa.GenerateKey(rand.Reader, 2048)
+		if err != nil {
+			return nil, fmt.Errorf("when generating key: %w", err)
+		}
+		block := &pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(key)}
+		if err := os.WriteFile(path, pem.EncodeToMemory(block), 0600); err != nil {
+			return nil, fmt.Errorf("when writing key to %s: %w", path, err)
+		}
+		return key, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("when reading key from %s: %w", path, err)
+	}
+
+	block, _ := pem.Decode(b)
+	if block == nil {
+		return nil, fmt.Errorf("no PEM data found in %s", path)
+	}
+	if key, err := x509.ParsePKCS1PrivateKey(block.Bytes); err == nil {
+		return key, nil
+	}
+	parsed, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("when parsing key from %s: %w", path, err)
+	}
+	key, ok := parsed.(*rsa.PrivateKey)
+	if !ok {
+		return nil, fmt.Errorf("key in %s is not an RSA key", path)
+	}
+
+	return key, nil
+}
+
+// PublicKeyPEM encodes the public half of key for an actor's publicKeyPem.
+func PublicKeyPEM(key *rsa.PrivateKey) (string, error) {
+	der, err := x509.MarshalPKIXPublicKey(&key.PublicKey)
+	if err != nil {
+		return "", err
+	}
+
+	return string(pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der})), nil
+}
+
+// ParsePublicKeyPEM decodes an actor's publicKeyPem.
+func ParsePublicKeyPEM(s string) (*rsa.PublicKey, error) {
+	block, _ := pem.Decode([]byte(s))
+	if block == nil {
+		return nil, errors.New("no PEM data found in public key")
+	}
+	if parsed, err := x509.ParsePKIXPublicKey(block.Bytes); err == nil {
+		key, ok := parsed.(*rsa.PublicKey)
+		if !ok {
+			return nil, errors.New("public key is not an RSA key")
+		}
+		return key, nil
+	}
+
+	return x509.ParsePKCS1PublicKey(block.Bytes)
+}
+
+// Digest returns the value of the Digest header for body.
+func Digest(body []byte) string {
+	sum := sha256.Sum256(body)
+	return "SHA-256=" + base64.StdEncoding.EncodeToString(sum[:])
+}
+
+// SignRequest adds Date, Digest when there's a body, and Signature headers to r, signed by key as keyID.
+// body must be the request's body, as it can't be read back from r.
+func SignRequest(r *http.Request, keyID string, key *rsa.PrivateKey, body []byte) error {
+	if r.Header.Get("Date") == "" {
+		r.Header.Set("Date", time.Now().UTC().Format(http.TimeFormat))
+	}
+	if r.Host == "" {
+		r.Host = r.URL.Host
+	}
+	headers := []string{"(request-target)", "host", "date"}
+	if body != nil {
+		r.Header.Set("Digest", Digest(body))
+		headers = append(headers, "digest")
+	}
+
+	sum := sha256.Sum256([]byte(signingString(r, headers)))
+	sig, err := rsa.SignPKCS1v15(rand.Reader, key, crypto.SHA256, sum[:])
+	if err != nil {
+		return fmt.Errorf("when signing request to %s: %w", r.URL, err)
+	}
+	r.Header.Set("Signature", fmt.Sprintf(`keyId="%s",algorithm="rsa-sha256",headers="%s",signature="%s"`,
+		keyID, strings.Join(headers, " "), base64.StdEncoding.EncodeToString(sig)))
+
+	return nil
+}
+
+// VerifyRequest checks the Signature header of r, along with its Date and, when there's a body, its Digest.
+// fetchKey is called with the signature's keyId to retrieve the signer's public key. Returns the keyId on success.
+func VerifyRequest(r *http.Request, body []byte, fetchKey func(keyID string) (*rsa.PublicKey, error)) (string, error) {
+	params := parseSignatureHeader(r.Header.Get("Signature"))
+	keyID := params["keyId"]
+	sig, err := base64.StdEncoding.DecodeString(params["signature"])
+	if keyID == "" || err != nil || len(sig) == 0 {
+		return "", fmt.Errorf("%w: missing keyId or signature", ErrInvalidSignature)
+	}
+	if alg := params["algorithm"]; alg != "" && alg != "rsa-sha256" && alg != "hs2019" {
+		return "", fmt.Errorf("%w: unsupported algorithm %s", ErrInvalidSignature, alg)
+	}
+
+	headers := strings.Fields(strings.ToLower(params["headers"]))
+	if len(headers) == 0 {
+		headers = []string{"date"}
+	}
+	required := []string{"(request-target)", "host", "date"}
+	if len(body) > 0 {
+		required = append(required, "digest")
+	}
+	for _, h := range required {
+		if !containsString(headers, h) {
+			return "", fmt.Errorf("%w: %s isn't signed", ErrInvalidSignature, h)
+		}
+	}
+
+	date, err := http.ParseTime(r.Header.Get("Date"))
+	if err != nil {
+		return "", fmt.Errorf("%w: invalid date", ErrInvalidSignature)
+	}
+	if skew := time.Since(date); skew > MaxClockSkew || skew < -MaxClockSkew {
+		return "", fmt.Errorf("%w: date is too far from now", ErrInvalidSignature)
+	}
+	if len(body) > 0 && r.Header.Get("Digest") != Digest(body) {
+		return "", fmt.Errorf("%w: digest doesn't match body", ErrInvalidSignature)
+	}
+
+	key, err := fetchKey(keyID)
+	if err != nil {
+		return "", fmt.Errorf("when fetching key %s: %w", keyID, err)
+	}
+	sum := sha256.Sum256([]byte(signingString(r, headers)))
+	if err := rsa.VerifyPKCS1v15(key, crypto.SHA256, sum[:], sig); err != nil {
+		return "", fmt.Errorf("%w: %s", ErrInvalidSignature, err)
+	}
+
+	return keyID, nil
+}
+
+// signingString builds the string covered by a signature over the provided headers.
+func signingString(r *http.Request, headers []string) string {
+	buf := bytes.Buffer{}
+	for i, h := range headers {
+		if i > 0 {
+			buf.WriteString("\n")
+		}
+		buf.WriteString(h)
+		buf.WriteString(": ")
+		switch h {
+		case "(request-target)":
+			buf.WriteString(strings.ToLower(r.Method))
+			buf.WriteString(" ")
+			buf.WriteString(r.URL.RequestURI())
+		case "host":
+			buf.WriteString(r.Host)
+		default:
+			buf.WriteString(strings.Join(r.Header.Values(h), ", "))
+		}
+	}
+
+	return buf.String()
+}
+
+// parseSignatureHeader splits a Signature header into its parameters.
+func parseSignatureHeader(header string) map[string]string {
+	params := make(map[string]string)
+	for _, part := range strings.Split(header, ",") {
+		k, v, ok := strings.Cut(strings.TrimSpace(part), "=")
+		if !ok {
+			continue
+		}
+		params[k] = strings.Trim(v, `"`)
+	}
+
+	return params
+}
+
+func containsString(haystack []string, needle string) bool {
+	for _, s := range haystack {
+		if s == needle {
+			return true
+		}
+	}
+
+	return false
+}
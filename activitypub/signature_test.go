@@ -0,0 +1,124 @@
+package activitypub
+
+/*
+Copyright 2021 G. Benjamin Morrison
+
+This file is part of getwtxt-ng.
+
+getwtxt-ng is free software: you can redistribute it and/or modify
+it under the terms of the GNU Affero General Public License as
+published by the Free Software Foundation, either version 3 of the
+License, or (at your option) any later version.
+
+getwtxt-ng is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU Affero General Public License for more details.
+
+You should have received a copy of the GNU Affero General Public License
+along with getwtxt-ng.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+import (
+	"bytes"
+	"crypto/rsa"
+	"errors"
+	"net/http"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestLoadOrCreateKey(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "ap.key")
+	key, err := LoadOrCreateKey(path)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	loaded, err := LoadOrCreateKey(path)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	if !key.Equal(loaded) {
+		t.Error("Expected the stored key to be loaded again")
+	}
+
+	pub, err := PublicKeyPEM(key)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	parsed, err := ParsePublicKeyPEM(pub)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	if !key.PublicKey.Equal(parsed) {
+		t.Error("Expected parsed public key to match")
+	}
+}
+
+func TestSignAndVerifyRequest(t *testing.T) {
+	key, err := LoadOrCreateKey(filepath.Join(t.TempDir(), "ap.key"))
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	keyID := "https://example.com/ap/users/foo#main-key"
+	fetchKey := func(id string) (*rsa.PublicKey, error) {
+		if id != keyID {
+			return nil, errors.New("unknown key")
+		}
+		return &key.PublicKey, nil
+	}
+	body := []byte(`{"type":"Follow"}`)
+	newRequest := func(t *testing.T) *http.Request {
+		req, err := http.NewRequest(http.MethodPost, "https://example.org/ap/users/bar/inbox", bytes.NewReader(body))
+		if err != nil {
+			t.Fatal(err.Error())
+		}
+		if err := SignRequest(req, keyID, key, body); err != nil {
+			t.Fatal(err.Error())
+		}
+		return req
+	}
+
+	t.Run("valid", func(t *testing.T) {
+		got, err := VerifyRequest(newRequest(t), body, fetchKey)
+		if err != nil {
+			t.Fatal(err.Error())
+		}
+		if got != keyID {
+			t.Errorf("Expected keyId %s, got %s", keyID, got)
+		}
+	})
+	t.Run("tampered body", func(t *testing.T) {
+		if _, err := VerifyRequest(newRequest(t), []byte(`{"type":"Undo"}`), fetchKey); !errors.Is(err, ErrInvalidSignature) {
+			t.Errorf("Expected ErrInvalidSignature, got: %v", err)
+		}
+	})
+	t.Run("tampered path", func(t *testing.T) {
+		req := newRequest(t)
+		req.URL.Path = "/ap/users/baz/inbox"
+		if _, err := VerifyRequest(req, body, fetchKey); !errors.Is(err, ErrInvalidSignature) {
+			t.Errorf("Expected ErrInvalidSignature, got: %v", err)
+		}
+	})
+	t.Run("stale date", func(t *testing.T) {
+		req, err := http.NewRequest(http.MethodPost, "https://example.org/ap/users/bar/inbox", nil)
+		if err != nil {
+			t.Fatal(err.Error())
+		}
+		req.Header.Set("Date", time.Now().Add(-2*MaxClockSkew).UTC().Format(http.TimeFormat))
+		if err := SignRequest(req, keyID, key, body); err != nil {
+			t.Fatal(err.Error())
+		}
+		if _, err := VerifyRequest(req, body, fetchKey); !errors.Is(err, ErrInvalidSignature) {
+			t.Errorf("Expected ErrInvalidSignature, got: %v", err)
+		}
+	})
+	t.Run("unsigned", func(t *testing.T) {
+		req := newRequest(t)
+		req.Header.Del("Signature")
+		if _, err := VerifyRequest(req, body, fetchKey); !errors.Is(err, ErrInvalidSignature) {
+			t.Errorf("Expected ErrInvalidSignature, got: %v", err)
+		}
+	})
+}
@@ -1,26 +1,148 @@
 package main
 
 import (
+	"bufio"
+	"errors"
+	"flag"
 	"fmt"
 	"os"
+	"regexp"
+	"strings"
 	"syscall"
 
 	"github.com/gbmor/getwtxt-ng/common"
 	"golang.org/x/term"
 )
 
+var flagStdin = flag.Bool("stdin", false, "Read the password from the first line of stdin instead of prompting")
+var flagConfirm = flag.Bool("confirm", false, "Prompt for the password twice and make sure they match. With -stdin, the second line must repeat the first")
+var flagWriteConfig = flag.String("write-config", "", "Write the hash to admin_password_hash in this config file instead of printing it")
+
+// regexHashKey matches an existing admin_password_hash assignment in the config file.
+var regexHashKey = regexp.MustCompile(`(?m)^\s*admin_password_hash\s*=.*$`)
+
+// regexServerConfigHeader matches the start of the [server_config] table.
+var regexServerConfigHeader = regexp.MustCompile(`(?m)^\s*\[server_config\]\s*$`)
+
 func main() {
-	fmt.Printf("Password: ")
-	line, err := term.ReadPassword(syscall.Stdin)
+	flag.Parse()
+
+	pass, err := readPassword()
 	if err != nil {
 		fmt.Println(err.Error())
 		os.Exit(1)
 	}
-	fmt.Println()
-	out, err := common.HashPass(string(line))
+
+	out, err := common.HashPass(pass)
 	if err != nil {
 		fmt.Println(err.Error())
 		os.Exit(1)
 	}
+
+	if *flagWriteConfig != "" {
+		if err := writeHashToConfig(*flagWriteConfig, string(out)); err != nil {
+			fmt.Println(err.Error())
+			os.Exit(1)
+		}
+		fmt.Printf("Wrote admin_password_hash to %s\n", *flagWriteConfig)
+		return
+	}
+
 	fmt.Println(string(out))
 }
+
+func readPassword() (string, error) {
+	if *flagStdin {
+		stdin := bufio.NewReader(os.Stdin)
+		pass, err := readStdinLine(stdin)
+		if err != nil {
+			return "", fmt.Errorf("couldn't read password from stdin: %w", err)
+		}
+		if pass == "" {
+			return "", errors.New("empty password provided")
+		}
+		if *flagConfirm {
+			again, err := readStdinLine(stdin)
+			if err != nil {
+				return "", fmt.Errorf("couldn't read password confirmation from stdin: %w", err)
+			}
+			if again != pass {
+				return "", errors.New("passwords do not match")
+			}
+		}
+		return pass, nil
+	}
+
+	pass, err := promptPassword("Password: ")
+	if err != nil {
+		return "", err
+	}
+	if pass == "" {
+		return "", errors.New("empty password provided")
+	}
+
+	if *flagConfirm {
+		again, err := promptPassword("Confirm Password: ")
+		if err != nil {
+			return "", err
+		}
+		if again != pass {
+			return "", errors.New("passwords do not match")
+		}
+	}
+
+	return pass, nil
+}
+
+// readStdinLine reads a line from stdin without its line ending. The last line may lack one.
+func readStdinLine(stdin *bufio.Reader) (string, error) {
+	line, err := stdin.ReadString('\n')
+	if err != nil && line == "" {
+		return "", err
+	}
+
+	return strings.TrimRight(line, "\r\n"), nil
+}
+
+func promptPassword(prompt string) (string, error) {
+	fmt.Printf("%s", prompt)
+	line, err := term.ReadPassword(syscall.Stdin)
+	fmt.Println()
+	if err != nil {
+		return "", err
+	}
+
+	return string(line), nil
+}
+
+// writeHashToConfig replaces admin_password_hash in the config file at path,
+// adding it to the [server_config] table if it isn't present yet.
+func writeHashToConfig(path, hash string) error {
+	info, err := os.Stat(path)
+	if err != nil {
+		return fmt.Errorf("couldn't stat config file at %s: %w", path, err)
+	}
+	contents, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("couldn't read config file at %s: %w", path, err)
+	}
+
+	line := fmt.Sprintf("admin_password_hash = %q", hash)
+	conf := string(contents)
+
+	switch {
+	case regexHashKey.MatchString(conf):
+		conf = regexHashKey.ReplaceAllLiteralString(conf, line)
+	case regexServerConfigHeader.MatchString(conf):
+		loc := regexServerConfigHeader.FindStringIndex(conf)
+		conf = conf[:loc[1]] + "\n" + line + conf[loc[1]:]
+	default:
+		conf = fmt.Sprintf("%s\n[server_config]\n%s\n", strings.TrimRight(conf, "\n"), line)
+	}
+
+	if err := os.WriteFile(path, []byte(conf), info.Mode().Perm()); err != nil {
+		return fmt.Errorf("couldn't write config file at %s: %w", path, err)
+	}
+
+	return nil
+}
@@ -5,8 +5,11 @@ import (
 	"context"
 	"flag"
 	"fmt"
+	"io"
+	"net/http"
 	"net/url"
 	"os"
+	"strconv"
 	"strings"
 	"time"
 
@@ -18,14 +21,17 @@ import (
 )
 
 var flagConfig = flag.String("config", "getwtxt-ng.toml", "Path to getwtxt-ng's config file")
+var flagFromURL = flag.String("from-url", "", "URL of another registry's plain user list to import, eg: https://twtxt.example.com/api/plain/users")
+var flagMaxPages = flag.Int("max-pages", 1000, "Maximum number of pages to request when using -from-url")
 
 func main() {
 	flag.Parse()
 	binaryName := os.Args[0]
 	args := flag.Args()
-	if len(args) < 1 {
-		fmt.Println("Please specify the path to the user list as an argument:")
+	if len(args) < 1 && *flagFromURL == "" {
+		fmt.Println("Please specify the path to the user list as an argument, or a remote registry with -from-url:")
 		fmt.Printf("\t%s /path/to/user_list.txt\n", binaryName)
+		fmt.Printf("\t%s -from-url https://twtxt.example.com/api/plain/users\n", binaryName)
 		os.Exit(1)
 	}
 
@@ -56,23 +62,121 @@ func main() {
 	}
 
 	userAgent := fmt.Sprintf("getwtxt-ng/%s (+%s; @getwtxt-ng/init-bulk-follow)", common.Version, conf.InstanceInfo.SiteURL)
-	dbConn, err := registry.InitSQLite(conf.ServerConfig.DatabasePath, 10, 10, nil, userAgent, log.StandardLogger())
+	dbConn, err := registry.Open(conf.ServerConfig.DatabasePath,
+		registry.WithPageLimits(10, 10),
+		registry.WithUserAgent(userAgent),
+		registry.WithLogger(log.StandardLogger()))
 	if err != nil {
 		fmt.Printf("Could not connect to database at %s: %s\n", conf.ServerConfig.DatabasePath, err)
 		os.Exit(1)
 	}
 
-	filePath := args[0]
-	userFile, err := os.Open(filePath)
+	ctx := context.Background()
+	var usersToAdd []registry.User
+
+	if *flagFromURL != "" {
+		usersToAdd, err = usersFromRemote(ctx, dbConn, *flagFromURL, *flagMaxPages)
+		if err != nil {
+			fmt.Printf("Couldn't retrieve user list from %s: %s\n", *flagFromURL, err)
+			_ = dbConn.Close()
+			os.Exit(1)
+		}
+	} else {
+		filePath := args[0]
+		userFile, err := os.Open(filePath)
+		if err != nil {
+			fmt.Printf("Couldn't open user list: %s\n", err)
+			_ = dbConn.Close()
+			os.Exit(1)
+		}
+		usersToAdd = parseUserList(ctx, dbConn, userFile, nil)
+		_ = userFile.Close()
+	}
+
+	users, err := dbConn.InsertUsers(ctx, usersToAdd)
 	if err != nil {
-		fmt.Printf("Couldn't open user list: %s\n", err)
+		fmt.Printf("When bulk inserting users: %s", err)
+		_ = dbConn.Close()
 		os.Exit(1)
 	}
 
+	for i, user := range users {
+		tweets, err := dbConn.FetchTwtxt(user.URL, user.ID, time.Time{})
+		if err != nil {
+			log.Errorf("Couldn't fetch tweets for %s: %s", user.URL, err)
+			continue
+		}
+		_, err = dbConn.InsertTweets(ctx, tweets)
+		if err != nil {
+			log.Errorf("Couldn't fetch tweets for %s: %s", user.URL, err)
+			continue
+		}
+		users[i].LastSync = time.Now().UTC()
+	}
+
+	plainUsersResp := registry.FormatUsersPlain(users)
+	fmt.Printf("Successfully added the following users:\n\n")
+	fmt.Printf("%s\n", plainUsersResp)
+
+	if err := dbConn.Close(); err != nil {
+		log.Errorf("When closing database: %s", err)
+	}
+}
+
+// usersFromRemote walks the pages of another registry's plain user list until
+// it receives an empty page, a page with nothing we haven't already seen, or maxPages is reached.
+func usersFromRemote(ctx context.Context, dbConn *registry.DB, remoteURL string, maxPages int) ([]registry.User, error) {
+	if !common.IsValidURL(remoteURL, log.StandardLogger()) {
+		return nil, fmt.Errorf("couldn't parse %s as URL", remoteURL)
+	}
+	parsedURL, err := url.Parse(remoteURL)
+	if err != nil {
+		return nil, fmt.Errorf("couldn't parse %s as URL: %w", remoteURL, err)
+	}
+
+	seen := make(map[string]struct{})
+	usersToAdd := make([]registry.User, 0, 32)
+
+	for page := 1; page <= maxPages; page++ {
+		query := parsedURL.Query()
+		query.Set("page", strconv.Itoa(page))
+		parsedURL.RawQuery = query.Encode()
+		pageURL := parsedURL.String()
+
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, pageURL, nil)
+		if err != nil {
+			return nil, fmt.Errorf("couldn't create http request for %s: %w", pageURL, err)
+		}
+		resp, err := dbConn.Client.Do(req)
+		if err != nil {
+			return nil, fmt.Errorf("couldn't fetch %s: %w", pageURL, err)
+		}
+		if resp.StatusCode != http.StatusOK {
+			_ = resp.Body.Close()
+			return nil, fmt.Errorf("got status code %d from %s", resp.StatusCode, pageURL)
+		}
+
+		seenBefore := len(seen)
+		pageUsers := parseUserList(ctx, dbConn, resp.Body, seen)
+		_ = resp.Body.Close()
+		usersToAdd = append(usersToAdd, pageUsers...)
+
+		// Some registries ignore the page parameter and hand back the same list every time.
+		if len(seen) == seenBefore {
+			break
+		}
+	}
+
+	return usersToAdd, nil
+}
+
+// parseUserList reads lines in the form of nick<TAB>url<TAB>datetime and returns the users
+// that aren't already present in the database. If seen is non-nil, each URL encountered is
+// recorded in it and URLs already present are skipped.
+func parseUserList(ctx context.Context, dbConn *registry.DB, list io.Reader, seen map[string]struct{}) []registry.User {
 	usersToAdd := make([]registry.User, 0, 5)
-	ctx := context.Background()
 
-	bodyScanner := bufio.NewScanner(userFile)
+	bodyScanner := bufio.NewScanner(list)
 	for bodyScanner.Scan() {
 		line := bodyScanner.Text()
 		fields := strings.Fields(line)
@@ -82,21 +186,25 @@ func main() {
 
 		// This is to prevent variations of the same URL showing up multiple times.
 		// Eg: http://example.com/twtxt.txt vs https://example.com/twtxt.txt
-		// We're also chomping www. off.
-		parsedURL, err := url.Parse(fields[1])
+		canonicalURL, err := registry.CanonicalURL(fields[1])
 		if err != nil {
 			log.Errorf("couldn't parse %s as URL: %s", fields[1], err)
 			continue
 		}
-		host := strings.TrimPrefix(parsedURL.Host, "www.")
-		constructedURL := fmt.Sprintf("%s%s", host, parsedURL.Path)
 
-		userSearchOut, err := dbConn.SearchUsers(ctx, 1, 10, constructedURL)
+		if seen != nil {
+			if _, ok := seen[canonicalURL]; ok {
+				continue
+			}
+			seen[canonicalURL] = struct{}{}
+		}
+
+		exists, err := dbConn.UserExists(ctx, fields[1])
 		if err != nil {
-			log.Errorf("While searching for user %s: %s", fields[1], err)
+			log.Errorf("While checking for existing user %s: %s", fields[1], err)
 			continue
 		}
-		if len(userSearchOut) > 0 {
+		if exists {
 			continue
 		}
 		var dt time.Time
@@ -120,27 +228,5 @@ func main() {
 		usersToAdd = append(usersToAdd, thisUser)
 	}
 
-	users, err := dbConn.InsertUsers(ctx, usersToAdd)
-	if err != nil {
-		fmt.Printf("When bulk inserting users: %s", err)
-		os.Exit(1)
-	}
-
-	for i, user := range users {
-		tweets, err := dbConn.FetchTwtxt(user.URL, user.ID, time.Time{})
-		if err != nil {
-			log.Errorf("Couldn't fetch tweets for %s: %s", user.URL, err)
-			continue
-		}
-		err = dbConn.InsertTweets(ctx, tweets)
-		if err != nil {
-			log.Errorf("Couldn't fetch tweets for %s: %s", user.URL, err)
-			continue
-		}
-		users[i].LastSync = time.Now().UTC()
-	}
-
-	plainUsersResp := registry.FormatUsersPlain(users)
-	fmt.Printf("Successfully added the following users:\n\n")
-	fmt.Printf("%s\n", plainUsersResp)
+	return usersToAdd
 }
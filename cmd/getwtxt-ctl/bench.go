@@ -0,0 +1,195 @@
+/*
+Copyright 2021 G. Benjamin Morrison
+
+This file is part of getwtxt-ng.
+
+getwtxt-ng is free software: you can redistribute it and/or modify
+it under the terms of the GNU Affero General Public License as
+published by the Free Software Foundation, either version 3 of the
+License, or (at your option) any later version.
+
+getwtxt-ng is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU Affero General Public License for more details.
+
+You should have received a copy of the GNU Affero General Public License
+along with getwtxt-ng.  If not, see <https://www.gnu.org/licenses/>.
+*/
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/gbmor/getwtxt-ng/registry"
+)
+
+// benchQuery is a registry read measured by the bench command.
+type benchQuery struct {
+	name string
+	run  func(ctx context.Context, dbConn *registry.DB, i int) error
+}
+
+// benchCmd seeds a scratch database with synthetic users and tweets, then measures query latency and sync throughput.
+// The configured database is never touched.
+func benchCmd(_ *ctlConfig, args []string) error {
+	flags := flag.NewFlagSet("bench", flag.ExitOnError)
+	nUsers := flags.Int("users", 1000, "Number of synthetic users to seed")
+	nTweets := flags.Int("tweets", 100, "Number of tweets to seed per user")
+	iterations := flags.Int("iterations", 20, "Number of times to run each query")
+	syncFeeds := flags.Int("sync-feeds", 50, "Number of feeds to fetch when measuring sync throughput")
+	dbPath := flags.String("db", "", "Path of the scratch database to create (default: a temporary file)")
+	_ = flags.Parse(args)
+
+	if *nUsers < 1 || *nTweets < 1 || *iterations < 1 {
+		return fmt.Errorf("-users, -tweets, and -iterations must be at least 1")
+	}
+	if *dbPath == "" {
+		dir, err := os.MkdirTemp("", "getwtxt-ctl-bench")
+		if err != nil {
+			return fmt.Errorf("couldn't create scratch directory: %w", err)
+		}
+		defer func() {
+			_ = os.RemoveAll(dir)
+		}()
+		*dbPath = filepath.Join(dir, "bench.db")
+	} else if _, err := os.Stat(*dbPath); err == nil {
+		return fmt.Errorf("refusing to seed existing database at %s", *dbPath)
+	}
+
+	dbConn, err := registry.Open(*dbPath, registry.WithPageLimits(10, 1000))
+	if err != nil {
+		return fmt.Errorf("could not create scratch database at %s: %w", *dbPath, err)
+	}
+	defer func() {
+		_ = dbConn.Close()
+	}()
+	ctx := context.Background()
+
+	begin := time.Now()
+	users, err := dbConn.SeedSynthetic(ctx, *nUsers, *nTweets)
+	if err != nil {
+		return err
+	}
+	seedTime := time.Since(begin)
+	fmt.Printf("Seeded %d users and %d tweets in %s (%.0f tweets/s)\n\n", len(users), len(users)**nTweets,
+		seedTime.Round(time.Millisecond), float64(len(users)**nTweets)/seedTime.Seconds())
+
+	deepPage := (*nUsers * *nTweets) / 20 / 2
+	if deepPage < 1 {
+		deepPage = 1
+	}
+	queries := []benchQuery{
+		{"tweets, page 1", func(ctx context.Context, dbConn *registry.DB, _ int) error {
+			_, err := dbConn.GetTweets(ctx, 1, 20, registry.StatusVisible)
+			return err
+		}},
+		{fmt.Sprintf("tweets, page %d", deepPage), func(ctx context.Context, dbConn *registry.DB, _ int) error {
+			_, err := dbConn.GetTweets(ctx, deepPage, 20, registry.StatusVisible)
+			return err
+		}},
+		{"users, page 1", func(ctx context.Context, dbConn *registry.DB, _ int) error {
+			_, err := dbConn.GetUsers(ctx, 1, 20)
+			return err
+		}},
+		{"user's tweets", func(ctx context.Context, dbConn *registry.DB, i int) error {
+			_, err := dbConn.GetUserTweets(ctx, users[i%len(users)].ID, 20)
+			return err
+		}},
+		{"search tweets", func(ctx context.Context, dbConn *registry.DB, _ int) error {
+			_, err := dbConn.SearchTweets(ctx, 1, 20, "words", registry.StatusVisible)
+			return err
+		}},
+		{"search users", func(ctx context.Context, dbConn *registry.DB, i int) error {
+			_, err := dbConn.SearchUsers(ctx, 1, 20, fmt.Sprintf("user%d", i%len(users)))
+			return err
+		}},
+		{"tags", func(ctx context.Context, dbConn *registry.DB, _ int) error {
+			_, err := dbConn.GetTags(ctx, 1, 20, registry.StatusVisible)
+			return err
+		}},
+		{"mentions", func(ctx context.Context, dbConn *registry.DB, _ int) error {
+			_, err := dbConn.GetMentions(ctx, 1, 20, registry.StatusVisible)
+			return err
+		}},
+	}
+
+	tw := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	_, _ = fmt.Fprintf(tw, "QUERY\tMIN\tMEDIAN\tP95\tMAX\n")
+	for _, q := range queries {
+		latencies := make([]time.Duration, 0, *iterations)
+		for i := 0; i < *iterations; i++ {
+			start := time.Now()
+			if err := q.run(ctx, dbConn, i); err != nil {
+				return fmt.Errorf("when running %s: %w", q.name, err)
+			}
+			latencies = append(latencies, time.Since(start))
+		}
+		sort.Slice(latencies, func(i, j int) bool { return latencies[i] < latencies[j] })
+		_, _ = fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s\n", q.name,
+			latencies[0].Round(time.Microsecond),
+			latencies[len(latencies)/2].Round(time.Microsecond),
+			latencies[len(latencies)*95/100].Round(time.Microsecond),
+			latencies[len(latencies)-1].Round(time.Microsecond))
+	}
+	_ = tw.Flush()
+
+	if *syncFeeds < 1 {
+		return nil
+	}
+	return benchSync(ctx, dbConn, *syncFeeds, *nTweets)
+}
+
+// benchSync registers feeds served from a local HTTP server and times fetching all of them once.
+func benchSync(ctx context.Context, dbConn *registry.DB, feeds, tweetsPerFeed int) error {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		builder := strings.Builder{}
+		for _, tweet := range registry.SyntheticTweets("http://"+r.Host+r.URL.Path, tweetsPerFeed) {
+			builder.WriteString(tweet.DateTime.Format(time.RFC3339))
+			builder.WriteString("\t")
+			builder.WriteString(tweet.Body)
+			builder.WriteString("\n")
+		}
+		w.Header().Set("Content-Type", "text/plain")
+		_, _ = w.Write([]byte(builder.String()))
+	}))
+	defer srv.Close()
+	dbConn.Client = srv.Client()
+
+	users := make([]registry.User, 0, feeds)
+	for i := 0; i < feeds; i++ {
+		u := registry.User{
+			Nick:         fmt.Sprintf("feed%d", i),
+			URL:          fmt.Sprintf("%s/feed%d/twtxt.txt", srv.URL, i),
+			PasscodeHash: []byte("not a real hash"),
+		}
+		if err := dbConn.InsertUser(ctx, &u); err != nil {
+			return fmt.Errorf("when registering feed to sync: %w", err)
+		}
+		users = append(users, u)
+	}
+
+	begin := time.Now()
+	result, err := dbConn.SyncUsers(ctx, users)
+	if err != nil {
+		return err
+	}
+	elapsed := time.Since(begin)
+	fmt.Printf("\nSynced %d feeds (%d new tweets) in %s: %.1f feeds/s, %.0f tweets/s\n", result.Users, result.Tweets,
+		elapsed.Round(time.Millisecond), float64(result.Users)/elapsed.Seconds(), float64(result.Tweets)/elapsed.Seconds())
+	if len(result.Failed) > 0 {
+		return fmt.Errorf("%d of %d feeds failed to sync", len(result.Failed), result.Users)
+	}
+
+	return nil
+}
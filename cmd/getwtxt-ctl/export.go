@@ -0,0 +1,67 @@
+/*
+Copyright 2021 G. Benjamin Morrison
+
+This file is part of getwtxt-ng.
+
+getwtxt-ng is free software: you can redistribute it and/or modify
+it under the terms of the GNU Affero General Public License as
+published by the Free Software Foundation, either version 3 of the
+License, or (at your option) any later version.
+
+getwtxt-ng is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU Affero General Public License for more details.
+
+You should have received a copy of the GNU Affero General Public License
+along with getwtxt-ng.  If not, see <https://www.gnu.org/licenses/>.
+*/
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/gbmor/getwtxt-ng/registry"
+)
+
+// exportCmd writes every user in the format consumed by bulkUserAdd and /api/plain/users/bulk.
+func exportCmd(conf *ctlConfig, args []string) error {
+	flags := flag.NewFlagSet("export", flag.ExitOnError)
+	outPath := flags.String("o", "", "Write the user list to this file instead of stdout")
+	_ = flags.Parse(args)
+
+	dbConn, err := openDB(conf)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		_ = dbConn.Close()
+	}()
+
+	users, err := dbConn.GetAllUsers(context.Background())
+	if err != nil {
+		return fmt.Errorf("couldn't retrieve users: %w", err)
+	}
+
+	var out io.Writer = os.Stdout
+	if *outPath != "" {
+		fd, err := os.OpenFile(*outPath, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0644)
+		if err != nil {
+			return fmt.Errorf("couldn't open %s for writing: %w", *outPath, err)
+		}
+		defer func() {
+			_ = fd.Close()
+		}()
+		out = fd
+	}
+
+	if _, err := io.WriteString(out, registry.FormatUsersList(users)); err != nil {
+		return fmt.Errorf("couldn't write user list: %w", err)
+	}
+
+	return nil
+}
@@ -0,0 +1,153 @@
+/*
+Copyright 2021 G. Benjamin Morrison
+
+This file is part of getwtxt-ng.
+
+getwtxt-ng is free software: you can redistribute it and/or modify
+it under the terms of the GNU Affero General Public License as
+published by the Free Software Foundation, either version 3 of the
+License, or (at your option) any later version.
+
+getwtxt-ng is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU Affero General Public License for more details.
+
+You should have received a copy of the GNU Affero General Public License
+along with getwtxt-ng.  If not, see <https://www.gnu.org/licenses/>.
+*/
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+
+	"github.com/gbmor/getwtxt-ng/registry"
+)
+
+// importedUser is a user and their tweets as read from another registry's data store.
+type importedUser struct {
+	user   registry.User
+	tweets []registry.Tweet
+}
+
+func importCmd(conf *ctlConfig, args []string) error {
+	if len(args) < 1 {
+		return errors.New("please specify what to import from: getwtxt, yarn")
+	}
+
+	switch args[0] {
+	case "getwtxt":
+		return importGetwtxtCmd(conf, args[1:])
+	case "yarn":
+		return importYarnCmd(conf, args[1:])
+	default:
+		return fmt.Errorf("unknown import source: %s", args[0])
+	}
+}
+
+// storeImportedUsers registers the users that don't already exist, then inserts their tweets.
+// The generated passcodes are written to passcodeOut, since the imported users have never had one.
+func storeImportedUsers(ctx context.Context, dbConn *registry.DB, imported []importedUser, passcodeOut io.Writer) error {
+	byURL := make(map[string]importedUser, len(imported))
+	usersToAdd := make([]registry.User, 0, len(imported))
+	for _, iu := range imported {
+		if _, err := dbConn.GetFullUserByURL(ctx, iu.user.URL); err == nil {
+			fmt.Printf("Skipping %s: already registered\n", iu.user.URL)
+			continue
+		}
+		if _, ok := byURL[iu.user.URL]; ok {
+			continue
+		}
+		byURL[iu.user.URL] = iu
+		usersToAdd = append(usersToAdd, iu.user)
+	}
+	if len(usersToAdd) < 1 {
+		fmt.Println("Nothing to import.")
+		return nil
+	}
+
+	users, err := dbConn.InsertUsers(ctx, usersToAdd)
+	if err != nil {
+		return fmt.Errorf("when inserting imported users: %w", err)
+	}
+
+	// Tweets are inserted with the search index's triggers dropped and in large batches,
+	// since imported archives can run to hundreds of thousands of tweets.
+	tweetCount := 0
+	err = dbConn.BulkImport(ctx, func(ctx context.Context) error {
+		batch := make([]registry.Tweet, 0, registry.BulkImportBatchSize)
+		flush := func() {
+			if len(batch) < 1 {
+				return
+			}
+			res, err := dbConn.InsertTweets(ctx, batch)
+			if err != nil {
+				log.Errorf("Couldn't insert a batch of %d tweets: %s", len(batch), err)
+			}
+			tweetCount += res.Inserted
+			batch = batch[:0]
+		}
+
+		for _, u := range users {
+			tweets := byURL[u.URL].tweets
+			for i := range tweets {
+				tweets[i].UserID = u.ID
+			}
+			batch = append(batch, tweets...)
+			if len(batch) >= registry.BulkImportBatchSize {
+				flush()
+			}
+			if _, err := fmt.Fprintf(passcodeOut, "%s\t%s\t%s\n", u.Nick, u.URL, u.Passcode); err != nil {
+				return fmt.Errorf("couldn't write passcode for %s: %w", u.URL, err)
+			}
+		}
+		flush()
+
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("Imported %d users and %d tweets\n", len(users), tweetCount)
+
+	return nil
+}
+
+// openPasscodeOutput returns stdout unless a path is given.
+func openPasscodeOutput(path string) (io.WriteCloser, error) {
+	if path == "" {
+		return nopCloser{os.Stdout}, nil
+	}
+	fd, err := os.OpenFile(path, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0600)
+	if err != nil {
+		return nil, fmt.Errorf("couldn't open %s for writing: %w", path, err)
+	}
+
+	return fd, nil
+}
+
+type nopCloser struct {
+	io.Writer
+}
+
+func (nopCloser) Close() error {
+	return nil
+}
+
+// parseLegacyTime accepts the timestamp formats written by other registries.
+func parseLegacyTime(s string) (time.Time, error) {
+	dt, err := time.Parse(time.RFC3339Nano, s)
+	if err != nil {
+		dt, err = time.Parse(time.RFC3339, s)
+	}
+
+	return dt, err
+}
@@ -0,0 +1,245 @@
+/*
+Copyright 2021 G. Benjamin Morrison
+
+This file is part of getwtxt-ng.
+
+getwtxt-ng is free software: you can redistribute it and/or modify
+it under the terms of the GNU Affero General Public License as
+published by the Free Software Foundation, either version 3 of the
+License, or (at your option) any later version.
+
+getwtxt-ng is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU Affero General Public License for more details.
+
+You should have received a copy of the GNU Affero General Public License
+along with getwtxt-ng.  If not, see <https://www.gnu.org/licenses/>.
+*/
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"flag"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/syndtr/goleveldb/leveldb"
+	"github.com/syndtr/goleveldb/leveldb/opt"
+
+	"github.com/gbmor/getwtxt-ng/registry"
+)
+
+// legacyUser accumulates the fields the original getwtxt stored for each user.
+type legacyUser struct {
+	nick     string
+	url      string
+	date     string
+	statuses map[string]string
+}
+
+// importGetwtxtCmd reads an original getwtxt data store and imports its users and their statuses.
+func importGetwtxtCmd(conf *ctlConfig, args []string) error {
+	flags := flag.NewFlagSet("import getwtxt", flag.ExitOnError)
+	dbType := flags.String("type", "leveldb", "Type of the getwtxt database: leveldb or sqlite")
+	passcodePath := flags.String("passcodes", "", "Write the generated passcodes to this file instead of stdout")
+	_ = flags.Parse(args)
+	if flags.NArg() < 1 {
+		return errors.New("please provide the path to the getwtxt database")
+	}
+	legacyPath := flags.Arg(0)
+
+	var legacy map[string]*legacyUser
+	var err error
+	switch *dbType {
+	case "leveldb":
+		legacy, err = readGetwtxtLevelDB(legacyPath)
+	case "sqlite":
+		legacy, err = readGetwtxtSQLite(legacyPath)
+	default:
+		return fmt.Errorf("unknown getwtxt database type: %s", *dbType)
+	}
+	if err != nil {
+		return err
+	}
+
+	dbConn, err := openDB(conf)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		_ = dbConn.Close()
+	}()
+
+	passcodeOut, err := openPasscodeOutput(*passcodePath)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		_ = passcodeOut.Close()
+	}()
+
+	return storeImportedUsers(context.Background(), dbConn, convertLegacyUsers(legacy), passcodeOut)
+}
+
+// readGetwtxtLevelDB reads getwtxt's LevelDB store, where keys take the form
+// url*Field or url*Status*timestamp. Remote registries are stored under remote*, which we skip.
+func readGetwtxtLevelDB(path string) (map[string]*legacyUser, error) {
+	db, err := leveldb.OpenFile(path, &opt.Options{ReadOnly: true, ErrorIfMissing: true})
+	if err != nil {
+		return nil, fmt.Errorf("couldn't open getwtxt leveldb database at %s: %w", path, err)
+	}
+	defer func() {
+		_ = db.Close()
+	}()
+
+	users := make(map[string]*legacyUser)
+	iter := db.NewIterator(nil, nil)
+	for iter.Next() {
+		urlKey, field, ok := splitLegacyKey(string(iter.Key()))
+		if !ok {
+			continue
+		}
+		addLegacyField(users, urlKey, field, string(iter.Value()))
+	}
+	iter.Release()
+	if err := iter.Error(); err != nil {
+		return nil, fmt.Errorf("while reading getwtxt leveldb database at %s: %w", path, err)
+	}
+
+	return users, nil
+}
+
+// splitLegacyKey splits a getwtxt LevelDB key into the user's URL and the field, which is a status's
+// timestamp for url*Status*timestamp keys. The URL may contain * itself, so the key is split from the end.
+// Keys of remote registries, and keys without a field, aren't user keys.
+func splitLegacyKey(key string) (string, string, bool) {
+	if strings.HasPrefix(key, "remote*") {
+		return "", "", false
+	}
+	if i := strings.LastIndex(key, "*Status*"); i > 0 {
+		return key[:i], key[i+len("*Status*"):], true
+	}
+	i := strings.LastIndex(key, "*")
+	if i < 1 || i == len(key)-1 {
+		return "", "", false
+	}
+	return key[:i], key[i+1:], true
+}
+
+// readGetwtxtSQLite reads getwtxt's SQLite store, which holds one row per (urlKey, dataKey) pair.
+func readGetwtxtSQLite(path string) (map[string]*legacyUser, error) {
+	db, err := sql.Open("sqlite3", fmt.Sprintf("file:%s?mode=ro", path))
+	if err != nil {
+		return nil, fmt.Errorf("couldn't open getwtxt sqlite database at %s: %w", path, err)
+	}
+	defer func() {
+		_ = db.Close()
+	}()
+
+	rows, err := db.Query("SELECT urlKey, dataKey, data FROM getwtxt WHERE isUser")
+	if err != nil {
+		return nil, fmt.Errorf("couldn't query getwtxt sqlite database at %s: %w", path, err)
+	}
+	defer func() {
+		_ = rows.Close()
+	}()
+
+	users := make(map[string]*legacyUser)
+	for rows.Next() {
+		urlKey := ""
+		dataKey := ""
+		var data []byte
+		if err := rows.Scan(&urlKey, &dataKey, &data); err != nil {
+			return nil, fmt.Errorf("while reading getwtxt sqlite database at %s: %w", path, err)
+		}
+		addLegacyField(users, urlKey, dataKey, string(data))
+	}
+
+	return users, rows.Err()
+}
+
+// addLegacyField records a single field for the user keyed by urlKey.
+// Any field name that parses as a timestamp is a status posted at that time.
+func addLegacyField(users map[string]*legacyUser, urlKey, field, value string) {
+	u, ok := users[urlKey]
+	if !ok {
+		u = &legacyUser{url: urlKey, statuses: make(map[string]string)}
+		users[urlKey] = u
+	}
+
+	switch strings.ToLower(field) {
+	case "nick", "nickname":
+		u.nick = value
+	case "url":
+		u.url = value
+	case "date":
+		u.date = value
+	default:
+		if _, err := parseLegacyTime(field); err == nil {
+			u.statuses[field] = value
+		}
+	}
+}
+
+// convertLegacyUsers maps getwtxt's users onto our own types.
+// getwtxt stored each status as the whole registry line, nick<TAB>url<TAB>timestamp<TAB>body,
+// so the body is whatever follows the timestamp.
+func convertLegacyUsers(legacy map[string]*legacyUser) []importedUser {
+	keys := make([]string, 0, len(legacy))
+	for k := range legacy {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	out := make([]importedUser, 0, len(legacy))
+	for _, k := range keys {
+		lu := legacy[k]
+		if lu.nick == "" || lu.url == "" {
+			continue
+		}
+
+		added, err := parseLegacyTime(lu.date)
+		if err != nil {
+			added = time.Now().UTC()
+		}
+
+		iu := importedUser{
+			user: registry.User{
+				Nick:          lu.nick,
+				URL:           lu.url,
+				DateTimeAdded: added,
+			},
+			tweets: make([]registry.Tweet, 0, len(lu.statuses)),
+		}
+
+		for ts, status := range lu.statuses {
+			dt, err := parseLegacyTime(ts)
+			if err != nil {
+				continue
+			}
+			// Tabs after the timestamp are part of the body.
+			body := status
+			if fields := strings.SplitN(status, "\t", 4); len(fields) == 4 {
+				if _, err := parseLegacyTime(strings.TrimSpace(fields[2])); err == nil {
+					body = fields[3]
+				}
+			}
+			iu.tweets = append(iu.tweets, registry.Tweet{
+				DateTime: dt,
+				Body:     strings.TrimSpace(body),
+			})
+		}
+		sort.Slice(iu.tweets, func(i, j int) bool {
+			return iu.tweets[i].DateTime.Before(iu.tweets[j].DateTime)
+		})
+
+		out = append(out, iu)
+	}
+
+	return out
+}
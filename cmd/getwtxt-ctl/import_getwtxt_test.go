@@ -0,0 +1,171 @@
+package main
+
+/*
+Copyright 2021 G. Benjamin Morrison
+
+This file is part of getwtxt-ng.
+
+getwtxt-ng is free software: you can redistribute it and/or modify
+it under the terms of the GNU Affero General Public License as
+published by the Free Software Foundation, either version 3 of the
+License, or (at your option) any later version.
+
+getwtxt-ng is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU Affero General Public License for more details.
+
+You should have received a copy of the GNU Affero General Public License
+along with getwtxt-ng.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+import (
+	"database/sql"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/syndtr/goleveldb/leveldb"
+)
+
+// legacyDump is laid out the way getwtxt wrote its LevelDB store: url*Field for each user field,
+// url*Status*timestamp for each status, holding the whole registry line, and remote*url for other registries.
+var legacyDump = map[string]string{
+	"https://example.com/twtxt.txt*Nick":                              "foo",
+	"https://example.com/twtxt.txt*URL":                               "https://example.com/twtxt.txt",
+	"https://example.com/twtxt.txt*IP":                                "127.0.0.1",
+	"https://example.com/twtxt.txt*Date":                              "2019-05-01T12:00:00Z",
+	"https://example.com/twtxt.txt*RLen":                              "2048",
+	"https://example.com/twtxt.txt*LastModified":                      "Wed, 01 May 2019 12:00:00 GMT",
+	"https://example.com/twtxt.txt*Status*2019-05-02T08:00:00Z":       "foo\thttps://example.com/twtxt.txt\t2019-05-02T08:00:00Z\thello\tworld",
+	"https://example.com/twtxt.txt*Status*2019-05-01T08:00:00-04:00":  "foo\thttps://example.com/twtxt.txt\t2019-05-01T08:00:00-04:00\tfirst",
+	"https://example.org/~bar*/twtxt.txt*Nick":                        "bar",
+	"https://example.org/~bar*/twtxt.txt*URL":                         "https://example.org/~bar*/twtxt.txt",
+	"https://example.org/~bar*/twtxt.txt*Date":                        "not a date",
+	"https://example.org/~bar*/twtxt.txt*Status*2020-01-01T00:00:00Z": "just a body",
+	"https://example.net/twtxt.txt*URL":                               "https://example.net/twtxt.txt",
+	"remote*https://registry.example/api":                             "https://registry.example/api",
+}
+
+func TestSplitLegacyKey(t *testing.T) {
+	tests := []struct {
+		key, url, field string
+		ok              bool
+	}{
+		{"https://example.com/twtxt.txt*Nick", "https://example.com/twtxt.txt", "Nick", true},
+		{"https://example.com/twtxt.txt*Status*2019-05-02T08:00:00Z", "https://example.com/twtxt.txt", "2019-05-02T08:00:00Z", true},
+		{"https://example.org/~bar*/twtxt.txt*URL", "https://example.org/~bar*/twtxt.txt", "URL", true},
+		{"https://example.org/~bar*/twtxt.txt*Status*2020-01-01T00:00:00Z", "https://example.org/~bar*/twtxt.txt", "2020-01-01T00:00:00Z", true},
+		{"remote*https://registry.example/api", "", "", false},
+		{"https://example.com/twtxt.txt", "", "", false},
+		{"https://example.com/twtxt.txt*", "", "", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.key, func(t *testing.T) {
+			url, field, ok := splitLegacyKey(tt.key)
+			if url != tt.url || field != tt.field || ok != tt.ok {
+				t.Errorf("Expected %q, %q, %t, got %q, %q, %t", tt.url, tt.field, tt.ok, url, field, ok)
+			}
+		})
+	}
+}
+
+func TestConvertLegacyUsers(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "getwtxt.db")
+	ldb, err := leveldb.OpenFile(dir, nil)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	for k, v := range legacyDump {
+		if err := ldb.Put([]byte(k), []byte(v), nil); err != nil {
+			t.Fatal(err.Error())
+		}
+	}
+	if err := ldb.Close(); err != nil {
+		t.Fatal(err.Error())
+	}
+
+	legacy, err := readGetwtxtLevelDB(dir)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	users := convertLegacyUsers(legacy)
+
+	tests := []struct {
+		nick, url string
+		added     time.Time
+		tweets    []string
+	}{
+		{
+			nick:   "foo",
+			url:    "https://example.com/twtxt.txt",
+			added:  time.Date(2019, 5, 1, 12, 0, 0, 0, time.UTC),
+			tweets: []string{"first", "hello\tworld"},
+		},
+		{
+			nick:   "bar",
+			url:    "https://example.org/~bar*/twtxt.txt",
+			tweets: []string{"just a body"},
+		},
+	}
+	if len(users) != len(tests) {
+		t.Fatalf("Expected %d users, without the one with no nick or the remote registry, got %+v", len(tests), users)
+	}
+	for i, tt := range tests {
+		t.Run(tt.nick, func(t *testing.T) {
+			got := users[i]
+			if got.user.Nick != tt.nick || got.user.URL != tt.url {
+				t.Errorf("Expected %s at %s, got %s at %s", tt.nick, tt.url, got.user.Nick, got.user.URL)
+			}
+			if !tt.added.IsZero() && !got.user.DateTimeAdded.Equal(tt.added) {
+				t.Errorf("Expected to be added at %s, got %s", tt.added, got.user.DateTimeAdded)
+			}
+			if tt.added.IsZero() && time.Since(got.user.DateTimeAdded) > time.Minute {
+				t.Errorf("Expected an unreadable date to be replaced with now, got %s", got.user.DateTimeAdded)
+			}
+			if len(got.tweets) != len(tt.tweets) {
+				t.Fatalf("Expected %d tweets, got %+v", len(tt.tweets), got.tweets)
+			}
+			for j, body := range tt.tweets {
+				if got.tweets[j].Body != body {
+					t.Errorf("Expected tweet %d to be %q, got %q", j, body, got.tweets[j].Body)
+				}
+			}
+		})
+	}
+}
+
+func TestReadGetwtxtSQLite(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "getwtxt.sqlite")
+	db, err := sql.Open("sqlite3", path)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	t.Cleanup(func() {
+		_ = db.Close()
+	})
+	// getwtxt's SQLite store uses the field alone as the data key, with statuses keyed by their timestamp.
+	stmts := []string{
+		"CREATE TABLE getwtxt (id INTEGER PRIMARY KEY, urlKey TEXT, isUser BOOL, dataKey TEXT, data BLOB)",
+		"INSERT INTO getwtxt (urlKey, isUser, dataKey, data) VALUES ('https://example.com/twtxt.txt', 1, 'nickname', 'foo')",
+		"INSERT INTO getwtxt (urlKey, isUser, dataKey, data) VALUES ('https://example.com/twtxt.txt', 1, 'url', 'https://example.com/twtxt.txt')",
+		"INSERT INTO getwtxt (urlKey, isUser, dataKey, data) VALUES ('https://example.com/twtxt.txt', 1, 'date', '2019-05-01T12:00:00Z')",
+		"INSERT INTO getwtxt (urlKey, isUser, dataKey, data) VALUES ('https://example.com/twtxt.txt', 1, 'ip', '127.0.0.1')",
+		"INSERT INTO getwtxt (urlKey, isUser, dataKey, data) VALUES ('https://example.com/twtxt.txt', 1, '2019-05-02T08:00:00Z', 'foo\thttps://example.com/twtxt.txt\t2019-05-02T08:00:00Z\thello')",
+		"INSERT INTO getwtxt (urlKey, isUser, dataKey, data) VALUES ('https://registry.example/api', 0, 'url', 'https://registry.example/api')",
+	}
+	for _, stmt := range stmts {
+		if _, err := db.Exec(stmt); err != nil {
+			t.Fatal(err.Error())
+		}
+	}
+
+	legacy, err := readGetwtxtSQLite(path)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	users := convertLegacyUsers(legacy)
+	if len(users) != 1 || users[0].user.Nick != "foo" || len(users[0].tweets) != 1 || users[0].tweets[0].Body != "hello" {
+		t.Errorf("Expected foo with one tweet, got %+v", users)
+	}
+}
@@ -0,0 +1,275 @@
+/*
+Copyright 2021 G. Benjamin Morrison
+
+This file is part of getwtxt-ng.
+
+getwtxt-ng is free software: you can redistribute it and/or modify
+it under the terms of the GNU Affero General Public License as
+published by the Free Software Foundation, either version 3 of the
+License, or (at your option) any later version.
+
+getwtxt-ng is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU Affero General Public License for more details.
+
+You should have received a copy of the GNU Affero General Public License
+along with getwtxt-ng.  If not, see <https://www.gnu.org/licenses/>.
+*/
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+
+	"github.com/gbmor/getwtxt-ng/common"
+	"github.com/gbmor/getwtxt-ng/registry"
+)
+
+// regexFeedURL finds links to twtxt files in a page's HTML.
+var regexFeedURL = regexp.MustCompile(`https?://[^\s"'<>]+/twtxt\.txt`)
+
+// regexYarnUserFeed extracts the nick from a pod-hosted feed URL, eg: https://pod.example.com/user/foo/twtxt.txt
+var regexYarnUserFeed = regexp.MustCompile(`/user/(\w+)/twtxt\.txt$`)
+
+// regexNickMetadata extracts the nick a feed declares for itself.
+var regexNickMetadata = regexp.MustCompile(`(?m)^#\s*nick\s*=\s*(\w+)`)
+
+// yarnPages are the public pages of a pod that list feeds.
+var yarnPages = []string{"/discover", "/feeds"}
+
+// importYarnCmd crawls a Yarn.social pod's public pages for feeds and registers the ones we don't know about.
+func importYarnCmd(conf *ctlConfig, args []string) error {
+	flags := flag.NewFlagSet("import yarn", flag.ExitOnError)
+	maxPages := flags.Int("max-pages", 10, "Maximum number of pages to crawl per listing")
+	delay := flags.Duration("delay", time.Second, "Time to wait between requests to the same host")
+	dryRun := flags.Bool("dry-run", false, "List the feeds that would be registered without registering them")
+	passcodePath := flags.String("passcodes", "", "Write the generated passcodes to this file instead of stdout")
+	_ = flags.Parse(args)
+	if flags.NArg() < 1 {
+		return errors.New("please provide the URL of the pod, eg: https://twtxt.net")
+	}
+	podURL := strings.TrimSuffix(flags.Arg(0), "/")
+	if !common.IsValidURL(podURL, log.StandardLogger()) {
+		return fmt.Errorf("invalid pod URL: %s", podURL)
+	}
+
+	dbConn, err := openDB(conf)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		_ = dbConn.Close()
+	}()
+	ctx := context.Background()
+
+	crawler := newPoliteCrawler(dbConn.Client, *delay)
+
+	feeds := make(map[string]string)
+	for _, listing := range yarnPages {
+		for page := 1; page <= *maxPages; page++ {
+			pageURL := fmt.Sprintf("%s%s?p=%s", podURL, listing, strconv.Itoa(page))
+			body, err := crawler.get(ctx, pageURL)
+			if err != nil {
+				log.Infof("Stopping crawl of %s: %s", listing, err)
+				break
+			}
+			found := 0
+			for _, feedURL := range regexFeedURL.FindAllString(string(body), -1) {
+				if _, ok := feeds[feedURL]; ok {
+					continue
+				}
+				feeds[feedURL] = ""
+				if m := regexYarnUserFeed.FindStringSubmatch(feedURL); len(m) == 2 {
+					feeds[feedURL] = m[1]
+				}
+				found++
+			}
+			if found == 0 {
+				break
+			}
+		}
+	}
+
+	imported := make([]importedUser, 0, len(feeds))
+	for feedURL, nick := range feeds {
+		if _, err := dbConn.GetFullUserByURL(ctx, feedURL); err == nil {
+			continue
+		}
+		if *dryRun {
+			fmt.Printf("%s\t%s\n", nick, feedURL)
+			continue
+		}
+
+		body, err := crawler.get(ctx, feedURL)
+		if err != nil {
+			log.Infof("Skipping %s: %s", feedURL, err)
+			continue
+		}
+		if m := regexNickMetadata.FindSubmatch(body); len(m) == 2 {
+			nick = string(m[1])
+		}
+		if nick == "" {
+			log.Infof("Skipping %s: couldn't determine nick", feedURL)
+			continue
+		}
+
+		imported = append(imported, importedUser{
+			user: registry.User{
+				Nick: nick,
+				URL:  feedURL,
+			},
+		})
+	}
+
+	if *dryRun {
+		return nil
+	}
+
+	passcodeOut, err := openPasscodeOutput(*passcodePath)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		_ = passcodeOut.Close()
+	}()
+
+	// The feeds' tweets will be picked up by the next sync.
+	return storeImportedUsers(ctx, dbConn, imported, passcodeOut)
+}
+
+// politeCrawler honors the Disallow rules in each host's robots.txt, and waits between requests to the same host.
+type politeCrawler struct {
+	client *http.Client
+	delay  time.Duration
+	hosts  map[string]*crawlHost
+}
+
+// crawlHost is what the crawler remembers of a host: its robots.txt rules and when it was last requested.
+type crawlHost struct {
+	disallowed []string
+	last       time.Time
+}
+
+func newPoliteCrawler(client *http.Client, delay time.Duration) *politeCrawler {
+	return &politeCrawler{
+		client: client,
+		delay:  delay,
+		hosts:  make(map[string]*crawlHost),
+	}
+}
+
+// host returns what's known of the host serving parsedURL, reading its robots.txt the first time it's seen.
+func (c *politeCrawler) host(ctx context.Context, parsedURL *url.URL) *crawlHost {
+	site := parsedURL.Scheme + "://" + parsedURL.Host
+	if h, ok := c.hosts[site]; ok {
+		return h
+	}
+
+	h := &crawlHost{}
+	c.hosts[site] = h
+	body, err := c.fetch(ctx, h, site+"/robots.txt")
+	if err != nil {
+		log.Infof("Couldn't load robots.txt from %s, continuing without it: %s", site, err)
+		return h
+	}
+	h.disallowed = parseRobots(body)
+
+	return h
+}
+
+// parseRobots reads the Disallow rules for all user agents, or for us, from a robots.txt.
+// Consecutive User-agent lines share the rules that follow them.
+func parseRobots(body []byte) []string {
+	disallowed := make([]string, 0)
+	applies := false
+	inAgents := false
+	scanner := bufio.NewScanner(bytes.NewReader(body))
+	for scanner.Scan() {
+		line, _, _ := strings.Cut(scanner.Text(), "#")
+		key, value, found := strings.Cut(line, ":")
+		if !found {
+			continue
+		}
+		key = strings.ToLower(strings.TrimSpace(key))
+		value = strings.TrimSpace(value)
+		switch key {
+		case "user-agent":
+			if !inAgents {
+				applies = false
+			}
+			inAgents = true
+			applies = applies || value == "*" || strings.Contains(strings.ToLower(value), "getwtxt")
+		case "disallow":
+			inAgents = false
+			if applies && value != "" {
+				disallowed = append(disallowed, value)
+			}
+		default:
+			inAgents = false
+		}
+	}
+
+	return disallowed
+}
+
+func (h *crawlHost) allowed(path string) bool {
+	for _, prefix := range h.disallowed {
+		if strings.HasPrefix(path, prefix) {
+			return false
+		}
+	}
+
+	return true
+}
+
+func (c *politeCrawler) get(ctx context.Context, rawURL string) ([]byte, error) {
+	parsedURL, err := url.Parse(rawURL)
+	if err != nil {
+		return nil, err
+	}
+	h := c.host(ctx, parsedURL)
+	if !h.allowed(parsedURL.Path) {
+		return nil, fmt.Errorf("disallowed by robots.txt: %s", rawURL)
+	}
+
+	return c.fetch(ctx, h, rawURL)
+}
+
+// fetch requests rawURL once the delay since the last request to its host has passed.
+func (c *politeCrawler) fetch(ctx context.Context, h *crawlHost, rawURL string) ([]byte, error) {
+	if wait := c.delay - time.Since(h.last); wait > 0 {
+		time.Sleep(wait)
+	}
+	h.last = time.Now()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, rawURL, nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		_ = resp.Body.Close()
+	}()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("got status code %d from %s", resp.StatusCode, rawURL)
+	}
+
+	return io.ReadAll(io.LimitReader(resp.Body, 8<<20))
+}
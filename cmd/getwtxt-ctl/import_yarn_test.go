@@ -0,0 +1,92 @@
+package main
+
+/*
+Copyright 2021 G. Benjamin Morrison
+
+This file is part of getwtxt-ng.
+
+getwtxt-ng is free software: you can redistribute it and/or modify
+it under the terms of the GNU Affero General Public License as
+published by the Free Software Foundation, either version 3 of the
+License, or (at your option) any later version.
+
+getwtxt-ng is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU Affero General Public License for more details.
+
+You should have received a copy of the GNU Affero General Public License
+along with getwtxt-ng.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestPoliteCrawler(t *testing.T) {
+	ctx := context.Background()
+	robotsFetches := make(map[string]int)
+	newHost := func(robots string) *httptest.Server {
+		var srv *httptest.Server
+		srv = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.URL.Path == "/robots.txt" {
+				robotsFetches[srv.URL]++
+				if robots == "" {
+					http.NotFound(w, r)
+					return
+				}
+				_, _ = w.Write([]byte(robots))
+				return
+			}
+			_, _ = w.Write([]byte("ok"))
+		}))
+		t.Cleanup(srv.Close)
+		return srv
+	}
+	pod := newHost("User-agent: *\nDisallow: /user/\n")
+	other := newHost("")
+
+	crawler := newPoliteCrawler(http.DefaultClient, 0)
+	if _, err := crawler.get(ctx, pod.URL+"/discover"); err != nil {
+		t.Errorf("Expected a page robots.txt allows to be fetched, got %s", err)
+	}
+	if _, err := crawler.get(ctx, pod.URL+"/user/foo/twtxt.txt"); err == nil {
+		t.Error("Expected a page robots.txt disallows to be refused")
+	}
+	if _, err := crawler.get(ctx, other.URL+"/user/foo/twtxt.txt"); err != nil {
+		t.Errorf("Expected another host's rules not to apply, got %s", err)
+	}
+	if _, err := crawler.get(ctx, other.URL+"/user/bar/twtxt.txt"); err != nil {
+		t.Errorf("Expected a host without robots.txt to be crawled, got %s", err)
+	}
+
+	if robotsFetches[pod.URL] != 1 || robotsFetches[other.URL] != 1 {
+		t.Errorf("Expected robots.txt to be fetched once per host, got %v", robotsFetches)
+	}
+}
+
+func TestParseRobots(t *testing.T) {
+	tests := []struct {
+		name, robots string
+		disallowed   []string
+	}{
+		{"everyone", "User-agent: *\nDisallow: /user/\n", []string{"/user/"}},
+		{"someone else", "User-agent: otherbot\nDisallow: /\n", []string{}},
+		{"grouped agents", "User-agent: otherbot\nUser-agent: getwtxt-ng\nDisallow: /private/\n", []string{"/private/"}},
+		{"grouped with us first", "User-agent: getwtxt-ng\nUser-agent: otherbot\nDisallow: /private/\n", []string{"/private/"}},
+		{"separate groups", "User-agent: *\nDisallow: /a/\n\nUser-agent: otherbot\nDisallow: /b/\n", []string{"/a/"}},
+		{"comments", "# hello\nUser-agent: * # everyone\nDisallow: /user/ # feeds\nDisallow: # nothing\n", []string{"/user/"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := parseRobots([]byte(tt.robots))
+			if fmt.Sprint(got) != fmt.Sprint(tt.disallowed) {
+				t.Errorf("Expected %q, got %q", tt.disallowed, got)
+			}
+		})
+	}
+}
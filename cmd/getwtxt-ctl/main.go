@@ -0,0 +1,201 @@
+/*
+Copyright 2021 G. Benjamin Morrison
+
+This file is part of getwtxt-ng.
+
+getwtxt-ng is free software: you can redistribute it and/or modify
+it under the terms of the GNU Affero General Public License as
+published by the Free Software Foundation, either version 3 of the
+License, or (at your option) any later version.
+
+getwtxt-ng is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU Affero General Public License for more details.
+
+You should have received a copy of the GNU Affero General Public License
+along with getwtxt-ng.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+// getwtxt-ctl performs administrative tasks against a getwtxt-ng database.
+package main
+
+import (
+	"bufio"
+	"flag"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/BurntSushi/toml"
+	log "github.com/sirupsen/logrus"
+
+	"github.com/gbmor/getwtxt-ng/common"
+	"github.com/gbmor/getwtxt-ng/registry"
+)
+
+var flagConfig = flag.String("config", "getwtxt-ng.toml", "Path to getwtxt-ng's config file")
+
+// ctlConfig holds the subset of getwtxt-ng's configuration the control tool cares about.
+type ctlConfig struct {
+	ServerConfig struct {
+		DatabasePath string `toml:"database_path"`
+		DedupeMode   string `toml:"dedupe_mode"`
+		FetchTimeout string `toml:"fetch_timeout"`
+	} `toml:"server_config"`
+	InstanceInfo struct {
+		SiteURL  string `toml:"site_url"`
+		SiteName string `toml:"site_name"`
+	} `toml:"instance_info"`
+}
+
+// A command is a single getwtxt-ctl subcommand.
+type command struct {
+	usage string
+	run   func(conf *ctlConfig, args []string) error
+}
+
+var commands = map[string]command{
+	"bench": {
+		usage: "bench [-users 1000] [-tweets 100] [-iterations 20] [-sync-feeds 50] [-db path]",
+		run:   benchCmd,
+	},
+	"export": {
+		usage: "export [-o path]",
+		run:   exportCmd,
+	},
+	"import": {
+		usage: "import getwtxt|yarn [arguments] <path|pod url>",
+		run:   importCmd,
+	},
+	"migrate": {
+		usage: "migrate [up|down|status] [-yes]",
+		run:   migrateCmd,
+	},
+	"prune": {
+		usage: "prune [-tweets-older-than 2y] [-dead-feeds 90d] [-batch 500] [-dry-run]",
+		run:   pruneCmd,
+	},
+	"reindex": {
+		usage: "reindex [-check]",
+		run:   reindexCmd,
+	},
+	"stats": {
+		usage: "stats [-days 7] [-top 10] [-stale 48h]",
+		run:   statsCmd,
+	},
+	"sync": {
+		usage: "sync [-user url]",
+		run:   syncCmd,
+	},
+	"tweets": {
+		usage: "tweets hide|unhide|delete [-q query] [-limit n] [-yes] [id ...] | history <id ...>",
+		run:   tweetsCmd,
+	},
+	"users": {
+		usage: "users list|search|delete|merge|status|set-status [arguments]",
+		run:   usersCmd,
+	},
+}
+
+func main() {
+	flag.Usage = usage
+	flag.Parse()
+	args := flag.Args()
+	if len(args) < 1 {
+		usage()
+		os.Exit(1)
+	}
+
+	cmd, ok := commands[args[0]]
+	if !ok {
+		fmt.Printf("Unknown command: %s\n\n", args[0])
+		usage()
+		os.Exit(1)
+	}
+
+	conf, err := readConfig(*flagConfig)
+	if err != nil {
+		fmt.Printf("%s\n", err)
+		fmt.Printf("You may need to specify an alternate config path with:\n")
+		fmt.Printf("\t%s -config /path/to/getwtxt-ng.toml %s\n", os.Args[0], cmd.usage)
+		os.Exit(1)
+	}
+
+	if err := cmd.run(conf, args[1:]); err != nil {
+		fmt.Printf("%s\n", err)
+		os.Exit(1)
+	}
+}
+
+func usage() {
+	fmt.Printf("getwtxt-ctl %s\n\n", common.Version)
+	fmt.Printf("Usage: %s [-config /path/to/getwtxt-ng.toml] <command> [arguments]\n\n", os.Args[0])
+	fmt.Printf("Commands:\n")
+	names := make([]string, 0, len(commands))
+	for name := range commands {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		fmt.Printf("\t%s\n", commands[name].usage)
+	}
+}
+
+func readConfig(path string) (*ctlConfig, error) {
+	confFile, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("could not read getwtxt-ng config file at %s: %w", path, err)
+	}
+
+	conf := ctlConfig{}
+	if _, err := toml.Decode(string(confFile), &conf); err != nil {
+		return nil, fmt.Errorf("could not parse getwtxt-ng config file at %s: %w", path, err)
+	}
+	if conf.ServerConfig.DatabasePath == "" {
+		return nil, fmt.Errorf("could not grab database path from getwtxt-ng config file at %s", path)
+	}
+
+	return &conf, nil
+}
+
+func openDB(conf *ctlConfig) (*registry.DB, error) {
+	userAgent := fmt.Sprintf("getwtxt-ng/%s (+%s; @getwtxt-ng/ctl)", common.Version, conf.InstanceInfo.SiteURL)
+	tuning := registry.HTTPTuning{}
+	if conf.ServerConfig.FetchTimeout != "" {
+		timeout, err := time.ParseDuration(conf.ServerConfig.FetchTimeout)
+		if err != nil {
+			return nil, fmt.Errorf("when parsing fetch_timeout: %w", err)
+		}
+		tuning.Timeout = timeout
+	}
+	dbConn, err := registry.Open(conf.ServerConfig.DatabasePath,
+		registry.WithPageLimits(10, 1000),
+		registry.WithUserAgent(userAgent),
+		registry.WithHTTPTuning(tuning),
+		registry.WithLogger(log.StandardLogger()))
+	if err != nil {
+		return nil, fmt.Errorf("could not connect to database at %s: %w", conf.ServerConfig.DatabasePath, err)
+	}
+	dbConn.Dedupe, err = registry.ParseDedupeMode(conf.ServerConfig.DedupeMode)
+	if err != nil {
+		_ = dbConn.Close()
+		return nil, err
+	}
+
+	return dbConn, nil
+}
+
+// confirm asks the operator a yes/no question on stdin, defaulting to no.
+func confirm(question string) bool {
+	fmt.Printf("%s [y/N]: ", question)
+	answer, err := bufio.NewReader(os.Stdin).ReadString('\n')
+	if err != nil {
+		return false
+	}
+	answer = strings.ToLower(strings.TrimSpace(answer))
+
+	return answer == "y" || answer == "yes"
+}
@@ -0,0 +1,100 @@
+/*
+Copyright 2021 G. Benjamin Morrison
+
+This file is part of getwtxt-ng.
+
+getwtxt-ng is free software: you can redistribute it and/or modify
+it under the terms of the GNU Affero General Public License as
+published by the Free Software Foundation, either version 3 of the
+License, or (at your option) any later version.
+
+getwtxt-ng is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU Affero General Public License for more details.
+
+You should have received a copy of the GNU Affero General Public License
+along with getwtxt-ng.  If not, see <https://www.gnu.org/licenses/>.
+*/
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+
+	log "github.com/sirupsen/logrus"
+
+	"github.com/gbmor/getwtxt-ng/registry"
+)
+
+// migrateCmd applies, reverts, or reports on schema migrations.
+// The database is opened without migrating so status reflects what's actually on disk.
+func migrateCmd(conf *ctlConfig, args []string) error {
+	action := "status"
+	if len(args) > 0 {
+		action = args[0]
+		args = args[1:]
+	}
+
+	flags := flag.NewFlagSet("migrate", flag.ExitOnError)
+	yes := flags.Bool("yes", false, "Don't ask for confirmation before reverting a migration")
+	_ = flags.Parse(args)
+
+	dbConn, err := registry.OpenSQLite(conf.ServerConfig.DatabasePath, log.StandardLogger())
+	if err != nil {
+		return fmt.Errorf("could not connect to database at %s: %w", conf.ServerConfig.DatabasePath, err)
+	}
+	defer func() {
+		_ = dbConn.Close()
+	}()
+	ctx := context.Background()
+
+	switch action {
+	case "status":
+		status, err := dbConn.MigrationStatus(ctx)
+		if err != nil {
+			return err
+		}
+		pending := 0
+		for _, m := range status {
+			state := "applied"
+			if !m.Applied {
+				state = "pending"
+				pending++
+			}
+			fmt.Printf("%4d\t%s\t%s\n", m.Version, state, m.Description)
+		}
+		fmt.Printf("\n%d pending migrations\n", pending)
+
+	case "up":
+		applied, err := dbConn.MigrateUp(ctx)
+		if err != nil {
+			return err
+		}
+		version, err := dbConn.SchemaVersion(ctx)
+		if err != nil {
+			return err
+		}
+		fmt.Printf("Applied %d migrations, schema is at version %d\n", applied, version)
+
+	case "down":
+		version, err := dbConn.SchemaVersion(ctx)
+		if err != nil {
+			return err
+		}
+		if !*yes && !confirm(fmt.Sprintf("Revert migration %d? This may destroy data.", version)) {
+			fmt.Println("Aborted.")
+			return nil
+		}
+		if err := dbConn.MigrateDown(ctx); err != nil {
+			return err
+		}
+		fmt.Printf("Reverted migration %d\n", version)
+
+	default:
+		return fmt.Errorf("unknown migrate action: %s", action)
+	}
+
+	return nil
+}
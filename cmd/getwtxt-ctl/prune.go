@@ -0,0 +1,123 @@
+/*
+Copyright 2021 G. Benjamin Morrison
+
+This file is part of getwtxt-ng.
+
+getwtxt-ng is free software: you can redistribute it and/or modify
+it under the terms of the GNU Affero General Public License as
+published by the Free Software Foundation, either version 3 of the
+License, or (at your option) any later version.
+
+getwtxt-ng is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU Affero General Public License for more details.
+
+You should have received a copy of the GNU Affero General Public License
+along with getwtxt-ng.  If not, see <https://www.gnu.org/licenses/>.
+*/
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"time"
+
+	"github.com/gbmor/getwtxt-ng/common"
+	"github.com/gbmor/getwtxt-ng/registry"
+)
+
+// pruneCmd removes aged tweets and feeds that haven't synced successfully in a long time.
+func pruneCmd(conf *ctlConfig, args []string) error {
+	flags := flag.NewFlagSet("prune", flag.ExitOnError)
+	tweetsOlderThan := flags.String("tweets-older-than", "", "Delete tweets older than this, eg: 2y, 180d")
+	deadFeeds := flags.String("dead-feeds", "", "Delete feeds that haven't synced successfully in this long, eg: 90d")
+	batchSize := flags.Int("batch", 500, "Number of rows to delete per transaction")
+	dryRun := flags.Bool("dry-run", false, "Report what would be removed without removing anything")
+	_ = flags.Parse(args)
+
+	if *tweetsOlderThan == "" && *deadFeeds == "" {
+		return errors.New("please specify -tweets-older-than and/or -dead-feeds")
+	}
+
+	dbConn, err := openDB(conf)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		_ = dbConn.Close()
+	}()
+	ctx := context.Background()
+	now := time.Now().UTC()
+
+	if *tweetsOlderThan != "" {
+		age, err := common.ParseDuration(*tweetsOlderThan)
+		if err != nil {
+			return err
+		}
+		cutoff := now.Add(-age)
+		if *dryRun {
+			count, err := dbConn.CountTweetsOlderThan(ctx, cutoff)
+			if err != nil {
+				return err
+			}
+			fmt.Printf("Dry run: %d tweets older than %s would be deleted\n", count, cutoff.Format(time.RFC3339))
+		} else {
+			deleted, err := dbConn.DeleteTweetsOlderThan(ctx, cutoff, *batchSize)
+			if err != nil {
+				return fmt.Errorf("deleted %d tweets before failing: %w", deleted, err)
+			}
+			fmt.Printf("Deleted %d tweets older than %s\n", deleted, cutoff.Format(time.RFC3339))
+		}
+	}
+
+	if *deadFeeds != "" {
+		age, err := common.ParseDuration(*deadFeeds)
+		if err != nil {
+			return err
+		}
+		cutoff := now.Add(-age)
+		staleUsers, err := dbConn.GetStaleUsers(ctx, cutoff)
+		if err != nil {
+			return err
+		}
+
+		// Feeds added recently haven't had the chance to go stale yet.
+		dead := make([]registry.User, 0, len(staleUsers))
+		for _, u := range staleUsers {
+			if u.DateTimeAdded.Before(cutoff) {
+				dead = append(dead, u.User)
+			}
+		}
+
+		fmt.Printf("%s", registry.FormatUsersPlain(dead))
+		if *dryRun {
+			fmt.Printf("Dry run: %d feeds not synced since %s would be deleted\n", len(dead), cutoff.Format(time.RFC3339))
+			return nil
+		}
+
+		usersDeleted := int64(0)
+		tweetsDeleted := int64(0)
+		for start := 0; start < len(dead); start += *batchSize {
+			end := start + *batchSize
+			if end > len(dead) {
+				end = len(dead)
+			}
+			urls := make([]string, 0, end-start)
+			for _, u := range dead[start:end] {
+				urls = append(urls, u.URL)
+			}
+			nUsers, nTweets, err := dbConn.DeleteUsers(ctx, urls)
+			if err != nil {
+				return fmt.Errorf("deleted %d feeds before failing: %w", usersDeleted, err)
+			}
+			usersDeleted += nUsers
+			tweetsDeleted += nTweets
+		}
+		fmt.Printf("Deleted %d feeds not synced since %s, along with %d of their tweets\n", usersDeleted, cutoff.Format(time.RFC3339), tweetsDeleted)
+	}
+
+	return nil
+}
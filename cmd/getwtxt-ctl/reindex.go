@@ -0,0 +1,58 @@
+/*
+Copyright 2021 G. Benjamin Morrison
+
+This file is part of getwtxt-ng.
+
+getwtxt-ng is free software: you can redistribute it and/or modify
+it under the terms of the GNU Affero General Public License as
+published by the Free Software Foundation, either version 3 of the
+License, or (at your option) any later version.
+
+getwtxt-ng is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU Affero General Public License for more details.
+
+You should have received a copy of the GNU Affero General Public License
+along with getwtxt-ng.  If not, see <https://www.gnu.org/licenses/>.
+*/
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"time"
+)
+
+// reindexCmd rebuilds the full-text search index from the tweets and users tables.
+func reindexCmd(conf *ctlConfig, args []string) error {
+	flags := flag.NewFlagSet("reindex", flag.ExitOnError)
+	checkOnly := flags.Bool("check", false, "Only check the index for consistency, don't rebuild it")
+	_ = flags.Parse(args)
+
+	dbConn, err := openDB(conf)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		_ = dbConn.Close()
+	}()
+	ctx := context.Background()
+
+	if *checkOnly {
+		if err := dbConn.CheckSearchIndex(ctx); err != nil {
+			return err
+		}
+		fmt.Println("Search index is consistent.")
+		return nil
+	}
+
+	begin := time.Now()
+	if err := dbConn.RebuildSearchIndex(ctx); err != nil {
+		return err
+	}
+	fmt.Printf("Rebuilt search index in %s\n", time.Since(begin).Round(time.Millisecond))
+
+	return nil
+}
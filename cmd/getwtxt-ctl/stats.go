@@ -0,0 +1,101 @@
+/*
+Copyright 2021 G. Benjamin Morrison
+
+This file is part of getwtxt-ng.
+
+getwtxt-ng is free software: you can redistribute it and/or modify
+it under the terms of the GNU Affero General Public License as
+published by the Free Software Foundation, either version 3 of the
+License, or (at your option) any later version.
+
+getwtxt-ng is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU Affero General Public License for more details.
+
+You should have received a copy of the GNU Affero General Public License
+along with getwtxt-ng.  If not, see <https://www.gnu.org/licenses/>.
+*/
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"time"
+)
+
+// statsCmd prints an overview of the registry's contents and health.
+func statsCmd(conf *ctlConfig, args []string) error {
+	flags := flag.NewFlagSet("stats", flag.ExitOnError)
+	days := flags.Int("days", 7, "Number of days to show tweet counts for")
+	top := flags.Int("top", 10, "Number of domains to show")
+	stale := flags.Duration("stale", 48*time.Hour, "Report feeds that haven't synced successfully within this long")
+	_ = flags.Parse(args)
+
+	dbConn, err := openDB(conf)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		_ = dbConn.Close()
+	}()
+	ctx := context.Background()
+
+	if err := dbConn.SetUserCount(ctx); err != nil {
+		return err
+	}
+	if err := dbConn.SetTweetCount(ctx); err != nil {
+		return err
+	}
+	fmt.Printf("Users:  %d\n", dbConn.GetUserCount())
+	fmt.Printf("Tweets: %d\n", dbConn.GetTweetCount())
+
+	dayCounts, err := dbConn.GetTweetCountsByDay(ctx, *days)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("\nTweets per day:\n")
+	for _, dc := range dayCounts {
+		fmt.Printf("\t%s\t%d\n", dc.Day.Format("2006-01-02"), dc.Count)
+	}
+
+	domains, err := dbConn.GetTopDomains(ctx, *top)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("\nTop domains:\n")
+	for _, dc := range domains {
+		fmt.Printf("\t%d\t%s\n", dc.Count, dc.Domain)
+	}
+
+	filterCounts, err := dbConn.GetFilterCounts(ctx)
+	if err != nil {
+		return err
+	}
+	if len(filterCounts) > 0 {
+		fmt.Printf("\nContent filter (pending, released):\n")
+		for _, fc := range filterCounts {
+			fmt.Printf("\t%s\t%s\t%d\t%d\n", fc.Rule, fc.Action, fc.Pending, fc.Released)
+		}
+	}
+
+	staleUsers, err := dbConn.GetStaleUsers(ctx, time.Now().UTC().Add(-*stale))
+	if err != nil {
+		return err
+	}
+	fmt.Printf("\nFeeds not synced in the last %s (last sync, last status, last error): %d\n", *stale, len(staleUsers))
+	for _, u := range staleUsers {
+		lastSync := "never"
+		if u.LastSync.UnixNano() > 0 {
+			lastSync = u.LastSync.UTC().Format(time.RFC3339)
+		}
+		lastError := "-"
+		if u.LastFetch.Error != "" {
+			lastError = u.LastFetch.Error
+		}
+		fmt.Printf("\t%s\t%s\t%s\t%d\t%s\n", u.Nick, u.URL, lastSync, u.LastFetch.StatusCode, lastError)
+	}
+
+	return nil
+}
@@ -0,0 +1,85 @@
+/*
+Copyright 2021 G. Benjamin Morrison
+
+This file is part of getwtxt-ng.
+
+getwtxt-ng is free software: you can redistribute it and/or modify
+it under the terms of the GNU Affero General Public License as
+published by the Free Software Foundation, either version 3 of the
+License, or (at your option) any later version.
+
+getwtxt-ng is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU Affero General Public License for more details.
+
+You should have received a copy of the GNU Affero General Public License
+along with getwtxt-ng.  If not, see <https://www.gnu.org/licenses/>.
+*/
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/gbmor/getwtxt-ng/registry"
+)
+
+// syncCmd fetches tweets from every feed (or just one) once and reports how it went.
+func syncCmd(conf *ctlConfig, args []string) error {
+	flags := flag.NewFlagSet("sync", flag.ExitOnError)
+	userURL := flags.String("user", "", "Only sync the feed at this URL")
+	_ = flags.Parse(args)
+
+	dbConn, err := openDB(conf)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		_ = dbConn.Close()
+	}()
+	ctx := context.Background()
+
+	var users []registry.User
+	if *userURL != "" {
+		user, err := dbConn.GetFullUserByURL(ctx, *userURL)
+		if err != nil {
+			return fmt.Errorf("couldn't find user %s: %w", *userURL, err)
+		}
+		users = []registry.User{*user}
+	} else {
+		users, err = dbConn.GetAllUsers(ctx)
+		if err != nil {
+			return fmt.Errorf("couldn't get users to sync: %w", err)
+		}
+	}
+
+	begin := time.Now()
+	result, err := dbConn.SyncUsers(ctx, users)
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("Synced %d feeds in %s\n", result.Users, time.Since(begin).Round(time.Millisecond))
+	fmt.Printf("\tUpdated:      %d (%d new tweets)\n", result.Updated, result.Tweets)
+	fmt.Printf("\tNot modified: %d\n", result.NotModified)
+	fmt.Printf("\tFailed:       %d\n", len(result.Failed))
+	if len(result.Failed) == 0 {
+		return nil
+	}
+
+	failed := make([]string, 0, len(result.Failed))
+	for feedURL := range result.Failed {
+		failed = append(failed, feedURL)
+	}
+	sort.Strings(failed)
+	fmt.Println()
+	for _, feedURL := range failed {
+		fmt.Printf("%s\t%s\n", feedURL, result.Failed[feedURL])
+	}
+
+	return fmt.Errorf("%d of %d feeds failed to sync", len(result.Failed), result.Users)
+}
@@ -0,0 +1,174 @@
+/*
+Copyright 2021 G. Benjamin Morrison
+
+This file is part of getwtxt-ng.
+
+getwtxt-ng is free software: you can redistribute it and/or modify
+it under the terms of the GNU Affero General Public License as
+published by the Free Software Foundation, either version 3 of the
+License, or (at your option) any later version.
+
+getwtxt-ng is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU Affero General Public License for more details.
+
+You should have received a copy of the GNU Affero General Public License
+along with getwtxt-ng.  If not, see <https://www.gnu.org/licenses/>.
+*/
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"time"
+
+	"github.com/gbmor/getwtxt-ng/registry"
+)
+
+// tweetsCmd hides, unhides, or deletes tweets selected by ID or by a search query.
+func tweetsCmd(conf *ctlConfig, args []string) error {
+	if len(args) < 1 {
+		return errors.New("please specify a tweets subcommand: hide, unhide, delete, history")
+	}
+	action := args[0]
+	if action == "history" {
+		return tweetsHistoryCmd(conf, args[1:])
+	}
+	if action != "hide" && action != "unhide" && action != "delete" {
+		return fmt.Errorf("unknown tweets subcommand: %s", action)
+	}
+
+	flags := flag.NewFlagSet("tweets "+action, flag.ExitOnError)
+	query := flags.String("q", "", "Select tweets matching this search query instead of by ID")
+	limit := flags.Int("limit", 100, "Maximum number of tweets to select with -q")
+	yes := flags.Bool("yes", false, "Don't ask for confirmation")
+	_ = flags.Parse(args[1:])
+
+	ids := flags.Args()
+	if *query == "" && len(ids) < 1 {
+		return errors.New("please provide tweet IDs or a search query with -q")
+	}
+
+	dbConn, err := openDB(conf)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		_ = dbConn.Close()
+	}()
+	ctx := context.Background()
+
+	var tweets []registry.Tweet
+	if *query != "" {
+		tweets, err = searchTweetsForAction(ctx, dbConn, action, *query, *limit)
+	} else {
+		tweets, err = dbConn.GetTweetsByID(ctx, ids)
+	}
+	if err != nil {
+		return fmt.Errorf("couldn't retrieve tweets: %w", err)
+	}
+	if len(tweets) < 1 {
+		fmt.Println("No tweets matched.")
+		return nil
+	}
+
+	for _, tw := range tweets {
+		fmt.Printf("%s\t%s", tw.ID, registry.FormatTweetsPlain([]registry.Tweet{tw}))
+	}
+	if !*yes && !confirm(fmt.Sprintf("%s these %d tweets?", action, len(tweets))) {
+		fmt.Println("Aborted.")
+		return nil
+	}
+
+	switch action {
+	case "delete":
+		toDelete := make([]string, 0, len(tweets))
+		for _, tw := range tweets {
+			toDelete = append(toDelete, tw.ID)
+		}
+		n, err := dbConn.DeleteTweets(ctx, toDelete)
+		if err != nil {
+			return err
+		}
+		fmt.Printf("Deleted %d tweets\n", n)
+
+	default:
+		status := registry.StatusHidden
+		if action == "unhide" {
+			status = registry.StatusVisible
+		}
+		ids := make([]string, 0, len(tweets))
+		for _, tw := range tweets {
+			ids = append(ids, tw.ID)
+		}
+		changed, err := dbConn.SetTweetsVisibility(ctx, ids, status)
+		if err != nil {
+			return err
+		}
+		fmt.Printf("Changed visibility of %d tweets\n", changed)
+	}
+
+	return nil
+}
+
+// searchTweetsForAction only looks at tweets the action would affect:
+// visible ones for hide, hidden ones for unhide, and both for delete.
+func searchTweetsForAction(ctx context.Context, dbConn *registry.DB, action, query string, limit int) ([]registry.Tweet, error) {
+	statuses := []registry.TweetVisibilityStatus{registry.StatusVisible, registry.StatusHidden}
+	switch action {
+	case "hide":
+		statuses = statuses[:1]
+	case "unhide":
+		statuses = statuses[1:]
+	}
+
+	out := make([]registry.Tweet, 0, limit)
+	for _, status := range statuses {
+		tweets, err := dbConn.SearchTweets(ctx, 1, limit, query, status)
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, tweets...)
+	}
+	if len(out) > limit {
+		out = out[:limit]
+	}
+
+	return out, nil
+}
+
+// tweetsHistoryCmd prints the current body of each tweet followed by its earlier versions.
+func tweetsHistoryCmd(conf *ctlConfig, args []string) error {
+	if len(args) < 1 {
+		return errors.New("please provide at least one tweet ID")
+	}
+
+	dbConn, err := openDB(conf)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		_ = dbConn.Close()
+	}()
+	ctx := context.Background()
+
+	tweets, err := dbConn.GetTweetsByID(ctx, args)
+	if err != nil {
+		return fmt.Errorf("couldn't retrieve tweets: %w", err)
+	}
+	for _, tw := range tweets {
+		fmt.Printf("%s\t%s", tw.ID, registry.FormatTweetsPlain([]registry.Tweet{tw}))
+		revisions, err := dbConn.GetTweetRevisions(ctx, tw.ID)
+		if err != nil {
+			return err
+		}
+		for _, rev := range revisions {
+			fmt.Printf("\treplaced %s\t%s\n", rev.Replaced.UTC().Format(time.RFC3339), rev.Body)
+		}
+	}
+
+	return nil
+}
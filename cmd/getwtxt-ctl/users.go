@@ -0,0 +1,426 @@
+/*
+Copyright 2021 G. Benjamin Morrison
+
+This file is part of getwtxt-ng.
+
+getwtxt-ng is free software: you can redistribute it and/or modify
+it under the terms of the GNU Affero General Public License as
+published by the Free Software Foundation, either version 3 of the
+License, or (at your option) any later version.
+
+getwtxt-ng is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU Affero General Public License for more details.
+
+You should have received a copy of the GNU Affero General Public License
+along with getwtxt-ng.  If not, see <https://www.gnu.org/licenses/>.
+*/
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"net/url"
+	"os"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/gbmor/getwtxt-ng/registry"
+)
+
+func usersCmd(conf *ctlConfig, args []string) error {
+	if len(args) < 1 {
+		return errors.New("please specify a users subcommand: list, search, delete, merge, status, set-status")
+	}
+
+	switch args[0] {
+	case "list":
+		return usersListCmd(conf, args[1:], false)
+	case "search":
+		return usersListCmd(conf, args[1:], true)
+	case "delete":
+		return usersDeleteCmd(conf, args[1:])
+	case "merge":
+		return usersMergeCmd(conf, args[1:])
+	case "status":
+		return usersStatusCmd(conf, args[1:])
+	case "set-status":
+		return usersSetStatusCmd(conf, args[1:])
+	default:
+		return fmt.Errorf("unknown users subcommand: %s", args[0])
+	}
+}
+
+// usersListCmd prints a page of users, optionally filtered by a search term.
+func usersListCmd(conf *ctlConfig, args []string, search bool) error {
+	name := "users list"
+	if search {
+		name = "users search"
+	}
+	flags := flag.NewFlagSet(name, flag.ExitOnError)
+	page := flags.Int("page", 1, "Page of results to show")
+	perPage := flags.Int("per-page", 20, "Number of users per page")
+	format := flags.String("format", "table", "Output format: table, json, or plain")
+	_ = flags.Parse(args)
+
+	searchTerm := strings.Join(flags.Args(), " ")
+	if search && searchTerm == "" {
+		return errors.New("please provide a search term")
+	}
+
+	dbConn, err := openDB(conf)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		_ = dbConn.Close()
+	}()
+	ctx := context.Background()
+
+	var users []registry.User
+	if search {
+		users, err = dbConn.SearchUsers(ctx, *page, *perPage, searchTerm)
+	} else {
+		users, err = dbConn.GetUsers(ctx, *page, *perPage)
+	}
+	if err != nil {
+		return fmt.Errorf("couldn't retrieve users: %w", err)
+	}
+
+	return printUsers(users, *format)
+}
+
+func printUsers(users []registry.User, format string) error {
+	switch format {
+	case "json":
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		return enc.Encode(users)
+	case "plain":
+		fmt.Print(registry.FormatUsersPlain(users))
+		return nil
+	case "table":
+		tw := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+		fmt.Fprintln(tw, "ID\tNICK\tURL\tADDED\tLAST SYNC")
+		for _, u := range users {
+			fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s\n", u.ID, u.Nick, u.URL, u.DateTimeAdded.UTC().Format(time.RFC3339), formatSyncTime(u.LastSync))
+		}
+		return tw.Flush()
+	default:
+		return fmt.Errorf("unknown output format: %s", format)
+	}
+}
+
+// usersDeleteCmd removes every user matching the provided URLs or domains, along with their tweets.
+func usersDeleteCmd(conf *ctlConfig, args []string) error {
+	flags := flag.NewFlagSet("users delete", flag.ExitOnError)
+	dryRun := flags.Bool("dry-run", false, "List the users that would be deleted without deleting them")
+	yes := flags.Bool("yes", false, "Don't ask for confirmation before deleting")
+	filePath := flags.String("file", "", "Path to a file containing one URL or domain per line")
+	_ = flags.Parse(args)
+
+	patterns := flags.Args()
+	if *filePath != "" {
+		filePatterns, err := readPatternFile(*filePath)
+		if err != nil {
+			return err
+		}
+		patterns = append(patterns, filePatterns...)
+	}
+	if len(patterns) < 1 {
+		return errors.New("please provide at least one URL or domain to delete, or a file containing them with -file")
+	}
+
+	dbConn, err := openDB(conf)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		_ = dbConn.Close()
+	}()
+
+	ctx := context.Background()
+	users, err := dbConn.GetAllUsers(ctx)
+	if err != nil {
+		return fmt.Errorf("couldn't retrieve users: %w", err)
+	}
+
+	matched := matchUsers(users, patterns)
+	if len(matched) < 1 {
+		fmt.Println("No users matched.")
+		return nil
+	}
+
+	fmt.Printf("%s\n", registry.FormatUsersPlain(matched))
+	if *dryRun {
+		fmt.Printf("Dry run: %d users would be deleted.\n", len(matched))
+		return nil
+	}
+	if !*yes && !confirm(fmt.Sprintf("Delete these %d users and their tweets?", len(matched))) {
+		fmt.Println("Aborted.")
+		return nil
+	}
+
+	urls := make([]string, 0, len(matched))
+	for _, u := range matched {
+		urls = append(urls, u.URL)
+	}
+
+	userCount, tweetCount, err := dbConn.DeleteUsers(ctx, urls)
+	if err != nil {
+		return fmt.Errorf("couldn't delete users: %w", err)
+	}
+
+	fmt.Printf("Deleted %d users\nDeleted %d tweets\n", userCount, tweetCount)
+
+	return nil
+}
+
+// usersMergeCmd folds a duplicate registration into the user that should be kept.
+// With -duplicates, it does so for every feed registered under more than one form of its URL.
+func usersMergeCmd(conf *ctlConfig, args []string) error {
+	flags := flag.NewFlagSet("users merge", flag.ExitOnError)
+	yes := flags.Bool("yes", false, "Don't ask for confirmation")
+	duplicates := flags.Bool("duplicates", false, "Merge every feed registered under more than one form of its URL, such as http:// and https://")
+	dryRun := flags.Bool("dry-run", false, "With -duplicates, list what would be merged without merging")
+	_ = flags.Parse(args)
+	if *duplicates {
+		return usersMergeDuplicates(conf, *yes, *dryRun)
+	}
+	if flags.NArg() != 2 {
+		return errors.New("please provide the URL of the user to remove followed by the URL of the user to keep")
+	}
+	loserURL := flags.Arg(0)
+	winnerURL := flags.Arg(1)
+
+	dbConn, err := openDB(conf)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		_ = dbConn.Close()
+	}()
+	ctx := context.Background()
+
+	if !*yes && !confirm(fmt.Sprintf("Move the tweets of %s to %s and delete %s?", loserURL, winnerURL, loserURL)) {
+		fmt.Println("Aborted.")
+		return nil
+	}
+
+	moved, dropped, err := dbConn.MergeUsers(ctx, loserURL, winnerURL)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("Merged %s into %s: moved %d tweets, dropped %d duplicates\n", loserURL, winnerURL, moved, dropped)
+
+	return nil
+}
+
+// usersStatusCmd prints the outcome of the last attempt to sync each of the provided users.
+func usersStatusCmd(conf *ctlConfig, args []string) error {
+	flags := flag.NewFlagSet("users status", flag.ExitOnError)
+	format := flags.String("format", "table", "Output format: table or json")
+	_ = flags.Parse(args)
+	if flags.NArg() < 1 {
+		return errors.New("please provide at least one user URL")
+	}
+
+	dbConn, err := openDB(conf)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		_ = dbConn.Close()
+	}()
+	ctx := context.Background()
+
+	statuses := make([]registry.FetchStatus, 0, flags.NArg())
+	for _, userURL := range flags.Args() {
+		status, err := dbConn.GetFetchStatus(ctx, userURL)
+		if err != nil {
+			return err
+		}
+		statuses = append(statuses, *status)
+	}
+
+	switch *format {
+	case "json":
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		return enc.Encode(statuses)
+	case "table":
+		tw := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+		fmt.Fprintln(tw, "URL\tSTATUS\tLAST ATTEMPT\tLAST SUCCESS\tERROR")
+		for _, s := range statuses {
+			fmt.Fprintf(tw, "%s\t%d\t%s\t%s\t%s\n", s.URL, s.StatusCode, formatSyncTime(s.LastAttempt), formatSyncTime(s.LastSuccess), s.Error)
+		}
+		return tw.Flush()
+	default:
+		return fmt.Errorf("unknown output format: %s", *format)
+	}
+}
+
+// usersSetStatusCmd moves the provided users into a lifecycle status, such as suspended.
+func usersSetStatusCmd(conf *ctlConfig, args []string) error {
+	flags := flag.NewFlagSet("users set-status", flag.ExitOnError)
+	yes := flags.Bool("yes", false, "Don't ask for confirmation")
+	_ = flags.Parse(args)
+	if flags.NArg() < 2 {
+		return errors.New("please provide a status (active, suspended, pending-verification, pending-approval, inactive) followed by at least one user URL")
+	}
+	status, err := registry.ParseUserStatus(flags.Arg(0))
+	if err != nil {
+		return err
+	}
+	urls := flags.Args()[1:]
+
+	dbConn, err := openDB(conf)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		_ = dbConn.Close()
+	}()
+
+	if !*yes && !confirm(fmt.Sprintf("Set the status of %d users to %s?", len(urls), status)) {
+		fmt.Println("Aborted.")
+		return nil
+	}
+
+	updated, err := dbConn.SetUserStatus(context.Background(), status, urls...)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("Set the status of %d users to %s\n", updated, status)
+
+	return nil
+}
+
+// formatSyncTime formats t as RFC3339, or "never" if it's unset.
+func formatSyncTime(t time.Time) string {
+	if t.UnixNano() <= 0 {
+		return "never"
+	}
+	return t.UTC().Format(time.RFC3339)
+}
+
+// readPatternFile reads one URL or domain per line, skipping comments and blank lines.
+// Lines in user list format (nick<TAB>url<TAB>datetime) are accepted as well.
+func readPatternFile(path string) ([]string, error) {
+	fd, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("couldn't open %s: %w", path, err)
+	}
+	defer func() {
+		_ = fd.Close()
+	}()
+
+	patterns := make([]string, 0, 16)
+	scanner := bufio.NewScanner(fd)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		fields := strings.Fields(line)
+		if len(fields) > 1 {
+			patterns = append(patterns, fields[1])
+			continue
+		}
+		patterns = append(patterns, fields[0])
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("couldn't read %s: %w", path, err)
+	}
+
+	return patterns, nil
+}
+
+// matchUsers returns the users matching any of the patterns.
+// Patterns containing a scheme are compared to the whole URL,
+// anything else is treated as a domain and matches it along with its subdomains.
+func matchUsers(users []registry.User, patterns []string) []registry.User {
+	urls := make(map[string]struct{})
+	domains := make([]string, 0, len(patterns))
+	for _, p := range patterns {
+		if strings.Contains(p, "://") {
+			urls[p] = struct{}{}
+			continue
+		}
+		domains = append(domains, strings.ToLower(strings.TrimPrefix(p, "www.")))
+	}
+
+	matched := make([]registry.User, 0)
+	for _, u := range users {
+		if _, ok := urls[u.URL]; ok {
+			matched = append(matched, u)
+			continue
+		}
+		parsedURL, err := url.Parse(u.URL)
+		if err != nil {
+			continue
+		}
+		host := strings.ToLower(strings.TrimPrefix(parsedURL.Hostname(), "www."))
+		for _, d := range domains {
+			if host == d || strings.HasSuffix(host, "."+d) {
+				matched = append(matched, u)
+				break
+			}
+		}
+	}
+
+	return matched
+}
+
+// usersMergeDuplicates merges each feed registered more than once into the registration that's kept.
+func usersMergeDuplicates(conf *ctlConfig, yes, dryRun bool) error {
+	dbConn, err := openDB(conf)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		_ = dbConn.Close()
+	}()
+	ctx := context.Background()
+
+	dupes, err := dbConn.FindDuplicateUsers(ctx)
+	if err != nil {
+		return fmt.Errorf("couldn't find duplicate users: %w", err)
+	}
+	if len(dupes) < 1 {
+		fmt.Println("No duplicate users found.")
+		return nil
+	}
+
+	removing := 0
+	for _, dupe := range dupes {
+		for _, u := range dupe.Remove {
+			fmt.Printf("%s -> %s\n", u.URL, dupe.Keep.URL)
+			removing++
+		}
+	}
+	if dryRun {
+		fmt.Printf("Dry run: %d users would be merged.\n", removing)
+		return nil
+	}
+	if !yes && !confirm(fmt.Sprintf("Merge these %d users into the ones they duplicate?", removing)) {
+		fmt.Println("Aborted.")
+		return nil
+	}
+
+	merged, err := dbConn.MergeDuplicateUsers(ctx)
+	for _, m := range merged {
+		fmt.Printf("Merged %s into %s: moved %d tweets, dropped %d duplicates\n", m.RemovedURL, m.KeptURL, m.Moved, m.Dropped)
+	}
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
@@ -0,0 +1,412 @@
+package main
+
+/*
+Copyright 2021 G. Benjamin Morrison
+
+This file is part of getwtxt-ng.
+
+getwtxt-ng is free software: you can redistribute it and/or modify
+it under the terms of the GNU Affero General Public License as
+published by the Free Software Foundation, either version 3 of the
+License, or (at your option) any later version.
+
+getwtxt-ng is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU Affero General Public License for more details.
+
+You should have received a copy of the GNU Affero General Public License
+along with getwtxt-ng.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+import (
+	"context"
+	"crypto/rsa"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+	log "github.com/sirupsen/logrus"
+
+	"github.com/gbmor/getwtxt-ng/activitypub"
+	"github.com/gbmor/getwtxt-ng/registry"
+)
+
+// Limits for the ActivityPub bridge.
+const (
+	apDeliveryWorkers = 4
+	apOutboxSize      = 20
+	apMaxInboxBody    = 1 << 20
+)
+
+// apBridge exposes registered feeds as read-only ActivityPub actors. Every actor shares the instance's key.
+type apBridge struct {
+	dbConn    *registry.DB
+	baseURL   string
+	host      string
+	siteName  string
+	key       *rsa.PrivateKey
+	keyPEM    string
+	client    *http.Client
+	deliverer *activitypub.Deliverer
+}
+
+func newAPBridge(conf *Config, dbConn *registry.DB) (*apBridge, error) {
+	conf.mu.RLock()
+	defer conf.mu.RUnlock()
+
+	siteURL, err := url.Parse(conf.InstanceConfig.SiteURL)
+	if err != nil || siteURL.Host == "" {
+		return nil, fmt.Errorf("site_url must be an absolute URL: %s", conf.InstanceConfig.SiteURL)
+	}
+	keyPEM, err := activitypub.PublicKeyPEM(conf.ServerConfig.ActivityPubKey)
+	if err != nil {
+		return nil, fmt.Errorf("when encoding activitypub public key: %w", err)
+	}
+	client := &http.Client{Timeout: 10 * time.Second}
+
+	return &apBridge{
+		dbConn:    dbConn,
+		baseURL:   strings.TrimSuffix(siteURL.String(), "/"),
+		host:      siteURL.Host,
+		siteName:  conf.InstanceConfig.SiteName,
+		key:       conf.ServerConfig.ActivityPubKey,
+		keyPEM:    keyPEM,
+		client:    client,
+		deliverer: activitypub.NewDeliverer(client, conf.ServerConfig.ActivityPubKey, apDeliveryWorkers, log.StandardLogger()),
+	}, nil
+}
+
+// Close waits for queued deliveries to finish. Nothing is delivered afterward.
+func (b *apBridge) Close() {
+	b.deliverer.Close()
+}
+
+func (b *apBridge) actorURL(nick string) string {
+	return fmt.Sprintf("%s/ap/users/%s", b.baseURL, url.PathEscape(nick))
+}
+
+func (b *apBridge) keyID(nick string) string {
+	return b.actorURL(nick) + "#main-key"
+}
+
+func (b *apBridge) noteURL(nick, tweetID string) string {
+	return fmt.Sprintf("%s/notes/%s", b.actorURL(nick), tweetID)
+}
+
+func (b *apBridge) note(nick string, t registry.Tweet) activitypub.Note {
+	actorURL := b.actorURL(nick)
+	note := activitypub.NewNote(b.noteURL(nick, t.ID), actorURL, actorURL+"/followers", t.DateTime, t.Body)
+	note.URL = t.URL
+	return note
+}
+
+func (b *apBridge) create(nick string, t registry.Tweet) activitypub.Activity {
+	note := b.note(nick, t)
+	return activitypub.Activity{
+		Context: activitypub.Context,
+		ID:      note.ID + "#create",
+		Type:    "Create",
+		Actor:   note.AttributedTo,
+		Object:  note,
+		To:      note.To,
+		CC:      note.CC,
+	}
+}
+
+// tweetsInserted delivers new tweets to the followers of their authors. It's used as the TweetsInserted hook.
+func (b *apBridge) tweetsInserted(ctx context.Context, tweets []registry.Tweet) {
+	byUser := make(map[string][]registry.Tweet)
+	for _, t := range tweets {
+		if t.Hidden == registry.StatusVisible {
+			byUser[t.UserID] = append(byUser[t.UserID], t)
+		}
+	}
+
+	for userID, userTweets := range byUser {
+		followers, err := b.dbConn.GetFollowers(ctx, userID)
+		if err != nil {
+			log.Errorf("When retrieving followers of user %s: %s", userID, err)
+			continue
+		}
+		if len(followers) == 0 {
+			continue
+		}
+		user, err := b.dbConn.GetFullUserByURL(ctx, userTweets[0].URL)
+		if err != nil {
+			log.Errorf("When retrieving user %s for delivery: %s", userID, err)
+			continue
+		}
+		// Only the first user registered with a nickname has an actor.
+		owner, err := b.dbConn.GetUserByNick(ctx, user.Nick)
+		if err != nil || owner.ID != userID {
+			continue
+		}
+
+		for _, t := range userTweets {
+			activity := b.create(user.Nick, t)
+			for _, f := range followers {
+				b.deliverer.Enqueue(activitypub.Delivery{Inbox: f.Inbox, KeyID: b.keyID(user.Nick), Activity: activity})
+			}
+		}
+	}
+}
+
+func apResponseWrite(w http.ResponseWriter, contentType string, body interface{}, statusCode int) {
+	w.Header().Set("Content-Type", contentType)
+	w.WriteHeader(statusCode)
+	if err := json.NewEncoder(w).Encode(body); err != nil {
+		log.Error(err)
+	}
+}
+
+// apUser retrieves the user whose actor is being requested, responding with an error if there isn't one.
+func (b *apBridge) apUser(w http.ResponseWriter, r *http.Request) (*registry.User, bool) {
+	nick := mux.Vars(r)["nick"]
+	user, err := b.dbConn.GetUserByNick(r.Context(), nick)
+	if errors.Is(err, sql.ErrNoRows) {
+		errorWrite(w, r, APIFormatJSON, http.StatusNotFound, "")
+		return nil, false
+	}
+	if err != nil {
+		reqLog(r).Errorf("When retrieving user with nick %s: %s", nick, err)
+		errorWrite(w, r, APIFormatJSON, http.StatusInternalServerError, "")
+		return nil, false
+	}
+
+	return user, true
+}
+
+func (b *apBridge) webFingerHandler(w http.ResponseWriter, r *http.Request) {
+	resource := r.URL.Query().Get("resource")
+	nick, host, ok := strings.Cut(strings.TrimPrefix(resource, "acct:"), "@")
+	if !strings.HasPrefix(resource, "acct:") || !ok || host != b.host {
+		errorWrite(w, r, APIFormatJSON, http.StatusNotFound, "")
+		return
+	}
+	if _, err := b.dbConn.GetUserByNick(r.Context(), nick); err != nil {
+		if !errors.Is(err, sql.ErrNoRows) {
+			reqLog(r).Errorf("When retrieving user with nick %s: %s", nick, err)
+		}
+		errorWrite(w, r, APIFormatJSON, http.StatusNotFound, "")
+		return
+	}
+
+	actorURL := b.actorURL(nick)
+	wf := activitypub.WebFinger{
+		Subject: resource,
+		Aliases: []string{actorURL},
+		Links: []activitypub.WebFingerLink{
+			{Rel: "self", Type: activitypub.ContentType, Href: actorURL},
+		},
+	}
+	apResponseWrite(w, "application/jrd+json", wf, http.StatusOK)
+}
+
+func (b *apBridge) actorHandler(w http.ResponseWriter, r *http.Request) {
+	user, ok := b.apUser(w, r)
+	if !ok {
+		return
+	}
+
+	actorURL := b.actorURL(user.Nick)
+	actor := activitypub.Actor{
+		Context:           activitypub.Context,
+		ID:                actorURL,
+		Type:              "Service",
+		PreferredUsername: user.Nick,
+		Name:              user.Nick,
+		Summary:           fmt.Sprintf("twtxt feed at %s, mirrored by %s", user.URL, b.siteName),
+		URL:               user.URL,
+		Inbox:             actorURL + "/inbox",
+		Outbox:            actorURL + "/outbox",
+		Followers:         actorURL + "/followers",
+		PublicKey: activitypub.PublicKey{
+			ID:           b.keyID(user.Nick),
+			Owner:        actorURL,
+			PublicKeyPem: b.keyPEM,
+		},
+	}
+	apResponseWrite(w, activitypub.ContentType, actor, http.StatusOK)
+}
+
+func (b *apBridge) outboxHandler(w http.ResponseWriter, r *http.Request) {
+	user, ok := b.apUser(w, r)
+	if !ok {
+		return
+	}
+
+	tweets, err := b.dbConn.GetUserTweets(r.Context(), user.ID, apOutboxSize)
+	if err != nil {
+		reqLog(r).Errorf("When retrieving tweets of user %s for outbox: %s", user.ID, err)
+		errorWrite(w, r, APIFormatJSON, http.StatusInternalServerError, "")
+		return
+	}
+	items := make([]interface{}, 0, len(tweets))
+	for _, t := range tweets {
+		items = append(items, b.create(user.Nick, t))
+	}
+
+	outbox := activitypub.OrderedCollection{
+		Context:      activitypub.Context,
+		ID:           b.actorURL(user.Nick) + "/outbox",
+		Type:         "OrderedCollection",
+		TotalItems:   len(items),
+		OrderedItems: items,
+	}
+	apResponseWrite(w, activitypub.ContentType, outbox, http.StatusOK)
+}
+
+// followersHandler only gives the number of followers, not who they are.
+func (b *apBridge) followersHandler(w http.ResponseWriter, r *http.Request) {
+	user, ok := b.apUser(w, r)
+	if !ok {
+		return
+	}
+
+	followers, err := b.dbConn.GetFollowers(r.Context(), user.ID)
+	if err != nil {
+		reqLog(r).Errorf("When retrieving followers of user %s: %s", user.ID, err)
+		errorWrite(w, r, APIFormatJSON, http.StatusInternalServerError, "")
+		return
+	}
+
+	collection := activitypub.OrderedCollection{
+		Context:    activitypub.Context,
+		ID:         b.actorURL(user.Nick) + "/followers",
+		Type:       "OrderedCollection",
+		TotalItems: len(followers),
+	}
+	apResponseWrite(w, activitypub.ContentType, collection, http.StatusOK)
+}
+
+func (b *apBridge) noteHandler(w http.ResponseWriter, r *http.Request) {
+	user, ok := b.apUser(w, r)
+	if !ok {
+		return
+	}
+
+	tweets, err := b.dbConn.GetTweetsByID(r.Context(), []string{mux.Vars(r)["id"]})
+	if err != nil {
+		reqLog(r).Errorf("When retrieving tweet %s: %s", mux.Vars(r)["id"], err)
+		errorWrite(w, r, APIFormatJSON, http.StatusInternalServerError, "")
+		return
+	}
+	if len(tweets) != 1 || tweets[0].UserID != user.ID || tweets[0].Hidden != registry.StatusVisible {
+		errorWrite(w, r, APIFormatJSON, http.StatusNotFound, "")
+		return
+	}
+
+	note := b.note(user.Nick, tweets[0])
+	note.Context = activitypub.Context
+	apResponseWrite(w, activitypub.ContentType, note, http.StatusOK)
+}
+
+// inboxHandler accepts signed Follow activities and Undo of them. Anything else is acknowledged and ignored.
+func (b *apBridge) inboxHandler(w http.ResponseWriter, r *http.Request) {
+	user, ok := b.apUser(w, r)
+	if !ok {
+		return
+	}
+	ctx := r.Context()
+
+	body, err := io.ReadAll(io.LimitReader(r.Body, apMaxInboxBody+1))
+	if err != nil || len(body) > apMaxInboxBody {
+		errorWrite(w, r, APIFormatJSON, http.StatusBadRequest, "")
+		return
+	}
+	activity := activitypub.Activity{}
+	if err := json.Unmarshal(body, &activity); err != nil || activity.Actor == "" {
+		errorWrite(w, r, APIFormatJSON, http.StatusBadRequest, "")
+		return
+	}
+
+	var sender *activitypub.Actor
+	_, err = activitypub.VerifyRequest(r, body, func(keyID string) (*rsa.PublicKey, error) {
+		actorURL, _, _ := strings.Cut(keyID, "#")
+		actor, err := activitypub.FetchActor(b.client, actorURL, b.keyID(user.Nick), b.key)
+		if err != nil {
+			return nil, err
+		}
+		if actor.PublicKey.ID != keyID {
+			return nil, fmt.Errorf("actor %s doesn't have key %s", actor.ID, keyID)
+		}
+		sender = actor
+		return activitypub.ParsePublicKeyPEM(actor.PublicKey.PublicKeyPem)
+	})
+	if err != nil {
+		reqLog(r).Debugf("Rejected activity for %s from %s: %s", user.Nick, activity.Actor, err)
+		errorWrite(w, r, APIFormatJSON, http.StatusUnauthorized, "")
+		return
+	}
+	if sender.ID != activity.Actor {
+		errorWrite(w, r, APIFormatJSON, http.StatusForbidden, "")
+		return
+	}
+
+	actorURL := b.actorURL(user.Nick)
+	switch activity.Type {
+	case "Follow":
+		if objectID(activity.Object) != actorURL {
+			errorWrite(w, r, APIFormatJSON, http.StatusBadRequest, "")
+			return
+		}
+		if err := b.dbConn.AddFollower(ctx, user.ID, sender.ID, sender.Inbox); err != nil {
+			reqLog(r).Errorf("When adding follower of %s: %s", user.Nick, err)
+			errorWrite(w, r, APIFormatJSON, http.StatusInternalServerError, "")
+			return
+		}
+		follow := activity
+		follow.Context = nil
+		accept := activitypub.Activity{
+			Context: activitypub.Context,
+			ID:      activitypub.NewID(actorURL),
+			Type:    "Accept",
+			Actor:   actorURL,
+			Object:  follow,
+		}
+		b.deliverer.Enqueue(activitypub.Delivery{Inbox: sender.Inbox, KeyID: b.keyID(user.Nick), Activity: accept})
+
+	case "Undo":
+		undone, ok := activity.Object.(map[string]interface{})
+		if ok && undone["type"] == "Follow" {
+			err := b.dbConn.RemoveFollower(ctx, user.ID, sender.ID)
+			if err != nil && !errors.Is(err, sql.ErrNoRows) {
+				reqLog(r).Errorf("When removing follower of %s: %s", user.Nick, err)
+				errorWrite(w, r, APIFormatJSON, http.StatusInternalServerError, "")
+				return
+			}
+		}
+	}
+
+	w.WriteHeader(http.StatusAccepted)
+}
+
+// objectID returns the ID of an activity's object, which may be given as a bare ID or an embedded object.
+func objectID(object interface{}) string {
+	switch o := object.(type) {
+	case string:
+		return o
+	case map[string]interface{}:
+		id, _ := o["id"].(string)
+		return id
+	default:
+		return ""
+	}
+}
+
+func setUpActivityPubRoutes(r *mux.Router, b *apBridge) {
+	r.HandleFunc("/.well-known/webfinger", b.webFingerHandler).Methods(http.MethodGet, http.MethodHead)
+	r.HandleFunc("/ap/users/{nick}", b.actorHandler).Methods(http.MethodGet, http.MethodHead)
+	r.HandleFunc("/ap/users/{nick}/inbox", b.inboxHandler).Methods(http.MethodPost)
+	r.HandleFunc("/ap/users/{nick}/outbox", b.outboxHandler).Methods(http.MethodGet, http.MethodHead)
+	r.HandleFunc("/ap/users/{nick}/followers", b.followersHandler).Methods(http.MethodGet, http.MethodHead)
+	r.HandleFunc("/ap/users/{nick}/notes/{id:[0-9]+}", b.noteHandler).Methods(http.MethodGet, http.MethodHead)
+}
@@ -0,0 +1,94 @@
+package main
+
+/*
+Copyright 2021 G. Benjamin Morrison
+
+This file is part of getwtxt-ng.
+
+getwtxt-ng is free software: you can redistribute it and/or modify
+it under the terms of the GNU Affero General Public License as
+published by the Free Software Foundation, either version 3 of the
+License, or (at your option) any later version.
+
+getwtxt-ng is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU Affero General Public License for more details.
+
+You should have received a copy of the GNU Affero General Public License
+along with getwtxt-ng.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gbmor/getwtxt-ng/activitypub"
+	"github.com/gbmor/getwtxt-ng/registry"
+)
+
+func TestAPBridge_TweetsInserted(t *testing.T) {
+	ctx := context.Background()
+	var mu sync.Mutex
+	delivered := make([]activitypub.Activity, 0)
+	inbox := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		activity := activitypub.Activity{}
+		if err := json.NewDecoder(r.Body).Decode(&activity); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		mu.Lock()
+		delivered = append(delivered, activity)
+		mu.Unlock()
+		w.WriteHeader(http.StatusAccepted)
+	}))
+	t.Cleanup(inbox.Close)
+
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	conf := &Config{
+		ServerConfig:   ServerConfig{ActivityPubKey: key},
+		InstanceConfig: InstanceConfig{SiteURL: "https://registry.example"},
+	}
+	dbConn := getFederationDB(t)
+	b, err := newAPBridge(conf, dbConn)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	dbConn.Hooks.TweetsInserted = b.tweetsInserted
+
+	user := registry.User{Nick: "foo", URL: "https://foo.example/twtxt.txt", PasscodeHash: []byte("not a real hash"), DateTimeAdded: time.Now().UTC()}
+	if err := dbConn.InsertUser(ctx, &user); err != nil {
+		t.Fatal(err.Error())
+	}
+	if err := dbConn.AddFollower(ctx, user.ID, "https://social.example/users/bar", inbox.URL); err != nil {
+		t.Fatal(err.Error())
+	}
+
+	// Tweets parsed from a feed carry only the ID of their author.
+	tweets := []registry.Tweet{{UserID: user.ID, DateTime: time.Now().UTC().Truncate(time.Second), Body: "hello fediverse"}}
+	if _, err := dbConn.InsertTweets(ctx, tweets); err != nil {
+		t.Fatal(err.Error())
+	}
+	b.Close()
+
+	if len(delivered) != 1 {
+		t.Fatalf("Expected the new tweet to be delivered to the follower, got %+v", delivered)
+	}
+	if delivered[0].Type != "Create" || delivered[0].Actor != "https://registry.example/ap/users/foo" {
+		t.Errorf("Expected a Create from foo's actor, got %+v", delivered[0])
+	}
+	note, ok := delivered[0].Object.(map[string]interface{})
+	if !ok || note["content"] != "<p>hello fediverse</p>" || note["url"] != user.URL {
+		t.Errorf("Expected the note to hold the tweet and link to its feed, got %+v", delivered[0].Object)
+	}
+}
@@ -0,0 +1,135 @@
+package main
+
+/*
+Copyright 2021 G. Benjamin Morrison
+
+This file is part of getwtxt-ng.
+
+getwtxt-ng is free software: you can redistribute it and/or modify
+it under the terms of the GNU Affero General Public License as
+published by the Free Software Foundation, either version 3 of the
+License, or (at your option) any later version.
+
+getwtxt-ng is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU Affero General Public License for more details.
+
+You should have received a copy of the GNU Affero General Public License
+along with getwtxt-ng.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"net"
+	"net/http"
+	"net/http/pprof"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/gorilla/handlers"
+	"github.com/gorilla/mux"
+	log "github.com/sirupsen/logrus"
+)
+
+// adminSocketPrefix marks an admin_listen that's the path of a unix socket rather than an address.
+const adminSocketPrefix = "unix:"
+
+// parseAdminListen checks admin_listen is either unix:/path/to/socket or a host:port other than the public one.
+func (sc *ServerConfig) parseAdminListen() error {
+	sc.AdminListen = strings.TrimSpace(sc.AdminListen)
+	if sc.AdminListen == "" {
+		return nil
+	}
+	network, address := adminListenAddr(sc.AdminListen)
+	if network == "unix" {
+		if address == "" {
+			return errors.New("admin_listen needs the path of the socket after unix:")
+		}
+		return nil
+	}
+
+	host, port, err := net.SplitHostPort(address)
+	if err != nil {
+		return fmt.Errorf("admin_listen must be host:port or unix:/path/to/socket: %w", err)
+	}
+	if port == sc.Port && (host == sc.IP || host == "" || sc.IP == "") {
+		return errors.New("admin_listen can't be the address the public API is served on")
+	}
+
+	return nil
+}
+
+// adminListenAddr splits admin_listen into the network and address to listen on.
+func adminListenAddr(listen string) (string, string) {
+	if strings.HasPrefix(listen, adminSocketPrefix) {
+		return "unix", strings.TrimPrefix(listen, adminSocketPrefix)
+	}
+	return "tcp", listen
+}
+
+// setUpPprofRoutes serves the runtime profiles under /debug/pprof. They say a lot about the server,
+// so they're only served on the admin listener.
+func setUpPprofRoutes(r *mux.Router) {
+	r.HandleFunc("/debug/pprof/cmdline", pprof.Cmdline)
+	r.HandleFunc("/debug/pprof/profile", pprof.Profile)
+	r.HandleFunc("/debug/pprof/symbol", pprof.Symbol)
+	r.HandleFunc("/debug/pprof/trace", pprof.Trace)
+	r.PathPrefix("/debug/pprof/").HandlerFunc(pprof.Index)
+}
+
+// listenAdmin opens the admin listener. A socket left behind by an earlier run is replaced, and
+// a new one may only be used by its owner and group.
+func listenAdmin(listen string) (net.Listener, error) {
+	network, address := adminListenAddr(listen)
+	if network == "unix" {
+		if info, err := os.Lstat(address); err == nil && info.Mode()&fs.ModeSocket != 0 {
+			if err := os.Remove(address); err != nil {
+				return nil, fmt.Errorf("when removing old admin socket at %s: %w", address, err)
+			}
+		}
+	}
+
+	l, err := net.Listen(network, address)
+	if err != nil {
+		return nil, fmt.Errorf("when opening admin listener at %s: %w", listen, err)
+	}
+	if network == "unix" {
+		if err := os.Chmod(address, 0660); err != nil {
+			_ = l.Close()
+			return nil, fmt.Errorf("when restricting access to admin socket at %s: %w", address, err)
+		}
+	}
+
+	return l, nil
+}
+
+// serveAdmin serves the admin API and profiles on the admin listener in the background. Requests are
+// logged with the public ones, but aren't rate limited, timed out, or turned away in maintenance mode,
+// and exports and profiles may take as long as they need to be written.
+func serveAdmin(conf *Config, handler http.Handler) error {
+	conf.mu.RLock()
+	listen := conf.ServerConfig.AdminListen
+	requestLog := conf.ServerConfig.RequestLogFd
+	conf.mu.RUnlock()
+
+	l, err := listenAdmin(listen)
+	if err != nil {
+		return err
+	}
+	s := &http.Server{
+		Handler:     withRequestID(handlers.CombinedLoggingHandler(requestLog, withRecovery(handler))),
+		ReadTimeout: 10 * time.Second,
+	}
+	go func() {
+		log.Infof("Serving the admin API at %s", listen)
+		if err := s.Serve(l); err != nil {
+			log.Errorf("Admin listener stopped: %s", err)
+		}
+	}()
+
+	return nil
+}
@@ -0,0 +1,122 @@
+package main
+
+/*
+Copyright 2021 G. Benjamin Morrison
+
+This file is part of getwtxt-ng.
+
+getwtxt-ng is free software: you can redistribute it and/or modify
+it under the terms of the GNU Affero General Public License as
+published by the Free Software Foundation, either version 3 of the
+License, or (at your option) any later version.
+
+getwtxt-ng is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU Affero General Public License for more details.
+
+You should have received a copy of the GNU Affero General Public License
+along with getwtxt-ng.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/gorilla/mux"
+)
+
+func TestServerConfig_parseAdminListen(t *testing.T) {
+	tests := []struct {
+		listen  string
+		wantErr bool
+	}{
+		{"", false},
+		{"127.0.0.1:9002", false},
+		{"localhost:9001", false},
+		{"unix:/run/getwtxt-ng/admin.sock", false},
+		{"unix:", true},
+		{"9002", true},
+		{"127.0.0.1:9001", true},
+		{":9001", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.listen, func(t *testing.T) {
+			sc := ServerConfig{IP: "127.0.0.1", Port: "9001", AdminListen: tt.listen}
+			if err := sc.parseAdminListen(); (err != nil) != tt.wantErr {
+				t.Errorf("Expected error: %t, got %v", tt.wantErr, err)
+			}
+		})
+	}
+}
+
+func TestAdminListener(t *testing.T) {
+	dbConn := getFederationDB(t)
+	conf := &Config{ServerConfig: ServerConfig{AdminListen: "127.0.0.1:0"}}
+	r := mux.NewRouter()
+	setUpRoutes(r, conf, dbConn)
+	for _, req := range []*http.Request{
+		httptest.NewRequest(http.MethodGet, "/api/admin/filtered", nil),
+		httptest.NewRequest(http.MethodPost, "/api/plain/users/bulk", nil),
+		httptest.NewRequest(http.MethodGet, "/api/plain/users/bulk/3f9a0c1d7e2b4a68", nil),
+	} {
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		if w.Code != http.StatusNotFound {
+			t.Errorf("Expected %s to be left off the public routes, got %d", req.URL.Path, w.Code)
+		}
+	}
+
+	sock := filepath.Join(t.TempDir(), "admin.sock")
+	for i := 0; i < 2; i++ {
+		// The second time around, the socket left by the first is replaced.
+		l, err := listenAdmin(adminSocketPrefix + sock)
+		if err != nil {
+			t.Fatal(err.Error())
+		}
+		info, err := os.Stat(sock)
+		if err != nil {
+			t.Fatal(err.Error())
+		}
+		if perm := info.Mode().Perm(); perm != 0660 {
+			t.Errorf("Expected the socket to be 0660, got %o", perm)
+		}
+		if ul, ok := l.(*net.UnixListener); ok {
+			ul.SetUnlinkOnClose(false)
+		}
+		_ = l.Close()
+	}
+
+	ar := mux.NewRouter()
+	setUpPprofRoutes(ar)
+	l, err := listenAdmin(adminSocketPrefix + sock)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	srv := &http.Server{Handler: ar}
+	go func() {
+		_ = srv.Serve(l)
+	}()
+	t.Cleanup(func() {
+		_ = srv.Close()
+	})
+	client := &http.Client{Transport: &http.Transport{
+		DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+			return (&net.Dialer{}).DialContext(ctx, "unix", sock)
+		},
+	}}
+	resp, err := client.Get("http://admin/debug/pprof/")
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	_ = resp.Body.Close()
+	if resp.StatusCode != http.StatusOK || !strings.Contains(resp.Header.Get("Content-Type"), "text/html") {
+		t.Errorf("Expected the profile index over the socket, got %d", resp.StatusCode)
+	}
+}
@@ -0,0 +1,248 @@
+package main
+
+/*
+Copyright 2021 G. Benjamin Morrison
+
+This file is part of getwtxt-ng.
+
+getwtxt-ng is free software: you can redistribute it and/or modify
+it under the terms of the GNU Affero General Public License as
+published by the Free Software Foundation, either version 3 of the
+License, or (at your option) any later version.
+
+getwtxt-ng is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU Affero General Public License for more details.
+
+You should have received a copy of the GNU Affero General Public License
+along with getwtxt-ng.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+
+	"github.com/gbmor/getwtxt-ng/registry"
+)
+
+// Registrations waiting on an admin are announced one at a time, as they're rare even during a flood of spam.
+const approvalQueueSize = 256
+
+// checkApprovalNotifyURL makes sure the URL registrations pending approval are announced to, if any, is http(s).
+func (sc *ServerConfig) checkApprovalNotifyURL() error {
+	notifyURL := strings.TrimSpace(sc.ApprovalNotifyURL)
+	if notifyURL == "" {
+		return nil
+	}
+	if !strings.HasPrefix(notifyURL, "https://") && !strings.HasPrefix(notifyURL, "http://") {
+		return fmt.Errorf("approval_notify_url must be an http:// or https:// URL: %s", notifyURL)
+	}
+	sc.ApprovalNotifyURL = notifyURL
+	return nil
+}
+
+// requireApproval reports whether new registrations wait for an admin before being listed.
+func requireApproval(conf *Config) bool {
+	conf.mu.RLock()
+	defer conf.mu.RUnlock()
+	return conf.ServerConfig.RequireApproval
+}
+
+// approvalEvent is what's posted to approval_notify_url when a registration is waiting for an admin.
+type approvalEvent struct {
+	Event string        `json:"event"`
+	User  registry.User `json:"user"`
+}
+
+// approvalNotifier announces registrations waiting for approval to approval_notify_url, so admins
+// don't have to poll the queue. The URL is read when each is sent, so it can be changed on reload.
+type approvalNotifier struct {
+	conf   *Config
+	client *http.Client
+	queue  chan registry.User
+	wg     sync.WaitGroup
+
+	mu     sync.Mutex
+	closed bool
+}
+
+func newApprovalNotifier(conf *Config) *approvalNotifier {
+	n := &approvalNotifier{
+		conf:   conf,
+		client: &http.Client{Timeout: 10 * time.Second},
+		queue:  make(chan registry.User, approvalQueueSize),
+	}
+
+	n.wg.Add(1)
+	go func() {
+		defer n.wg.Done()
+		for u := range n.queue {
+			if err := n.notify(u); err != nil {
+				log.Errorf("Couldn't announce registration of %s pending approval: %s", u.URL, err)
+			}
+		}
+	}()
+
+	return n
+}
+
+func (n *approvalNotifier) notify(u registry.User) error {
+	n.conf.mu.RLock()
+	notifyURL := n.conf.ServerConfig.ApprovalNotifyURL
+	n.conf.mu.RUnlock()
+	if notifyURL == "" {
+		return nil
+	}
+
+	body, err := json.Marshal(approvalEvent{Event: "registration_pending", User: u})
+	if err != nil {
+		return fmt.Errorf("when encoding event: %w", err)
+	}
+	req, err := http.NewRequest(http.MethodPost, notifyURL, bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("when creating request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := n.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		_ = resp.Body.Close()
+	}()
+	_, _ = io.Copy(io.Discard, io.LimitReader(resp.Body, 1<<16))
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("got status code %d", resp.StatusCode)
+	}
+
+	return nil
+}
+
+// Close stops accepting registrations and waits for the queued ones to be announced.
+func (n *approvalNotifier) Close() {
+	n.mu.Lock()
+	if !n.closed {
+		n.closed = true
+		close(n.queue)
+	}
+	n.mu.Unlock()
+	n.wg.Wait()
+}
+
+// usersInserted logs and queues the registrations waiting for approval. It's used as part of the UsersInserted hook.
+func (n *approvalNotifier) usersInserted(_ context.Context, users []registry.User) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	if n.closed {
+		return
+	}
+
+	for _, u := range users {
+		if u.Status != registry.UserStatusPendingApproval {
+			continue
+		}
+		log.Infof("Registration of %s %s is waiting for approval", u.Nick, u.URL)
+		select {
+		case n.queue <- u:
+		default:
+			log.Errorf("Approval notification queue full, not announcing %s", u.URL)
+		}
+	}
+}
+
+// approvalsHandler lists the registrations waiting for approval, oldest first.
+func approvalsHandler(w http.ResponseWriter, r *http.Request, conf *Config, dbConn *registry.DB) {
+	if !adminAuthorized(w, r, conf) {
+		return
+	}
+
+	users, err := dbConn.GetPendingApprovals(r.Context())
+	if err != nil {
+		reqLog(r).Errorf("When retrieving registrations pending approval: %s", err)
+		code, message := queryErrorStatus(r, err)
+		errorResponseWrite(w, r, APIFormatJSON, code, MessageResponse{Message: message})
+		return
+	}
+	jsonResponseWrite(w, r, users, http.StatusOK)
+}
+
+// pendingUser retrieves the user at ?url= if their registration is waiting for approval, writing the error response if not.
+func pendingUser(w http.ResponseWriter, r *http.Request, dbConn *registry.DB) *registry.User {
+	userURL := strings.TrimSpace(r.URL.Query().Get("url"))
+	if userURL == "" {
+		errorWrite(w, r, APIFormatJSON, http.StatusBadRequest, "Please provide the URL of a registration")
+		return nil
+	}
+	user, err := dbConn.GetFullUserByURL(r.Context(), userURL)
+	if err != nil {
+		if errors.Is(err, registry.ErrUserNotFound) || errors.Is(err, sql.ErrNoRows) {
+			errorWrite(w, r, APIFormatJSON, http.StatusNotFound, "No user with that URL")
+			return nil
+		}
+		reqLog(r).Errorf("When retrieving user %s: %s", userURL, err)
+		errorWrite(w, r, APIFormatJSON, http.StatusInternalServerError, "")
+		return nil
+	}
+	if user.Status != registry.UserStatusPendingApproval {
+		errorWrite(w, r, APIFormatJSON, http.StatusConflict, "That user isn't waiting for approval")
+		return nil
+	}
+	return user
+}
+
+// approveUserHandler approves the registration at ?url=, making the user and the tweets synced while it waited public.
+func approveUserHandler(w http.ResponseWriter, r *http.Request, conf *Config, dbConn *registry.DB) {
+	if !adminAuthorized(w, r, conf) {
+		return
+	}
+	user := pendingUser(w, r, dbConn)
+	if user == nil {
+		return
+	}
+
+	if err := dbConn.ApproveUser(r.Context(), user); err != nil {
+		if errors.Is(err, registry.ErrUserNotPendingApproval) {
+			errorWrite(w, r, APIFormatJSON, http.StatusConflict, "That user isn't waiting for approval")
+			return
+		}
+		reqLog(r).Errorf("When approving user %s: %s", user.URL, err)
+		errorWrite(w, r, APIFormatJSON, http.StatusInternalServerError, "")
+		return
+	}
+	reqLog(r).Infof("Approved registration of %s %s", user.Nick, user.URL)
+	jsonResponseWrite(w, r, MessageResponse{Message: "Approved"}, http.StatusOK)
+}
+
+// rejectUserHandler deletes the registration at ?url= and everything synced for it while it waited.
+// The feed can register again, so a ban is the way to keep it out.
+func rejectUserHandler(w http.ResponseWriter, r *http.Request, conf *Config, dbConn *registry.DB) {
+	if !adminAuthorized(w, r, conf) {
+		return
+	}
+	user := pendingUser(w, r, dbConn)
+	if user == nil {
+		return
+	}
+
+	deleted, err := dbConn.DeleteUser(r.Context(), user)
+	if err != nil {
+		reqLog(r).Errorf("When rejecting user %s: %s", user.URL, err)
+		errorWrite(w, r, APIFormatJSON, http.StatusInternalServerError, "")
+		return
+	}
+	reqLog(r).Infof("Rejected registration of %s %s", user.Nick, user.URL)
+	jsonResponseWrite(w, r, MessageResponse{Message: "Rejected", TweetsDeleted: deleted}, http.StatusOK)
+}
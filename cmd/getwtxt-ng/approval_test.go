@@ -0,0 +1,180 @@
+package main
+
+/*
+Copyright 2021 G. Benjamin Morrison
+
+This file is part of getwtxt-ng.
+
+getwtxt-ng is free software: you can redistribute it and/or modify
+it under the terms of the GNU Affero General Public License as
+published by the Free Software Foundation, either version 3 of the
+License, or (at your option) any later version.
+
+getwtxt-ng is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU Affero General Public License for more details.
+
+You should have received a copy of the GNU Affero General Public License
+along with getwtxt-ng.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/mux"
+
+	"github.com/gbmor/getwtxt-ng/common"
+	"github.com/gbmor/getwtxt-ng/registry"
+)
+
+func TestApprovalQueue(t *testing.T) {
+	ctx := context.Background()
+	dbConn := getFederationDB(t)
+	hash, err := common.HashPass("hunter2")
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	conf := &Config{ServerConfig: ServerConfig{AdminPassword: string(hash), RequireApproval: true}}
+
+	feed := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = fmt.Fprint(w, "2022-10-19T00:00:00Z\tbuy cheap things\n")
+	}))
+	defer feed.Close()
+
+	r := mux.NewRouter()
+	setUpRoutes(r, conf, dbConn)
+	serve := func(method, path, pass, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, path, strings.NewReader(body))
+		if pass != "" {
+			req.Header.Set("X-Auth", pass)
+		}
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		return w
+	}
+
+	plainURL := feed.URL + "/plain/twtxt.txt"
+	form := url.Values{"nickname": {"foo"}, "url": {plainURL}}
+	w := serve(http.MethodPost, "/api/plain/users?"+form.Encode(), "", "")
+	if w.Code != http.StatusAccepted || !strings.Contains(w.Body.String(), "administrator approves") {
+		t.Fatalf("Expected %d saying the feed waits for approval, got %d: %s", http.StatusAccepted, w.Code, w.Body.String())
+	}
+
+	// Asking to be active doesn't skip the queue.
+	jsonURL := feed.URL + "/json/twtxt.txt"
+	w = serve(http.MethodPost, "/api/json/users", "", `{"nickname":"bar","url":"`+jsonURL+`","status":"active"}`)
+	msg := MessageResponse{}
+	if err := json.NewDecoder(w.Body).Decode(&msg); err != nil {
+		t.Fatal(err.Error())
+	}
+	if w.Code != http.StatusAccepted || !msg.PendingApproval || msg.Passcode == "" {
+		t.Fatalf("Expected %d with pending_approval, got %d: %+v", http.StatusAccepted, w.Code, msg)
+	}
+
+	users, err := dbConn.GetUsers(ctx, 1, 20)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	if len(users) != 0 {
+		t.Errorf("Expected no users listed while they wait, got %+v", users)
+	}
+	tweets, err := dbConn.GetTweets(ctx, 1, 20, registry.StatusVisible)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	if len(tweets) != 0 {
+		t.Errorf("Expected no tweets listed while their feeds wait, got %+v", tweets)
+	}
+
+	if w := serve(http.MethodGet, "/api/admin/approvals", "wrong", ""); w.Code != http.StatusForbidden {
+		t.Errorf("Expected %d with the wrong password, got %d", http.StatusForbidden, w.Code)
+	}
+	w = serve(http.MethodGet, "/api/admin/approvals", "hunter2", "")
+	pending := make([]registry.User, 0)
+	if err := json.NewDecoder(w.Body).Decode(&pending); err != nil {
+		t.Fatal(err.Error())
+	}
+	if len(pending) != 2 || pending[0].URL != plainURL || pending[1].URL != jsonURL {
+		t.Fatalf("Expected both registrations waiting, oldest first, got %+v", pending)
+	}
+
+	if w := serve(http.MethodPost, "/api/admin/approvals/approve?url="+url.QueryEscape(plainURL), "hunter2", ""); w.Code != http.StatusOK {
+		t.Errorf("Expected %d approving, got %d: %s", http.StatusOK, w.Code, w.Body.String())
+	}
+	if w := serve(http.MethodPost, "/api/admin/approvals/approve?url="+url.QueryEscape(plainURL), "hunter2", ""); w.Code != http.StatusConflict {
+		t.Errorf("Expected %d approving again, got %d", http.StatusConflict, w.Code)
+	}
+	if w := serve(http.MethodPost, "/api/admin/approvals/reject?url="+url.QueryEscape(jsonURL), "hunter2", ""); w.Code != http.StatusOK {
+		t.Errorf("Expected %d rejecting, got %d: %s", http.StatusOK, w.Code, w.Body.String())
+	}
+	if w := serve(http.MethodPost, "/api/admin/approvals/reject?url="+url.QueryEscape(jsonURL), "hunter2", ""); w.Code != http.StatusNotFound {
+		t.Errorf("Expected %d rejecting again, got %d", http.StatusNotFound, w.Code)
+	}
+
+	users, err = dbConn.GetUsers(ctx, 1, 20)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	if len(users) != 1 || users[0].URL != plainURL {
+		t.Errorf("Expected only the approved user listed, got %+v", users)
+	}
+	tweets, err = dbConn.GetTweets(ctx, 1, 20, registry.StatusVisible)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	if len(tweets) != 1 || tweets[0].URL != plainURL {
+		t.Errorf("Expected the approved user's tweet listed, got %+v", tweets)
+	}
+}
+
+func TestApprovalNotifier(t *testing.T) {
+	events := make(chan approvalEvent, 2)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		event := approvalEvent{}
+		if err := json.NewDecoder(r.Body).Decode(&event); err != nil {
+			t.Error(err.Error())
+		}
+		events <- event
+	}))
+	defer srv.Close()
+
+	conf := &Config{ServerConfig: ServerConfig{ApprovalNotifyURL: srv.URL}}
+	n := newApprovalNotifier(conf)
+	n.usersInserted(context.Background(), []registry.User{
+		{Nick: "foo", URL: "https://foo.example/twtxt.txt", Status: registry.UserStatusActive},
+		{Nick: "bar", URL: "https://bar.example/twtxt.txt", Status: registry.UserStatusPendingApproval},
+	})
+	n.Close()
+
+	select {
+	case event := <-events:
+		if event.Event != "registration_pending" || event.User.URL != "https://bar.example/twtxt.txt" {
+			t.Errorf("Expected the pending registration announced, got %+v", event)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Timed out waiting for the announcement")
+	}
+	if len(events) != 0 {
+		t.Errorf("Expected only the pending registration announced, got %d more", len(events))
+	}
+}
+
+func TestCheckApprovalNotifyURL(t *testing.T) {
+	sc := ServerConfig{ApprovalNotifyURL: " https://hooks.example/new "}
+	if err := sc.checkApprovalNotifyURL(); err != nil || sc.ApprovalNotifyURL != "https://hooks.example/new" {
+		t.Errorf("Expected a trimmed https URL to be accepted, got %q, %v", sc.ApprovalNotifyURL, err)
+	}
+	sc = ServerConfig{ApprovalNotifyURL: "ftp://hooks.example/new"}
+	if err := sc.checkApprovalNotifyURL(); err == nil {
+		t.Error("Expected an ftp URL to be refused")
+	}
+}
@@ -0,0 +1,180 @@
+package main
+
+/*
+Copyright 2021 G. Benjamin Morrison
+
+This file is part of getwtxt-ng.
+
+getwtxt-ng is free software: you can redistribute it and/or modify
+it under the terms of the GNU Affero General Public License as
+published by the Free Software Foundation, either version 3 of the
+License, or (at your option) any later version.
+
+getwtxt-ng is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU Affero General Public License for more details.
+
+You should have received a copy of the GNU Affero General Public License
+along with getwtxt-ng.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"net/http"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/gorilla/mux"
+	log "github.com/sirupsen/logrus"
+	"github.com/throttled/throttled/v2"
+
+	"github.com/gbmor/getwtxt-ng/registry"
+)
+
+// Public archives are for grabbing the whole dataset now and then, so they're rebuilt daily
+// and each address may only download a few an hour.
+const (
+	defaultPublicArchiveInterval  = "24h"
+	defaultPublicArchiveDownloads = 4
+	minPublicArchiveInterval      = time.Hour
+)
+
+// setUpPublicArchiveRoutes serves the latest public archive at /archive, rate limited by address.
+func setUpPublicArchiveRoutes(r *mux.Router, conf *Config) {
+	conf.mu.RLock()
+	perHour := conf.PublicArchive.DownloadsPerHour
+	conf.mu.RUnlock()
+	rl := newHTTPRateLimiter(throttled.RateQuota{
+		MaxRate:  throttled.PerHour(perHour),
+		MaxBurst: perHour - 1,
+	}, &throttled.VaryBy{RemoteAddr: true})
+
+	r.Handle("/archive", rl.RateLimit(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		publicArchiveHandler(w, r, conf)
+	}))).Methods(http.MethodGet, http.MethodHead)
+}
+
+// publicArchiveHandler sends the latest public archive. Range requests are honored, so an interrupted
+// download can be resumed rather than started over.
+func publicArchiveHandler(w http.ResponseWriter, r *http.Request, conf *Config) {
+	conf.mu.RLock()
+	path := conf.PublicArchive.Path
+	conf.mu.RUnlock()
+
+	f, err := os.Open(path)
+	if err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			errorWrite(w, r, APIFormatPlain, http.StatusNotFound, "The archive hasn't been written yet. Try again later.")
+			return
+		}
+		reqLog(r).Errorf("When opening public archive at %s: %s", path, err)
+		errorWrite(w, r, APIFormatPlain, http.StatusInternalServerError, "")
+		return
+	}
+	defer func() {
+		_ = f.Close()
+	}()
+	info, err := f.Stat()
+	if err != nil {
+		reqLog(r).Errorf("When reading public archive at %s: %s", path, err)
+		errorWrite(w, r, APIFormatPlain, http.StatusInternalServerError, "")
+		return
+	}
+
+	modTime := info.ModTime().UTC()
+	w.Header().Set("Content-Type", "application/gzip")
+	w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="getwtxt-ng-archive-%s.tar.gz"`, modTime.Format("20060102")))
+	http.ServeContent(w, r, "", modTime, f)
+}
+
+// InitPublicArchiveTicker writes the public archive in the background, unless the one on disk is
+// recent enough, then again every interval.
+func InitPublicArchiveTicker(conf *Config, dbConn *registry.DB) chan<- struct{} {
+	conf.mu.RLock()
+	path := conf.PublicArchive.Path
+	interval := conf.PublicArchive.Interval
+	conf.mu.RUnlock()
+	tick := time.NewTicker(interval)
+	done := make(chan struct{})
+
+	write := func() {
+		begin := time.Now()
+		if err := writePublicArchive(context.Background(), dbConn, path); err != nil {
+			log.Errorf("Couldn't write public archive: %s", err)
+			return
+		}
+		log.Infof("Wrote public archive to %s in %s", path, time.Since(begin))
+	}
+
+	go func() {
+		if info, err := os.Stat(path); err != nil || time.Since(info.ModTime()) >= interval {
+			write()
+		}
+		for {
+			select {
+			case <-done:
+				tick.Stop()
+				return
+			case <-tick.C:
+				write()
+			}
+		}
+	}()
+
+	return done
+}
+
+// writePublicArchive writes a gzipped tarball of the active users and their visible tweets to path,
+// laid out like the admin export. It's written beside path and moved into place once it's complete,
+// so downloads in progress keep reading the previous one.
+func writePublicArchive(ctx context.Context, dbConn *registry.DB, path string) error {
+	users, err := dbConn.GetAllUsers(ctx)
+	if err != nil {
+		return fmt.Errorf("when retrieving users to archive: %w", err)
+	}
+	active := make([]registry.User, 0, len(users))
+	for _, u := range users {
+		if u.Status == registry.UserStatusActive {
+			active = append(active, u)
+		}
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".*.tmp")
+	if err != nil {
+		return fmt.Errorf("when creating public archive: %w", err)
+	}
+	defer func() {
+		_ = tmp.Close()
+		_ = os.Remove(tmp.Name())
+	}()
+
+	gz := gzip.NewWriter(tmp)
+	tw := tar.NewWriter(gz)
+	if err := writeExportArchive(ctx, tw, dbConn, active, time.Now().UTC()); err != nil {
+		return fmt.Errorf("when writing public archive: %w", err)
+	}
+	if err := tw.Close(); err != nil {
+		return fmt.Errorf("when finishing public archive: %w", err)
+	}
+	if err := gz.Close(); err != nil {
+		return fmt.Errorf("when finishing public archive: %w", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("when finishing public archive: %w", err)
+	}
+	if err := os.Chmod(tmp.Name(), 0644); err != nil {
+		return fmt.Errorf("when setting permissions of public archive: %w", err)
+	}
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		return fmt.Errorf("when moving public archive into place: %w", err)
+	}
+
+	return nil
+}
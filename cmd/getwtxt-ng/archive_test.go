@@ -0,0 +1,112 @@
+package main
+
+/*
+Copyright 2021 G. Benjamin Morrison
+
+This file is part of getwtxt-ng.
+
+getwtxt-ng is free software: you can redistribute it and/or modify
+it under the terms of the GNU Affero General Public License as
+published by the Free Software Foundation, either version 3 of the
+License, or (at your option) any later version.
+
+getwtxt-ng is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU Affero General Public License for more details.
+
+You should have received a copy of the GNU Affero General Public License
+along with getwtxt-ng.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/mux"
+
+	"github.com/gbmor/getwtxt-ng/registry"
+)
+
+func TestPublicArchive(t *testing.T) {
+	ctx := context.Background()
+	dbConn := getFederationDB(t)
+	conf := &Config{PublicArchive: PublicArchive{
+		Path:             filepath.Join(t.TempDir(), "archive.tar.gz"),
+		Interval:         time.Hour,
+		DownloadsPerHour: 2,
+	}}
+	r := mux.NewRouter()
+	setUpPublicArchiveRoutes(r, conf)
+
+	get := func() *httptest.ResponseRecorder {
+		t.Helper()
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/archive", nil))
+		return w
+	}
+
+	if w := get(); w.Code != http.StatusNotFound {
+		t.Errorf("Expected 404 before the archive is written, got %d", w.Code)
+	}
+
+	active := registry.User{Nick: "foo", URL: "https://foo.example/twtxt.txt", PasscodeHash: []byte("hash")}
+	tweets := []registry.Tweet{{DateTime: time.Date(2022, 1, 1, 0, 0, 0, 0, time.UTC), Body: "first"}}
+	if _, err := dbConn.InsertUserWithTweets(ctx, &active, tweets); err != nil {
+		t.Fatal(err.Error())
+	}
+	suspended := registry.User{Nick: "bar", URL: "https://bar.example/twtxt.txt", PasscodeHash: []byte("hash")}
+	if _, err := dbConn.InsertUserWithTweets(ctx, &suspended, tweets); err != nil {
+		t.Fatal(err.Error())
+	}
+	if _, err := dbConn.SetUserStatus(ctx, registry.UserStatusSuspended, suspended.URL); err != nil {
+		t.Fatal(err.Error())
+	}
+	if err := writePublicArchive(ctx, dbConn, conf.PublicArchive.Path); err != nil {
+		t.Fatal(err.Error())
+	}
+
+	w := get()
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected 200, got %d", w.Code)
+	}
+	gz, err := gzip.NewReader(w.Body)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	files := make(map[string]string)
+	tr := tar.NewReader(gz)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatal(err.Error())
+		}
+		contents, err := io.ReadAll(tr)
+		if err != nil {
+			t.Fatal(err.Error())
+		}
+		files[hdr.Name] = string(contents)
+	}
+	index := files[exportArchiveIndex]
+	if !strings.Contains(index, active.URL) || strings.Contains(index, suspended.URL) {
+		t.Errorf("Expected only the active user in the index, got:\n%s", index)
+	}
+	if !strings.HasSuffix(files[exportFeedPath(active)], "\tfirst\n") {
+		t.Errorf("Expected the active user's feed, got:\n%s", files[exportFeedPath(active)])
+	}
+
+	if w := get(); w.Code != http.StatusTooManyRequests {
+		t.Errorf("Expected 429 past downloads_per_hour, got %d", w.Code)
+	}
+}
@@ -0,0 +1,228 @@
+package main
+
+/*
+Copyright 2021 G. Benjamin Morrison
+
+This file is part of getwtxt-ng.
+
+getwtxt-ng is free software: you can redistribute it and/or modify
+it under the terms of the GNU Affero General Public License as
+published by the Free Software Foundation, either version 3 of the
+License, or (at your option) any later version.
+
+getwtxt-ng is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU Affero General Public License for more details.
+
+You should have received a copy of the GNU Affero General Public License
+along with getwtxt-ng.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gorilla/mux"
+	log "github.com/sirupsen/logrus"
+
+	"github.com/gbmor/getwtxt-ng/common"
+	"github.com/gbmor/getwtxt-ng/registry"
+)
+
+// Avatars are kept for a day and may be up to 256 KiB unless configured otherwise.
+// Those that couldn't be fetched aren't tried again for a while, so a broken one isn't requested on every view.
+const (
+	defaultAvatarMaxBytes     = 256 << 10
+	defaultAvatarCacheTTL     = "24h"
+	defaultAvatarCacheEntries = 256
+	avatarFailureTTL          = 10 * time.Minute
+)
+
+// avatarTypes are the image types served. Others, notably SVG, which can carry scripts, are refused.
+var avatarTypes = map[string]bool{
+	"image/png":  true,
+	"image/jpeg": true,
+	"image/gif":  true,
+	"image/webp": true,
+}
+
+// errAvatarRefused is returned for avatars that were fetched but aren't served, because they're too large
+// or not an image.
+var errAvatarRefused = errors.New("avatar refused")
+
+// parse validates the avatar proxy's limits, filling in the defaults.
+func (a *Avatars) parse() error {
+	if a.MaxBytes < 0 {
+		return errors.New("avatars max_bytes can't be negative")
+	}
+	if a.MaxBytes == 0 {
+		a.MaxBytes = defaultAvatarMaxBytes
+	}
+	if a.CacheEntries < 0 {
+		return errors.New("avatars cache_entries can't be negative")
+	}
+	if a.CacheEntries == 0 {
+		a.CacheEntries = defaultAvatarCacheEntries
+	}
+	if strings.TrimSpace(a.CacheTTLStr) == "" {
+		a.CacheTTLStr = defaultAvatarCacheTTL
+	}
+	ttl, err := common.ParseDuration(a.CacheTTLStr)
+	if err != nil {
+		return fmt.Errorf("when parsing avatars cache_ttl: %w", err)
+	}
+	if ttl <= 0 {
+		return errors.New("avatars cache_ttl must be positive")
+	}
+	a.CacheTTL = ttl
+
+	return nil
+}
+
+// avatarProxy fetches the avatars feeds declare and keeps them, keyed by user ID, so visitors' browsers
+// load them from the registry rather than from wherever the feed says.
+type avatarProxy struct {
+	dbConn     *registry.DB
+	maxBytes   int64
+	ttl        time.Duration
+	maxEntries int
+
+	mu      sync.Mutex
+	entries map[string]cachedAvatar
+}
+
+// cachedAvatar is an avatar fetched from source, or the reason it couldn't be.
+type cachedAvatar struct {
+	source      string
+	contentType string
+	body        []byte
+	err         error
+	fetched     time.Time
+	expires     time.Time
+}
+
+func newAvatarProxy(conf *Config, dbConn *registry.DB) *avatarProxy {
+	conf.mu.RLock()
+	limits := conf.Avatars
+	conf.mu.RUnlock()
+	// Fills in the defaults for configurations that weren't parsed, as in tests. Parsed ones are left alone.
+	_ = limits.parse()
+
+	return &avatarProxy{
+		dbConn:     dbConn,
+		maxBytes:   limits.MaxBytes,
+		ttl:        limits.CacheTTL,
+		maxEntries: limits.CacheEntries,
+		entries:    make(map[string]cachedAvatar),
+	}
+}
+
+// get returns the avatar of the user with the provided ID, declared at source, fetching it if it
+// isn't cached or has changed since it was.
+func (p *avatarProxy) get(userID, source string) cachedAvatar {
+	p.mu.Lock()
+	entry, ok := p.entries[userID]
+	p.mu.Unlock()
+	if ok && entry.source == source && time.Now().Before(entry.expires) {
+		return entry
+	}
+
+	entry = p.fetch(source)
+	entry.fetched = time.Now().UTC()
+	entry.expires = entry.fetched.Add(p.ttl)
+	if entry.err != nil {
+		entry.expires = entry.fetched.Add(avatarFailureTTL)
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	// Like the response cache, it's emptied when full, as most of what's in it will be asked for again.
+	if _, ok := p.entries[userID]; !ok && len(p.entries) >= p.maxEntries {
+		p.entries = make(map[string]cachedAvatar)
+	}
+	p.entries[userID] = entry
+
+	return entry
+}
+
+// fetch downloads the avatar at source, refusing it if it's larger than the limit or isn't one of avatarTypes.
+// The type is sniffed from the image itself rather than trusted from the response.
+func (p *avatarProxy) fetch(source string) cachedAvatar {
+	entry := cachedAvatar{source: source}
+	if !common.IsValidURL(source, log.StandardLogger()) {
+		entry.err = fmt.Errorf("%w: invalid URL %s", errAvatarRefused, source)
+		return entry
+	}
+
+	resp, err := p.dbConn.Client.Get(source)
+	if err != nil {
+		entry.err = fmt.Errorf("when fetching avatar at %s: %w", source, err)
+		return entry
+	}
+	defer func() {
+		_ = resp.Body.Close()
+	}()
+	if resp.StatusCode != http.StatusOK {
+		entry.err = fmt.Errorf("got status code %d from avatar at %s", resp.StatusCode, source)
+		return entry
+	}
+	if resp.ContentLength > p.maxBytes {
+		entry.err = fmt.Errorf("%w: avatar at %s is %d bytes, more than %d", errAvatarRefused, source, resp.ContentLength, p.maxBytes)
+		return entry
+	}
+
+	body, err := io.ReadAll(io.LimitReader(resp.Body, p.maxBytes+1))
+	if err != nil {
+		entry.err = fmt.Errorf("when reading avatar at %s: %w", source, err)
+		return entry
+	}
+	if int64(len(body)) > p.maxBytes {
+		entry.err = fmt.Errorf("%w: avatar at %s is more than %d bytes", errAvatarRefused, source, p.maxBytes)
+		return entry
+	}
+	contentType := http.DetectContentType(body)
+	if !avatarTypes[contentType] {
+		entry.err = fmt.Errorf("%w: avatar at %s is %s, not an image", errAvatarRefused, source, contentType)
+		return entry
+	}
+
+	entry.contentType = contentType
+	entry.body = body
+	return entry
+}
+
+// avatarHandler serves the avatar of the user with the ID in the path.
+func avatarHandler(w http.ResponseWriter, r *http.Request, proxy *avatarProxy) {
+	userID := mux.Vars(r)["id"]
+	users, err := proxy.dbConn.GetUsersByID(r.Context(), []string{userID})
+	if err != nil {
+		reqLog(r).Errorf("When looking up user %s for their avatar: %s", userID, err)
+		code, message := queryErrorStatus(r, err)
+		errorWrite(w, r, errorFormat(r), code, message)
+		return
+	}
+	if len(users) == 0 || users[0].Avatar == "" {
+		errorWrite(w, r, errorFormat(r), http.StatusNotFound, "")
+		return
+	}
+
+	avatar := proxy.get(userID, users[0].Avatar)
+	if avatar.err != nil {
+		reqLog(r).Debugf("Couldn't serve avatar of user %s: %s", userID, avatar.err)
+		errorWrite(w, r, errorFormat(r), http.StatusBadGateway, "")
+		return
+	}
+
+	w.Header().Set("Content-Type", avatar.contentType)
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.Header().Set("Content-Security-Policy", "default-src 'none'")
+	w.Header().Set("Cache-Control", fmt.Sprintf("public, max-age=%d", int(time.Until(avatar.expires).Seconds())))
+	http.ServeContent(w, r, "", avatar.fetched, bytes.NewReader(avatar.body))
+}
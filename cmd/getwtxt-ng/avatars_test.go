@@ -0,0 +1,115 @@
+package main
+
+/*
+Copyright 2021 G. Benjamin Morrison
+
+This file is part of getwtxt-ng.
+
+getwtxt-ng is free software: you can redistribute it and/or modify
+it under the terms of the GNU Affero General Public License as
+published by the Free Software Foundation, either version 3 of the
+License, or (at your option) any later version.
+
+getwtxt-ng is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU Affero General Public License for more details.
+
+You should have received a copy of the GNU Affero General Public License
+along with getwtxt-ng.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+
+	"github.com/gorilla/mux"
+
+	"github.com/gbmor/getwtxt-ng/registry"
+)
+
+func TestAvatarHandler(t *testing.T) {
+	ctx := context.Background()
+	dbConn := getFederationDB(t)
+
+	png := []byte("\x89PNG\r\n\x1a\n\x00\x00\x00\rIHDR")
+	var fetches int32
+	feedHost := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&fetches, 1)
+		switch r.URL.Path {
+		case "/avatar.png":
+			_, _ = w.Write(png)
+		case "/avatar.svg":
+			_, _ = w.Write([]byte(`<svg xmlns="http://www.w3.org/2000/svg"><script>alert(1)</script></svg>`))
+		case "/huge.png":
+			_, _ = w.Write(append(png, bytes.Repeat([]byte{0}, 2048)...))
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer feedHost.Close()
+
+	avatars := map[string]string{
+		"png":     feedHost.URL + "/avatar.png",
+		"svg":     feedHost.URL + "/avatar.svg",
+		"huge":    feedHost.URL + "/huge.png",
+		"missing": feedHost.URL + "/missing.png",
+		"none":    "",
+	}
+	ids := make(map[string]string)
+	for name, avatar := range avatars {
+		u := registry.User{Nick: name, URL: "https://" + name + ".example/twtxt.txt", PasscodeHash: []byte("hash")}
+		if err := dbConn.InsertUser(ctx, &u); err != nil {
+			t.Fatal(err.Error())
+		}
+		if err := dbConn.SetFeedMetadata(ctx, u.ID, registry.FeedMetadata{Avatar: avatar}); err != nil {
+			t.Fatal(err.Error())
+		}
+		ids[name] = u.ID
+	}
+
+	conf := &Config{Avatars: Avatars{MaxBytes: 1024}}
+	r := mux.NewRouter()
+	setUpRoutes(r, conf, dbConn)
+	serve := func(id string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, "/avatars/"+id, nil)
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		return w
+	}
+
+	for i := 0; i < 2; i++ {
+		w := serve(ids["png"])
+		if w.Code != http.StatusOK {
+			t.Fatalf("Expected %d for a png avatar, got %d", http.StatusOK, w.Code)
+		}
+		if w.Header().Get("Content-Type") != "image/png" || !bytes.Equal(w.Body.Bytes(), png) {
+			t.Errorf("Expected the png, got %q %q", w.Header().Get("Content-Type"), w.Body.Bytes())
+		}
+		if w.Header().Get("X-Content-Type-Options") != "nosniff" {
+			t.Errorf("Expected nosniff, got %q", w.Header().Get("X-Content-Type-Options"))
+		}
+	}
+	if n := atomic.LoadInt32(&fetches); n != 1 {
+		t.Errorf("Expected the avatar to be fetched once and then cached, got %d fetches", n)
+	}
+
+	tests := map[string]int{
+		"svg":     http.StatusBadGateway,
+		"huge":    http.StatusBadGateway,
+		"missing": http.StatusBadGateway,
+		"none":    http.StatusNotFound,
+	}
+	for name, code := range tests {
+		if w := serve(ids[name]); w.Code != code {
+			t.Errorf("Expected %d for avatar %s, got %d", code, name, w.Code)
+		}
+	}
+	if w := serve("9999"); w.Code != http.StatusNotFound {
+		t.Errorf("Expected %d for an unknown user, got %d", http.StatusNotFound, w.Code)
+	}
+}
@@ -0,0 +1,173 @@
+package main
+
+/*
+Copyright 2021 G. Benjamin Morrison
+
+This file is part of getwtxt-ng.
+
+getwtxt-ng is free software: you can redistribute it and/or modify
+it under the terms of the GNU Affero General Public License as
+published by the Free Software Foundation, either version 3 of the
+License, or (at your option) any later version.
+
+getwtxt-ng is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU Affero General Public License for more details.
+
+You should have received a copy of the GNU Affero General Public License
+along with getwtxt-ng.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+	log "github.com/sirupsen/logrus"
+
+	"github.com/gbmor/getwtxt-ng/registry"
+)
+
+// Blocklists are fetched every six hours unless configured otherwise.
+const (
+	defaultBlocklistInterval = "6h"
+	minBlocklistInterval     = time.Minute
+)
+
+// parse validates the blocklist URLs and interval.
+func (b *Blocklists) parse() error {
+	for _, listURL := range b.URLs {
+		if !strings.HasPrefix(listURL, "https://") && !strings.HasPrefix(listURL, "http://") {
+			return fmt.Errorf("blocklist must be an http:// or https:// URL: %s", listURL)
+		}
+	}
+
+	if strings.TrimSpace(b.IntervalStr) == "" {
+		b.IntervalStr = defaultBlocklistInterval
+	}
+	interval, err := time.ParseDuration(b.IntervalStr)
+	if err != nil {
+		return fmt.Errorf("when parsing blocklists interval: %w", err)
+	}
+	if interval < minBlocklistInterval {
+		return fmt.Errorf("blocklists interval can't be less than %s", minBlocklistInterval)
+	}
+	b.Interval = interval
+
+	return nil
+}
+
+// InitBlocklistTicker refreshes the bans from the subscribed blocklists in the background, then again every interval.
+// Bans from lists no longer subscribed to are dropped on the first refresh, even when there are none left.
+func InitBlocklistTicker(conf *Config, dbConn *registry.DB) chan<- struct{} {
+	conf.mu.RLock()
+	urls := append([]string(nil), conf.Blocklists.URLs...)
+	interval := conf.Blocklists.Interval
+	conf.mu.RUnlock()
+	tick := time.NewTicker(interval)
+	done := make(chan struct{})
+
+	go func() {
+		refreshBlocklists(context.Background(), urls, dbConn)
+		for {
+			select {
+			case <-done:
+				tick.Stop()
+				return
+			case <-tick.C:
+				refreshBlocklists(context.Background(), urls, dbConn)
+			}
+		}
+	}()
+
+	return done
+}
+
+// refreshBlocklists replaces the bans from each list with its current entries. A list that can't be
+// fetched keeps the bans it had until the next pass.
+func refreshBlocklists(ctx context.Context, urls []string, dbConn *registry.DB) {
+	for _, listURL := range urls {
+		patterns, invalid, err := dbConn.FetchBlocklist(ctx, listURL)
+		if err != nil {
+			log.Errorf("Couldn't fetch blocklist %s: %s", listURL, err)
+			continue
+		}
+		added, removed, err := dbConn.ReplaceBlocklist(ctx, listURL, patterns)
+		if err != nil {
+			log.Errorf("Couldn't update bans from blocklist %s: %s", listURL, err)
+			continue
+		}
+		log.Infof("Blocklist %s: %d bans added, %d removed, %d invalid entries skipped", listURL, added, removed, invalid)
+	}
+
+	removed, err := dbConn.DeleteBlocklistsExcept(ctx, urls)
+	if err != nil {
+		log.Errorf("Couldn't drop bans from unsubscribed blocklists: %s", err)
+		return
+	}
+	if removed > 0 {
+		log.Infof("Dropped %d bans from blocklists no longer subscribed to", removed)
+	}
+}
+
+// bansHandler lists every ban, or adds one for the domain or feed URL in ?pattern=.
+func bansHandler(w http.ResponseWriter, r *http.Request, conf *Config, dbConn *registry.DB) {
+	if !adminAuthorized(w, r, conf) {
+		return
+	}
+
+	if r.Method != http.MethodPost {
+		bans, err := dbConn.GetBans(r.Context())
+		if err != nil {
+			reqLog(r).Errorf("When retrieving bans: %s", err)
+			code, message := queryErrorStatus(r, err)
+			errorResponseWrite(w, r, APIFormatJSON, code, MessageResponse{Message: message})
+			return
+		}
+		jsonResponseWrite(w, r, bans, http.StatusOK)
+		return
+	}
+
+	pattern := strings.TrimSpace(r.URL.Query().Get("pattern"))
+	if pattern == "" {
+		errorWrite(w, r, APIFormatJSON, http.StatusBadRequest, "Please provide a domain or feed URL to ban")
+		return
+	}
+	ban, err := dbConn.AddBan(r.Context(), pattern)
+	if err != nil {
+		if errors.Is(err, registry.ErrInvalidBanPattern) {
+			errorWrite(w, r, APIFormatJSON, http.StatusBadRequest, "Invalid domain or feed URL: "+pattern)
+			return
+		}
+		reqLog(r).Errorf("When adding ban %s: %s", pattern, err)
+		errorWrite(w, r, APIFormatJSON, http.StatusInternalServerError, "")
+		return
+	}
+	reqLog(r).Infof("Banned %s", ban.Pattern)
+	jsonResponseWrite(w, r, ban, http.StatusOK)
+}
+
+// deleteBanHandler lifts a ban. One from a blocklist comes back when the list is next fetched, if it's still listed.
+func deleteBanHandler(w http.ResponseWriter, r *http.Request, conf *Config, dbConn *registry.DB) {
+	if !adminAuthorized(w, r, conf) {
+		return
+	}
+
+	banID := mux.Vars(r)["id"]
+	if err := dbConn.DeleteBan(r.Context(), banID); err != nil {
+		if errors.Is(err, registry.ErrBanNotFound) {
+			errorWrite(w, r, APIFormatJSON, http.StatusNotFound, "No ban with that ID")
+			return
+		}
+		reqLog(r).Errorf("When deleting ban %s: %s", banID, err)
+		errorWrite(w, r, APIFormatJSON, http.StatusInternalServerError, "")
+		return
+	}
+	reqLog(r).Infof("Deleted ban %s", banID)
+	jsonResponseWrite(w, r, MessageResponse{Message: "Deleted"}, http.StatusOK)
+}
@@ -0,0 +1,168 @@
+package main
+
+/*
+Copyright 2021 G. Benjamin Morrison
+
+This file is part of getwtxt-ng.
+
+getwtxt-ng is free software: you can redistribute it and/or modify
+it under the terms of the GNU Affero General Public License as
+published by the Free Software Foundation, either version 3 of the
+License, or (at your option) any later version.
+
+getwtxt-ng is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU Affero General Public License for more details.
+
+You should have received a copy of the GNU Affero General Public License
+along with getwtxt-ng.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/gorilla/mux"
+
+	"github.com/gbmor/getwtxt-ng/common"
+	"github.com/gbmor/getwtxt-ng/registry"
+)
+
+func TestBansHandlers(t *testing.T) {
+	dbConn := getFederationDB(t)
+	hash, err := common.HashPass("hunter2")
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	conf := &Config{ServerConfig: ServerConfig{AdminPassword: string(hash)}}
+
+	r := mux.NewRouter()
+	setUpRoutes(r, conf, dbConn)
+	serve := func(method, path, pass string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, path, nil)
+		if pass != "" {
+			req.Header.Set("X-Auth", pass)
+		}
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		return w
+	}
+
+	if w := serve(http.MethodPost, "/api/admin/bans?pattern=spam.example", "wrong"); w.Code != http.StatusForbidden {
+		t.Errorf("Expected %d with the wrong password, got %d", http.StatusForbidden, w.Code)
+	}
+	if w := serve(http.MethodPost, "/api/admin/bans?pattern=not%20a%20domain", "hunter2"); w.Code != http.StatusBadRequest {
+		t.Errorf("Expected %d for an invalid pattern, got %d", http.StatusBadRequest, w.Code)
+	}
+
+	w := serve(http.MethodPost, "/api/admin/bans?pattern=*.Spam.Example", "hunter2")
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected %d when banning, got %d: %s", http.StatusOK, w.Code, w.Body.String())
+	}
+	ban := registry.Ban{}
+	if err := json.NewDecoder(w.Body).Decode(&ban); err != nil {
+		t.Fatal(err.Error())
+	}
+	if ban.Pattern != "spam.example" || ban.Source != "" {
+		t.Errorf("Expected a local ban of spam.example, got %+v", ban)
+	}
+
+	form := url.Values{"nickname": {"foo"}, "url": {"https://www.feeds.spam.example/twtxt.txt"}}
+	req := httptest.NewRequest(http.MethodPost, "/api/plain/users?"+form.Encode(), nil)
+	w = httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	if w.Code != http.StatusForbidden {
+		t.Errorf("Expected %d registering a banned feed, got %d: %s", http.StatusForbidden, w.Code, w.Body.String())
+	}
+
+	body := `{"nickname":"foo","url":"https://feeds.spam.example/twtxt.txt"}`
+	req = httptest.NewRequest(http.MethodPost, "/api/json/users", strings.NewReader(body))
+	w = httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	msg := MessageResponse{}
+	if err := json.NewDecoder(w.Body).Decode(&msg); err != nil {
+		t.Fatal(err.Error())
+	}
+	if w.Code != http.StatusForbidden || msg.Code != errCodeBanned {
+		t.Errorf("Expected %d with code %s, got %d with %+v", http.StatusForbidden, errCodeBanned, w.Code, msg)
+	}
+
+	w = serve(http.MethodGet, "/api/admin/bans", "hunter2")
+	bans := make([]registry.Ban, 0)
+	if err := json.NewDecoder(w.Body).Decode(&bans); err != nil {
+		t.Fatal(err.Error())
+	}
+	if len(bans) != 1 || bans[0].ID != ban.ID {
+		t.Fatalf("Expected the one ban, got %+v", bans)
+	}
+
+	if w := serve(http.MethodDelete, "/api/admin/bans/"+ban.ID, "hunter2"); w.Code != http.StatusOK {
+		t.Errorf("Expected %d lifting the ban, got %d", http.StatusOK, w.Code)
+	}
+	if w := serve(http.MethodDelete, "/api/admin/bans/"+ban.ID, "hunter2"); w.Code != http.StatusNotFound {
+		t.Errorf("Expected %d lifting it again, got %d", http.StatusNotFound, w.Code)
+	}
+}
+
+func TestRefreshBlocklists(t *testing.T) {
+	ctx := context.Background()
+	dbConn := getFederationDB(t)
+
+	list := "# shared\nspam.example\nhttps://bad.example/twtxt.txt\nnot a domain\n"
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = fmt.Fprint(w, list)
+	}))
+	defer srv.Close()
+	if _, err := dbConn.AddBan(ctx, "local.example"); err != nil {
+		t.Fatal(err.Error())
+	}
+
+	refreshBlocklists(ctx, []string{srv.URL}, dbConn)
+	bans, err := dbConn.GetBans(ctx)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	if len(bans) != 3 {
+		t.Fatalf("Expected the local ban and two from the list, got %+v", bans)
+	}
+	if ban, err := dbConn.FindBan(ctx, "https://feeds.spam.example/twtxt.txt"); err != nil || ban == nil || ban.Source != srv.URL {
+		t.Errorf("Expected spam.example banned by the list, got %+v, %v", ban, err)
+	}
+
+	list = "spam.example\n"
+	refreshBlocklists(ctx, []string{srv.URL}, dbConn)
+	if ban, err := dbConn.FindBan(ctx, "https://bad.example/twtxt.txt"); err != nil || ban != nil {
+		t.Errorf("Expected the entry dropped from the list to be unbanned, got %+v, %v", ban, err)
+	}
+
+	refreshBlocklists(ctx, nil, dbConn)
+	bans, err = dbConn.GetBans(ctx)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	if len(bans) != 1 || bans[0].Pattern != "local.example" {
+		t.Errorf("Expected only the local ban after unsubscribing, got %+v", bans)
+	}
+}
+
+func TestBlocklistsParse(t *testing.T) {
+	b := Blocklists{}
+	if err := b.parse(); err != nil || b.Interval.String() != "6h0m0s" {
+		t.Errorf("Expected the default interval, got %s, %v", b.Interval, err)
+	}
+	b = Blocklists{URLs: []string{"ftp://lists.example/bans.txt"}}
+	if err := b.parse(); err == nil {
+		t.Error("Expected an error for a non-http blocklist URL")
+	}
+	b = Blocklists{IntervalStr: "10s"}
+	if err := b.parse(); err == nil {
+		t.Error("Expected an error for too short an interval")
+	}
+}
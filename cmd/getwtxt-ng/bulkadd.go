@@ -0,0 +1,399 @@
+package main
+
+/*
+Copyright 2021 G. Benjamin Morrison
+
+This file is part of getwtxt-ng.
+
+getwtxt-ng is free software: you can redistribute it and/or modify
+it under the terms of the GNU Affero General Public License as
+published by the Free Software Foundation, either version 3 of the
+License, or (at your option) any later version.
+
+getwtxt-ng is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU Affero General Public License for more details.
+
+You should have received a copy of the GNU Affero General Public License
+along with getwtxt-ng.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gorilla/mux"
+	log "github.com/sirupsen/logrus"
+
+	"github.com/gbmor/getwtxt-ng/common"
+	"github.com/gbmor/getwtxt-ng/registry"
+)
+
+// Limits on bulk adding users.
+const (
+	bulkAddMaxBody  = 4 << 20
+	bulkAddMaxLines = 10000
+	bulkAddJobsKept = 20
+	// bulkAddMaxRunning is how many jobs may run at once; more are turned away.
+	bulkAddMaxRunning = 2
+)
+
+// errTooManyBulkJobs is returned when starting a job while bulkAddMaxRunning are already running.
+var errTooManyBulkJobs = errors.New("too many bulk add jobs running")
+
+// States of a bulk add job.
+const (
+	bulkJobRunning = "running"
+	bulkJobDone    = "done"
+	bulkJobFailed  = "failed"
+)
+
+// What became of each line of a bulk add.
+const (
+	bulkLineAdded   = "added"
+	bulkLineSkipped = "skipped"
+	bulkLineInvalid = "invalid"
+)
+
+// bulkAddLine is the outcome of one line of the list being added.
+type bulkAddLine struct {
+	Line   int
+	Nick   string
+	URL    string
+	Result string
+	Reason string
+}
+
+// bulkAddJob is a list of users being added in the background.
+type bulkAddJob struct {
+	mu       sync.Mutex
+	id       string
+	source   string
+	state    string
+	err      string
+	started  time.Time
+	finished time.Time
+	lines    []bulkAddLine
+	done     chan struct{}
+}
+
+// bulkAddJobs holds the most recent bulk add jobs, so their results can be looked up once they're done.
+type bulkAddJobs struct {
+	mu    sync.Mutex
+	jobs  map[string]*bulkAddJob
+	order []string
+}
+
+func newBulkAddJobs() *bulkAddJobs {
+	return &bulkAddJobs{jobs: make(map[string]*bulkAddJob)}
+}
+
+// start begins adding the users listed at source, forgetting the oldest finished job if too many are kept.
+// Returns errTooManyBulkJobs if bulkAddMaxRunning jobs are already running.
+func (b *bulkAddJobs) start(dbConn *registry.DB, source string) (*bulkAddJob, error) {
+	job := &bulkAddJob{
+		id:      newRandomID(),
+		source:  source,
+		state:   bulkJobRunning,
+		started: time.Now().UTC(),
+		done:    make(chan struct{}),
+	}
+
+	b.mu.Lock()
+	running := 0
+	for _, id := range b.order {
+		if b.jobs[id].finishedAt().IsZero() {
+			running++
+		}
+	}
+	if running >= bulkAddMaxRunning {
+		b.mu.Unlock()
+		return nil, errTooManyBulkJobs
+	}
+	excess := len(b.order) - bulkAddJobsKept + 1
+	kept := b.order[:0]
+	for _, id := range b.order {
+		if excess > 0 && !b.jobs[id].finishedAt().IsZero() {
+			delete(b.jobs, id)
+			excess--
+			continue
+		}
+		kept = append(kept, id)
+	}
+	b.order = kept
+	b.jobs[job.id] = job
+	b.order = append(b.order, job.id)
+	b.mu.Unlock()
+
+	go job.run(context.Background(), dbConn)
+	return job, nil
+}
+
+func (b *bulkAddJobs) get(id string) (*bulkAddJob, bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	job, ok := b.jobs[id]
+	return job, ok
+}
+
+func (j *bulkAddJob) finishedAt() time.Time {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	return j.finished
+}
+
+func (j *bulkAddJob) finish(state, errMsg string) {
+	j.mu.Lock()
+	j.state = state
+	j.err = errMsg
+	j.finished = time.Now().UTC()
+	j.mu.Unlock()
+	close(j.done)
+}
+
+func (j *bulkAddJob) setLines(lines []bulkAddLine) {
+	j.mu.Lock()
+	j.lines = lines
+	j.mu.Unlock()
+}
+
+// run fetches the list, checks each line, adds the users that are new, and fetches their feeds.
+func (j *bulkAddJob) run(ctx context.Context, dbConn *registry.DB) {
+	body, err := fetchBulkList(ctx, dbConn, j.source)
+	if err != nil {
+		log.Errorf("Bulk add job %s: %s", j.id, err)
+		j.finish(bulkJobFailed, err.Error())
+		return
+	}
+
+	lines, usersToAdd, err := checkBulkList(ctx, dbConn, body)
+	if err != nil {
+		log.Errorf("Bulk add job %s: %s", j.id, err)
+		j.finish(bulkJobFailed, err.Error())
+		return
+	}
+	j.setLines(lines)
+
+	users, err := dbConn.InsertUsers(ctx, usersToAdd)
+	if err != nil {
+		log.Errorf("Bulk add job %s: when bulk inserting users: %s", j.id, err)
+		j.finish(bulkJobFailed, "couldn't add the users")
+		return
+	}
+
+	added := make(map[string]bool, len(users))
+	for _, user := range users {
+		added[user.URL] = true
+	}
+	for i := range lines {
+		if lines[i].Result != "" {
+			continue
+		}
+		if added[lines[i].URL] {
+			lines[i].Result = bulkLineAdded
+		} else {
+			lines[i].Result = bulkLineSkipped
+			lines[i].Reason = "already registered"
+		}
+	}
+	j.setLines(lines)
+
+	for _, user := range users {
+		tweets, err := dbConn.FetchTwtxt(user.URL, user.ID, time.Time{})
+		if err != nil {
+			log.Errorf("Bulk add job %s: couldn't fetch tweets for %s: %s", j.id, user.URL, err)
+			continue
+		}
+		res, err := dbConn.InsertTweets(ctx, tweets)
+		if err != nil {
+			log.Errorf("Bulk add job %s: couldn't insert tweets for %s: %s", j.id, user.URL, err)
+			continue
+		}
+		log.Infof("Bulk add job %s: ingested %d new twts from %s", j.id, res.Inserted, user.URL)
+	}
+
+	j.finish(bulkJobDone, "")
+}
+
+// fetchBulkList downloads the list of users to add, refusing lists over bulkAddMaxBody.
+func fetchBulkList(ctx context.Context, dbConn *registry.DB, source string) ([]byte, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, source, nil)
+	if err != nil {
+		return nil, fmt.Errorf("couldn't create request for %s: %w", source, err)
+	}
+	resp, err := dbConn.Client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("couldn't fetch %s: %w", source, err)
+	}
+	defer func() {
+		_ = resp.Body.Close()
+	}()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("fetching %s returned %s", source, resp.Status)
+	}
+
+	body, err := io.ReadAll(io.LimitReader(resp.Body, bulkAddMaxBody+1))
+	if err != nil {
+		return nil, fmt.Errorf("couldn't read %s: %w", source, err)
+	}
+	if len(body) > bulkAddMaxBody {
+		return nil, fmt.Errorf("list is larger than %d bytes", bulkAddMaxBody)
+	}
+	return body, nil
+}
+
+// checkBulkList reads the tab-separated nickname, URL, and optional date added on each line of body.
+// Lines that can't be added get their result; the rest are returned as users to add, with their
+// result left for once they have been.
+func checkBulkList(ctx context.Context, dbConn *registry.DB, body []byte) ([]bulkAddLine, []registry.User, error) {
+	lines := make([]bulkAddLine, 0, 64)
+	usersToAdd := make([]registry.User, 0, 64)
+	seen := make(map[string]bool)
+
+	scanner := bufio.NewScanner(bytes.NewReader(body))
+	lineNum := 0
+	for scanner.Scan() {
+		lineNum++
+		if lineNum > bulkAddMaxLines {
+			return nil, nil, fmt.Errorf("list has more than %d lines", bulkAddMaxLines)
+		}
+		fields := strings.Fields(scanner.Text())
+		if len(fields) == 0 {
+			continue
+		}
+
+		line := bulkAddLine{Line: lineNum, Nick: fields[0]}
+		if len(fields) > 1 {
+			line.URL = fields[1]
+		}
+		reason := ""
+		var dt time.Time
+		canonical, urlErr := registry.CanonicalURL(line.URL)
+		switch {
+		case len(fields) < 2:
+			reason = "needs a nickname and a URL"
+		case !registry.RegexIsAlpha.MatchString(line.Nick):
+			reason = "invalid nickname"
+		case urlErr != nil || !registry.RegexURLIsTwtxtFile.MatchString(line.URL):
+			reason = "not a URL to a twtxt file"
+		case len(fields) > 2:
+			var err error
+			dt, err = time.Parse(time.RFC3339Nano, fields[2])
+			if err != nil {
+				reason = "invalid date added"
+			}
+		}
+		if reason != "" {
+			line.Result, line.Reason = bulkLineInvalid, reason
+			lines = append(lines, line)
+			continue
+		}
+
+		if seen[canonical] {
+			line.Result, line.Reason = bulkLineSkipped, "listed more than once"
+			lines = append(lines, line)
+			continue
+		}
+		seen[canonical] = true
+
+		ban, err := dbConn.FindBan(ctx, line.URL)
+		if err != nil {
+			return nil, nil, fmt.Errorf("when checking for ban of %s: %w", line.URL, err)
+		}
+		if ban != nil {
+			line.Result, line.Reason = bulkLineInvalid, "banned"
+			lines = append(lines, line)
+			continue
+		}
+
+		exists, err := dbConn.UserExists(ctx, line.URL)
+		if err != nil {
+			return nil, nil, fmt.Errorf("when checking for existing user %s: %w", line.URL, err)
+		}
+		if exists {
+			line.Result, line.Reason = bulkLineSkipped, "already registered"
+			lines = append(lines, line)
+			continue
+		}
+
+		if dt.IsZero() {
+			dt = time.Now().UTC()
+		}
+		usersToAdd = append(usersToAdd, registry.User{Nick: line.Nick, URL: line.URL, DateTimeAdded: dt})
+		lines = append(lines, line)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, nil, fmt.Errorf("when reading list: %w", err)
+	}
+
+	return lines, usersToAdd, nil
+}
+
+// plain writes the job's state as its first line, followed by a line for each line of the list seen so far.
+func (j *bulkAddJob) plain() string {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	builder := strings.Builder{}
+	builder.WriteString(fmt.Sprintf("%s\t%s\t%s\t%s\n", j.id, j.state, j.source, j.err))
+	for _, line := range j.lines {
+		result := line.Result
+		if result == "" {
+			result = bulkJobRunning
+		}
+		builder.WriteString(fmt.Sprintf("%d\t%s\t%s\t%s\t%s\n", line.Line, result, line.Nick, line.URL, line.Reason))
+	}
+	return builder.String()
+}
+
+// plainBulkAddUserHandler starts adding the users listed at the URL in source, and answers with the
+// ID of the job and where to find its results.
+func plainBulkAddUserHandler(w http.ResponseWriter, r *http.Request, conf *Config, dbConn *registry.DB, jobs *bulkAddJobs) {
+	w.Header().Set("Content-Type", "text/plain")
+	_ = r.ParseForm()
+	remoteURL := r.Form.Get("source")
+
+	if !adminAuthorized(w, r, conf) {
+		return
+	}
+
+	if !common.IsValidURL(remoteURL, log.StandardLogger()) {
+		msg := fmt.Sprintf("Couldn't parse %s as URL", remoteURL)
+		errorWrite(w, r, APIFormatPlain, http.StatusBadRequest, msg)
+		return
+	}
+
+	job, err := jobs.start(dbConn, remoteURL)
+	if err != nil {
+		errorWrite(w, r, APIFormatPlain, http.StatusTooManyRequests, "Too many bulk adds are running; try again once one finishes")
+		return
+	}
+	reqLog(r).Infof("Started bulk add job %s for %s", job.id, remoteURL)
+	statusPath := "/api/plain/users/bulk/" + job.id
+	w.Header().Set("Location", statusPath)
+	plainResponseWrite(w, fmt.Sprintf("%s\t%s\n", job.id, statusPath), http.StatusAccepted)
+}
+
+// plainBulkAddStatusHandler reports how a bulk add job is going, or how it went.
+func plainBulkAddStatusHandler(w http.ResponseWriter, r *http.Request, conf *Config, jobs *bulkAddJobs) {
+	w.Header().Set("Content-Type", "text/plain")
+	if !adminAuthorized(w, r, conf) {
+		return
+	}
+
+	job, ok := jobs.get(mux.Vars(r)["id"])
+	if !ok {
+		errorWrite(w, r, APIFormatPlain, http.StatusNotFound, "No bulk add job with that ID")
+		return
+	}
+	plainResponseWrite(w, job.plain(), http.StatusOK)
+}
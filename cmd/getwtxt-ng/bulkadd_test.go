@@ -0,0 +1,151 @@
+package main
+
+/*
+Copyright 2021 G. Benjamin Morrison
+
+This file is part of getwtxt-ng.
+
+getwtxt-ng is free software: you can redistribute it and/or modify
+it under the terms of the GNU Affero General Public License as
+published by the Free Software Foundation, either version 3 of the
+License, or (at your option) any later version.
+
+getwtxt-ng is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU Affero General Public License for more details.
+
+You should have received a copy of the GNU Affero General Public License
+along with getwtxt-ng.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/mux"
+
+	"github.com/gbmor/getwtxt-ng/common"
+	"github.com/gbmor/getwtxt-ng/registry"
+)
+
+func TestPlainBulkAddUserHandler(t *testing.T) {
+	ctx := context.Background()
+	dbConn := getFederationDB(t)
+	hash, err := common.HashPass("hunter2")
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	conf := &Config{ServerConfig: ServerConfig{AdminPassword: string(hash)}}
+
+	var feeds *httptest.Server
+	feeds = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/list" {
+			_, _ = fmt.Fprintf(w, "new\t%[1]s/new/twtxt.txt\nold\t%[1]s/old/twtxt.txt\nnew\t%[1]s/new/twtxt.txt\n!!!\t%[1]s/bad/twtxt.txt\nlonely\nbanned\t%[1]s/banned/twtxt.txt\n", feeds.URL)
+			return
+		}
+		_, _ = fmt.Fprintln(w, "2022-01-01T00:00:00Z\thello")
+	}))
+	t.Cleanup(feeds.Close)
+
+	if _, err := dbConn.AddBan(ctx, feeds.URL+"/banned/twtxt.txt"); err != nil {
+		t.Fatal(err.Error())
+	}
+	old := registry.User{Nick: "old", URL: feeds.URL + "/old/twtxt.txt", PasscodeHash: []byte("hash")}
+	if err := dbConn.InsertUser(ctx, &old); err != nil {
+		t.Fatal(err.Error())
+	}
+
+	r := mux.NewRouter()
+	setUpRoutes(r, conf, dbConn)
+	srv := httptest.NewServer(r)
+	t.Cleanup(srv.Close)
+
+	req, _ := http.NewRequest(http.MethodPost, srv.URL+"/api/plain/users/bulk?source="+feeds.URL+"/list", nil)
+	req.Header.Set("X-Auth", "hunter2")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	_ = resp.Body.Close()
+	if resp.StatusCode != http.StatusAccepted {
+		t.Fatalf("Expected %d, got %d", http.StatusAccepted, resp.StatusCode)
+	}
+	statusURL := srv.URL + resp.Header.Get("Location")
+
+	var body string
+	deadline := time.Now().Add(5 * time.Second)
+	for time.Now().Before(deadline) {
+		req, _ = http.NewRequest(http.MethodGet, statusURL, nil)
+		req.Header.Set("X-Auth", "hunter2")
+		resp, err = http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err.Error())
+		}
+		b, err := io.ReadAll(resp.Body)
+		_ = resp.Body.Close()
+		if err != nil {
+			t.Fatal(err.Error())
+		}
+		body = string(b)
+		if !strings.Contains(strings.SplitN(body, "\n", 2)[0], bulkJobRunning) {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	lines := strings.Split(strings.TrimSuffix(body, "\n"), "\n")
+	if len(lines) != 7 || !strings.Contains(lines[0], bulkJobDone) {
+		t.Fatalf("Expected a finished job with 6 lines, got:\n%s", body)
+	}
+	want := []string{bulkLineAdded, bulkLineSkipped, bulkLineSkipped, bulkLineInvalid, bulkLineInvalid, bulkLineInvalid}
+	for i, result := range want {
+		if fields := strings.Split(lines[i+1], "\t"); fields[1] != result {
+			t.Errorf("Expected line %d to be %s, got %q", i+1, result, lines[i+1])
+		}
+	}
+
+	if fields := strings.Split(lines[6], "\t"); fields[len(fields)-1] != "banned" {
+		t.Errorf("Expected the banned feed's line to say so, got %q", lines[6])
+	}
+
+	exists, err := dbConn.UserExists(ctx, feeds.URL+"/new/twtxt.txt")
+	if err != nil || !exists {
+		t.Errorf("Expected new user to be added, got %v %v", exists, err)
+	}
+}
+
+func TestBulkAddJobs_maxRunning(t *testing.T) {
+	dbConn := getFederationDB(t)
+	release := make(chan struct{})
+	lists := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-release
+	}))
+	t.Cleanup(lists.Close)
+	t.Cleanup(func() { close(release) })
+
+	jobs := newBulkAddJobs()
+	for i := 0; i < bulkAddMaxRunning; i++ {
+		if _, err := jobs.start(dbConn, lists.URL+"/list"); err != nil {
+			t.Fatal(err.Error())
+		}
+	}
+	if _, err := jobs.start(dbConn, lists.URL+"/list"); !errors.Is(err, errTooManyBulkJobs) {
+		t.Errorf("Expected errTooManyBulkJobs past %d running jobs, got %v", bulkAddMaxRunning, err)
+	}
+}
+
+func TestCheckBulkList_maxLines(t *testing.T) {
+	dbConn := getFederationDB(t)
+	body := strings.Repeat("\n", bulkAddMaxLines+1)
+	if _, _, err := checkBulkList(context.Background(), dbConn, []byte(body)); err == nil || !strings.Contains(err.Error(), "more than") {
+		t.Errorf("Expected error about the list's length, got %v", err)
+	}
+}
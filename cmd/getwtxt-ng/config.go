@@ -20,18 +20,24 @@ package main
 
 import (
 	"context"
+	"crypto/rsa"
+	"encoding/json"
 	"errors"
 	"fmt"
 	"html/template"
+	"io"
 	"os"
 	"strings"
 	"sync"
+	texttemplate "text/template"
 	"time"
 
 	"github.com/BurntSushi/toml"
 	log "github.com/sirupsen/logrus"
 
+	"github.com/gbmor/getwtxt-ng/activitypub"
 	"github.com/gbmor/getwtxt-ng/common"
+	"github.com/gbmor/getwtxt-ng/nostr"
 	"github.com/gbmor/getwtxt-ng/registry"
 )
 
@@ -39,13 +45,22 @@ type Config struct {
 	mu             sync.RWMutex
 	ServerConfig   ServerConfig   `toml:"server_config"`
 	InstanceConfig InstanceConfig `toml:"instance_info"`
+	Federation     Federation     `toml:"federation"`
+	ContentFilter  ContentFilter  `toml:"content_filter"`
+	PublicArchive  PublicArchive  `toml:"public_archive"`
+	Retention      Retention      `toml:"retention"`
+	Avatars        Avatars        `toml:"avatars"`
+	Blocklists     Blocklists     `toml:"blocklists"`
+	Registries     []RegistryHost `toml:"registries"`
 	Assets         Assets         `toml:"-"`
 }
 
 type ServerConfig struct {
 	AdminPassword         string `toml:"admin_password"`
+	AdminPasswordHash     string `toml:"admin_password_hash"`
 	IP                    string `toml:"bind_ip"`
 	Port                  string `toml:"port"`
+	AdminListen           string `toml:"admin_listen"`
 	DatabasePath          string `toml:"database_path"`
 	MessageLogPath        string `toml:"message_log"`
 	MessageLogFd          *os.File
@@ -53,34 +68,148 @@ type ServerConfig struct {
 	RequestLogFd          *os.File
 	FetchIntervalStr      string `toml:"fetch_interval"`
 	FetchInterval         time.Duration
+	FetchTimeoutStr       string `toml:"fetch_timeout"`
+	FetchTLSTimeoutStr    string `toml:"fetch_tls_handshake_timeout"`
+	FetchIdleTimeoutStr   string `toml:"fetch_idle_conn_timeout"`
+	FetchIdleConnsPerHost int    `toml:"fetch_max_idle_conns_per_host"`
+	FetchNoKeepAlives     bool   `toml:"fetch_disable_keep_alives"`
+	FetchTuning           registry.HTTPTuning
+	QueryTimeoutStr       string `toml:"query_timeout"`
+	QueryTimeout          time.Duration
+	RequestTimeoutStr     string `toml:"request_timeout"`
+	RequestTimeout        time.Duration
+	DeletionGraceStr      string `toml:"deletion_grace_period"`
+	DeletionGrace         time.Duration
 	TemplatePathIndex     string `toml:"template_path_index"`
 	TemplatePathPlainDocs string `toml:"template_path_plain_docs"`
 	TemplatePathJSONDocs  string `toml:"template_path_json_docs"`
+	TemplatePathRegPlain  string `toml:"template_path_registration_plain"`
+	TemplatePathRegJSON   string `toml:"template_path_registration_json"`
+	TemplatePathTweet     string `toml:"template_path_tweet"`
 	StylesheetPath        string `toml:"stylesheet_path"`
+	LocalesPath           string `toml:"locales_path"`
+	DefaultLanguage       string `toml:"default_language"`
 	EntriesPerPageMax     int    `toml:"entries_per_page_max"`
 	EntriesPerPageMin     int    `toml:"entries_per_page_min"`
+	DedupeModeStr         string `toml:"dedupe_mode"`
+	DedupeMode            registry.DedupeMode
+	CollapseMirrors       bool   `toml:"collapse_mirrored_tweets"`
+	SchemePolicyStr       string `toml:"feed_scheme_policy"`
+	SchemePolicy          registry.SchemePolicy
+	ControlCharsStr       string `toml:"plain_control_chars"`
+	DisplayTimezone       string `toml:"display_timezone"`
+	TimestampPrecision    string `toml:"timestamp_precision"`
+	PlainFormat           registry.PlainFormat
+	JSONEnvelope          bool   `toml:"json_envelope"`
+	SpecCompliant         bool   `toml:"spec_compliant"`
+	ArchiveDepth          int    `toml:"archive_depth"`
+	StatsRetentionDays    int    `toml:"stats_retention_days"`
+	HostedFeeds           bool   `toml:"hosted_feeds"`
+	RequireApproval       bool   `toml:"require_approval"`
+	ApprovalNotifyURL     string `toml:"approval_notify_url"`
 	HTTPRequestsPerMinute int    `toml:"http_requests_per_minute"`
 	HTTPRequestsBurstMax  int    `toml:"http_requests_max_burst"`
-	DebugMode             bool   `toml:"debug_mode"`
+	ActivityPubEnabled    bool   `toml:"activitypub_enabled"`
+	ActivityPubKeyPath    string `toml:"activitypub_key_path"`
+	ActivityPubKey        *rsa.PrivateKey
+	NostrRelays           []string `toml:"nostr_relays"`
+	NostrKeyPath          string   `toml:"nostr_key_path"`
+	NostrSecret           []byte
+	DebugMode             bool `toml:"debug_mode"`
 }
 
 // InstanceConfig holds the values that will be filled in on the landing page template.
 type InstanceConfig struct {
-	SiteName        string `toml:"site_name"`
-	SiteURL         string `toml:"site_url"`
-	SiteDescription string `toml:"site_description"`
-	OwnerName       string `toml:"owner_name"`
-	OwnerEmail      string `toml:"owner_email"`
-	Version         string `toml:"-"`
-	UserCount       uint32 `toml:"-"`
-	TweetCount      uint32 `toml:"-"`
+	SiteName        string `toml:"site_name" json:"site_name"`
+	SiteURL         string `toml:"site_url" json:"site_url"`
+	SiteDescription string `toml:"site_description" json:"site_description"`
+	OwnerName       string `toml:"owner_name" json:"owner_name"`
+	OwnerEmail      string `toml:"owner_email" json:"owner_email"`
+	Version         string `toml:"-" json:"-"`
+	UserCount       uint32 `toml:"-" json:"-"`
+	TweetCount      uint32 `toml:"-" json:"-"`
+}
+
+// Federation lists the peer registries whose users are periodically registered here.
+// With a shared secret, peers also push newly registered users to each other.
+// With an announce URL, that feed is registered with the registries feeds here declare.
+type Federation struct {
+	Peers        []string `toml:"peers"`
+	IntervalStr  string   `toml:"interval"`
+	Interval     time.Duration
+	SharedSecret string `toml:"shared_secret"`
+	AnnounceNick string `toml:"announce_nick"`
+	AnnounceURL  string `toml:"announce_url"`
+}
+
+// ContentFilter lists the rules new tweets are checked against as they're ingested.
+// Tweets matching a rule are hidden or flagged, and listed for the admin to review.
+type ContentFilter struct {
+	Rules  []FilterRule `toml:"rules" json:"rules"`
+	Filter *registry.ContentFilter
+}
+
+// PublicArchive configures the dump of the registry anyone may download from /archive.
+// With a path set, a new one is written there every interval.
+type PublicArchive struct {
+	Path             string `toml:"path"`
+	IntervalStr      string `toml:"interval"`
+	Interval         time.Duration
+	DownloadsPerHour int `toml:"downloads_per_hour"`
+}
+
+// Retention bounds how many tweets are kept. Every interval, tweets older than max_tweet_age, those hidden
+// for purge_hidden_after_days, and each user's beyond their newest max_tweets_per_user are deleted.
+// Zero or empty limits are off.
+type Retention struct {
+	MaxTweetAgeStr       string `toml:"max_tweet_age"`
+	MaxTweetAge          time.Duration
+	MaxTweetsPerUser     int    `toml:"max_tweets_per_user"`
+	PurgeHiddenAfterDays int    `toml:"purge_hidden_after_days"`
+	IntervalStr          string `toml:"interval"`
+	Interval             time.Duration
+}
+
+// Avatars configures the proxy serving the avatars feeds declare from /avatars/{id}, so pages showing them
+// don't send visitors' browsers to wherever the feeds say. Avatars larger than max_bytes, or that aren't
+// PNG, JPEG, GIF, or WebP images, aren't served. Up to cache_entries are kept for cache_ttl.
+type Avatars struct {
+	MaxBytes     int64  `toml:"max_bytes"`
+	CacheTTLStr  string `toml:"cache_ttl"`
+	CacheTTL     time.Duration
+	CacheEntries int `toml:"cache_entries"`
+}
+
+// Blocklists lists the shared blocklists whose domains and feed URLs are banned here, fetched every interval.
+// Banned feeds can't register and aren't synced.
+type Blocklists struct {
+	URLs        []string `toml:"urls"`
+	IntervalStr string   `toml:"interval"`
+	Interval    time.Duration
+}
+
+// FilterRule matches tweets containing any of its keywords or matching its pattern.
+// With domains, it only matches tweets from feeds on them, and without keywords or a pattern,
+// it matches every tweet from them. Action is "hide", the default, or "flag".
+type FilterRule struct {
+	Name     string   `toml:"name" json:"name"`
+	Action   string   `toml:"action" json:"action"`
+	Keywords []string `toml:"keywords" json:"keywords,omitempty"`
+	Pattern  string   `toml:"pattern" json:"pattern,omitempty"`
+	Domains  []string `toml:"domains" json:"domains,omitempty"`
 }
 
 type Assets struct {
 	IndexTemplate     *template.Template
 	PlainDocsTemplate *template.Template
 	JSONDocsTemplate  *template.Template
+	TweetTemplate     *template.Template
 	Stylesheet        []byte
+	Catalogs          *Catalogs
+
+	// RegistrationPlainTemplate and RegistrationJSONTemplate render the responses to a registration.
+	RegistrationPlainTemplate *texttemplate.Template
+	RegistrationJSONTemplate  *texttemplate.Template
 }
 
 // Reads the config file directly into a *Config without doing any additional parsing.
@@ -99,6 +228,7 @@ func readConfig(path string) (*Config, error) {
 
 // Open files, parse fetch interval, hash admin pass
 func (c *Config) parse() error {
+	c.ServerConfig.resolveAdminPassword()
 	if strings.TrimSpace(c.ServerConfig.AdminPassword) == "" {
 		return errors.New("please set admin_password in the configuration file")
 	}
@@ -119,6 +249,187 @@ func (c *Config) parse() error {
 	}
 	c.ServerConfig.FetchInterval = intervalParsed
 
+	fetchTuning, err := c.ServerConfig.parseFetchTuning()
+	if err != nil {
+		return err
+	}
+	c.ServerConfig.FetchTuning = fetchTuning
+
+	if strings.TrimSpace(c.ServerConfig.QueryTimeoutStr) == "" {
+		c.ServerConfig.QueryTimeoutStr = defaultQueryTimeout
+	}
+	queryTimeout, err := time.ParseDuration(c.ServerConfig.QueryTimeoutStr)
+	if err != nil {
+		return fmt.Errorf("when parsing query timeout: %w", err)
+	}
+	if queryTimeout < 0 {
+		return errors.New("query_timeout can't be negative")
+	}
+	c.ServerConfig.QueryTimeout = queryTimeout
+
+	requestTimeout, err := c.ServerConfig.parseRequestTimeout()
+	if err != nil {
+		return err
+	}
+	c.ServerConfig.RequestTimeout = requestTimeout
+
+	deletionGrace, err := c.ServerConfig.parseDeletionGrace()
+	if err != nil {
+		return err
+	}
+	c.ServerConfig.DeletionGrace = deletionGrace
+
+	if c.ServerConfig.SpecCompliant && c.ServerConfig.EntriesPerPageMin > specPageSize {
+		return fmt.Errorf("entries_per_page_min can't be more than %d with spec_compliant set", specPageSize)
+	}
+
+	if c.ServerConfig.ArchiveDepth < 0 {
+		c.ServerConfig.ArchiveDepth = 0
+	}
+	if c.ServerConfig.ArchiveDepth > maxArchiveDepth {
+		c.ServerConfig.ArchiveDepth = maxArchiveDepth
+	}
+
+	if c.ServerConfig.StatsRetentionDays < 1 {
+		c.ServerConfig.StatsRetentionDays = defaultStatsRetentionDays
+	}
+
+	dedupeMode, err := registry.ParseDedupeMode(c.ServerConfig.DedupeModeStr)
+	if err != nil {
+		return fmt.Errorf("when parsing dedupe mode: %w", err)
+	}
+	c.ServerConfig.DedupeMode = dedupeMode
+
+	schemePolicy, err := registry.ParseSchemePolicy(c.ServerConfig.SchemePolicyStr)
+	if err != nil {
+		return fmt.Errorf("when parsing feed scheme policy: %w", err)
+	}
+	c.ServerConfig.SchemePolicy = schemePolicy
+
+	plainFormat, err := c.ServerConfig.parsePlainFormat()
+	if err != nil {
+		return err
+	}
+	c.ServerConfig.PlainFormat = plainFormat
+
+	if c.ServerConfig.HostedFeeds && strings.TrimSpace(c.InstanceConfig.SiteURL) == "" {
+		return errors.New("site_url must be set to host feeds")
+	}
+
+	if err := c.ServerConfig.checkApprovalNotifyURL(); err != nil {
+		return err
+	}
+
+	if c.ServerConfig.ActivityPubEnabled {
+		if strings.TrimSpace(c.InstanceConfig.SiteURL) == "" {
+			return errors.New("site_url must be set to enable activitypub")
+		}
+		if strings.TrimSpace(c.ServerConfig.ActivityPubKeyPath) == "" {
+			return errors.New("activitypub_key_path must be set to enable activitypub")
+		}
+		key, err := activitypub.LoadOrCreateKey(c.ServerConfig.ActivityPubKeyPath)
+		if err != nil {
+			return fmt.Errorf("when loading activitypub key: %w", err)
+		}
+		c.ServerConfig.ActivityPubKey = key
+	}
+
+	if len(c.ServerConfig.NostrRelays) > 0 {
+		for _, relay := range c.ServerConfig.NostrRelays {
+			if !strings.HasPrefix(relay, "wss://") && !strings.HasPrefix(relay, "ws://") {
+				return fmt.Errorf("nostr relay must be a ws:// or wss:// URL: %s", relay)
+			}
+		}
+		if strings.TrimSpace(c.ServerConfig.NostrKeyPath) == "" {
+			return errors.New("nostr_key_path must be set to publish to nostr relays")
+		}
+		secret, err := nostr.LoadOrCreateSecret(c.ServerConfig.NostrKeyPath)
+		if err != nil {
+			return fmt.Errorf("when loading nostr secret: %w", err)
+		}
+		c.ServerConfig.NostrSecret = secret
+	}
+
+	if c.Federation.AnnounceURL != "" {
+		if !strings.HasPrefix(c.Federation.AnnounceURL, "https://") && !strings.HasPrefix(c.Federation.AnnounceURL, "http://") {
+			return fmt.Errorf("announce_url must be an http:// or https:// URL: %s", c.Federation.AnnounceURL)
+		}
+		if strings.TrimSpace(c.Federation.AnnounceNick) == "" {
+			return errors.New("announce_nick must be set to announce this registry")
+		}
+	}
+	if len(c.Federation.Peers) > 0 || c.Federation.AnnounceURL != "" {
+		for _, peer := range c.Federation.Peers {
+			if !strings.HasPrefix(peer, "https://") && !strings.HasPrefix(peer, "http://") {
+				return fmt.Errorf("federation peer must be an http:// or https:// URL: %s", peer)
+			}
+		}
+		if strings.TrimSpace(c.Federation.IntervalStr) == "" {
+			c.Federation.IntervalStr = defaultFederationInterval
+		}
+		federationInterval, err := time.ParseDuration(c.Federation.IntervalStr)
+		if err != nil {
+			return fmt.Errorf("when parsing federation interval: %w", err)
+		}
+		c.Federation.Interval = federationInterval
+		if c.Federation.SharedSecret != "" && strings.TrimSpace(c.InstanceConfig.SiteURL) == "" {
+			return errors.New("site_url must be set to push users to federation peers")
+		}
+	}
+
+	if len(c.ContentFilter.Rules) > 0 {
+		filter := &registry.ContentFilter{}
+		for i, rc := range c.ContentFilter.Rules {
+			if strings.TrimSpace(rc.Name) == "" {
+				c.ContentFilter.Rules[i].Name = fmt.Sprintf("rule %d", i+1)
+				rc.Name = c.ContentFilter.Rules[i].Name
+			}
+			rule, err := registry.NewFilterRule(rc.Name, rc.Action, rc.Keywords, rc.Pattern, rc.Domains)
+			if err != nil {
+				return fmt.Errorf("when parsing content filter rules: %w", err)
+			}
+			filter.Rules = append(filter.Rules, rule)
+		}
+		c.ContentFilter.Filter = filter
+	}
+
+	if strings.TrimSpace(c.PublicArchive.Path) != "" {
+		if strings.TrimSpace(c.PublicArchive.IntervalStr) == "" {
+			c.PublicArchive.IntervalStr = defaultPublicArchiveInterval
+		}
+		archiveInterval, err := time.ParseDuration(c.PublicArchive.IntervalStr)
+		if err != nil {
+			return fmt.Errorf("when parsing public archive interval: %w", err)
+		}
+		if archiveInterval < minPublicArchiveInterval {
+			return fmt.Errorf("public archive interval can't be less than %s", minPublicArchiveInterval)
+		}
+		c.PublicArchive.Interval = archiveInterval
+		if c.PublicArchive.DownloadsPerHour < 1 {
+			c.PublicArchive.DownloadsPerHour = defaultPublicArchiveDownloads
+		}
+	}
+
+	if err := c.Retention.parse(); err != nil {
+		return err
+	}
+
+	if err := c.Avatars.parse(); err != nil {
+		return err
+	}
+
+	if err := c.Blocklists.parse(); err != nil {
+		return err
+	}
+
+	if err := c.parseRegistryHosts(); err != nil {
+		return err
+	}
+
+	if err := c.ServerConfig.parseAdminListen(); err != nil {
+		return err
+	}
+
 	msgLogFd, err := os.OpenFile(c.ServerConfig.MessageLogPath, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
 	if err != nil {
 		return fmt.Errorf("when opening message log file: %w", err)
@@ -151,16 +462,41 @@ func (c *Config) parse() error {
 		return fmt.Errorf("couldn't read json docs template at %s: %w", c.ServerConfig.TemplatePathJSONDocs, err)
 	}
 
+	tweetTmpl, err := parseTweetTemplate(c.ServerConfig)
+	if err != nil {
+		return err
+	}
+
 	cssBytes, err := os.ReadFile(c.ServerConfig.StylesheetPath)
 	if err != nil {
 		return fmt.Errorf("couldn't read stylesheet at %s: %w", c.ServerConfig.StylesheetPath, err)
 	}
 
+	regPlainTmpl, err := parseRegistrationTemplate(c.ServerConfig.TemplatePathRegPlain, defaultRegistrationPlain)
+	if err != nil {
+		return err
+	}
+
+	regJSONTmpl, err := parseRegistrationTemplate(c.ServerConfig.TemplatePathRegJSON, defaultRegistrationJSON)
+	if err != nil {
+		return err
+	}
+
 	c.Assets = Assets{
-		IndexTemplate:     indexTmpl,
-		PlainDocsTemplate: plainTmpl,
-		JSONDocsTemplate:  jsonTmpl,
-		Stylesheet:        cssBytes,
+		IndexTemplate:             indexTmpl,
+		PlainDocsTemplate:         plainTmpl,
+		JSONDocsTemplate:          jsonTmpl,
+		TweetTemplate:             tweetTmpl,
+		Stylesheet:                cssBytes,
+		RegistrationPlainTemplate: regPlainTmpl,
+		RegistrationJSONTemplate:  regJSONTmpl,
+	}
+	if strings.TrimSpace(c.ServerConfig.LocalesPath) != "" {
+		catalogs, err := loadCatalogs(c.ServerConfig.LocalesPath, c.ServerConfig.DefaultLanguage)
+		if err != nil {
+			return err
+		}
+		c.Assets.Catalogs = catalogs
 	}
 
 	c.InstanceConfig.Version = common.Version
@@ -168,6 +504,327 @@ func (c *Config) parse() error {
 	return nil
 }
 
+// admin_password_hash, as written by adminPassGen -write-config, takes precedence over admin_password.
+func (sc *ServerConfig) resolveAdminPassword() {
+	if hash := strings.TrimSpace(sc.AdminPasswordHash); hash != "" {
+		sc.AdminPassword = hash
+	}
+}
+
+// maxArchiveDepth caps how many archived files are fetched when a user registers, however archive_depth is set.
+const maxArchiveDepth = 50
+
+// defaultQueryTimeout bounds each registry read when query_timeout isn't set.
+const defaultQueryTimeout = "10s"
+
+// defaultRequestTimeout bounds the database work of each request when request_timeout isn't set.
+const defaultRequestTimeout = "20s"
+
+// httpWriteTimeout is how long the server has to write a response. request_timeout has to be
+// shorter, so there's time left to tell the client the request timed out.
+const httpWriteTimeout = 30 * time.Second
+
+// parseRequestTimeout reads request_timeout, filling in the default if it's empty.
+func (sc *ServerConfig) parseRequestTimeout() (time.Duration, error) {
+	s := sc.RequestTimeoutStr
+	if strings.TrimSpace(s) == "" {
+		s = defaultRequestTimeout
+	}
+	timeout, err := time.ParseDuration(s)
+	if err != nil {
+		return 0, fmt.Errorf("when parsing request timeout: %w", err)
+	}
+	if timeout < 0 {
+		return 0, errors.New("request_timeout can't be negative")
+	}
+	if timeout >= httpWriteTimeout {
+		return 0, fmt.Errorf("request_timeout must be less than the %s write timeout", httpWriteTimeout)
+	}
+	return timeout, nil
+}
+
+// defaultDeletionGrace is how long users who delete themselves are kept when deletion_grace_period isn't set.
+const defaultDeletionGrace = "7d"
+
+// parseDeletionGrace reads deletion_grace_period, filling in the default if it's empty.
+func (sc *ServerConfig) parseDeletionGrace() (time.Duration, error) {
+	s := sc.DeletionGraceStr
+	if strings.TrimSpace(s) == "" {
+		s = defaultDeletionGrace
+	}
+	grace, err := common.ParseDuration(s)
+	if err != nil {
+		return 0, fmt.Errorf("when parsing deletion grace period: %w", err)
+	}
+	if grace < 0 {
+		return 0, errors.New("deletion_grace_period can't be negative")
+	}
+	return grace, nil
+}
+
+// Values of timestamp_precision.
+const (
+	timestampSeconds     = "seconds"
+	timestampNanoseconds = "nanoseconds"
+)
+
+// parsePlainFormat reads how timestamps and control characters are written in plain output.
+// An empty display_timezone leaves timestamps in the timezone they're stored with.
+func (sc *ServerConfig) parsePlainFormat() (registry.PlainFormat, error) {
+	f := registry.PlainFormat{}
+
+	controlChars, err := registry.ParseControlCharMode(sc.ControlCharsStr)
+	if err != nil {
+		return f, fmt.Errorf("when parsing plain control character mode: %w", err)
+	}
+	f.ControlChars = controlChars
+
+	if tz := strings.TrimSpace(sc.DisplayTimezone); tz != "" {
+		loc, err := time.LoadLocation(tz)
+		if err != nil {
+			return f, fmt.Errorf("when parsing display timezone: %w", err)
+		}
+		f.Location = loc
+	}
+
+	switch strings.ToLower(strings.TrimSpace(sc.TimestampPrecision)) {
+	case "", timestampSeconds:
+	case timestampNanoseconds:
+		f.Nanoseconds = true
+	default:
+		return f, fmt.Errorf("timestamp_precision must be %s or %s, got: %s", timestampSeconds, timestampNanoseconds, sc.TimestampPrecision)
+	}
+
+	return f, nil
+}
+
+// parseFetchTuning reads the settings of the client that fetches twtxt files.
+// Anything left unset keeps the registry's default.
+func (sc *ServerConfig) parseFetchTuning() (registry.HTTPTuning, error) {
+	tuning := registry.HTTPTuning{
+		MaxIdleConnsPerHost: sc.FetchIdleConnsPerHost,
+		DisableKeepAlives:   sc.FetchNoKeepAlives,
+	}
+	if tuning.MaxIdleConnsPerHost < 0 {
+		return tuning, errors.New("fetch_max_idle_conns_per_host can't be negative")
+	}
+	durations := []struct {
+		name string
+		str  string
+		dst  *time.Duration
+	}{
+		{"fetch_timeout", sc.FetchTimeoutStr, &tuning.Timeout},
+		{"fetch_tls_handshake_timeout", sc.FetchTLSTimeoutStr, &tuning.TLSHandshakeTimeout},
+		{"fetch_idle_conn_timeout", sc.FetchIdleTimeoutStr, &tuning.IdleConnTimeout},
+	}
+	for _, d := range durations {
+		if strings.TrimSpace(d.str) == "" {
+			continue
+		}
+		parsed, err := time.ParseDuration(d.str)
+		if err != nil {
+			return tuning, fmt.Errorf("when parsing %s: %w", d.name, err)
+		}
+		if parsed <= 0 {
+			return tuning, fmt.Errorf("%s must be positive", d.name)
+		}
+		*d.dst = parsed
+	}
+	return tuning, nil
+}
+
+// redactedSecret replaces secrets in the printed configuration.
+const redactedSecret = "[redacted]"
+
+// effectiveConfig mirrors the config file's layout with the values the server actually uses.
+type effectiveConfig struct {
+	ServerConfig struct {
+		AdminPassword         string   `toml:"admin_password" json:"admin_password"`
+		IP                    string   `toml:"bind_ip" json:"bind_ip"`
+		Port                  string   `toml:"port" json:"port"`
+		AdminListen           string   `toml:"admin_listen" json:"admin_listen"`
+		DatabasePath          string   `toml:"database_path" json:"database_path"`
+		MessageLogPath        string   `toml:"message_log" json:"message_log"`
+		RequestLogPath        string   `toml:"request_log" json:"request_log"`
+		FetchInterval         string   `toml:"fetch_interval" json:"fetch_interval"`
+		FetchTimeout          string   `toml:"fetch_timeout,omitempty" json:"fetch_timeout,omitempty"`
+		FetchTLSTimeout       string   `toml:"fetch_tls_handshake_timeout,omitempty" json:"fetch_tls_handshake_timeout,omitempty"`
+		FetchIdleTimeout      string   `toml:"fetch_idle_conn_timeout,omitempty" json:"fetch_idle_conn_timeout,omitempty"`
+		FetchIdleConnsPerHost int      `toml:"fetch_max_idle_conns_per_host,omitempty" json:"fetch_max_idle_conns_per_host,omitempty"`
+		FetchNoKeepAlives     bool     `toml:"fetch_disable_keep_alives" json:"fetch_disable_keep_alives"`
+		QueryTimeout          string   `toml:"query_timeout" json:"query_timeout"`
+		RequestTimeout        string   `toml:"request_timeout" json:"request_timeout"`
+		DeletionGrace         string   `toml:"deletion_grace_period" json:"deletion_grace_period"`
+		TemplatePathIndex     string   `toml:"template_path_index" json:"template_path_index"`
+		TemplatePathPlainDocs string   `toml:"template_path_plain_docs" json:"template_path_plain_docs"`
+		TemplatePathJSONDocs  string   `toml:"template_path_json_docs" json:"template_path_json_docs"`
+		TemplatePathRegPlain  string   `toml:"template_path_registration_plain" json:"template_path_registration_plain"`
+		TemplatePathRegJSON   string   `toml:"template_path_registration_json" json:"template_path_registration_json"`
+		TemplatePathTweet     string   `toml:"template_path_tweet" json:"template_path_tweet"`
+		StylesheetPath        string   `toml:"stylesheet_path" json:"stylesheet_path"`
+		LocalesPath           string   `toml:"locales_path" json:"locales_path"`
+		DefaultLanguage       string   `toml:"default_language" json:"default_language"`
+		EntriesPerPageMax     int      `toml:"entries_per_page_max" json:"entries_per_page_max"`
+		EntriesPerPageMin     int      `toml:"entries_per_page_min" json:"entries_per_page_min"`
+		DedupeMode            string   `toml:"dedupe_mode" json:"dedupe_mode"`
+		CollapseMirrors       bool     `toml:"collapse_mirrored_tweets" json:"collapse_mirrored_tweets"`
+		SchemePolicy          string   `toml:"feed_scheme_policy" json:"feed_scheme_policy"`
+		ControlChars          string   `toml:"plain_control_chars" json:"plain_control_chars"`
+		DisplayTimezone       string   `toml:"display_timezone" json:"display_timezone"`
+		TimestampPrecision    string   `toml:"timestamp_precision" json:"timestamp_precision"`
+		JSONEnvelope          bool     `toml:"json_envelope" json:"json_envelope"`
+		SpecCompliant         bool     `toml:"spec_compliant" json:"spec_compliant"`
+		ArchiveDepth          int      `toml:"archive_depth" json:"archive_depth"`
+		StatsRetentionDays    int      `toml:"stats_retention_days" json:"stats_retention_days"`
+		HostedFeeds           bool     `toml:"hosted_feeds" json:"hosted_feeds"`
+		RequireApproval       bool     `toml:"require_approval" json:"require_approval"`
+		ApprovalNotifyURL     string   `toml:"approval_notify_url" json:"approval_notify_url"`
+		HTTPRequestsPerMinute int      `toml:"http_requests_per_minute" json:"http_requests_per_minute"`
+		HTTPRequestsBurstMax  int      `toml:"http_requests_max_burst" json:"http_requests_max_burst"`
+		ActivityPubEnabled    bool     `toml:"activitypub_enabled" json:"activitypub_enabled"`
+		ActivityPubKeyPath    string   `toml:"activitypub_key_path" json:"activitypub_key_path"`
+		NostrRelays           []string `toml:"nostr_relays" json:"nostr_relays"`
+		NostrKeyPath          string   `toml:"nostr_key_path" json:"nostr_key_path"`
+		DebugMode             bool     `toml:"debug_mode" json:"debug_mode"`
+	} `toml:"server_config" json:"server_config"`
+	InstanceConfig InstanceConfig `toml:"instance_info" json:"instance_info"`
+	Federation     struct {
+		Peers        []string `toml:"peers" json:"peers"`
+		Interval     string   `toml:"interval" json:"interval"`
+		SharedSecret string   `toml:"shared_secret" json:"shared_secret"`
+		AnnounceNick string   `toml:"announce_nick" json:"announce_nick"`
+		AnnounceURL  string   `toml:"announce_url" json:"announce_url"`
+	} `toml:"federation" json:"federation"`
+	ContentFilter struct {
+		Rules []FilterRule `toml:"rules" json:"rules"`
+	} `toml:"content_filter" json:"content_filter"`
+	PublicArchive struct {
+		Path             string `toml:"path" json:"path"`
+		Interval         string `toml:"interval" json:"interval"`
+		DownloadsPerHour int    `toml:"downloads_per_hour" json:"downloads_per_hour"`
+	} `toml:"public_archive" json:"public_archive"`
+	Retention struct {
+		MaxTweetAge          string `toml:"max_tweet_age" json:"max_tweet_age"`
+		MaxTweetsPerUser     int    `toml:"max_tweets_per_user" json:"max_tweets_per_user"`
+		PurgeHiddenAfterDays int    `toml:"purge_hidden_after_days" json:"purge_hidden_after_days"`
+		Interval             string `toml:"interval" json:"interval"`
+	} `toml:"retention" json:"retention"`
+	Avatars struct {
+		MaxBytes     int64  `toml:"max_bytes" json:"max_bytes"`
+		CacheTTL     string `toml:"cache_ttl" json:"cache_ttl"`
+		CacheEntries int    `toml:"cache_entries" json:"cache_entries"`
+	} `toml:"avatars" json:"avatars"`
+	Blocklists struct {
+		URLs     []string `toml:"urls" json:"urls"`
+		Interval string   `toml:"interval" json:"interval"`
+	} `toml:"blocklists" json:"blocklists"`
+	Registries []RegistryHost `toml:"registries" json:"registries"`
+}
+
+// printEffective writes the parsed configuration, with secrets redacted, as toml or json.
+func (c *Config) printEffective(w io.Writer, format string) error {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	out := effectiveConfig{}
+	sc := c.ServerConfig
+	if sc.AdminPassword != "" {
+		out.ServerConfig.AdminPassword = redactedSecret
+	}
+	out.ServerConfig.IP = sc.IP
+	out.ServerConfig.Port = sc.Port
+	out.ServerConfig.AdminListen = sc.AdminListen
+	out.ServerConfig.DatabasePath = sc.DatabasePath
+	out.ServerConfig.MessageLogPath = sc.MessageLogPath
+	out.ServerConfig.RequestLogPath = sc.RequestLogPath
+	out.ServerConfig.FetchInterval = sc.FetchInterval.String()
+	if sc.FetchTuning.Timeout > 0 {
+		out.ServerConfig.FetchTimeout = sc.FetchTuning.Timeout.String()
+	}
+	if sc.FetchTuning.TLSHandshakeTimeout > 0 {
+		out.ServerConfig.FetchTLSTimeout = sc.FetchTuning.TLSHandshakeTimeout.String()
+	}
+	if sc.FetchTuning.IdleConnTimeout > 0 {
+		out.ServerConfig.FetchIdleTimeout = sc.FetchTuning.IdleConnTimeout.String()
+	}
+	out.ServerConfig.FetchIdleConnsPerHost = sc.FetchTuning.MaxIdleConnsPerHost
+	out.ServerConfig.FetchNoKeepAlives = sc.FetchTuning.DisableKeepAlives
+	out.ServerConfig.QueryTimeout = sc.QueryTimeout.String()
+	out.ServerConfig.RequestTimeout = sc.RequestTimeout.String()
+	out.ServerConfig.DeletionGrace = sc.DeletionGrace.String()
+	out.ServerConfig.TemplatePathIndex = sc.TemplatePathIndex
+	out.ServerConfig.TemplatePathPlainDocs = sc.TemplatePathPlainDocs
+	out.ServerConfig.TemplatePathJSONDocs = sc.TemplatePathJSONDocs
+	out.ServerConfig.TemplatePathRegPlain = sc.TemplatePathRegPlain
+	out.ServerConfig.TemplatePathRegJSON = sc.TemplatePathRegJSON
+	out.ServerConfig.TemplatePathTweet = sc.TemplatePathTweet
+	out.ServerConfig.StylesheetPath = sc.StylesheetPath
+	out.ServerConfig.LocalesPath = sc.LocalesPath
+	out.ServerConfig.DefaultLanguage = sc.DefaultLanguage
+	out.ServerConfig.EntriesPerPageMax = sc.EntriesPerPageMax
+	out.ServerConfig.EntriesPerPageMin = sc.EntriesPerPageMin
+	out.ServerConfig.DedupeMode = string(sc.DedupeMode)
+	out.ServerConfig.CollapseMirrors = sc.CollapseMirrors
+	out.ServerConfig.SchemePolicy = string(sc.SchemePolicy)
+	out.ServerConfig.ControlChars = string(sc.PlainFormat.ControlChars)
+	if sc.PlainFormat.Location != nil {
+		out.ServerConfig.DisplayTimezone = sc.PlainFormat.Location.String()
+	}
+	out.ServerConfig.TimestampPrecision = timestampSeconds
+	if sc.PlainFormat.Nanoseconds {
+		out.ServerConfig.TimestampPrecision = timestampNanoseconds
+	}
+	out.ServerConfig.JSONEnvelope = sc.JSONEnvelope
+	out.ServerConfig.SpecCompliant = sc.SpecCompliant
+	out.ServerConfig.ArchiveDepth = sc.ArchiveDepth
+	out.ServerConfig.StatsRetentionDays = sc.StatsRetentionDays
+	out.ServerConfig.HostedFeeds = sc.HostedFeeds
+	out.ServerConfig.RequireApproval = sc.RequireApproval
+	if sc.ApprovalNotifyURL != "" {
+		out.ServerConfig.ApprovalNotifyURL = redactedSecret
+	}
+	out.ServerConfig.HTTPRequestsPerMinute = sc.HTTPRequestsPerMinute
+	out.ServerConfig.HTTPRequestsBurstMax = sc.HTTPRequestsBurstMax
+	out.ServerConfig.ActivityPubEnabled = sc.ActivityPubEnabled
+	out.ServerConfig.ActivityPubKeyPath = sc.ActivityPubKeyPath
+	out.ServerConfig.NostrRelays = sc.NostrRelays
+	out.ServerConfig.NostrKeyPath = sc.NostrKeyPath
+	out.ServerConfig.DebugMode = sc.DebugMode
+	out.InstanceConfig = c.InstanceConfig
+	out.Federation.Peers = c.Federation.Peers
+	out.Federation.Interval = c.Federation.Interval.String()
+	if c.Federation.SharedSecret != "" {
+		out.Federation.SharedSecret = redactedSecret
+	}
+	out.Federation.AnnounceNick = c.Federation.AnnounceNick
+	out.Federation.AnnounceURL = c.Federation.AnnounceURL
+	out.ContentFilter.Rules = c.ContentFilter.Rules
+	out.PublicArchive.Path = c.PublicArchive.Path
+	out.PublicArchive.Interval = c.PublicArchive.Interval.String()
+	out.PublicArchive.DownloadsPerHour = c.PublicArchive.DownloadsPerHour
+	out.Retention.MaxTweetAge = c.Retention.MaxTweetAgeStr
+	out.Retention.MaxTweetsPerUser = c.Retention.MaxTweetsPerUser
+	out.Retention.PurgeHiddenAfterDays = c.Retention.PurgeHiddenAfterDays
+	out.Retention.Interval = c.Retention.Interval.String()
+	out.Avatars.MaxBytes = c.Avatars.MaxBytes
+	out.Avatars.CacheTTL = c.Avatars.CacheTTLStr
+	out.Avatars.CacheEntries = c.Avatars.CacheEntries
+	out.Blocklists.URLs = c.Blocklists.URLs
+	out.Blocklists.Interval = c.Blocklists.Interval.String()
+	out.Registries = c.Registries
+
+	switch format {
+	case "toml":
+		return toml.NewEncoder(w).Encode(out)
+	case "json":
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		return enc.Encode(out)
+	default:
+		return fmt.Errorf("unknown config format %s, expected toml or json", format)
+	}
+}
+
 // Reloads "safe" configuration options.
 // To be called on SIGHUP.
 func (c *Config) reload(path string, logger *log.Logger) error {
@@ -178,9 +835,12 @@ func (c *Config) reload(path string, logger *log.Logger) error {
 		return fmt.Errorf("while reloading config: %w", err)
 	}
 
+	newConf.ServerConfig.resolveAdminPassword()
 	if strings.TrimSpace(newConf.ServerConfig.AdminPassword) == "" {
 		return errors.New("please set admin_password in the configuration file")
 	}
+	c.ServerConfig.AdminPassword = newConf.ServerConfig.AdminPassword
+	c.ServerConfig.AdminPasswordHash = newConf.ServerConfig.AdminPasswordHash
 
 	if newConf.ServerConfig.MessageLogPath != c.ServerConfig.MessageLogPath {
 		msgLogFd, err := os.OpenFile(newConf.ServerConfig.MessageLogPath, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
@@ -204,9 +864,61 @@ func (c *Config) reload(path string, logger *log.Logger) error {
 		c.ServerConfig.FetchInterval = fetchInterval
 	}
 
+	requestTimeout, err := newConf.ServerConfig.parseRequestTimeout()
+	if err != nil {
+		logger.Infof("Couldn't parse new request timeout when reloading config: %s", err)
+	} else {
+		c.ServerConfig.RequestTimeout = requestTimeout
+	}
+
+	deletionGrace, err := newConf.ServerConfig.parseDeletionGrace()
+	if err != nil {
+		logger.Infof("Couldn't parse new deletion grace period when reloading config: %s", err)
+	} else {
+		c.ServerConfig.DeletionGrace = deletionGrace
+	}
+
+	if newConf.ServerConfig.SpecCompliant && c.ServerConfig.EntriesPerPageMin > specPageSize {
+		logger.Infof("Not enabling spec_compliant on reload: entries_per_page_min is more than %d", specPageSize)
+	} else {
+		c.ServerConfig.SpecCompliant = newConf.ServerConfig.SpecCompliant
+	}
+
+	c.ServerConfig.RequireApproval = newConf.ServerConfig.RequireApproval
+	if err := newConf.ServerConfig.checkApprovalNotifyURL(); err != nil {
+		logger.Infof("Not changing approval_notify_url on reload: %s", err)
+	} else {
+		c.ServerConfig.ApprovalNotifyURL = newConf.ServerConfig.ApprovalNotifyURL
+	}
+
+	plainFormat, err := newConf.ServerConfig.parsePlainFormat()
+	if err != nil {
+		logger.Infof("Couldn't parse new plain output format when reloading config: %s", err)
+	} else {
+		c.ServerConfig.PlainFormat = plainFormat
+	}
+	c.ServerConfig.JSONEnvelope = newConf.ServerConfig.JSONEnvelope
+	c.ServerConfig.StatsRetentionDays = newConf.ServerConfig.StatsRetentionDays
+	if c.ServerConfig.StatsRetentionDays < 1 {
+		c.ServerConfig.StatsRetentionDays = defaultStatsRetentionDays
+	}
+
+	// The limits take effect on the next run, but the interval stays as it was.
+	if err := newConf.Retention.parse(); err != nil {
+		logger.Infof("Couldn't parse new retention policy when reloading config: %s", err)
+	} else {
+		c.Retention.MaxTweetAgeStr = newConf.Retention.MaxTweetAgeStr
+		c.Retention.MaxTweetAge = newConf.Retention.MaxTweetAge
+		c.Retention.MaxTweetsPerUser = newConf.Retention.MaxTweetsPerUser
+		c.Retention.PurgeHiddenAfterDays = newConf.Retention.PurgeHiddenAfterDays
+	}
+
 	c.ServerConfig.TemplatePathIndex = newConf.ServerConfig.TemplatePathIndex
 	c.ServerConfig.TemplatePathPlainDocs = newConf.ServerConfig.TemplatePathPlainDocs
 	c.ServerConfig.TemplatePathJSONDocs = newConf.ServerConfig.TemplatePathJSONDocs
+	c.ServerConfig.TemplatePathRegPlain = newConf.ServerConfig.TemplatePathRegPlain
+	c.ServerConfig.TemplatePathRegJSON = newConf.ServerConfig.TemplatePathRegJSON
+	c.ServerConfig.TemplatePathTweet = newConf.ServerConfig.TemplatePathTweet
 	c.ServerConfig.StylesheetPath = newConf.ServerConfig.StylesheetPath
 
 	newIndexTemplate, err := template.ParseFiles(newConf.ServerConfig.TemplatePathIndex)
@@ -230,6 +942,27 @@ func (c *Config) reload(path string, logger *log.Logger) error {
 		c.Assets.JSONDocsTemplate = newJSONDocsTemplate
 	}
 
+	newTweetTemplate, err := parseTweetTemplate(newConf.ServerConfig)
+	if err != nil {
+		logger.Errorf("Couldn't read new tweet template: %s", err)
+	} else {
+		c.Assets.TweetTemplate = newTweetTemplate
+	}
+
+	newRegPlainTemplate, err := parseRegistrationTemplate(newConf.ServerConfig.TemplatePathRegPlain, defaultRegistrationPlain)
+	if err != nil {
+		logger.Errorf("Couldn't read new plain registration template: %s", err)
+	} else {
+		c.Assets.RegistrationPlainTemplate = newRegPlainTemplate
+	}
+
+	newRegJSONTemplate, err := parseRegistrationTemplate(newConf.ServerConfig.TemplatePathRegJSON, defaultRegistrationJSON)
+	if err != nil {
+		logger.Errorf("Couldn't read new json registration template: %s", err)
+	} else {
+		c.Assets.RegistrationJSONTemplate = newRegJSONTemplate
+	}
+
 	newStylesheet, err := os.ReadFile(newConf.ServerConfig.StylesheetPath)
 	if err != nil {
 		logger.Errorf("Couldn't read new stylesheet data")
@@ -237,8 +970,19 @@ func (c *Config) reload(path string, logger *log.Logger) error {
 		c.Assets.Stylesheet = newStylesheet
 	}
 
+	if strings.TrimSpace(newConf.ServerConfig.LocalesPath) == "" {
+		c.Assets.Catalogs = nil
+	} else if newCatalogs, err := loadCatalogs(newConf.ServerConfig.LocalesPath, newConf.ServerConfig.DefaultLanguage); err != nil {
+		logger.Errorf("Couldn't read new message catalogs: %s", err)
+	} else {
+		c.Assets.Catalogs = newCatalogs
+	}
+	c.ServerConfig.LocalesPath = newConf.ServerConfig.LocalesPath
+	c.ServerConfig.DefaultLanguage = newConf.ServerConfig.DefaultLanguage
+
 	c.ServerConfig.EntriesPerPageMax = newConf.ServerConfig.EntriesPerPageMax
 	c.ServerConfig.EntriesPerPageMin = newConf.ServerConfig.EntriesPerPageMin
+	c.ServerConfig.ArchiveDepth = newConf.ServerConfig.ArchiveDepth
 	c.InstanceConfig = newConf.InstanceConfig
 
 	if c.ServerConfig.EntriesPerPageMax < 20 {
@@ -247,6 +991,12 @@ func (c *Config) reload(path string, logger *log.Logger) error {
 	if c.ServerConfig.EntriesPerPageMin < 10 {
 		c.ServerConfig.EntriesPerPageMin = 10
 	}
+	if c.ServerConfig.ArchiveDepth < 0 {
+		c.ServerConfig.ArchiveDepth = 0
+	}
+	if c.ServerConfig.ArchiveDepth > maxArchiveDepth {
+		c.ServerConfig.ArchiveDepth = maxArchiveDepth
+	}
 
 	if c.ServerConfig.DebugMode {
 		logger.SetLevel(log.DebugLevel)
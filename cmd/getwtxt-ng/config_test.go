@@ -19,13 +19,20 @@ along with getwtxt-ng.  If not, see <https://www.gnu.org/licenses/>.
 package main
 
 import (
+	"bytes"
 	"crypto/rand"
+	"encoding/json"
+	"errors"
 	"fmt"
 	"os"
+	"path/filepath"
 	"strings"
 	"testing"
+	"time"
 
 	log "github.com/sirupsen/logrus"
+
+	"github.com/gbmor/getwtxt-ng/registry"
 )
 
 func Test_readConfig(t *testing.T) {
@@ -101,6 +108,240 @@ func TestConfig_parse(t *testing.T) {
 			t.Errorf("Expected error regarding unset admin_password, got: %s", err)
 		}
 	})
+	t.Run("admin_password_hash takes precedence", func(t *testing.T) {
+		conf := &Config{
+			ServerConfig: ServerConfig{
+				AdminPassword:     "hunter2",
+				AdminPasswordHash: "hunter3",
+				FetchIntervalStr:  "3kg",
+			},
+		}
+		_ = conf.parse()
+		if conf.ServerConfig.AdminPassword != "hunter3" {
+			t.Errorf("Expected admin_password_hash to be used, got: %s", conf.ServerConfig.AdminPassword)
+		}
+	})
+	t.Run("invalid dedupe mode", func(t *testing.T) {
+		conf := &Config{
+			ServerConfig: ServerConfig{
+				AdminPassword:    "hunter2",
+				FetchIntervalStr: "1h",
+				DedupeModeStr:    "fuzzy",
+			},
+		}
+		if err := conf.parse(); !errors.Is(err, registry.ErrInvalidDedupeMode) {
+			t.Errorf("Expected ErrInvalidDedupeMode, got: %v", err)
+		}
+	})
+	t.Run("invalid feed scheme policy", func(t *testing.T) {
+		conf := &Config{
+			ServerConfig: ServerConfig{
+				AdminPassword:    "hunter2",
+				FetchIntervalStr: "1h",
+				SchemePolicyStr:  "tls",
+			},
+		}
+		if err := conf.parse(); !errors.Is(err, registry.ErrInvalidSchemePolicy) {
+			t.Errorf("Expected ErrInvalidSchemePolicy, got: %v", err)
+		}
+	})
+	t.Run("invalid plain control character mode", func(t *testing.T) {
+		conf := &Config{
+			ServerConfig: ServerConfig{
+				AdminPassword:    "hunter2",
+				FetchIntervalStr: "1h",
+				ControlCharsStr:  "strip",
+			},
+		}
+		if err := conf.parse(); !errors.Is(err, registry.ErrInvalidControlCharMode) {
+			t.Errorf("Expected ErrInvalidControlCharMode, got: %v", err)
+		}
+	})
+	t.Run("plain format", func(t *testing.T) {
+		sc := ServerConfig{DisplayTimezone: "UTC", TimestampPrecision: "nanoseconds"}
+		f, err := sc.parsePlainFormat()
+		if err != nil {
+			t.Fatal(err.Error())
+		}
+		if f.Location != time.UTC || !f.Nanoseconds || f.ControlChars != registry.ControlCharsEscape {
+			t.Errorf("Unexpected plain format: %+v", f)
+		}
+		for _, bad := range []ServerConfig{{DisplayTimezone: "Mars/Olympus_Mons"}, {TimestampPrecision: "minutes"}} {
+			if _, err := bad.parsePlainFormat(); err == nil {
+				t.Errorf("Expected error parsing %+v", bad)
+			}
+		}
+	})
+	t.Run("activitypub without site_url", func(t *testing.T) {
+		conf := &Config{
+			ServerConfig: ServerConfig{
+				AdminPassword:      "hunter2",
+				FetchIntervalStr:   "1h",
+				ActivityPubEnabled: true,
+				ActivityPubKeyPath: filepath.Join(t.TempDir(), "ap.pem"),
+			},
+		}
+		if err := conf.parse(); err == nil || !strings.Contains(err.Error(), "site_url") {
+			t.Errorf("Expected error regarding site_url, got: %v", err)
+		}
+	})
+	t.Run("invalid nostr relay", func(t *testing.T) {
+		conf := &Config{
+			ServerConfig: ServerConfig{
+				AdminPassword:    "hunter2",
+				FetchIntervalStr: "1h",
+				NostrRelays:      []string{"https://relay.example.com"},
+				NostrKeyPath:     filepath.Join(t.TempDir(), "nostr.key"),
+			},
+		}
+		if err := conf.parse(); err == nil || !strings.Contains(err.Error(), "nostr relay") {
+			t.Errorf("Expected error regarding nostr relay, got: %v", err)
+		}
+	})
+	t.Run("invalid federation peer", func(t *testing.T) {
+		conf := &Config{
+			ServerConfig: ServerConfig{
+				AdminPassword:    "hunter2",
+				FetchIntervalStr: "1h",
+			},
+			Federation: Federation{
+				Peers: []string{"gopher://registry.example.com"},
+			},
+		}
+		if err := conf.parse(); err == nil || !strings.Contains(err.Error(), "federation peer") {
+			t.Errorf("Expected error regarding federation peer, got: %v", err)
+		}
+	})
+	t.Run("invalid federation interval", func(t *testing.T) {
+		conf := &Config{
+			ServerConfig: ServerConfig{
+				AdminPassword:    "hunter2",
+				FetchIntervalStr: "1h",
+			},
+			Federation: Federation{
+				Peers:       []string{"https://registry.example.com"},
+				IntervalStr: "fortnightly",
+			},
+		}
+		if err := conf.parse(); err == nil || !strings.Contains(err.Error(), "federation interval") {
+			t.Errorf("Expected error regarding federation interval, got: %v", err)
+		}
+	})
+	t.Run("announce without nick", func(t *testing.T) {
+		conf := &Config{
+			ServerConfig: ServerConfig{
+				AdminPassword:    "hunter2",
+				FetchIntervalStr: "1h",
+			},
+			Federation: Federation{
+				AnnounceURL: "https://registry.example.com/twtxt.txt",
+			},
+		}
+		if err := conf.parse(); err == nil || !strings.Contains(err.Error(), "announce_nick") {
+			t.Errorf("Expected error regarding announce_nick, got: %v", err)
+		}
+	})
+	t.Run("fetch tuning", func(t *testing.T) {
+		sc := &ServerConfig{
+			FetchTimeoutStr:       "1m",
+			FetchIdleConnsPerHost: 8,
+		}
+		tuning, err := sc.parseFetchTuning()
+		if err != nil {
+			t.Fatal(err.Error())
+		}
+		if tuning.Timeout != time.Minute || tuning.MaxIdleConnsPerHost != 8 {
+			t.Errorf("Expected a minute timeout and 8 idle connections per host, got %+v", tuning)
+		}
+		if tuning.TLSHandshakeTimeout != 0 || tuning.IdleConnTimeout != 0 {
+			t.Errorf("Expected unset fetch timeouts to be left to the registry, got %+v", tuning)
+		}
+	})
+	t.Run("invalid query timeout", func(t *testing.T) {
+		conf := &Config{
+			ServerConfig: ServerConfig{
+				AdminPassword:    "hunter2",
+				FetchIntervalStr: "1h",
+				QueryTimeoutStr:  "soon",
+			},
+		}
+		if err := conf.parse(); err == nil || !strings.Contains(err.Error(), "query timeout") {
+			t.Errorf("Expected error regarding query timeout, got: %v", err)
+		}
+	})
+	t.Run("request timeout past write timeout", func(t *testing.T) {
+		conf := &Config{
+			ServerConfig: ServerConfig{
+				AdminPassword:     "hunter2",
+				FetchIntervalStr:  "1h",
+				RequestTimeoutStr: "45s",
+			},
+		}
+		if err := conf.parse(); err == nil || !strings.Contains(err.Error(), "request_timeout") {
+			t.Errorf("Expected error regarding request_timeout, got: %v", err)
+		}
+	})
+	t.Run("invalid content filter rule", func(t *testing.T) {
+		conf := &Config{
+			ServerConfig: ServerConfig{
+				AdminPassword:    "hunter2",
+				FetchIntervalStr: "1h",
+			},
+			ContentFilter: ContentFilter{Rules: []FilterRule{{Keywords: []string{"spam"}, Action: "delete"}}},
+		}
+		if err := conf.parse(); err == nil || !strings.Contains(err.Error(), "content filter") {
+			t.Errorf("Expected error regarding content filter, got: %v", err)
+		}
+	})
+	t.Run("public archive interval too short", func(t *testing.T) {
+		conf := &Config{
+			ServerConfig: ServerConfig{
+				AdminPassword:    "hunter2",
+				FetchIntervalStr: "1h",
+			},
+			PublicArchive: PublicArchive{Path: "archive.tar.gz", IntervalStr: "5m"},
+		}
+		if err := conf.parse(); err == nil || !strings.Contains(err.Error(), "public archive interval") {
+			t.Errorf("Expected error regarding public archive interval, got: %v", err)
+		}
+	})
+	t.Run("invalid retention age", func(t *testing.T) {
+		conf := &Config{
+			ServerConfig: ServerConfig{
+				AdminPassword:    "hunter2",
+				FetchIntervalStr: "1h",
+			},
+			Retention: Retention{MaxTweetAgeStr: "forever"},
+		}
+		if err := conf.parse(); err == nil || !strings.Contains(err.Error(), "max_tweet_age") {
+			t.Errorf("Expected error regarding max_tweet_age, got: %v", err)
+		}
+	})
+	t.Run("invalid fetch timeout", func(t *testing.T) {
+		conf := &Config{
+			ServerConfig: ServerConfig{
+				AdminPassword:      "hunter2",
+				FetchIntervalStr:   "1h",
+				FetchTLSTimeoutStr: "-5s",
+			},
+		}
+		if err := conf.parse(); err == nil || !strings.Contains(err.Error(), "fetch_tls_handshake_timeout") {
+			t.Errorf("Expected error regarding fetch_tls_handshake_timeout, got: %v", err)
+		}
+	})
+	t.Run("spec_compliant with large pages", func(t *testing.T) {
+		conf := &Config{
+			ServerConfig: ServerConfig{
+				AdminPassword:     "hunter2",
+				FetchIntervalStr:  "1h",
+				EntriesPerPageMin: 50,
+				SpecCompliant:     true,
+			},
+		}
+		if err := conf.parse(); err == nil || !strings.Contains(err.Error(), "spec_compliant") {
+			t.Errorf("Expected error regarding spec_compliant, got: %v", err)
+		}
+	})
 	t.Run("invalid fetch interval", func(t *testing.T) {
 		fd, err := os.CreateTemp(os.TempDir(), "getwtxt-ng-test-config")
 		if err != nil {
@@ -270,3 +511,55 @@ func TestConfig_reload(t *testing.T) {
 		}
 	})
 }
+
+func TestConfig_printEffective(t *testing.T) {
+	conf := &Config{
+		ServerConfig: ServerConfig{
+			AdminPassword:     "hunter2",
+			AdminPasswordHash: "hunter2",
+			Port:              "9001",
+			FetchInterval:     90 * time.Minute,
+		},
+		InstanceConfig: InstanceConfig{
+			SiteName: "getwtxt-ng test",
+		},
+	}
+
+	t.Run("toml", func(t *testing.T) {
+		buf := &bytes.Buffer{}
+		if err := conf.printEffective(buf, "toml"); err != nil {
+			t.Fatal(err.Error())
+		}
+		out := buf.String()
+		if strings.Contains(out, "hunter2") {
+			t.Errorf("Admin password wasn't redacted: %s", out)
+		}
+		if !strings.Contains(out, `fetch_interval = "1h30m0s"`) {
+			t.Errorf("Expected normalized fetch interval, got: %s", out)
+		}
+		if !strings.Contains(out, `site_name = "getwtxt-ng test"`) {
+			t.Errorf("Expected instance info, got: %s", out)
+		}
+	})
+	t.Run("json", func(t *testing.T) {
+		buf := &bytes.Buffer{}
+		if err := conf.printEffective(buf, "json"); err != nil {
+			t.Fatal(err.Error())
+		}
+		out := effectiveConfig{}
+		if err := json.Unmarshal(buf.Bytes(), &out); err != nil {
+			t.Fatal(err.Error())
+		}
+		if out.ServerConfig.AdminPassword != redactedSecret {
+			t.Errorf("Got %s, expected %s", out.ServerConfig.AdminPassword, redactedSecret)
+		}
+		if out.ServerConfig.Port != "9001" {
+			t.Errorf("Got %s, expected 9001", out.ServerConfig.Port)
+		}
+	})
+	t.Run("unknown format", func(t *testing.T) {
+		if err := conf.printEffective(&bytes.Buffer{}, "yaml"); err == nil {
+			t.Error("Expected error for unknown format")
+		}
+	})
+}
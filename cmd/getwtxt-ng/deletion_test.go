@@ -0,0 +1,115 @@
+package main
+
+/*
+Copyright 2021 G. Benjamin Morrison
+
+This file is part of getwtxt-ng.
+
+getwtxt-ng is free software: you can redistribute it and/or modify
+it under the terms of the GNU Affero General Public License as
+published by the Free Software Foundation, either version 3 of the
+License, or (at your option) any later version.
+
+getwtxt-ng is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU Affero General Public License for more details.
+
+You should have received a copy of the GNU Affero General Public License
+along with getwtxt-ng.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/mux"
+
+	"github.com/gbmor/getwtxt-ng/common"
+	"github.com/gbmor/getwtxt-ng/registry"
+)
+
+func TestSelfDeletionGracePeriod(t *testing.T) {
+	ctx := context.Background()
+	dbConn := getFederationDB(t)
+	adminHash, err := common.HashPass("hunter2")
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	passHash, err := common.HashPass("passcode")
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	conf := &Config{ServerConfig: ServerConfig{AdminPassword: string(adminHash), DeletionGrace: time.Hour}}
+
+	u := registry.User{Nick: "foo", URL: "https://foo.example/twtxt.txt", PasscodeHash: passHash}
+	if _, err := dbConn.InsertUserWithTweets(ctx, &u, []registry.Tweet{{DateTime: time.Date(2022, 1, 1, 0, 0, 0, 0, time.UTC), Body: "hello"}}); err != nil {
+		t.Fatal(err.Error())
+	}
+
+	r := mux.NewRouter()
+	setUpRoutes(r, conf, dbConn)
+	serve := func(method, path, pass, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, path, strings.NewReader(body))
+		req.Header.Set("X-Auth", pass)
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		return w
+	}
+
+	w := serve(http.MethodDelete, "/api/plain/users?url="+u.URL, "passcode", "")
+	if w.Code != http.StatusAccepted || !strings.Contains(w.Body.String(), "will be deleted after") {
+		t.Fatalf("Expected the deletion to be scheduled, got %d: %s", w.Code, w.Body.String())
+	}
+	if users, err := dbConn.GetUsersByID(ctx, []string{u.ID}); err != nil || len(users) != 0 {
+		t.Errorf("Expected the user to be hidden while pending deletion, got %d, %v", len(users), err)
+	}
+
+	if w := serve(http.MethodPost, "/api/plain/users/undelete?url="+u.URL, "wrong", ""); w.Code != http.StatusForbidden {
+		t.Errorf("Expected %d with the wrong passcode, got %d", http.StatusForbidden, w.Code)
+	}
+	if w := serve(http.MethodPost, "/api/plain/users/undelete", "passcode", ""); w.Code != http.StatusBadRequest {
+		t.Errorf("Expected %d without a url, got %d", http.StatusBadRequest, w.Code)
+	}
+	if w := serve(http.MethodPost, "/api/plain/users/undelete?url=https://nobody.example/twtxt.txt", "passcode", ""); w.Code != http.StatusNotFound {
+		t.Errorf("Expected %d for an unknown user, got %d", http.StatusNotFound, w.Code)
+	}
+	if w := serve(http.MethodPost, "/api/plain/users/undelete?url="+u.URL, "passcode", ""); w.Code != http.StatusOK {
+		t.Fatalf("Expected the deletion to be undone, got %d: %s", w.Code, w.Body.String())
+	}
+	if users, err := dbConn.GetUsersByID(ctx, []string{u.ID}); err != nil || len(users) != 1 {
+		t.Errorf("Expected the user to be listed again, got %d, %v", len(users), err)
+	}
+	if w := serve(http.MethodPost, "/api/json/users/undelete?url="+u.URL, "hunter2", ""); w.Code != http.StatusConflict {
+		t.Errorf("Expected %d for a user who isn't pending deletion, got %d", http.StatusConflict, w.Code)
+	}
+
+	w = serve(http.MethodDelete, "/api/json/users", "passcode", `[{"url": "`+u.URL+`"}]`)
+	resp := MessageResponse{}
+	if err := json.NewDecoder(w.Body).Decode(&resp); err != nil {
+		t.Fatal(err.Error())
+	}
+	if w.Code != http.StatusAccepted || resp.DeleteAfter == "" {
+		t.Errorf("Expected the deletion to be scheduled, got %d %+v", w.Code, resp)
+	}
+	if _, err := dbConn.GetFullUserByURL(ctx, u.URL); err != nil {
+		t.Errorf("Expected the user to be kept until the grace period passes, got %v", err)
+	}
+
+	// Without a grace period, users are deleted right away, as before.
+	conf.ServerConfig.DeletionGrace = 0
+	if _, err := dbConn.CancelUserDeletion(ctx, &u); err != nil {
+		t.Fatal(err.Error())
+	}
+	if w := serve(http.MethodDelete, "/api/plain/users?url="+u.URL, "passcode", ""); w.Code != http.StatusOK {
+		t.Errorf("Expected the user to be deleted, got %d: %s", w.Code, w.Body.String())
+	}
+	if _, err := dbConn.GetFullUserByURL(ctx, u.URL); err == nil {
+		t.Error("Expected the user to be gone")
+	}
+}
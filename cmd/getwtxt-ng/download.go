@@ -0,0 +1,123 @@
+package main
+
+/*
+Copyright 2021 G. Benjamin Morrison
+
+This file is part of getwtxt-ng.
+
+getwtxt-ng is free software: you can redistribute it and/or modify
+it under the terms of the GNU Affero General Public License as
+published by the Free Software Foundation, either version 3 of the
+License, or (at your option) any later version.
+
+getwtxt-ng is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU Affero General Public License for more details.
+
+You should have received a copy of the GNU Affero General Public License
+along with getwtxt-ng.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gbmor/getwtxt-ng/registry"
+)
+
+// downloadTwtxt is the value of the download parameter that returns a result set as a twtxt file.
+const downloadTwtxt = "twtxt"
+
+// tweetPager retrieves a page of a result set.
+type tweetPager func(ctx context.Context, page, perPage int) ([]registry.Tweet, error)
+
+// wantsTwtxtDownload reports whether the request asked for its results as a twtxt file.
+// An unknown download value is answered with an error, and false with ok unset.
+func wantsTwtxtDownload(w http.ResponseWriter, r *http.Request, format APIFormat) (want bool, ok bool) {
+	switch download := r.Form.Get("download"); download {
+	case "":
+		return false, true
+	case downloadTwtxt:
+		return true, true
+	default:
+		msg := fieldErrorResponse(FieldError{Field: "download", Code: fieldInvalid, Message: fmt.Sprintf("Invalid download format specified, expected twtxt: %s", download)})
+		errorResponseWrite(w, r, format, http.StatusBadRequest, msg)
+		return false, false
+	}
+}
+
+// twtxtDownloadWrite streams every page of a result set as a twtxt file named filename, newest first.
+// The file is headed by comments saying where and when it was taken from, and each run of tweets
+// by the same author is preceded by a comment attributing them.
+// Once the first tweet is written the status can't change, so a failure after that ends the file
+// with a comment saying it's incomplete.
+func twtxtDownloadWrite(w http.ResponseWriter, r *http.Request, conf *Config, dbConn *registry.DB, format APIFormat, filename, description string, next tweetPager) {
+	ctx := r.Context()
+	_, perPage := dbConn.PageBounds(1, dbConn.EntriesPerPageMax)
+	// The description may quote a search term, which mustn't be able to break out of its comment.
+	description = strings.Join(strings.Fields(description), " ")
+
+	tweets, err := next(ctx, 1, perPage)
+	if err != nil {
+		reqLog(r).Errorf("When retrieving tweets to download as %s: %s", filename, err)
+		code, message := queryErrorStatus(r, err)
+		errorResponseWrite(w, r, format, code, MessageResponse{Message: message})
+		return
+	}
+
+	conf.mu.RLock()
+	siteName := conf.InstanceConfig.SiteName
+	siteURL := conf.InstanceConfig.SiteURL
+	conf.mu.RUnlock()
+
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="%s"`, filename))
+	w.WriteHeader(http.StatusOK)
+
+	header := strings.Builder{}
+	header.WriteString(fmt.Sprintf("# %s\n", description))
+	header.WriteString(fmt.Sprintf("# Downloaded from %s <%s> at %s\n", siteName, siteURL, time.Now().UTC().Format(time.RFC3339)))
+	header.WriteString("# Each tweet keeps its original timestamp and is attributed to its author in the comment above it.\n#\n")
+	if _, err := w.Write([]byte(header.String())); err != nil {
+		reqLog(r).Debugf("When writing twtxt download %s: %s", filename, err)
+		return
+	}
+
+	lastAuthor := ""
+	for page := 1; ; page++ {
+		if page > 1 {
+			tweets, err = next(ctx, page, perPage)
+			if err != nil {
+				reqLog(r).Errorf("When retrieving page %d of tweets to download as %s: %s", page, filename, err)
+				_, message := queryErrorStatus(r, err)
+				_, _ = w.Write([]byte(fmt.Sprintf("#\n# This download is incomplete: %s\n", message)))
+				return
+			}
+		}
+
+		out := strings.Builder{}
+		out.Grow(len(tweets) * 128)
+		for _, tweet := range tweets {
+			if tweet.URL != lastAuthor {
+				out.WriteString(fmt.Sprintf("# by @<%s %s>\n", tweet.Nickname, tweet.URL))
+				lastAuthor = tweet.URL
+			}
+			out.WriteString(tweet.DateTime.Format(time.RFC3339))
+			out.WriteString("\t")
+			out.WriteString(tweet.Body)
+			out.WriteString("\n")
+		}
+		if _, err := w.Write([]byte(out.String())); err != nil {
+			reqLog(r).Debugf("When writing twtxt download %s: %s", filename, err)
+			return
+		}
+
+		if len(tweets) < perPage {
+			return
+		}
+	}
+}
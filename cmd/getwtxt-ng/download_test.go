@@ -0,0 +1,113 @@
+package main
+
+/*
+Copyright 2021 G. Benjamin Morrison
+
+This file is part of getwtxt-ng.
+
+getwtxt-ng is free software: you can redistribute it and/or modify
+it under the terms of the GNU Affero General Public License as
+published by the Free Software Foundation, either version 3 of the
+License, or (at your option) any later version.
+
+getwtxt-ng is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU Affero General Public License for more details.
+
+You should have received a copy of the GNU Affero General Public License
+along with getwtxt-ng.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gbmor/getwtxt-ng/registry"
+	"github.com/gorilla/mux"
+)
+
+func TestTwtxtDownload(t *testing.T) {
+	// Two tweets a page, so a download has to go past the first.
+	dbConn, err := registry.Open(":memory:", registry.WithPageLimits(1, 2))
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	t.Cleanup(func() {
+		_ = dbConn.Close()
+	})
+	ctx := context.Background()
+
+	users := []registry.User{
+		{Nick: "foo", URL: "https://example.com/twtxt.txt", PasscodeHash: []byte("not a real hash"), DateTimeAdded: time.Now().UTC()},
+		{Nick: "bar", URL: "https://example.org/twtxt.txt", PasscodeHash: []byte("not a real hash"), DateTimeAdded: time.Now().UTC()},
+	}
+	for i := range users {
+		if err := dbConn.InsertUser(ctx, &users[i]); err != nil {
+			t.Fatal(err.Error())
+		}
+	}
+	start := time.Date(2022, 2, 1, 0, 0, 0, 0, time.UTC)
+	tweets := []registry.Tweet{
+		{UserID: users[0].ID, DateTime: start, Body: "first #topic"},
+		{UserID: users[0].ID, DateTime: start.Add(time.Minute), Body: "second #topic"},
+		{UserID: users[1].ID, DateTime: start.Add(2 * time.Minute), Body: "third #topic"},
+		{UserID: users[1].ID, DateTime: start.Add(3 * time.Minute), Body: "unrelated"},
+	}
+	if _, err := dbConn.InsertTweets(ctx, tweets); err != nil {
+		t.Fatal(err.Error())
+	}
+
+	conf := &Config{InstanceConfig: InstanceConfig{SiteName: "Test Registry", SiteURL: "https://registry.example"}}
+	r := mux.NewRouter()
+	setUpRoutes(r, conf, dbConn)
+
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/api/json/tags/topic?download=twtxt", nil))
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected 200, got %d: %s", w.Code, w.Body.String())
+	}
+	if cd := w.Header().Get("Content-Disposition"); cd != `attachment; filename="tag-topic.txt"` {
+		t.Errorf("Expected the download to be named for the tag, got %q", cd)
+	}
+	want := []string{
+		"# by @<bar https://example.org/twtxt.txt>",
+		"2022-02-01T00:02:00Z\tthird #topic",
+		"# by @<foo https://example.com/twtxt.txt>",
+		"2022-02-01T00:01:00Z\tsecond #topic",
+		"2022-02-01T00:00:00Z\tfirst #topic",
+	}
+	lines := strings.Split(strings.TrimSuffix(w.Body.String(), "\n"), "\n")
+	if len(lines) < len(want) || !strings.HasPrefix(lines[1], "# Downloaded from Test Registry <https://registry.example>") {
+		t.Fatalf("Expected a header and %d lines of tweets, got:\n%s", len(want), w.Body.String())
+	}
+	got := lines[len(lines)-len(want):]
+	for i := range want {
+		if got[i] != want[i] {
+			t.Errorf("Line %d: expected %q, got %q", i, want[i], got[i])
+		}
+	}
+
+	w = httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/api/plain/tweets?q=%22%0A2022-01-01T00:00:00Z%20injected%22&download=twtxt", nil))
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected 200 for a search download, got %d: %s", w.Code, w.Body.String())
+	}
+	for _, line := range strings.Split(w.Body.String(), "\n") {
+		if strings.HasPrefix(line, "2022-01-01") {
+			t.Errorf("Expected the search term to stay inside its comment, got %q", line)
+		}
+	}
+
+	for _, target := range []string{"/api/json/tweets?download=zip", "/api/json/tweets?download=twtxt&hash=abc"} {
+		w = httptest.NewRecorder()
+		r.ServeHTTP(w, httptest.NewRequest(http.MethodGet, target, nil))
+		if w.Code != http.StatusBadRequest {
+			t.Errorf("Expected 400 for %s, got %d", target, w.Code)
+		}
+	}
+}
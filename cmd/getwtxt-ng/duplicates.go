@@ -0,0 +1,56 @@
+package main
+
+/*
+Copyright 2021 G. Benjamin Morrison
+
+This file is part of getwtxt-ng.
+
+getwtxt-ng is free software: you can redistribute it and/or modify
+it under the terms of the GNU Affero General Public License as
+published by the Free Software Foundation, either version 3 of the
+License, or (at your option) any later version.
+
+getwtxt-ng is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU Affero General Public License for more details.
+
+You should have received a copy of the GNU Affero General Public License
+along with getwtxt-ng.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+import (
+	"net/http"
+
+	"github.com/gbmor/getwtxt-ng/registry"
+)
+
+// duplicateUsersHandler lets the admin find feeds registered under more than one form of their URL with GET,
+// and merge each into the registration that's kept with POST.
+func duplicateUsersHandler(w http.ResponseWriter, r *http.Request, conf *Config, dbConn *registry.DB) {
+	ctx := r.Context()
+
+	if !adminAuthorized(w, r, conf) {
+		return
+	}
+
+	if r.Method != http.MethodPost {
+		dupes, err := dbConn.FindDuplicateUsers(ctx)
+		if err != nil {
+			reqLog(r).Errorf("When finding duplicate users: %s", err)
+			code, message := queryErrorStatus(r, err)
+			errorResponseWrite(w, r, APIFormatJSON, code, MessageResponse{Message: message})
+			return
+		}
+		jsonResponseWrite(w, r, dupes, http.StatusOK)
+		return
+	}
+
+	merged, err := dbConn.MergeDuplicateUsers(ctx)
+	if err != nil {
+		reqLog(r).Errorf("When merging duplicate users, after %d merges: %s", len(merged), err)
+		errorResponseWrite(w, r, APIFormatJSON, http.StatusInternalServerError, MessageResponse{})
+		return
+	}
+	jsonResponseWrite(w, r, merged, http.StatusOK)
+}
@@ -0,0 +1,118 @@
+package main
+
+/*
+Copyright 2021 G. Benjamin Morrison
+
+This file is part of getwtxt-ng.
+
+getwtxt-ng is free software: you can redistribute it and/or modify
+it under the terms of the GNU Affero General Public License as
+published by the Free Software Foundation, either version 3 of the
+License, or (at your option) any later version.
+
+getwtxt-ng is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU Affero General Public License for more details.
+
+You should have received a copy of the GNU Affero General Public License
+along with getwtxt-ng.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+// Codes of error responses, saying what went wrong for clients to act on. The message is only for people.
+const (
+	errCodeBadRequest       = "bad_request"
+	errCodeInvalidFields    = "invalid_fields"
+	errCodeDuplicateUser    = "duplicate_user"
+	errCodeBanned           = "banned"
+	errCodeUnauthorized     = "unauthorized"
+	errCodeForbidden        = "forbidden"
+	errCodeNotFound         = "not_found"
+	errCodeMethodNotAllowed = "method_not_allowed"
+	errCodeTooLarge         = "too_large"
+	errCodeRateLimited      = "rate_limited"
+	errCodeInternal         = "internal_error"
+	errCodeUnavailable      = "unavailable"
+	errCodeTimeout          = "timeout"
+)
+
+// statusErrorCodes are the codes of errors that don't say more than their status does.
+var statusErrorCodes = map[int]string{
+	http.StatusBadRequest:            errCodeBadRequest,
+	http.StatusUnauthorized:          errCodeUnauthorized,
+	http.StatusForbidden:             errCodeForbidden,
+	http.StatusNotFound:              errCodeNotFound,
+	http.StatusMethodNotAllowed:      errCodeMethodNotAllowed,
+	http.StatusRequestEntityTooLarge: errCodeTooLarge,
+	http.StatusTooManyRequests:       errCodeRateLimited,
+	http.StatusInternalServerError:   errCodeInternal,
+	http.StatusServiceUnavailable:    errCodeUnavailable,
+	http.StatusGatewayTimeout:        errCodeTimeout,
+}
+
+// errorFormat picks the format of an error response for a request that may not name one in its path,
+// such as one that didn't match a route: JSON if the client accepts it, plain text otherwise.
+func errorFormat(r *http.Request) APIFormat {
+	if format := getFormat(r); format == APIFormatJSON || format == APIFormatPlain {
+		return format
+	}
+	if strings.HasPrefix(r.URL.Path, "/api/json/") {
+		return APIFormatJSON
+	}
+	if strings.HasPrefix(r.URL.Path, "/api/plain/") {
+		return APIFormatPlain
+	}
+	if strings.Contains(r.Header.Get("Accept"), "json") {
+		return APIFormatJSON
+	}
+	return APIFormatPlain
+}
+
+// errorResponseWrite writes msg as an error response with the provided status, filling in the code if it
+// isn't set, the status, and the request ID. In the plain format, that's one line of tab-separated values:
+// status, code, request ID, and message.
+func errorResponseWrite(w http.ResponseWriter, r *http.Request, format APIFormat, status int, msg MessageResponse) {
+	msg.Status = status
+	if msg.Code == "" {
+		msg.Code = statusErrorCodes[status]
+		if len(msg.Errors) > 0 {
+			msg.Code = errCodeInvalidFields
+		}
+		if msg.Code == "" {
+			msg.Code = errCodeBadRequest
+			if status >= http.StatusInternalServerError {
+				msg.Code = errCodeInternal
+			}
+		}
+	}
+	if msg.Message == "" {
+		msg.Message = http.StatusText(status)
+	}
+	if loc := requestLocalizer(r); loc != nil {
+		msg.Message = loc.T(msg.Message)
+		for i := range msg.Errors {
+			msg.Errors[i].Message = loc.T(msg.Errors[i].Message)
+		}
+		setContentLanguage(w, r)
+	}
+	msg.RequestID = requestID(r)
+	w.Header().Set("X-Request-ID", msg.RequestID)
+
+	if format == APIFormatJSON {
+		jsonResponseWrite(w, r, msg, status)
+		return
+	}
+	body := fmt.Sprintf("%d\t%s\t%s\t%s\n", status, msg.Code, msg.RequestID, strings.ReplaceAll(msg.Message, "\n", " "))
+	plainResponseWrite(w, body, status)
+}
+
+// errorWrite writes an error response with just a message, which is the status text if it's empty.
+func errorWrite(w http.ResponseWriter, r *http.Request, format APIFormat, status int, message string) {
+	errorResponseWrite(w, r, format, status, MessageResponse{Message: message})
+}
@@ -0,0 +1,129 @@
+package main
+
+/*
+Copyright 2021 G. Benjamin Morrison
+
+This file is part of getwtxt-ng.
+
+getwtxt-ng is free software: you can redistribute it and/or modify
+it under the terms of the GNU Affero General Public License as
+published by the Free Software Foundation, either version 3 of the
+License, or (at your option) any later version.
+
+getwtxt-ng is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU Affero General Public License for more details.
+
+You should have received a copy of the GNU Affero General Public License
+along with getwtxt-ng.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"context"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gbmor/getwtxt-ng/common"
+	"github.com/gbmor/getwtxt-ng/registry"
+)
+
+// exportArchiveIndex is the name of the users index at the root of the export archive.
+const exportArchiveIndex = "users.txt"
+
+// exportArchiveHandler streams a gzipped tarball of the registry to the admin: a twtxt file
+// of each user's visible tweets under twtxt/, plus an index listing every user and their file.
+// Only one user's tweets are held in memory at a time.
+func exportArchiveHandler(w http.ResponseWriter, r *http.Request, conf *Config, dbConn *registry.DB) {
+	ctx := r.Context()
+
+	conf.mu.RLock()
+	adminPassword := conf.ServerConfig.AdminPassword
+	conf.mu.RUnlock()
+	pass := r.Header.Get("X-Auth")
+	if pass == "" || !common.ValidatePass(pass, []byte(adminPassword)) {
+		errorWrite(w, r, errorFormat(r), http.StatusForbidden, "")
+		return
+	}
+
+	users, err := dbConn.GetAllUsers(ctx)
+	if err != nil {
+		reqLog(r).Errorf("When retrieving users to export: %s", err)
+		code, msg := queryErrorStatus(r, err)
+		errorWrite(w, r, errorFormat(r), code, msg)
+		return
+	}
+
+	now := time.Now().UTC()
+	w.Header().Set("Content-Type", "application/gzip")
+	w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="getwtxt-ng-export-%s.tar.gz"`, now.Format("20060102")))
+	w.WriteHeader(http.StatusOK)
+	if r.Method == http.MethodHead {
+		return
+	}
+
+	gz := gzip.NewWriter(w)
+	tw := tar.NewWriter(gz)
+	// Once the response has started, the only way to report a failure is to cut the archive short.
+	if err := writeExportArchive(ctx, tw, dbConn, users, now); err != nil {
+		reqLog(r).Errorf("When exporting registry archive: %s", err)
+		return
+	}
+	if err := tw.Close(); err != nil {
+		reqLog(r).Errorf("When finishing registry archive: %s", err)
+		return
+	}
+	if err := gz.Close(); err != nil {
+		reqLog(r).Errorf("When finishing registry archive: %s", err)
+	}
+}
+
+// writeExportArchive writes the users index followed by each user's twtxt file.
+func writeExportArchive(ctx context.Context, tw *tar.Writer, dbConn *registry.DB, users []registry.User, modTime time.Time) error {
+	index := strings.Builder{}
+	index.Grow(len(users) * 128)
+	for _, u := range users {
+		index.WriteString(fmt.Sprintf("%s\t%s\t%s\t%s\t%s\n", u.Nick, u.URL, u.DateTimeAdded.UTC().Format(time.RFC3339), u.Status, exportFeedPath(u)))
+	}
+	if err := writeExportFile(tw, exportArchiveIndex, index.String(), modTime); err != nil {
+		return err
+	}
+
+	for i := range users {
+		tweets, err := dbConn.GetUserFeed(ctx, users[i].ID)
+		if err != nil {
+			return err
+		}
+		if err := writeExportFile(tw, exportFeedPath(users[i]), registry.FormatTwtxtFile(&users[i], tweets), modTime); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// exportFeedPath is where a user's twtxt file is placed in the export archive. Nicks aren't unique, so it's named by ID.
+func exportFeedPath(u registry.User) string {
+	return fmt.Sprintf("twtxt/%s.txt", u.ID)
+}
+
+func writeExportFile(tw *tar.Writer, name, contents string, modTime time.Time) error {
+	hdr := &tar.Header{
+		Name:    name,
+		Mode:    0644,
+		Size:    int64(len(contents)),
+		ModTime: modTime,
+	}
+	if err := tw.WriteHeader(hdr); err != nil {
+		return fmt.Errorf("when writing archive header for %s: %w", name, err)
+	}
+	if _, err := tw.Write([]byte(contents)); err != nil {
+		return fmt.Errorf("when writing %s to archive: %w", name, err)
+	}
+
+	return nil
+}
@@ -0,0 +1,117 @@
+package main
+
+/*
+Copyright 2021 G. Benjamin Morrison
+
+This file is part of getwtxt-ng.
+
+getwtxt-ng is free software: you can redistribute it and/or modify
+it under the terms of the GNU Affero General Public License as
+published by the Free Software Foundation, either version 3 of the
+License, or (at your option) any later version.
+
+getwtxt-ng is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU Affero General Public License for more details.
+
+You should have received a copy of the GNU Affero General Public License
+along with getwtxt-ng.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/mux"
+
+	"github.com/gbmor/getwtxt-ng/common"
+	"github.com/gbmor/getwtxt-ng/registry"
+)
+
+func TestExportArchiveHandler(t *testing.T) {
+	ctx := context.Background()
+	dbConn := getFederationDB(t)
+	hash, err := common.HashPass("hunter2")
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	conf := &Config{ServerConfig: ServerConfig{AdminPassword: string(hash), EntriesPerPageMin: 10, EntriesPerPageMax: 1000}}
+	r := mux.NewRouter()
+	setUpRoutes(r, conf, dbConn)
+	srv := httptest.NewServer(r)
+	t.Cleanup(srv.Close)
+
+	u := registry.User{Nick: "foo", URL: "https://foo.example/twtxt.txt", PasscodeHash: []byte("hash")}
+	tweets := []registry.Tweet{
+		{DateTime: time.Date(2022, 1, 1, 0, 0, 0, 0, time.UTC), Body: "first"},
+		{DateTime: time.Date(2022, 1, 2, 0, 0, 0, 0, time.UTC), Body: "second"},
+	}
+	if _, err := dbConn.InsertUserWithTweets(ctx, &u, tweets); err != nil {
+		t.Fatal(err.Error())
+	}
+
+	get := func(pass string) *http.Response {
+		t.Helper()
+		req, err := http.NewRequest(http.MethodGet, srv.URL+"/api/admin/export.tar.gz", nil)
+		if err != nil {
+			t.Fatal(err.Error())
+		}
+		if pass != "" {
+			req.Header.Set("X-Auth", pass)
+		}
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err.Error())
+		}
+		t.Cleanup(func() {
+			_ = resp.Body.Close()
+		})
+		return resp
+	}
+
+	if resp := get("wrong"); resp.StatusCode != http.StatusForbidden {
+		t.Errorf("Expected 403 with the wrong password, got %d", resp.StatusCode)
+	}
+
+	resp := get("hunter2")
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("Expected 200, got %d", resp.StatusCode)
+	}
+	gz, err := gzip.NewReader(resp.Body)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	files := make(map[string]string)
+	tr := tar.NewReader(gz)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatal(err.Error())
+		}
+		contents, err := io.ReadAll(tr)
+		if err != nil {
+			t.Fatal(err.Error())
+		}
+		files[hdr.Name] = string(contents)
+	}
+
+	feedPath := exportFeedPath(u)
+	if !strings.Contains(files[exportArchiveIndex], "foo\thttps://foo.example/twtxt.txt\t") || !strings.HasSuffix(files[exportArchiveIndex], feedPath+"\n") {
+		t.Errorf("Unexpected users index:\n%s", files[exportArchiveIndex])
+	}
+	want := "# nick = foo\n# url = https://foo.example/twtxt.txt\n#\n2022-01-01T00:00:00Z\tfirst\n2022-01-02T00:00:00Z\tsecond\n"
+	if files[feedPath] != want {
+		t.Errorf("Expected feed:\n%s\ngot:\n%s", want, files[feedPath])
+	}
+}
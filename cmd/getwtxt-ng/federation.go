@@ -0,0 +1,290 @@
+package main
+
+/*
+Copyright 2021 G. Benjamin Morrison
+
+This file is part of getwtxt-ng.
+
+getwtxt-ng is free software: you can redistribute it and/or modify
+it under the terms of the GNU Affero General Public License as
+published by the Free Software Foundation, either version 3 of the
+License, or (at your option) any later version.
+
+getwtxt-ng is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU Affero General Public License for more details.
+
+You should have received a copy of the GNU Affero General Public License
+along with getwtxt-ng.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gorilla/mux"
+	log "github.com/sirupsen/logrus"
+
+	"github.com/gbmor/getwtxt-ng/registry"
+)
+
+// Peers don't gain users quickly, so there's no point pulling their lists as often as feeds are fetched.
+const defaultFederationInterval = "6h"
+
+// Limits on pushing newly registered users to peers.
+const (
+	federationQueueSize    = 1024
+	federationBatchSize    = 100
+	federationBatchDelay   = 30 * time.Second
+	federationMaxPushBody  = 1 << 20
+	federationSignaturePre = "sha256="
+)
+
+// InitFederationTicker registers the users of each peer registry in the background, then again every interval.
+func InitFederationTicker(peers []string, interval time.Duration, dbConn *registry.DB) chan<- struct{} {
+	tick := time.NewTicker(interval)
+	done := make(chan struct{})
+
+	go func() {
+		pullPeerUsers(peers, dbConn)
+		for {
+			select {
+			case <-done:
+				tick.Stop()
+				return
+			case <-tick.C:
+				pullPeerUsers(peers, dbConn)
+			}
+		}
+	}()
+
+	return done
+}
+
+// pullPeerUsers registers the users listed by each peer that aren't known here.
+// A peer that can't be reached is skipped until the next pass.
+func pullPeerUsers(peers []string, dbConn *registry.DB) {
+	ctx := context.Background()
+	for _, peer := range peers {
+		users, err := dbConn.FetchPeerUsers(ctx, peer)
+		if err != nil {
+			log.Errorf("Couldn't get users from peer registry %s: %s", peer, err)
+			continue
+		}
+		added, err := dbConn.ImportPeerUsers(ctx, peer, users)
+		if err != nil {
+			log.Errorf("Couldn't register users from peer registry %s: %s", peer, err)
+			continue
+		}
+		log.Infof("Registered %d of %d users listed by peer registry %s", len(added), len(users), peer)
+	}
+}
+
+// signFederationBody returns the value of the X-Signature header for a push with the provided body.
+func signFederationBody(secret, body []byte) string {
+	mac := hmac.New(sha256.New, secret)
+	mac.Write(body)
+	return federationSignaturePre + hex.EncodeToString(mac.Sum(nil))
+}
+
+// federationNotifier pushes newly registered local users to peer registries in batches,
+// so they don't have to wait for their next pull to learn of them.
+type federationNotifier struct {
+	dbConn  *registry.DB
+	peers   []string
+	siteURL string
+	secret  []byte
+	client  *http.Client
+	queue   chan registry.User
+	wg      sync.WaitGroup
+
+	mu     sync.Mutex
+	closed bool
+}
+
+func newFederationNotifier(conf *Config, dbConn *registry.DB) *federationNotifier {
+	conf.mu.RLock()
+	defer conf.mu.RUnlock()
+
+	n := &federationNotifier{
+		dbConn:  dbConn,
+		peers:   conf.Federation.Peers,
+		siteURL: strings.TrimSuffix(conf.InstanceConfig.SiteURL, "/"),
+		secret:  []byte(conf.Federation.SharedSecret),
+		client:  &http.Client{Timeout: 10 * time.Second},
+		queue:   make(chan registry.User, federationQueueSize),
+	}
+
+	n.wg.Add(1)
+	go func() {
+		defer n.wg.Done()
+		n.run()
+	}()
+
+	return n
+}
+
+// run collects queued users, pushing them once a batch fills up or has waited long enough.
+func (n *federationNotifier) run() {
+	batch := make([]registry.User, 0, federationBatchSize)
+	var flush <-chan time.Time
+	for {
+		select {
+		case u, ok := <-n.queue:
+			if !ok {
+				n.push(batch)
+				return
+			}
+			if len(batch) == 0 {
+				flush = time.After(federationBatchDelay)
+			}
+			batch = append(batch, u)
+			if len(batch) < federationBatchSize {
+				continue
+			}
+		case <-flush:
+		}
+		n.push(batch)
+		batch = make([]registry.User, 0, federationBatchSize)
+		flush = nil
+	}
+}
+
+// push sends a batch of users to every peer. A peer that can't be reached picks them up on its next pull.
+func (n *federationNotifier) push(users []registry.User) {
+	if len(users) == 0 {
+		return
+	}
+	body := []byte(registry.FormatUsersPlain(users))
+	signature := signFederationBody(n.secret, body)
+	for _, peer := range n.peers {
+		if err := n.pushTo(peer, body, signature); err != nil {
+			log.Errorf("Couldn't notify peer registry %s of %d new users: %s", peer, len(users), err)
+		}
+	}
+}
+
+func (n *federationNotifier) pushTo(peer string, body []byte, signature string) error {
+	pushURL := strings.TrimSuffix(peer, "/") + "/api/plain/federation/users"
+	req, err := http.NewRequest(http.MethodPost, pushURL, bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("when creating request: %w", err)
+	}
+	req.Header.Set("Content-Type", "text/plain")
+	req.Header.Set("X-Registry", n.siteURL)
+	req.Header.Set("X-Signature", signature)
+
+	resp, err := n.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		_ = resp.Body.Close()
+	}()
+	_, _ = io.Copy(io.Discard, io.LimitReader(resp.Body, 1<<16))
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("got status code %d", resp.StatusCode)
+	}
+
+	return nil
+}
+
+// Close stops accepting users and waits for the pending batch to be pushed.
+func (n *federationNotifier) Close() {
+	n.mu.Lock()
+	if !n.closed {
+		n.closed = true
+		close(n.queue)
+	}
+	n.mu.Unlock()
+	n.wg.Wait()
+}
+
+// usersInserted queues users who registered here for pushing. It's used as the UsersInserted and UsersApproved hooks.
+// Users federated from a peer aren't passed along, so pushes can't bounce between registries, and neither are
+// those waiting for approval until they get it.
+func (n *federationNotifier) usersInserted(ctx context.Context, users []registry.User) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	if n.closed {
+		return
+	}
+
+	for _, u := range users {
+		if u.Status == registry.UserStatusPendingApproval {
+			continue
+		}
+		source, err := n.dbConn.GetUserSource(ctx, u.URL)
+		if err != nil {
+			log.Errorf("When checking where user %s came from: %s", u.URL, err)
+			continue
+		}
+		if source != "" {
+			continue
+		}
+		select {
+		case n.queue <- u:
+		default:
+			log.Errorf("Federation queue full, not notifying peers of %s", u.URL)
+		}
+	}
+}
+
+// federationPushHandler registers the users a configured peer has pushed to us.
+// The peer names itself in X-Registry and signs the body with the shared secret.
+func federationPushHandler(w http.ResponseWriter, r *http.Request, conf *Config, dbConn *registry.DB) {
+	conf.mu.RLock()
+	peers := conf.Federation.Peers
+	secret := []byte(conf.Federation.SharedSecret)
+	conf.mu.RUnlock()
+
+	source := strings.TrimSuffix(r.Header.Get("X-Registry"), "/")
+	known := false
+	for _, peer := range peers {
+		if strings.TrimSuffix(peer, "/") == source {
+			known = true
+			break
+		}
+	}
+	if source == "" || !known {
+		errorWrite(w, r, APIFormatPlain, http.StatusForbidden, "")
+		return
+	}
+
+	body, err := io.ReadAll(io.LimitReader(r.Body, federationMaxPushBody))
+	if err != nil {
+		errorWrite(w, r, APIFormatPlain, http.StatusBadRequest, "")
+		return
+	}
+	if !hmac.Equal([]byte(r.Header.Get("X-Signature")), []byte(signFederationBody(secret, body))) {
+		errorWrite(w, r, APIFormatPlain, http.StatusForbidden, "")
+		return
+	}
+
+	users := registry.ParseUsersPlain(bytes.NewReader(body))
+	added, err := dbConn.ImportPeerUsers(r.Context(), source, users)
+	if err != nil {
+		reqLog(r).Errorf("Couldn't register users pushed by peer registry %s: %s", source, err)
+		errorWrite(w, r, APIFormatPlain, http.StatusInternalServerError, "")
+		return
+	}
+	reqLog(r).Infof("Registered %d of %d users pushed by peer registry %s", len(added), len(users), source)
+
+	plainResponseWrite(w, fmt.Sprintf("%d users added", len(added)), http.StatusOK)
+}
+
+func setUpFederationRoutes(r *mux.Router, conf *Config, dbConn *registry.DB) {
+	r.HandleFunc("/api/plain/federation/users", func(w http.ResponseWriter, r *http.Request) {
+		federationPushHandler(w, r, conf, dbConn)
+	}).Methods(http.MethodPost)
+}
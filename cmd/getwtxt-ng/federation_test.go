@@ -0,0 +1,108 @@
+package main
+
+/*
+Copyright 2021 G. Benjamin Morrison
+
+This file is part of getwtxt-ng.
+
+getwtxt-ng is free software: you can redistribute it and/or modify
+it under the terms of the GNU Affero General Public License as
+published by the Free Software Foundation, either version 3 of the
+License, or (at your option) any later version.
+
+getwtxt-ng is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU Affero General Public License for more details.
+
+You should have received a copy of the GNU Affero General Public License
+along with getwtxt-ng.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/mux"
+
+	"github.com/gbmor/getwtxt-ng/registry"
+)
+
+func getFederationDB(t *testing.T) *registry.DB {
+	t.Helper()
+	dbConn, err := registry.Open(":memory:")
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	t.Cleanup(func() {
+		_ = dbConn.Close()
+	})
+	return dbConn
+}
+
+func TestFederationPush(t *testing.T) {
+	ctx := context.Background()
+	secret := "hunter2"
+
+	peerDB := getFederationDB(t)
+	peerConf := &Config{Federation: Federation{Peers: []string{"https://a.example/"}, SharedSecret: secret}}
+	r := mux.NewRouter()
+	setUpFederationRoutes(r, peerConf, peerDB)
+	srv := httptest.NewServer(r)
+	t.Cleanup(srv.Close)
+
+	localDB := getFederationDB(t)
+	localConf := &Config{
+		InstanceConfig: InstanceConfig{SiteURL: "https://a.example"},
+		Federation:     Federation{Peers: []string{srv.URL}, SharedSecret: secret},
+	}
+	fn := newFederationNotifier(localConf, localDB)
+	localDB.Hooks.UsersInserted = fn.usersInserted
+
+	user := registry.User{Nick: "foo", URL: "https://foo.example/twtxt.txt", PasscodeHash: []byte("not a real hash"), DateTimeAdded: time.Now().UTC()}
+	if err := localDB.InsertUser(ctx, &user); err != nil {
+		t.Fatal(err.Error())
+	}
+	fn.Close()
+
+	source, err := peerDB.GetUserSource(ctx, user.URL)
+	if err != nil {
+		t.Fatalf("Expected pushed user to be registered with peer: %s", err)
+	}
+	if source != "https://a.example" {
+		t.Errorf("Expected source https://a.example, got %s", source)
+	}
+
+	t.Run("bad signature", func(t *testing.T) {
+		body := "bar\thttps://bar.example/twtxt.txt\n"
+		req, _ := http.NewRequest(http.MethodPost, srv.URL+"/api/plain/federation/users", strings.NewReader(body))
+		req.Header.Set("X-Registry", "https://a.example")
+		req.Header.Set("X-Signature", signFederationBody([]byte("hunter3"), []byte(body)))
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err.Error())
+		}
+		_ = resp.Body.Close()
+		if resp.StatusCode != http.StatusForbidden {
+			t.Errorf("Expected 403, got %d", resp.StatusCode)
+		}
+	})
+	t.Run("unknown peer", func(t *testing.T) {
+		body := "bar\thttps://bar.example/twtxt.txt\n"
+		req, _ := http.NewRequest(http.MethodPost, srv.URL+"/api/plain/federation/users", strings.NewReader(body))
+		req.Header.Set("X-Registry", "https://b.example")
+		req.Header.Set("X-Signature", signFederationBody([]byte(secret), []byte(body)))
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err.Error())
+		}
+		_ = resp.Body.Close()
+		if resp.StatusCode != http.StatusForbidden {
+			t.Errorf("Expected 403, got %d", resp.StatusCode)
+		}
+	})
+}
@@ -0,0 +1,94 @@
+package main
+
+/*
+Copyright 2021 G. Benjamin Morrison
+
+This file is part of getwtxt-ng.
+
+getwtxt-ng is free software: you can redistribute it and/or modify
+it under the terms of the GNU Affero General Public License as
+published by the Free Software Foundation, either version 3 of the
+License, or (at your option) any later version.
+
+getwtxt-ng is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU Affero General Public License for more details.
+
+You should have received a copy of the GNU Affero General Public License
+along with getwtxt-ng.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+// requestedFields reads ?fields=, a comma-separated list of the JSON fields the client wants,
+// such as nickname,url,last_sync. Returns nil when every field is wanted.
+func requestedFields(r *http.Request) map[string]bool {
+	if r == nil {
+		return nil
+	}
+	var fields map[string]bool
+	for _, field := range strings.Split(r.URL.Query().Get("fields"), ",") {
+		field = strings.TrimSpace(field)
+		if field == "" {
+			continue
+		}
+		if fields == nil {
+			fields = make(map[string]bool)
+		}
+		fields[field] = true
+	}
+	return fields
+}
+
+// selectFields strips a JSON response down to the requested fields. Each entry of a listing keeps
+// those fields, including the entries in a ListEnvelope, whose own fields are left alone; any other
+// response keeps those fields of itself. Fields that don't exist are ignored.
+func selectFields(body interface{}, fields map[string]bool) (interface{}, error) {
+	if env, ok := body.(ListEnvelope); ok {
+		data, err := selectFields(env.Data, fields)
+		if err != nil {
+			return nil, err
+		}
+		env.Data = data
+		return env, nil
+	}
+
+	raw, err := json.Marshal(body)
+	if err != nil {
+		return nil, fmt.Errorf("when encoding response to select fields: %w", err)
+	}
+	// Numbers are kept as they were written rather than passing through float64.
+	dec := json.NewDecoder(bytes.NewReader(raw))
+	dec.UseNumber()
+	var generic interface{}
+	if err := dec.Decode(&generic); err != nil {
+		return nil, fmt.Errorf("when decoding response to select fields: %w", err)
+	}
+
+	switch v := generic.(type) {
+	case []interface{}:
+		for _, entry := range v {
+			if obj, ok := entry.(map[string]interface{}); ok {
+				keepFields(obj, fields)
+			}
+		}
+	case map[string]interface{}:
+		keepFields(v, fields)
+	}
+	return generic, nil
+}
+
+func keepFields(obj map[string]interface{}, fields map[string]bool) {
+	for k := range obj {
+		if !fields[k] {
+			delete(obj, k)
+		}
+	}
+}
@@ -0,0 +1,77 @@
+package main
+
+/*
+Copyright 2021 G. Benjamin Morrison
+
+This file is part of getwtxt-ng.
+
+getwtxt-ng is free software: you can redistribute it and/or modify
+it under the terms of the GNU Affero General Public License as
+published by the Free Software Foundation, either version 3 of the
+License, or (at your option) any later version.
+
+getwtxt-ng is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU Affero General Public License for more details.
+
+You should have received a copy of the GNU Affero General Public License
+along with getwtxt-ng.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+import (
+	"database/sql"
+	"errors"
+	"net/http"
+	"strconv"
+
+	"github.com/gorilla/mux"
+
+	"github.com/gbmor/getwtxt-ng/registry"
+)
+
+// filteredTweetsHandler lists the tweets caught by the content filter that are waiting for review.
+func filteredTweetsHandler(w http.ResponseWriter, r *http.Request, conf *Config, dbConn *registry.DB) {
+	if !adminAuthorized(w, r, conf) {
+		return
+	}
+
+	limit := 0
+	if limitStr := r.URL.Query().Get("limit"); limitStr != "" {
+		var err error
+		limit, err = strconv.Atoi(limitStr)
+		if err != nil || limit < 1 {
+			errorWrite(w, r, APIFormatJSON, http.StatusBadRequest, "Invalid limit specified: "+limitStr)
+			return
+		}
+	}
+
+	filtered, err := dbConn.GetFilteredTweets(r.Context(), limit)
+	if err != nil {
+		reqLog(r).Errorf("When retrieving filtered tweets: %s", err)
+		code, message := queryErrorStatus(r, err)
+		errorResponseWrite(w, r, APIFormatJSON, code, MessageResponse{Message: message})
+		return
+	}
+	jsonResponseWrite(w, r, filtered, http.StatusOK)
+}
+
+// releaseFilteredTweetHandler makes a tweet the content filter caught visible again, as a false positive.
+func releaseFilteredTweetHandler(w http.ResponseWriter, r *http.Request, conf *Config, dbConn *registry.DB) {
+	if !adminAuthorized(w, r, conf) {
+		return
+	}
+
+	tweetID := mux.Vars(r)["id"]
+	if err := dbConn.ReleaseFilteredTweet(r.Context(), tweetID); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			errorWrite(w, r, APIFormatJSON, http.StatusNotFound, "No filtered tweet with that ID is waiting for review")
+			return
+		}
+		reqLog(r).Errorf("When releasing filtered tweet %s: %s", tweetID, err)
+		errorWrite(w, r, APIFormatJSON, http.StatusInternalServerError, "")
+		return
+	}
+	reqLog(r).Infof("Released filtered tweet %s", tweetID)
+	jsonResponseWrite(w, r, MessageResponse{Message: "Released"}, http.StatusOK)
+}
@@ -0,0 +1,93 @@
+package main
+
+/*
+Copyright 2021 G. Benjamin Morrison
+
+This file is part of getwtxt-ng.
+
+getwtxt-ng is free software: you can redistribute it and/or modify
+it under the terms of the GNU Affero General Public License as
+published by the Free Software Foundation, either version 3 of the
+License, or (at your option) any later version.
+
+getwtxt-ng is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU Affero General Public License for more details.
+
+You should have received a copy of the GNU Affero General Public License
+along with getwtxt-ng.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gorilla/mux"
+
+	"github.com/gbmor/getwtxt-ng/common"
+	"github.com/gbmor/getwtxt-ng/registry"
+)
+
+func TestFilteredTweetsHandlers(t *testing.T) {
+	ctx := context.Background()
+	hash, err := common.HashPass("hunter2")
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	conf := &Config{ServerConfig: ServerConfig{AdminPassword: string(hash)}}
+	rule, err := registry.NewFilterRule("spam", "hide", []string{"buy now"}, "", nil)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	dbConn, err := registry.Open(":memory:", registry.WithContentFilter(&registry.ContentFilter{Rules: []registry.FilterRule{rule}}))
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	t.Cleanup(func() {
+		_ = dbConn.Close()
+	})
+
+	u := registry.User{Nick: "foo", URL: "https://foo.example/twtxt.txt", PasscodeHash: []byte("hash")}
+	tweets := []registry.Tweet{{DateTime: time.Date(2022, 1, 1, 0, 0, 0, 0, time.UTC), Body: "buy now!"}}
+	if _, err := dbConn.InsertUserWithTweets(ctx, &u, tweets); err != nil {
+		t.Fatal(err.Error())
+	}
+
+	r := mux.NewRouter()
+	setUpRoutes(r, conf, dbConn)
+	serve := func(method, path, pass string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, path, nil)
+		if pass != "" {
+			req.Header.Set("X-Auth", pass)
+		}
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		return w
+	}
+
+	if w := serve(http.MethodGet, "/api/admin/filtered", "wrong"); w.Code != http.StatusForbidden {
+		t.Errorf("Expected %d with the wrong password, got %d", http.StatusForbidden, w.Code)
+	}
+
+	w := serve(http.MethodGet, "/api/admin/filtered", "hunter2")
+	filtered := make([]registry.FilteredTweet, 0)
+	if err := json.NewDecoder(w.Body).Decode(&filtered); err != nil {
+		t.Fatal(err.Error())
+	}
+	if len(filtered) != 1 || filtered[0].Rule != "spam" || filtered[0].Tweet.Hidden != registry.StatusHidden {
+		t.Fatalf("Expected the hidden spam tweet, got %+v", filtered)
+	}
+
+	path := "/api/admin/filtered/" + filtered[0].Tweet.ID + "/release"
+	if w := serve(http.MethodPost, path, "hunter2"); w.Code != http.StatusOK {
+		t.Errorf("Expected %d releasing the tweet, got %d %s", http.StatusOK, w.Code, w.Body.String())
+	}
+	if w := serve(http.MethodPost, path, "hunter2"); w.Code != http.StatusNotFound {
+		t.Errorf("Expected %d releasing the tweet again, got %d", http.StatusNotFound, w.Code)
+	}
+}
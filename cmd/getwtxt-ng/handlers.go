@@ -19,9 +19,13 @@ along with getwtxt-ng.  If not, see <https://www.gnu.org/licenses/>.
 package main
 
 import (
+	"context"
+	_ "embed"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"net/http"
+	"strconv"
 
 	"github.com/gorilla/mux"
 	log "github.com/sirupsen/logrus"
@@ -31,25 +35,146 @@ import (
 )
 
 type JSONResponse interface {
-	MessageResponse | []registry.Tweet | []registry.User
+	MessageResponse | ListEnvelope | []registry.Tweet | []registry.User | *registry.FetchStatus | []registry.Webmention | []registry.KnownRegistry |
+		[]registry.DuplicateUsers | []registry.MergedUser | []registry.FilteredTweet | []registry.DailyStats | []registry.DayCount | []registry.Suggestion | []registry.TagCount | []registry.SyncRun | maintenanceStatus | []registry.Ban | registry.Ban
 }
 
+// ListEnvelope wraps a page of a JSON listing with where it is in the listing, for clients that ask for it.
+type ListEnvelope struct {
+	Data    interface{} `json:"data"`
+	Page    int         `json:"page"`
+	PerPage int         `json:"per_page"`
+
+	// Total is how many entries the listing has, when that's known without counting them.
+	Total *uint32 `json:"total,omitempty"`
+}
+
+// MessageResponse is the body of JSON responses that aren't listings. Error responses also have
+// a code saying what went wrong, their status, and the ID of the request, set by errorResponseWrite.
 type MessageResponse struct {
-	Message       string `json:"message"`
-	Passcode      string `json:"passcode,omitempty"`
-	TweetsDeleted int64  `json:"tweets_deleted,omitempty"`
-	UsersDeleted  int    `json:"users_deleted,omitempty"`
+	Message         string       `json:"message"`
+	Code            string       `json:"code,omitempty"`
+	Status          int          `json:"status,omitempty"`
+	RequestID       string       `json:"request_id,omitempty"`
+	Errors          []FieldError `json:"errors,omitempty"`
+	Passcode        string       `json:"passcode,omitempty"`
+	TweetsDeleted   int64        `json:"tweets_deleted,omitempty"`
+	DeleteAfter     string       `json:"delete_after,omitempty"`
+	TweetsChanged   int64        `json:"tweets_changed,omitempty"`
+	TweetsAdded     int          `json:"tweets_added,omitempty"`
+	PendingApproval bool         `json:"pending_approval,omitempty"`
+	UsersDeleted    int          `json:"users_deleted,omitempty"`
+}
+
+// FieldError describes what's wrong with one field of a request, so clients can point out the input to fix.
+type FieldError struct {
+	Field   string `json:"field"`
+	Code    string `json:"code"`
+	Message string `json:"message"`
+}
+
+// Codes of FieldError.
+const (
+	fieldRequired     = "required"
+	fieldInvalid      = "invalid"
+	fieldNotTwtxt     = "not_twtxt"
+	fieldDuplicate    = "duplicate"
+	fieldInsecure     = "insecure"
+	fieldNickMismatch = "nickname_mismatch"
+	fieldNotFound     = "not_found"
+)
+
+// userNotFoundResponse reports that the user asked about by the url field isn't registered.
+func userNotFoundResponse(userURL string) MessageResponse {
+	return fieldErrorResponse(FieldError{Field: "url", Code: fieldNotFound, Message: fmt.Sprintf("User not found: %s", userURL)})
+}
+
+// fieldErrorResponse is a response rejecting the request because of the provided field errors.
+// Its message is that of the first error, for clients that only show the message.
+func fieldErrorResponse(errs ...FieldError) MessageResponse {
+	msg := MessageResponse{
+		Errors: errs,
+	}
+	if len(errs) > 0 {
+		msg.Message = errs[0].Message
+	}
+	return msg
 }
 
-func jsonResponseWrite[T JSONResponse](w http.ResponseWriter, body T, statusCode int) {
+// jsonResponseWrite writes body as JSON. Successful responses are stripped down to the fields in ?fields=
+// when it's given, so clients can leave out what they don't use; errors are always written whole.
+func jsonResponseWrite[T JSONResponse](w http.ResponseWriter, r *http.Request, body T, statusCode int) {
+	var out interface{} = body
+	if fields := requestedFields(r); fields != nil && statusCode < http.StatusBadRequest {
+		selected, err := selectFields(body, fields)
+		if err != nil {
+			reqLog(r).Errorf("Couldn't select fields of response, writing all of them: %s", err)
+		} else {
+			out = selected
+		}
+	}
+
 	jsonEncoder := json.NewEncoder(w)
 	w.Header().Set("Content-Type", "application/json")
 	w.WriteHeader(statusCode)
-	if err := jsonEncoder.Encode(body); err != nil {
+	if err := jsonEncoder.Encode(out); err != nil {
 		log.Error(err)
 	}
 }
 
+// wantsEnvelope reports whether a JSON listing should be wrapped in a ListEnvelope, as ?envelope= says,
+// or as json_envelope does when that isn't given or can't be read.
+func wantsEnvelope(r *http.Request, conf *Config) bool {
+	if envelope, err := strconv.ParseBool(r.URL.Query().Get("envelope")); err == nil {
+		return envelope
+	}
+	conf.mu.RLock()
+	defer conf.mu.RUnlock()
+	return conf.ServerConfig.JSONEnvelope
+}
+
+// jsonListWrite writes the provided page of a listing as a bare JSON array, or in a ListEnvelope if the request
+// wants one. total counts the entries in the listing, and is nil when they can't be counted cheaply.
+func jsonListWrite[T []registry.Tweet | []registry.User](w http.ResponseWriter, r *http.Request, conf *Config, dbConn *registry.DB,
+	list T, page, perPage int, total func(context.Context, *registry.DB) (uint32, error)) {
+	if !wantsEnvelope(r, conf) {
+		jsonResponseWrite(w, r, list, http.StatusOK)
+		return
+	}
+
+	page, perPage = dbConn.PageBounds(page, perPage)
+	envelope := ListEnvelope{
+		Data:    list,
+		Page:    page,
+		PerPage: perPage,
+	}
+	if total != nil {
+		n, err := total(r.Context(), dbConn)
+		if err != nil {
+			reqLog(r).Errorf("When counting entries for JSON envelope: %s", err)
+		} else {
+			envelope.Total = &n
+		}
+	}
+	jsonResponseWrite(w, r, envelope, http.StatusOK)
+}
+
+// tweetTotal counts the tweets stored, from the running count kept by the database.
+func tweetTotal(ctx context.Context, dbConn *registry.DB) (uint32, error) {
+	if err := dbConn.SetTweetCount(ctx); err != nil {
+		return 0, err
+	}
+	return dbConn.GetTweetCount(), nil
+}
+
+// userTotal counts the users registered, from the running count kept by the database.
+func userTotal(ctx context.Context, dbConn *registry.DB) (uint32, error) {
+	if err := dbConn.SetUserCount(ctx); err != nil {
+		return 0, err
+	}
+	return dbConn.GetUserCount(), nil
+}
+
 func plainResponseWrite(w http.ResponseWriter, body string, statusCode int) {
 	w.Header().Set("Content-Type", "text/plain")
 	w.WriteHeader(statusCode)
@@ -58,6 +183,36 @@ func plainResponseWrite(w http.ResponseWriter, body string, statusCode int) {
 	}
 }
 
+// adminAuthorized checks the X-Auth header against the admin password, responding with a 403 if it doesn't match.
+func adminAuthorized(w http.ResponseWriter, r *http.Request, conf *Config) bool {
+	conf.mu.RLock()
+	adminPassword := conf.ServerConfig.AdminPassword
+	conf.mu.RUnlock()
+	pass := r.Header.Get("X-Auth")
+	if pass == "" || !common.ValidatePass(pass, []byte(adminPassword)) {
+		errorResponseWrite(w, r, APIFormatJSON, http.StatusForbidden, MessageResponse{})
+		return false
+	}
+	return true
+}
+
+// queryErrorStatus picks the status and message for a failed registry read. A read cut off by the
+// query timeout is reported as 503 rather than 500, so clients know to try again later, one cut off
+// because the whole request ran past request_timeout is a 504, and a read about a user that isn't
+// registered is a 404.
+func queryErrorStatus(r *http.Request, err error) (int, string) {
+	if errors.Is(err, registry.ErrUserNotFound) {
+		return http.StatusNotFound, "Not Found: no user is registered with that URL"
+	}
+	if errors.Is(r.Context().Err(), context.DeadlineExceeded) {
+		return http.StatusGatewayTimeout, "Gateway Timeout: the request took too long, please try again later"
+	}
+	if errors.Is(err, context.DeadlineExceeded) {
+		return http.StatusServiceUnavailable, "Service Unavailable: the registry took too long to answer, please try again later"
+	}
+	return http.StatusInternalServerError, "Internal Server Error"
+}
+
 // This just responds with the most recent tagged version.
 func versionHandler(w http.ResponseWriter, r *http.Request) {
 	vars := mux.Vars(r)
@@ -69,11 +224,23 @@ func versionHandler(w http.ResponseWriter, r *http.Request) {
 		msg := MessageResponse{
 			Message: versionString,
 		}
-		jsonResponseWrite(w, msg, http.StatusOK)
+		jsonResponseWrite(w, r, msg, http.StatusOK)
 	case APIFormatPlain:
 		plainResponseWrite(w, versionString, http.StatusOK)
 	default:
-		http.Error(w, "404 Not Found", http.StatusNotFound)
+		errorWrite(w, r, errorFormat(r), http.StatusNotFound, "")
+	}
+}
+
+// openAPISpec describes the JSON API and its error responses.
+//
+//go:embed openapi.yaml
+var openAPISpec []byte
+
+func openAPIHandler(w http.ResponseWriter, _ *http.Request) {
+	w.Header().Set("Content-Type", "application/yaml")
+	if _, err := w.Write(openAPISpec); err != nil {
+		log.Error(err)
 	}
 }
 
@@ -86,27 +253,30 @@ func cssHandler(w http.ResponseWriter, _ *http.Request, conf *Config) {
 
 func indexHandler(w http.ResponseWriter, r *http.Request, conf *Config, dbConn *registry.DB) {
 	w.Header().Set("Content-Type", "text/html")
+	setContentLanguage(w, r)
 	conf.InstanceConfig.PopulateFields(r.Context(), dbConn)
-	if err := conf.Assets.IndexTemplate.Execute(w, conf.InstanceConfig); err != nil {
-		log.Error(err)
-		http.Error(w, "500 Internal Server Error", http.StatusInternalServerError)
+	if err := conf.Assets.IndexTemplate.Execute(w, pageData{InstanceConfig: conf.InstanceConfig, localizer: requestLocalizer(r)}); err != nil {
+		reqLog(r).Error(err)
+		errorWrite(w, r, errorFormat(r), http.StatusInternalServerError, "")
 	}
 }
 
 func plainDocsHandler(w http.ResponseWriter, r *http.Request, conf *Config, dbConn *registry.DB) {
 	w.Header().Set("Content-Type", "text/html")
+	setContentLanguage(w, r)
 	conf.InstanceConfig.PopulateFields(r.Context(), dbConn)
-	if err := conf.Assets.PlainDocsTemplate.Execute(w, conf.InstanceConfig); err != nil {
-		log.Error(err)
-		http.Error(w, "500 Internal Server Error", http.StatusInternalServerError)
+	if err := conf.Assets.PlainDocsTemplate.Execute(w, pageData{InstanceConfig: conf.InstanceConfig, localizer: requestLocalizer(r)}); err != nil {
+		reqLog(r).Error(err)
+		errorWrite(w, r, errorFormat(r), http.StatusInternalServerError, "")
 	}
 }
 
 func jsonDocsHandler(w http.ResponseWriter, r *http.Request, conf *Config, dbConn *registry.DB) {
 	w.Header().Set("Content-Type", "text/html")
+	setContentLanguage(w, r)
 	conf.InstanceConfig.PopulateFields(r.Context(), dbConn)
-	if err := conf.Assets.JSONDocsTemplate.Execute(w, conf.InstanceConfig); err != nil {
-		log.Error(err)
-		http.Error(w, "500 Internal Server Error", http.StatusInternalServerError)
+	if err := conf.Assets.JSONDocsTemplate.Execute(w, pageData{InstanceConfig: conf.InstanceConfig, localizer: requestLocalizer(r)}); err != nil {
+		reqLog(r).Error(err)
+		errorWrite(w, r, errorFormat(r), http.StatusInternalServerError, "")
 	}
 }
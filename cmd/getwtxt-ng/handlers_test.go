@@ -0,0 +1,389 @@
+package main
+
+/*
+Copyright 2021 G. Benjamin Morrison
+
+This file is part of getwtxt-ng.
+
+getwtxt-ng is free software: you can redistribute it and/or modify
+it under the terms of the GNU Affero General Public License as
+published by the Free Software Foundation, either version 3 of the
+License, or (at your option) any later version.
+
+getwtxt-ng is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU Affero General Public License for more details.
+
+You should have received a copy of the GNU Affero General Public License
+along with getwtxt-ng.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/mux"
+
+	"github.com/gbmor/getwtxt-ng/registry"
+)
+
+func Test_queryErrorStatus(t *testing.T) {
+	r := httptest.NewRequest(http.MethodGet, "/api/json/tweets", nil)
+	code, _ := queryErrorStatus(r, fmt.Errorf("when searching tweets: %w", context.DeadlineExceeded))
+	if code != http.StatusServiceUnavailable {
+		t.Errorf("Expected %d for a read that was cut off, got %d", http.StatusServiceUnavailable, code)
+	}
+	code, msg := queryErrorStatus(r, errors.New("disk on fire"))
+	if code != http.StatusInternalServerError || msg != "Internal Server Error" {
+		t.Errorf("Expected %d Internal Server Error, got %d %s", http.StatusInternalServerError, code, msg)
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), 0)
+	defer cancel()
+	code, _ = queryErrorStatus(r.WithContext(ctx), fmt.Errorf("when searching tweets: %w", ctx.Err()))
+	if code != http.StatusGatewayTimeout {
+		t.Errorf("Expected %d for a request past its deadline, got %d", http.StatusGatewayTimeout, code)
+	}
+}
+
+func TestWithRecovery(t *testing.T) {
+	handler := withRequestID(withRecovery(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Query().Get("started") != "" {
+			w.WriteHeader(http.StatusOK)
+		}
+		panic("bad request")
+	})))
+
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/api/json/tweets", nil))
+	resp := MessageResponse{}
+	if err := json.NewDecoder(w.Body).Decode(&resp); err != nil {
+		t.Fatal(err.Error())
+	}
+	if w.Code != http.StatusInternalServerError || resp.Code != errCodeInternal || resp.RequestID != w.Header().Get("X-Request-ID") {
+		t.Errorf("Expected a JSON 500 with the request ID, got %d %+v", w.Code, resp)
+	}
+
+	w = httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/api/plain/tweets", nil))
+	if w.Code != http.StatusInternalServerError || !strings.HasPrefix(w.Body.String(), "500\t"+errCodeInternal+"\t") {
+		t.Errorf("Expected a plain 500, got %d %q", w.Code, w.Body.String())
+	}
+
+	w = httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/api/json/tweets?started=1", nil))
+	if w.Code != http.StatusOK || w.Body.Len() != 0 {
+		t.Errorf("Expected the started response to be left alone, got %d %q", w.Code, w.Body.String())
+	}
+}
+
+func TestWithRequestTimeout(t *testing.T) {
+	dbConn := getFederationDB(t)
+	conf := &Config{}
+	conf.ServerConfig.RequestTimeout = time.Nanosecond
+	r := mux.NewRouter()
+	setUpRoutes(r, conf, dbConn)
+	handler := withRequestTimeout(conf, r)
+
+	req := httptest.NewRequest(http.MethodGet, "/api/json/tweets", nil)
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, req)
+	resp := MessageResponse{}
+	if err := json.NewDecoder(w.Body).Decode(&resp); err != nil {
+		t.Fatal(err.Error())
+	}
+	if w.Code != http.StatusGatewayTimeout || resp.Code != errCodeTimeout {
+		t.Errorf("Expected %d %s, got %d %+v", http.StatusGatewayTimeout, errCodeTimeout, w.Code, resp)
+	}
+}
+
+func TestJSONAddUserHandler_fieldErrors(t *testing.T) {
+	dbConn := getFederationDB(t)
+	conf := &Config{}
+
+	cases := []struct {
+		name string
+		body string
+		want []FieldError
+	}{
+		{
+			name: "missing nickname and url",
+			body: `{}`,
+			want: []FieldError{{Field: "nickname", Code: fieldRequired}, {Field: "url", Code: fieldRequired}},
+		},
+		{
+			name: "invalid nickname",
+			body: `{"nickname": "!!!", "url": "https://example.com/twtxt.txt"}`,
+			want: []FieldError{{Field: "nickname", Code: fieldInvalid}},
+		},
+		{
+			name: "not a twtxt file",
+			body: `{"nickname": "foo", "url": "https://example.com/index.html"}`,
+			want: []FieldError{{Field: "url", Code: fieldNotTwtxt}},
+		},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodPost, "/api/json/users", strings.NewReader(tc.body))
+			w := httptest.NewRecorder()
+			jsonAddUserHandler(w, req, conf, dbConn)
+
+			if w.Code != http.StatusBadRequest {
+				t.Fatalf("Expected 400, got %d", w.Code)
+			}
+			resp := MessageResponse{}
+			if err := json.NewDecoder(w.Body).Decode(&resp); err != nil {
+				t.Fatal(err.Error())
+			}
+			if len(resp.Errors) != len(tc.want) {
+				t.Fatalf("Expected %d field errors, got: %+v", len(tc.want), resp.Errors)
+			}
+			for i, want := range tc.want {
+				got := resp.Errors[i]
+				if got.Field != want.Field || got.Code != want.Code || got.Message == "" {
+					t.Errorf("Expected %s %s, got: %+v", want.Field, want.Code, got)
+				}
+			}
+			if resp.Message != resp.Errors[0].Message {
+				t.Errorf("Expected message of first error, got: %s", resp.Message)
+			}
+			if resp.Code != errCodeInvalidFields || resp.Status != http.StatusBadRequest || resp.RequestID == "" {
+				t.Errorf("Expected invalid_fields, 400, and a request ID, got: %+v", resp)
+			}
+		})
+	}
+}
+
+func TestJSONListWrite_envelope(t *testing.T) {
+	dbConn := getFederationDB(t)
+	conf := &Config{}
+
+	req := httptest.NewRequest(http.MethodGet, "/api/json/users?envelope=true", nil)
+	w := httptest.NewRecorder()
+	getLatestUsersHandler(w, req, conf, dbConn, 0, 0, APIFormatJSON)
+
+	envelope := struct {
+		Data    []json.RawMessage `json:"data"`
+		Page    int               `json:"page"`
+		PerPage int               `json:"per_page"`
+		Total   *uint32           `json:"total"`
+	}{}
+	if err := json.NewDecoder(w.Body).Decode(&envelope); err != nil {
+		t.Fatal(err.Error())
+	}
+	if envelope.Data == nil || envelope.Page != 1 || envelope.PerPage != dbConn.EntriesPerPageMin {
+		t.Errorf("Expected first page of %d, got: %+v", dbConn.EntriesPerPageMin, envelope)
+	}
+	if envelope.Total == nil || *envelope.Total != 0 {
+		t.Errorf("Expected total of 0, got: %v", envelope.Total)
+	}
+
+	conf.ServerConfig.JSONEnvelope = true
+	req = httptest.NewRequest(http.MethodGet, "/api/json/users?envelope=false", nil)
+	w = httptest.NewRecorder()
+	getLatestUsersHandler(w, req, conf, dbConn, 0, 0, APIFormatJSON)
+	if body := strings.TrimSpace(w.Body.String()); !strings.HasPrefix(body, "[") {
+		t.Errorf("Expected bare array with ?envelope=false, got: %s", body)
+	}
+}
+
+func TestJSONResponseWrite_fields(t *testing.T) {
+	users := []registry.User{{ID: "1", Nick: "foo", URL: "https://foo.example/twtxt.txt", Description: "hi"}}
+
+	req := httptest.NewRequest(http.MethodGet, "/api/json/users?fields=nickname,+url,bogus", nil)
+	w := httptest.NewRecorder()
+	jsonResponseWrite(w, req, users, http.StatusOK)
+	if body := strings.TrimSpace(w.Body.String()); body != `[{"nickname":"foo","url":"https://foo.example/twtxt.txt"}]` {
+		t.Errorf("Expected only nickname and url, got: %s", body)
+	}
+
+	req = httptest.NewRequest(http.MethodGet, "/api/json/users?envelope=true&fields=id", nil)
+	w = httptest.NewRecorder()
+	jsonResponseWrite(w, req, ListEnvelope{Data: users, Page: 1, PerPage: 20}, http.StatusOK)
+	if body := strings.TrimSpace(w.Body.String()); body != `{"data":[{"id":"1"}],"page":1,"per_page":20}` {
+		t.Errorf("Expected the envelope kept with only IDs in its data, got: %s", body)
+	}
+
+	req = httptest.NewRequest(http.MethodPost, "/api/json/users?fields=message", nil)
+	w = httptest.NewRecorder()
+	jsonResponseWrite(w, req, MessageResponse{Message: "Added", TweetsAdded: 12}, http.StatusOK)
+	if body := strings.TrimSpace(w.Body.String()); body != `{"message":"Added"}` {
+		t.Errorf("Expected only the message, got: %s", body)
+	}
+
+	w = httptest.NewRecorder()
+	jsonResponseWrite(w, req, MessageResponse{Message: "Bad Request", Code: errCodeBadRequest}, http.StatusBadRequest)
+	if resp := (MessageResponse{}); json.NewDecoder(w.Body).Decode(&resp) != nil || resp.Code != errCodeBadRequest {
+		t.Errorf("Expected errors to be written whole, got: %+v", resp)
+	}
+}
+
+func TestGetTweetsHandler_lang(t *testing.T) {
+	dbConn := getFederationDB(t)
+	conf := &Config{}
+
+	req := httptest.NewRequest(http.MethodGet, "/api/json/tweets?lang=english", nil)
+	w := httptest.NewRecorder()
+	getTweetsHandler(w, req, conf, dbConn, APIFormatJSON)
+	if w.Code != http.StatusBadRequest {
+		t.Fatalf("Expected 400, got %d", w.Code)
+	}
+	resp := MessageResponse{}
+	if err := json.NewDecoder(w.Body).Decode(&resp); err != nil {
+		t.Fatal(err.Error())
+	}
+	if len(resp.Errors) != 1 || resp.Errors[0].Field != "lang" || resp.Errors[0].Code != fieldInvalid {
+		t.Errorf("Expected lang invalid, got: %+v", resp.Errors)
+	}
+
+	for _, target := range []string{"/api/json/tweets?lang=de", "/api/json/tweets?lang=de&q=getwtxt"} {
+		w = httptest.NewRecorder()
+		getTweetsHandler(w, httptest.NewRequest(http.MethodGet, target, nil), conf, dbConn, APIFormatJSON)
+		if w.Code != http.StatusOK {
+			t.Errorf("Expected 200 from %s, got %d: %s", target, w.Code, w.Body.String())
+		}
+	}
+}
+
+func TestUnknownUserNotFound(t *testing.T) {
+	dbConn := getFederationDB(t)
+	conf := &Config{}
+	unknown := "https://example.net/twtxt.txt"
+
+	cases := []struct {
+		name    string
+		target  string
+		handler func(http.ResponseWriter, *http.Request)
+	}{
+		{
+			name:   "mentions",
+			target: "/api/json/mentions?url=" + unknown,
+			handler: func(w http.ResponseWriter, r *http.Request) {
+				getMentionsHandler(w, r, conf, dbConn, APIFormatJSON)
+			},
+		},
+		{
+			name:   "status",
+			target: "/api/json/users/status?url=" + unknown,
+			handler: func(w http.ResponseWriter, r *http.Request) {
+				getUserStatusHandler(w, r, conf, dbConn, APIFormatJSON)
+			},
+		},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			tc.handler(w, httptest.NewRequest(http.MethodGet, tc.target, nil))
+
+			if w.Code != http.StatusNotFound {
+				t.Fatalf("Expected 404, got %d", w.Code)
+			}
+			resp := MessageResponse{}
+			if err := json.NewDecoder(w.Body).Decode(&resp); err != nil {
+				t.Fatal(err.Error())
+			}
+			if len(resp.Errors) != 1 || resp.Errors[0].Field != "url" || resp.Errors[0].Code != fieldNotFound {
+				t.Errorf("Expected url not_found, got: %+v", resp.Errors)
+			}
+		})
+	}
+}
+
+func TestErrorResponseWrite(t *testing.T) {
+	dbConn := getFederationDB(t)
+	r := mux.NewRouter()
+	setUpRoutes(r, &Config{}, dbConn)
+
+	req := httptest.NewRequest(http.MethodGet, "/api/json/nothing", nil)
+	req.Header.Set("X-Request-ID", "abc-123")
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	resp := MessageResponse{}
+	if err := json.NewDecoder(w.Body).Decode(&resp); err != nil {
+		t.Fatal(err.Error())
+	}
+	want := MessageResponse{Message: "Not Found", Code: errCodeNotFound, Status: http.StatusNotFound, RequestID: "abc-123"}
+	if w.Code != http.StatusNotFound || resp.Message != want.Message || resp.Code != want.Code || resp.Status != want.Status || resp.RequestID != want.RequestID {
+		t.Errorf("Expected %+v, got %d %+v", want, w.Code, resp)
+	}
+
+	req = httptest.NewRequest(http.MethodGet, "/api/plain/tweets?page=x", nil)
+	req.Header.Set("X-Request-ID", "not a usable id")
+	w = httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	fields := strings.Split(strings.TrimSuffix(w.Body.String(), "\n"), "\t")
+	if len(fields) != 4 || fields[0] != "400" || fields[1] != errCodeInvalidFields || fields[3] != "Invalid page specified: x" {
+		t.Fatalf("Unexpected plain error: %q", w.Body.String())
+	}
+	if fields[2] == "" || fields[2] == "not a usable id" || w.Header().Get("X-Request-ID") != fields[2] {
+		t.Errorf("Expected a generated request ID matching the header, got %q and %q", fields[2], w.Header().Get("X-Request-ID"))
+	}
+}
+
+func TestWithRequestID(t *testing.T) {
+	var seen []string
+	handler := withRequestID(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		seen = append(seen, requestID(r), requestID(r))
+	}))
+
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/", nil))
+	id := w.Header().Get("X-Request-ID")
+	if len(id) != 16 || seen[0] != id || seen[1] != id {
+		t.Errorf("Expected one generated ID for the whole request, got header %q and %v", id, seen)
+	}
+
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	req.Header.Set("X-Request-ID", "abc-123")
+	w = httptest.NewRecorder()
+	handler.ServeHTTP(w, req)
+	if w.Header().Get("X-Request-ID") != "abc-123" || seen[2] != "abc-123" {
+		t.Errorf("Expected the client's ID abc-123, got %q and %q", w.Header().Get("X-Request-ID"), seen[2])
+	}
+}
+
+func TestSuggestUsersHandler(t *testing.T) {
+	dbConn := getFederationDB(t)
+
+	w := httptest.NewRecorder()
+	suggestUsersHandler(w, httptest.NewRequest(http.MethodGet, "/api/json/suggest?q=foo", nil), dbConn, APIFormatJSON)
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected 200, got %d: %s", w.Code, w.Body.String())
+	}
+	if cc := w.Header().Get("Cache-Control"); !strings.Contains(cc, "max-age=") {
+		t.Errorf("Expected suggestions to be cacheable, got Cache-Control %q", cc)
+	}
+
+	w = httptest.NewRecorder()
+	suggestUsersHandler(w, httptest.NewRequest(http.MethodGet, "/api/json/suggest", nil), dbConn, APIFormatJSON)
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("Expected 400 without q, got %d", w.Code)
+	}
+}
+
+func TestSuggestTagsHandler(t *testing.T) {
+	dbConn := getFederationDB(t)
+
+	w := httptest.NewRecorder()
+	suggestTagsHandler(w, httptest.NewRequest(http.MethodGet, "/api/plain/tags/suggest?q=pro", nil), dbConn, APIFormatPlain)
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected 200, got %d: %s", w.Code, w.Body.String())
+	}
+	if cc := w.Header().Get("Cache-Control"); !strings.Contains(cc, "max-age=") {
+		t.Errorf("Expected suggestions to be cacheable, got Cache-Control %q", cc)
+	}
+
+	w = httptest.NewRecorder()
+	suggestTagsHandler(w, httptest.NewRequest(http.MethodGet, "/api/json/tags/suggest?q=%23", nil), dbConn, APIFormatJSON)
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("Expected 400 without a tag in q, got %d", w.Code)
+	}
+}
@@ -19,111 +19,266 @@ along with getwtxt-ng.  If not, see <https://www.gnu.org/licenses/>.
 package main
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"net/http"
 	"strconv"
-
-	log "github.com/sirupsen/logrus"
+	"strings"
+	"time"
 
 	"github.com/gbmor/getwtxt-ng/registry"
 )
 
-func getTweetsHandler(w http.ResponseWriter, r *http.Request, dbConn *registry.DB, format APIFormat) {
+func getTweetsHandler(w http.ResponseWriter, r *http.Request, conf *Config, dbConn *registry.DB, format APIFormat) {
 	var err error
 	_ = r.ParseForm()
 	pageStr := r.Form.Get("page")
 	perPageStr := r.Form.Get("per_page")
 	searchTerm := r.Form.Get("q")
+	sinceStr := r.Form.Get("since")
+	hash := r.Form.Get("hash")
+	lang := r.Form.Get("lang")
 
 	page := 0
 	perPage := 0
 	if pageStr != "" {
 		page, err = strconv.Atoi(pageStr)
 		if err != nil {
-			msg := MessageResponse{
-				Message: fmt.Sprintf("Invalid page specified: %s", pageStr),
-			}
-			if format == APIFormatPlain {
-				plainResponseWrite(w, msg.Message, http.StatusBadRequest)
-			} else if format == APIFormatJSON {
-				jsonResponseWrite(w, msg, http.StatusBadRequest)
-			}
+			msg := fieldErrorResponse(FieldError{Field: "page", Code: fieldInvalid, Message: fmt.Sprintf("Invalid page specified: %s", pageStr)})
+			errorResponseWrite(w, r, format, http.StatusBadRequest, msg)
 			return
 		}
 	}
 	if perPageStr != "" {
 		perPage, err = strconv.Atoi(perPageStr)
 		if err != nil {
-			msg := MessageResponse{
-				Message: fmt.Sprintf("Invalid per page count specified: %s", perPageStr),
-			}
-			if format == APIFormatPlain {
-				plainResponseWrite(w, msg.Message, http.StatusBadRequest)
-			} else if format == APIFormatJSON {
-				jsonResponseWrite(w, msg, http.StatusBadRequest)
-			}
+			msg := fieldErrorResponse(FieldError{Field: "per_page", Code: fieldInvalid, Message: fmt.Sprintf("Invalid per page count specified: %s", perPageStr)})
+			errorResponseWrite(w, r, format, http.StatusBadRequest, msg)
 			return
 		}
 	}
 
+	if lang != "" && !registry.RegexLanguageCode.MatchString(lang) {
+		msg := fieldErrorResponse(FieldError{Field: "lang", Code: fieldInvalid, Message: fmt.Sprintf("Invalid language specified, expected a two-letter code: %s", lang)})
+		errorResponseWrite(w, r, format, http.StatusBadRequest, msg)
+		return
+	}
+
+	download, ok := wantsTwtxtDownload(w, r, format)
+	if !ok {
+		return
+	}
+	if download && (sinceStr != "" || hash != "") {
+		msg := fieldErrorResponse(FieldError{Field: "download", Code: fieldInvalid, Message: "Downloads can't be combined with since or hash"})
+		errorResponseWrite(w, r, format, http.StatusBadRequest, msg)
+		return
+	}
+
+	if sinceStr != "" {
+		since, err := time.Parse(time.RFC3339Nano, sinceStr)
+		if err != nil {
+			msg := fieldErrorResponse(FieldError{Field: "since", Code: fieldInvalid, Message: fmt.Sprintf("Invalid since timestamp specified, expected RFC3339: %s", sinceStr)})
+			errorResponseWrite(w, r, format, http.StatusBadRequest, msg)
+			return
+		}
+		getTweetsSinceHandler(w, r, conf, dbConn, since, perPage, format)
+		return
+	}
+
+	if hash != "" {
+		getTweetsByHashHandler(w, r, conf, dbConn, hash, format)
+		return
+	}
+
+	if download {
+		downloadTweetsHandler(w, r, conf, dbConn, format, searchTerm, lang)
+		return
+	}
+
 	if searchTerm == "" {
-		getLatestTweetsHandler(w, r, dbConn, page, perPage, format)
+		getLatestTweetsHandler(w, r, conf, dbConn, page, perPage, format, lang)
 	} else {
-		searchTweetsHandler(w, r, dbConn, page, perPage, format, searchTerm)
+		searchTweetsHandler(w, r, conf, dbConn, page, perPage, format, searchTerm, lang)
+	}
+}
+
+// downloadTweetsHandler responds with all of the newest tweets, or those matching searchTerm if it isn't empty,
+// as a twtxt file. Only tweets in lang are included if it isn't empty.
+func downloadTweetsHandler(w http.ResponseWriter, r *http.Request, conf *Config, dbConn *registry.DB, format APIFormat, searchTerm, lang string) {
+	filename := "tweets.txt"
+	description := "Tweets"
+	if searchTerm != "" {
+		filename = "search.txt"
+		description = fmt.Sprintf("Tweets matching %q", searchTerm)
 	}
+	if lang != "" {
+		description = fmt.Sprintf("%s in language %s", description, lang)
+	}
+
+	twtxtDownloadWrite(w, r, conf, dbConn, format, filename, description, func(ctx context.Context, page, perPage int) ([]registry.Tweet, error) {
+		switch {
+		case searchTerm == "" && lang == "":
+			return dbConn.GetTweets(ctx, page, perPage, registry.StatusVisible)
+		case searchTerm == "":
+			return dbConn.GetTweetsInLanguage(ctx, page, perPage, lang, registry.StatusVisible)
+		case lang == "":
+			return dbConn.SearchTweets(ctx, page, perPage, searchTerm, registry.StatusVisible)
+		default:
+			return dbConn.SearchTweetsInLanguage(ctx, page, perPage, searchTerm, lang, registry.StatusVisible)
+		}
+	})
 }
 
-func getLatestTweetsHandler(w http.ResponseWriter, r *http.Request, dbConn *registry.DB, page, perPage int, format APIFormat) {
+// getLatestTweetsHandler responds with a page of the newest tweets, only those in lang if it isn't empty.
+func getLatestTweetsHandler(w http.ResponseWriter, r *http.Request, conf *Config, dbConn *registry.DB, page, perPage int, format APIFormat, lang string) {
 	ctx := r.Context()
 
-	tweets, err := dbConn.GetTweets(ctx, page, perPage, registry.StatusVisible)
+	var tweets []registry.Tweet
+	var err error
+	total := tweetTotal
+	if lang == "" {
+		tweets, err = dbConn.GetTweets(ctx, page, perPage, registry.StatusVisible)
+	} else {
+		tweets, err = dbConn.GetTweetsInLanguage(ctx, page, perPage, lang, registry.StatusVisible)
+		total = nil
+	}
+	if err != nil {
+		reqLog(r).Errorf("When retrieving latest tweets, page %d, per page %d: %s", page, perPage, err)
+		code, message := queryErrorStatus(r, err)
+		msg := MessageResponse{
+			Message: message,
+		}
+		errorResponseWrite(w, r, format, code, msg)
+		return
+	}
+
+	if format == APIFormatPlain {
+		out := registry.FormatTweetsPlainWith(tweets, plainFormat(conf))
+		plainResponseWrite(w, out, http.StatusOK)
+	} else if format == APIFormatJSON {
+		jsonListWrite(w, r, conf, dbConn, tweets, page, perPage, total)
+	}
+}
+
+// getConversationHandler responds with the tweets in the thread started by the tweet with the provided hash, oldest first.
+func getConversationHandler(w http.ResponseWriter, r *http.Request, conf *Config, dbConn *registry.DB, format APIFormat, hash string) {
+	ctx := r.Context()
+
+	tweets, err := dbConn.GetConversation(ctx, hash)
 	if err != nil {
-		log.Errorf("When retrieving latest tweets, page %d, per page %d: %s", page, perPage, err)
+		code, message := queryErrorStatus(r, err)
+		msg := MessageResponse{
+			Message: message,
+		}
+		if errors.Is(err, registry.ErrInvalidTwtHash) {
+			code = http.StatusBadRequest
+			msg.Message = fmt.Sprintf("Invalid twt hash: %s", hash)
+		} else {
+			reqLog(r).Errorf("When retrieving conversation %s: %s", hash, err)
+		}
+		errorResponseWrite(w, r, format, code, msg)
+		return
+	}
+	if len(tweets) == 0 {
 		msg := MessageResponse{
-			Message: "Internal Server Error",
+			Message: fmt.Sprintf("Conversation not found: %s", hash),
 		}
-		if format == APIFormatPlain {
-			plainResponseWrite(w, msg.Message, http.StatusInternalServerError)
-		} else if format == APIFormatJSON {
-			jsonResponseWrite(w, msg, http.StatusInternalServerError)
+		errorResponseWrite(w, r, format, http.StatusNotFound, msg)
+		return
+	}
+
+	if format == APIFormatPlain {
+		out := registry.FormatTweetsPlainWith(tweets, plainFormat(conf))
+		plainResponseWrite(w, out, http.StatusOK)
+	} else if format == APIFormatJSON {
+		jsonResponseWrite(w, r, tweets, http.StatusOK)
+	}
+}
+
+// getTweetsByHashHandler responds with the tweets that have the provided twt hash.
+func getTweetsByHashHandler(w http.ResponseWriter, r *http.Request, conf *Config, dbConn *registry.DB, hash string, format APIFormat) {
+	ctx := r.Context()
+
+	tweets, err := dbConn.GetTweetsByHash(ctx, hash)
+	if err != nil {
+		reqLog(r).Errorf("When retrieving tweets with hash %s: %s", hash, err)
+		code, message := queryErrorStatus(r, err)
+		msg := MessageResponse{
+			Message: message,
 		}
+		errorResponseWrite(w, r, format, code, msg)
 		return
 	}
 
 	if format == APIFormatPlain {
-		out := registry.FormatTweetsPlain(tweets)
+		out := registry.FormatTweetsPlainWith(tweets, plainFormat(conf))
 		plainResponseWrite(w, out, http.StatusOK)
 	} else if format == APIFormatJSON {
-		jsonResponseWrite(w, tweets, http.StatusOK)
+		jsonResponseWrite(w, r, tweets, http.StatusOK)
 	}
 }
 
-func searchTweetsHandler(w http.ResponseWriter, r *http.Request, dbConn *registry.DB, page, perPage int, format APIFormat, searchTerm string) {
+// getTweetsSinceHandler responds with the tweets ingested after since, oldest first.
+// The X-Next-Since header holds the watermark to pass on the next request.
+func getTweetsSinceHandler(w http.ResponseWriter, r *http.Request, conf *Config, dbConn *registry.DB, since time.Time, limit int, format APIFormat) {
 	ctx := r.Context()
 
-	tweets, err := dbConn.SearchTweets(ctx, page, perPage, searchTerm, registry.StatusVisible)
+	tweets, err := dbConn.GetTweetsSince(ctx, since, limit)
 	if err != nil {
-		log.Errorf("When searching for tweets containing %s, page %d, per page %d: %s", searchTerm, page, perPage, searchTerm)
+		reqLog(r).Errorf("When retrieving tweets ingested since %s, limit %d: %s", since.Format(time.RFC3339Nano), limit, err)
+		code, message := queryErrorStatus(r, err)
 		msg := MessageResponse{
-			Message: "Internal Server Error",
+			Message: message,
 		}
-		if format == APIFormatPlain {
-			plainResponseWrite(w, msg.Message, http.StatusInternalServerError)
-		} else if format == APIFormatJSON {
-			jsonResponseWrite(w, msg, http.StatusInternalServerError)
+		errorResponseWrite(w, r, format, code, msg)
+		return
+	}
+
+	next := since
+	if len(tweets) > 0 {
+		next = tweets[len(tweets)-1].Ingested
+	}
+	w.Header().Set("X-Next-Since", next.UTC().Format(time.RFC3339Nano))
+
+	if format == APIFormatPlain {
+		out := registry.FormatTweetsPlainWith(tweets, plainFormat(conf))
+		plainResponseWrite(w, out, http.StatusOK)
+	} else if format == APIFormatJSON {
+		jsonResponseWrite(w, r, tweets, http.StatusOK)
+	}
+}
+
+// searchTweetsHandler responds with a page of the tweets matching searchTerm, only those in lang if it isn't empty.
+func searchTweetsHandler(w http.ResponseWriter, r *http.Request, conf *Config, dbConn *registry.DB, page, perPage int, format APIFormat, searchTerm, lang string) {
+	ctx := r.Context()
+
+	var tweets []registry.Tweet
+	var err error
+	if lang == "" {
+		tweets, err = dbConn.SearchTweets(ctx, page, perPage, searchTerm, registry.StatusVisible)
+	} else {
+		tweets, err = dbConn.SearchTweetsInLanguage(ctx, page, perPage, searchTerm, lang, registry.StatusVisible)
+	}
+	if err != nil {
+		reqLog(r).Errorf("When searching for tweets containing %s, page %d, per page %d: %s", searchTerm, page, perPage, searchTerm)
+		code, message := queryErrorStatus(r, err)
+		msg := MessageResponse{
+			Message: message,
 		}
+		errorResponseWrite(w, r, format, code, msg)
 		return
 	}
 
 	if format == APIFormatPlain {
-		out := registry.FormatTweetsPlain(tweets)
+		out := registry.FormatTweetsPlainWith(tweets, plainFormat(conf))
 		plainResponseWrite(w, out, http.StatusOK)
 	} else if format == APIFormatJSON {
-		jsonResponseWrite(w, tweets, http.StatusOK)
+		jsonListWrite(w, r, conf, dbConn, tweets, page, perPage, nil)
 	}
 }
 
-func getMentionsHandler(w http.ResponseWriter, r *http.Request, dbConn *registry.DB, format APIFormat) {
+func getMentionsHandler(w http.ResponseWriter, r *http.Request, conf *Config, dbConn *registry.DB, format APIFormat) {
 	ctx := r.Context()
 	var err error
 	var tweets []registry.Tweet
@@ -137,28 +292,35 @@ func getMentionsHandler(w http.ResponseWriter, r *http.Request, dbConn *registry
 	if pageStr != "" {
 		page, err = strconv.Atoi(pageStr)
 		if err != nil {
-			msg := MessageResponse{
-				Message: fmt.Sprintf("Invalid page specified: %s", pageStr),
-			}
-			if format == APIFormatPlain {
-				plainResponseWrite(w, msg.Message, http.StatusBadRequest)
-			} else if format == APIFormatJSON {
-				jsonResponseWrite(w, msg, http.StatusBadRequest)
-			}
+			msg := fieldErrorResponse(FieldError{Field: "page", Code: fieldInvalid, Message: fmt.Sprintf("Invalid page specified: %s", pageStr)})
+			errorResponseWrite(w, r, format, http.StatusBadRequest, msg)
 			return
 		}
 	}
 	if perPageStr != "" {
 		perPage, err = strconv.Atoi(perPageStr)
 		if err != nil {
-			msg := MessageResponse{
-				Message: fmt.Sprintf("Invalid per page count specified: %s", perPageStr),
-			}
-			if format == APIFormatPlain {
-				plainResponseWrite(w, msg.Message, http.StatusBadRequest)
-			} else if format == APIFormatJSON {
-				jsonResponseWrite(w, msg, http.StatusBadRequest)
-			}
+			msg := fieldErrorResponse(FieldError{Field: "per_page", Code: fieldInvalid, Message: fmt.Sprintf("Invalid per page count specified: %s", perPageStr)})
+			errorResponseWrite(w, r, format, http.StatusBadRequest, msg)
+			return
+		}
+	}
+
+	if targetURL != "" {
+		code := http.StatusOK
+		msg := MessageResponse{}
+		if _, err := registry.CanonicalURL(targetURL); err != nil {
+			code = http.StatusBadRequest
+			msg = fieldErrorResponse(FieldError{Field: "url", Code: fieldInvalid, Message: fmt.Sprintf("Invalid user URL: %s", targetURL)})
+		} else if exists, err := dbConn.UserExists(ctx, targetURL); err != nil {
+			reqLog(r).Errorf("When checking for user %s before looking up mentions: %s", targetURL, err)
+			code, msg.Message = queryErrorStatus(r, err)
+		} else if !exists {
+			code = http.StatusNotFound
+			msg = userNotFoundResponse(targetURL)
+		}
+		if code != http.StatusOK {
+			errorResponseWrite(w, r, format, code, msg)
 			return
 		}
 	}
@@ -170,27 +332,24 @@ func getMentionsHandler(w http.ResponseWriter, r *http.Request, dbConn *registry
 		tweets, err = dbConn.SearchMentions(ctx, page, perPage, mention, registry.StatusVisible)
 	}
 	if err != nil {
-		log.Errorf("When searching for tweets containing mention of \"%s\", page %d, per page %d: %s", mention, page, perPage, mention)
+		reqLog(r).Errorf("When searching for tweets containing mention of \"%s\", page %d, per page %d: %s", mention, page, perPage, err)
+		code, message := queryErrorStatus(r, err)
 		msg := MessageResponse{
-			Message: "Internal Server Error",
-		}
-		if format == APIFormatPlain {
-			plainResponseWrite(w, msg.Message, http.StatusInternalServerError)
-		} else if format == APIFormatJSON {
-			jsonResponseWrite(w, msg, http.StatusInternalServerError)
+			Message: message,
 		}
+		errorResponseWrite(w, r, format, code, msg)
 		return
 	}
 
 	if format == APIFormatPlain {
-		out := registry.FormatTweetsPlain(tweets)
+		out := registry.FormatTweetsPlainWith(tweets, plainFormat(conf))
 		plainResponseWrite(w, out, http.StatusOK)
 	} else if format == APIFormatJSON {
-		jsonResponseWrite(w, tweets, http.StatusOK)
+		jsonListWrite(w, r, conf, dbConn, tweets, page, perPage, nil)
 	}
 }
 
-func getTagsHandler(w http.ResponseWriter, r *http.Request, dbConn *registry.DB, format APIFormat, tag string) {
+func getTagsHandler(w http.ResponseWriter, r *http.Request, conf *Config, dbConn *registry.DB, format APIFormat, tag string) {
 	ctx := r.Context()
 	var tweets []registry.Tweet
 	var err error
@@ -203,32 +362,29 @@ func getTagsHandler(w http.ResponseWriter, r *http.Request, dbConn *registry.DB,
 	if pageStr != "" {
 		page, err = strconv.Atoi(pageStr)
 		if err != nil {
-			msg := MessageResponse{
-				Message: fmt.Sprintf("Invalid page specified: %s", pageStr),
-			}
-			if format == APIFormatPlain {
-				plainResponseWrite(w, msg.Message, http.StatusBadRequest)
-			} else if format == APIFormatJSON {
-				jsonResponseWrite(w, msg, http.StatusBadRequest)
-			}
+			msg := fieldErrorResponse(FieldError{Field: "page", Code: fieldInvalid, Message: fmt.Sprintf("Invalid page specified: %s", pageStr)})
+			errorResponseWrite(w, r, format, http.StatusBadRequest, msg)
 			return
 		}
 	}
 	if perPageStr != "" {
 		perPage, err = strconv.Atoi(perPageStr)
 		if err != nil {
-			msg := MessageResponse{
-				Message: fmt.Sprintf("Invalid per page count specified: %s", perPageStr),
-			}
-			if format == APIFormatPlain {
-				plainResponseWrite(w, msg.Message, http.StatusBadRequest)
-			} else if format == APIFormatJSON {
-				jsonResponseWrite(w, msg, http.StatusBadRequest)
-			}
+			msg := fieldErrorResponse(FieldError{Field: "per_page", Code: fieldInvalid, Message: fmt.Sprintf("Invalid per page count specified: %s", perPageStr)})
+			errorResponseWrite(w, r, format, http.StatusBadRequest, msg)
 			return
 		}
 	}
 
+	download, ok := wantsTwtxtDownload(w, r, format)
+	if !ok {
+		return
+	}
+	if download {
+		downloadTagsHandler(w, r, conf, dbConn, format, tag)
+		return
+	}
+
 	if tag == "" {
 		tweets, err = dbConn.GetTags(ctx, page, perPage, registry.StatusVisible)
 	} else {
@@ -236,22 +392,69 @@ func getTagsHandler(w http.ResponseWriter, r *http.Request, dbConn *registry.DB,
 		tweets, err = dbConn.SearchTags(ctx, page, perPage, tag, registry.StatusVisible)
 	}
 	if err != nil {
-		log.Errorf("When searching for tweets containing tag \"%s\", page %d, per page %d: %s", tag, page, perPage, err)
+		reqLog(r).Errorf("When searching for tweets containing tag \"%s\", page %d, per page %d: %s", tag, page, perPage, err)
+		code, message := queryErrorStatus(r, err)
 		msg := MessageResponse{
-			Message: "Internal Server Error",
-		}
-		if format == APIFormatPlain {
-			plainResponseWrite(w, msg.Message, http.StatusInternalServerError)
-		} else if format == APIFormatJSON {
-			jsonResponseWrite(w, msg, http.StatusInternalServerError)
+			Message: message,
 		}
+		errorResponseWrite(w, r, format, code, msg)
 		return
 	}
 
 	if format == APIFormatPlain {
-		out := registry.FormatTweetsPlain(tweets)
+		out := registry.FormatTweetsPlainWith(tweets, plainFormat(conf))
 		plainResponseWrite(w, out, http.StatusOK)
 	} else if format == APIFormatJSON {
-		jsonResponseWrite(w, tweets, http.StatusOK)
+		jsonListWrite(w, r, conf, dbConn, tweets, page, perPage, nil)
+	}
+}
+
+// downloadTagsHandler responds with all of the tweets using tag, or any tag if it's empty, as a twtxt file.
+func downloadTagsHandler(w http.ResponseWriter, r *http.Request, conf *Config, dbConn *registry.DB, format APIFormat, tag string) {
+	filename := "tags.txt"
+	description := "Tagged tweets"
+	if tag != "" {
+		filename = fmt.Sprintf("tag-%s.txt", tag)
+		description = fmt.Sprintf("Tweets tagged #%s", tag)
+	}
+	phrase := fmt.Sprintf(`"#%s"`, tag)
+
+	twtxtDownloadWrite(w, r, conf, dbConn, format, filename, description, func(ctx context.Context, page, perPage int) ([]registry.Tweet, error) {
+		if tag == "" {
+			return dbConn.GetTags(ctx, page, perPage, registry.StatusVisible)
+		}
+		return dbConn.SearchTags(ctx, page, perPage, phrase, registry.StatusVisible)
+	})
+}
+
+// suggestTagsHandler returns known tags starting with the q parameter along with how many tweets use each.
+func suggestTagsHandler(w http.ResponseWriter, r *http.Request, dbConn *registry.DB, format APIFormat) {
+	prefix := r.URL.Query().Get("q")
+	if strings.TrimSpace(strings.TrimPrefix(strings.TrimSpace(prefix), "#")) == "" {
+		msg := fieldErrorResponse(FieldError{Field: "q", Code: fieldRequired, Message: "Please provide the start of a tag"})
+		errorResponseWrite(w, r, format, http.StatusBadRequest, msg)
+		return
+	}
+
+	tags, err := dbConn.SuggestTags(r.Context(), prefix)
+	if err != nil {
+		reqLog(r).Errorf("When suggesting tags starting with %s: %s", prefix, err)
+		code, message := queryErrorStatus(r, err)
+		msg := MessageResponse{
+			Message: message,
+		}
+		errorResponseWrite(w, r, format, code, msg)
+		return
+	}
+
+	w.Header().Set("Cache-Control", fmt.Sprintf("public, max-age=%d", int(suggestCacheMaxAge.Seconds())))
+	if format == APIFormatPlain {
+		out := strings.Builder{}
+		for _, t := range tags {
+			out.WriteString(fmt.Sprintf("%s\t%d\n", t.Tag, t.Count))
+		}
+		plainResponseWrite(w, out.String(), http.StatusOK)
+	} else if format == APIFormatJSON {
+		jsonResponseWrite(w, r, tags, http.StatusOK)
 	}
 }
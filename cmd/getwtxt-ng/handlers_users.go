@@ -19,7 +19,7 @@ along with getwtxt-ng.  If not, see <https://www.gnu.org/licenses/>.
 package main
 
 import (
-	"bufio"
+	"context"
 	"encoding/json"
 	"errors"
 	"fmt"
@@ -44,217 +44,191 @@ func addUserHandler(w http.ResponseWriter, r *http.Request, conf *Config, dbConn
 		jsonAddUserHandler(w, r, conf, dbConn)
 	default:
 		// should have 404'ed before this
-		http.Error(w, "404 Not Found", http.StatusNotFound)
+		errorWrite(w, r, errorFormat(r), http.StatusNotFound, "")
 	}
 }
 
-func plainBulkAddUserHandler(w http.ResponseWriter, r *http.Request, conf *Config, dbConn *registry.DB) {
-	log.SetLevel(log.ErrorLevel)
-	defer log.SetLevel(log.InfoLevel)
+func plainAddUserHandler(w http.ResponseWriter, r *http.Request, conf *Config, dbConn *registry.DB) {
 	ctx := r.Context()
 	w.Header().Set("Content-Type", "text/plain")
-	_ = r.ParseForm()
-	remoteURL := r.Form.Get("source")
 
 	if r.Method != http.MethodPost {
-		http.Error(w, "Method Not Allowed", http.StatusMethodNotAllowed)
+		errorWrite(w, r, APIFormatPlain, http.StatusMethodNotAllowed, "")
 		return
 	}
 
-	auth := r.Header.Get("X-Auth")
-	if auth == "" {
-		http.Error(w, "403 Forbidden", http.StatusForbidden)
+	_ = r.ParseForm()
+	nick := strings.TrimSpace(r.Form.Get("nickname"))
+	twtxtURL := strings.TrimSpace(r.Form.Get("url"))
+
+	if nick == "" {
+		errorWrite(w, r, APIFormatPlain, http.StatusBadRequest, "Please provide a nickname")
 		return
 	}
-	if !common.ValidatePass(auth, []byte(conf.ServerConfig.AdminPassword)) {
-		http.Error(w, "403 Forbidden", http.StatusForbidden)
+	if twtxtURL == "" {
+		errorWrite(w, r, APIFormatPlain, http.StatusBadRequest, "Please provide a twtxt.txt URL")
 		return
 	}
 
-	if !common.IsValidURL(remoteURL, log.StandardLogger()) {
-		msg := fmt.Sprintf("400 Bad Request: couldn't parse %s as URL", remoteURL)
-		http.Error(w, msg, http.StatusBadRequest)
+	// Variations of the same URL, such as http:// and https:// or with and without www., are the same feed.
+	if _, err := registry.CanonicalURL(twtxtURL); err != nil {
+		msg := "Invalid URL"
+		errorWrite(w, r, APIFormatPlain, http.StatusBadRequest, msg)
 		return
 	}
-
-	req, err := http.NewRequest(http.MethodGet, remoteURL, nil)
+	exists, err := dbConn.UserExists(ctx, twtxtURL)
 	if err != nil {
-		log.Errorf("Couldn't create http request to fetch list of new users from %s: %s", remoteURL, err)
-		http.Error(w, "500 Internal Server Error", http.StatusInternalServerError)
+		errorWrite(w, r, APIFormatPlain, http.StatusInternalServerError, "")
+		reqLog(r).Errorf("While checking for existing user %s: %s", twtxtURL, err)
+		return
+	}
+	if exists {
+		errorResponseWrite(w, r, APIFormatPlain, http.StatusBadRequest, MessageResponse{Message: "Cannot add duplicate user", Code: errCodeDuplicateUser})
 		return
 	}
-	resp, err := dbConn.Client.Do(req)
+	ban, err := dbConn.FindBan(ctx, twtxtURL)
 	if err != nil {
-		log.Errorf("Couldn't fetch list of new users from %s: %s", remoteURL, err)
-		http.Error(w, "500 Internal Server Error", http.StatusInternalServerError)
+		errorWrite(w, r, APIFormatPlain, http.StatusInternalServerError, "")
+		reqLog(r).Errorf("While checking bans for %s: %s", twtxtURL, err)
 		return
 	}
-
-	usersToAdd := make([]registry.User, 0, 5)
-
-	bodyScanner := bufio.NewScanner(resp.Body)
-	for bodyScanner.Scan() {
-		line := bodyScanner.Text()
-		fields := strings.Fields(line)
-		if len(fields) < 2 {
-			continue
-		}
-
-		// This is to prevent variations of the same URL showing up multiple times.
-		// Eg: http://example.com/twtxt.txt vs https://example.com/twtxt.txt
-		// We're also chomping www. off.
-		parsedURL, err := url.Parse(fields[1])
-		if err != nil {
-			log.Errorf("couldn't parse %s as URL: %s", fields[1], err)
-			continue
-		}
-		host := strings.TrimPrefix(parsedURL.Host, "www.")
-		constructedURL := fmt.Sprintf("%s%s", host, parsedURL.Path)
-
-		userSearchOut, err := dbConn.SearchUsers(ctx, 1, conf.ServerConfig.EntriesPerPageMin, constructedURL)
-		if err != nil {
-			log.Errorf("While searching for user %s: %s", fields[1], err)
-			continue
-		}
-		if len(userSearchOut) > 0 {
-			continue
-		}
-		var dt time.Time
-		if len(fields) < 3 {
-			dt = time.Now().UTC()
-		} else {
-			dt, err = time.Parse(time.RFC3339, fields[2])
-			if err != nil {
-				dt, err = time.Parse(time.RFC3339Nano, fields[2])
-				if err != nil {
-					continue
-				}
-			}
-		}
-
-		thisUser := registry.User{
-			Nick:          fields[0],
-			URL:           fields[1],
-			DateTimeAdded: dt,
-		}
-		usersToAdd = append(usersToAdd, thisUser)
+	if ban != nil {
+		errorResponseWrite(w, r, APIFormatPlain, http.StatusForbidden, MessageResponse{Message: "This feed is banned from the registry", Code: errCodeBanned})
+		return
 	}
 
-	users, err := dbConn.InsertUsers(ctx, usersToAdd)
+	twtxtURL, err = dbConn.ApplySchemePolicy(ctx, conf.ServerConfig.SchemePolicy, twtxtURL)
 	if err != nil {
-		log.Errorf("When bulk inserting users: %s", err)
-		http.Error(w, "500 Internal Server Error", http.StatusInternalServerError)
+		errorWrite(w, r, APIFormatPlain, http.StatusBadRequest, "This registry only accepts https:// feed URLs")
 		return
 	}
 
-	for i, user := range users {
-		tweets, err := dbConn.FetchTwtxt(user.URL, user.ID, time.Time{})
-		if err != nil {
-			log.Errorf("Couldn't fetch tweets for %s: %s", user.URL, err)
-			continue
-		}
-		err = dbConn.InsertTweets(ctx, tweets)
-		if err != nil {
-			log.Errorf("Couldn't fetch tweets for %s: %s", user.URL, err)
-			continue
-		}
-		users[i].LastSync = time.Now().UTC()
+	user := registry.User{
+		Nick:   nick,
+		URL:    twtxtURL,
+		Status: registry.UserStatusActive,
 	}
-
-	plainUsersResp := registry.FormatUsersPlain(users)
-	plainResponseWrite(w, plainUsersResp, http.StatusOK)
-}
-
-func plainAddUserHandler(w http.ResponseWriter, r *http.Request, conf *Config, dbConn *registry.DB) {
-	ctx := r.Context()
-	w.Header().Set("Content-Type", "text/plain")
-
-	if r.Method != http.MethodPost {
-		http.Error(w, "Method Not Allowed", http.StatusMethodNotAllowed)
-		return
+	if requireApproval(conf) {
+		user.Status = registry.UserStatusPendingApproval
 	}
+	pending := user.Status == registry.UserStatusPendingApproval
 
-	_ = r.ParseForm()
-	nick := strings.TrimSpace(r.Form.Get("nickname"))
-	twtxtURL := strings.TrimSpace(r.Form.Get("url"))
-
-	if nick == "" {
-		http.Error(w, "Please provide a nickname", http.StatusBadRequest)
+	passcode, err := user.GeneratePasscode()
+	if err != nil {
+		errorWrite(w, r, APIFormatPlain, http.StatusInternalServerError, "")
+		reqLog(r).Errorf("While generating passcode for new user %s %s: %s", user.Nick, user.URL, err)
 		return
 	}
-	if twtxtURL == "" {
-		http.Error(w, "Please provide a twtxt.txt URL", http.StatusBadRequest)
+
+	if !registry.RegexURLIsTwtxtFile.MatchString(user.URL) {
+		msg := "Make sure the info provided is valid and the URL points to a twtxt.txt file"
+		errorWrite(w, r, APIFormatPlain, http.StatusBadRequest, msg)
 		return
 	}
 
-	// This is to prevent variations of the same URL showing up multiple times.
-	// Eg: http://example.com/twtxt.txt vs https://example.com/twtxt.txt
-	// We're also chomping www. off.
-	parsedURL, err := url.Parse(twtxtURL)
+	hosted, err := isHostedRegistration(conf, user.Nick, user.URL)
 	if err != nil {
-		msg := "400 Bad Request: Invalid URL"
-		http.Error(w, msg, http.StatusBadRequest)
+		errorWrite(w, r, APIFormatPlain, http.StatusBadRequest, "Hosted feed URLs must end with your nickname")
 		return
 	}
-	host := strings.TrimPrefix(parsedURL.Host, "www.")
-	constructedURL := fmt.Sprintf("%s%s", host, parsedURL.Path)
 
-	userSearchOut, err := dbConn.SearchUsers(ctx, 1, conf.ServerConfig.EntriesPerPageMin, constructedURL)
+	// The user is still registered if their file can't be fetched right now; the next sync will try again.
+	var tweets []registry.Tweet
+	var meta *registry.FeedMetadata
+	var fetchErr error
+	if !hosted {
+		tweets, meta, fetchErr = dbConn.FetchTwtxtWithMetadata(twtxtURL, "", time.Time{})
+		if fetchErr != nil {
+			reqLog(r).Errorf("When fetching twtxt.txt for new user %s %s: %s", user.Nick, user.URL, fetchErr)
+		}
+		tweets = append(tweets, fetchNewUserArchives(conf, dbConn, &user, meta)...)
+	}
+
+	res, err := insertNewUser(ctx, dbConn, &user, tweets, hosted)
 	if err != nil {
-		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
-		log.Errorf("While searching for user %s: %s", twtxtURL, err)
+		if errors.Is(err, registry.ErrUserURLIsNotTwtxtFile) || errors.Is(err, registry.ErrIncompleteUserInfo) {
+			msg := "Make sure the info provided is valid and the URL points to a twtxt.txt file"
+			errorWrite(w, r, APIFormatPlain, http.StatusBadRequest, msg)
+			return
+		}
+		if errors.Is(err, registry.ErrUserExists) {
+			errorResponseWrite(w, r, APIFormatPlain, http.StatusBadRequest, MessageResponse{Message: "Cannot add duplicate user", Code: errCodeDuplicateUser})
+			return
+		}
+		if errors.Is(err, registry.ErrUserBanned) {
+			errorResponseWrite(w, r, APIFormatPlain, http.StatusForbidden, MessageResponse{Message: "This feed is banned from the registry", Code: errCodeBanned})
+			return
+		}
+		errorWrite(w, r, APIFormatPlain, http.StatusInternalServerError, "")
+		reqLog(r).Errorf("When adding new user %s %s: %s", user.Nick, user.URL, err)
 		return
 	}
-	if len(userSearchOut) > 0 {
-		http.Error(w, "Cannot add duplicate user", http.StatusBadRequest)
+	setNewUserMetadata(ctx, dbConn, &user, meta)
+
+	// The spec's clients only look for OK. The passcode is still needed to delete the user later.
+	if specCompliant(conf) {
+		w.Header().Set("X-Passcode", passcode)
+		plainResponseWrite(w, "OK", http.StatusOK)
 		return
 	}
 
-	user := registry.User{
-		Nick: nick,
-		URL:  twtxtURL,
-	}
+	response := registrationMessage(r, conf, APIFormatPlain, registrationData{
+		Nick:            user.Nick,
+		URL:             user.URL,
+		Passcode:        passcode,
+		TweetsAdded:     res.Inserted,
+		FetchFailed:     fetchErr != nil,
+		PendingApproval: pending,
+	})
 
-	passcode, err := user.GeneratePasscode()
-	if err != nil {
-		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
-		log.Errorf("While generating passcode for new user %s %s: %s", user.Nick, user.URL, err)
+	if fetchErr != nil {
+		errorWrite(w, r, APIFormatPlain, http.StatusInternalServerError, response)
 		return
 	}
 
-	if err := dbConn.InsertUser(ctx, &user); err != nil {
-		if errors.Is(err, registry.ErrUserURLIsNotTwtxtFile) || errors.Is(err, registry.ErrIncompleteUserInfo) {
-			msg := "400 Bad Request: Make sure the info provided is valid and the URL points to a twtxt.txt file"
-			http.Error(w, msg, http.StatusBadRequest)
-			return
-		}
-		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
-		log.Errorf("When adding new user %s %s: %s", user.Nick, user.URL, err)
-		return
+	if pending {
+		w.WriteHeader(http.StatusAccepted)
+	}
+	if _, err := w.Write([]byte(response)); err != nil {
+		reqLog(r).Error(err)
 	}
+}
 
-	response := fmt.Sprintf("You have been added! Your user's generated passcode is: %s\n", passcode)
+// insertNewUser registers a user along with the tweets first fetched from their feed,
+// or as a hosted feed that starts out empty.
+func insertNewUser(ctx context.Context, dbConn *registry.DB, user *registry.User, tweets []registry.Tweet, hosted bool) (registry.InsertResult, error) {
+	if hosted {
+		return registry.InsertResult{}, dbConn.InsertHostedUser(ctx, user)
+	}
+	return dbConn.InsertUserWithTweets(ctx, user, tweets)
+}
 
-	tweets, err := dbConn.FetchTwtxt(twtxtURL, user.ID, time.Time{})
-	if err != nil {
-		log.Errorf("When fetching twtxt.txt for new user %s %s: %s", user.Nick, user.URL, err)
-		response = fmt.Sprintf("%sHowever, we were unable to fetch your twtxt file.", response)
-		http.Error(w, response, http.StatusInternalServerError)
+// setNewUserMetadata stores the metadata a new user's feed declared when it was first fetched,
+// so it's shown before the feed next changes. Failing to is logged, as the next sync will try again.
+func setNewUserMetadata(ctx context.Context, dbConn *registry.DB, user *registry.User, meta *registry.FeedMetadata) {
+	if meta == nil {
 		return
 	}
+	if err := dbConn.SetFeedMetadata(ctx, user.ID, *meta); err != nil {
+		log.Errorf("When storing metadata of new user %s %s: %s", user.Nick, user.URL, err)
+	}
+}
 
-	if len(tweets) > 0 {
-		if err := dbConn.InsertTweets(ctx, tweets); err != nil {
-			log.Errorf("When adding tweets for new user %s %s: %s", user.Nick, user.URL, err)
-			response = fmt.Sprintf("%sHowever, we were unable to add your tweets to the registry for some reason.", response)
-			http.Error(w, response, http.StatusInternalServerError)
-			return
-		}
+// fetchNewUserArchives retrieves the tweets in a new user's archived files, when their feed declares any
+// and archive_depth allows it, so their history is indexed along with the live file.
+func fetchNewUserArchives(conf *Config, dbConn *registry.DB, user *registry.User, meta *registry.FeedMetadata) []registry.Tweet {
+	conf.mu.RLock()
+	depth := conf.ServerConfig.ArchiveDepth
+	conf.mu.RUnlock()
+	if meta == nil || meta.Prev == "" || depth < 1 {
+		return nil
 	}
 
-	if _, err := w.Write([]byte(response)); err != nil {
-		log.Error(err)
+	archived, err := dbConn.FetchArchivedTwtxt(user.URL, "", meta.Prev, depth)
+	if err != nil {
+		log.Errorf("When fetching archived twtxt files for new user %s %s: %s", user.Nick, user.URL, err)
 	}
+	return archived
 }
 
 func jsonAddUserHandler(w http.ResponseWriter, r *http.Request, conf *Config, dbConn *registry.DB) {
@@ -266,7 +240,7 @@ func jsonAddUserHandler(w http.ResponseWriter, r *http.Request, conf *Config, db
 
 	if r.Method != http.MethodPost {
 		response.Message = "Method Not Allowed"
-		jsonResponseWrite(w, response, http.StatusMethodNotAllowed)
+		errorResponseWrite(w, r, APIFormatJSON, http.StatusMethodNotAllowed, response)
 		return
 	}
 
@@ -274,92 +248,166 @@ func jsonAddUserHandler(w http.ResponseWriter, r *http.Request, conf *Config, db
 
 	user := registry.User{}
 	if err := bodyDecoder.Decode(&user); err != nil {
-		log.Error(err)
+		reqLog(r).Error(err)
 		response.Message = "Invalid Request Body"
-		jsonResponseWrite(w, response, http.StatusBadRequest)
+		errorResponseWrite(w, r, APIFormatJSON, http.StatusBadRequest, response)
 		return
 	}
 
+	missing := make([]FieldError, 0, 2)
 	if user.Nick == "" {
-		response.Message = "Please provide a nickname"
-		jsonResponseWrite(w, response, http.StatusBadRequest)
-		return
+		missing = append(missing, FieldError{Field: "nickname", Code: fieldRequired, Message: "Please provide a nickname"})
 	}
 	if user.URL == "" {
-		response.Message = "Please provide a twtxt.txt URL"
-		jsonResponseWrite(w, response, http.StatusBadRequest)
+		missing = append(missing, FieldError{Field: "url", Code: fieldRequired, Message: "Please provide a twtxt.txt URL"})
+	}
+	if len(missing) > 0 {
+		errorResponseWrite(w, r, APIFormatJSON, http.StatusBadRequest, fieldErrorResponse(missing...))
+		return
+	}
+	if !registry.RegexIsAlpha.MatchString(user.Nick) {
+		response = fieldErrorResponse(FieldError{Field: "nickname", Code: fieldInvalid, Message: "Nicknames must contain letters, numbers, or underscores"})
+		errorResponseWrite(w, r, APIFormatJSON, http.StatusBadRequest, response)
 		return
 	}
 
-	// This is to prevent variations of the same URL showing up multiple times.
-	// Eg: http://example.com/twtxt.txt vs https://example.com/twtxt.txt
-	// We're also chomping www. off.
-	parsedURL, err := url.Parse(user.URL)
+	// Variations of the same URL, such as http:// and https:// or with and without www., are the same feed.
+	if _, err := registry.CanonicalURL(user.URL); err != nil {
+		response = fieldErrorResponse(FieldError{Field: "url", Code: fieldInvalid, Message: "Invalid URL"})
+		errorResponseWrite(w, r, APIFormatJSON, http.StatusBadRequest, response)
+		return
+	}
+	exists, err := dbConn.UserExists(ctx, user.URL)
 	if err != nil {
-		response.Message = "400 Bad Request: Invalid URL"
-		jsonResponseWrite(w, response, http.StatusBadRequest)
+		reqLog(r).Errorf("While checking for existing user %s: %s", user.URL, err)
+		response.Message = "Internal Server Error"
+		errorResponseWrite(w, r, APIFormatJSON, http.StatusInternalServerError, response)
+		return
 	}
-	host := strings.TrimPrefix(parsedURL.Host, "www.")
-	constructedURL := fmt.Sprintf("%s%s", host, parsedURL.Path)
-
-	userSearchOut, err := dbConn.SearchUsers(ctx, 1, conf.ServerConfig.EntriesPerPageMin, constructedURL)
+	if exists {
+		response = fieldErrorResponse(FieldError{Field: "url", Code: fieldDuplicate, Message: "Cannot add duplicate user"})
+		response.Code = errCodeDuplicateUser
+		errorResponseWrite(w, r, APIFormatJSON, http.StatusBadRequest, response)
+		return
+	}
+	ban, err := dbConn.FindBan(ctx, user.URL)
 	if err != nil {
-		log.Errorf("While searching for user %s: %s", user.URL, err)
+		reqLog(r).Errorf("While checking bans for %s: %s", user.URL, err)
 		response.Message = "Internal Server Error"
-		jsonResponseWrite(w, response, http.StatusInternalServerError)
+		errorResponseWrite(w, r, APIFormatJSON, http.StatusInternalServerError, response)
 		return
 	}
-	if len(userSearchOut) > 0 {
-		response.Message = "Cannot add duplicate user"
-		jsonResponseWrite(w, response, http.StatusBadRequest)
+	if ban != nil {
+		response.Message = "This feed is banned from the registry"
+		response.Code = errCodeBanned
+		errorResponseWrite(w, r, APIFormatJSON, http.StatusForbidden, response)
 		return
 	}
 
+	user.URL, err = dbConn.ApplySchemePolicy(ctx, conf.ServerConfig.SchemePolicy, user.URL)
+	if err != nil {
+		response = fieldErrorResponse(FieldError{Field: "url", Code: fieldInsecure, Message: "This registry only accepts https:// feed URLs"})
+		errorResponseWrite(w, r, APIFormatJSON, http.StatusBadRequest, response)
+		return
+	}
+
+	// Registrants don't get to pick their own status, least of all when they'd be skipping the approval queue.
+	user.Status = registry.UserStatusActive
+	if requireApproval(conf) {
+		user.Status = registry.UserStatusPendingApproval
+	}
+	pending := user.Status == registry.UserStatusPendingApproval
+
 	passcode, err := user.GeneratePasscode()
 	if err != nil {
-		log.Errorf("While generating passcode for new user %s %s: %s", user.Nick, user.URL, err)
+		reqLog(r).Errorf("While generating passcode for new user %s %s: %s", user.Nick, user.URL, err)
 		response.Message = "Internal Server Error"
-		jsonResponseWrite(w, response, http.StatusInternalServerError)
+		errorResponseWrite(w, r, APIFormatJSON, http.StatusInternalServerError, response)
 		return
 	}
 
-	if err := dbConn.InsertUser(ctx, &user); err != nil {
-		if errors.Is(err, registry.ErrUserURLIsNotTwtxtFile) || errors.Is(err, registry.ErrIncompleteUserInfo) {
-			response.Message = "400 Bad Request: Make sure the info provided is valid and the URL points to a twtxt.txt file"
-			jsonResponseWrite(w, response, http.StatusBadRequest)
+	if !registry.RegexURLIsTwtxtFile.MatchString(user.URL) {
+		response = fieldErrorResponse(FieldError{Field: "url", Code: fieldNotTwtxt, Message: "Make sure the info provided is valid and the URL points to a twtxt.txt file"})
+		errorResponseWrite(w, r, APIFormatJSON, http.StatusBadRequest, response)
+		return
+	}
+
+	hosted, err := isHostedRegistration(conf, user.Nick, user.URL)
+	if err != nil {
+		response = fieldErrorResponse(FieldError{Field: "url", Code: fieldNickMismatch, Message: "Hosted feed URLs must end with your nickname"})
+		errorResponseWrite(w, r, APIFormatJSON, http.StatusBadRequest, response)
+		return
+	}
+
+	// The user is still registered if their file can't be fetched right now; the next sync will try again.
+	var tweets []registry.Tweet
+	var meta *registry.FeedMetadata
+	var fetchErr error
+	if !hosted {
+		tweets, meta, fetchErr = dbConn.FetchTwtxtWithMetadata(user.URL, "", time.Time{})
+		if fetchErr != nil {
+			reqLog(r).Errorf("When fetching twtxt.txt for new user %s %s: %s", user.Nick, user.URL, fetchErr)
+		}
+		tweets = append(tweets, fetchNewUserArchives(conf, dbConn, &user, meta)...)
+	}
+
+	res, err := insertNewUser(ctx, dbConn, &user, tweets, hosted)
+	if err != nil {
+		if errors.Is(err, registry.ErrUserURLIsNotTwtxtFile) {
+			response = fieldErrorResponse(FieldError{Field: "url", Code: fieldNotTwtxt, Message: "Make sure the info provided is valid and the URL points to a twtxt.txt file"})
+			errorResponseWrite(w, r, APIFormatJSON, http.StatusBadRequest, response)
+			return
+		}
+		if errors.Is(err, registry.ErrIncompleteUserInfo) {
+			// The nickname was checked above, so it's the URL that's lacking.
+			response = fieldErrorResponse(FieldError{Field: "url", Code: fieldInvalid, Message: "Make sure the info provided is valid and the URL points to a twtxt.txt file"})
+			errorResponseWrite(w, r, APIFormatJSON, http.StatusBadRequest, response)
+			return
+		}
+		if errors.Is(err, registry.ErrUserExists) {
+			response = fieldErrorResponse(FieldError{Field: "url", Code: fieldDuplicate, Message: "Cannot add duplicate user"})
+			response.Code = errCodeDuplicateUser
+			errorResponseWrite(w, r, APIFormatJSON, http.StatusBadRequest, response)
+			return
+		}
+		if errors.Is(err, registry.ErrUserBanned) {
+			response.Message = "This feed is banned from the registry"
+			response.Code = errCodeBanned
+			errorResponseWrite(w, r, APIFormatJSON, http.StatusForbidden, response)
 			return
 		}
-		log.Errorf("When adding new user %s %s: %s", user.Nick, user.URL, err)
+		reqLog(r).Errorf("When adding new user %s %s: %s", user.Nick, user.URL, err)
 		response.Message = "Internal Server Error"
-		jsonResponseWrite(w, response, http.StatusInternalServerError)
+		errorResponseWrite(w, r, APIFormatJSON, http.StatusInternalServerError, response)
 		return
 	}
+	setNewUserMetadata(ctx, dbConn, &user, meta)
 
-	response.Message = "You have been added and your passcode has been generated."
+	response.Message = registrationMessage(r, conf, APIFormatJSON, registrationData{
+		Nick:            user.Nick,
+		URL:             user.URL,
+		Passcode:        passcode,
+		TweetsAdded:     res.Inserted,
+		FetchFailed:     fetchErr != nil,
+		PendingApproval: pending,
+	})
 	response.Passcode = passcode
+	response.TweetsAdded = res.Inserted
+	response.PendingApproval = pending
 
-	tweets, err := dbConn.FetchTwtxt(user.URL, user.ID, time.Time{})
-	if err != nil {
-		log.Errorf("When fetching twtxt.txt for new user %s %s: %s", user.Nick, user.URL, err)
-		response.Message = fmt.Sprintf("%s However, we were unable to fetch your twtxt file at %s. Another attempt will be made at the next sync interval (every %s)",
-			response.Message, user.URL, conf.ServerConfig.FetchInterval)
-		jsonResponseWrite(w, response, http.StatusInternalServerError)
+	if fetchErr != nil {
+		errorResponseWrite(w, r, APIFormatJSON, http.StatusInternalServerError, response)
 		return
 	}
 
-	if len(tweets) > 0 {
-		if err := dbConn.InsertTweets(ctx, tweets); err != nil {
-			log.Errorf("When adding tweets for new user %s %s: %s", user.Nick, user.URL, err)
-			response.Message = fmt.Sprintf("%s However, we were unable to add your tweets to the registry for some reason. Please contact the administrator of this instance.", response.Message)
-			jsonResponseWrite(w, response, http.StatusInternalServerError)
-			return
-		}
+	if pending {
+		jsonResponseWrite(w, r, response, http.StatusAccepted)
+		return
 	}
-
-	jsonResponseWrite(w, response, http.StatusOK)
+	jsonResponseWrite(w, r, response, http.StatusOK)
 }
 
-func getUsersHandler(w http.ResponseWriter, r *http.Request, dbConn *registry.DB, format APIFormat) {
+func getUsersHandler(w http.ResponseWriter, r *http.Request, conf *Config, dbConn *registry.DB, format APIFormat) {
 	var err error
 	_ = r.ParseForm()
 	pageStr := r.Form.Get("page")
@@ -371,86 +419,68 @@ func getUsersHandler(w http.ResponseWriter, r *http.Request, dbConn *registry.DB
 	if pageStr != "" {
 		page, err = strconv.Atoi(pageStr)
 		if err != nil {
-			msg := MessageResponse{
-				Message: fmt.Sprintf("Invalid page specified: %s", pageStr),
-			}
-			if format == APIFormatPlain {
-				plainResponseWrite(w, msg.Message, http.StatusBadRequest)
-			} else if format == APIFormatJSON {
-				jsonResponseWrite(w, msg, http.StatusBadRequest)
-			}
+			msg := fieldErrorResponse(FieldError{Field: "page", Code: fieldInvalid, Message: fmt.Sprintf("Invalid page specified: %s", pageStr)})
+			errorResponseWrite(w, r, format, http.StatusBadRequest, msg)
 			return
 		}
 	}
 	if perPageStr != "" {
 		perPage, err = strconv.Atoi(perPageStr)
 		if err != nil {
-			msg := MessageResponse{
-				Message: fmt.Sprintf("Invalid per page count specified: %s", perPageStr),
-			}
-			if format == APIFormatPlain {
-				plainResponseWrite(w, msg.Message, http.StatusBadRequest)
-			} else if format == APIFormatJSON {
-				jsonResponseWrite(w, msg, http.StatusBadRequest)
-			}
+			msg := fieldErrorResponse(FieldError{Field: "per_page", Code: fieldInvalid, Message: fmt.Sprintf("Invalid per page count specified: %s", perPageStr)})
+			errorResponseWrite(w, r, format, http.StatusBadRequest, msg)
 			return
 		}
 	}
 
 	if searchTerm == "" {
-		getLatestUsersHandler(w, r, dbConn, page, perPage, format)
+		getLatestUsersHandler(w, r, conf, dbConn, page, perPage, format)
 	} else {
-		searchUsersHandler(w, r, dbConn, page, perPage, format, searchTerm)
+		searchUsersHandler(w, r, conf, dbConn, page, perPage, format, searchTerm)
 	}
 }
 
-func getLatestUsersHandler(w http.ResponseWriter, r *http.Request, dbConn *registry.DB, page, perPage int, format APIFormat) {
+func getLatestUsersHandler(w http.ResponseWriter, r *http.Request, conf *Config, dbConn *registry.DB, page, perPage int, format APIFormat) {
 	ctx := r.Context()
 
 	users, err := dbConn.GetUsers(ctx, page, perPage)
 	if err != nil {
-		log.Errorf("When retrieving latest users, page %d, per page %d: %s", page, perPage, err)
+		reqLog(r).Errorf("When retrieving latest users, page %d, per page %d: %s", page, perPage, err)
+		code, message := queryErrorStatus(r, err)
 		msg := MessageResponse{
-			Message: "Internal Server Error",
-		}
-		if format == APIFormatPlain {
-			plainResponseWrite(w, msg.Message, http.StatusInternalServerError)
-		} else if format == APIFormatJSON {
-			jsonResponseWrite(w, msg, http.StatusInternalServerError)
+			Message: message,
 		}
+		errorResponseWrite(w, r, format, code, msg)
 		return
 	}
 
 	if format == APIFormatPlain {
-		out := registry.FormatUsersPlain(users)
+		out := formatUsersPlain(conf, users)
 		plainResponseWrite(w, out, http.StatusOK)
 	} else if format == APIFormatJSON {
-		jsonResponseWrite(w, users, http.StatusOK)
+		jsonListWrite(w, r, conf, dbConn, users, page, perPage, userTotal)
 	}
 }
 
-func searchUsersHandler(w http.ResponseWriter, r *http.Request, dbConn *registry.DB, page, perPage int, format APIFormat, searchTerm string) {
+func searchUsersHandler(w http.ResponseWriter, r *http.Request, conf *Config, dbConn *registry.DB, page, perPage int, format APIFormat, searchTerm string) {
 	ctx := r.Context()
 
 	users, err := dbConn.SearchUsers(ctx, page, perPage, searchTerm)
 	if err != nil {
-		log.Errorf("When retrieving latest users, page %d, per page %d: %s", page, perPage, err)
+		reqLog(r).Errorf("When retrieving latest users, page %d, per page %d: %s", page, perPage, err)
+		code, message := queryErrorStatus(r, err)
 		msg := MessageResponse{
-			Message: "Internal Server Error",
-		}
-		if format == APIFormatPlain {
-			plainResponseWrite(w, msg.Message, http.StatusInternalServerError)
-		} else if format == APIFormatJSON {
-			jsonResponseWrite(w, msg, http.StatusInternalServerError)
+			Message: message,
 		}
+		errorResponseWrite(w, r, format, code, msg)
 		return
 	}
 
 	if format == APIFormatPlain {
-		out := registry.FormatUsersPlain(users)
+		out := formatUsersPlain(conf, users)
 		plainResponseWrite(w, out, http.StatusOK)
 	} else if format == APIFormatJSON {
-		jsonResponseWrite(w, users, http.StatusOK)
+		jsonListWrite(w, r, conf, dbConn, users, page, perPage, nil)
 	}
 }
 
@@ -462,7 +492,7 @@ func deleteUsersHandler(w http.ResponseWriter, r *http.Request, conf *Config, db
 		jsonDeleteUsersHandler(w, r, conf, dbConn)
 	default:
 		// should have 404'ed before this
-		http.Error(w, "404 Not Found", http.StatusNotFound)
+		errorWrite(w, r, errorFormat(r), http.StatusNotFound, "")
 	}
 }
 
@@ -472,60 +502,73 @@ func plainDeleteUsersHandler(w http.ResponseWriter, r *http.Request, conf *Confi
 
 	pass := r.Header.Get("X-Auth")
 	if pass == "" {
-		http.Error(w, "403 Forbidden", http.StatusForbidden)
+		errorWrite(w, r, APIFormatPlain, http.StatusForbidden, "")
 		return
 	}
 	isAdmin := common.ValidatePass(pass, []byte(conf.ServerConfig.AdminPassword))
 
 	urls := r.Form["url"]
 	if len(urls) < 1 || urls[0] == "" {
-		http.Error(w, "400 Bad Request: No user(s) to delete", http.StatusBadRequest)
+		errorWrite(w, r, APIFormatPlain, http.StatusBadRequest, "No user(s) to delete")
 		return
 	}
 
 	if !isAdmin {
 		if len(urls) > 1 {
-			http.Error(w, "403 Forbidden: Non-admin users may only delete themselves", http.StatusForbidden)
+			errorWrite(w, r, APIFormatPlain, http.StatusForbidden, "Non-admin users may only delete themselves")
 			return
 		}
 
 		dbUser, err := dbConn.GetFullUserByURL(ctx, urls[0])
 		if err != nil {
-			log.Errorf("When grabbing user %s: %s", urls[0], err)
-			http.Error(w, "500 Internal Server Error", http.StatusInternalServerError)
+			reqLog(r).Errorf("When grabbing user %s: %s", urls[0], err)
+			errorWrite(w, r, APIFormatPlain, http.StatusInternalServerError, "")
 			return
 		}
 
 		if !common.ValidatePass(pass, dbUser.PasscodeHash) {
-			http.Error(w, "403 Forbidden", http.StatusForbidden)
+			errorWrite(w, r, APIFormatPlain, http.StatusForbidden, "")
+			return
+		}
+
+		if grace := deletionGrace(conf); grace > 0 {
+			deleteAfter, err := dbConn.ScheduleUserDeletion(ctx, dbUser, time.Now().Add(grace))
+			if err != nil {
+				reqLog(r).Errorf("When scheduling deletion of user %s: %s", dbUser.URL, err)
+				errorWrite(w, r, APIFormatPlain, http.StatusInternalServerError, "")
+				return
+			}
+			out := fmt.Sprintf("User %s will be deleted after %s\nUndo with a POST request to /api/plain/users/undelete?url=%s\n",
+				dbUser.URL, deleteAfter.Format(time.RFC3339), url.QueryEscape(dbUser.URL))
+			plainResponseWrite(w, out, http.StatusAccepted)
 			return
 		}
 
 		nTweets, err := dbConn.DeleteUser(ctx, dbUser)
 		if err != nil {
-			log.Errorf("When deleting user %s: %s", dbUser.URL, err)
-			http.Error(w, "500 Internal Server Error", http.StatusInternalServerError)
+			reqLog(r).Errorf("When deleting user %s: %s", dbUser.URL, err)
+			errorWrite(w, r, APIFormatPlain, http.StatusInternalServerError, "")
 			return
 		}
 
 		out := fmt.Sprintf("Deleted user %s\nDeleted %d tweets\n", dbUser.URL, nTweets)
 		if _, err := w.Write([]byte(out)); err != nil {
-			log.Error(err)
+			reqLog(r).Error(err)
 		}
 
 		return
 	}
 
-	tweetCount, err := dbConn.DeleteUsers(ctx, urls)
+	userCount, tweetCount, err := dbConn.DeleteUsers(ctx, urls)
 	if err != nil {
-		log.Errorf("When deleting %d users: %s", len(urls), err)
-		http.Error(w, "500 Internal Server Error", http.StatusInternalServerError)
+		reqLog(r).Errorf("When deleting %d users: %s", len(urls), err)
+		errorWrite(w, r, APIFormatPlain, http.StatusInternalServerError, "")
 		return
 	}
 
-	out := fmt.Sprintf("Deleted %d users\nDeleted %d tweets\n", len(urls), tweetCount)
+	out := fmt.Sprintf("Deleted %d users\nDeleted %d tweets\n", userCount, tweetCount)
 	if _, err := w.Write([]byte(out)); err != nil {
-		log.Error(err)
+		reqLog(r).Error(err)
 	}
 }
 
@@ -534,7 +577,7 @@ func jsonDeleteUsersHandler(w http.ResponseWriter, r *http.Request, conf *Config
 
 	pass := r.Header.Get("X-Auth")
 	if pass == "" {
-		http.Error(w, "403 Forbidden", http.StatusForbidden)
+		errorWrite(w, r, APIFormatPlain, http.StatusForbidden, "")
 		return
 	}
 	isAdmin := common.ValidatePass(pass, []byte(conf.ServerConfig.AdminPassword))
@@ -544,45 +587,60 @@ func jsonDeleteUsersHandler(w http.ResponseWriter, r *http.Request, conf *Config
 	users := make([]registry.User, 0, 2)
 	if err := bodyDecoder.Decode(&users); err != nil {
 		msg := MessageResponse{
-			Message: "400 Bad Request: Invalid request body",
+			Message: "Invalid request body",
 		}
-		jsonResponseWrite(w, msg, http.StatusBadRequest)
+		errorResponseWrite(w, r, APIFormatJSON, http.StatusBadRequest, msg)
 		return
 	}
 
 	if len(users) < 1 {
 		msg := MessageResponse{
-			Message: "400 Bad Request: No user(s) to delete",
+			Message: "No user(s) to delete",
 		}
-		jsonResponseWrite(w, msg, http.StatusBadRequest)
+		errorResponseWrite(w, r, APIFormatJSON, http.StatusBadRequest, msg)
 		return
 	}
 
 	if !isAdmin {
 		if len(users) > 1 {
 			msg := MessageResponse{
-				Message: "403 Forbidden: Non-admin users may only delete themselves",
+				Message: "Non-admin users may only delete themselves",
 			}
-			jsonResponseWrite(w, msg, http.StatusForbidden)
+			errorResponseWrite(w, r, APIFormatJSON, http.StatusForbidden, msg)
 			return
 		}
 		firstUserURL := users[0].URL
 
 		dbUser, err := dbConn.GetFullUserByURL(ctx, firstUserURL)
 		if err != nil {
-			log.Errorf("When grabbing user %s: %s", firstUserURL, err)
+			reqLog(r).Errorf("When grabbing user %s: %s", firstUserURL, err)
 			msg := MessageResponse{
-				Message: "500 Internal Server Error",
+				Message: "",
 			}
-			jsonResponseWrite(w, msg, http.StatusInternalServerError)
+			errorResponseWrite(w, r, APIFormatJSON, http.StatusInternalServerError, msg)
 			return
 		}
 
 		if !common.ValidatePass(pass, dbUser.PasscodeHash) {
 			msg := MessageResponse{
-				Message: "403 Forbidden",
+				Message: "",
 			}
-			jsonResponseWrite(w, msg, http.StatusForbidden)
+			errorResponseWrite(w, r, APIFormatJSON, http.StatusForbidden, msg)
+			return
+		}
+
+		if grace := deletionGrace(conf); grace > 0 {
+			deleteAfter, err := dbConn.ScheduleUserDeletion(ctx, dbUser, time.Now().Add(grace))
+			if err != nil {
+				reqLog(r).Errorf("When scheduling deletion of user %s: %s", dbUser.URL, err)
+				errorResponseWrite(w, r, APIFormatJSON, http.StatusInternalServerError, MessageResponse{})
+				return
+			}
+			msg := MessageResponse{
+				Message:     fmt.Sprintf("User %s will be deleted after %s", dbUser.URL, deleteAfter.Format(time.RFC3339)),
+				DeleteAfter: deleteAfter.Format(time.RFC3339),
+			}
+			jsonResponseWrite(w, r, msg, http.StatusAccepted)
 			return
 		}
 
@@ -591,7 +649,7 @@ func jsonDeleteUsersHandler(w http.ResponseWriter, r *http.Request, conf *Config
 			msg := MessageResponse{
 				Message: fmt.Sprintf("When deleting user %s: %s", dbUser.URL, err),
 			}
-			jsonResponseWrite(w, msg, http.StatusInternalServerError)
+			errorResponseWrite(w, r, APIFormatJSON, http.StatusInternalServerError, msg)
 			return
 		}
 
@@ -599,7 +657,7 @@ func jsonDeleteUsersHandler(w http.ResponseWriter, r *http.Request, conf *Config
 			Message:       fmt.Sprintf("Deleted user %s", dbUser.URL),
 			TweetsDeleted: nTweets,
 		}
-		jsonResponseWrite(w, msg, http.StatusOK)
+		jsonResponseWrite(w, r, msg, http.StatusOK)
 
 		return
 	}
@@ -609,19 +667,153 @@ func jsonDeleteUsersHandler(w http.ResponseWriter, r *http.Request, conf *Config
 		urls = append(urls, user.URL)
 	}
 
-	nTweets, err := dbConn.DeleteUsers(ctx, urls)
+	nUsers, nTweets, err := dbConn.DeleteUsers(ctx, urls)
 	if err != nil {
 		msg := MessageResponse{
-			Message: "500 Internal Server Error",
+			Message: "",
 		}
-		jsonResponseWrite(w, msg, http.StatusInternalServerError)
+		errorResponseWrite(w, r, APIFormatJSON, http.StatusInternalServerError, msg)
 		return
 	}
 
 	msg := MessageResponse{
 		Message:       "Deleted users successfully",
-		UsersDeleted:  len(users),
+		UsersDeleted:  int(nUsers),
 		TweetsDeleted: nTweets,
 	}
-	jsonResponseWrite(w, msg, http.StatusOK)
+	jsonResponseWrite(w, r, msg, http.StatusOK)
+}
+
+// deletionGrace returns how long users who delete themselves are kept before they're removed.
+func deletionGrace(conf *Config) time.Duration {
+	conf.mu.RLock()
+	defer conf.mu.RUnlock()
+	return conf.ServerConfig.DeletionGrace
+}
+
+// undeleteUserHandler cancels the pending deletion of the user identified by ?url=X, authenticated by their
+// passcode or the admin password, restoring the status they had before.
+func undeleteUserHandler(w http.ResponseWriter, r *http.Request, conf *Config, dbConn *registry.DB, format APIFormat) {
+	ctx := r.Context()
+	pass := r.Header.Get("X-Auth")
+	if pass == "" {
+		errorWrite(w, r, format, http.StatusForbidden, "")
+		return
+	}
+
+	_ = r.ParseForm()
+	userURL := strings.TrimSpace(r.Form.Get("url"))
+	if userURL == "" {
+		msg := fieldErrorResponse(FieldError{Field: "url", Code: fieldRequired, Message: "Missing user URL"})
+		errorResponseWrite(w, r, format, http.StatusBadRequest, msg)
+		return
+	}
+
+	dbUser, err := dbConn.GetFullUserByURL(ctx, userURL)
+	if err != nil {
+		if errors.Is(err, registry.ErrUserNotFound) {
+			errorResponseWrite(w, r, format, http.StatusNotFound, userNotFoundResponse(userURL))
+			return
+		}
+		reqLog(r).Errorf("When grabbing user %s to undelete: %s", userURL, err)
+		code, message := queryErrorStatus(r, err)
+		errorResponseWrite(w, r, format, code, MessageResponse{Message: message})
+		return
+	}
+	conf.mu.RLock()
+	isAdmin := common.ValidatePass(pass, []byte(conf.ServerConfig.AdminPassword))
+	conf.mu.RUnlock()
+	if !isAdmin && !common.ValidatePass(pass, dbUser.PasscodeHash) {
+		errorWrite(w, r, format, http.StatusForbidden, "")
+		return
+	}
+
+	status, err := dbConn.CancelUserDeletion(ctx, dbUser)
+	if errors.Is(err, registry.ErrUserNotPendingDeletion) {
+		errorWrite(w, r, format, http.StatusConflict, fmt.Sprintf("User %s isn't pending deletion", dbUser.URL))
+		return
+	}
+	if err != nil {
+		reqLog(r).Errorf("When cancelling deletion of user %s: %s", dbUser.URL, err)
+		errorWrite(w, r, format, http.StatusInternalServerError, "")
+		return
+	}
+
+	reqLog(r).Infof("Cancelled deletion of user %s, who is %s again", dbUser.URL, status)
+	message := fmt.Sprintf("User %s will no longer be deleted", dbUser.URL)
+	if format == APIFormatPlain {
+		plainResponseWrite(w, message+"\n", http.StatusOK)
+	} else if format == APIFormatJSON {
+		jsonResponseWrite(w, r, MessageResponse{Message: message}, http.StatusOK)
+	}
+}
+
+// getUserStatusHandler responds with the outcome of the last attempt to sync the user identified by ?url=X.
+func getUserStatusHandler(w http.ResponseWriter, r *http.Request, conf *Config, dbConn *registry.DB, format APIFormat) {
+	_ = r.ParseForm()
+	userURL := strings.TrimSpace(r.Form.Get("url"))
+	if userURL == "" {
+		msg := fieldErrorResponse(FieldError{Field: "url", Code: fieldRequired, Message: "Missing user URL"})
+		errorResponseWrite(w, r, format, http.StatusBadRequest, msg)
+		return
+	}
+
+	status, err := dbConn.GetFetchStatus(r.Context(), userURL)
+	if err != nil {
+		code, message := queryErrorStatus(r, err)
+		msg := MessageResponse{
+			Message: message,
+		}
+		if errors.Is(err, registry.ErrUserNotFound) {
+			msg = userNotFoundResponse(userURL)
+		} else {
+			reqLog(r).Errorf("When retrieving fetch status of %s: %s", userURL, err)
+		}
+		errorResponseWrite(w, r, format, code, msg)
+		return
+	}
+
+	if format == APIFormatPlain {
+		f := plainFormat(conf)
+		out := fmt.Sprintf("%s\t%d\t%s\t%s\t%s\n", status.URL, status.StatusCode,
+			f.Timestamp(status.LastAttempt), f.Timestamp(status.LastSuccess), status.Error)
+		plainResponseWrite(w, out, http.StatusOK)
+	} else if format == APIFormatJSON {
+		jsonResponseWrite(w, r, status, http.StatusOK)
+	}
+}
+
+// Suggestions change only when users register or leave, so clients and proxies may keep them a while.
+const suggestCacheMaxAge = 5 * time.Minute
+
+// suggestUsersHandler responds with up to 10 users whose nickname or URL starts with ?q=, for autocompletion.
+func suggestUsersHandler(w http.ResponseWriter, r *http.Request, dbConn *registry.DB, format APIFormat) {
+	prefix := r.URL.Query().Get("q")
+	if strings.TrimSpace(prefix) == "" {
+		msg := fieldErrorResponse(FieldError{Field: "q", Code: fieldRequired, Message: "Please provide the start of a nickname or URL"})
+		errorResponseWrite(w, r, format, http.StatusBadRequest, msg)
+		return
+	}
+
+	suggestions, err := dbConn.SuggestUsers(r.Context(), prefix)
+	if err != nil {
+		reqLog(r).Errorf("When suggesting users starting with %s: %s", prefix, err)
+		code, message := queryErrorStatus(r, err)
+		msg := MessageResponse{
+			Message: message,
+		}
+		errorResponseWrite(w, r, format, code, msg)
+		return
+	}
+
+	w.Header().Set("Cache-Control", fmt.Sprintf("public, max-age=%d", int(suggestCacheMaxAge.Seconds())))
+	if format == APIFormatPlain {
+		out := strings.Builder{}
+		for _, s := range suggestions {
+			out.WriteString(fmt.Sprintf("%s\t%s\n", s.Nickname, s.URL))
+		}
+		plainResponseWrite(w, out.String(), http.StatusOK)
+	} else if format == APIFormatJSON {
+		jsonResponseWrite(w, r, suggestions, http.StatusOK)
+	}
 }
@@ -0,0 +1,76 @@
+package main
+
+/*
+Copyright 2021 G. Benjamin Morrison
+
+This file is part of getwtxt-ng.
+
+getwtxt-ng is free software: you can redistribute it and/or modify
+it under the terms of the GNU Affero General Public License as
+published by the Free Software Foundation, either version 3 of the
+License, or (at your option) any later version.
+
+getwtxt-ng is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU Affero General Public License for more details.
+
+You should have received a copy of the GNU Affero General Public License
+along with getwtxt-ng.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/gbmor/getwtxt-ng/registry"
+)
+
+// userTweetsVisibilityHandler lets the admin hide or unhide every tweet of the user at the url parameter.
+// It's a step short of deleting the user: the tweets can be brought back, and the feed is still synced,
+// though what it posts afterward isn't hidden.
+func userTweetsVisibilityHandler(w http.ResponseWriter, r *http.Request, conf *Config, dbConn *registry.DB, status registry.TweetVisibilityStatus) {
+	ctx := r.Context()
+
+	if !adminAuthorized(w, r, conf) {
+		return
+	}
+
+	userURL := r.URL.Query().Get("url")
+	if userURL == "" {
+		msg := fieldErrorResponse(FieldError{Field: "url", Code: fieldRequired, Message: "Please provide the URL of the user"})
+		errorResponseWrite(w, r, APIFormatJSON, http.StatusBadRequest, msg)
+		return
+	}
+
+	user, err := dbConn.GetFullUserByURL(ctx, userURL)
+	if err != nil {
+		if errors.Is(err, registry.ErrUserNotFound) {
+			errorResponseWrite(w, r, APIFormatJSON, http.StatusNotFound, userNotFoundResponse(userURL))
+			return
+		}
+		reqLog(r).Errorf("When looking up user %s to set the visibility of their tweets: %s", userURL, err)
+		code, message := queryErrorStatus(r, err)
+		errorResponseWrite(w, r, APIFormatJSON, code, MessageResponse{Message: message})
+		return
+	}
+
+	changed, err := dbConn.SetUserTweetsVisibility(ctx, user.ID, status)
+	if err != nil {
+		reqLog(r).Errorf("When setting the visibility of tweets by %s to %d: %s", user.URL, status, err)
+		errorResponseWrite(w, r, APIFormatJSON, http.StatusInternalServerError, MessageResponse{})
+		return
+	}
+
+	verb := "Hid"
+	if status == registry.StatusVisible {
+		verb = "Unhid"
+	}
+	reqLog(r).Infof("%s %d tweets by %s", verb, changed, user.URL)
+	msg := MessageResponse{
+		Message:       fmt.Sprintf("%s %d tweets by %s", verb, changed, user.URL),
+		TweetsChanged: changed,
+	}
+	jsonResponseWrite(w, r, msg, http.StatusOK)
+}
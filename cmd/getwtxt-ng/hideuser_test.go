@@ -0,0 +1,94 @@
+package main
+
+/*
+Copyright 2021 G. Benjamin Morrison
+
+This file is part of getwtxt-ng.
+
+getwtxt-ng is free software: you can redistribute it and/or modify
+it under the terms of the GNU Affero General Public License as
+published by the Free Software Foundation, either version 3 of the
+License, or (at your option) any later version.
+
+getwtxt-ng is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU Affero General Public License for more details.
+
+You should have received a copy of the GNU Affero General Public License
+along with getwtxt-ng.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gorilla/mux"
+
+	"github.com/gbmor/getwtxt-ng/common"
+	"github.com/gbmor/getwtxt-ng/registry"
+)
+
+func TestUserTweetsVisibilityHandler(t *testing.T) {
+	ctx := context.Background()
+	dbConn := getFederationDB(t)
+	hash, err := common.HashPass("hunter2")
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	conf := &Config{ServerConfig: ServerConfig{AdminPassword: string(hash)}}
+
+	u := registry.User{Nick: "foo", URL: "https://foo.example/twtxt.txt", PasscodeHash: []byte("hash")}
+	tweets := []registry.Tweet{
+		{DateTime: time.Date(2022, 1, 1, 0, 0, 0, 0, time.UTC), Body: "one"},
+		{DateTime: time.Date(2022, 1, 2, 0, 0, 0, 0, time.UTC), Body: "two"},
+	}
+	if _, err := dbConn.InsertUserWithTweets(ctx, &u, tweets); err != nil {
+		t.Fatal(err.Error())
+	}
+
+	r := mux.NewRouter()
+	setUpRoutes(r, conf, dbConn)
+	serve := func(path, pass string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, path, nil)
+		req.Header.Set("X-Auth", pass)
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		return w
+	}
+
+	if w := serve("/api/admin/users/hide?url="+u.URL, "wrong"); w.Code != http.StatusForbidden {
+		t.Errorf("Expected %d with the wrong password, got %d", http.StatusForbidden, w.Code)
+	}
+	if w := serve("/api/admin/users/hide", "hunter2"); w.Code != http.StatusBadRequest {
+		t.Errorf("Expected %d without a url, got %d", http.StatusBadRequest, w.Code)
+	}
+	if w := serve("/api/admin/users/hide?url=https://nobody.example/twtxt.txt", "hunter2"); w.Code != http.StatusNotFound {
+		t.Errorf("Expected %d for an unknown user, got %d", http.StatusNotFound, w.Code)
+	}
+
+	w := serve("/api/admin/users/hide?url="+u.URL, "hunter2")
+	resp := MessageResponse{}
+	if err := json.NewDecoder(w.Body).Decode(&resp); err != nil {
+		t.Fatal(err.Error())
+	}
+	if w.Code != http.StatusOK || resp.TweetsChanged != 2 {
+		t.Fatalf("Expected both tweets to be hidden, got %d %+v", w.Code, resp)
+	}
+	if visible, err := dbConn.GetUserTweets(ctx, u.ID, 10); err != nil || len(visible) != 0 {
+		t.Errorf("Expected no visible tweets, got %d, %v", len(visible), err)
+	}
+
+	w = serve("/api/admin/users/unhide?url="+u.URL, "hunter2")
+	resp = MessageResponse{}
+	if err := json.NewDecoder(w.Body).Decode(&resp); err != nil {
+		t.Fatal(err.Error())
+	}
+	if w.Code != http.StatusOK || resp.TweetsChanged != 2 {
+		t.Errorf("Expected both tweets to be unhidden, got %d %+v", w.Code, resp)
+	}
+}
@@ -0,0 +1,173 @@
+package main
+
+/*
+Copyright 2021 G. Benjamin Morrison
+
+This file is part of getwtxt-ng.
+
+getwtxt-ng is free software: you can redistribute it and/or modify
+it under the terms of the GNU Affero General Public License as
+published by the Free Software Foundation, either version 3 of the
+License, or (at your option) any later version.
+
+getwtxt-ng is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU Affero General Public License for more details.
+
+You should have received a copy of the GNU Affero General Public License
+along with getwtxt-ng.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/gorilla/mux"
+
+	"github.com/gbmor/getwtxt-ng/common"
+	"github.com/gbmor/getwtxt-ng/registry"
+)
+
+// hostedFeedPrefix is the path under site_url that hosted feeds are served from.
+const hostedFeedPrefix = "/u/"
+
+// errHostedFeedNick is returned when registering a hosted feed URL that belongs to a different nickname.
+var errHostedFeedNick = errors.New("hosted feed URL doesn't match nickname")
+
+// hostedPostRequest is the body of a JSON request to post to a hosted feed.
+type hostedPostRequest struct {
+	Nick string `json:"nickname"`
+	Body string `json:"body"`
+}
+
+// hostedFeedURL returns the URL the registry serves nick's hosted feed at, or an empty string if hosting is off.
+func hostedFeedURL(conf *Config, nick string) string {
+	conf.mu.RLock()
+	defer conf.mu.RUnlock()
+	if !conf.ServerConfig.HostedFeeds {
+		return ""
+	}
+
+	return strings.TrimSuffix(conf.InstanceConfig.SiteURL, "/") + hostedFeedPrefix + url.PathEscape(nick) + "/twtxt.txt"
+}
+
+// isHostedRegistration reports whether registering nick with twtxtURL creates a hosted feed rather than one
+// that's fetched. Returns errHostedFeedNick for a hosted feed URL under a different nickname.
+func isHostedRegistration(conf *Config, nick, twtxtURL string) (bool, error) {
+	feedURL := hostedFeedURL(conf, nick)
+	if feedURL == "" {
+		return false, nil
+	}
+	if twtxtURL == feedURL {
+		return true, nil
+	}
+	prefix := strings.TrimSuffix(feedURL, url.PathEscape(nick)+"/twtxt.txt")
+	if strings.HasPrefix(twtxtURL, prefix) {
+		return false, errHostedFeedNick
+	}
+
+	return false, nil
+}
+
+func setUpHostedFeedRoutes(r *mux.Router, conf *Config, dbConn *registry.DB) {
+	r.HandleFunc(hostedFeedPrefix+"{nick}/twtxt.txt", func(w http.ResponseWriter, r *http.Request) {
+		hostedFeedHandler(w, r, conf, dbConn, mux.Vars(r)["nick"])
+	}).Methods(http.MethodGet, http.MethodHead)
+	r.HandleFunc("/api/{format:json|plain}/post", func(w http.ResponseWriter, r *http.Request) {
+		postHostedTweetHandler(w, r, conf, dbConn, getFormat(r))
+	}).Methods(http.MethodPost)
+}
+
+// hostedFeedHandler serves the twtxt file of a hosted feed.
+func hostedFeedHandler(w http.ResponseWriter, r *http.Request, conf *Config, dbConn *registry.DB, nick string) {
+	user, tweets, err := dbConn.GetHostedFeed(r.Context(), hostedFeedURL(conf, nick))
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			errorWrite(w, r, APIFormatPlain, http.StatusNotFound, "No hosted feed for that nickname")
+			return
+		}
+		reqLog(r).Errorf("When retrieving hosted feed of %s: %s", nick, err)
+		errorWrite(w, r, APIFormatPlain, http.StatusInternalServerError, "")
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	w.WriteHeader(http.StatusOK)
+	if _, err := w.Write([]byte(registry.FormatTwtxtFile(user, tweets))); err != nil {
+		reqLog(r).Error(err)
+	}
+}
+
+// postHostedTweetHandler adds a twt to a hosted feed. The feed's passcode is provided in the X-Auth header.
+func postHostedTweetHandler(w http.ResponseWriter, r *http.Request, conf *Config, dbConn *registry.DB, format APIFormat) {
+	ctx := r.Context()
+	writeErr := func(msg MessageResponse, code int) {
+		errorResponseWrite(w, r, format, code, msg)
+	}
+
+	pass := r.Header.Get("X-Auth")
+	if pass == "" {
+		writeErr(MessageResponse{}, http.StatusForbidden)
+		return
+	}
+
+	req := hostedPostRequest{}
+	if format == APIFormatJSON {
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			writeErr(MessageResponse{Message: "Invalid request body"}, http.StatusBadRequest)
+			return
+		}
+	} else {
+		_ = r.ParseForm()
+		req.Nick = r.Form.Get("nickname")
+		req.Body = r.Form.Get("body")
+	}
+	req.Nick = strings.TrimSpace(req.Nick)
+	if req.Nick == "" {
+		writeErr(fieldErrorResponse(FieldError{Field: "nickname", Code: fieldRequired, Message: "Please provide a nickname"}), http.StatusBadRequest)
+		return
+	}
+
+	user, err := dbConn.GetFullUserByURL(ctx, hostedFeedURL(conf, req.Nick))
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			writeErr(MessageResponse{Message: "No hosted feed for that nickname"}, http.StatusNotFound)
+			return
+		}
+		reqLog(r).Errorf("When grabbing hosted user %s: %s", req.Nick, err)
+		writeErr(MessageResponse{}, http.StatusInternalServerError)
+		return
+	}
+	if !common.ValidatePass(pass, user.PasscodeHash) {
+		writeErr(MessageResponse{}, http.StatusForbidden)
+		return
+	}
+
+	tweet, err := dbConn.PostHostedTweet(ctx, user, req.Body)
+	if err != nil {
+		switch {
+		case errors.Is(err, registry.ErrInvalidTwtBody):
+			msg := fmt.Sprintf("Twts must be a single line of at most %d bytes", registry.HostedTwtMaxLength)
+			writeErr(fieldErrorResponse(FieldError{Field: "body", Code: fieldInvalid, Message: msg}), http.StatusBadRequest)
+		case errors.Is(err, registry.ErrUserNotHosted):
+			writeErr(MessageResponse{Message: "No hosted feed for that nickname"}, http.StatusNotFound)
+		default:
+			reqLog(r).Errorf("When posting to hosted feed of %s: %s", user.Nick, err)
+			writeErr(MessageResponse{}, http.StatusInternalServerError)
+		}
+		return
+	}
+
+	msg := MessageResponse{Message: fmt.Sprintf("Posted twt %s", tweet.Hash), TweetsAdded: 1}
+	if format == APIFormatPlain {
+		plainResponseWrite(w, msg.Message, http.StatusOK)
+	} else if format == APIFormatJSON {
+		jsonResponseWrite(w, r, msg, http.StatusOK)
+	}
+}
@@ -0,0 +1,110 @@
+package main
+
+/*
+Copyright 2021 G. Benjamin Morrison
+
+This file is part of getwtxt-ng.
+
+getwtxt-ng is free software: you can redistribute it and/or modify
+it under the terms of the GNU Affero General Public License as
+published by the Free Software Foundation, either version 3 of the
+License, or (at your option) any later version.
+
+getwtxt-ng is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU Affero General Public License for more details.
+
+You should have received a copy of the GNU Affero General Public License
+along with getwtxt-ng.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+import (
+	"bytes"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/gorilla/mux"
+)
+
+func TestHostedFeeds(t *testing.T) {
+	dbConn := getFederationDB(t)
+	conf := &Config{
+		ServerConfig:   ServerConfig{SpecCompliant: true, EntriesPerPageMin: 10, EntriesPerPageMax: 1000, HostedFeeds: true},
+		InstanceConfig: InstanceConfig{SiteURL: "https://registry.example/"},
+	}
+	r := mux.NewRouter()
+	setUpRoutes(r, conf, dbConn)
+	setUpHostedFeedRoutes(r, conf, dbConn)
+	srv := httptest.NewServer(r)
+	t.Cleanup(srv.Close)
+
+	feedURL := "https://registry.example/u/foo/twtxt.txt"
+	if got := hostedFeedURL(conf, "foo"); got != feedURL {
+		t.Fatalf("Expected hosted feed URL %s, got %s", feedURL, got)
+	}
+
+	code, _, _ := specRequest(t, http.MethodPost, srv.URL+"/api/plain/users?nickname=bar&url="+url.QueryEscape(feedURL))
+	if code != http.StatusBadRequest {
+		t.Errorf("Expected 400 registering another nickname's hosted feed, got %d", code)
+	}
+
+	code, header, _ := specRequest(t, http.MethodPost, srv.URL+"/api/plain/users?nickname=foo&url="+url.QueryEscape(feedURL))
+	if code != http.StatusOK {
+		t.Fatalf("Expected 200 registering a hosted feed, got %d", code)
+	}
+	passcode := header.Get("X-Passcode")
+
+	post := func(format, pass, contentType, body string) int {
+		t.Helper()
+		req, err := http.NewRequest(http.MethodPost, srv.URL+"/api/"+format+"/post", strings.NewReader(body))
+		if err != nil {
+			t.Fatal(err.Error())
+		}
+		req.Header.Set("Content-Type", contentType)
+		req.Header.Set("X-Auth", pass)
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err.Error())
+		}
+		_ = resp.Body.Close()
+		return resp.StatusCode
+	}
+	form := "application/x-www-form-urlencoded"
+	if code := post("plain", "wrong", form, "nickname=foo&body=hi"); code != http.StatusForbidden {
+		t.Errorf("Expected 403 with the wrong passcode, got %d", code)
+	}
+	if code := post("plain", passcode, form, "nickname=nobody&body=hi"); code != http.StatusNotFound {
+		t.Errorf("Expected 404 posting as an unknown nickname, got %d", code)
+	}
+	if code := post("plain", passcode, form, "nickname=foo&body="); code != http.StatusBadRequest {
+		t.Errorf("Expected 400 posting an empty twt, got %d", code)
+	}
+	if code := post("plain", passcode, form, "nickname=foo&body=hello+world"); code != http.StatusOK {
+		t.Errorf("Expected 200 posting a twt, got %d", code)
+	}
+	if code := post("json", passcode, "application/json", `{"nickname":"foo","body":"hello again"}`); code != http.StatusOK {
+		t.Errorf("Expected 200 posting a twt as json, got %d", code)
+	}
+
+	resp, err := http.Get(srv.URL + "/u/foo/twtxt.txt")
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	body, _ := io.ReadAll(resp.Body)
+	_ = resp.Body.Close()
+	if resp.StatusCode != http.StatusOK || !bytes.HasPrefix(body, []byte("# nick = foo\n")) {
+		t.Fatalf("Unexpected hosted feed %d: %s", resp.StatusCode, body)
+	}
+	if !bytes.Contains(body, []byte("\thello world\n")) || !bytes.Contains(body, []byte("\thello again\n")) {
+		t.Errorf("Expected posted twts in hosted feed, got: %s", body)
+	}
+
+	if code, _, _ := specRequest(t, http.MethodGet, srv.URL+"/u/nobody/twtxt.txt"); code != http.StatusNotFound {
+		t.Errorf("Expected 404 for a feed that isn't hosted, got %d", code)
+	}
+}
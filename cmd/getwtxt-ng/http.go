@@ -19,9 +19,11 @@ along with getwtxt-ng.  If not, see <https://www.gnu.org/licenses/>.
 package main
 
 import (
+	"context"
 	"fmt"
 	"net/http"
 	"os"
+	"runtime/debug"
 
 	"github.com/gorilla/mux"
 	"github.com/throttled/throttled/v2"
@@ -43,17 +45,22 @@ func getFormat(r *http.Request) APIFormat {
 }
 
 func getHTTPRateLimiter(conf *Config) throttled.HTTPRateLimiter {
+	limits := throttled.RateQuota{
+		MaxRate:  throttled.PerMin(conf.ServerConfig.HTTPRequestsPerMinute),
+		MaxBurst: conf.ServerConfig.HTTPRequestsBurstMax,
+	}
+
+	return newHTTPRateLimiter(limits, &throttled.VaryBy{Path: true})
+}
+
+// newHTTPRateLimiter builds a rate limiter keeping its state in memory, answering 429 to requests over quota.
+func newHTTPRateLimiter(limits throttled.RateQuota, varyBy *throttled.VaryBy) throttled.HTTPRateLimiter {
 	store, err := memstore.New(65536)
 	if err != nil {
 		fmt.Printf("Could not initialize memstore for HTTP rate limiter: %s", err)
 		os.Exit(1)
 	}
 
-	limits := throttled.RateQuota{
-		MaxRate:  throttled.PerMin(conf.ServerConfig.HTTPRequestsPerMinute),
-		MaxBurst: conf.ServerConfig.HTTPRequestsBurstMax,
-	}
-
 	rl, err := throttled.NewGCRARateLimiter(store, limits)
 	if err != nil {
 		fmt.Printf("Couldn't build rate limiter: %s", err)
@@ -61,46 +68,217 @@ func getHTTPRateLimiter(conf *Config) throttled.HTTPRateLimiter {
 	}
 
 	return throttled.HTTPRateLimiter{
-		DeniedHandler: http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
-			w.WriteHeader(http.StatusTooManyRequests)
+		DeniedHandler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			errorWrite(w, r, errorFormat(r), http.StatusTooManyRequests, "")
 		}),
 		RateLimiter: rl,
-		VaryBy:      &throttled.VaryBy{Path: true},
+		VaryBy:      varyBy,
 	}
 }
 
-func setUpRoutes(r *mux.Router, conf *Config, dbConn *registry.DB) {
-	r.HandleFunc("/api/{format:json|plain}/mentions", func(w http.ResponseWriter, r *http.Request) {
-		getMentionsHandler(w, r, dbConn, getFormat(r))
-	}).Methods(http.MethodGet, http.MethodHead)
+// withRequestTimeout gives the database work of each request request_timeout to finish, so a slow
+// search gives up with an error rather than running into the write timeout and leaving the client
+// with nothing.
+func withRequestTimeout(conf *Config, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conf.mu.RLock()
+		timeout := conf.ServerConfig.RequestTimeout
+		conf.mu.RUnlock()
+		if timeout <= 0 {
+			next.ServeHTTP(w, r)
+			return
+		}
+		ctx, cancel := context.WithTimeout(r.Context(), timeout)
+		defer cancel()
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
 
-	r.HandleFunc("/api/{format:json|plain}/tags/{tag:[\\w]+}", func(w http.ResponseWriter, r *http.Request) {
-		vars := mux.Vars(r)
-		getTagsHandler(w, r, dbConn, getFormat(r), vars["tag"])
+// withRecovery turns a panic in a handler into a logged stack trace and a 500 in the format the
+// client asked for, rather than a dropped connection. If the response was already started, all
+// that can be done is to log it.
+func withRecovery(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		pw := &panicWriter{ResponseWriter: w}
+		defer func() {
+			rec := recover()
+			if rec == nil {
+				return
+			}
+			if rec == http.ErrAbortHandler {
+				panic(rec)
+			}
+			reqLog(r).Errorf("Panic while handling %s %s: %v\n%s", r.Method, r.URL.Path, rec, debug.Stack())
+			if !pw.wroteHeader {
+				errorWrite(w, r, errorFormat(r), http.StatusInternalServerError, "")
+			}
+		}()
+		next.ServeHTTP(pw, r)
+	})
+}
+
+// panicWriter notes whether a response has been started, so withRecovery knows if it can still send one.
+type panicWriter struct {
+	http.ResponseWriter
+	wroteHeader bool
+}
+
+func (pw *panicWriter) WriteHeader(status int) {
+	pw.wroteHeader = true
+	pw.ResponseWriter.WriteHeader(status)
+}
+
+func (pw *panicWriter) Write(b []byte) (int, error) {
+	pw.wroteHeader = true
+	return pw.ResponseWriter.Write(b)
+}
+
+// setUpAdminRoutes adds the routes of the admin API, under /api/admin, and the bulk user import.
+func setUpAdminRoutes(r *mux.Router, conf *Config, dbConn *registry.DB) {
+	r.HandleFunc("/api/admin/export.tar.gz", func(w http.ResponseWriter, r *http.Request) {
+		exportArchiveHandler(w, r, conf, dbConn)
 	}).Methods(http.MethodGet, http.MethodHead)
-	r.HandleFunc("/api/{format:json|plain}/tags", func(w http.ResponseWriter, r *http.Request) {
-		getTagsHandler(w, r, dbConn, getFormat(r), "")
+	r.HandleFunc("/api/admin/users/duplicates", func(w http.ResponseWriter, r *http.Request) {
+		duplicateUsersHandler(w, r, conf, dbConn)
+	}).Methods(http.MethodGet, http.MethodHead, http.MethodPost)
+	r.HandleFunc("/api/admin/users/hide", func(w http.ResponseWriter, r *http.Request) {
+		userTweetsVisibilityHandler(w, r, conf, dbConn, registry.StatusHidden)
+	}).Methods(http.MethodPost)
+	r.HandleFunc("/api/admin/users/unhide", func(w http.ResponseWriter, r *http.Request) {
+		userTweetsVisibilityHandler(w, r, conf, dbConn, registry.StatusVisible)
+	}).Methods(http.MethodPost)
+	r.HandleFunc("/api/admin/filtered", func(w http.ResponseWriter, r *http.Request) {
+		filteredTweetsHandler(w, r, conf, dbConn)
 	}).Methods(http.MethodGet, http.MethodHead)
-
-	r.HandleFunc("/api/{format:json|plain}/tweets", func(w http.ResponseWriter, r *http.Request) {
-		getTweetsHandler(w, r, dbConn, getFormat(r))
+	r.HandleFunc("/api/admin/filtered/{id:[0-9]+}/release", func(w http.ResponseWriter, r *http.Request) {
+		releaseFilteredTweetHandler(w, r, conf, dbConn)
+	}).Methods(http.MethodPost)
+	r.HandleFunc("/api/admin/bans", func(w http.ResponseWriter, r *http.Request) {
+		bansHandler(w, r, conf, dbConn)
+	}).Methods(http.MethodGet, http.MethodHead, http.MethodPost)
+	r.HandleFunc("/api/admin/bans/{id:[0-9]+}", func(w http.ResponseWriter, r *http.Request) {
+		deleteBanHandler(w, r, conf, dbConn)
+	}).Methods(http.MethodDelete)
+	r.HandleFunc("/api/admin/approvals", func(w http.ResponseWriter, r *http.Request) {
+		approvalsHandler(w, r, conf, dbConn)
 	}).Methods(http.MethodGet, http.MethodHead)
+	r.HandleFunc("/api/admin/approvals/approve", func(w http.ResponseWriter, r *http.Request) {
+		approveUserHandler(w, r, conf, dbConn)
+	}).Methods(http.MethodPost)
+	r.HandleFunc("/api/admin/approvals/reject", func(w http.ResponseWriter, r *http.Request) {
+		rejectUserHandler(w, r, conf, dbConn)
+	}).Methods(http.MethodPost)
 
+	bulkJobs := newBulkAddJobs()
 	r.HandleFunc("/api/plain/users/bulk", func(w http.ResponseWriter, r *http.Request) {
-		plainBulkAddUserHandler(w, r, conf, dbConn)
+		plainBulkAddUserHandler(w, r, conf, dbConn, bulkJobs)
 	}).Methods(http.MethodPost)
+	r.HandleFunc("/api/plain/users/bulk/{id}", func(w http.ResponseWriter, r *http.Request) {
+		plainBulkAddStatusHandler(w, r, conf, bulkJobs)
+	}).Methods(http.MethodGet, http.MethodHead)
+}
+
+func setUpRoutes(r *mux.Router, conf *Config, dbConn *registry.DB) {
+	r.NotFoundHandler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		errorWrite(w, r, errorFormat(r), http.StatusNotFound, "")
+	})
+	r.MethodNotAllowedHandler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		errorWrite(w, r, errorFormat(r), http.StatusMethodNotAllowed, "")
+	})
+
+	// With an admin listener, the admin API is only served there.
+	conf.mu.RLock()
+	adminListen := conf.ServerConfig.AdminListen
+	conf.mu.RUnlock()
+	if adminListen == "" {
+		setUpAdminRoutes(r, conf, dbConn)
+	}
+
+	r.HandleFunc("/api/{format:json|plain}/conversations/{hash:[a-z2-7]+}", func(w http.ResponseWriter, r *http.Request) {
+		vars := mux.Vars(r)
+		// Conversations are valid Webmention targets, so senders need to be able to find the endpoint.
+		w.Header().Set("Link", `</webmention>; rel="webmention"`)
+		getConversationHandler(w, r, conf, dbConn, getFormat(r), vars["hash"])
+	}).Methods(http.MethodGet, http.MethodHead)
+
+	r.HandleFunc("/api/{format:json|plain}/mentions", specPaging(conf, func(w http.ResponseWriter, r *http.Request) {
+		getMentionsHandler(w, r, conf, dbConn, getFormat(r))
+	})).Methods(http.MethodGet, http.MethodHead)
+
+	// Registered before the tag listing, which "suggest" would otherwise match.
+	r.HandleFunc("/api/{format:json|plain}/tags/suggest", func(w http.ResponseWriter, r *http.Request) {
+		suggestTagsHandler(w, r, dbConn, getFormat(r))
+	}).Methods(http.MethodGet, http.MethodHead)
+	r.HandleFunc("/api/{format:json|plain}/tags/{tag:[\\w]+}", specPaging(conf, func(w http.ResponseWriter, r *http.Request) {
+		vars := mux.Vars(r)
+		getTagsHandler(w, r, conf, dbConn, getFormat(r), vars["tag"])
+	})).Methods(http.MethodGet, http.MethodHead)
+	r.HandleFunc("/api/{format:json|plain}/tags", specPaging(conf, func(w http.ResponseWriter, r *http.Request) {
+		getTagsHandler(w, r, conf, dbConn, getFormat(r), "")
+	})).Methods(http.MethodGet, http.MethodHead)
+
+	r.HandleFunc("/api/{format:json|plain}/tweets", specPaging(conf, func(w http.ResponseWriter, r *http.Request) {
+		getTweetsHandler(w, r, conf, dbConn, getFormat(r))
+	})).Methods(http.MethodGet, http.MethodHead)
+
+	r.HandleFunc("/api/{format:json|plain}/suggest", func(w http.ResponseWriter, r *http.Request) {
+		suggestUsersHandler(w, r, dbConn, getFormat(r))
+	}).Methods(http.MethodGet, http.MethodHead)
+	r.HandleFunc("/api/{format:json|plain}/users/status", func(w http.ResponseWriter, r *http.Request) {
+		getUserStatusHandler(w, r, conf, dbConn, getFormat(r))
+	}).Methods(http.MethodGet, http.MethodHead)
 	r.HandleFunc("/api/{format:json|plain}/users", func(w http.ResponseWriter, r *http.Request) {
 		deleteUsersHandler(w, r, conf, dbConn, getFormat(r))
 	}).Methods(http.MethodDelete)
-	r.HandleFunc("/api/{format:json|plain}/users", func(w http.ResponseWriter, r *http.Request) {
-		getUsersHandler(w, r, dbConn, getFormat(r))
-	}).Methods(http.MethodGet, http.MethodHead)
+	r.HandleFunc("/api/{format:json|plain}/users/undelete", func(w http.ResponseWriter, r *http.Request) {
+		undeleteUserHandler(w, r, conf, dbConn, getFormat(r))
+	}).Methods(http.MethodPost)
+	r.HandleFunc("/api/{format:json|plain}/users", specPaging(conf, func(w http.ResponseWriter, r *http.Request) {
+		getUsersHandler(w, r, conf, dbConn, getFormat(r))
+	})).Methods(http.MethodGet, http.MethodHead)
 	r.HandleFunc("/api/{format:json|plain}/users", func(w http.ResponseWriter, r *http.Request) {
 		addUserHandler(w, r, conf, dbConn, getFormat(r))
 	}).Methods(http.MethodPost)
 
+	r.HandleFunc("/api/v1/timelines/public", func(w http.ResponseWriter, r *http.Request) {
+		mastodonPublicTimelineHandler(w, r, conf, dbConn)
+	}).Methods(http.MethodGet, http.MethodHead)
+	r.HandleFunc("/api/v1/accounts/{id:[0-9]+}/statuses", func(w http.ResponseWriter, r *http.Request) {
+		mastodonAccountStatusesHandler(w, r, conf, dbConn)
+	}).Methods(http.MethodGet, http.MethodHead)
+	r.HandleFunc("/api/v1/accounts/{id:[0-9]+}", func(w http.ResponseWriter, r *http.Request) {
+		mastodonAccountHandler(w, r, dbConn)
+	}).Methods(http.MethodGet, http.MethodHead)
+	r.HandleFunc("/api/v1/statuses/{id:[0-9]+}", func(w http.ResponseWriter, r *http.Request) {
+		mastodonStatusHandler(w, r, conf, dbConn)
+	}).Methods(http.MethodGet, http.MethodHead)
+
+	r.HandleFunc("/api/{format:json|plain}/webmentions", func(w http.ResponseWriter, r *http.Request) {
+		getWebmentionsHandler(w, r, conf, dbConn, getFormat(r))
+	}).Methods(http.MethodGet, http.MethodHead)
+
+	r.HandleFunc("/api/{format:json|plain}/registries", func(w http.ResponseWriter, r *http.Request) {
+		getRegistriesHandler(w, r, conf, dbConn, getFormat(r))
+	}).Methods(http.MethodGet, http.MethodHead)
+
+	r.HandleFunc("/api/{format:json|plain}/stats/daily", func(w http.ResponseWriter, r *http.Request) {
+		getDailyStatsHandler(w, r, conf, dbConn, getFormat(r))
+	}).Methods(http.MethodGet, http.MethodHead)
+	r.HandleFunc("/api/{format:json|plain}/stats/registrations", func(w http.ResponseWriter, r *http.Request) {
+		getRegistrationsHandler(w, r, dbConn, getFormat(r))
+	}).Methods(http.MethodGet, http.MethodHead)
+	r.HandleFunc("/api/{format:json|plain}/sync/history", func(w http.ResponseWriter, r *http.Request) {
+		getSyncHistoryHandler(w, r, dbConn, getFormat(r))
+	}).Methods(http.MethodGet, http.MethodHead)
+
 	r.HandleFunc("/api/{format:json|plain}/version", versionHandler).
 		Methods(http.MethodGet, http.MethodHead)
+	r.HandleFunc("/api/openapi.yaml", openAPIHandler).
+		Methods(http.MethodGet, http.MethodHead)
+
+	r.HandleFunc("/webmention", func(w http.ResponseWriter, r *http.Request) {
+		webmentionHandler(w, r, conf, dbConn)
+	}).Methods(http.MethodPost)
 
 	r.HandleFunc("/docs/json.html", func(w http.ResponseWriter, r *http.Request) {
 		jsonDocsHandler(w, r, conf, dbConn)
@@ -108,6 +286,13 @@ func setUpRoutes(r *mux.Router, conf *Config, dbConn *registry.DB) {
 	r.HandleFunc("/docs/plain.html", func(w http.ResponseWriter, r *http.Request) {
 		plainDocsHandler(w, r, conf, dbConn)
 	}).Methods(http.MethodGet, http.MethodHead)
+	r.HandleFunc("/tweets/{id:[0-9]+}", func(w http.ResponseWriter, r *http.Request) {
+		tweetPageHandler(w, r, conf, dbConn)
+	}).Methods(http.MethodGet, http.MethodHead)
+	avatars := newAvatarProxy(conf, dbConn)
+	r.HandleFunc("/avatars/{id:[0-9]+}", func(w http.ResponseWriter, r *http.Request) {
+		avatarHandler(w, r, avatars)
+	}).Methods(http.MethodGet, http.MethodHead)
 	r.HandleFunc("/css", func(w http.ResponseWriter, r *http.Request) {
 		cssHandler(w, r, conf)
 	}).Methods(http.MethodGet, http.MethodHead)
@@ -0,0 +1,170 @@
+package main
+
+/*
+Copyright 2021 G. Benjamin Morrison
+
+This file is part of getwtxt-ng.
+
+getwtxt-ng is free software: you can redistribute it and/or modify
+it under the terms of the GNU Affero General Public License as
+published by the Free Software Foundation, either version 3 of the
+License, or (at your option) any later version.
+
+getwtxt-ng is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU Affero General Public License for more details.
+
+You should have received a copy of the GNU Affero General Public License
+along with getwtxt-ng.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+import (
+	"bytes"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gbmor/getwtxt-ng/registry"
+)
+
+// Polling clients ask for the same few API responses over and over between syncs.
+// Like the read cache, responses to writes made through getwtxt-ctl are served until responseCacheTTL has passed.
+const (
+	responseCacheEntries = 512
+	responseCacheMaxBody = 1 << 20
+	responseCacheTTL     = readCacheTTL
+)
+
+// responseCache keeps the bodies of successful GET API responses, keyed by path and query.
+// Everything is dropped when the registry's generation changes, which happens whenever
+// a write is committed or a sync run completes.
+type responseCache struct {
+	dbConn     *registry.DB
+	ttl        time.Duration
+	maxEntries int
+
+	mu      sync.Mutex
+	gen     uint64
+	entries map[string]cachedResponse
+}
+
+type cachedResponse struct {
+	status  int
+	header  http.Header
+	body    []byte
+	expires time.Time
+}
+
+func newResponseCache(dbConn *registry.DB, ttl time.Duration, maxEntries int) *responseCache {
+	return &responseCache{
+		dbConn:     dbConn,
+		ttl:        ttl,
+		maxEntries: maxEntries,
+		gen:        dbConn.Generation(),
+		entries:    make(map[string]cachedResponse),
+	}
+}
+
+// cacheable reports whether the response to r may be cached. Only anonymous GET requests to the API are.
+func cacheable(r *http.Request) bool {
+	return r.Method == http.MethodGet && strings.HasPrefix(r.URL.Path, "/api/") && r.Header.Get("X-Auth") == ""
+}
+
+// get returns the response cached under key along with the generation to pass to put if it's missing.
+func (c *responseCache) get(key string) (cachedResponse, uint64, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if gen := c.dbConn.Generation(); gen != c.gen {
+		c.gen = gen
+		c.entries = make(map[string]cachedResponse)
+		return cachedResponse{}, gen, false
+	}
+	entry, ok := c.entries[key]
+	if !ok || time.Now().After(entry.expires) {
+		return cachedResponse{}, c.gen, false
+	}
+
+	return entry, c.gen, true
+}
+
+// put stores resp under key, unless the registry has changed since gen was retrieved.
+// When the cache is full it's emptied, as it'll be soon enough by the next sync anyway.
+func (c *responseCache) put(key string, gen uint64, resp cachedResponse) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if gen != c.gen || gen != c.dbConn.Generation() {
+		return
+	}
+	if len(c.entries) >= c.maxEntries {
+		c.entries = make(map[string]cachedResponse)
+	}
+	resp.expires = time.Now().Add(c.ttl)
+	c.entries[key] = resp
+}
+
+// wrap serves cacheable requests from the cache when possible, otherwise passing them to next
+// and keeping successful responses.
+func (c *responseCache) wrap(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !cacheable(r) {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		key := r.URL.Path + "?" + r.URL.RawQuery
+		cached, gen, ok := c.get(key)
+		if ok {
+			for k, v := range cached.header {
+				w.Header()[k] = v
+			}
+			w.Header().Set("X-Cache", "HIT")
+			w.WriteHeader(cached.status)
+			_, _ = w.Write(cached.body)
+			return
+		}
+
+		rec := &cacheRecorder{ResponseWriter: w, status: http.StatusOK}
+		w.Header().Set("X-Cache", "MISS")
+		next.ServeHTTP(rec, r)
+		if rec.status != http.StatusOK || rec.overflow {
+			return
+		}
+		header := w.Header().Clone()
+		header.Del("X-Cache")
+		header.Del("X-Request-ID")
+		c.put(key, gen, cachedResponse{
+			status: rec.status,
+			header: header,
+			body:   rec.body.Bytes(),
+		})
+	})
+}
+
+// cacheRecorder passes a response through while keeping a copy of it, up to responseCacheMaxBody.
+type cacheRecorder struct {
+	http.ResponseWriter
+	status   int
+	body     bytes.Buffer
+	overflow bool
+}
+
+func (rec *cacheRecorder) WriteHeader(status int) {
+	rec.status = status
+	rec.ResponseWriter.WriteHeader(status)
+}
+
+func (rec *cacheRecorder) Write(b []byte) (int, error) {
+	if !rec.overflow {
+		if rec.body.Len()+len(b) > responseCacheMaxBody {
+			rec.overflow = true
+			rec.body = bytes.Buffer{}
+		} else {
+			rec.body.Write(b)
+		}
+	}
+	return rec.ResponseWriter.Write(b)
+}
@@ -0,0 +1,88 @@
+package main
+
+/*
+Copyright 2021 G. Benjamin Morrison
+
+This file is part of getwtxt-ng.
+
+getwtxt-ng is free software: you can redistribute it and/or modify
+it under the terms of the GNU Affero General Public License as
+published by the Free Software Foundation, either version 3 of the
+License, or (at your option) any later version.
+
+getwtxt-ng is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU Affero General Public License for more details.
+
+You should have received a copy of the GNU Affero General Public License
+along with getwtxt-ng.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gbmor/getwtxt-ng/registry"
+)
+
+func TestResponseCache(t *testing.T) {
+	dbConn := getFederationDB(t)
+	calls := 0
+	next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		if r.URL.Query().Get("fail") != "" {
+			http.Error(w, "nope", http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "text/plain")
+		_, _ = fmt.Fprintf(w, "response %d", calls)
+	})
+	handler := newResponseCache(dbConn, time.Minute, 2).wrap(next)
+
+	get := func(target string, header http.Header) *httptest.ResponseRecorder {
+		t.Helper()
+		req := httptest.NewRequest(http.MethodGet, target, nil)
+		for k, v := range header {
+			req.Header[k] = v
+		}
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		return rec
+	}
+
+	first := get("/api/plain/tweets?page=1", nil)
+	second := get("/api/plain/tweets?page=1", nil)
+	if calls != 1 || second.Body.String() != first.Body.String() || second.Header().Get("X-Cache") != "HIT" {
+		t.Errorf("Expected second request to be served from the cache, got %d calls: %q", calls, second.Body.String())
+	}
+	if second.Header().Get("Content-Type") != "text/plain" {
+		t.Errorf("Expected cached headers to be served, got %v", second.Header())
+	}
+
+	get("/api/plain/tweets?page=2", nil)
+	if calls != 2 {
+		t.Errorf("Expected a different query to miss, got %d calls", calls)
+	}
+
+	get("/api/plain/tweets?page=1", http.Header{"X-Auth": {"hunter2"}})
+	get("/docs/plain.html", nil)
+	get("/docs/plain.html", nil)
+	get("/api/plain/tweets?fail=1", nil)
+	get("/api/plain/tweets?fail=1", nil)
+	if calls != 7 {
+		t.Errorf("Expected authenticated, non-API, and failed requests to bypass the cache, got %d calls", calls)
+	}
+
+	user := registry.User{Nick: "foo", URL: "https://foo.example/twtxt.txt", PasscodeHash: []byte("not a real hash")}
+	if err := dbConn.InsertUser(context.Background(), &user); err != nil {
+		t.Fatal(err.Error())
+	}
+	if got := get("/api/plain/tweets?page=1", nil); calls != 8 || got.Header().Get("X-Cache") != "MISS" {
+		t.Errorf("Expected a write to invalidate the cache, got %d calls", calls)
+	}
+}
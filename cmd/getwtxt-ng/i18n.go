@@ -0,0 +1,173 @@
+package main
+
+/*
+Copyright 2021 G. Benjamin Morrison
+
+This file is part of getwtxt-ng.
+
+getwtxt-ng is free software: you can redistribute it and/or modify
+it under the terms of the GNU Affero General Public License as
+published by the Free Software Foundation, either version 3 of the
+License, or (at your option) any later version.
+
+getwtxt-ng is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU Affero General Public License for more details.
+
+You should have received a copy of the GNU Affero General Public License
+along with getwtxt-ng.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/BurntSushi/toml"
+	"golang.org/x/text/language"
+)
+
+// Catalogs are the translations of the registry's pages and messages, one per language, read from
+// the <language>.toml files in locales_path, such as de.toml or pt-BR.toml. Each maps the English text
+// of a message to its translation under [messages]; anything missing is left in English.
+type Catalogs struct {
+	// tags are the languages requests are matched against. The first is used when none match.
+	tags     []language.Tag
+	messages map[language.Tag]map[string]string
+	matcher  language.Matcher
+}
+
+// catalogFile is the layout of a catalog on disk.
+type catalogFile struct {
+	Messages map[string]string `toml:"messages"`
+}
+
+// loadCatalogs reads the catalogs in dir. Requests not asking for any of them get defaultLang,
+// which is English, the language messages are written in, unless it's one of the catalogs.
+func loadCatalogs(dir, defaultLang string) (*Catalogs, error) {
+	defaultTag := language.English
+	if strings.TrimSpace(defaultLang) != "" {
+		tag, err := language.Parse(defaultLang)
+		if err != nil {
+			return nil, fmt.Errorf("when parsing default_language: %w", err)
+		}
+		defaultTag = tag
+	}
+
+	paths, err := filepath.Glob(filepath.Join(dir, "*.toml"))
+	if err != nil {
+		return nil, fmt.Errorf("when listing message catalogs in %s: %w", dir, err)
+	}
+	c := &Catalogs{messages: make(map[language.Tag]map[string]string, len(paths))}
+	for _, path := range paths {
+		tag, err := language.Parse(strings.TrimSuffix(filepath.Base(path), ".toml"))
+		if err != nil {
+			return nil, fmt.Errorf("when parsing the language of message catalog %s: %w", path, err)
+		}
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return nil, fmt.Errorf("when reading message catalog %s: %w", path, err)
+		}
+		cf := catalogFile{}
+		if err := toml.Unmarshal(data, &cf); err != nil {
+			return nil, fmt.Errorf("when parsing message catalog %s: %w", path, err)
+		}
+		c.messages[tag] = cf.Messages
+		if tag != defaultTag {
+			c.tags = append(c.tags, tag)
+		}
+	}
+
+	if _, ok := c.messages[defaultTag]; !ok && defaultTag != language.English {
+		return nil, fmt.Errorf("default_language %s has no message catalog in %s", defaultTag, dir)
+	}
+	// English needs no catalog, but is matched like the rest.
+	if _, ok := c.messages[language.English]; !ok && defaultTag != language.English {
+		c.tags = append(c.tags, language.English)
+	}
+	c.tags = append([]language.Tag{defaultTag}, c.tags...)
+	c.matcher = language.NewMatcher(c.tags)
+
+	return c, nil
+}
+
+// localizer translates messages into the language negotiated for a request.
+type localizer struct {
+	tag      language.Tag
+	messages map[string]string
+}
+
+// negotiate picks the catalog best matching the languages a client accepts.
+func (c *Catalogs) negotiate(acceptLanguage string) *localizer {
+	accepted, _, _ := language.ParseAcceptLanguage(acceptLanguage)
+	_, i, _ := c.matcher.Match(accepted...)
+	tag := c.tags[i]
+	return &localizer{tag: tag, messages: c.messages[tag]}
+}
+
+// T returns the translation of msg, or msg if it has none.
+func (l *localizer) T(msg string) string {
+	if l == nil {
+		return msg
+	}
+	if t, ok := l.messages[msg]; ok && t != "" {
+		return t
+	}
+	return msg
+}
+
+// Lang is the tag of the language translated into, such as "de", for the lang attribute of pages.
+func (l *localizer) Lang() string {
+	if l == nil {
+		return language.English.String()
+	}
+	return l.tag.String()
+}
+
+// localizerKey is the context key of the localizer given to a request by withLocalization.
+type localizerKey struct{}
+
+// withLocalization negotiates the language of each request from its Accept-Language header,
+// for pages and messages to be translated into.
+func withLocalization(conf *Config, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conf.mu.RLock()
+		catalogs := conf.Assets.Catalogs
+		conf.mu.RUnlock()
+		if catalogs == nil {
+			next.ServeHTTP(w, r)
+			return
+		}
+		loc := catalogs.negotiate(r.Header.Get("Accept-Language"))
+		next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), localizerKey{}, loc)))
+	})
+}
+
+// requestLocalizer returns the localizer withLocalization gave the request. It's nil, and translates
+// nothing, if there are no catalogs.
+func requestLocalizer(r *http.Request) *localizer {
+	loc, _ := r.Context().Value(localizerKey{}).(*localizer)
+	return loc
+}
+
+// setContentLanguage marks a response translated for the request as being in its language,
+// and as depending on Accept-Language, so caches keep each language apart.
+func setContentLanguage(w http.ResponseWriter, r *http.Request) {
+	loc := requestLocalizer(r)
+	if loc == nil {
+		return
+	}
+	w.Header().Set("Content-Language", loc.Lang())
+	w.Header().Add("Vary", "Accept-Language")
+}
+
+// pageData is what the page templates are executed with: the instance's details, plus T to translate
+// the page's text, as in {{.T "Users"}}, and Lang, the language it's translated into.
+type pageData struct {
+	InstanceConfig
+	*localizer
+}
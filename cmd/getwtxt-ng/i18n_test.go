@@ -0,0 +1,123 @@
+package main
+
+/*
+Copyright 2021 G. Benjamin Morrison
+
+This file is part of getwtxt-ng.
+
+getwtxt-ng is free software: you can redistribute it and/or modify
+it under the terms of the GNU Affero General Public License as
+published by the Free Software Foundation, either version 3 of the
+License, or (at your option) any later version.
+
+getwtxt-ng is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU Affero General Public License for more details.
+
+You should have received a copy of the GNU Affero General Public License
+along with getwtxt-ng.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+import (
+	"html/template"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestLoadCatalogs(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "de.toml"), []byte("[messages]\n\"Not Found\" = \"Nicht gefunden\"\n"), 0600); err != nil {
+		t.Fatal(err.Error())
+	}
+	if err := os.WriteFile(filepath.Join(dir, "pt-BR.toml"), []byte("[messages]\n\"Not Found\" = \"Não encontrado\"\n"), 0600); err != nil {
+		t.Fatal(err.Error())
+	}
+
+	catalogs, err := loadCatalogs(dir, "")
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	tests := []struct {
+		acceptLanguage string
+		want           string
+	}{
+		{"", "Not Found"},
+		{"de-DE,de;q=0.9", "Nicht gefunden"},
+		{"fr, pt-BR;q=0.8", "Não encontrado"},
+		{"de;q=0.5, en", "Not Found"},
+		{"fr", "Not Found"},
+		{"not a language", "Not Found"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.acceptLanguage, func(t *testing.T) {
+			if got := catalogs.negotiate(tt.acceptLanguage).T("Not Found"); got != tt.want {
+				t.Errorf("Expected %q, got %q", tt.want, got)
+			}
+		})
+	}
+
+	catalogs, err = loadCatalogs(dir, "de")
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	if loc := catalogs.negotiate("fr"); loc.Lang() != "de" || loc.T("Mail:") != "Mail:" {
+		t.Errorf("Expected German by default, leaving untranslated messages alone, got %s", loc.Lang())
+	}
+	if _, err := loadCatalogs(dir, "fr"); err == nil {
+		t.Error("Expected an error for a default language without a catalog")
+	}
+}
+
+func TestLocalization(t *testing.T) {
+	catalogs, err := loadCatalogs("../../assets/locales", "")
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	indexTmpl, err := template.ParseFiles("../../assets/index.tmpl")
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	conf := &Config{Assets: Assets{IndexTemplate: indexTmpl, Catalogs: catalogs}}
+	dbConn := getFederationDB(t)
+	r := http.NewServeMux()
+	r.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/" {
+			errorWrite(w, r, APIFormatPlain, http.StatusNotFound, "")
+			return
+		}
+		indexHandler(w, r, conf, dbConn)
+	})
+	handler := withLocalization(conf, r)
+
+	serve := func(path, acceptLanguage string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, path, nil)
+		req.Header.Set("Accept-Language", acceptLanguage)
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, req)
+		return w
+	}
+
+	w := serve("/", "de-AT")
+	if !strings.Contains(w.Body.String(), `<html lang="de">`) || !strings.Contains(w.Body.String(), "Endpunkte") {
+		t.Errorf("Expected the index page in German, got %s", w.Body.String())
+	}
+	if w.Header().Get("Content-Language") != "de" || w.Header().Get("Vary") != "Accept-Language" {
+		t.Errorf("Expected Content-Language and Vary headers, got %v", w.Header())
+	}
+	if w := serve("/", "en-US"); !strings.Contains(w.Body.String(), "Endpoints") {
+		t.Errorf("Expected the index page in English, got %s", w.Body.String())
+	}
+	if w := serve("/missing", "de"); !strings.Contains(w.Body.String(), "Nicht gefunden") {
+		t.Errorf("Expected a German error message, got %s", w.Body.String())
+	}
+
+	conf.Assets.Catalogs = nil
+	if w := serve("/", "de"); !strings.Contains(w.Body.String(), `<html lang="en">`) || w.Header().Get("Content-Language") != "" {
+		t.Errorf("Expected the English page without catalogs, got %s", w.Body.String())
+	}
+}
@@ -19,6 +19,7 @@ along with getwtxt-ng.  If not, see <https://www.gnu.org/licenses/>.
 package main
 
 import (
+	"context"
 	"fmt"
 	"net/http"
 	"os"
@@ -34,10 +35,27 @@ import (
 )
 
 var flagConfig = pflag.StringP("config", "c", "getwtxt-ng.toml", "path to config file")
+var flagPrintConfig = pflag.Bool("print-config", false, "print the effective configuration with secrets redacted, then exit")
+var flagPrintConfigFormat = pflag.String("print-config-format", "toml", "format for -print-config: toml or json")
+
+// bridge republishes new twts or users to another network in the background.
+// Close waits for what's already queued to be sent.
+type bridge interface {
+	Close()
+}
+
+// The landing page and polling clients mostly ask for the first few pages of tweets and users.
+// Writes made through getwtxt-ctl aren't seen by the server's cache until readCacheTTL has passed.
+const (
+	readCachePages = 3
+	readCacheTTL   = time.Minute
+)
 
 func main() {
 	pflag.Parse()
-	fmt.Printf("getwtxt-ng %s\n", common.Version)
+	if !*flagPrintConfig {
+		fmt.Printf("getwtxt-ng %s\n", common.Version)
+	}
 	conf, err := readConfig(*flagConfig)
 	if err != nil {
 		fmt.Printf("Error loading configuration from %s: %s\n", *flagConfig, err)
@@ -47,31 +65,121 @@ func main() {
 		fmt.Printf("Config at %s has errors: %s\n", *flagConfig, err)
 		os.Exit(1)
 	}
+	if *flagPrintConfig {
+		if err := conf.printEffective(os.Stdout, *flagPrintConfigFormat); err != nil {
+			fmt.Printf("Couldn't print configuration: %s\n", err)
+			os.Exit(1)
+		}
+		return
+	}
 
 	if conf.ServerConfig.DebugMode {
 		log.SetLevel(log.DebugLevel)
 	}
 	log.SetOutput(conf.ServerConfig.MessageLogFd)
 
-	userAgent := fmt.Sprintf("getwtxt-ng/%s (+%s; @getwtxt-ng/registry-sync)", common.Version, conf.InstanceConfig.SiteURL)
-
-	dbConn, err := registry.InitSQLite(conf.ServerConfig.DatabasePath,
-		conf.ServerConfig.EntriesPerPageMax,
-		conf.ServerConfig.EntriesPerPageMin,
-		nil,
-		userAgent,
-		log.StandardLogger())
+	dbConn, err := openRegistryDB(conf)
 	if err != nil {
 		log.Errorf("Could not initialize database: %s", err)
 		os.Exit(1)
 	}
 
-	tickerExitChan := InitTicker(conf.ServerConfig.FetchInterval, dbConn)
-	signalWatcher(conf, tickerExitChan, log.StandardLogger())
-
 	r := mux.NewRouter()
 	setUpRoutes(r, conf, dbConn)
-	loggedHandler := handlers.CombinedLoggingHandler(conf.ServerConfig.RequestLogFd, r)
+
+	var bridges []bridge
+	var insertHooks []func(context.Context, []registry.Tweet)
+	if conf.ServerConfig.ActivityPubEnabled {
+		ap, err := newAPBridge(conf, dbConn)
+		if err != nil {
+			log.Errorf("Could not initialize ActivityPub bridge: %s", err)
+			os.Exit(1)
+		}
+		setUpActivityPubRoutes(r, ap)
+		bridges = append(bridges, ap)
+		insertHooks = append(insertHooks, ap.tweetsInserted)
+	}
+	if len(conf.ServerConfig.NostrRelays) > 0 {
+		nb := newNostrBridge(conf, dbConn)
+		setUpNostrRoutes(r, nb)
+		bridges = append(bridges, nb)
+		insertHooks = append(insertHooks, nb.tweetsInserted)
+	}
+	if conf.ServerConfig.HostedFeeds {
+		setUpHostedFeedRoutes(r, conf, dbConn)
+	}
+	if conf.PublicArchive.Path != "" {
+		setUpPublicArchiveRoutes(r, conf)
+	}
+	// With an admin listener, the admin API and profiles are served there rather than with the public API.
+	adminRouter := r
+	if conf.ServerConfig.AdminListen != "" {
+		adminRouter = mux.NewRouter()
+		setUpAdminRoutes(adminRouter, conf, dbConn)
+		setUpPprofRoutes(adminRouter)
+	}
+	maintenance := &maintenanceMode{}
+	setUpMaintenanceRoutes(adminRouter, conf, maintenance)
+	var userHooks []func(context.Context, []registry.User)
+	if len(conf.Federation.Peers) > 0 && conf.Federation.SharedSecret != "" {
+		fn := newFederationNotifier(conf, dbConn)
+		setUpFederationRoutes(r, conf, dbConn)
+		bridges = append(bridges, fn)
+		userHooks = append(userHooks, fn.usersInserted)
+		dbConn.Hooks.UsersApproved = fn.usersInserted
+	}
+	// Approval can be turned on by a reload, so registrations are always watched for.
+	an := newApprovalNotifier(conf)
+	bridges = append(bridges, an)
+	userHooks = append(userHooks, an.usersInserted)
+	dbConn.Hooks.UsersInserted = func(ctx context.Context, users []registry.User) {
+		for _, hook := range userHooks {
+			hook(ctx, users)
+		}
+	}
+	if len(insertHooks) > 0 {
+		dbConn.Hooks.TweetsInserted = func(ctx context.Context, tweets []registry.Tweet) {
+			for _, hook := range insertHooks {
+				hook(ctx, tweets)
+			}
+		}
+	}
+
+	tickerExitChans := []chan<- struct{}{InitTicker(conf.ServerConfig.FetchInterval, dbConn), InitStatsTicker(conf, dbConn), InitRetentionTicker(conf, dbConn), InitBlocklistTicker(conf, dbConn)}
+	if len(conf.Federation.Peers) > 0 {
+		tickerExitChans = append(tickerExitChans, InitFederationTicker(conf.Federation.Peers, conf.Federation.Interval, dbConn))
+	}
+	if conf.Federation.AnnounceURL != "" {
+		tickerExitChans = append(tickerExitChans, InitAnnounceTicker(conf, dbConn))
+	}
+	if conf.PublicArchive.Path != "" {
+		tickerExitChans = append(tickerExitChans, InitPublicArchiveTicker(conf, dbConn))
+	}
+	hosts, hostTickerExits, err := startRegistryHosts(conf)
+	if err != nil {
+		log.Errorf("Could not start additional registries: %s", err)
+		os.Exit(1)
+	}
+	tickerExitChans = append(tickerExitChans, hostTickerExits...)
+	signalWatcher(conf, dbConn, hosts, maintenance, bridges, tickerExitChans, log.StandardLogger())
+
+	hostHandlers := make(map[string]http.Handler, len(hosts))
+	hostAdminHandlers := make(map[string]http.Handler, len(hosts))
+	for _, h := range hosts {
+		hostHandlers[h.hostname] = h.handler
+		if h.adminHandler != nil {
+			hostAdminHandlers[h.hostname] = h.adminHandler
+		}
+	}
+	if conf.ServerConfig.AdminListen != "" {
+		if err := serveAdmin(conf, withRegistryHosts(hostAdminHandlers, adminRouter)); err != nil {
+			log.Errorf("Could not start admin listener: %s", err)
+			os.Exit(1)
+		}
+	}
+
+	cachedHandler := withRegistryHosts(hostHandlers, newResponseCache(dbConn, responseCacheTTL, responseCacheEntries).wrap(withRequestTimeout(conf, r)))
+	loggedHandler := handlers.CombinedLoggingHandler(conf.ServerConfig.RequestLogFd, withRecovery(withMaintenance(maintenance, cachedHandler)))
 
 	var handler http.Handler
 	if conf.ServerConfig.HTTPRequestsPerMinute > 0 {
@@ -81,14 +189,49 @@ func main() {
 	} else {
 		handler = loggedHandler
 	}
+	handler = withRequestID(withLocalization(conf, handler))
 
 	s := &http.Server{
 		Handler:      handler,
 		Addr:         fmt.Sprintf("%s:%s", conf.ServerConfig.IP, conf.ServerConfig.Port),
-		WriteTimeout: 30 * time.Second,
+		WriteTimeout: httpWriteTimeout,
 		ReadTimeout:  10 * time.Second,
 	}
 
 	err = s.ListenAndServe()
 	log.Infof("%s", err)
+	for _, b := range bridges {
+		b.Close()
+	}
+	if err := dbConn.Close(); err != nil {
+		log.Errorf("When closing database: %s", err)
+	}
+	for _, h := range hosts {
+		if err := h.dbConn.Close(); err != nil {
+			log.Errorf("When closing database of the registry at %s: %s", h.hostname, err)
+		}
+	}
+}
+
+// openRegistryDB opens the database a registry is configured with.
+func openRegistryDB(conf *Config) (*registry.DB, error) {
+	conf.mu.RLock()
+	defer conf.mu.RUnlock()
+	userAgent := fmt.Sprintf("getwtxt-ng/%s (+%s; @getwtxt-ng/registry-sync)", common.Version, conf.InstanceConfig.SiteURL)
+
+	dbConn, err := registry.Open(conf.ServerConfig.DatabasePath,
+		registry.WithPageLimits(conf.ServerConfig.EntriesPerPageMin, conf.ServerConfig.EntriesPerPageMax),
+		registry.WithUserAgent(userAgent),
+		registry.WithHTTPTuning(conf.ServerConfig.FetchTuning),
+		registry.WithQueryTimeout(conf.ServerConfig.QueryTimeout),
+		registry.WithReadCache(readCachePages, readCacheTTL),
+		registry.WithContentFilter(conf.ContentFilter.Filter),
+		registry.WithCollapseMirrors(conf.ServerConfig.CollapseMirrors),
+		registry.WithLogger(log.StandardLogger()))
+	if err != nil {
+		return nil, err
+	}
+	dbConn.Dedupe = conf.ServerConfig.DedupeMode
+
+	return dbConn, nil
 }
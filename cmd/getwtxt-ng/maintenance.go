@@ -0,0 +1,194 @@
+package main
+
+/*
+Copyright 2021 G. Benjamin Morrison
+
+This file is part of getwtxt-ng.
+
+getwtxt-ng is free software: you can redistribute it and/or modify
+it under the terms of the GNU Affero General Public License as
+published by the Free Software Foundation, either version 3 of the
+License, or (at your option) any later version.
+
+getwtxt-ng is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU Affero General Public License for more details.
+
+You should have received a copy of the GNU Affero General Public License
+along with getwtxt-ng.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+import (
+	"html/template"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+// Clients are asked to come back after five minutes unless the admin says otherwise.
+const defaultMaintenanceRetryAfter = 5 * time.Minute
+
+// maintenancePage is shown to browsers while the registry is in maintenance mode.
+var maintenancePage = template.Must(template.New("maintenance").Parse(`<!DOCTYPE HTML>
+<html lang="{{.Lang}}">
+<head>
+    <meta http-equiv="Content-Type" content="text/html; charset=utf-8"/>
+    <meta name="viewport" content="width=device-width, initial-scale=1.0"/>
+    <link rel="stylesheet" type="text/css" href="/css">
+    <title>{{.T "Down for maintenance"}}</title>
+</head>
+<body>
+<main>
+    <h2>{{.T "Down for maintenance"}}</h2>
+    <p>{{.Message}}</p>
+    <p>{{.T "Please check back in a few minutes."}}</p>
+</main>
+</body>
+</html>
+`))
+
+// maintenancePageData is what maintenancePage is executed with.
+type maintenancePageData struct {
+	Message string
+	*localizer
+}
+
+// maintenanceMode is whether the registry is turning away requests while backups or migrations run.
+type maintenanceMode struct {
+	mu         sync.RWMutex
+	enabled    bool
+	message    string
+	since      time.Time
+	retryAfter time.Duration
+}
+
+// maintenanceStatus is how maintenance mode is reported to the admin.
+type maintenanceStatus struct {
+	Enabled    bool       `json:"enabled"`
+	Message    string     `json:"message,omitempty"`
+	Since      *time.Time `json:"since,omitempty"`
+	RetryAfter int        `json:"retry_after,omitempty"`
+}
+
+// set turns maintenance mode on or off. A message and retry-after only matter while it's on.
+func (m *maintenanceMode) set(enabled bool, message string, retryAfter time.Duration) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.enabled = enabled
+	m.message = ""
+	m.since = time.Time{}
+	m.retryAfter = 0
+	if enabled {
+		m.message = message
+		m.since = time.Now().UTC()
+		m.retryAfter = retryAfter
+		if m.retryAfter <= 0 {
+			m.retryAfter = defaultMaintenanceRetryAfter
+		}
+	}
+}
+
+// toggle flips maintenance mode with the default message and retry-after. Returns whether it's now on.
+func (m *maintenanceMode) toggle() bool {
+	m.mu.RLock()
+	enabled := !m.enabled
+	m.mu.RUnlock()
+	m.set(enabled, "", 0)
+	return enabled
+}
+
+func (m *maintenanceMode) status() maintenanceStatus {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	st := maintenanceStatus{
+		Enabled:    m.enabled,
+		Message:    m.message,
+		RetryAfter: int(m.retryAfter.Seconds()),
+	}
+	if m.enabled {
+		since := m.since
+		st.Since = &since
+	}
+	return st
+}
+
+// withMaintenance answers requests with 503 and a Retry-After header while maintenance mode is on:
+// a friendly page for browsers and an error in the usual format for everything else. The admin API
+// and the stylesheet are still served, so maintenance mode can be turned off and the page looks right.
+func withMaintenance(m *maintenanceMode, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		st := m.status()
+		if !st.Enabled || strings.HasPrefix(r.URL.Path, "/api/admin/") || r.URL.Path == "/css" {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		w.Header().Set("Retry-After", strconv.Itoa(st.RetryAfter))
+		message := st.Message
+		if message == "" {
+			message = requestLocalizer(r).T("The registry is down for maintenance.")
+		}
+		if !strings.HasPrefix(r.URL.Path, "/api/") && strings.Contains(r.Header.Get("Accept"), "text/html") {
+			w.Header().Set("Content-Type", "text/html; charset=utf-8")
+			setContentLanguage(w, r)
+			w.WriteHeader(http.StatusServiceUnavailable)
+			if err := maintenancePage.Execute(w, maintenancePageData{Message: message, localizer: requestLocalizer(r)}); err != nil {
+				reqLog(r).Errorf("When writing maintenance page: %s", err)
+			}
+			return
+		}
+		errorWrite(w, r, errorFormat(r), http.StatusServiceUnavailable, requestLocalizer(r).T("Service Unavailable")+": "+message)
+	})
+}
+
+// setUpMaintenanceRoutes lets the admin check and change maintenance mode at /api/admin/maintenance.
+func setUpMaintenanceRoutes(r *mux.Router, conf *Config, m *maintenanceMode) {
+	r.HandleFunc("/api/admin/maintenance", func(w http.ResponseWriter, r *http.Request) {
+		maintenanceHandler(w, r, conf, m)
+	}).Methods(http.MethodGet, http.MethodHead, http.MethodPost)
+}
+
+// maintenanceHandler reports maintenance mode, or with POST, turns it on or off with enabled=true|false.
+// While turning it on, message is shown to visitors and retry_after, a duration like 30m, is how long
+// clients are asked to wait.
+func maintenanceHandler(w http.ResponseWriter, r *http.Request, conf *Config, m *maintenanceMode) {
+	if !adminAuthorized(w, r, conf) {
+		return
+	}
+
+	if r.Method == http.MethodPost {
+		_ = r.ParseForm()
+		enabled, err := strconv.ParseBool(r.Form.Get("enabled"))
+		if err != nil {
+			msg := fieldErrorResponse(FieldError{Field: "enabled", Code: fieldInvalid, Message: "Please provide true or false"})
+			errorResponseWrite(w, r, APIFormatJSON, http.StatusBadRequest, msg)
+			return
+		}
+		retryAfter := time.Duration(0)
+		if s := strings.TrimSpace(r.Form.Get("retry_after")); s != "" {
+			retryAfter, err = time.ParseDuration(s)
+			if err != nil || retryAfter < time.Second {
+				msg := fieldErrorResponse(FieldError{Field: "retry_after", Code: fieldInvalid, Message: "Please provide a duration of at least 1s, like 30m"})
+				errorResponseWrite(w, r, APIFormatJSON, http.StatusBadRequest, msg)
+				return
+			}
+		}
+		m.set(enabled, strings.TrimSpace(r.Form.Get("message")), retryAfter)
+		reqLog(r).Infof("Maintenance mode %s", onOff(enabled))
+	}
+
+	jsonResponseWrite(w, r, m.status(), http.StatusOK)
+}
+
+// onOff describes a toggle for the logs.
+func onOff(enabled bool) string {
+	if enabled {
+		return "on"
+	}
+	return "off"
+}
@@ -0,0 +1,111 @@
+package main
+
+/*
+Copyright 2021 G. Benjamin Morrison
+
+This file is part of getwtxt-ng.
+
+getwtxt-ng is free software: you can redistribute it and/or modify
+it under the terms of the GNU Affero General Public License as
+published by the Free Software Foundation, either version 3 of the
+License, or (at your option) any later version.
+
+getwtxt-ng is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU Affero General Public License for more details.
+
+You should have received a copy of the GNU Affero General Public License
+along with getwtxt-ng.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/gorilla/mux"
+
+	"github.com/gbmor/getwtxt-ng/common"
+)
+
+func TestMaintenanceMode(t *testing.T) {
+	hash, err := common.HashPass("hunter2")
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	conf := &Config{ServerConfig: ServerConfig{AdminPassword: string(hash)}}
+	m := &maintenanceMode{}
+	r := mux.NewRouter()
+	setUpMaintenanceRoutes(r, conf, m)
+	r.HandleFunc("/api/plain/tweets", func(w http.ResponseWriter, r *http.Request) {
+		plainResponseWrite(w, "tweets", http.StatusOK)
+	})
+	r.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+		plainResponseWrite(w, "index", http.StatusOK)
+	})
+	handler := withMaintenance(m, r)
+
+	serve := func(method, path string, form url.Values, header http.Header) *httptest.ResponseRecorder {
+		t.Helper()
+		req := httptest.NewRequest(method, path, strings.NewReader(form.Encode()))
+		if form != nil {
+			req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		}
+		for k, v := range header {
+			req.Header[k] = v
+		}
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, req)
+		return w
+	}
+	admin := http.Header{"X-Auth": []string{"hunter2"}}
+
+	if w := serve(http.MethodGet, "/api/plain/tweets", nil, nil); w.Code != http.StatusOK {
+		t.Errorf("Expected 200 outside maintenance mode, got %d", w.Code)
+	}
+	if w := serve(http.MethodPost, "/api/admin/maintenance", url.Values{"enabled": {"true"}}, nil); w.Code != http.StatusForbidden {
+		t.Errorf("Expected 403 without the admin password, got %d", w.Code)
+	}
+	if w := serve(http.MethodPost, "/api/admin/maintenance", url.Values{"enabled": {"maybe"}}, admin); w.Code != http.StatusBadRequest {
+		t.Errorf("Expected 400 with an invalid toggle, got %d", w.Code)
+	}
+
+	form := url.Values{"enabled": {"true"}, "message": {"Backing up <now>"}, "retry_after": {"10m"}}
+	w := serve(http.MethodPost, "/api/admin/maintenance", form, admin)
+	st := maintenanceStatus{}
+	if err := json.Unmarshal(w.Body.Bytes(), &st); err != nil {
+		t.Fatal(err.Error())
+	}
+	if w.Code != http.StatusOK || !st.Enabled || st.RetryAfter != 600 || st.Since == nil {
+		t.Fatalf("Expected maintenance mode on for 10 minutes, got %d: %s", w.Code, w.Body.String())
+	}
+
+	w = serve(http.MethodGet, "/api/plain/tweets", nil, nil)
+	if w.Code != http.StatusServiceUnavailable || w.Header().Get("Retry-After") != "600" {
+		t.Errorf("Expected 503 with Retry-After: 600, got %d with %q", w.Code, w.Header().Get("Retry-After"))
+	}
+	if !strings.Contains(w.Body.String(), "Backing up <now>") {
+		t.Errorf("Expected the message in the error, got %s", w.Body.String())
+	}
+	w = serve(http.MethodGet, "/", nil, http.Header{"Accept": []string{"text/html,application/xhtml+xml"}})
+	if w.Code != http.StatusServiceUnavailable || !strings.Contains(w.Header().Get("Content-Type"), "text/html") {
+		t.Errorf("Expected a 503 page for browsers, got %d with %q", w.Code, w.Header().Get("Content-Type"))
+	}
+	if !strings.Contains(w.Body.String(), "Backing up &lt;now&gt;") {
+		t.Errorf("Expected the escaped message on the page, got %s", w.Body.String())
+	}
+	if w := serve(http.MethodGet, "/api/admin/maintenance", nil, admin); w.Code != http.StatusOK {
+		t.Errorf("Expected the admin API to be served in maintenance mode, got %d", w.Code)
+	}
+
+	if m.toggle() {
+		t.Error("Expected toggling to turn maintenance mode off")
+	}
+	if w := serve(http.MethodGet, "/api/plain/tweets", nil, nil); w.Code != http.StatusOK {
+		t.Errorf("Expected 200 after maintenance mode is off, got %d", w.Code)
+	}
+}
@@ -0,0 +1,334 @@
+package main
+
+/*
+Copyright 2021 G. Benjamin Morrison
+
+This file is part of getwtxt-ng.
+
+getwtxt-ng is free software: you can redistribute it and/or modify
+it under the terms of the GNU Affero General Public License as
+published by the Free Software Foundation, either version 3 of the
+License, or (at your option) any later version.
+
+getwtxt-ng is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU Affero General Public License for more details.
+
+You should have received a copy of the GNU Affero General Public License
+along with getwtxt-ng.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+import (
+	"encoding/json"
+	"fmt"
+	"html"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+	log "github.com/sirupsen/logrus"
+
+	"github.com/gbmor/getwtxt-ng/registry"
+)
+
+// Mastodon's own limits on the number of statuses per request.
+const (
+	mastodonDefaultLimit = 20
+	mastodonMaxLimit     = 40
+)
+
+// The read-only subset of Mastodon's client API, so Mastodon apps can browse the registry.
+// Every twt is a public status and every feed is an account nobody can follow.
+
+type mastodonAccount struct {
+	ID             string        `json:"id"`
+	Username       string        `json:"username"`
+	Acct           string        `json:"acct"`
+	DisplayName    string        `json:"display_name"`
+	Locked         bool          `json:"locked"`
+	Bot            bool          `json:"bot"`
+	CreatedAt      string        `json:"created_at"`
+	Note           string        `json:"note"`
+	URL            string        `json:"url"`
+	Avatar         string        `json:"avatar"`
+	AvatarStatic   string        `json:"avatar_static"`
+	Header         string        `json:"header"`
+	HeaderStatic   string        `json:"header_static"`
+	FollowersCount int           `json:"followers_count"`
+	FollowingCount int           `json:"following_count"`
+	StatusesCount  int64         `json:"statuses_count"`
+	Emojis         []interface{} `json:"emojis"`
+	Fields         []interface{} `json:"fields"`
+}
+
+type mastodonTag struct {
+	Name string `json:"name"`
+	URL  string `json:"url"`
+}
+
+type mastodonStatus struct {
+	ID                 string          `json:"id"`
+	CreatedAt          string          `json:"created_at"`
+	InReplyToID        *string         `json:"in_reply_to_id"`
+	InReplyToAccountID *string         `json:"in_reply_to_account_id"`
+	Sensitive          bool            `json:"sensitive"`
+	SpoilerText        string          `json:"spoiler_text"`
+	Visibility         string          `json:"visibility"`
+	Language           *string         `json:"language"`
+	URI                string          `json:"uri"`
+	URL                string          `json:"url"`
+	RepliesCount       int             `json:"replies_count"`
+	ReblogsCount       int             `json:"reblogs_count"`
+	FavouritesCount    int             `json:"favourites_count"`
+	Content            string          `json:"content"`
+	Reblog             interface{}     `json:"reblog"`
+	Account            mastodonAccount `json:"account"`
+	MediaAttachments   []interface{}   `json:"media_attachments"`
+	Mentions           []interface{}   `json:"mentions"`
+	Tags               []mastodonTag   `json:"tags"`
+	Emojis             []interface{}   `json:"emojis"`
+	Card               interface{}     `json:"card"`
+	Poll               interface{}     `json:"poll"`
+}
+
+type mastodonError struct {
+	Error string `json:"error"`
+}
+
+func mastodonResponseWrite(w http.ResponseWriter, body interface{}, statusCode int) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(statusCode)
+	if err := json.NewEncoder(w).Encode(body); err != nil {
+		log.Error(err)
+	}
+}
+
+// mastodonTime formats times the way Mastodon does, with milliseconds.
+func mastodonTime(t time.Time) string {
+	return t.UTC().Format("2006-01-02T15:04:05.000Z")
+}
+
+func siteURL(conf *Config) string {
+	conf.mu.RLock()
+	defer conf.mu.RUnlock()
+	return strings.TrimSuffix(conf.InstanceConfig.SiteURL, "/")
+}
+
+func newMastodonAccount(u registry.User) mastodonAccount {
+	acct := u.Nick
+	if parsed, err := url.Parse(u.URL); err == nil && parsed.Host != "" {
+		acct = u.Nick + "@" + parsed.Host
+	}
+	note := ""
+	if u.Description != "" {
+		note = "<p>" + html.EscapeString(u.Description) + "</p>"
+	}
+	return mastodonAccount{
+		ID:           u.ID,
+		Username:     u.Nick,
+		Acct:         acct,
+		DisplayName:  u.Nick,
+		CreatedAt:    mastodonTime(u.DateTimeAdded),
+		Note:         note,
+		URL:          u.URL,
+		Avatar:       u.Avatar,
+		AvatarStatic: u.Avatar,
+		Emojis:       []interface{}{},
+		Fields:       []interface{}{},
+	}
+}
+
+func newMastodonStatus(base string, t registry.Tweet, account mastodonAccount) mastodonStatus {
+	tags := make([]mastodonTag, 0, len(t.Tags))
+	for _, tag := range t.Tags {
+		tags = append(tags, mastodonTag{Name: tag, URL: fmt.Sprintf("%s/api/plain/tags/%s", base, url.PathEscape(tag))})
+	}
+	return mastodonStatus{
+		ID:               t.ID,
+		CreatedAt:        mastodonTime(t.DateTime),
+		Visibility:       "public",
+		URI:              fmt.Sprintf("%s/api/v1/statuses/%s", base, t.ID),
+		URL:              t.URL,
+		Content:          "<p>" + html.EscapeString(t.Body) + "</p>",
+		Account:          account,
+		MediaAttachments: []interface{}{},
+		Mentions:         []interface{}{},
+		Tags:             tags,
+		Emojis:           []interface{}{},
+	}
+}
+
+// mastodonStatuses converts tweets into statuses, looking up their authors.
+func mastodonStatuses(r *http.Request, conf *Config, dbConn *registry.DB, tweets []registry.Tweet) ([]mastodonStatus, error) {
+	statuses := make([]mastodonStatus, 0, len(tweets))
+	if len(tweets) == 0 {
+		return statuses, nil
+	}
+
+	userIDs := make([]string, 0)
+	seen := make(map[string]bool)
+	for _, t := range tweets {
+		if !seen[t.UserID] {
+			seen[t.UserID] = true
+			userIDs = append(userIDs, t.UserID)
+		}
+	}
+	users, err := dbConn.GetUsersByID(r.Context(), userIDs)
+	if err != nil {
+		return nil, err
+	}
+	accounts := make(map[string]mastodonAccount, len(users))
+	for _, u := range users {
+		accounts[u.ID] = newMastodonAccount(u)
+	}
+
+	base := siteURL(conf)
+	for _, t := range tweets {
+		account, ok := accounts[t.UserID]
+		if !ok {
+			continue
+		}
+		statuses = append(statuses, newMastodonStatus(base, t, account))
+	}
+
+	return statuses, nil
+}
+
+// parseTimelineQuery reads Mastodon's pagination parameters. Invalid IDs are ignored, as Mastodon does.
+func parseTimelineQuery(r *http.Request) registry.TimelineQuery {
+	params := r.URL.Query()
+	id := func(name string) int64 {
+		n, _ := strconv.ParseInt(params.Get(name), 10, 64)
+		return n
+	}
+	limit, err := strconv.Atoi(params.Get("limit"))
+	if err != nil || limit < 1 {
+		limit = mastodonDefaultLimit
+	}
+	if limit > mastodonMaxLimit {
+		limit = mastodonMaxLimit
+	}
+
+	return registry.TimelineQuery{
+		MaxID:   id("max_id"),
+		SinceID: id("since_id"),
+		MinID:   id("min_id"),
+		Limit:   limit,
+	}
+}
+
+// setMastodonLinkHeader points clients to the next and previous pages of a timeline.
+func setMastodonLinkHeader(w http.ResponseWriter, r *http.Request, conf *Config, statuses []mastodonStatus) {
+	if len(statuses) == 0 {
+		return
+	}
+	pageURL := func(param, id string) string {
+		params := r.URL.Query()
+		params.Del("max_id")
+		params.Del("since_id")
+		params.Del("min_id")
+		params.Set(param, id)
+		return fmt.Sprintf("%s%s?%s", siteURL(conf), r.URL.Path, params.Encode())
+	}
+	w.Header().Set("Link", fmt.Sprintf(`<%s>; rel="next", <%s>; rel="prev"`,
+		pageURL("max_id", statuses[len(statuses)-1].ID), pageURL("min_id", statuses[0].ID)))
+}
+
+func mastodonTimelineResponse(w http.ResponseWriter, r *http.Request, conf *Config, dbConn *registry.DB, q registry.TimelineQuery) {
+	tweets, err := dbConn.GetTimeline(r.Context(), q)
+	if err != nil {
+		reqLog(r).Errorf("When retrieving timeline: %s", err)
+		mastodonResponseWrite(w, mastodonError{Error: "Internal Server Error"}, http.StatusInternalServerError)
+		return
+	}
+	statuses, err := mastodonStatuses(r, conf, dbConn, tweets)
+	if err != nil {
+		reqLog(r).Errorf("When retrieving authors of timeline: %s", err)
+		mastodonResponseWrite(w, mastodonError{Error: "Internal Server Error"}, http.StatusInternalServerError)
+		return
+	}
+
+	setMastodonLinkHeader(w, r, conf, statuses)
+	mastodonResponseWrite(w, statuses, http.StatusOK)
+}
+
+func mastodonPublicTimelineHandler(w http.ResponseWriter, r *http.Request, conf *Config, dbConn *registry.DB) {
+	mastodonTimelineResponse(w, r, conf, dbConn, parseTimelineQuery(r))
+}
+
+// mastodonAccountUser retrieves the account in the request's path, responding with an error if there isn't one.
+func mastodonAccountUser(w http.ResponseWriter, r *http.Request, dbConn *registry.DB) (*registry.User, bool) {
+	users, err := dbConn.GetUsersByID(r.Context(), []string{mux.Vars(r)["id"]})
+	if err != nil {
+		reqLog(r).Errorf("When retrieving account %s: %s", mux.Vars(r)["id"], err)
+		mastodonResponseWrite(w, mastodonError{Error: "Internal Server Error"}, http.StatusInternalServerError)
+		return nil, false
+	}
+	if len(users) == 0 {
+		mastodonResponseWrite(w, mastodonError{Error: "Record not found"}, http.StatusNotFound)
+		return nil, false
+	}
+
+	return &users[0], true
+}
+
+func mastodonAccountHandler(w http.ResponseWriter, r *http.Request, dbConn *registry.DB) {
+	user, ok := mastodonAccountUser(w, r, dbConn)
+	if !ok {
+		return
+	}
+
+	account := newMastodonAccount(*user)
+	count, err := dbConn.CountUserTweets(r.Context(), user.ID)
+	if err != nil {
+		reqLog(r).Errorf("When counting tweets of account %s: %s", user.ID, err)
+		mastodonResponseWrite(w, mastodonError{Error: "Internal Server Error"}, http.StatusInternalServerError)
+		return
+	}
+	account.StatusesCount = count
+
+	mastodonResponseWrite(w, account, http.StatusOK)
+}
+
+func mastodonAccountStatusesHandler(w http.ResponseWriter, r *http.Request, conf *Config, dbConn *registry.DB) {
+	user, ok := mastodonAccountUser(w, r, dbConn)
+	if !ok {
+		return
+	}
+
+	q := parseTimelineQuery(r)
+	q.UserID = user.ID
+	mastodonTimelineResponse(w, r, conf, dbConn, q)
+}
+
+func mastodonStatusHandler(w http.ResponseWriter, r *http.Request, conf *Config, dbConn *registry.DB) {
+	id := mux.Vars(r)["id"]
+	tweets, err := dbConn.GetTweetsByID(r.Context(), []string{id})
+	if err != nil {
+		reqLog(r).Errorf("When retrieving status %s: %s", id, err)
+		mastodonResponseWrite(w, mastodonError{Error: "Internal Server Error"}, http.StatusInternalServerError)
+		return
+	}
+	if len(tweets) != 1 || tweets[0].Hidden != registry.StatusVisible {
+		mastodonResponseWrite(w, mastodonError{Error: "Record not found"}, http.StatusNotFound)
+		return
+	}
+
+	statuses, err := mastodonStatuses(r, conf, dbConn, tweets)
+	if err != nil {
+		reqLog(r).Errorf("When retrieving author of status %s: %s", id, err)
+		mastodonResponseWrite(w, mastodonError{Error: "Internal Server Error"}, http.StatusInternalServerError)
+		return
+	}
+	// The author is no longer active.
+	if len(statuses) == 0 {
+		mastodonResponseWrite(w, mastodonError{Error: "Record not found"}, http.StatusNotFound)
+		return
+	}
+
+	mastodonResponseWrite(w, statuses[0], http.StatusOK)
+}
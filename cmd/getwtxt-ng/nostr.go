@@ -0,0 +1,103 @@
+package main
+
+/*
+Copyright 2021 G. Benjamin Morrison
+
+This file is part of getwtxt-ng.
+
+getwtxt-ng is free software: you can redistribute it and/or modify
+it under the terms of the GNU Affero General Public License as
+published by the Free Software Foundation, either version 3 of the
+License, or (at your option) any later version.
+
+getwtxt-ng is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU Affero General Public License for more details.
+
+You should have received a copy of the GNU Affero General Public License
+along with getwtxt-ng.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/gorilla/mux"
+	log "github.com/sirupsen/logrus"
+
+	"github.com/gbmor/getwtxt-ng/nostr"
+	"github.com/gbmor/getwtxt-ng/registry"
+)
+
+// Twts older than this when they're fetched are history rather than news, and aren't published.
+const nostrMaxAge = 24 * time.Hour
+
+// nostrBridge publishes new twts to Nostr relays, signed with a key derived for each feed.
+type nostrBridge struct {
+	dbConn    *registry.DB
+	publisher *nostr.Publisher
+}
+
+func newNostrBridge(conf *Config, dbConn *registry.DB) *nostrBridge {
+	conf.mu.RLock()
+	defer conf.mu.RUnlock()
+
+	return &nostrBridge{
+		dbConn:    dbConn,
+		publisher: nostr.NewPublisher(conf.ServerConfig.NostrRelays, conf.ServerConfig.NostrSecret, conf.InstanceConfig.SiteURL, log.StandardLogger()),
+	}
+}
+
+// Close waits for queued notes to be published. Nothing is published afterward.
+func (b *nostrBridge) Close() {
+	b.publisher.Close()
+}
+
+// tweetsInserted publishes recent visible tweets. It's used as the TweetsInserted hook.
+func (b *nostrBridge) tweetsInserted(_ context.Context, tweets []registry.Tweet) {
+	cutoff := time.Now().Add(-nostrMaxAge)
+	for _, t := range tweets {
+		if t.Hidden != registry.StatusVisible || t.URL == "" || t.DateTime.Before(cutoff) {
+			continue
+		}
+		b.publisher.Enqueue(nostr.Note{FeedURL: t.URL, Created: t.DateTime, Body: t.Body})
+	}
+}
+
+// nip05Response maps nicknames to the public keys their feeds publish with, as described by NIP-05.
+type nip05Response struct {
+	Names map[string]string `json:"names"`
+}
+
+func (b *nostrBridge) nip05Handler(w http.ResponseWriter, r *http.Request) {
+	nick := r.URL.Query().Get("name")
+	user, err := b.dbConn.GetUserByNick(r.Context(), nick)
+	if errors.Is(err, sql.ErrNoRows) {
+		errorWrite(w, r, APIFormatJSON, http.StatusNotFound, "")
+		return
+	}
+	if err != nil {
+		reqLog(r).Errorf("When retrieving user with nick %s: %s", nick, err)
+		errorWrite(w, r, APIFormatJSON, http.StatusInternalServerError, "")
+		return
+	}
+
+	pub, err := b.publisher.PublicKey(user.URL)
+	if err != nil {
+		reqLog(r).Errorf("When deriving nostr key for %s: %s", user.URL, err)
+		errorWrite(w, r, APIFormatJSON, http.StatusInternalServerError, "")
+		return
+	}
+
+	// Clients fetch this from web pages on other origins.
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+	apResponseWrite(w, "application/json", nip05Response{Names: map[string]string{nick: pub}}, http.StatusOK)
+}
+
+func setUpNostrRoutes(r *mux.Router, b *nostrBridge) {
+	r.HandleFunc("/.well-known/nostr.json", b.nip05Handler).Methods(http.MethodGet, http.MethodHead)
+}
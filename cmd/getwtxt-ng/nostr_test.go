@@ -0,0 +1,94 @@
+package main
+
+/*
+Copyright 2021 G. Benjamin Morrison
+
+This file is part of getwtxt-ng.
+
+getwtxt-ng is free software: you can redistribute it and/or modify
+it under the terms of the GNU Affero General Public License as
+published by the Free Software Foundation, either version 3 of the
+License, or (at your option) any later version.
+
+getwtxt-ng is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU Affero General Public License for more details.
+
+You should have received a copy of the GNU Affero General Public License
+along with getwtxt-ng.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+import (
+	"context"
+	"encoding/json"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"golang.org/x/net/websocket"
+
+	"github.com/gbmor/getwtxt-ng/nostr"
+	"github.com/gbmor/getwtxt-ng/registry"
+)
+
+func TestNostrBridge_TweetsInserted(t *testing.T) {
+	ctx := context.Background()
+	var mu sync.Mutex
+	published := make([]nostr.Event, 0)
+	relay := httptest.NewServer(websocket.Handler(func(conn *websocket.Conn) {
+		for {
+			msg := []json.RawMessage{}
+			if err := websocket.JSON.Receive(conn, &msg); err != nil {
+				return
+			}
+			ev := nostr.Event{}
+			if len(msg) != 2 || json.Unmarshal(msg[1], &ev) != nil {
+				return
+			}
+			mu.Lock()
+			published = append(published, ev)
+			mu.Unlock()
+			_ = websocket.JSON.Send(conn, []interface{}{"OK", ev.ID, true, ""})
+		}
+	}))
+	t.Cleanup(relay.Close)
+
+	conf := &Config{
+		ServerConfig:   ServerConfig{NostrRelays: []string{"ws" + strings.TrimPrefix(relay.URL, "http")}, NostrSecret: []byte("master")},
+		InstanceConfig: InstanceConfig{SiteURL: "http://localhost/"},
+	}
+	dbConn := getFederationDB(t)
+	b := newNostrBridge(conf, dbConn)
+	dbConn.Hooks.TweetsInserted = b.tweetsInserted
+
+	user := registry.User{Nick: "foo", URL: "https://foo.example/twtxt.txt", PasscodeHash: []byte("not a real hash"), DateTimeAdded: time.Now().UTC()}
+	if err := dbConn.InsertUser(ctx, &user); err != nil {
+		t.Fatal(err.Error())
+	}
+
+	// Tweets parsed from a feed carry only the ID of their author.
+	now := time.Now().UTC().Truncate(time.Second)
+	tweets := []registry.Tweet{
+		{UserID: user.ID, DateTime: now, Body: "hello nostr"},
+		{UserID: user.ID, DateTime: now.Add(-2 * nostrMaxAge), Body: "old news"},
+	}
+	if _, err := dbConn.InsertTweets(ctx, tweets); err != nil {
+		t.Fatal(err.Error())
+	}
+	b.Close()
+
+	pub, err := b.publisher.PublicKey(user.URL)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	if len(published) != 1 {
+		t.Fatalf("Expected only the recent tweet to be published, got %+v", published)
+	}
+	ev := published[0]
+	if ev.Content != "hello nostr" || ev.PubKey != pub || ev.CreatedAt != now.Unix() {
+		t.Errorf("Expected the tweet signed with its feed's key, got %+v", ev)
+	}
+}
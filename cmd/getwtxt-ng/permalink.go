@@ -0,0 +1,173 @@
+package main
+
+/*
+Copyright 2021 G. Benjamin Morrison
+
+This file is part of getwtxt-ng.
+
+getwtxt-ng is free software: you can redistribute it and/or modify
+it under the terms of the GNU Affero General Public License as
+published by the Free Software Foundation, either version 3 of the
+License, or (at your option) any later version.
+
+getwtxt-ng is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU Affero General Public License for more details.
+
+You should have received a copy of the GNU Affero General Public License
+along with getwtxt-ng.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+import (
+	"errors"
+	"fmt"
+	"html/template"
+	"io/fs"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+	log "github.com/sirupsen/logrus"
+
+	"github.com/gbmor/getwtxt-ng/registry"
+)
+
+// tweetSummaryRunes is how much of a tweet is used as its page's description, for link previews.
+const tweetSummaryRunes = 200
+
+// tweetPage is what the tweet template is executed with: the tweet, its author, and the rest of its thread,
+// oldest first, including the tweet itself.
+type tweetPage struct {
+	pageData
+	Tweet  registry.Tweet
+	Author registry.User
+	Thread []registry.Tweet
+
+	// Permalink is the page's own URL, and SourceURL points to the tweet's line in the author's feed.
+	Permalink string
+	SourceURL string
+	// AvatarURL is where the registry serves the author's avatar, or empty if they haven't declared one.
+	AvatarURL string
+	// Summary is the start of the tweet, and Published is when it was posted, as RFC 3339.
+	Summary   string
+	Published string
+}
+
+// parseTweetTemplate reads the tweet template from template_path_tweet, or from tweet.tmpl beside the index
+// template if that's empty. Configurations from before tweet pages existed won't have it, so when it isn't
+// configured and isn't there, the pages are left off rather than refusing to start.
+func parseTweetTemplate(sc ServerConfig) (*template.Template, error) {
+	path := sc.TemplatePathTweet
+	if strings.TrimSpace(path) == "" {
+		path = filepath.Join(filepath.Dir(sc.TemplatePathIndex), "tweet.tmpl")
+		if _, err := os.Stat(path); errors.Is(err, fs.ErrNotExist) {
+			log.Warnf("No tweet template at %s, so tweets won't have pages", path)
+			return nil, nil
+		}
+	}
+	tmpl, err := template.ParseFiles(path)
+	if err != nil {
+		return nil, fmt.Errorf("couldn't read tweet template at %s: %w", path, err)
+	}
+	return tmpl, nil
+}
+
+// tweetSourceURL links to the tweet's line in the feed, using a text fragment to highlight its timestamp
+// in browsers that support them.
+func tweetSourceURL(t registry.Tweet) string {
+	text := strings.NewReplacer("-", "%2D", "&", "%26", ",", "%2C").Replace(t.DateTime.Format(time.RFC3339))
+	return t.URL + "#:~:text=" + text
+}
+
+// tweetSummary shortens the tweet's body to the first tweetSummaryRunes runes, on one line.
+func tweetSummary(body string) string {
+	summary := []rune(strings.Join(strings.Fields(body), " "))
+	if len(summary) <= tweetSummaryRunes {
+		return string(summary)
+	}
+	return strings.TrimSpace(string(summary[:tweetSummaryRunes-1])) + "…"
+}
+
+// tweetPageHandler renders the page of a visible tweet, so each has a URL that can be shared.
+func tweetPageHandler(w http.ResponseWriter, r *http.Request, conf *Config, dbConn *registry.DB) {
+	ctx := r.Context()
+	id := mux.Vars(r)["id"]
+	tweets, err := dbConn.GetTweetsByID(ctx, []string{id})
+	if err != nil {
+		reqLog(r).Errorf("When retrieving tweet %s for its page: %s", id, err)
+		code, message := queryErrorStatus(r, err)
+		errorWrite(w, r, errorFormat(r), code, message)
+		return
+	}
+	if len(tweets) != 1 || tweets[0].Hidden != registry.StatusVisible {
+		errorWrite(w, r, errorFormat(r), http.StatusNotFound, "")
+		return
+	}
+	tweet := tweets[0]
+
+	// Only active users are returned, so this also leaves out tweets by users who were deleted or suspended.
+	users, err := dbConn.GetUsersByID(ctx, []string{tweet.UserID})
+	if err != nil {
+		reqLog(r).Errorf("When retrieving author of tweet %s for its page: %s", id, err)
+		code, message := queryErrorStatus(r, err)
+		errorWrite(w, r, errorFormat(r), code, message)
+		return
+	}
+	if len(users) == 0 {
+		errorWrite(w, r, errorFormat(r), http.StatusNotFound, "")
+		return
+	}
+	author := users[0]
+
+	var thread []registry.Tweet
+	root := tweet.Subject
+	if root == "" {
+		root = tweet.Hash
+	}
+	if root != "" {
+		thread, err = dbConn.GetConversation(ctx, root)
+		if err != nil {
+			// The tweet is still worth showing without the rest of its thread.
+			reqLog(r).Errorf("When retrieving conversation %s for the page of tweet %s: %s", root, id, err)
+			thread = nil
+		}
+		// A tweet with neither a parent nor any replies isn't much of a thread.
+		if len(thread) < 2 {
+			thread = nil
+		}
+	}
+
+	base := siteURL(conf)
+	data := tweetPage{
+		Tweet:     tweet,
+		Author:    author,
+		Thread:    thread,
+		Permalink: base + "/tweets/" + tweet.ID,
+		SourceURL: tweetSourceURL(tweet),
+		Summary:   tweetSummary(tweet.Body),
+		Published: tweet.DateTime.Format(time.RFC3339),
+	}
+	if author.Avatar != "" {
+		data.AvatarURL = base + "/avatars/" + author.ID
+	}
+
+	conf.mu.RLock()
+	tmpl := conf.Assets.TweetTemplate
+	data.pageData = pageData{InstanceConfig: conf.InstanceConfig, localizer: requestLocalizer(r)}
+	conf.mu.RUnlock()
+	if tmpl == nil {
+		errorWrite(w, r, errorFormat(r), http.StatusNotFound, "")
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/html")
+	setContentLanguage(w, r)
+	if err := tmpl.Execute(w, data); err != nil {
+		reqLog(r).Error(err)
+		errorWrite(w, r, errorFormat(r), http.StatusInternalServerError, "")
+	}
+}
@@ -0,0 +1,133 @@
+package main
+
+/*
+Copyright 2021 G. Benjamin Morrison
+
+This file is part of getwtxt-ng.
+
+getwtxt-ng is free software: you can redistribute it and/or modify
+it under the terms of the GNU Affero General Public License as
+published by the Free Software Foundation, either version 3 of the
+License, or (at your option) any later version.
+
+getwtxt-ng is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU Affero General Public License for more details.
+
+You should have received a copy of the GNU Affero General Public License
+along with getwtxt-ng.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+import (
+	"context"
+	"html/template"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/mux"
+
+	"github.com/gbmor/getwtxt-ng/registry"
+)
+
+func TestTweetPageHandler(t *testing.T) {
+	ctx := context.Background()
+	dbConn := getFederationDB(t)
+
+	rootTime := time.Date(2022, 1, 1, 12, 0, 0, 0, time.UTC)
+	foo := registry.User{Nick: "foo", URL: "https://foo.example/twtxt.txt", PasscodeHash: []byte("hash")}
+	if _, err := dbConn.InsertUserWithTweets(ctx, &foo, []registry.Tweet{
+		{DateTime: rootTime, Body: "Is anyone <b>out</b> there?"},
+		{DateTime: rootTime.Add(time.Hour), Body: "secret"},
+	}); err != nil {
+		t.Fatal(err.Error())
+	}
+	if err := dbConn.ToggleTweetHiddenStatus(ctx, foo.ID, rootTime.Add(time.Hour), registry.StatusHidden); err != nil {
+		t.Fatal(err.Error())
+	}
+	rootHash := registry.TwtHash(foo.URL, rootTime, "Is anyone <b>out</b> there?")
+	bar := registry.User{Nick: "bar", URL: "https://bar.example/twtxt.txt", PasscodeHash: []byte("hash")}
+	if _, err := dbConn.InsertUserWithTweets(ctx, &bar, []registry.Tweet{
+		{DateTime: rootTime.Add(2 * time.Hour), Body: "(#" + rootHash + ") I am!"},
+	}); err != nil {
+		t.Fatal(err.Error())
+	}
+	if err := dbConn.SetFeedMetadata(ctx, foo.ID, registry.FeedMetadata{Avatar: "https://foo.example/avatar.png"}); err != nil {
+		t.Fatal(err.Error())
+	}
+
+	ids := make(map[string]string)
+	for _, status := range []registry.TweetVisibilityStatus{registry.StatusVisible, registry.StatusHidden} {
+		tweets, err := dbConn.GetTweets(ctx, 1, 20, status)
+		if err != nil {
+			t.Fatal(err.Error())
+		}
+		for _, tw := range tweets {
+			ids[tw.Body] = tw.ID
+		}
+	}
+
+	tmpl, err := template.ParseFiles("../../assets/tweet.tmpl")
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	conf := &Config{
+		InstanceConfig: InstanceConfig{SiteName: "Test Registry", SiteURL: "https://registry.example/"},
+		Assets:         Assets{TweetTemplate: tmpl},
+	}
+	r := mux.NewRouter()
+	setUpRoutes(r, conf, dbConn)
+	serve := func(id string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, "/tweets/"+id, nil)
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		return w
+	}
+
+	w := serve(ids["Is anyone <b>out</b> there?"])
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected %d, got %d: %s", http.StatusOK, w.Code, w.Body.String())
+	}
+	page := w.Body.String()
+	wants := []string{
+		`<meta property="og:title" content="@foo">`,
+		`<meta property="og:description" content="Is anyone &lt;b&gt;out&lt;/b&gt; there?">`,
+		`<meta property="og:url" content="https://registry.example/tweets/` + ids["Is anyone <b>out</b> there?"] + `">`,
+		`<meta property="og:image" content="https://registry.example/avatars/` + foo.ID + `">`,
+		`<link rel="canonical" href="https://registry.example/tweets/` + ids["Is anyone <b>out</b> there?"] + `">`,
+		`https://foo.example/twtxt.txt#:~:text=2022%2D01%2D01T12:00:00Z`,
+		`<a href="/tweets/` + ids["(#"+rootHash+") I am!"] + `">@bar</a>`,
+	}
+	for _, want := range wants {
+		if !strings.Contains(page, want) {
+			t.Errorf("Expected the page to contain %s, got:\n%s", want, page)
+		}
+	}
+	if strings.Contains(page, "<b>out</b>") {
+		t.Error("Expected the tweet's body to be escaped")
+	}
+
+	if w := serve(ids["(#"+rootHash+") I am!"]); w.Code != http.StatusOK || !strings.Contains(w.Body.String(), "@foo</a>") {
+		t.Errorf("Expected the reply's page to link to the tweet it answers, got %d", w.Code)
+	}
+	if w := serve(ids["secret"]); w.Code != http.StatusNotFound {
+		t.Errorf("Expected %d for a hidden tweet, got %d", http.StatusNotFound, w.Code)
+	}
+	if w := serve("9999"); w.Code != http.StatusNotFound {
+		t.Errorf("Expected %d for an unknown tweet, got %d", http.StatusNotFound, w.Code)
+	}
+}
+
+func TestTweetSummary(t *testing.T) {
+	if got := tweetSummary("  one\n two  "); got != "one two" {
+		t.Errorf("Expected whitespace to be collapsed, got %q", got)
+	}
+	long := strings.Repeat("é", tweetSummaryRunes+10)
+	got := []rune(tweetSummary(long))
+	if len(got) != tweetSummaryRunes || got[len(got)-1] != '…' {
+		t.Errorf("Expected a summary of %d runes ending in an ellipsis, got %d", tweetSummaryRunes, len(got))
+	}
+}
@@ -0,0 +1,103 @@
+package main
+
+/*
+Copyright 2021 G. Benjamin Morrison
+
+This file is part of getwtxt-ng.
+
+getwtxt-ng is free software: you can redistribute it and/or modify
+it under the terms of the GNU Affero General Public License as
+published by the Free Software Foundation, either version 3 of the
+License, or (at your option) any later version.
+
+getwtxt-ng is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU Affero General Public License for more details.
+
+You should have received a copy of the GNU Affero General Public License
+along with getwtxt-ng.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+	texttemplate "text/template"
+	"time"
+)
+
+// The responses to a registration, unless template_path_registration_plain or template_path_registration_json
+// point to templates of their own.
+var (
+	defaultRegistrationPlain = texttemplate.Must(texttemplate.New("registration_plain").Parse(
+		"You have been added! Your user's generated passcode is: {{.Passcode}}\n" +
+			"{{if .FetchFailed}}However, we were unable to fetch your twtxt file.{{else}}{{.TweetsAdded}} new twts ingested.\n{{end}}" +
+			"{{if .PendingApproval}}{{if .FetchFailed}}\n{{end}}Your feed will be listed once an administrator approves it.\n{{end}}"))
+	defaultRegistrationJSON = texttemplate.Must(texttemplate.New("registration_json").Parse(
+		"You have been added and your passcode has been generated." +
+			"{{if .FetchFailed}} However, we were unable to fetch your twtxt file at {{.URL}}. " +
+			"Another attempt will be made at the next sync interval (every {{.SyncInterval}}){{end}}" +
+			"{{if .PendingApproval}} Your feed will be listed once an administrator approves it.{{end}}"))
+)
+
+// registrationData is what the registration templates are executed with. Along with the new user and
+// the instance's details, T translates text into the language negotiated for the request, as in
+// {{.T "Welcome"}}, and Lang is that language.
+type registrationData struct {
+	Nick            string
+	URL             string
+	Passcode        string
+	TweetsAdded     int
+	FetchFailed     bool
+	PendingApproval bool
+	SiteName        string
+	SiteURL         string
+	DocsURL         string
+	SyncInterval    time.Duration
+	*localizer
+}
+
+// parseRegistrationTemplate reads the registration template at path, or returns def if no path is set.
+func parseRegistrationTemplate(path string, def *texttemplate.Template) (*texttemplate.Template, error) {
+	if strings.TrimSpace(path) == "" {
+		return def, nil
+	}
+	tmpl, err := texttemplate.ParseFiles(path)
+	if err != nil {
+		return nil, fmt.Errorf("couldn't read registration template at %s: %w", path, err)
+	}
+	return tmpl, nil
+}
+
+// registrationMessage renders the response to a registration for the format it was made in: the whole
+// body for plain, and the message for JSON. data needs only the user's details; the rest are filled in.
+// If the configured template fails, the default is used instead.
+func registrationMessage(r *http.Request, conf *Config, format APIFormat, data registrationData) string {
+	conf.mu.RLock()
+	tmpl := conf.Assets.RegistrationPlainTemplate
+	def := defaultRegistrationPlain
+	docs := "/docs/plain.html"
+	if format == APIFormatJSON {
+		tmpl = conf.Assets.RegistrationJSONTemplate
+		def = defaultRegistrationJSON
+		docs = "/docs/json.html"
+	}
+	data.SiteName = conf.InstanceConfig.SiteName
+	data.SiteURL = conf.InstanceConfig.SiteURL
+	data.SyncInterval = conf.ServerConfig.FetchInterval
+	conf.mu.RUnlock()
+	data.DocsURL = strings.TrimSuffix(data.SiteURL, "/") + docs
+	data.localizer = requestLocalizer(r)
+
+	if tmpl == nil {
+		tmpl = def
+	}
+	out := strings.Builder{}
+	if err := tmpl.Execute(&out, data); err != nil {
+		reqLog(r).Errorf("Couldn't render registration template %s, using the default: %s", tmpl.Name(), err)
+		out.Reset()
+		_ = def.Execute(&out, data)
+	}
+	return out.String()
+}
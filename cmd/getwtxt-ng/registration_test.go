@@ -0,0 +1,78 @@
+package main
+
+/*
+Copyright 2021 G. Benjamin Morrison
+
+This file is part of getwtxt-ng.
+
+getwtxt-ng is free software: you can redistribute it and/or modify
+it under the terms of the GNU Affero General Public License as
+published by the Free Software Foundation, either version 3 of the
+License, or (at your option) any later version.
+
+getwtxt-ng is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU Affero General Public License for more details.
+
+You should have received a copy of the GNU Affero General Public License
+along with getwtxt-ng.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestRegistrationMessage(t *testing.T) {
+	conf := &Config{InstanceConfig: InstanceConfig{SiteName: "Test Registry", SiteURL: "https://registry.example/"}}
+	conf.ServerConfig.FetchInterval = time.Hour
+	r := httptest.NewRequest(http.MethodPost, "/api/plain/users", nil)
+	data := registrationData{Nick: "foo", URL: "https://foo.example/twtxt.txt", Passcode: "d34db33f", TweetsAdded: 3}
+
+	got := registrationMessage(r, conf, APIFormatPlain, data)
+	if want := "You have been added! Your user's generated passcode is: d34db33f\n3 new twts ingested.\n"; got != want {
+		t.Errorf("Expected the default plain response %q, got %q", want, got)
+	}
+	failed := data
+	failed.FetchFailed = true
+	got = registrationMessage(r, conf, APIFormatJSON, failed)
+	if want := "You have been added and your passcode has been generated. However, we were unable to fetch your twtxt file at https://foo.example/twtxt.txt. Another attempt will be made at the next sync interval (every 1h0m0s)"; got != want {
+		t.Errorf("Expected the default JSON message %q, got %q", want, got)
+	}
+
+	dir := t.TempDir()
+	path := filepath.Join(dir, "registration.tmpl")
+	if err := os.WriteFile(path, []byte(`Welcome to {{.SiteName}}, {{.Nick}} ({{.Lang}}). Keep {{.Passcode}} safe. See {{.DocsURL}}`), 0600); err != nil {
+		t.Fatal(err.Error())
+	}
+	tmpl, err := parseRegistrationTemplate(path, defaultRegistrationPlain)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	conf.Assets.RegistrationPlainTemplate = tmpl
+	got = registrationMessage(r, conf, APIFormatPlain, data)
+	if want := "Welcome to Test Registry, foo (en). Keep d34db33f safe. See https://registry.example/docs/plain.html"; got != want {
+		t.Errorf("Expected the configured template to be used, wanted %q, got %q", want, got)
+	}
+
+	broken := filepath.Join(dir, "broken.tmpl")
+	if err := os.WriteFile(broken, []byte(`{{.NoSuchField}}`), 0600); err != nil {
+		t.Fatal(err.Error())
+	}
+	if conf.Assets.RegistrationPlainTemplate, err = parseRegistrationTemplate(broken, defaultRegistrationPlain); err != nil {
+		t.Fatal(err.Error())
+	}
+	got = registrationMessage(r, conf, APIFormatPlain, data)
+	if want := "You have been added! Your user's generated passcode is: d34db33f\n3 new twts ingested.\n"; got != want {
+		t.Errorf("Expected a template that fails to fall back to the default, got %q", got)
+	}
+
+	if _, err := parseRegistrationTemplate(filepath.Join(dir, "missing.tmpl"), defaultRegistrationPlain); err == nil {
+		t.Error("Expected an error for a template that doesn't exist")
+	}
+}
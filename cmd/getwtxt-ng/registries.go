@@ -0,0 +1,151 @@
+package main
+
+/*
+Copyright 2021 G. Benjamin Morrison
+
+This file is part of getwtxt-ng.
+
+getwtxt-ng is free software: you can redistribute it and/or modify
+it under the terms of the GNU Affero General Public License as
+published by the Free Software Foundation, either version 3 of the
+License, or (at your option) any later version.
+
+getwtxt-ng is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU Affero General Public License for more details.
+
+You should have received a copy of the GNU Affero General Public License
+along with getwtxt-ng.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+
+	"github.com/gbmor/getwtxt-ng/registry"
+)
+
+// Registries that turned down an announcement are asked again after this long.
+const registryAnnounceRetry = 24 * time.Hour
+
+// registryAnnouncer registers a feed representing this registry with the registries that feeds here declare.
+type registryAnnouncer struct {
+	dbConn  *registry.DB
+	client  *http.Client
+	nick    string
+	feedURL string
+	siteURL string
+}
+
+// InitAnnounceTicker announces this registry to newly discovered registries in the background, then again every interval.
+func InitAnnounceTicker(conf *Config, dbConn *registry.DB) chan<- struct{} {
+	conf.mu.RLock()
+	a := &registryAnnouncer{
+		dbConn:  dbConn,
+		client:  &http.Client{Timeout: 10 * time.Second},
+		nick:    conf.Federation.AnnounceNick,
+		feedURL: conf.Federation.AnnounceURL,
+		siteURL: strings.TrimSuffix(conf.InstanceConfig.SiteURL, "/"),
+	}
+	tick := time.NewTicker(conf.Federation.Interval)
+	conf.mu.RUnlock()
+	done := make(chan struct{})
+
+	go func() {
+		a.announceAll()
+		for {
+			select {
+			case <-done:
+				tick.Stop()
+				return
+			case <-tick.C:
+				a.announceAll()
+			}
+		}
+	}()
+
+	return done
+}
+
+// announceAll announces this registry to each known registry it hasn't been announced to yet.
+func (a *registryAnnouncer) announceAll() {
+	ctx := context.Background()
+	registries, err := a.dbConn.GetUnannouncedRegistries(ctx, registryAnnounceRetry)
+	if err != nil {
+		log.Errorf("Couldn't get registries to announce to: %s", err)
+		return
+	}
+
+	for _, reg := range registries {
+		if a.siteURL != "" && strings.TrimSuffix(reg.URL, "/") == a.siteURL {
+			continue
+		}
+		announceErr := a.announce(ctx, reg.URL)
+		if announceErr != nil {
+			log.Infof("Couldn't announce this registry to %s: %s", reg.URL, announceErr)
+		} else {
+			log.Infof("Announced this registry to %s", reg.URL)
+		}
+		if err := a.dbConn.RecordRegistryAnnouncement(ctx, reg.URL, announceErr); err != nil {
+			log.Errorf("%s", err)
+		}
+	}
+}
+
+// announce registers the announce feed with the registry at registryURL the way any user would.
+func (a *registryAnnouncer) announce(ctx context.Context, registryURL string) error {
+	form := url.Values{}
+	form.Set("nickname", a.nick)
+	form.Set("url", a.feedURL)
+	target := strings.TrimSuffix(registryURL, "/") + "/api/plain/users?" + form.Encode()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, target, strings.NewReader(form.Encode()))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+	resp, err := a.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		_ = resp.Body.Close()
+	}()
+	_, _ = io.Copy(io.Discard, io.LimitReader(resp.Body, 1<<16))
+
+	switch resp.StatusCode {
+	case http.StatusOK, http.StatusCreated, http.StatusConflict:
+		// Already being listed there counts as announced.
+		return nil
+	default:
+		return fmt.Errorf("registry responded %s", resp.Status)
+	}
+}
+
+func getRegistriesHandler(w http.ResponseWriter, r *http.Request, conf *Config, dbConn *registry.DB, format APIFormat) {
+	registries, err := dbConn.GetKnownRegistries(r.Context())
+	if err != nil {
+		reqLog(r).Errorf("When retrieving known registries: %s", err)
+		code, message := queryErrorStatus(r, err)
+		msg := MessageResponse{
+			Message: message,
+		}
+		errorResponseWrite(w, r, format, code, msg)
+		return
+	}
+
+	if format == APIFormatPlain {
+		plainResponseWrite(w, registry.FormatRegistriesPlainWith(registries, plainFormat(conf)), http.StatusOK)
+	} else if format == APIFormatJSON {
+		jsonResponseWrite(w, r, registries, http.StatusOK)
+	}
+}
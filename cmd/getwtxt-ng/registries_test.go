@@ -0,0 +1,73 @@
+package main
+
+/*
+Copyright 2021 G. Benjamin Morrison
+
+This file is part of getwtxt-ng.
+
+getwtxt-ng is free software: you can redistribute it and/or modify
+it under the terms of the GNU Affero General Public License as
+published by the Free Software Foundation, either version 3 of the
+License, or (at your option) any later version.
+
+getwtxt-ng is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU Affero General Public License for more details.
+
+You should have received a copy of the GNU Affero General Public License
+along with getwtxt-ng.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestRegistryAnnouncer_announce(t *testing.T) {
+	var gotNick, gotURL string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost || r.URL.Path != "/api/plain/users" {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		_ = r.ParseForm()
+		gotNick = r.Form.Get("nickname")
+		gotURL = r.Form.Get("url")
+		if gotNick == "taken" {
+			w.WriteHeader(http.StatusConflict)
+			return
+		}
+		if gotNick == "" {
+			w.WriteHeader(http.StatusBadRequest)
+		}
+	}))
+	t.Cleanup(srv.Close)
+
+	a := &registryAnnouncer{
+		client:  srv.Client(),
+		nick:    "registry",
+		feedURL: "https://a.example/twtxt.txt",
+	}
+	if err := a.announce(context.Background(), srv.URL+"/"); err != nil {
+		t.Fatal(err.Error())
+	}
+	if gotNick != a.nick || gotURL != a.feedURL {
+		t.Errorf("Expected %s %s to be registered, got %s %s", a.nick, a.feedURL, gotNick, gotURL)
+	}
+
+	a.nick = "taken"
+	if err := a.announce(context.Background(), srv.URL); err != nil {
+		t.Errorf("Expected an existing listing to count as announced, got: %s", err)
+	}
+
+	a.nick = ""
+	if err := a.announce(context.Background(), srv.URL); err == nil {
+		t.Error("Expected an error when the registry refuses")
+	}
+	if err := a.announce(context.Background(), srv.URL+"/missing"); err == nil {
+		t.Error("Expected an error when the registry 404s")
+	}
+}
@@ -0,0 +1,70 @@
+package main
+
+/*
+Copyright 2021 G. Benjamin Morrison
+
+This file is part of getwtxt-ng.
+
+getwtxt-ng is free software: you can redistribute it and/or modify
+it under the terms of the GNU Affero General Public License as
+published by the Free Software Foundation, either version 3 of the
+License, or (at your option) any later version.
+
+getwtxt-ng is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU Affero General Public License for more details.
+
+You should have received a copy of the GNU Affero General Public License
+along with getwtxt-ng.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"net/http"
+	"regexp"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// requestIDKey is the context key of the ID given to a request by withRequestID.
+type requestIDKey struct{}
+
+// regexRequestID matches request IDs clients may choose for themselves.
+var regexRequestID = regexp.MustCompile(`^[\w.-]{1,64}$`)
+
+// withRequestID gives each request an ID, returned in the X-Request-ID header and logged with
+// the messages about it, so a report of an error can be matched to what the server saw.
+// A client's own X-Request-ID is used if it's reasonable, otherwise one is made up.
+func withRequestID(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id := requestID(r)
+		w.Header().Set("X-Request-ID", id)
+		next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), requestIDKey{}, id)))
+	})
+}
+
+// requestID returns the ID withRequestID gave the request, or makes one for requests it didn't see.
+func requestID(r *http.Request) string {
+	if id, ok := r.Context().Value(requestIDKey{}).(string); ok {
+		return id
+	}
+	if id := r.Header.Get("X-Request-ID"); regexRequestID.MatchString(id) {
+		return id
+	}
+	return newRandomID()
+}
+
+// newRandomID makes an ID unlikely to be made again, for requests and jobs.
+func newRandomID() string {
+	id := make([]byte, 8)
+	_, _ = rand.Read(id)
+	return hex.EncodeToString(id)
+}
+
+// reqLog is the logger for messages about a request, which carry its ID.
+func reqLog(r *http.Request) *log.Entry {
+	return log.WithField("request_id", requestID(r))
+}
@@ -0,0 +1,171 @@
+package main
+
+/*
+Copyright 2021 G. Benjamin Morrison
+
+This file is part of getwtxt-ng.
+
+getwtxt-ng is free software: you can redistribute it and/or modify
+it under the terms of the GNU Affero General Public License as
+published by the Free Software Foundation, either version 3 of the
+License, or (at your option) any later version.
+
+getwtxt-ng is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU Affero General Public License for more details.
+
+You should have received a copy of the GNU Affero General Public License
+along with getwtxt-ng.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+
+	"github.com/gbmor/getwtxt-ng/common"
+	"github.com/gbmor/getwtxt-ng/registry"
+)
+
+// The retention policy is checked hourly unless configured otherwise, and tweets are deleted
+// a batch at a time so fetches and requests aren't held up for long.
+const (
+	defaultRetentionInterval = "1h"
+	minRetentionInterval     = time.Minute
+	retentionDeleteBatchSize = 500
+)
+
+// parse validates the retention limits and interval.
+func (r *Retention) parse() error {
+	r.MaxTweetAge = 0
+	if strings.TrimSpace(r.MaxTweetAgeStr) != "" {
+		age, err := common.ParseDuration(r.MaxTweetAgeStr)
+		if err != nil {
+			return fmt.Errorf("when parsing retention max_tweet_age: %w", err)
+		}
+		if age <= 0 {
+			return errors.New("retention max_tweet_age must be positive")
+		}
+		r.MaxTweetAge = age
+	}
+	if r.MaxTweetsPerUser < 0 {
+		return errors.New("retention max_tweets_per_user can't be negative")
+	}
+	if r.PurgeHiddenAfterDays < 0 {
+		return errors.New("retention purge_hidden_after_days can't be negative")
+	}
+
+	if strings.TrimSpace(r.IntervalStr) == "" {
+		r.IntervalStr = defaultRetentionInterval
+	}
+	interval, err := time.ParseDuration(r.IntervalStr)
+	if err != nil {
+		return fmt.Errorf("when parsing retention interval: %w", err)
+	}
+	if interval < minRetentionInterval {
+		return fmt.Errorf("retention interval can't be less than %s", minRetentionInterval)
+	}
+	r.Interval = interval
+
+	return nil
+}
+
+// policy returns the limits in the form the registry enforces them.
+func (r *Retention) policy() registry.RetentionPolicy {
+	return registry.RetentionPolicy{
+		MaxTweetAge:      r.MaxTweetAge,
+		MaxTweetsPerUser: r.MaxTweetsPerUser,
+		PurgeHiddenAfter: time.Duration(r.PurgeHiddenAfterDays) * 24 * time.Hour,
+	}
+}
+
+// retentionJob enforces the configured retention policy. The first run after the policy is enabled or
+// changed, including the first after starting, only logs what would be deleted, so a mistaken limit
+// can be caught before anything's lost.
+type retentionJob struct {
+	conf   *Config
+	dbConn *registry.DB
+	last   registry.RetentionPolicy
+}
+
+// InitRetentionTicker runs the retention job in the background, then again every interval.
+func InitRetentionTicker(conf *Config, dbConn *registry.DB) chan<- struct{} {
+	conf.mu.RLock()
+	interval := conf.Retention.Interval
+	conf.mu.RUnlock()
+	tick := time.NewTicker(interval)
+	done := make(chan struct{})
+	job := &retentionJob{conf: conf, dbConn: dbConn}
+
+	go func() {
+		job.run(context.Background())
+		job.deletePendingUsers(context.Background())
+		for {
+			select {
+			case <-done:
+				tick.Stop()
+				return
+			case <-tick.C:
+				job.run(context.Background())
+				job.deletePendingUsers(context.Background())
+			}
+		}
+	}()
+
+	return done
+}
+
+// run enforces the policy, or dry-runs it if it's new. Returns what was deleted, or would have been.
+func (j *retentionJob) run(ctx context.Context) registry.RetentionResult {
+	j.conf.mu.RLock()
+	policy := j.conf.Retention.policy()
+	interval := j.conf.Retention.Interval
+	j.conf.mu.RUnlock()
+
+	if !policy.Enabled() {
+		j.last = policy
+		return registry.RetentionResult{}
+	}
+
+	now := time.Now().UTC()
+	if policy != j.last {
+		res, err := j.dbConn.CountRetention(ctx, policy, now)
+		if err != nil {
+			log.Errorf("Couldn't dry-run retention policy: %s", err)
+			return res
+		}
+		j.last = policy
+		log.Infof("Retention policy enabled or changed. Dry run: would delete %d aged, %d hidden, and %d excess tweets. Deleting from the next run, in %s",
+			res.Aged, res.Hidden, res.Excess, interval)
+		return res
+	}
+
+	res, err := j.dbConn.EnforceRetention(ctx, policy, now, retentionDeleteBatchSize)
+	if err != nil {
+		log.Errorf("Couldn't enforce retention policy, after deleting %d tweets: %s", res.Total(), err)
+		return res
+	}
+	if res.Total() > 0 {
+		log.Infof("Retention policy deleted %d aged, %d hidden, and %d excess tweets", res.Aged, res.Hidden, res.Excess)
+	}
+
+	return res
+}
+
+// deletePendingUsers removes the users who deleted themselves and whose grace period has passed.
+// It runs whether or not a retention policy is enabled.
+func (j *retentionJob) deletePendingUsers(ctx context.Context) {
+	urls, tweets, err := j.dbConn.DeletePendingUsers(ctx, time.Now().UTC())
+	if err != nil {
+		log.Errorf("Couldn't delete users pending deletion: %s", err)
+		return
+	}
+	if len(urls) > 0 {
+		log.Infof("Deleted %d users whose deletion grace period passed, and their %d tweets: %s", len(urls), tweets, strings.Join(urls, ", "))
+	}
+}
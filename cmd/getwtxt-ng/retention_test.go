@@ -0,0 +1,78 @@
+package main
+
+/*
+Copyright 2021 G. Benjamin Morrison
+
+This file is part of getwtxt-ng.
+
+getwtxt-ng is free software: you can redistribute it and/or modify
+it under the terms of the GNU Affero General Public License as
+published by the Free Software Foundation, either version 3 of the
+License, or (at your option) any later version.
+
+getwtxt-ng is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU Affero General Public License for more details.
+
+You should have received a copy of the GNU Affero General Public License
+along with getwtxt-ng.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/gbmor/getwtxt-ng/registry"
+)
+
+func TestRetentionJob(t *testing.T) {
+	ctx := context.Background()
+	dbConn := getFederationDB(t)
+	u := registry.User{Nick: "foo", URL: "https://foo.example/twtxt.txt", PasscodeHash: []byte("hash")}
+	now := time.Now().UTC()
+	tweets := []registry.Tweet{
+		{DateTime: now.AddDate(-3, 0, 0), Body: "old"},
+		{DateTime: now.Add(-time.Hour), Body: "new"},
+	}
+	if _, err := dbConn.InsertUserWithTweets(ctx, &u, tweets); err != nil {
+		t.Fatal(err.Error())
+	}
+
+	conf := &Config{Retention: Retention{MaxTweetAgeStr: "2y"}}
+	if err := conf.Retention.parse(); err != nil {
+		t.Fatal(err.Error())
+	}
+	job := &retentionJob{conf: conf, dbConn: dbConn}
+
+	if res := job.run(ctx); res.Aged != 1 {
+		t.Errorf("Expected the dry run to count 1 aged tweet, got %+v", res)
+	}
+	left, err := dbConn.GetUserTweets(ctx, u.ID, 10)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	if len(left) != 2 {
+		t.Errorf("Expected the dry run to delete nothing, got %d tweets left", len(left))
+	}
+	if res := job.run(ctx); res.Aged != 1 {
+		t.Errorf("Expected 1 aged tweet deleted, got %+v", res)
+	}
+	left, err = dbConn.GetUserTweets(ctx, u.ID, 10)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	if len(left) != 1 || left[0].Body != "new" {
+		t.Errorf("Expected only the new tweet left, got %+v", left)
+	}
+
+	// Changing the limits dry-runs them again.
+	conf.Retention.MaxTweetsPerUser = 1
+	if res := job.run(ctx); res.Total() != 0 {
+		t.Errorf("Expected nothing left to delete, got %+v", res)
+	}
+	if job.last.MaxTweetsPerUser != 1 {
+		t.Errorf("Expected the changed policy to be remembered, got %+v", job.last)
+	}
+}
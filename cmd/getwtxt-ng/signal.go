@@ -24,11 +24,13 @@ import (
 	"syscall"
 
 	log "github.com/sirupsen/logrus"
+
+	"github.com/gbmor/getwtxt-ng/registry"
 )
 
-func signalWatcher(conf *Config, tickerExit chan<- struct{}, logger *log.Logger) {
+func signalWatcher(conf *Config, dbConn *registry.DB, hosts []*registryHost, maintenance *maintenanceMode, bridges []bridge, tickerExits []chan<- struct{}, logger *log.Logger) {
 	c := make(chan os.Signal, 1)
-	signal.Notify(c, os.Interrupt, syscall.SIGHUP)
+	signal.Notify(c, os.Interrupt, syscall.SIGHUP, syscall.SIGUSR1)
 
 	go func() {
 		for sig := range c {
@@ -37,8 +39,27 @@ func signalWatcher(conf *Config, tickerExit chan<- struct{}, logger *log.Logger)
 				conf.mu.Lock()
 				logger.Infof("Caught %s", sig)
 
-				logger.Info("Shutting down sync ticker")
-				tickerExit <- struct{}{}
+				logger.Info("Shutting down sync tickers")
+				for _, tickerExit := range tickerExits {
+					tickerExit <- struct{}{}
+				}
+
+				if len(bridges) > 0 {
+					logger.Info("Finishing deliveries to bridged networks")
+					for _, b := range bridges {
+						b.Close()
+					}
+				}
+
+				logger.Info("Closing database")
+				if err := dbConn.Close(); err != nil {
+					logger.Infof("When closing database: %s\n", err)
+				}
+				for _, h := range hosts {
+					if err := h.dbConn.Close(); err != nil {
+						logger.Infof("When closing database of the registry at %s: %s\n", h.hostname, err)
+					}
+				}
 
 				logger.Info("Closing log files and switching to stderr")
 				logger.SetOutput(os.Stderr)
@@ -52,11 +73,17 @@ func signalWatcher(conf *Config, tickerExit chan<- struct{}, logger *log.Logger)
 
 				os.Exit(130)
 
+			case syscall.SIGUSR1:
+				logger.Infof("Caught %s: maintenance mode %s", sig, onOff(maintenance.toggle()))
+
 			case syscall.SIGHUP:
 				logger.Infof("Caught %s: reloading configuration...\n", sig)
 				if err := conf.reload(*flagConfig, logger); err != nil {
 					logger.Infof(err.Error())
 				}
+				for _, h := range hosts {
+					conf.syncHostConfig(h.conf)
+				}
 			}
 		}
 	}()
@@ -0,0 +1,66 @@
+package main
+
+/*
+Copyright 2021 G. Benjamin Morrison
+
+This file is part of getwtxt-ng.
+
+getwtxt-ng is free software: you can redistribute it and/or modify
+it under the terms of the GNU Affero General Public License as
+published by the Free Software Foundation, either version 3 of the
+License, or (at your option) any later version.
+
+getwtxt-ng is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU Affero General Public License for more details.
+
+You should have received a copy of the GNU Affero General Public License
+along with getwtxt-ng.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+import (
+	"net/http"
+	"strconv"
+
+	"github.com/gbmor/getwtxt-ng/registry"
+)
+
+// specPageSize is the number of entries per page the twtxt registry specification calls for.
+const specPageSize = 20
+
+// specCompliant reports whether the plain API should behave exactly as the twtxt registry specification describes,
+// rather than with getwtxt-ng's additions.
+func specCompliant(conf *Config) bool {
+	conf.mu.RLock()
+	defer conf.mu.RUnlock()
+	return conf.ServerConfig.SpecCompliant
+}
+
+// formatUsersPlain lists users as nickname, URL, and time added, as the specification does,
+// or with their last sync time appended otherwise.
+func formatUsersPlain(conf *Config, users []registry.User) string {
+	if specCompliant(conf) {
+		return registry.FormatUsersList(users)
+	}
+	return registry.FormatUsersPlainWith(users, plainFormat(conf))
+}
+
+// plainFormat is how the plain API writes timestamps and twt bodies, which can change when the config is reloaded.
+func plainFormat(conf *Config) registry.PlainFormat {
+	conf.mu.RLock()
+	defer conf.mu.RUnlock()
+	return conf.ServerConfig.PlainFormat
+}
+
+// specPaging makes plain listings use the specification's page size in compliance mode, whatever per_page says.
+func specPaging(conf *Config, next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if getFormat(r) == APIFormatPlain && specCompliant(conf) {
+			query := r.URL.Query()
+			query.Set("per_page", strconv.Itoa(specPageSize))
+			r.URL.RawQuery = query.Encode()
+		}
+		next(w, r)
+	}
+}
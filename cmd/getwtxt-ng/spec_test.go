@@ -0,0 +1,219 @@
+package main
+
+/*
+Copyright 2021 G. Benjamin Morrison
+
+This file is part of getwtxt-ng.
+
+getwtxt-ng is free software: you can redistribute it and/or modify
+it under the terms of the GNU Affero General Public License as
+published by the Free Software Foundation, either version 3 of the
+License, or (at your option) any later version.
+
+getwtxt-ng is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU Affero General Public License for more details.
+
+You should have received a copy of the GNU Affero General Public License
+along with getwtxt-ng.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/mux"
+
+	"github.com/gbmor/getwtxt-ng/registry"
+)
+
+// getSpecServer starts a server in compliance mode with two users. The first has 25 tweets,
+// one minute apart, the even ones tagged #spec and the last mentioning the second user.
+func getSpecServer(t *testing.T) (*httptest.Server, []registry.User) {
+	t.Helper()
+	dbConn, err := registry.Open(":memory:", registry.WithPageLimits(10, 1000))
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	t.Cleanup(func() {
+		_ = dbConn.Close()
+	})
+	ctx := context.Background()
+
+	users := []registry.User{
+		{Nick: "foo", URL: "https://example.com/twtxt.txt", DateTimeAdded: time.Date(2022, 1, 1, 0, 0, 0, 0, time.UTC)},
+		{Nick: "bar", URL: "https://example.org/twtxt.txt", DateTimeAdded: time.Date(2022, 1, 2, 0, 0, 0, 0, time.UTC)},
+	}
+	for i := range users {
+		users[i].PasscodeHash = []byte("not a real hash")
+		if err := dbConn.InsertUser(ctx, &users[i]); err != nil {
+			t.Fatal(err.Error())
+		}
+	}
+
+	start := time.Date(2022, 2, 1, 0, 0, 0, 0, time.UTC)
+	tweets := make([]registry.Tweet, 0, 25)
+	for i := 0; i < 25; i++ {
+		body := fmt.Sprintf("twt %d", i)
+		if i%2 == 0 {
+			body += " #spec"
+		}
+		if i == 24 {
+			body += fmt.Sprintf(" @<%s %s>", users[1].Nick, users[1].URL)
+		}
+		tweets = append(tweets, registry.Tweet{UserID: users[0].ID, DateTime: start.Add(time.Duration(i) * time.Minute), Body: body})
+	}
+	if _, err := dbConn.InsertTweets(ctx, tweets); err != nil {
+		t.Fatal(err.Error())
+	}
+
+	conf := &Config{ServerConfig: ServerConfig{SpecCompliant: true, EntriesPerPageMin: 10, EntriesPerPageMax: 1000}}
+	r := mux.NewRouter()
+	setUpRoutes(r, conf, dbConn)
+	srv := httptest.NewServer(r)
+	t.Cleanup(srv.Close)
+
+	return srv, users
+}
+
+func specRequest(t *testing.T, method, target string) (int, http.Header, []string) {
+	t.Helper()
+	req, err := http.NewRequest(method, target, nil)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	defer func() {
+		_ = resp.Body.Close()
+	}()
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+
+	lines := strings.Split(strings.TrimSuffix(string(body), "\n"), "\n")
+	if len(body) == 0 {
+		lines = nil
+	}
+	return resp.StatusCode, resp.Header, lines
+}
+
+// checkSpecTweets verifies each line is nick, URL, RFC3339 timestamp, and body, newest first.
+func checkSpecTweets(t *testing.T, lines []string, wantCount int) {
+	t.Helper()
+	if len(lines) != wantCount {
+		t.Fatalf("Expected %d tweets, got %d: %v", wantCount, len(lines), lines)
+	}
+	var prev time.Time
+	for i, line := range lines {
+		fields := strings.Split(line, "\t")
+		if len(fields) != 4 {
+			t.Fatalf("Expected 4 fields, got %d: %q", len(fields), line)
+		}
+		ts, err := time.Parse(time.RFC3339, fields[2])
+		if err != nil {
+			t.Fatalf("Expected RFC3339 timestamp, got %q", fields[2])
+		}
+		if i > 0 && ts.After(prev) {
+			t.Errorf("Expected tweets in descending order, got %s after %s", ts, prev)
+		}
+		prev = ts
+	}
+}
+
+func TestSpecCompliance_Tweets(t *testing.T) {
+	srv, _ := getSpecServer(t)
+
+	t.Run("first page", func(t *testing.T) {
+		code, header, lines := specRequest(t, http.MethodGet, srv.URL+"/api/plain/tweets?per_page=100")
+		if code != http.StatusOK {
+			t.Fatalf("Expected 200, got %d", code)
+		}
+		if !strings.HasPrefix(header.Get("Content-Type"), "text/plain") {
+			t.Errorf("Expected text/plain, got %s", header.Get("Content-Type"))
+		}
+		checkSpecTweets(t, lines, specPageSize)
+		if !strings.HasPrefix(strings.Split(lines[0], "\t")[3], "twt 24") {
+			t.Errorf("Expected newest tweet first, got: %s", lines[0])
+		}
+	})
+	t.Run("second page", func(t *testing.T) {
+		_, _, lines := specRequest(t, http.MethodGet, srv.URL+"/api/plain/tweets?page=2")
+		checkSpecTweets(t, lines, 5)
+	})
+	t.Run("past the end", func(t *testing.T) {
+		code, _, lines := specRequest(t, http.MethodGet, srv.URL+"/api/plain/tweets?page=3")
+		if code != http.StatusOK || len(lines) != 0 {
+			t.Errorf("Expected empty 200 response, got %d: %v", code, lines)
+		}
+	})
+	t.Run("search", func(t *testing.T) {
+		_, _, lines := specRequest(t, http.MethodGet, srv.URL+"/api/plain/tweets?q=spec")
+		checkSpecTweets(t, lines, 13)
+	})
+	t.Run("tags", func(t *testing.T) {
+		_, _, lines := specRequest(t, http.MethodGet, srv.URL+"/api/plain/tags/spec")
+		checkSpecTweets(t, lines, 13)
+	})
+	t.Run("mentions", func(t *testing.T) {
+		_, _, lines := specRequest(t, http.MethodGet, srv.URL+"/api/plain/mentions?url="+url.QueryEscape("https://example.org/twtxt.txt"))
+		checkSpecTweets(t, lines, 1)
+	})
+}
+
+func TestSpecCompliance_Users(t *testing.T) {
+	srv, users := getSpecServer(t)
+
+	t.Run("list", func(t *testing.T) {
+		code, _, lines := specRequest(t, http.MethodGet, srv.URL+"/api/plain/users")
+		if code != http.StatusOK {
+			t.Fatalf("Expected 200, got %d", code)
+		}
+		if len(lines) != len(users) {
+			t.Fatalf("Expected %d users, got: %v", len(users), lines)
+		}
+		for _, line := range lines {
+			fields := strings.Split(line, "\t")
+			if len(fields) != 3 {
+				t.Fatalf("Expected nick, URL, and timestamp, got: %q", line)
+			}
+			if _, err := time.Parse(time.RFC3339, fields[2]); err != nil {
+				t.Errorf("Expected RFC3339 timestamp, got %q", fields[2])
+			}
+		}
+	})
+	t.Run("search", func(t *testing.T) {
+		_, _, lines := specRequest(t, http.MethodGet, srv.URL+"/api/plain/users?q=bar")
+		if len(lines) != 1 || !strings.HasPrefix(lines[0], "bar\thttps://example.org/twtxt.txt\t") {
+			t.Errorf("Expected only bar, got: %v", lines)
+		}
+	})
+	t.Run("add", func(t *testing.T) {
+		// Nothing listens on port 1, so the fetch fails, but the user is still registered.
+		target := fmt.Sprintf("%s/api/plain/users?nickname=baz&url=%s", srv.URL, url.QueryEscape("http://127.0.0.1:1/twtxt.txt"))
+		code, header, lines := specRequest(t, http.MethodPost, target)
+		if code != http.StatusOK || len(lines) != 1 || lines[0] != "OK" {
+			t.Errorf("Expected 200 OK, got %d: %v", code, lines)
+		}
+		if header.Get("X-Passcode") == "" {
+			t.Error("Expected passcode in X-Passcode header")
+		}
+	})
+	t.Run("add without nickname", func(t *testing.T) {
+		code, _, _ := specRequest(t, http.MethodPost, srv.URL+"/api/plain/users?url="+url.QueryEscape("https://example.net/twtxt.txt"))
+		if code != http.StatusBadRequest {
+			t.Errorf("Expected 400, got %d", code)
+		}
+	})
+}
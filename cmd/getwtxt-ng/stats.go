@@ -0,0 +1,177 @@
+package main
+
+/*
+Copyright 2021 G. Benjamin Morrison
+
+This file is part of getwtxt-ng.
+
+getwtxt-ng is free software: you can redistribute it and/or modify
+it under the terms of the GNU Affero General Public License as
+published by the Free Software Foundation, either version 3 of the
+License, or (at your option) any later version.
+
+getwtxt-ng is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU Affero General Public License for more details.
+
+You should have received a copy of the GNU Affero General Public License
+along with getwtxt-ng.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+
+	"github.com/gbmor/getwtxt-ng/registry"
+)
+
+// Daily stats are kept for a year unless configured otherwise. Snapshots are taken a few minutes
+// into each UTC day, so that the fetches running at midnight have finished.
+const (
+	defaultStatsRetentionDays = 365
+	statsSnapshotDelay        = 5 * time.Minute
+)
+
+// Registrations are counted over the last month of days or quarter of weeks unless asked otherwise,
+// and over at most a year's worth of periods.
+const (
+	defaultRegistrationDays  = 30
+	defaultRegistrationWeeks = 12
+	maxRegistrationPeriods   = 366
+)
+
+// InitStatsTicker records the snapshot of the previous UTC day in the background, then again after each midnight,
+// deleting snapshots older than stats_retention_days each time.
+func InitStatsTicker(conf *Config, dbConn *registry.DB) chan<- struct{} {
+	done := make(chan struct{})
+
+	go func() {
+		recordDailyStats(conf, dbConn)
+		for {
+			now := time.Now().UTC()
+			next := now.Truncate(24*time.Hour).AddDate(0, 0, 1).Add(statsSnapshotDelay)
+			timer := time.NewTimer(next.Sub(now))
+			select {
+			case <-done:
+				timer.Stop()
+				return
+			case <-timer.C:
+				recordDailyStats(conf, dbConn)
+			}
+		}
+	}()
+
+	return done
+}
+
+func recordDailyStats(conf *Config, dbConn *registry.DB) {
+	conf.mu.RLock()
+	retentionDays := conf.ServerConfig.StatsRetentionDays
+	conf.mu.RUnlock()
+
+	ctx := context.Background()
+	yesterday := time.Now().UTC().AddDate(0, 0, -1)
+	recorded, err := dbConn.RecordDailyStats(ctx, yesterday)
+	if err != nil {
+		log.Errorf("Couldn't record daily stats: %s", err)
+		return
+	}
+	if recorded {
+		log.Debugf("Recorded stats for %s", yesterday.Format("2006-01-02"))
+	}
+
+	cutoff := time.Now().UTC().Truncate(24*time.Hour).AddDate(0, 0, -retentionDays)
+	if _, err := dbConn.DeleteDailyStatsOlderThan(ctx, cutoff); err != nil {
+		log.Errorf("Couldn't delete old daily stats: %s", err)
+	}
+}
+
+// getDailyStatsHandler responds with the snapshots of the last ?days=N days, oldest first.
+func getDailyStatsHandler(w http.ResponseWriter, r *http.Request, conf *Config, dbConn *registry.DB, format APIFormat) {
+	conf.mu.RLock()
+	retentionDays := conf.ServerConfig.StatsRetentionDays
+	conf.mu.RUnlock()
+
+	days := 30
+	if daysStr := r.URL.Query().Get("days"); daysStr != "" {
+		parsed, err := strconv.Atoi(daysStr)
+		if err != nil || parsed < 1 {
+			msg := fieldErrorResponse(FieldError{Field: "days", Code: fieldInvalid, Message: fmt.Sprintf("Invalid number of days specified: %s", daysStr)})
+			errorResponseWrite(w, r, format, http.StatusBadRequest, msg)
+			return
+		}
+		days = parsed
+	}
+	if days > retentionDays {
+		days = retentionDays
+	}
+
+	stats, err := dbConn.GetDailyStats(r.Context(), days)
+	if err != nil {
+		reqLog(r).Errorf("When retrieving daily stats for the last %d days: %s", days, err)
+		code, message := queryErrorStatus(r, err)
+		msg := MessageResponse{
+			Message: message,
+		}
+		errorResponseWrite(w, r, format, code, msg)
+		return
+	}
+
+	if format == APIFormatPlain {
+		plainResponseWrite(w, registry.FormatDailyStatsPlain(stats), http.StatusOK)
+	} else if format == APIFormatJSON {
+		jsonResponseWrite(w, r, stats, http.StatusOK)
+	}
+}
+
+// getRegistrationsHandler responds with the number of users registered in each of the last ?last=N days,
+// or weeks with ?period=week, oldest first.
+func getRegistrationsHandler(w http.ResponseWriter, r *http.Request, dbConn *registry.DB, format APIFormat) {
+	query := r.URL.Query()
+	period, err := registry.ParseRegistrationPeriod(query.Get("period"))
+	if err != nil {
+		msg := fieldErrorResponse(FieldError{Field: "period", Code: fieldInvalid, Message: fmt.Sprintf("Invalid period specified, expected day or week: %s", query.Get("period"))})
+		errorResponseWrite(w, r, format, http.StatusBadRequest, msg)
+		return
+	}
+
+	last := defaultRegistrationDays
+	if period == registry.PeriodWeek {
+		last = defaultRegistrationWeeks
+	}
+	if lastStr := query.Get("last"); lastStr != "" {
+		parsed, err := strconv.Atoi(lastStr)
+		if err != nil || parsed < 1 {
+			msg := fieldErrorResponse(FieldError{Field: "last", Code: fieldInvalid, Message: fmt.Sprintf("Invalid number of periods specified: %s", lastStr)})
+			errorResponseWrite(w, r, format, http.StatusBadRequest, msg)
+			return
+		}
+		last = parsed
+	}
+	if last > maxRegistrationPeriods {
+		last = maxRegistrationPeriods
+	}
+
+	counts, err := dbConn.GetRegistrationCounts(r.Context(), period, last)
+	if err != nil {
+		reqLog(r).Errorf("When retrieving registrations over the last %d %ss: %s", last, period, err)
+		code, message := queryErrorStatus(r, err)
+		msg := MessageResponse{
+			Message: message,
+		}
+		errorResponseWrite(w, r, format, code, msg)
+		return
+	}
+
+	if format == APIFormatPlain {
+		plainResponseWrite(w, registry.FormatDayCountsPlain(counts), http.StatusOK)
+	} else if format == APIFormatJSON {
+		jsonResponseWrite(w, r, counts, http.StatusOK)
+	}
+}
@@ -0,0 +1,83 @@
+package main
+
+/*
+Copyright 2021 G. Benjamin Morrison
+
+This file is part of getwtxt-ng.
+
+getwtxt-ng is free software: you can redistribute it and/or modify
+it under the terms of the GNU Affero General Public License as
+published by the Free Software Foundation, either version 3 of the
+License, or (at your option) any later version.
+
+getwtxt-ng is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU Affero General Public License for more details.
+
+You should have received a copy of the GNU Affero General Public License
+along with getwtxt-ng.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gbmor/getwtxt-ng/registry"
+)
+
+func TestGetDailyStatsHandler(t *testing.T) {
+	dbConn := getFederationDB(t)
+	conf := &Config{ServerConfig: ServerConfig{StatsRetentionDays: 7}}
+	if _, err := dbConn.RecordDailyStats(context.Background(), time.Now().UTC().AddDate(0, 0, -1)); err != nil {
+		t.Fatal(err.Error())
+	}
+
+	w := httptest.NewRecorder()
+	getDailyStatsHandler(w, httptest.NewRequest(http.MethodGet, "/api/json/stats/daily?days=400", nil), conf, dbConn, APIFormatJSON)
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected 200, got %d: %s", w.Code, w.Body.String())
+	}
+	stats := make([]registry.DailyStats, 0)
+	if err := json.NewDecoder(w.Body).Decode(&stats); err != nil {
+		t.Fatal(err.Error())
+	}
+	if len(stats) != 1 {
+		t.Errorf("Expected yesterday's snapshot, got %+v", stats)
+	}
+
+	w = httptest.NewRecorder()
+	getDailyStatsHandler(w, httptest.NewRequest(http.MethodGet, "/api/json/stats/daily?days=0", nil), conf, dbConn, APIFormatJSON)
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("Expected 400 for days=0, got %d", w.Code)
+	}
+}
+
+func TestGetRegistrationsHandler(t *testing.T) {
+	dbConn := getFederationDB(t)
+
+	w := httptest.NewRecorder()
+	getRegistrationsHandler(w, httptest.NewRequest(http.MethodGet, "/api/json/stats/registrations?period=week&last=4", nil), dbConn, APIFormatJSON)
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected 200, got %d: %s", w.Code, w.Body.String())
+	}
+	counts := make([]registry.DayCount, 0)
+	if err := json.NewDecoder(w.Body).Decode(&counts); err != nil {
+		t.Fatal(err.Error())
+	}
+	if len(counts) != 4 {
+		t.Errorf("Expected 4 weeks, got %+v", counts)
+	}
+
+	for _, target := range []string{"/api/json/stats/registrations?period=month", "/api/json/stats/registrations?last=-1"} {
+		w = httptest.NewRecorder()
+		getRegistrationsHandler(w, httptest.NewRequest(http.MethodGet, target, nil), dbConn, APIFormatJSON)
+		if w.Code != http.StatusBadRequest {
+			t.Errorf("Expected 400 from %s, got %d", target, w.Code)
+		}
+	}
+}
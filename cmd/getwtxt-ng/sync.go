@@ -3,6 +3,8 @@ package main
 import (
 	"context"
 	"fmt"
+	"net/http"
+	"strconv"
 	"time"
 
 	log "github.com/sirupsen/logrus"
@@ -10,6 +12,9 @@ import (
 	"github.com/gbmor/getwtxt-ng/registry"
 )
 
+// defaultSyncRuns is how many sync reports are listed unless ?limit= asks for another number.
+const defaultSyncRuns = 20
+
 func InitTicker(t time.Duration, dbConn *registry.DB) chan<- struct{} {
 	if err := pullAllTweets(dbConn); err != nil {
 		log.Errorf("Error syncing: %s", err)
@@ -34,35 +39,66 @@ func InitTicker(t time.Duration, dbConn *registry.DB) chan<- struct{} {
 	return done
 }
 
-func pullAllTweets(dbConn *registry.DB) error {
+// pullAllTweets syncs the feeds that are due and records a report of the run, whether or not it finished.
+func pullAllTweets(dbConn *registry.DB) (err error) {
 	begin := time.Now().UTC()
 	log.Debugf("Initiating sync at %s", begin)
-	defer log.Debugf("Sync finished after %s", time.Since(begin))
 
 	ctx := context.Background()
-	users, err := dbConn.GetAllUsers(context.Background())
+	result := registry.SyncResult{}
+	defer func() {
+		run := registry.NewSyncRun(begin, time.Now(), result, err)
+		if recErr := dbConn.RecordSyncRun(ctx, &run); recErr != nil {
+			log.Errorf("Couldn't record report of sync started at %s: %s", begin.Format(time.RFC3339), recErr)
+		}
+	}()
+
+	users, err := dbConn.GetUsersDueForSync(ctx, 0, begin)
 	if err != nil {
-		return fmt.Errorf("couldn't get all users to sync tweets: %w", err)
+		return fmt.Errorf("couldn't get users due for sync: %w", err)
 	}
 
-	usersSynced := make([]registry.User, 0, len(users))
-	for i, e := range users {
-		tweets, err := dbConn.FetchTwtxt(e.URL, e.ID, e.LastSync)
-		if err != nil {
-			log.Errorf("Couldn't get twtxt file for user %s: %s", e.URL, err)
-			continue
-		}
-		if err := dbConn.InsertTweets(ctx, tweets); err != nil {
-			log.Errorf("couldn't insert tweets for user %s during sync: %s", e.URL, err)
-			continue
-		}
-		users[i].LastSync = time.Now().UTC()
-		usersSynced = append(usersSynced, users[i])
+	result, err = dbConn.SyncUsers(ctx, users)
+	if err != nil {
+		return err
 	}
+	log.Infof("Sync ingested %d new twts from %d of %d users, %d failed, in %s",
+		result.Tweets, result.Updated+result.NotModified, result.Users, len(result.Failed), time.Since(begin))
 
-	if err := dbConn.UpdateUsersSyncTime(ctx, usersSynced); err != nil {
-		return fmt.Errorf("couldn't update users sync time: %w", err)
+	// Run even when it's off, so turning it off shows the duplicates again.
+	if n, err := dbConn.CollapseMirroredTweets(ctx); err != nil {
+		log.Errorf("Couldn't collapse twts posted by mirrored feeds: %s", err)
+	} else if n > 0 {
+		log.Infof("Updated %d twts posted by mirrored feeds", n)
 	}
 
 	return nil
 }
+
+// getSyncHistoryHandler responds with the reports of the last ?limit=N syncs, newest first.
+func getSyncHistoryHandler(w http.ResponseWriter, r *http.Request, dbConn *registry.DB, format APIFormat) {
+	limit := defaultSyncRuns
+	if limitStr := r.URL.Query().Get("limit"); limitStr != "" {
+		parsed, err := strconv.Atoi(limitStr)
+		if err != nil || parsed < 1 {
+			msg := fieldErrorResponse(FieldError{Field: "limit", Code: fieldInvalid, Message: fmt.Sprintf("Invalid limit specified: %s", limitStr)})
+			errorResponseWrite(w, r, format, http.StatusBadRequest, msg)
+			return
+		}
+		limit = parsed
+	}
+
+	runs, err := dbConn.GetSyncRuns(r.Context(), limit)
+	if err != nil {
+		reqLog(r).Errorf("When retrieving the last %d sync runs: %s", limit, err)
+		code, message := queryErrorStatus(r, err)
+		errorResponseWrite(w, r, format, code, MessageResponse{Message: message})
+		return
+	}
+
+	if format == APIFormatPlain {
+		plainResponseWrite(w, registry.FormatSyncRunsPlain(runs), http.StatusOK)
+	} else if format == APIFormatJSON {
+		jsonResponseWrite(w, r, runs, http.StatusOK)
+	}
+}
@@ -0,0 +1,57 @@
+package main
+
+/*
+Copyright 2021 G. Benjamin Morrison
+
+This file is part of getwtxt-ng.
+
+getwtxt-ng is free software: you can redistribute it and/or modify
+it under the terms of the GNU Affero General Public License as
+published by the Free Software Foundation, either version 3 of the
+License, or (at your option) any later version.
+
+getwtxt-ng is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU Affero General Public License for more details.
+
+You should have received a copy of the GNU Affero General Public License
+along with getwtxt-ng.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gbmor/getwtxt-ng/registry"
+)
+
+func TestGetSyncHistoryHandler(t *testing.T) {
+	dbConn := getFederationDB(t)
+	for i := 0; i < 2; i++ {
+		if err := pullAllTweets(dbConn); err != nil {
+			t.Fatal(err.Error())
+		}
+	}
+
+	w := httptest.NewRecorder()
+	getSyncHistoryHandler(w, httptest.NewRequest(http.MethodGet, "/api/json/sync/history?limit=1", nil), dbConn, APIFormatJSON)
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected 200, got %d: %s", w.Code, w.Body.String())
+	}
+	runs := make([]registry.SyncRun, 0)
+	if err := json.NewDecoder(w.Body).Decode(&runs); err != nil {
+		t.Fatal(err.Error())
+	}
+	if len(runs) != 1 || runs[0].ID != 2 || runs[0].Started.IsZero() || runs[0].Error != "" {
+		t.Errorf("Expected the report of the second sync, got %+v", runs)
+	}
+
+	w = httptest.NewRecorder()
+	getSyncHistoryHandler(w, httptest.NewRequest(http.MethodGet, "/api/plain/sync/history?limit=none", nil), dbConn, APIFormatPlain)
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("Expected 400 for an invalid limit, got %d", w.Code)
+	}
+}
@@ -0,0 +1,193 @@
+package main
+
+/*
+Copyright 2021 G. Benjamin Morrison
+
+This file is part of getwtxt-ng.
+
+getwtxt-ng is free software: you can redistribute it and/or modify
+it under the terms of the GNU Affero General Public License as
+published by the Free Software Foundation, either version 3 of the
+License, or (at your option) any later version.
+
+getwtxt-ng is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU Affero General Public License for more details.
+
+You should have received a copy of the GNU Affero General Public License
+along with getwtxt-ng.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+
+	"github.com/gorilla/mux"
+	log "github.com/sirupsen/logrus"
+
+	"github.com/gbmor/getwtxt-ng/common"
+	"github.com/gbmor/getwtxt-ng/registry"
+)
+
+// RegistryHost is another registry served by the same process, with its own database and landing page,
+// chosen by the Host header of each request. It shares everything else in server_config, and the
+// subscribed blocklists, with the main registry, but doesn't federate, bridge, or write public archives.
+type RegistryHost struct {
+	Hostname       string         `toml:"hostname" json:"hostname"`
+	DatabasePath   string         `toml:"database_path" json:"database_path"`
+	InstanceConfig InstanceConfig `toml:"instance_info" json:"instance_info"`
+}
+
+// registryHost is a running RegistryHost.
+type registryHost struct {
+	hostname string
+	conf     *Config
+	dbConn   *registry.DB
+	handler  http.Handler
+	// adminHandler serves the admin API when it's on its own listener.
+	adminHandler http.Handler
+}
+
+// parseRegistryHosts validates the hostnames and databases of the additional registries.
+// Each needs a hostname and database of its own.
+func (c *Config) parseRegistryHosts() error {
+	hostnames := make(map[string]bool, len(c.Registries))
+	databases := map[string]bool{c.ServerConfig.DatabasePath: true}
+	for i := range c.Registries {
+		rh := &c.Registries[i]
+		if strings.ContainsAny(strings.TrimSpace(rh.Hostname), "/:@ ") || normalizeHostname(rh.Hostname) == "" {
+			return fmt.Errorf("registries need a bare hostname, like registry.example.com, got %q", rh.Hostname)
+		}
+		rh.Hostname = normalizeHostname(rh.Hostname)
+		if hostnames[rh.Hostname] {
+			return fmt.Errorf("registries can't share the hostname %s", rh.Hostname)
+		}
+		hostnames[rh.Hostname] = true
+
+		if strings.TrimSpace(rh.DatabasePath) == "" {
+			return fmt.Errorf("the registry at %s needs a database_path", rh.Hostname)
+		}
+		if rh.DatabasePath != ":memory:" && databases[rh.DatabasePath] {
+			return fmt.Errorf("the registry at %s can't share the database at %s", rh.Hostname, rh.DatabasePath)
+		}
+		databases[rh.DatabasePath] = true
+		if c.ServerConfig.HostedFeeds && strings.TrimSpace(rh.InstanceConfig.SiteURL) == "" {
+			return errors.New("please set site_url in the instance_info of each registry to host feeds")
+		}
+	}
+
+	return nil
+}
+
+// hostConfig derives the configuration of an additional registry from the main one's.
+// The caller must hold c.mu.
+func (c *Config) hostConfig(rh RegistryHost) *Config {
+	hc := &Config{
+		ServerConfig:   c.ServerConfig,
+		InstanceConfig: rh.InstanceConfig,
+		ContentFilter:  c.ContentFilter,
+		Retention:      c.Retention,
+		Blocklists:     c.Blocklists,
+		Assets:         c.Assets,
+	}
+	hc.ServerConfig.DatabasePath = rh.DatabasePath
+	hc.InstanceConfig.Version = common.Version
+
+	return hc
+}
+
+// syncHostConfig copies the main registry's settings to an additional one after they're reloaded.
+func (c *Config) syncHostConfig(hc *Config) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	hc.mu.Lock()
+	defer hc.mu.Unlock()
+
+	sc := c.ServerConfig
+	sc.DatabasePath = hc.ServerConfig.DatabasePath
+	hc.ServerConfig = sc
+	hc.Retention = c.Retention
+	hc.Assets = c.Assets
+}
+
+// startRegistryHosts opens the database of each additional registry, sets up its routes, and starts syncing it.
+// Each registry's handler has its own response cache, since each has its own data.
+func startRegistryHosts(conf *Config) ([]*registryHost, []chan<- struct{}, error) {
+	conf.mu.RLock()
+	hostConfs := make([]*Config, 0, len(conf.Registries))
+	hostnames := make([]string, 0, len(conf.Registries))
+	for _, rh := range conf.Registries {
+		hostConfs = append(hostConfs, conf.hostConfig(rh))
+		hostnames = append(hostnames, rh.Hostname)
+	}
+	conf.mu.RUnlock()
+
+	hosts := make([]*registryHost, 0, len(hostConfs))
+	tickerExits := make([]chan<- struct{}, 0, 3*len(hostConfs))
+	for i, hc := range hostConfs {
+		dbConn, err := openRegistryDB(hc)
+		if err != nil {
+			for _, h := range hosts {
+				_ = h.dbConn.Close()
+			}
+			return nil, nil, fmt.Errorf("when opening database of the registry at %s: %w", hostnames[i], err)
+		}
+
+		r := mux.NewRouter()
+		setUpRoutes(r, hc, dbConn)
+		if hc.ServerConfig.HostedFeeds {
+			setUpHostedFeedRoutes(r, hc, dbConn)
+		}
+		h := &registryHost{
+			hostname: hostnames[i],
+			conf:     hc,
+			dbConn:   dbConn,
+			handler:  newResponseCache(dbConn, responseCacheTTL, responseCacheEntries).wrap(withRequestTimeout(hc, r)),
+		}
+		if hc.ServerConfig.AdminListen != "" {
+			ar := mux.NewRouter()
+			setUpAdminRoutes(ar, hc, dbConn)
+			h.adminHandler = ar
+		}
+		hosts = append(hosts, h)
+		log.Infof("Serving the registry at %s from %s", hostnames[i], hc.ServerConfig.DatabasePath)
+	}
+
+	// Started once every database is open, so a bad one doesn't leave tickers running.
+	for _, h := range hosts {
+		tickerExits = append(tickerExits, InitTicker(h.conf.ServerConfig.FetchInterval, h.dbConn), InitStatsTicker(h.conf, h.dbConn), InitRetentionTicker(h.conf, h.dbConn),
+			InitBlocklistTicker(h.conf, h.dbConn))
+	}
+
+	return hosts, tickerExits, nil
+}
+
+// withRegistryHosts sends each request to the handler of the registry whose hostname it's for,
+// or to next if it's for none of them.
+func withRegistryHosts(byHostname map[string]http.Handler, next http.Handler) http.Handler {
+	if len(byHostname) == 0 {
+		return next
+	}
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if h, ok := byHostname[normalizeHostname(r.Host)]; ok {
+			h.ServeHTTP(w, r)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// normalizeHostname lowercases a hostname and strips any port and trailing dot, so it can be compared
+// with a Host header.
+func normalizeHostname(host string) string {
+	host = strings.TrimSpace(host)
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
+	return strings.TrimSuffix(strings.ToLower(host), ".")
+}
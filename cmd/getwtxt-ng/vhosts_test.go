@@ -0,0 +1,147 @@
+package main
+
+/*
+Copyright 2021 G. Benjamin Morrison
+
+This file is part of getwtxt-ng.
+
+getwtxt-ng is free software: you can redistribute it and/or modify
+it under the terms of the GNU Affero General Public License as
+published by the Free Software Foundation, either version 3 of the
+License, or (at your option) any later version.
+
+getwtxt-ng is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU Affero General Public License for more details.
+
+You should have received a copy of the GNU Affero General Public License
+along with getwtxt-ng.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gbmor/getwtxt-ng/registry"
+)
+
+func TestRegistryHosts(t *testing.T) {
+	conf := &Config{
+		ServerConfig: ServerConfig{
+			DatabasePath:      ":memory:",
+			FetchInterval:     time.Hour,
+			EntriesPerPageMin: 10,
+			EntriesPerPageMax: 20,
+		},
+		Registries: []RegistryHost{{
+			Hostname:       "Second.Example.",
+			DatabasePath:   ":memory:",
+			InstanceConfig: InstanceConfig{SiteName: "second"},
+		}},
+	}
+	blocklist := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = fmt.Fprint(w, "spam.example\n")
+	}))
+	t.Cleanup(blocklist.Close)
+	conf.Blocklists.URLs = []string{blocklist.URL}
+	if err := conf.Retention.parse(); err != nil {
+		t.Fatal(err.Error())
+	}
+	if err := conf.Blocklists.parse(); err != nil {
+		t.Fatal(err.Error())
+	}
+	if err := conf.parseRegistryHosts(); err != nil {
+		t.Fatal(err.Error())
+	}
+	hosts, tickerExits, err := startRegistryHosts(conf)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	t.Cleanup(func() {
+		for _, exit := range tickerExits {
+			exit <- struct{}{}
+		}
+		for _, h := range hosts {
+			_ = h.dbConn.Close()
+		}
+	})
+	if len(hosts) != 1 || hosts[0].hostname != "second.example" || hosts[0].conf.InstanceConfig.SiteName != "second" {
+		t.Fatalf("Expected the second registry by its normalized hostname, got %+v", hosts)
+	}
+
+	u := registry.User{Nick: "foo", URL: "https://foo.example/twtxt.txt", PasscodeHash: []byte("hash")}
+	if err := hosts[0].dbConn.InsertUser(context.Background(), &u); err != nil {
+		t.Fatal(err.Error())
+	}
+
+	// The second registry subscribes to the same blocklists, refreshed in the background.
+	var ban *registry.Ban
+	for deadline := time.Now().Add(5 * time.Second); ban == nil && time.Now().Before(deadline); time.Sleep(10 * time.Millisecond) {
+		ban, err = hosts[0].dbConn.FindBan(context.Background(), "https://feeds.spam.example/twtxt.txt")
+		if err != nil {
+			t.Fatal(err.Error())
+		}
+	}
+	if ban == nil || ban.Source != blocklist.URL {
+		t.Errorf("Expected the blocklist's bans in the second registry, got %+v", ban)
+	}
+
+	main := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		plainResponseWrite(w, "main", http.StatusOK)
+	})
+	handler := withRegistryHosts(map[string]http.Handler{hosts[0].hostname: hosts[0].handler}, main)
+
+	tests := []struct {
+		host string
+		want string
+	}{
+		{"second.example", u.URL},
+		{"SECOND.example:8080", u.URL},
+		{"first.example", "main"},
+		{"", "main"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.host, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, "/api/plain/users", nil)
+			req.Host = tt.host
+			w := httptest.NewRecorder()
+			handler.ServeHTTP(w, req)
+			if w.Code != http.StatusOK || !strings.Contains(w.Body.String(), tt.want) {
+				t.Errorf("Expected 200 with %q, got %d: %s", tt.want, w.Code, w.Body.String())
+			}
+		})
+	}
+}
+
+func TestConfig_parseRegistryHosts(t *testing.T) {
+	tests := []struct {
+		name       string
+		registries []RegistryHost
+		wantErr    string
+	}{
+		{"no hostname", []RegistryHost{{DatabasePath: "a.db"}}, "bare hostname"},
+		{"hostname with a scheme", []RegistryHost{{Hostname: "https://a.example", DatabasePath: "a.db"}}, "bare hostname"},
+		{"no database", []RegistryHost{{Hostname: "a.example"}}, "database_path"},
+		{"main database", []RegistryHost{{Hostname: "a.example", DatabasePath: "main.db"}}, "share the database"},
+		{"same hostname", []RegistryHost{{Hostname: "a.example", DatabasePath: "a.db"}, {Hostname: "A.example", DatabasePath: "b.db"}}, "share the hostname"},
+		{"valid", []RegistryHost{{Hostname: "a.example", DatabasePath: "a.db"}, {Hostname: "b.example", DatabasePath: "b.db"}}, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			conf := &Config{ServerConfig: ServerConfig{DatabasePath: "main.db"}, Registries: tt.registries}
+			err := conf.parseRegistryHosts()
+			if tt.wantErr == "" && err != nil {
+				t.Errorf("Expected no error, got %s", err)
+			}
+			if tt.wantErr != "" && (err == nil || !strings.Contains(err.Error(), tt.wantErr)) {
+				t.Errorf("Expected error regarding %s, got %v", tt.wantErr, err)
+			}
+		})
+	}
+}
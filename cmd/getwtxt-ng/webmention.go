@@ -0,0 +1,218 @@
+package main
+
+/*
+Copyright 2021 G. Benjamin Morrison
+
+This file is part of getwtxt-ng.
+
+getwtxt-ng is free software: you can redistribute it and/or modify
+it under the terms of the GNU Affero General Public License as
+published by the Free Software Foundation, either version 3 of the
+License, or (at your option) any later version.
+
+getwtxt-ng is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU Affero General Public License for more details.
+
+You should have received a copy of the GNU Affero General Public License
+along with getwtxt-ng.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
+
+	log "github.com/sirupsen/logrus"
+
+	"github.com/gbmor/getwtxt-ng/common"
+	"github.com/gbmor/getwtxt-ng/registry"
+)
+
+// webmentionMaxSource is how much of a source page is searched for a link to the target.
+const webmentionMaxSource = 1 << 20
+
+// regexConversationPath matches the path of a conversation on this registry, extracting the twt hash.
+var regexConversationPath = regexp.MustCompile(`^/api/(?:json|plain)/conversations/([a-z2-7]+)$`)
+
+// errWebmentionTarget is returned when a Webmention's target isn't something this registry knows about.
+var errWebmentionTarget = errors.New("target isn't a registered feed or a conversation on this registry")
+
+// resolveWebmentionTarget works out which user, and which of their tweets if any, target refers to.
+// A target is either a registered feed's URL or the URL of a conversation on this registry.
+func resolveWebmentionTarget(ctx context.Context, conf *Config, dbConn *registry.DB, target string) (registry.Webmention, error) {
+	mention := registry.Webmention{Target: target}
+
+	conf.mu.RLock()
+	siteURL := conf.InstanceConfig.SiteURL
+	conf.mu.RUnlock()
+
+	parsedTarget, err := url.Parse(target)
+	if err != nil {
+		return mention, errWebmentionTarget
+	}
+	parsedSite, err := url.Parse(siteURL)
+	if err == nil && parsedSite.Host != "" && strings.EqualFold(parsedTarget.Host, parsedSite.Host) {
+		if match := regexConversationPath.FindStringSubmatch(parsedTarget.Path); match != nil {
+			tweets, err := dbConn.GetTweetsByHash(ctx, match[1])
+			if err != nil {
+				return mention, err
+			}
+			if len(tweets) == 0 {
+				return mention, errWebmentionTarget
+			}
+			mention.UserURL = tweets[0].URL
+			mention.TwtHash = match[1]
+			return mention, nil
+		}
+	}
+
+	user, err := dbConn.GetFullUserByURL(ctx, target)
+	if errors.Is(err, sql.ErrNoRows) || (err == nil && user.Status != registry.UserStatusActive) {
+		return mention, errWebmentionTarget
+	}
+	if err != nil {
+		return mention, err
+	}
+	mention.UserURL = user.URL
+
+	return mention, nil
+}
+
+// webmentionSourceLinks fetches source and reports whether it still links to target, along with the response's status.
+func webmentionSourceLinks(ctx context.Context, client *http.Client, source, target string) (bool, int, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, source, nil)
+	if err != nil {
+		return false, 0, fmt.Errorf("couldn't create http request to fetch %s: %w", source, err)
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return false, 0, fmt.Errorf("error making http request to %s: %w", source, err)
+	}
+	defer func() {
+		_ = resp.Body.Close()
+	}()
+	if resp.StatusCode == http.StatusGone {
+		return false, resp.StatusCode, nil
+	}
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return false, resp.StatusCode, fmt.Errorf("got status code %d from %s", resp.StatusCode, source)
+	}
+
+	body, err := io.ReadAll(io.LimitReader(resp.Body, webmentionMaxSource))
+	if err != nil {
+		return false, resp.StatusCode, fmt.Errorf("when reading %s: %w", source, err)
+	}
+
+	return strings.Contains(string(body), target), resp.StatusCode, nil
+}
+
+// webmentionHandler receives Webmentions as described by the W3C recommendation. The source is fetched
+// before responding, and the mention is recorded only if it links to the target. A mention whose source
+// has gone or no longer links to the target is removed.
+func webmentionHandler(w http.ResponseWriter, r *http.Request, conf *Config, dbConn *registry.DB) {
+	ctx := r.Context()
+	_ = r.ParseForm()
+	source := strings.TrimSpace(r.PostForm.Get("source"))
+	target := strings.TrimSpace(r.PostForm.Get("target"))
+
+	if !common.IsValidURL(source, log.StandardLogger()) || !common.IsValidURL(target, log.StandardLogger()) {
+		errorWrite(w, r, errorFormat(r), http.StatusBadRequest, "Source and target must be http or https URLs")
+		return
+	}
+	if source == target {
+		errorWrite(w, r, errorFormat(r), http.StatusBadRequest, "Source and target must differ")
+		return
+	}
+
+	mention, err := resolveWebmentionTarget(ctx, conf, dbConn, target)
+	if errors.Is(err, errWebmentionTarget) {
+		errorWrite(w, r, errorFormat(r), http.StatusBadRequest, err.Error())
+		return
+	}
+	if err != nil {
+		reqLog(r).Errorf("When resolving webmention target %s: %s", target, err)
+		errorWrite(w, r, errorFormat(r), http.StatusInternalServerError, "")
+		return
+	}
+	mention.Source = source
+
+	links, status, err := webmentionSourceLinks(ctx, dbConn.Client, source, target)
+	if err != nil {
+		reqLog(r).Debugf("Couldn't verify webmention of %s from %s: %s", target, source, err)
+		errorWrite(w, r, errorFormat(r), http.StatusBadRequest, "Couldn't fetch source")
+		return
+	}
+	if !links {
+		if err := dbConn.RemoveWebmention(ctx, source, target); err != nil && !errors.Is(err, sql.ErrNoRows) {
+			reqLog(r).Errorf("When removing webmention of %s from %s: %s", target, source, err)
+		}
+		if status == http.StatusGone {
+			plainResponseWrite(w, "Webmention removed", http.StatusOK)
+			return
+		}
+		errorWrite(w, r, errorFormat(r), http.StatusBadRequest, "Source doesn't link to target")
+		return
+	}
+
+	if err := dbConn.AddWebmention(ctx, &mention); err != nil {
+		reqLog(r).Errorf("When adding webmention of %s from %s: %s", target, source, err)
+		errorWrite(w, r, errorFormat(r), http.StatusInternalServerError, "")
+		return
+	}
+
+	plainResponseWrite(w, "Webmention accepted", http.StatusOK)
+}
+
+// getWebmentionsHandler responds with the Webmentions of the user whose feed is at the url parameter,
+// or of the tweets with the twt hash in the hash parameter.
+func getWebmentionsHandler(w http.ResponseWriter, r *http.Request, conf *Config, dbConn *registry.DB, format APIFormat) {
+	ctx := r.Context()
+	_ = r.ParseForm()
+	userURL := strings.TrimSpace(r.Form.Get("url"))
+	hash := strings.TrimSpace(r.Form.Get("hash"))
+
+	var mentions []registry.Webmention
+	var err error
+	switch {
+	case hash != "":
+		mentions, err = dbConn.GetTwtWebmentions(ctx, hash)
+	case userURL != "":
+		mentions, err = dbConn.GetUserWebmentions(ctx, userURL)
+	default:
+		msg := fieldErrorResponse(
+			FieldError{Field: "url", Code: fieldRequired, Message: "Missing user URL or twt hash"},
+			FieldError{Field: "hash", Code: fieldRequired, Message: "Missing user URL or twt hash"},
+		)
+		errorResponseWrite(w, r, format, http.StatusBadRequest, msg)
+		return
+	}
+	if err != nil {
+		code := http.StatusInternalServerError
+		msg := MessageResponse{
+			Message: "Internal Server Error",
+		}
+		if errors.Is(err, registry.ErrInvalidTwtHash) {
+			code = http.StatusBadRequest
+			msg.Message = fmt.Sprintf("Invalid twt hash: %s", hash)
+		} else {
+			reqLog(r).Errorf("When retrieving webmentions of %s%s: %s", userURL, hash, err)
+		}
+		errorResponseWrite(w, r, format, code, msg)
+		return
+	}
+
+	if format == APIFormatPlain {
+		out := registry.FormatWebmentionsPlainWith(mentions, plainFormat(conf))
+		plainResponseWrite(w, out, http.StatusOK)
+	} else if format == APIFormatJSON {
+		jsonResponseWrite(w, r, mentions, http.StatusOK)
+	}
+}
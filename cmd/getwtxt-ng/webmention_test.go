@@ -0,0 +1,132 @@
+package main
+
+/*
+Copyright 2021 G. Benjamin Morrison
+
+This file is part of getwtxt-ng.
+
+getwtxt-ng is free software: you can redistribute it and/or modify
+it under the terms of the GNU Affero General Public License as
+published by the Free Software Foundation, either version 3 of the
+License, or (at your option) any later version.
+
+getwtxt-ng is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU Affero General Public License for more details.
+
+You should have received a copy of the GNU Affero General Public License
+along with getwtxt-ng.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/mux"
+
+	"github.com/gbmor/getwtxt-ng/registry"
+)
+
+func TestWebmentionHandler(t *testing.T) {
+	ctx := context.Background()
+	dbConn, err := registry.Open(":memory:")
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	t.Cleanup(func() {
+		_ = dbConn.Close()
+	})
+
+	user := registry.User{Nick: "foo", URL: "https://foo.example/twtxt.txt", PasscodeHash: []byte("not a real hash"), DateTimeAdded: time.Now().UTC()}
+	if err := dbConn.InsertUser(ctx, &user); err != nil {
+		t.Fatal(err.Error())
+	}
+	tweet := registry.Tweet{UserID: user.ID, DateTime: time.Date(2022, 2, 1, 0, 0, 0, 0, time.UTC), Body: "hello"}
+	if _, err := dbConn.InsertTweets(ctx, []registry.Tweet{tweet}); err != nil {
+		t.Fatal(err.Error())
+	}
+	hash := registry.TwtHash(user.URL, tweet.DateTime, tweet.Body)
+
+	conf := &Config{InstanceConfig: InstanceConfig{SiteURL: "https://registry.example"}}
+	r := mux.NewRouter()
+	setUpRoutes(r, conf, dbConn)
+	srv := httptest.NewServer(r)
+	t.Cleanup(srv.Close)
+
+	pages := map[string]string{}
+	gone := false
+	source := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if gone {
+			w.WriteHeader(http.StatusGone)
+			return
+		}
+		_, _ = fmt.Fprint(w, pages[r.URL.Path])
+	}))
+	t.Cleanup(source.Close)
+
+	send := func(source, target string) int {
+		t.Helper()
+		resp, err := http.PostForm(srv.URL+"/webmention", url.Values{"source": {source}, "target": {target}})
+		if err != nil {
+			t.Fatal(err.Error())
+		}
+		_ = resp.Body.Close()
+		return resp.StatusCode
+	}
+
+	conversationURL := "https://registry.example/api/json/conversations/" + hash
+	pages["/feed"] = fmt.Sprintf(`<a href="%s">foo's feed</a>`, user.URL)
+	pages["/reply"] = fmt.Sprintf(`<a href="%s">in reply to</a>`, conversationURL)
+	pages["/nothing"] = "no links here"
+
+	if code := send(source.URL+"/feed", user.URL); code != http.StatusOK {
+		t.Errorf("Expected mention of feed to be accepted, got %d", code)
+	}
+	if code := send(source.URL+"/reply", conversationURL); code != http.StatusOK {
+		t.Errorf("Expected mention of conversation to be accepted, got %d", code)
+	}
+	if code := send(source.URL+"/nothing", user.URL); code != http.StatusBadRequest {
+		t.Errorf("Expected source without a link to be rejected, got %d", code)
+	}
+	if code := send(source.URL+"/feed", "https://nobody.example/twtxt.txt"); code != http.StatusBadRequest {
+		t.Errorf("Expected unknown target to be rejected, got %d", code)
+	}
+
+	mentions, err := dbConn.GetUserWebmentions(ctx, user.URL)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	if len(mentions) != 2 {
+		t.Fatalf("Expected 2 webmentions, got: %v", mentions)
+	}
+
+	resp, err := http.Get(srv.URL + "/api/plain/webmentions?hash=" + hash)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	body, _ := io.ReadAll(resp.Body)
+	_ = resp.Body.Close()
+	if !strings.Contains(string(body), source.URL+"/reply") {
+		t.Errorf("Expected the reply in the twt's webmentions, got: %s", body)
+	}
+
+	gone = true
+	if code := send(source.URL+"/feed", user.URL); code != http.StatusOK {
+		t.Errorf("Expected deletion to be accepted, got %d", code)
+	}
+	mentions, err = dbConn.GetUserWebmentions(ctx, user.URL)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	if len(mentions) != 1 {
+		t.Errorf("Expected the gone source's webmention to be removed, got: %v", mentions)
+	}
+}
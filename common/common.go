@@ -21,11 +21,13 @@ along with getwtxt-ng.  If not, see <https://www.gnu.org/licenses/>.
 */
 
 import (
+	"fmt"
 	"net"
 	"net/url"
+	"strconv"
 	"strings"
+	"time"
 
-	log "github.com/sirupsen/logrus"
 	"golang.org/x/crypto/bcrypt"
 )
 
@@ -54,7 +56,7 @@ func ValidatePass(pass string, hash []byte) bool {
 }
 
 // IsValidURL returns true if the provided URL is a valid-looking HTTP or HTTPS URL.
-func IsValidURL(destURL string, logger *log.Logger) bool {
+func IsValidURL(destURL string, logger Logger) bool {
 	if strings.TrimSpace(destURL) == "" {
 		return false
 	}
@@ -77,3 +79,26 @@ func IsValidURL(destURL string, logger *log.Logger) bool {
 	}
 	return true
 }
+
+// ParseDuration extends time.ParseDuration with day (d), week (w), and year (y) units,
+// which may only be used on their own. Eg: "90d" or "2y", but not "1y2d".
+// A year is treated as 365 days.
+func ParseDuration(s string) (time.Duration, error) {
+	s = strings.TrimSpace(s)
+	units := map[byte]time.Duration{
+		'd': 24 * time.Hour,
+		'w': 7 * 24 * time.Hour,
+		'y': 365 * 24 * time.Hour,
+	}
+	if len(s) > 1 {
+		if unit, ok := units[s[len(s)-1]]; ok {
+			n, err := strconv.ParseFloat(s[:len(s)-1], 64)
+			if err != nil {
+				return 0, fmt.Errorf("invalid duration %q: %w", s, err)
+			}
+			return time.Duration(n * float64(unit)), nil
+		}
+	}
+
+	return time.ParseDuration(s)
+}
@@ -20,9 +20,13 @@ along with getwtxt-ng.  If not, see <https://www.gnu.org/licenses/>.
 */
 
 import (
+	"bytes"
 	"crypto/rand"
 	"fmt"
+	stdlog "log"
+	"strings"
 	"testing"
+	"time"
 
 	log "github.com/sirupsen/logrus"
 	"golang.org/x/crypto/bcrypt"
@@ -108,3 +112,52 @@ func TestIsValidURL(t *testing.T) {
 		})
 	}
 }
+
+func TestParseDuration(t *testing.T) {
+	cases := []struct {
+		in      string
+		expect  time.Duration
+		wantErr bool
+	}{
+		{in: "90m", expect: 90 * time.Minute},
+		{in: "90d", expect: 90 * 24 * time.Hour},
+		{in: "2w", expect: 14 * 24 * time.Hour},
+		{in: "2y", expect: 2 * 365 * 24 * time.Hour},
+		{in: "1.5d", expect: 36 * time.Hour},
+		{in: "xd", wantErr: true},
+		{in: "3kg", wantErr: true},
+	}
+	for _, tt := range cases {
+		t.Run(tt.in, func(t *testing.T) {
+			out, err := ParseDuration(tt.in)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Got error %v, wanted error: %v", err, tt.wantErr)
+			}
+			if out != tt.expect {
+				t.Errorf("Got %s, expected %s", out, tt.expect)
+			}
+		})
+	}
+}
+
+func TestStdLogger(t *testing.T) {
+	buf := &bytes.Buffer{}
+	logger := StdLogger{
+		Logger: stdlog.New(buf, "", 0),
+	}
+	logger.Debugf("hidden %d", 1)
+	logger.Infof("shown %d", 2)
+	logger.Errorf("shown %d", 3)
+	if strings.Contains(buf.String(), "hidden") {
+		t.Errorf("Debug message written with debugging off: %s", buf.String())
+	}
+	if buf.String() != "INFO: shown 2\nERROR: shown 3\n" {
+		t.Errorf("Got unexpected output: %q", buf.String())
+	}
+
+	logger.Debug = true
+	logger.Debugf("shown %d", 4)
+	if !strings.HasSuffix(buf.String(), "DEBUG: shown 4\n") {
+		t.Errorf("Expected debug message, got: %q", buf.String())
+	}
+}
@@ -0,0 +1,65 @@
+package common
+
+/*
+Copyright 2021 G. Benjamin Morrison
+
+This file is part of getwtxt-ng.
+
+getwtxt-ng is free software: you can redistribute it and/or modify
+it under the terms of the GNU Affero General Public License as
+published by the Free Software Foundation, either version 3 of the
+License, or (at your option) any later version.
+
+getwtxt-ng is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU Affero General Public License for more details.
+
+You should have received a copy of the GNU Affero General Public License
+along with getwtxt-ng.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+import (
+	"fmt"
+	stdlog "log"
+)
+
+// Logger is the leveled logger getwtxt-ng's packages write to.
+// *logrus.Logger and *logrus.Entry satisfy it as-is; StdLogger adapts the standard library's logger.
+type Logger interface {
+	Debugf(format string, args ...interface{})
+	Infof(format string, args ...interface{})
+	Errorf(format string, args ...interface{})
+}
+
+// NopLogger discards everything written to it.
+type NopLogger struct{}
+
+func (NopLogger) Debugf(string, ...interface{}) {}
+func (NopLogger) Infof(string, ...interface{})  {}
+func (NopLogger) Errorf(string, ...interface{}) {}
+
+// StdLogger adapts a standard library logger to Logger, prefixing each message with its level.
+// Debug messages are dropped unless Debug is set.
+type StdLogger struct {
+	Logger *stdlog.Logger
+	Debug  bool
+}
+
+func (l StdLogger) Debugf(format string, args ...interface{}) {
+	if l.Debug {
+		l.output("DEBUG", format, args...)
+	}
+}
+
+func (l StdLogger) Infof(format string, args ...interface{}) {
+	l.output("INFO", format, args...)
+}
+
+func (l StdLogger) Errorf(format string, args ...interface{}) {
+	l.output("ERROR", format, args...)
+}
+
+func (l StdLogger) output(level, format string, args ...interface{}) {
+	_ = l.Logger.Output(3, fmt.Sprintf("%s: %s", level, fmt.Sprintf(format, args...)))
+}
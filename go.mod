@@ -5,18 +5,26 @@ go 1.18
 require (
 	github.com/BurntSushi/toml v0.4.1
 	github.com/DATA-DOG/go-sqlmock v1.5.0
+	github.com/btcsuite/btcd/btcec/v2 v2.3.2
 	github.com/gorilla/handlers v1.5.1
 	github.com/gorilla/mux v1.8.0
 	github.com/mattn/go-sqlite3 v1.14.9
 	github.com/ogier/pflag v0.0.1
 	github.com/sirupsen/logrus v1.8.1
+	github.com/syndtr/goleveldb v1.0.0
 	github.com/throttled/throttled/v2 v2.9.0
 	golang.org/x/crypto v0.1.0
+	golang.org/x/net v0.1.0
 	golang.org/x/term v0.1.0
+	golang.org/x/text v0.4.0
 )
 
 require (
+	github.com/btcsuite/btcd/chaincfg/chainhash v1.0.1 // indirect
+	github.com/decred/dcrd/crypto/blake256 v1.0.0 // indirect
+	github.com/decred/dcrd/dcrec/secp256k1/v4 v4.0.1 // indirect
 	github.com/felixge/httpsnoop v1.0.2 // indirect
+	github.com/golang/snappy v0.0.0-20180518054509-2e65f85255db // indirect
 	github.com/hashicorp/golang-lru v0.5.4 // indirect
 	golang.org/x/sys v0.1.0 // indirect
 )
@@ -0,0 +1,160 @@
+package nostr
+
+/*
+Copyright 2021 G. Benjamin Morrison
+
+This file is part of getwtxt-ng.
+
+getwtxt-ng is free software: you can redistribute it and/or modify
+it under the terms of the GNU Affero General Public License as
+published by the Free Software Foundation, either version 3 of the
+License, or (at your option) any later version.
+
+getwtxt-ng is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU Affero General Public License for more details.
+
+You should have received a copy of the GNU Affero General Public License
+along with getwtxt-ng.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// KindTextNote is the kind of a short text note.
+const KindTextNote = 1
+
+// Event is a signed Nostr event, as described by NIP-01.
+type Event struct {
+	ID        string     `json:"id"`
+	PubKey    string     `json:"pubkey"`
+	CreatedAt int64      `json:"created_at"`
+	Kind      int        `json:"kind"`
+	Tags      [][]string `json:"tags"`
+	Content   string     `json:"content"`
+	Sig       string     `json:"sig"`
+}
+
+// serialize returns the canonical form of the event that its ID is the hash of.
+func (e *Event) serialize() ([]byte, error) {
+	tags := e.Tags
+	if tags == nil {
+		tags = [][]string{}
+	}
+	buf := &bytes.Buffer{}
+	enc := json.NewEncoder(buf)
+	enc.SetEscapeHTML(false)
+	if err := enc.Encode([]interface{}{0, e.PubKey, e.CreatedAt, e.Kind, tags, e.Content}); err != nil {
+		return nil, err
+	}
+	// NIP-01 only escapes control characters, quotes, and backslashes.
+	out := bytes.TrimSuffix(buf.Bytes(), []byte("\n"))
+	out = bytes.ReplaceAll(out, []byte(`\u2028`), []byte("\u2028"))
+	out = bytes.ReplaceAll(out, []byte(`\u2029`), []byte("\u2029"))
+
+	return out, nil
+}
+
+func (e *Event) hash() ([]byte, error) {
+	serialized, err := e.serialize()
+	if err != nil {
+		return nil, fmt.Errorf("when serializing event: %w", err)
+	}
+	sum := sha256.Sum256(serialized)
+	return sum[:], nil
+}
+
+// Sign sets the event's public key, ID, and signature using the 32-byte secret key.
+func (e *Event) Sign(secret []byte) error {
+	pub, err := PublicKey(secret)
+	if err != nil {
+		return err
+	}
+	if e.Tags == nil {
+		e.Tags = [][]string{}
+	}
+	e.PubKey = hex.EncodeToString(pub)
+	id, err := e.hash()
+	if err != nil {
+		return err
+	}
+	sig, err := Sign(secret, id)
+	if err != nil {
+		return fmt.Errorf("when signing event: %w", err)
+	}
+	e.ID = hex.EncodeToString(id)
+	e.Sig = hex.EncodeToString(sig)
+
+	return nil
+}
+
+// Verify checks that the event's ID matches its contents and is signed by its public key.
+func (e *Event) Verify() bool {
+	id, err := e.hash()
+	if err != nil || hex.EncodeToString(id) != e.ID {
+		return false
+	}
+	pub, err := hex.DecodeString(e.PubKey)
+	if err != nil {
+		return false
+	}
+	sig, err := hex.DecodeString(e.Sig)
+	if err != nil {
+		return false
+	}
+	return Verify(pub, id, sig)
+}
+
+// DeriveKey derives a feed's secret key from the master secret, so each feed has its own stable identity
+// without storing a key for every one of them.
+func DeriveKey(master []byte, feedURL string) []byte {
+	for counter := uint32(0); ; counter++ {
+		mac := hmac.New(sha256.New, master)
+		mac.Write([]byte(feedURL))
+		if counter > 0 {
+			_ = binary.Write(mac, binary.BigEndian, counter)
+		}
+		secret := mac.Sum(nil)
+		if _, err := parseSecretKey(secret); err == nil {
+			return secret
+		}
+	}
+}
+
+// LoadOrCreateSecret reads the hex-encoded 32-byte master secret at path, generating and storing a new one
+// if it doesn't exist.
+func LoadOrCreateSecret(path string) ([]byte, error) {
+	b, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		secret := make([]byte, 32)
+		if _, err := rand.Read(secret); err != nil {
+			return nil, fmt.Errorf("when generating secret: %w", err)
+		}
+		if err := os.WriteFile(path, []byte(hex.EncodeToString(secret)+"\n"), 0600); err != nil {
+			return nil, fmt.Errorf("when writing secret to %s: %w", path, err)
+		}
+		return secret, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("when reading secret from %s: %w", path, err)
+	}
+
+	secret, err := hex.DecodeString(strings.TrimSpace(string(b)))
+	if err != nil || len(secret) != 32 {
+		return nil, fmt.Errorf("secret in %s must be 32 hex-encoded bytes", path)
+	}
+
+	return secret, nil
+}
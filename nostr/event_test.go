@@ -0,0 +1,87 @@
+package nostr
+
+/*
+Copyright 2021 G. Benjamin Morrison
+
+This file is part of getwtxt-ng.
+
+getwtxt-ng is free software: you can redistribute it and/or modify
+it under the terms of the GNU Affero General Public License as
+published by the Free Software Foundation, either version 3 of the
+License, or (at your option) any later version.
+
+getwtxt-ng is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU Affero General Public License for more details.
+
+You should have received a copy of the GNU Affero General Public License
+along with getwtxt-ng.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+import (
+	"bytes"
+	"path/filepath"
+	"testing"
+)
+
+func TestEvent_Sign(t *testing.T) {
+	secret := DeriveKey([]byte("master"), "https://example.com/twtxt.txt")
+	ev := Event{
+		CreatedAt: 1650000000,
+		Kind:      KindTextNote,
+		Content:   "hello <world> & \"friends\"\n ",
+	}
+	if err := ev.Sign(secret); err != nil {
+		t.Fatal(err.Error())
+	}
+	if len(ev.ID) != 64 || len(ev.PubKey) != 64 || len(ev.Sig) != 128 {
+		t.Errorf("Expected hex ID, public key, and signature, got: %+v", ev)
+	}
+	if !ev.Verify() {
+		t.Error("Expected signed event to verify")
+	}
+
+	serialized, err := ev.serialize()
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	want := `[0,"` + ev.PubKey + `",1650000000,1,[],"hello <world> & \"friends\"\n` + " " + `"]`
+	if string(serialized) != want {
+		t.Errorf("Expected serialization %s, got %s", want, serialized)
+	}
+
+	ev.Content = "changed"
+	if ev.Verify() {
+		t.Error("Expected modified event to fail verification")
+	}
+}
+
+func TestDeriveKey(t *testing.T) {
+	master := []byte("master")
+	a := DeriveKey(master, "https://example.com/twtxt.txt")
+	if !bytes.Equal(a, DeriveKey(master, "https://example.com/twtxt.txt")) {
+		t.Error("Expected the same key for the same feed")
+	}
+	if bytes.Equal(a, DeriveKey(master, "https://example.org/twtxt.txt")) {
+		t.Error("Expected different keys for different feeds")
+	}
+	if bytes.Equal(a, DeriveKey([]byte("other"), "https://example.com/twtxt.txt")) {
+		t.Error("Expected different keys for different masters")
+	}
+}
+
+func TestLoadOrCreateSecret(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "nostr.key")
+	secret, err := LoadOrCreateSecret(path)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	loaded, err := LoadOrCreateSecret(path)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	if len(secret) != 32 || !bytes.Equal(secret, loaded) {
+		t.Error("Expected the stored secret to be loaded again")
+	}
+}
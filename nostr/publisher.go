@@ -0,0 +1,240 @@
+package nostr
+
+/*
+Copyright 2021 G. Benjamin Morrison
+
+This file is part of getwtxt-ng.
+
+getwtxt-ng is free software: you can redistribute it and/or modify
+it under the terms of the GNU Affero General Public License as
+published by the Free Software Foundation, either version 3 of the
+License, or (at your option) any later version.
+
+getwtxt-ng is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU Affero General Public License for more details.
+
+You should have received a copy of the GNU Affero General Public License
+along with getwtxt-ng.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"sync"
+	"time"
+
+	"golang.org/x/net/websocket"
+
+	"github.com/gbmor/getwtxt-ng/common"
+)
+
+const (
+	publishQueueSize = 1024
+	relayTimeout     = 10 * time.Second
+)
+
+// Note is a twt to be published as a text note signed by its feed's derived key.
+type Note struct {
+	FeedURL string
+	Created time.Time
+	Body    string
+}
+
+// Publisher signs notes and sends them to relays in the background, so slow or unreachable relays
+// don't hold up syncing. Each relay has its own connection, which is reopened after an error.
+type Publisher struct {
+	master []byte
+	logger common.Logger
+	queue  chan Note
+	relays []*relay
+	wg     sync.WaitGroup
+
+	mu     sync.Mutex
+	closed bool
+}
+
+// NewPublisher starts publishing to the provided relays with keys derived from master.
+// origin is sent in the websocket handshake. A nil logger discards everything.
+func NewPublisher(relayURLs []string, master []byte, origin string, logger common.Logger) *Publisher {
+	if logger == nil {
+		logger = common.NopLogger{}
+	}
+	p := &Publisher{
+		master: master,
+		logger: logger,
+		queue:  make(chan Note, publishQueueSize),
+	}
+	for _, u := range relayURLs {
+		r := &relay{url: u, origin: origin, queue: make(chan Event, publishQueueSize)}
+		p.relays = append(p.relays, r)
+		p.wg.Add(1)
+		go func() {
+			defer p.wg.Done()
+			for ev := range r.queue {
+				if err := r.publish(ev); err != nil {
+					p.logger.Errorf("When publishing event %s to %s: %s", ev.ID, r.url, err)
+				}
+			}
+			r.close()
+		}()
+	}
+
+	p.wg.Add(1)
+	go func() {
+		defer p.wg.Done()
+		for n := range p.queue {
+			ev, err := p.sign(n)
+			if err != nil {
+				p.logger.Errorf("When signing note from %s: %s", n.FeedURL, err)
+				continue
+			}
+			for _, r := range p.relays {
+				select {
+				case r.queue <- ev:
+				default:
+					p.logger.Errorf("Queue for relay %s full, dropping event %s", r.url, ev.ID)
+				}
+			}
+		}
+		for _, r := range p.relays {
+			close(r.queue)
+		}
+	}()
+
+	return p
+}
+
+// PublicKey returns the hex-encoded public key that notes from the feed are signed with.
+func (p *Publisher) PublicKey(feedURL string) (string, error) {
+	ev := Event{}
+	if err := ev.Sign(DeriveKey(p.master, feedURL)); err != nil {
+		return "", err
+	}
+	return ev.PubKey, nil
+}
+
+func (p *Publisher) sign(n Note) (Event, error) {
+	ev := Event{
+		CreatedAt: n.Created.Unix(),
+		Kind:      KindTextNote,
+		Tags:      [][]string{{"r", n.FeedURL}},
+		Content:   n.Body,
+	}
+	err := ev.Sign(DeriveKey(p.master, n.FeedURL))
+	return ev, err
+}
+
+// Enqueue schedules a note for publishing. It's dropped if the queue is full, rather than blocking the caller.
+func (p *Publisher) Enqueue(n Note) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.closed {
+		return
+	}
+
+	select {
+	case p.queue <- n:
+	default:
+		p.logger.Errorf("Publish queue full, dropping note from %s", n.FeedURL)
+	}
+}
+
+// Close stops accepting notes and waits for the queued ones to be published.
+func (p *Publisher) Close() {
+	p.mu.Lock()
+	if !p.closed {
+		p.closed = true
+		close(p.queue)
+	}
+	p.mu.Unlock()
+	p.wg.Wait()
+}
+
+// relay is a connection to a single relay, used by one goroutine at a time.
+type relay struct {
+	url    string
+	origin string
+	queue  chan Event
+	conn   *websocket.Conn
+}
+
+// publish sends the event and waits for the relay to acknowledge it. The connection is dropped after an error.
+func (r *relay) publish(ev Event) error {
+	if r.conn == nil {
+		conn, err := Dial(r.url, r.origin)
+		if err != nil {
+			return err
+		}
+		r.conn = conn
+	}
+
+	if err := Publish(r.conn, ev); err != nil {
+		r.close()
+		return err
+	}
+
+	return nil
+}
+
+func (r *relay) close() {
+	if r.conn != nil {
+		_ = r.conn.Close()
+		r.conn = nil
+	}
+}
+
+// Dial opens a websocket connection to a relay.
+func Dial(relayURL, origin string) (*websocket.Conn, error) {
+	config, err := websocket.NewConfig(relayURL, origin)
+	if err != nil {
+		return nil, fmt.Errorf("when configuring connection to %s: %w", relayURL, err)
+	}
+	config.Dialer = &net.Dialer{Timeout: relayTimeout}
+	conn, err := websocket.DialConfig(config)
+	if err != nil {
+		return nil, fmt.Errorf("when connecting to %s: %w", relayURL, err)
+	}
+	return conn, nil
+}
+
+// ErrRejected is returned when a relay refuses an event.
+var ErrRejected = errors.New("relay rejected event")
+
+// Publish sends an event over conn and waits for the relay's OK. Other messages are skipped.
+func Publish(conn *websocket.Conn, ev Event) error {
+	if err := conn.SetDeadline(time.Now().Add(relayTimeout)); err != nil {
+		return err
+	}
+	if err := websocket.JSON.Send(conn, []interface{}{"EVENT", ev}); err != nil {
+		return fmt.Errorf("when sending event: %w", err)
+	}
+
+	for {
+		msg := []json.RawMessage{}
+		if err := websocket.JSON.Receive(conn, &msg); err != nil {
+			return fmt.Errorf("when reading response: %w", err)
+		}
+		if len(msg) < 3 {
+			continue
+		}
+		label, id := "", ""
+		if json.Unmarshal(msg[0], &label) != nil || label != "OK" || json.Unmarshal(msg[1], &id) != nil || id != ev.ID {
+			continue
+		}
+
+		accepted := false
+		reason := ""
+		_ = json.Unmarshal(msg[2], &accepted)
+		if len(msg) > 3 {
+			_ = json.Unmarshal(msg[3], &reason)
+		}
+		if !accepted {
+			return fmt.Errorf("%w: %s", ErrRejected, reason)
+		}
+		return nil
+	}
+}
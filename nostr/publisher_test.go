@@ -0,0 +1,129 @@
+package nostr
+
+/*
+Copyright 2021 G. Benjamin Morrison
+
+This file is part of getwtxt-ng.
+
+getwtxt-ng is free software: you can redistribute it and/or modify
+it under the terms of the GNU Affero General Public License as
+published by the Free Software Foundation, either version 3 of the
+License, or (at your option) any later version.
+
+getwtxt-ng is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU Affero General Public License for more details.
+
+You should have received a copy of the GNU Affero General Public License
+along with getwtxt-ng.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"golang.org/x/net/websocket"
+)
+
+// testRelay accepts every event that verifies, unless its content is "reject".
+type testRelay struct {
+	mu     sync.Mutex
+	events []Event
+}
+
+func (tr *testRelay) handle(conn *websocket.Conn) {
+	for {
+		msg := []json.RawMessage{}
+		if err := websocket.JSON.Receive(conn, &msg); err != nil {
+			return
+		}
+		ev := Event{}
+		if len(msg) != 2 || json.Unmarshal(msg[1], &ev) != nil {
+			return
+		}
+		_ = websocket.JSON.Send(conn, []interface{}{"NOTICE", "hello"})
+		if !ev.Verify() || ev.Content == "reject" {
+			_ = websocket.JSON.Send(conn, []interface{}{"OK", ev.ID, false, "invalid: no"})
+			continue
+		}
+		tr.mu.Lock()
+		tr.events = append(tr.events, ev)
+		tr.mu.Unlock()
+		_ = websocket.JSON.Send(conn, []interface{}{"OK", ev.ID, true, ""})
+	}
+}
+
+func startTestRelay(t *testing.T) (*testRelay, string) {
+	tr := &testRelay{}
+	srv := httptest.NewServer(websocket.Handler(tr.handle))
+	t.Cleanup(srv.Close)
+	return tr, "ws" + strings.TrimPrefix(srv.URL, "http")
+}
+
+func TestPublish(t *testing.T) {
+	tr, relayURL := startTestRelay(t)
+	conn, err := Dial(relayURL, "http://localhost/")
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	defer func() {
+		_ = conn.Close()
+	}()
+	secret := DeriveKey([]byte("master"), "https://example.com/twtxt.txt")
+
+	ev := Event{CreatedAt: time.Now().Unix(), Kind: KindTextNote, Content: "hi"}
+	if err := ev.Sign(secret); err != nil {
+		t.Fatal(err.Error())
+	}
+	if err := Publish(conn, ev); err != nil {
+		t.Error(err.Error())
+	}
+
+	rejected := Event{CreatedAt: time.Now().Unix(), Kind: KindTextNote, Content: "reject"}
+	if err := rejected.Sign(secret); err != nil {
+		t.Fatal(err.Error())
+	}
+	if err := Publish(conn, rejected); !errors.Is(err, ErrRejected) {
+		t.Errorf("Expected ErrRejected, got: %v", err)
+	}
+
+	if len(tr.events) != 1 || tr.events[0].ID != ev.ID {
+		t.Errorf("Expected relay to store only the accepted event, got: %v", tr.events)
+	}
+}
+
+func TestPublisher(t *testing.T) {
+	trA, relayA := startTestRelay(t)
+	trB, relayB := startTestRelay(t)
+	p := NewPublisher([]string{relayA, relayB}, []byte("master"), "http://localhost/", nil)
+
+	feedURL := "https://example.com/twtxt.txt"
+	created := time.Date(2022, 4, 15, 12, 0, 0, 0, time.UTC)
+	p.Enqueue(Note{FeedURL: feedURL, Created: created, Body: "first"})
+	p.Enqueue(Note{FeedURL: feedURL, Created: created.Add(time.Minute), Body: "second"})
+	p.Close()
+	p.Enqueue(Note{FeedURL: feedURL, Created: created, Body: "after close"})
+
+	pub, err := p.PublicKey(feedURL)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	for _, tr := range []*testRelay{trA, trB} {
+		if len(tr.events) != 2 {
+			t.Fatalf("Expected 2 events on each relay, got: %v", tr.events)
+		}
+		ev := tr.events[0]
+		if ev.Content != "first" || ev.PubKey != pub || ev.CreatedAt != created.Unix() || ev.Kind != KindTextNote {
+			t.Errorf("Got unexpected event: %+v", ev)
+		}
+		if len(ev.Tags) != 1 || ev.Tags[0][0] != "r" || ev.Tags[0][1] != feedURL {
+			t.Errorf("Expected event to reference its feed, got tags: %v", ev.Tags)
+		}
+	}
+}
@@ -0,0 +1,87 @@
+package nostr
+
+/*
+Copyright 2021 G. Benjamin Morrison
+
+This file is part of getwtxt-ng.
+
+getwtxt-ng is free software: you can redistribute it and/or modify
+it under the terms of the GNU Affero General Public License as
+published by the Free Software Foundation, either version 3 of the
+License, or (at your option) any later version.
+
+getwtxt-ng is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU Affero General Public License for more details.
+
+You should have received a copy of the GNU Affero General Public License
+along with getwtxt-ng.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+import (
+	"crypto/rand"
+	"errors"
+
+	"github.com/btcsuite/btcd/btcec/v2"
+	"github.com/btcsuite/btcd/btcec/v2/schnorr"
+)
+
+// ErrInvalidKey is returned for a secret key outside the curve's order or a public key not on the curve.
+var ErrInvalidKey = errors.New("invalid secp256k1 key")
+
+func parseSecretKey(secret []byte) (*btcec.PrivateKey, error) {
+	var d btcec.ModNScalar
+	if len(secret) != 32 || d.SetByteSlice(secret) || d.IsZero() {
+		return nil, ErrInvalidKey
+	}
+	return btcec.PrivKeyFromScalar(&d), nil
+}
+
+// PublicKey returns the 32-byte x-only public key for a 32-byte secret key.
+func PublicKey(secret []byte) ([]byte, error) {
+	priv, err := parseSecretKey(secret)
+	if err != nil {
+		return nil, err
+	}
+	defer priv.Zero()
+	return schnorr.SerializePubKey(priv.PubKey()), nil
+}
+
+// Sign creates a BIP-340 Schnorr signature of msg, a 32-byte hash.
+func Sign(secret, msg []byte) ([]byte, error) {
+	aux := make([]byte, 32)
+	if _, err := rand.Read(aux); err != nil {
+		return nil, err
+	}
+	return signWithAux(secret, msg, aux)
+}
+
+func signWithAux(secret, msg, aux []byte) ([]byte, error) {
+	priv, err := parseSecretKey(secret)
+	if err != nil {
+		return nil, err
+	}
+	defer priv.Zero()
+
+	var auxData [32]byte
+	copy(auxData[:], aux)
+	sig, err := schnorr.Sign(priv, msg, schnorr.CustomNonce(auxData))
+	if err != nil {
+		return nil, err
+	}
+	return sig.Serialize(), nil
+}
+
+// Verify checks a BIP-340 Schnorr signature of msg by the x-only public key.
+func Verify(pubKey, msg, sig []byte) bool {
+	pub, err := schnorr.ParsePubKey(pubKey)
+	if err != nil {
+		return false
+	}
+	parsed, err := schnorr.ParseSignature(sig)
+	if err != nil {
+		return false
+	}
+	return parsed.Verify(msg, pub)
+}
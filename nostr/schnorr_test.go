@@ -0,0 +1,108 @@
+package nostr
+
+/*
+Copyright 2021 G. Benjamin Morrison
+
+This file is part of getwtxt-ng.
+
+getwtxt-ng is free software: you can redistribute it and/or modify
+it under the terms of the GNU Affero General Public License as
+published by the Free Software Foundation, either version 3 of the
+License, or (at your option) any later version.
+
+getwtxt-ng is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU Affero General Public License for more details.
+
+You should have received a copy of the GNU Affero General Public License
+along with getwtxt-ng.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+import (
+	"bytes"
+	"encoding/hex"
+	"strings"
+	"testing"
+)
+
+func mustHex(t *testing.T, s string) []byte {
+	t.Helper()
+	b, err := hex.DecodeString(s)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	return b
+}
+
+// Vectors from the BIP-340 reference test suite.
+func TestSignWithAux(t *testing.T) {
+	cases := []struct {
+		secret, pub, aux, msg, sig string
+	}{
+		{
+			secret: "0000000000000000000000000000000000000000000000000000000000000003",
+			pub:    "F9308A019258C31049344F85F89D5229B531C845836F99B08601F113BCE036F9",
+			aux:    "0000000000000000000000000000000000000000000000000000000000000000",
+			msg:    "0000000000000000000000000000000000000000000000000000000000000000",
+			sig:    "E907831F80848D1069A5371B402410364BDF1C5F8307B0084C55F1CE2DCA821525F66A4A85EA8B71E482A74F382D2CE5EBEEE8FDB2172F477DF4900D310536C0",
+		},
+		{
+			secret: "B7E151628AED2A6ABF7158809CF4F3C762E7160F38B4DA56A784D9045190CFEF",
+			pub:    "DFF1D77F2A671C5F36183726DB2341BE58FEAE1DA2DECED843240F7B502BA659",
+			aux:    "0000000000000000000000000000000000000000000000000000000000000001",
+			msg:    "243F6A8885A308D313198A2E03707344A4093822299F31D0082EFA98EC4E6C89",
+			sig:    "6896BD60EEAE296DB48A229FF71DFE071BDE413E6D43F917DC8DCF8C78DE33418906D11AC976ABCCB20B091292BFF4EA897EFCB639EA871CFA95F6DE339E4B0A",
+		},
+	}
+
+	for _, c := range cases {
+		secret := mustHex(t, c.secret)
+		pub, err := PublicKey(secret)
+		if err != nil {
+			t.Fatal(err.Error())
+		}
+		if got := strings.ToUpper(hex.EncodeToString(pub)); got != c.pub {
+			t.Errorf("Expected public key %s, got %s", c.pub, got)
+		}
+		sig, err := signWithAux(secret, mustHex(t, c.msg), mustHex(t, c.aux))
+		if err != nil {
+			t.Fatal(err.Error())
+		}
+		if got := strings.ToUpper(hex.EncodeToString(sig)); got != c.sig {
+			t.Errorf("Expected signature %s, got %s", c.sig, got)
+		}
+		if !Verify(pub, mustHex(t, c.msg), sig) {
+			t.Error("Expected signature to verify")
+		}
+	}
+}
+
+func TestVerify(t *testing.T) {
+	secret := bytes.Repeat([]byte{0x42}, 32)
+	pub, err := PublicKey(secret)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	msg := bytes.Repeat([]byte{0x01}, 32)
+	sig, err := Sign(secret, msg)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	if !Verify(pub, msg, sig) {
+		t.Error("Expected signature to verify")
+	}
+
+	tampered := append([]byte{}, msg...)
+	tampered[0] ^= 0xff
+	if Verify(pub, tampered, sig) {
+		t.Error("Expected signature of a different message to fail")
+	}
+	if Verify(pub, msg, sig[:63]) {
+		t.Error("Expected truncated signature to fail")
+	}
+
+	if _, err := PublicKey(make([]byte, 32)); err != ErrInvalidKey {
+		t.Errorf("Expected ErrInvalidKey for zero secret, got: %v", err)
+	}
+}
@@ -105,18 +105,22 @@ func getDBMocker(t *testing.T) (*DB, sqlmock.Sqlmock) {
 // test data loaded into the tables.
 func getPopulatedDB(t *testing.T) *DB {
 	t.Helper()
-	db, err := InitSQLite(":memory:", 20, 1000, nil, log.StandardLogger())
+	db, err := Open(":memory:", WithLogger(log.StandardLogger()))
 	if err != nil {
 		t.Fatal(err.Error())
 	}
 
-	usersStmt := "INSERT INTO users (id, url, nick, passcode_hash, dt_added, last_sync) VALUES (?,?,?,?,?,?)"
+	usersStmt := "INSERT INTO users (id, url, canonical_url, nick, passcode_hash, dt_added, last_sync) VALUES (?,?,?,?,?,?,?)"
 	for _, u := range populatedDBUsers {
 		u.PasscodeHash, err = common.HashPass(u.Passcode)
 		if err != nil {
 			t.Fatal(err.Error())
 		}
-		if _, err := db.conn.Exec(usersStmt, u.ID, u.URL, u.Nick, u.PasscodeHash, u.DateTimeAdded.UnixNano(), u.LastSync.UnixNano()); err != nil {
+		canonical, err := CanonicalURL(u.URL)
+		if err != nil {
+			t.Fatal(err.Error())
+		}
+		if _, err := db.conn.Exec(usersStmt, u.ID, u.URL, canonical, u.Nick, u.PasscodeHash, u.DateTimeAdded.UnixNano(), u.LastSync.UnixNano()); err != nil {
 			_ = db.conn.Close()
 			t.Fatal(err.Error())
 			return nil
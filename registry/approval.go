@@ -0,0 +1,103 @@
+package registry
+
+/*
+Copyright 2021 G. Benjamin Morrison
+
+This file is part of getwtxt-ng.
+
+getwtxt-ng is free software: you can redistribute it and/or modify
+it under the terms of the GNU Affero General Public License as
+published by the Free Software Foundation, either version 3 of the
+License, or (at your option) any later version.
+
+getwtxt-ng is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU Affero General Public License for more details.
+
+You should have received a copy of the GNU Affero General Public License
+along with getwtxt-ng.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// ErrUserNotPendingApproval is returned when approving a user who isn't waiting for approval.
+var ErrUserNotPendingApproval = errors.New("user isn't pending approval")
+
+// GetPendingApprovals retrieves the users waiting for approval, oldest registration first,
+// along with what their feeds say about themselves so they can be judged.
+func (d *DB) GetPendingApprovals(ctx context.Context) ([]User, error) {
+	ctx, cancel := d.withQueryTimeout(ctx)
+	defer cancel()
+
+	userStmt := `SELECT id, url, nick, dt_added, last_sync, meta_nick, avatar, description FROM users
+					WHERE status = ?
+					ORDER BY dt_added ASC, id ASC`
+	rows, err := d.conn.QueryContext(ctx, userStmt, UserStatusPendingApproval)
+	if err != nil {
+		return nil, fmt.Errorf("when querying for users pending approval: %w", err)
+	}
+	defer func() {
+		_ = rows.Close()
+	}()
+
+	users := make([]User, 0)
+	for rows.Next() {
+		dt := int64(0)
+		ls := int64(0)
+		thisUser := User{Status: UserStatusPendingApproval}
+		err := rows.Scan(&thisUser.ID, &thisUser.URL, &thisUser.Nick, &dt, &ls, &thisUser.DeclaredNick, &thisUser.Avatar, &thisUser.Description)
+		if err != nil {
+			return nil, fmt.Errorf("when scanning user pending approval: %w", err)
+		}
+		thisUser.DateTimeAdded = time.Unix(0, dt).UTC()
+		thisUser.LastSync = time.Unix(0, ls).UTC()
+		users = append(users, thisUser)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("when reading users pending approval: %w", err)
+	}
+
+	return users, nil
+}
+
+// ApproveUser makes a user waiting for approval active, listing them and their tweets.
+// Returns ErrUserNotPendingApproval if they aren't waiting.
+func (d *DB) ApproveUser(ctx context.Context, u *User) error {
+	if u == nil || u.ID == "" {
+		return ErrNoUsersProvided
+	}
+
+	tx, err := d.beginWrite(ctx)
+	if err != nil {
+		return fmt.Errorf("when beginning tx to approve user %s: %w", u.URL, err)
+	}
+	defer func() {
+		_ = tx.Rollback()
+	}()
+
+	res, err := tx.ExecContext(ctx, "UPDATE users SET status = ? WHERE id = ? AND status = ?", UserStatusActive, u.ID, UserStatusPendingApproval)
+	if err != nil {
+		return fmt.Errorf("when approving user %s: %w", u.URL, err)
+	}
+	if n, err := res.RowsAffected(); err != nil {
+		return fmt.Errorf("when approving user %s: %w", u.URL, err)
+	} else if n == 0 {
+		return fmt.Errorf("when approving user %s: %w", u.URL, ErrUserNotPendingApproval)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("when committing tx to approve user %s: %w", u.URL, err)
+	}
+	d.invalidate()
+
+	u.Status = UserStatusActive
+	d.Hooks.usersApproved(ctx, []User{*u})
+
+	return nil
+}
@@ -0,0 +1,88 @@
+package registry
+
+/*
+Copyright 2021 G. Benjamin Morrison
+
+This file is part of getwtxt-ng.
+
+getwtxt-ng is free software: you can redistribute it and/or modify
+it under the terms of the GNU Affero General Public License as
+published by the Free Software Foundation, either version 3 of the
+License, or (at your option) any later version.
+
+getwtxt-ng is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU Affero General Public License for more details.
+
+You should have received a copy of the GNU Affero General Public License
+along with getwtxt-ng.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestDB_ApproveUser(t *testing.T) {
+	memDB := getPopulatedDB(t)
+	ctx := context.Background()
+
+	var insertedUsers, approved []User
+	tweetsNotified := 0
+	memDB.Hooks.UsersInserted = func(_ context.Context, users []User) { insertedUsers = append(insertedUsers, users...) }
+	memDB.Hooks.UsersApproved = func(_ context.Context, users []User) { approved = append(approved, users...) }
+	memDB.Hooks.TweetsInserted = func(_ context.Context, tweets []Tweet) { tweetsNotified += len(tweets) }
+
+	u := User{Nick: "spammy", URL: "https://spammy.example/twtxt.txt", PasscodeHash: []byte("hash"), Status: UserStatusPendingApproval}
+	tweets := []Tweet{{DateTime: time.Date(2022, 1, 1, 0, 0, 0, 0, time.UTC), Body: "buy now"}}
+	if _, err := memDB.InsertUserWithTweets(ctx, &u, tweets); err != nil {
+		t.Fatal(err.Error())
+	}
+	if len(insertedUsers) != 1 || insertedUsers[0].Status != UserStatusPendingApproval {
+		t.Errorf("Expected the insert hook to get the pending user, got %+v", insertedUsers)
+	}
+	if tweetsNotified != 0 {
+		t.Errorf("Expected the pending user's tweets not to be passed along, got %d", tweetsNotified)
+	}
+
+	if listed, err := memDB.GetUsersByID(ctx, []string{u.ID}); err != nil || len(listed) != 0 {
+		t.Errorf("Expected a user pending approval to be left out of listings, got %d, %v", len(listed), err)
+	}
+	due, err := memDB.GetUsersDueForSync(ctx, 0, time.Now())
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	synced := false
+	for _, d := range due {
+		if d.ID == u.ID {
+			synced = d.Status == UserStatusPendingApproval
+		}
+	}
+	if !synced {
+		t.Errorf("Expected a user pending approval to be synced, got %+v", due)
+	}
+
+	pending, err := memDB.GetPendingApprovals(ctx)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	if len(pending) != 1 || pending[0].URL != u.URL {
+		t.Fatalf("Expected the one user pending approval, got %+v", pending)
+	}
+
+	if err := memDB.ApproveUser(ctx, &pending[0]); err != nil {
+		t.Fatal(err.Error())
+	}
+	if len(approved) != 1 || approved[0].Status != UserStatusActive {
+		t.Errorf("Expected the approval hook to get the active user, got %+v", approved)
+	}
+	if listed, err := memDB.GetUsersByID(ctx, []string{u.ID}); err != nil || len(listed) != 1 {
+		t.Errorf("Expected the approved user to be listed, got %d, %v", len(listed), err)
+	}
+	if err := memDB.ApproveUser(ctx, &pending[0]); !errors.Is(err, ErrUserNotPendingApproval) {
+		t.Errorf("Expected ErrUserNotPendingApproval approving again, got %v", err)
+	}
+}
@@ -0,0 +1,441 @@
+package registry
+
+/*
+Copyright 2021 G. Benjamin Morrison
+
+This file is part of getwtxt-ng.
+
+getwtxt-ng is free software: you can redistribute it and/or modify
+it under the terms of the GNU Affero General Public License as
+published by the Free Software Foundation, either version 3 of the
+License, or (at your option) any later version.
+
+getwtxt-ng is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU Affero General Public License for more details.
+
+You should have received a copy of the GNU Affero General Public License
+along with getwtxt-ng.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// A blocklist may be up to 4 MiB, and its lines up to 4 KiB. Longer lines are skipped.
+const (
+	blocklistMaxBytes     = 4 << 20
+	blocklistMaxLineBytes = 4 << 10
+)
+
+// ErrUserBanned is returned when registering a feed that matches a ban.
+var ErrUserBanned = errors.New("feed is banned from this registry")
+
+// ErrInvalidBanPattern is returned for a ban that's neither a domain nor a feed URL.
+var ErrInvalidBanPattern = errors.New("invalid ban pattern")
+
+// ErrBanNotFound is returned when removing a ban that doesn't exist.
+var ErrBanNotFound = fmt.Errorf("ban not found: %w", sql.ErrNoRows)
+
+// Ban keeps a domain, along with its subdomains, or a single feed from being registered or synced.
+type Ban struct {
+	ID string `json:"id"`
+
+	// Pattern is a domain, such as example.com, or the canonical form of a feed's URL, such as
+	// example.com/twtxt.txt. Domains never contain a slash and feed URLs always do.
+	Pattern string `json:"pattern"`
+
+	// Source is the URL of the blocklist the ban came from, or empty if it was added here.
+	Source string `json:"source,omitempty"`
+
+	DateTimeAdded time.Time `json:"dt_added"`
+}
+
+// NormalizeBanPattern returns the form bans are stored in: the lowercased domain, without a leading *. or www.,
+// or the canonical form of a URL. A domain needs at least two labels, so a whole TLD can't be banned by mistake.
+func NormalizeBanPattern(pattern string) (string, error) {
+	p := strings.ToLower(strings.TrimSpace(pattern))
+	if strings.Contains(p, "://") {
+		canonical, err := CanonicalURL(p)
+		if err != nil {
+			return "", fmt.Errorf("%w: %s", ErrInvalidBanPattern, pattern)
+		}
+		return canonical, nil
+	}
+
+	p = strings.TrimSuffix(strings.TrimPrefix(strings.TrimPrefix(p, "*."), "www."), ".")
+	if p == "" || strings.ContainsAny(p, "/?#@:* \t") || strings.HasPrefix(p, ".") {
+		return "", fmt.Errorf("%w: %s", ErrInvalidBanPattern, pattern)
+	}
+	if !strings.Contains(p, ".") {
+		return "", fmt.Errorf("%w: %s is a top-level domain", ErrInvalidBanPattern, pattern)
+	}
+	return p, nil
+}
+
+// banCandidates returns the patterns that would ban the feed at feedURL: its canonical URL, its domain,
+// and each domain above that.
+func banCandidates(feedURL string) ([]string, error) {
+	canonical, err := CanonicalURL(feedURL)
+	if err != nil {
+		return nil, err
+	}
+	host := canonical[:strings.Index(canonical, "/")]
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
+
+	candidates := []string{canonical}
+	for host != "" {
+		candidates = append(candidates, host)
+		dot := strings.Index(host, ".")
+		if dot < 0 {
+			break
+		}
+		host = host[dot+1:]
+	}
+	return candidates, nil
+}
+
+// rowQuerier is what findBan needs from either the DB or a transaction.
+type rowQuerier interface {
+	QueryRowContext(ctx context.Context, query string, args ...interface{}) *sql.Row
+}
+
+// findBan returns the ban matching the feed at feedURL, or nil if there isn't one.
+func findBan(ctx context.Context, q rowQuerier, feedURL string) (*Ban, error) {
+	candidates, err := banCandidates(feedURL)
+	if err != nil {
+		return nil, nil
+	}
+	args := make([]interface{}, 0, len(candidates))
+	for _, c := range candidates {
+		args = append(args, c)
+	}
+
+	ban := Ban{}
+	dt := int64(0)
+	err = q.QueryRowContext(ctx, findBanQuery(len(candidates)), args...).Scan(&ban.ID, &ban.Pattern, &ban.Source, &dt)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("when looking for bans of %s: %w", feedURL, err)
+	}
+	ban.DateTimeAdded = time.Unix(0, dt).UTC()
+
+	return &ban, nil
+}
+
+// findBanQuery returns the query for the ban matching any of n candidate patterns, preferring those added here.
+func findBanQuery(n int) string {
+	placeholders := strings.TrimSuffix(strings.Repeat("?,", n), ",")
+	return fmt.Sprintf("SELECT id, pattern, source, dt_added FROM bans WHERE pattern IN (%s) ORDER BY source = '' DESC, id ASC LIMIT 1", placeholders)
+}
+
+// FindBan returns the ban keeping the feed at feedURL out of the registry, or nil if there isn't one.
+// Bans added here are preferred over those from blocklists.
+func (d *DB) FindBan(ctx context.Context, feedURL string) (*Ban, error) {
+	ctx, cancel := d.withQueryTimeout(ctx)
+	defer cancel()
+
+	return findBan(ctx, d.conn, feedURL)
+}
+
+// GetBans retrieves every ban, those added here and those from blocklists, ordered by pattern.
+func (d *DB) GetBans(ctx context.Context) ([]Ban, error) {
+	ctx, cancel := d.withQueryTimeout(ctx)
+	defer cancel()
+
+	rows, err := d.conn.QueryContext(ctx, "SELECT id, pattern, source, dt_added FROM bans ORDER BY pattern ASC, source ASC")
+	if err != nil {
+		return nil, fmt.Errorf("when querying for bans: %w", err)
+	}
+	defer func() {
+		_ = rows.Close()
+	}()
+
+	bans := make([]Ban, 0)
+	for rows.Next() {
+		ban := Ban{}
+		dt := int64(0)
+		if err := rows.Scan(&ban.ID, &ban.Pattern, &ban.Source, &dt); err != nil {
+			return nil, fmt.Errorf("when scanning ban: %w", err)
+		}
+		ban.DateTimeAdded = time.Unix(0, dt).UTC()
+		bans = append(bans, ban)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("when reading bans: %w", err)
+	}
+
+	return bans, nil
+}
+
+// AddBan bans the domain or feed URL in pattern here, independent of any blocklist.
+func (d *DB) AddBan(ctx context.Context, pattern string) (Ban, error) {
+	normalized, err := NormalizeBanPattern(pattern)
+	if err != nil {
+		return Ban{}, err
+	}
+
+	tx, err := d.beginWrite(ctx)
+	if err != nil {
+		return Ban{}, fmt.Errorf("when beginning tx to ban %s: %w", normalized, err)
+	}
+	defer func() {
+		_ = tx.Rollback()
+	}()
+
+	now := time.Now().UTC()
+	stmt := "INSERT INTO bans (pattern, source, dt_added) VALUES (?, '', ?) ON CONFLICT (pattern, source) DO NOTHING"
+	if _, err := tx.ExecContext(ctx, stmt, normalized, now.UnixNano()); err != nil {
+		return Ban{}, fmt.Errorf("when banning %s: %w", normalized, err)
+	}
+	ban := Ban{Pattern: normalized}
+	dt := int64(0)
+	if err := tx.QueryRowContext(ctx, "SELECT id, dt_added FROM bans WHERE pattern = ? AND source = ''", normalized).Scan(&ban.ID, &dt); err != nil {
+		return Ban{}, fmt.Errorf("when reading ban of %s: %w", normalized, err)
+	}
+	ban.DateTimeAdded = time.Unix(0, dt).UTC()
+
+	if err := tx.Commit(); err != nil {
+		return Ban{}, fmt.Errorf("when committing tx to ban %s: %w", normalized, err)
+	}
+	d.invalidate()
+
+	return ban, nil
+}
+
+// DeleteBan removes the ban with the provided ID. A ban from a blocklist comes back the next time the list is
+// refreshed, if it's still listed.
+func (d *DB) DeleteBan(ctx context.Context, id string) error {
+	tx, err := d.beginWrite(ctx)
+	if err != nil {
+		return fmt.Errorf("when beginning tx to remove ban %s: %w", id, err)
+	}
+	defer func() {
+		_ = tx.Rollback()
+	}()
+
+	res, err := tx.ExecContext(ctx, "DELETE FROM bans WHERE id = ?", id)
+	if err != nil {
+		return fmt.Errorf("when removing ban %s: %w", id, err)
+	}
+	if n, err := res.RowsAffected(); err != nil {
+		return fmt.Errorf("when removing ban %s: %w", id, err)
+	} else if n == 0 {
+		return fmt.Errorf("when removing ban %s: %w", id, ErrBanNotFound)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("when committing tx to remove ban %s: %w", id, err)
+	}
+	d.invalidate()
+
+	return nil
+}
+
+// ParseBlocklist reads a blocklist: one domain or feed URL per line. Blank lines and those starting with #
+// are ignored. Returns the normalized patterns, and the number of lines skipped because they weren't either.
+func ParseBlocklist(r io.Reader) ([]string, int, error) {
+	patterns := make([]string, 0)
+	seen := make(map[string]bool)
+	invalid := 0
+
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 0, 4096), blocklistMaxLineBytes)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		pattern, err := NormalizeBanPattern(line)
+		if err != nil {
+			invalid++
+			continue
+		}
+		if !seen[pattern] {
+			seen[pattern] = true
+			patterns = append(patterns, pattern)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, invalid, fmt.Errorf("when reading blocklist: %w", err)
+	}
+
+	return patterns, invalid, nil
+}
+
+// FetchBlocklist downloads and parses the blocklist at listURL. Returns the patterns listed and the number of
+// lines that weren't valid.
+func (d *DB) FetchBlocklist(ctx context.Context, listURL string) ([]string, int, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, listURL, nil)
+	if err != nil {
+		return nil, 0, fmt.Errorf("couldn't create http request to fetch blocklist %s: %w", listURL, err)
+	}
+	resp, err := d.Client.Do(req)
+	if err != nil {
+		return nil, 0, fmt.Errorf("error making http request to %s: %w", listURL, err)
+	}
+	defer func() {
+		_ = resp.Body.Close()
+	}()
+	if resp.StatusCode != http.StatusOK {
+		return nil, 0, fmt.Errorf("got status code %d from %s", resp.StatusCode, listURL)
+	}
+
+	body, err := io.ReadAll(io.LimitReader(resp.Body, blocklistMaxBytes+1))
+	if err != nil {
+		return nil, 0, fmt.Errorf("when reading blocklist %s: %w", listURL, err)
+	}
+	if len(body) > blocklistMaxBytes {
+		return nil, 0, fmt.Errorf("blocklist %s is larger than %d bytes", listURL, blocklistMaxBytes)
+	}
+
+	return ParseBlocklist(bytes.NewReader(body))
+}
+
+// ReplaceBlocklist makes the bans from the blocklist at source match patterns, adding the new ones and
+// removing those no longer listed. Returns how many were added and removed.
+func (d *DB) ReplaceBlocklist(ctx context.Context, source string, patterns []string) (int64, int64, error) {
+	if strings.TrimSpace(source) == "" {
+		return 0, 0, errors.New("blocklist has no source")
+	}
+
+	tx, err := d.beginWrite(ctx)
+	if err != nil {
+		return 0, 0, fmt.Errorf("when beginning tx to replace blocklist %s: %w", source, err)
+	}
+	defer func() {
+		_ = tx.Rollback()
+	}()
+
+	listed := make(map[string]bool, len(patterns))
+	for _, p := range patterns {
+		listed[p] = true
+	}
+	rows, err := tx.QueryContext(ctx, "SELECT id, pattern FROM bans WHERE source = ?", source)
+	if err != nil {
+		return 0, 0, fmt.Errorf("when querying for bans from %s: %w", source, err)
+	}
+	stale := make([]string, 0)
+	existing := make(map[string]bool)
+	for rows.Next() {
+		id, pattern := "", ""
+		if err := rows.Scan(&id, &pattern); err != nil {
+			_ = rows.Close()
+			return 0, 0, fmt.Errorf("when scanning ban from %s: %w", source, err)
+		}
+		existing[pattern] = true
+		if !listed[pattern] {
+			stale = append(stale, id)
+		}
+	}
+	err = rows.Err()
+	_ = rows.Close()
+	if err != nil {
+		return 0, 0, fmt.Errorf("when reading bans from %s: %w", source, err)
+	}
+
+	for _, id := range stale {
+		if _, err := tx.ExecContext(ctx, "DELETE FROM bans WHERE id = ?", id); err != nil {
+			return 0, 0, fmt.Errorf("when removing ban %s from %s: %w", id, source, err)
+		}
+	}
+	added := int64(0)
+	now := time.Now().UnixNano()
+	for p := range listed {
+		if existing[p] {
+			continue
+		}
+		if _, err := tx.ExecContext(ctx, "INSERT INTO bans (pattern, source, dt_added) VALUES (?, ?, ?)", p, source, now); err != nil {
+			return 0, 0, fmt.Errorf("when adding ban of %s from %s: %w", p, source, err)
+		}
+		added++
+	}
+
+	if err := tx.Commit(); err != nil {
+		return 0, 0, fmt.Errorf("when committing tx to replace blocklist %s: %w", source, err)
+	}
+	if added > 0 || len(stale) > 0 {
+		d.invalidate()
+	}
+
+	return added, int64(len(stale)), nil
+}
+
+// DeleteBlocklistsExcept removes the bans from every blocklist other than those in sources, so unsubscribing
+// from a list lifts its bans. Bans added here are kept. Returns how many were removed.
+func (d *DB) DeleteBlocklistsExcept(ctx context.Context, sources []string) (int64, error) {
+	tx, err := d.beginWrite(ctx)
+	if err != nil {
+		return 0, fmt.Errorf("when beginning tx to remove bans from old blocklists: %w", err)
+	}
+	defer func() {
+		_ = tx.Rollback()
+	}()
+
+	stmt := "DELETE FROM bans WHERE source != ''"
+	args := make([]interface{}, 0, len(sources))
+	if len(sources) > 0 {
+		for _, s := range sources {
+			args = append(args, s)
+		}
+		stmt += fmt.Sprintf(" AND source NOT IN (%s)", strings.TrimSuffix(strings.Repeat("?,", len(sources)), ","))
+	}
+	res, err := tx.ExecContext(ctx, stmt, args...)
+	if err != nil {
+		return 0, fmt.Errorf("when removing bans from old blocklists: %w", err)
+	}
+	removed, err := res.RowsAffected()
+	if err != nil {
+		return 0, fmt.Errorf("when removing bans from old blocklists: %w", err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return 0, fmt.Errorf("when committing tx to remove bans from old blocklists: %w", err)
+	}
+	if removed > 0 {
+		d.invalidate()
+	}
+
+	return removed, nil
+}
+
+// withoutBanned leaves out the users whose feeds are banned.
+func (d *DB) withoutBanned(ctx context.Context, users []User) ([]User, error) {
+	bans := 0
+	if err := d.conn.QueryRowContext(ctx, "SELECT COUNT(*) FROM bans").Scan(&bans); err != nil {
+		return nil, fmt.Errorf("when counting bans: %w", err)
+	}
+	if bans == 0 {
+		return users, nil
+	}
+
+	kept := make([]User, 0, len(users))
+	for _, u := range users {
+		ban, err := findBan(ctx, d.conn, u.URL)
+		if err != nil {
+			return nil, err
+		}
+		if ban != nil {
+			d.logger.Debugf("Not syncing %s: banned by %s", u.URL, ban.Pattern)
+			continue
+		}
+		kept = append(kept, u)
+	}
+
+	return kept, nil
+}
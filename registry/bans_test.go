@@ -0,0 +1,169 @@
+package registry
+
+/*
+Copyright 2021 G. Benjamin Morrison
+
+This file is part of getwtxt-ng.
+
+getwtxt-ng is free software: you can redistribute it and/or modify
+it under the terms of the GNU Affero General Public License as
+published by the Free Software Foundation, either version 3 of the
+License, or (at your option) any later version.
+
+getwtxt-ng is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU Affero General Public License for more details.
+
+You should have received a copy of the GNU Affero General Public License
+along with getwtxt-ng.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestNormalizeBanPattern(t *testing.T) {
+	tests := map[string]string{
+		"Spam.Example":                          "spam.example",
+		"*.spam.example.":                       "spam.example",
+		"www.spam.example":                      "spam.example",
+		"https://www.Spam.example/twtxt.txt":    "spam.example/twtxt.txt",
+		"http://spam.example:80/a/../twtxt.txt": "spam.example/twtxt.txt",
+	}
+	for in, want := range tests {
+		if got, err := NormalizeBanPattern(in); err != nil || got != want {
+			t.Errorf("%s: expected %s, got %s, %v", in, want, got, err)
+		}
+	}
+	for _, in := range []string{"", "spam.example/twtxt.txt", "user@spam.example", ".example", "https://", "com", "*.com", "www.com"} {
+		if _, err := NormalizeBanPattern(in); !errors.Is(err, ErrInvalidBanPattern) {
+			t.Errorf("%q: expected ErrInvalidBanPattern, got %v", in, err)
+		}
+	}
+}
+
+func TestParseBlocklist(t *testing.T) {
+	list := "# shared spam list\n\nspam.example\nhttps://bad.example/twtxt.txt\nnot a domain\nSPAM.example\n"
+	patterns, invalid, err := ParseBlocklist(strings.NewReader(list))
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	if want := []string{"spam.example", "bad.example/twtxt.txt"}; !reflect.DeepEqual(patterns, want) || invalid != 1 {
+		t.Errorf("Expected %v and 1 invalid line, got %v and %d", want, patterns, invalid)
+	}
+}
+
+func TestDB_Bans(t *testing.T) {
+	memDB := getPopulatedDB(t)
+	ctx := context.Background()
+
+	local, err := memDB.AddBan(ctx, "spam.example")
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	if again, err := memDB.AddBan(ctx, "SPAM.example"); err != nil || again.ID != local.ID {
+		t.Errorf("Expected banning the same domain twice to keep one ban, got %+v, %v", again, err)
+	}
+
+	listURL := "https://lists.example/blocklist.txt"
+	added, removed, err := memDB.ReplaceBlocklist(ctx, listURL, []string{"spam.example", "bad.example/twtxt.txt", "worse.example"})
+	if err != nil || added != 3 || removed != 0 {
+		t.Fatalf("Expected 3 bans added, got %d added, %d removed, %v", added, removed, err)
+	}
+	added, removed, err = memDB.ReplaceBlocklist(ctx, listURL, []string{"spam.example", "bad.example/twtxt.txt"})
+	if err != nil || added != 0 || removed != 1 {
+		t.Errorf("Expected the delisted ban to be removed, got %d added, %d removed, %v", added, removed, err)
+	}
+
+	tests := map[string]string{
+		"https://spam.example/twtxt.txt":      "spam.example",
+		"https://sub.spam.example/twtxt.txt":  "spam.example",
+		"http://www.bad.example/twtxt.txt":    "bad.example/twtxt.txt",
+		"https://bad.example/other/twtxt.txt": "",
+		"https://worse.example/twtxt.txt":     "",
+		"https://notspam.example/twtxt.txt":   "",
+		"https://spam.example:8080/twtxt.txt": "spam.example",
+	}
+	for feedURL, want := range tests {
+		ban, err := memDB.FindBan(ctx, feedURL)
+		if err != nil {
+			t.Fatal(err.Error())
+		}
+		got := ""
+		if ban != nil {
+			got = ban.Pattern
+		}
+		if got != want {
+			t.Errorf("%s: expected ban %q, got %q", feedURL, want, got)
+		}
+	}
+	// The local ban is preferred over the list's.
+	if ban, _ := memDB.FindBan(ctx, "https://spam.example/twtxt.txt"); ban == nil || ban.Source != "" {
+		t.Errorf("Expected the local ban, got %+v", ban)
+	}
+
+	u := User{Nick: "spammer", URL: "https://spam.example/twtxt.txt", PasscodeHash: []byte("hash")}
+	if err := memDB.InsertUser(ctx, &u); !errors.Is(err, ErrUserBanned) {
+		t.Errorf("Expected ErrUserBanned, got %v", err)
+	}
+	bulk, err := memDB.InsertUsers(ctx, []User{{Nick: "bad", URL: "https://bad.example/twtxt.txt"}, {Nick: "good", URL: "https://good.example/twtxt.txt"}})
+	if err != nil || len(bulk) != 1 || bulk[0].Nick != "good" {
+		t.Errorf("Expected only the unbanned user to be added in bulk, got %+v, %v", bulk, err)
+	}
+
+	// Users registered before a ban aren't synced once it's in place.
+	if _, err := memDB.AddBan(ctx, "good.example"); err != nil {
+		t.Fatal(err.Error())
+	}
+	due, err := memDB.GetUsersDueForSync(ctx, 0, time.Now())
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	for _, d := range due {
+		if d.URL == "https://good.example/twtxt.txt" {
+			t.Error("Expected the banned feed not to be due for sync")
+		}
+	}
+
+	bans, err := memDB.GetBans(ctx)
+	if err != nil || len(bans) != 4 {
+		t.Fatalf("Expected 4 bans, got %d, %v", len(bans), err)
+	}
+	if removed, err := memDB.DeleteBlocklistsExcept(ctx, nil); err != nil || removed != 2 {
+		t.Errorf("Expected the list's 2 bans to be removed, got %d, %v", removed, err)
+	}
+	if err := memDB.DeleteBan(ctx, local.ID); err != nil {
+		t.Error(err.Error())
+	}
+	if err := memDB.DeleteBan(ctx, local.ID); !errors.Is(err, ErrBanNotFound) {
+		t.Errorf("Expected ErrBanNotFound, got %v", err)
+	}
+}
+
+func TestDB_FetchBlocklist(t *testing.T) {
+	memDB := getPopulatedDB(t)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/list.txt" {
+			http.NotFound(w, r)
+			return
+		}
+		_, _ = w.Write([]byte("# pooled\nspam.example\n"))
+	}))
+	defer srv.Close()
+
+	patterns, invalid, err := memDB.FetchBlocklist(context.Background(), srv.URL+"/list.txt")
+	if err != nil || len(patterns) != 1 || patterns[0] != "spam.example" || invalid != 0 {
+		t.Errorf("Expected the one pattern, got %v, %d, %v", patterns, invalid, err)
+	}
+	if _, _, err := memDB.FetchBlocklist(context.Background(), srv.URL+"/missing.txt"); err == nil {
+		t.Error("Expected an error for a missing list")
+	}
+}
@@ -0,0 +1,163 @@
+package registry
+
+/*
+Copyright 2021 G. Benjamin Morrison
+
+This file is part of getwtxt-ng.
+
+getwtxt-ng is free software: you can redistribute it and/or modify
+it under the terms of the GNU Affero General Public License as
+published by the Free Software Foundation, either version 3 of the
+License, or (at your option) any later version.
+
+getwtxt-ng is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU Affero General Public License for more details.
+
+You should have received a copy of the GNU Affero General Public License
+along with getwtxt-ng.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// Sizes of the database the query benchmarks run against.
+const (
+	benchUsers         = 100
+	benchTweetsPerUser = 200
+)
+
+// getBenchDB returns an in-memory database seeded with synthetic users and tweets, without a read cache,
+// so every iteration reaches SQLite.
+func getBenchDB(b *testing.B) (*DB, []User) {
+	b.Helper()
+	db, err := Open(":memory:")
+	if err != nil {
+		b.Fatal(err.Error())
+	}
+	b.Cleanup(func() {
+		_ = db.Close()
+	})
+	users, err := db.SeedSynthetic(context.Background(), benchUsers, benchTweetsPerUser)
+	if err != nil {
+		b.Fatal(err.Error())
+	}
+
+	return db, users
+}
+
+func BenchmarkDB_GetTweets(b *testing.B) {
+	db, _ := getBenchDB(b)
+	ctx := context.Background()
+	for _, page := range []int{1, 50} {
+		b.Run(fmt.Sprintf("page %d", page), func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				if _, err := db.GetTweets(ctx, page, 20, StatusVisible); err != nil {
+					b.Fatal(err.Error())
+				}
+			}
+		})
+	}
+}
+
+func BenchmarkDB_GetUsers(b *testing.B) {
+	db, _ := getBenchDB(b)
+	ctx := context.Background()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, err := db.GetUsers(ctx, 1, 20); err != nil {
+			b.Fatal(err.Error())
+		}
+	}
+}
+
+func BenchmarkDB_GetUserTweets(b *testing.B) {
+	db, users := getBenchDB(b)
+	ctx := context.Background()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, err := db.GetUserTweets(ctx, users[i%len(users)].ID, 20); err != nil {
+			b.Fatal(err.Error())
+		}
+	}
+}
+
+func BenchmarkDB_SearchTweets(b *testing.B) {
+	db, _ := getBenchDB(b)
+	ctx := context.Background()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, err := db.SearchTweets(ctx, 1, 20, "words", StatusVisible); err != nil {
+			b.Fatal(err.Error())
+		}
+	}
+}
+
+func BenchmarkDB_GetTags(b *testing.B) {
+	db, _ := getBenchDB(b)
+	ctx := context.Background()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, err := db.GetTags(ctx, 1, 20, StatusVisible); err != nil {
+			b.Fatal(err.Error())
+		}
+	}
+}
+
+func BenchmarkDB_GetMentions(b *testing.B) {
+	db, _ := getBenchDB(b)
+	ctx := context.Background()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, err := db.GetMentions(ctx, 1, 20, StatusVisible); err != nil {
+			b.Fatal(err.Error())
+		}
+	}
+}
+
+func BenchmarkDB_SyncUsers(b *testing.B) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		builder := strings.Builder{}
+		for _, tweet := range SyntheticTweets("http://"+r.Host+r.URL.Path, benchTweetsPerUser) {
+			builder.WriteString(tweet.DateTime.Format(time.RFC3339))
+			builder.WriteString("\t")
+			builder.WriteString(tweet.Body)
+			builder.WriteString("\n")
+		}
+		w.Header().Set("Content-Type", "text/plain")
+		_, _ = w.Write([]byte(builder.String()))
+	}))
+	defer srv.Close()
+	ctx := context.Background()
+
+	for i := 0; i < b.N; i++ {
+		b.StopTimer()
+		db, err := Open(":memory:")
+		if err != nil {
+			b.Fatal(err.Error())
+		}
+		db.Client = srv.Client()
+		users := make([]User, 0, 20)
+		for j := 0; j < cap(users); j++ {
+			u := User{Nick: fmt.Sprintf("feed%d", j), URL: fmt.Sprintf("%s/feed%d/twtxt.txt", srv.URL, j), PasscodeHash: []byte("not a real hash")}
+			if err := db.InsertUser(ctx, &u); err != nil {
+				b.Fatal(err.Error())
+			}
+			users = append(users, u)
+		}
+		b.StartTimer()
+		if _, err := db.SyncUsers(ctx, users); err != nil {
+			b.Fatal(err.Error())
+		}
+		b.StopTimer()
+		_ = db.Close()
+	}
+}
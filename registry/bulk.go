@@ -0,0 +1,137 @@
+package registry
+
+/*
+Copyright 2021 G. Benjamin Morrison
+
+This file is part of getwtxt-ng.
+
+getwtxt-ng is free software: you can redistribute it and/or modify
+it under the terms of the GNU Affero General Public License as
+published by the Free Software Foundation, either version 3 of the
+License, or (at your option) any later version.
+
+getwtxt-ng is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU Affero General Public License for more details.
+
+You should have received a copy of the GNU Affero General Public License
+along with getwtxt-ng.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+import (
+	"context"
+	"fmt"
+)
+
+// searchTriggers keep tweets_search in step with tweets. They must match the ones created by migration 2.
+var searchTriggers = []struct {
+	name   string
+	create string
+}{
+	{
+		name: "tweetsInsert",
+		create: `CREATE TRIGGER IF NOT EXISTS tweetsInsert AFTER INSERT ON tweets
+				BEGIN
+					INSERT INTO tweets_search (
+						ROWID, id, user_id, nick, url, dt, body, contains_mentions, contains_tags, hidden
+					) SELECT id, id, user_id, nick, url, dt, body, contains_mentions, contains_tags, hidden
+					FROM tweets_users WHERE id = NEW.id;
+				END`,
+	},
+	{
+		name: "tweetsDelete",
+		create: `CREATE TRIGGER IF NOT EXISTS tweetsDelete AFTER DELETE ON tweets
+				BEGIN
+					INSERT INTO tweets_search (
+						tweets_search, ROWID, id, user_id, nick, url, dt, body, contains_mentions, contains_tags, hidden
+					) SELECT 'delete', OLD.id, OLD.id, OLD.user_id, users.nick, users.url, OLD.dt, OLD.body,
+						OLD.contains_mentions, OLD.contains_tags, OLD.hidden
+					FROM users WHERE users.id = OLD.user_id;
+				END`,
+	},
+	{
+		name: "tweetsUpdate",
+		create: `CREATE TRIGGER IF NOT EXISTS tweetsUpdate AFTER UPDATE ON tweets
+				BEGIN
+					INSERT INTO tweets_search (
+						tweets_search, ROWID, id, user_id, nick, url, dt, body, contains_mentions, contains_tags, hidden
+					) SELECT 'delete', OLD.id, OLD.id, OLD.user_id, users.nick, users.url, OLD.dt, OLD.body,
+						OLD.contains_mentions, OLD.contains_tags, OLD.hidden
+					FROM users WHERE users.id = OLD.user_id;
+					INSERT INTO tweets_search (
+						ROWID, id, user_id, nick, url, dt, body, contains_mentions, contains_tags, hidden
+					) SELECT id, id, user_id, nick, url, dt, body, contains_mentions, contains_tags, hidden
+					FROM tweets_users WHERE id = NEW.id;
+				END`,
+	},
+}
+
+// BulkImportBatchSize is how many tweets to pass to each InsertTweets call during a bulk import.
+// Larger batches mean fewer transactions, at the cost of holding the write lock for longer.
+const BulkImportBatchSize = 10000
+
+// BulkImport runs fn with the search index's triggers dropped, so tweets inserted by fn don't update
+// the index one at a time, then rebuilds the index once at the end. This is much faster for importing
+// large archives, especially when fn inserts tweets in batches of BulkImportBatchSize.
+// Searches won't find anything changed by fn until it returns. If the process dies before the triggers
+// are restored, Open restores them and rebuilds the index.
+func (d *DB) BulkImport(ctx context.Context, fn func(ctx context.Context) error) error {
+	tx, err := d.beginWrite(ctx)
+	if err != nil {
+		return fmt.Errorf("when beginning tx to drop search triggers: %w", err)
+	}
+	for _, trigger := range searchTriggers {
+		if _, err := tx.ExecContext(ctx, "DROP TRIGGER IF EXISTS "+trigger.name); err != nil {
+			_ = tx.Rollback()
+			return fmt.Errorf("when dropping search trigger %s: %w", trigger.name, err)
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("when committing tx to drop search triggers: %w", err)
+	}
+
+	importErr := fn(ctx)
+
+	// The index has to be restored even if the import was cancelled.
+	if err := d.restoreSearchTriggers(context.Background()); err != nil {
+		if importErr != nil {
+			return fmt.Errorf("%w (and when restoring the search index: %s)", importErr, err)
+		}
+		return err
+	}
+
+	return importErr
+}
+
+// restoreSearchTriggers recreates any missing search triggers, then rebuilds the search index
+// so it includes whatever was changed while they were gone.
+func (d *DB) restoreSearchTriggers(ctx context.Context) error {
+	tx, err := d.beginWrite(ctx)
+	if err != nil {
+		return fmt.Errorf("when beginning tx to restore search triggers: %w", err)
+	}
+	for _, trigger := range searchTriggers {
+		if _, err := tx.ExecContext(ctx, trigger.create); err != nil {
+			_ = tx.Rollback()
+			return fmt.Errorf("when restoring search trigger %s: %w", trigger.name, err)
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("when committing tx to restore search triggers: %w", err)
+	}
+
+	return d.RebuildSearchIndex(ctx)
+}
+
+// missingSearchTriggers reports whether any search trigger has been dropped, which means a bulk import didn't finish.
+func (d *DB) missingSearchTriggers(ctx context.Context) (bool, error) {
+	stmt := `SELECT COUNT(*) FROM sqlite_master WHERE type = 'trigger' AND name IN (?, ?, ?)`
+	count := 0
+	err := d.conn.QueryRowContext(ctx, stmt, searchTriggers[0].name, searchTriggers[1].name, searchTriggers[2].name).Scan(&count)
+	if err != nil {
+		return false, fmt.Errorf("when looking up search triggers: %w", err)
+	}
+
+	return count < len(searchTriggers), nil
+}
@@ -0,0 +1,113 @@
+package registry
+
+/*
+Copyright 2021 G. Benjamin Morrison
+
+This file is part of getwtxt-ng.
+
+getwtxt-ng is free software: you can redistribute it and/or modify
+it under the terms of the GNU Affero General Public License as
+published by the Free Software Foundation, either version 3 of the
+License, or (at your option) any later version.
+
+getwtxt-ng is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU Affero General Public License for more details.
+
+You should have received a copy of the GNU Affero General Public License
+along with getwtxt-ng.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+import (
+	"context"
+	"errors"
+	"path/filepath"
+	"testing"
+)
+
+func TestDB_BulkImport(t *testing.T) {
+	db, err := Open(":memory:")
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	defer func() {
+		_ = db.Close()
+	}()
+	ctx := context.Background()
+
+	err = db.BulkImport(ctx, func(ctx context.Context) error {
+		if _, err := db.SeedSynthetic(ctx, 3, 10); err != nil {
+			return err
+		}
+		found, err := db.SearchTweets(ctx, 1, 20, "words", StatusVisible)
+		if err != nil {
+			return err
+		}
+		if len(found) != 0 {
+			t.Errorf("Expected the search index to be left alone during the import, found %d tweets", len(found))
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+
+	missing, err := db.missingSearchTriggers(ctx)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	if missing {
+		t.Error("Expected search triggers to be restored")
+	}
+	found, err := db.SearchTweets(ctx, 1, 100, "words", StatusVisible)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	if len(found) != 30 {
+		t.Errorf("Expected all 30 imported tweets to be searchable, found %d", len(found))
+	}
+
+	t.Run("failed import", func(t *testing.T) {
+		failure := errors.New("archive is corrupt")
+		if err := db.BulkImport(ctx, func(ctx context.Context) error { return failure }); !errors.Is(err, failure) {
+			t.Errorf("Expected the import's error, got: %v", err)
+		}
+		if missing, _ := db.missingSearchTriggers(ctx); missing {
+			t.Error("Expected search triggers to be restored after a failed import")
+		}
+	})
+}
+
+func TestOpen_interruptedBulkImport(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "bulk.db")
+	db, err := Open(path)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	ctx := context.Background()
+	for _, trigger := range searchTriggers {
+		if _, err := db.conn.ExecContext(ctx, "DROP TRIGGER "+trigger.name); err != nil {
+			t.Fatal(err.Error())
+		}
+	}
+	if _, err := db.SeedSynthetic(ctx, 1, 5); err != nil {
+		t.Fatal(err.Error())
+	}
+	_ = db.Close()
+
+	db, err = Open(path)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	defer func() {
+		_ = db.Close()
+	}()
+	found, err := db.SearchTweets(ctx, 1, 20, "words", StatusVisible)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	if len(found) != 5 {
+		t.Errorf("Expected the search index to be rebuilt on open, found %d of 5 tweets", len(found))
+	}
+}
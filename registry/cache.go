@@ -0,0 +1,128 @@
+package registry
+
+/*
+Copyright 2021 G. Benjamin Morrison
+
+This file is part of getwtxt-ng.
+
+getwtxt-ng is free software: you can redistribute it and/or modify
+it under the terms of the GNU Affero General Public License as
+published by the Free Software Foundation, either version 3 of the
+License, or (at your option) any later version.
+
+getwtxt-ng is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU Affero General Public License for more details.
+
+You should have received a copy of the GNU Affero General Public License
+along with getwtxt-ng.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+import (
+	"fmt"
+	"sync"
+	"time"
+)
+
+// readCache holds the results of frequently repeated read queries, such as the first few pages of
+// tweets and users that the landing page and polling clients ask for over and over.
+// Entries expire after ttl, and the whole cache is dropped whenever a write is committed.
+// A nil *readCache is valid and caches nothing.
+type readCache struct {
+	pages int
+	ttl   time.Duration
+
+	mu      sync.Mutex
+	gen     uint64
+	entries map[string]readCacheEntry
+}
+
+type readCacheEntry struct {
+	value   interface{}
+	expires time.Time
+}
+
+func newReadCache(pages int, ttl time.Duration) *readCache {
+	if pages < 1 || ttl <= 0 {
+		return nil
+	}
+
+	return &readCache{
+		pages:   pages,
+		ttl:     ttl,
+		entries: make(map[string]readCacheEntry),
+	}
+}
+
+// cachesPage reports whether results for the provided page are kept.
+func (c *readCache) cachesPage(page int) bool {
+	return c != nil && page <= c.pages
+}
+
+// get returns the cached value for key along with the generation to pass to put if it's missing.
+func (c *readCache) get(key string) (interface{}, uint64, bool) {
+	if c == nil {
+		return nil, 0, false
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	entry, ok := c.entries[key]
+	if !ok || time.Now().After(entry.expires) {
+		return nil, c.gen, false
+	}
+
+	return entry.value, c.gen, true
+}
+
+// put stores value under key, unless the cache has been invalidated since gen was retrieved,
+// in which case value may already be stale.
+func (c *readCache) put(key string, gen uint64, value interface{}) {
+	if c == nil {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if gen != c.gen {
+		return
+	}
+	c.entries[key] = readCacheEntry{
+		value:   value,
+		expires: time.Now().Add(c.ttl),
+	}
+}
+
+// invalidate drops everything in the cache.
+func (c *readCache) invalidate() {
+	if c == nil {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.gen++
+	c.entries = make(map[string]readCacheEntry)
+}
+
+func tweetsCacheKey(page, perPage int, visibilityStatus TweetVisibilityStatus) string {
+	return fmt.Sprintf("tweets:%d:%d:%d", page, perPage, visibilityStatus)
+}
+
+func usersCacheKey(page, perPage int) string {
+	return fmt.Sprintf("users:%d:%d", page, perPage)
+}
+
+func suggestionsCacheKey(prefix string) string {
+	return "suggest:" + prefix
+}
+
+func tagSuggestionsCacheKey(prefix string) string {
+	return "suggest-tags:" + prefix
+}
+
+const (
+	tweetCountCacheKey = "count:tweets"
+	userCountCacheKey  = "count:users"
+)
@@ -0,0 +1,174 @@
+package registry
+
+/*
+Copyright 2021 G. Benjamin Morrison
+
+This file is part of getwtxt-ng.
+
+getwtxt-ng is free software: you can redistribute it and/or modify
+it under the terms of the GNU Affero General Public License as
+published by the Free Software Foundation, either version 3 of the
+License, or (at your option) any later version.
+
+getwtxt-ng is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU Affero General Public License for more details.
+
+You should have received a copy of the GNU Affero General Public License
+along with getwtxt-ng.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestReadCache(t *testing.T) {
+	t.Run("nil cache", func(t *testing.T) {
+		var c *readCache
+		c.put("key", 0, 1)
+		if _, _, ok := c.get("key"); ok {
+			t.Error("Expected nil cache to hold nothing")
+		}
+		if c.cachesPage(1) {
+			t.Error("Expected nil cache not to cache pages")
+		}
+		c.invalidate()
+	})
+	t.Run("disabled", func(t *testing.T) {
+		if newReadCache(0, time.Minute) != nil || newReadCache(3, 0) != nil {
+			t.Error("Expected cache to be disabled")
+		}
+	})
+	t.Run("stale put is dropped", func(t *testing.T) {
+		c := newReadCache(3, time.Minute)
+		_, gen, ok := c.get("key")
+		if ok {
+			t.Fatal("Expected miss")
+		}
+		c.invalidate()
+		c.put("key", gen, 1)
+		if _, _, ok := c.get("key"); ok {
+			t.Error("Expected value read before invalidation to be dropped")
+		}
+	})
+	t.Run("expiry", func(t *testing.T) {
+		c := newReadCache(3, time.Nanosecond)
+		_, gen, _ := c.get("key")
+		c.put("key", gen, 1)
+		time.Sleep(time.Millisecond)
+		if _, _, ok := c.get("key"); ok {
+			t.Error("Expected entry to expire")
+		}
+	})
+}
+
+func TestDB_ReadCache(t *testing.T) {
+	db := getPopulatedDB(t)
+	defer func() {
+		_ = db.Close()
+	}()
+	db.cache = newReadCache(1, time.Minute)
+	ctx := context.Background()
+
+	tweets, err := db.GetTweets(ctx, 1, 20, StatusVisible)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	if len(tweets) != 2 {
+		t.Fatalf("Expected 2 tweets, got %d", len(tweets))
+	}
+	users, err := db.GetUsers(ctx, 1, 20)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	if len(users) != 2 {
+		t.Fatalf("Expected 2 users, got %d", len(users))
+	}
+	if err := db.SetTweetCount(ctx); err != nil {
+		t.Fatal(err.Error())
+	}
+	if db.GetTweetCount() != 3 {
+		t.Fatalf("Expected 3 tweets counted, got %d", db.GetTweetCount())
+	}
+
+	// Writing behind the DB's back goes unnoticed until the cache is invalidated.
+	if _, err := db.conn.Exec("DELETE FROM tweets WHERE id = 2"); err != nil {
+		t.Fatal(err.Error())
+	}
+	tweets, err = db.GetTweets(ctx, 1, 20, StatusVisible)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	if len(tweets) != 2 {
+		t.Errorf("Expected cached page of 2 tweets, got %d", len(tweets))
+	}
+	if err := db.SetTweetCount(ctx); err != nil {
+		t.Fatal(err.Error())
+	}
+	if db.GetTweetCount() != 3 {
+		t.Errorf("Expected cached count of 3 tweets, got %d", db.GetTweetCount())
+	}
+
+	if err := db.UpdateUsersSyncTime(ctx, populatedDBUsers[:1]); err != nil {
+		t.Fatal(err.Error())
+	}
+	tweets, err = db.GetTweets(ctx, 1, 20, StatusVisible)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	if len(tweets) != 1 {
+		t.Errorf("Expected 1 tweet after invalidation, got %d", len(tweets))
+	}
+	if err := db.SetTweetCount(ctx); err != nil {
+		t.Fatal(err.Error())
+	}
+	if db.GetTweetCount() != 2 {
+		t.Errorf("Expected 2 tweets counted after invalidation, got %d", db.GetTweetCount())
+	}
+
+	// Pages past the cached ones always hit the database.
+	if _, _, ok := db.cache.get(tweetsCacheKey(2, 20, StatusVisible)); ok {
+		t.Error("Expected page 2 not to be cached")
+	}
+	if _, err := db.GetTweets(ctx, 2, 20, StatusVisible); err != nil {
+		t.Fatal(err.Error())
+	}
+	if _, _, ok := db.cache.get(tweetsCacheKey(2, 20, StatusVisible)); ok {
+		t.Error("Expected page 2 not to be cached")
+	}
+}
+
+func TestDB_Generation(t *testing.T) {
+	db := getPopulatedDB(t)
+	defer func() {
+		_ = db.Close()
+	}()
+	ctx := context.Background()
+
+	gen := db.Generation()
+	if _, err := db.GetTweets(ctx, 1, 20, StatusVisible); err != nil {
+		t.Fatal(err.Error())
+	}
+	if db.Generation() != gen {
+		t.Error("Expected a read not to change the generation")
+	}
+
+	tweet := Tweet{UserID: populatedDBUsers[0].ID, DateTime: time.Now(), Body: "new"}
+	if _, err := db.InsertTweets(ctx, []Tweet{tweet}); err != nil {
+		t.Fatal(err.Error())
+	}
+	if db.Generation() == gen {
+		t.Error("Expected inserting tweets to change the generation")
+	}
+
+	gen = db.Generation()
+	if _, err := db.SyncUsers(ctx, nil); err != nil {
+		t.Fatal(err.Error())
+	}
+	if db.Generation() == gen {
+		t.Error("Expected a sync run to change the generation")
+	}
+}
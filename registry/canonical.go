@@ -0,0 +1,261 @@
+package registry
+
+/*
+Copyright 2021 G. Benjamin Morrison
+
+This file is part of getwtxt-ng.
+
+getwtxt-ng is free software: you can redistribute it and/or modify
+it under the terms of the GNU Affero General Public License as
+published by the Free Software Foundation, either version 3 of the
+License, or (at your option) any later version.
+
+getwtxt-ng is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU Affero General Public License for more details.
+
+You should have received a copy of the GNU Affero General Public License
+along with getwtxt-ng.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/url"
+	"path"
+	"sort"
+	"strings"
+
+	"github.com/mattn/go-sqlite3"
+)
+
+// ErrUserExists is returned when registering a feed that's already registered under another form of its URL.
+var ErrUserExists = errors.New("user with that feed URL already exists")
+
+// CanonicalURL returns the form of a feed URL that's the same for every way of writing it: without the scheme,
+// the leading www., a default port, or a fragment, with the host lowercased and the path cleaned up.
+// So http://www.Example.com/./twtxt.txt and https://example.com/twtxt.txt are both example.com/twtxt.txt.
+// The query string is kept, since it may select a different feed.
+func CanonicalURL(feedURL string) (string, error) {
+	parsed, err := url.Parse(strings.TrimSpace(feedURL))
+	if err != nil {
+		return "", fmt.Errorf("when parsing feed URL %s: %w", feedURL, err)
+	}
+	host := strings.ToLower(parsed.Host)
+	if h, port, err := net.SplitHostPort(host); err == nil && (port == "80" || port == "443") {
+		host = h
+	}
+	host = strings.TrimPrefix(host, "www.")
+	if host == "" {
+		return "", fmt.Errorf("feed URL %s has no host", feedURL)
+	}
+
+	p := parsed.EscapedPath()
+	if p == "" {
+		p = "/"
+	}
+	p = path.Clean(p)
+
+	canonical := host + p
+	if parsed.RawQuery != "" {
+		canonical += "?" + parsed.RawQuery
+	}
+
+	return canonical, nil
+}
+
+// UserExists reports whether a user is registered with any form of the provided feed URL, in any status.
+func (d *DB) UserExists(ctx context.Context, feedURL string) (bool, error) {
+	canonical, err := CanonicalURL(feedURL)
+	if err != nil {
+		return false, err
+	}
+
+	ctx, cancel := d.withQueryTimeout(ctx)
+	defer cancel()
+
+	known := 0
+	if err := d.conn.QueryRowContext(ctx, "SELECT COUNT(*) FROM users WHERE canonical_url = ?", canonical).Scan(&known); err != nil {
+		return false, fmt.Errorf("when checking for existing user %s: %w", feedURL, err)
+	}
+
+	return known > 0, nil
+}
+
+// isUniqueViolation reports whether err is SQLite refusing a row that would duplicate a unique column.
+func isUniqueViolation(err error) bool {
+	sqliteErr := sqlite3.Error{}
+	if !errors.As(err, &sqliteErr) {
+		return false
+	}
+	return sqliteErr.ExtendedCode == sqlite3.ErrConstraintUnique || sqliteErr.ExtendedCode == sqlite3.ErrConstraintPrimaryKey
+}
+
+// BackfillCanonicalURLs stores the canonical URL of users registered before it was stored, and returns how many were set.
+// Users whose feed is already registered under another form of its URL are left without one and logged,
+// so they don't block the rest. They're still listed and synced, but don't count for duplicate checks.
+func (d *DB) BackfillCanonicalURLs(ctx context.Context) (int64, error) {
+	tx, err := d.beginWrite(ctx)
+	if err != nil {
+		return 0, fmt.Errorf("when beginning tx to backfill canonical URLs: %w", err)
+	}
+	defer func() {
+		_ = tx.Rollback()
+	}()
+
+	rows, err := tx.QueryContext(ctx, "SELECT id, url FROM users WHERE canonical_url IS NULL ORDER BY id ASC")
+	if err != nil {
+		return 0, fmt.Errorf("when querying for users without canonical URLs: %w", err)
+	}
+	type pending struct {
+		id  string
+		url string
+	}
+	users := make([]pending, 0)
+	for rows.Next() {
+		u := pending{}
+		if err := rows.Scan(&u.id, &u.url); err != nil {
+			d.logger.Debugf("when scanning user to set canonical URL: %s", err)
+			continue
+		}
+		users = append(users, u)
+	}
+	err = rows.Err()
+	_ = rows.Close()
+	if err != nil {
+		return 0, fmt.Errorf("when reading users without canonical URLs: %w", err)
+	}
+
+	set := int64(0)
+	for _, u := range users {
+		canonical, err := CanonicalURL(u.url)
+		if err != nil {
+			d.logger.Infof("Couldn't find canonical URL of user %s: %s", u.id, err)
+			continue
+		}
+		if _, err := tx.ExecContext(ctx, "UPDATE users SET canonical_url = ? WHERE id = ?", canonical, u.id); err != nil {
+			if isUniqueViolation(err) {
+				d.logger.Infof("Feed %s of user %s is already registered under another URL", u.url, u.id)
+				continue
+			}
+			return 0, fmt.Errorf("when storing canonical URL of user %s: %w", u.id, err)
+		}
+		set++
+	}
+
+	if err := tx.Commit(); err != nil {
+		return 0, fmt.Errorf("when committing tx to backfill canonical URLs: %w", err)
+	}
+	if set > 0 {
+		d.invalidate()
+	}
+
+	return set, nil
+}
+
+// DuplicateUsers is a feed that's registered more than once, under different forms of its URL.
+type DuplicateUsers struct {
+	CanonicalURL string `json:"canonical_url"`
+
+	// Keep is the registration the others are merged into.
+	Keep User `json:"keep"`
+
+	// Remove are the registrations whose tweets are moved to Keep before they're deleted.
+	Remove []User `json:"remove"`
+}
+
+// MergedUser is the outcome of merging one duplicate registration into the one kept.
+type MergedUser struct {
+	RemovedURL string `json:"removed_url"`
+	KeptURL    string `json:"kept_url"`
+	Moved      int64  `json:"tweets_moved"`
+	Dropped    int64  `json:"tweets_dropped"`
+}
+
+// FindDuplicateUsers finds feeds registered under more than one form of their URL, such as both the http://
+// and https:// versions, which could be registered before canonical URLs were stored.
+// The registration kept is the https:// one if there is one, then the active one, then the earliest added.
+func (d *DB) FindDuplicateUsers(ctx context.Context) ([]DuplicateUsers, error) {
+	users, err := d.GetAllUsers(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	groups := make(map[string][]User)
+	order := make([]string, 0)
+	for _, u := range users {
+		canonical, err := CanonicalURL(u.URL)
+		if err != nil {
+			d.logger.Debugf("Couldn't find canonical URL of user %s: %s", u.ID, err)
+			continue
+		}
+		if _, ok := groups[canonical]; !ok {
+			order = append(order, canonical)
+		}
+		groups[canonical] = append(groups[canonical], u)
+	}
+
+	dupes := make([]DuplicateUsers, 0)
+	for _, canonical := range order {
+		group := groups[canonical]
+		if len(group) < 2 {
+			continue
+		}
+		sort.SliceStable(group, func(i, j int) bool {
+			return preferredUser(group[i], group[j])
+		})
+		dupes = append(dupes, DuplicateUsers{
+			CanonicalURL: canonical,
+			Keep:         group[0],
+			Remove:       group[1:],
+		})
+	}
+
+	return dupes, nil
+}
+
+// preferredUser reports whether a should be kept over b when they're the same feed.
+func preferredUser(a, b User) bool {
+	aHTTPS := strings.HasPrefix(strings.ToLower(a.URL), "https://")
+	bHTTPS := strings.HasPrefix(strings.ToLower(b.URL), "https://")
+	if aHTTPS != bHTTPS {
+		return aHTTPS
+	}
+	aActive := a.Status == UserStatusActive
+	bActive := b.Status == UserStatusActive
+	if aActive != bActive {
+		return aActive
+	}
+	return a.DateTimeAdded.Before(b.DateTimeAdded)
+}
+
+// MergeDuplicateUsers merges every feed registered more than once into the registration FindDuplicateUsers keeps.
+// It stops at the first merge that fails, returning the merges done before it.
+func (d *DB) MergeDuplicateUsers(ctx context.Context) ([]MergedUser, error) {
+	dupes, err := d.FindDuplicateUsers(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	merged := make([]MergedUser, 0)
+	for _, dupe := range dupes {
+		for _, loser := range dupe.Remove {
+			moved, dropped, err := d.MergeUsers(ctx, loser.URL, dupe.Keep.URL)
+			if err != nil {
+				return merged, fmt.Errorf("when merging duplicate user %s into %s: %w", loser.URL, dupe.Keep.URL, err)
+			}
+			d.logger.Infof("Merged duplicate user %s into %s: moved %d tweets, dropped %d", loser.URL, dupe.Keep.URL, moved, dropped)
+			merged = append(merged, MergedUser{
+				RemovedURL: loser.URL,
+				KeptURL:    dupe.Keep.URL,
+				Moved:      moved,
+				Dropped:    dropped,
+			})
+		}
+	}
+
+	return merged, nil
+}
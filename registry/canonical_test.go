@@ -0,0 +1,190 @@
+package registry
+
+/*
+Copyright 2021 G. Benjamin Morrison
+
+This file is part of getwtxt-ng.
+
+getwtxt-ng is free software: you can redistribute it and/or modify
+it under the terms of the GNU Affero General Public License as
+published by the Free Software Foundation, either version 3 of the
+License, or (at your option) any later version.
+
+getwtxt-ng is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU Affero General Public License for more details.
+
+You should have received a copy of the GNU Affero General Public License
+along with getwtxt-ng.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestCanonicalURL(t *testing.T) {
+	cases := []struct {
+		name    string
+		in      string
+		want    string
+		wantErr bool
+	}{
+		{name: "https", in: "https://example.com/twtxt.txt", want: "example.com/twtxt.txt"},
+		{name: "http with www", in: "http://www.example.com/twtxt.txt", want: "example.com/twtxt.txt"},
+		{name: "mixed case host", in: "https://WWW.Example.COM/twtxt.txt", want: "example.com/twtxt.txt"},
+		{name: "default port", in: "https://example.com:443/twtxt.txt", want: "example.com/twtxt.txt"},
+		{name: "other port", in: "http://example.com:8080/twtxt.txt", want: "example.com:8080/twtxt.txt"},
+		{name: "messy path", in: "https://example.com//user/./../twtxt.txt", want: "example.com/twtxt.txt"},
+		{name: "query kept, fragment dropped", in: "https://example.com/twtxt.txt?u=1#top", want: "example.com/twtxt.txt?u=1"},
+		{name: "path case kept", in: "https://example.com/~Foo/twtxt.txt", want: "example.com/~Foo/twtxt.txt"},
+		{name: "no host", in: "/twtxt.txt", wantErr: true},
+		{name: "unparseable", in: "https://exa mple.com/%zz", wantErr: true},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			got, err := CanonicalURL(tc.in)
+			if tc.wantErr {
+				if err == nil {
+					t.Errorf("Expected error, got %s", got)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err.Error())
+			}
+			if got != tc.want {
+				t.Errorf("Expected %s, got %s", tc.want, got)
+			}
+		})
+	}
+}
+
+func TestDB_UserExists(t *testing.T) {
+	db := getPopulatedDB(t)
+	defer func() {
+		_ = db.Close()
+	}()
+	ctx := context.Background()
+
+	for _, u := range []string{"https://example.com/twtxt.txt", "http://www.example.com/twtxt.txt"} {
+		exists, err := db.UserExists(ctx, u)
+		if err != nil {
+			t.Fatal(err.Error())
+		}
+		if !exists {
+			t.Errorf("Expected %s to be known", u)
+		}
+	}
+	exists, err := db.UserExists(ctx, "https://example.net/twtxt.txt")
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	if exists {
+		t.Error("Expected example.net to be unknown")
+	}
+
+	user := User{
+		URL:          "http://www.example.org/twtxt.txt",
+		Nick:         "dupe",
+		PasscodeHash: []byte("hash"),
+	}
+	if err := db.InsertUser(ctx, &user); !errors.Is(err, ErrUserExists) {
+		t.Errorf("Expected ErrUserExists, got: %v", err)
+	}
+}
+
+func TestDB_BackfillCanonicalURLs(t *testing.T) {
+	db, err := Open(":memory:")
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	defer func() {
+		_ = db.Close()
+	}()
+	ctx := context.Background()
+
+	// Registered before canonical URLs were stored, so the second is a duplicate of the first.
+	stmt := "INSERT INTO users (id, url, nick, passcode_hash, dt_added, last_sync) VALUES (?, ?, 'foo', 'hash', 0, 0)"
+	for i, u := range []string{"https://example.com/twtxt.txt", "http://www.example.com/twtxt.txt", "https://example.org/twtxt.txt"} {
+		if _, err := db.conn.Exec(stmt, i+1, u); err != nil {
+			t.Fatal(err.Error())
+		}
+	}
+
+	set, err := db.BackfillCanonicalURLs(ctx)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	if set != 2 {
+		t.Errorf("Expected 2 canonical URLs to be set, got %d", set)
+	}
+
+	missing := ""
+	if err := db.conn.QueryRow("SELECT url FROM users WHERE canonical_url IS NULL").Scan(&missing); err != nil {
+		t.Fatal(err.Error())
+	}
+	if missing != "http://www.example.com/twtxt.txt" {
+		t.Errorf("Expected the later duplicate to be left without a canonical URL, got %s", missing)
+	}
+}
+
+func TestDB_MergeDuplicateUsers(t *testing.T) {
+	db, err := Open(":memory:")
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	defer func() {
+		_ = db.Close()
+	}()
+	ctx := context.Background()
+
+	// The http:// registration came first, so it's the one the backfill gives the canonical URL to.
+	stmt := "INSERT INTO users (id, url, nick, passcode_hash, dt_added, last_sync) VALUES (?, ?, 'foo', 'hash', ?, 0)"
+	for i, u := range []string{"http://www.example.com/twtxt.txt", "https://example.com/twtxt.txt", "https://example.org/twtxt.txt"} {
+		if _, err := db.conn.Exec(stmt, i+1, u, i); err != nil {
+			t.Fatal(err.Error())
+		}
+	}
+	if _, err := db.BackfillCanonicalURLs(ctx); err != nil {
+		t.Fatal(err.Error())
+	}
+	tweet := Tweet{UserID: "1", DateTime: time.Unix(1, 0), Body: "hello"}
+	if _, err := db.InsertTweets(ctx, []Tweet{tweet}); err != nil {
+		t.Fatal(err.Error())
+	}
+
+	dupes, err := db.FindDuplicateUsers(ctx)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	if len(dupes) != 1 || dupes[0].Keep.URL != "https://example.com/twtxt.txt" || len(dupes[0].Remove) != 1 {
+		t.Fatalf("Expected the https:// registration to be kept, got: %+v", dupes)
+	}
+
+	merged, err := db.MergeDuplicateUsers(ctx)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	if len(merged) != 1 || merged[0].RemovedURL != "http://www.example.com/twtxt.txt" || merged[0].Moved != 1 {
+		t.Errorf("Unexpected merges: %+v", merged)
+	}
+
+	canonical := ""
+	if err := db.conn.QueryRow("SELECT canonical_url FROM users WHERE id = 2").Scan(&canonical); err != nil {
+		t.Fatal(err.Error())
+	}
+	if canonical != "example.com/twtxt.txt" {
+		t.Errorf("Expected the kept user to take the canonical URL, got %q", canonical)
+	}
+	dupes, err = db.FindDuplicateUsers(ctx)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	if len(dupes) != 0 {
+		t.Errorf("Expected no duplicates left, got: %+v", dupes)
+	}
+}
@@ -0,0 +1,126 @@
+package registry
+
+/*
+Copyright 2021 G. Benjamin Morrison
+
+This file is part of getwtxt-ng.
+
+getwtxt-ng is free software: you can redistribute it and/or modify
+it under the terms of the GNU Affero General Public License as
+published by the Free Software Foundation, either version 3 of the
+License, or (at your option) any later version.
+
+getwtxt-ng is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU Affero General Public License for more details.
+
+You should have received a copy of the GNU Affero General Public License
+along with getwtxt-ng.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// ErrInvalidTwtHash is returned when a string can't be a twt hash.
+var ErrInvalidTwtHash = errors.New("invalid twt hash")
+
+// RegexIsTwtHash matches strings that could be a twt hash: lowercase base32 without padding.
+var RegexIsTwtHash = regexp.MustCompile(`^[a-z2-7]+$`)
+
+// GetConversation retrieves the visible tweets in the thread started by the tweet with the provided twt hash,
+// oldest first. A thread holds the tweets with that hash and the replies that name it as their subject,
+// either as (#hash) or as (#<hash url>). Replies are found even if the tweet they answer isn't in this registry.
+func (d *DB) GetConversation(ctx context.Context, hash string) ([]Tweet, error) {
+	ctx, cancel := d.withQueryTimeout(ctx)
+	defer cancel()
+
+	hash = strings.ToLower(strings.TrimSpace(hash))
+	if !RegexIsTwtHash.MatchString(hash) {
+		return nil, fmt.Errorf("%w: %s", ErrInvalidTwtHash, hash)
+	}
+
+	tweetStmt := `SELECT tweets.id, tweets.user_id, users.nick, users.url, tweets.dt, tweets.body, tweets.hidden, tweets.hash, tweets.subject, tweets.mentions, tweets.tags, tweets.utc_offset, tweets.lang
+					FROM tweets JOIN users ON users.id = tweets.user_id
+					WHERE (tweets.hash = ? OR tweets.subject = ?)
+					AND tweets.hidden = ? AND users.status = 'active'
+					ORDER BY tweets.dt ASC, tweets.id ASC`
+	rows, err := d.queryPrepared(ctx, tweetStmt, hash, hash, StatusVisible)
+	if err != nil {
+		return nil, fmt.Errorf("when querying for conversation %s: %w", hash, err)
+	}
+	defer func() {
+		_ = rows.Close()
+	}()
+
+	return d.scanTweetRows(rows)
+}
+
+// BackfillTweetSubjects parses the subject of stored tweets that haven't had it parsed yet, correcting whether
+// they're counted as containing tags, and returns how many were parsed.
+func (d *DB) BackfillTweetSubjects(ctx context.Context) (int64, error) {
+	parsed := int64(0)
+	for {
+		n, err := d.backfillTweetSubjectsBatch(ctx)
+		if err != nil {
+			return parsed, err
+		}
+		parsed += n
+		if n < backfillTweetsBatchSize {
+			break
+		}
+	}
+	if parsed > 0 {
+		d.invalidate()
+	}
+
+	return parsed, nil
+}
+
+func (d *DB) backfillTweetSubjectsBatch(ctx context.Context) (int64, error) {
+	tx, err := d.beginWrite(ctx)
+	if err != nil {
+		return 0, fmt.Errorf("when beginning tx to backfill tweet subjects: %w", err)
+	}
+	defer func() {
+		_ = tx.Rollback()
+	}()
+
+	rows, err := tx.QueryContext(ctx, "SELECT id, body FROM tweets WHERE subject IS NULL LIMIT ?", backfillTweetsBatchSize)
+	if err != nil {
+		return 0, fmt.Errorf("when querying for tweets without subjects: %w", err)
+	}
+	bodies := make(map[string]string, backfillTweetsBatchSize)
+	for rows.Next() {
+		id := ""
+		body := ""
+		if err := rows.Scan(&id, &body); err != nil {
+			d.logger.Debugf("when scanning tweet to parse subject: %s", err)
+			continue
+		}
+		bodies[id] = body
+	}
+	err = rows.Err()
+	_ = rows.Close()
+	if err != nil {
+		return 0, fmt.Errorf("when reading tweets without subjects: %w", err)
+	}
+
+	for id, body := range bodies {
+		_, hasTags := tweetBodyFlags(body)
+		if _, err := tx.ExecContext(ctx, "UPDATE tweets SET subject = ?, contains_tags = ? WHERE id = ?", tweetSubject(body), hasTags, id); err != nil {
+			return 0, fmt.Errorf("when storing subject of tweet %s: %w", id, err)
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return 0, fmt.Errorf("when committing tx to backfill tweet subjects: %w", err)
+	}
+
+	return int64(len(bodies)), nil
+}
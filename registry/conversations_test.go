@@ -0,0 +1,150 @@
+package registry
+
+/*
+Copyright 2021 G. Benjamin Morrison
+
+This file is part of getwtxt-ng.
+
+getwtxt-ng is free software: you can redistribute it and/or modify
+it under the terms of the GNU Affero General Public License as
+published by the Free Software Foundation, either version 3 of the
+License, or (at your option) any later version.
+
+getwtxt-ng is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU Affero General Public License for more details.
+
+You should have received a copy of the GNU Affero General Public License
+along with getwtxt-ng.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+)
+
+func TestDB_GetConversation(t *testing.T) {
+	db := getPopulatedDB(t)
+	defer func() {
+		_ = db.Close()
+	}()
+	ctx := context.Background()
+
+	t.Run("invalid hash", func(t *testing.T) {
+		for _, hash := range []string{"", "abc%", "abc)d"} {
+			if _, err := db.GetConversation(ctx, hash); !errors.Is(err, ErrInvalidTwtHash) {
+				t.Errorf("Expected ErrInvalidTwtHash for %q, got: %v", hash, err)
+			}
+		}
+	})
+
+	now := time.Now().UTC().Truncate(time.Second)
+	root := Tweet{UserID: "1", DateTime: now.Add(-time.Hour), Body: "what's everyone up to?"}
+	hash := TwtHash(populatedDBUsers[0].URL, root.DateTime, root.Body)
+	tweets := []Tweet{
+		root,
+		{UserID: "2", DateTime: now.Add(-30 * time.Minute), Body: fmt.Sprintf("(#%s) @<foobar %s> writing tests", hash, populatedDBUsers[0].URL)},
+		{UserID: "1", DateTime: now.Add(-20 * time.Minute), Body: fmt.Sprintf("(#<%s https://example.com/twt/%s>) same", hash, hash)},
+		{UserID: "2", DateTime: now.Add(-10 * time.Minute), Body: "(#zzzzzzz) some other thread"},
+		{UserID: "2", DateTime: now.Add(-5 * time.Minute), Body: fmt.Sprintf("(#%s) hidden reply", hash)},
+	}
+	res, err := db.InsertTweets(ctx, tweets)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	if _, err := db.conn.Exec("UPDATE tweets SET hidden = 1 WHERE id = ?", res.IDs[4]); err != nil {
+		t.Fatal(err.Error())
+	}
+
+	t.Run("thread", func(t *testing.T) {
+		conv, err := db.GetConversation(ctx, hash)
+		if err != nil {
+			t.Fatal(err.Error())
+		}
+		if len(conv) != 3 {
+			t.Fatalf("Expected 3 tweets in conversation, got %d: %v", len(conv), conv)
+		}
+		for i, id := range res.IDs[:3] {
+			if conv[i].ID != id {
+				t.Errorf("Expected tweet %s at position %d, got %s", id, i, conv[i].ID)
+			}
+		}
+		if conv[0].Hash != hash {
+			t.Errorf("Expected root to have hash %s, got %s", hash, conv[0].Hash)
+		}
+	})
+	t.Run("unknown thread", func(t *testing.T) {
+		conv, err := db.GetConversation(ctx, "aaaaaaa")
+		if err != nil {
+			t.Fatal(err.Error())
+		}
+		if len(conv) != 0 {
+			t.Errorf("Expected empty conversation, got %v", conv)
+		}
+	})
+}
+
+func TestTweetSubject(t *testing.T) {
+	cases := []struct {
+		body    string
+		subject string
+		tags    []string
+	}{
+		{body: "(#abcdefg) sounds good", subject: "abcdefg", tags: []string{}},
+		{body: "(#<abcdefg https://example.com/twt/abcdefg>) sounds #good", subject: "abcdefg", tags: []string{"good"}},
+		{body: "@<foo https://example.com/twtxt.txt> (#abcdefg) me too", subject: "abcdefg", tags: []string{}},
+		{body: "no subject, just a #tag", subject: "", tags: []string{"tag"}},
+		{body: "(#NotAHash) is a tag in parentheses", subject: "", tags: []string{"NotAHash"}},
+	}
+	for _, c := range cases {
+		tw := Tweet{Body: c.body}
+		tw.parseMentionsAndTags()
+		if tw.Subject != c.subject {
+			t.Errorf("Expected subject %q for %q, got %q", c.subject, c.body, tw.Subject)
+		}
+		if fmt.Sprint(tw.Tags) != fmt.Sprint(c.tags) {
+			t.Errorf("Expected tags %v for %q, got %v", c.tags, c.body, tw.Tags)
+		}
+	}
+}
+
+func TestDB_BackfillTweetSubjects(t *testing.T) {
+	db := getPopulatedDB(t)
+	defer func() {
+		_ = db.Close()
+	}()
+	ctx := context.Background()
+
+	// Stored as it would have been before subjects were parsed.
+	tweetsStmt := "INSERT INTO tweets (id, user_id, dt, body, contains_tags) VALUES (?,?,?,?,?)"
+	if _, err := db.conn.Exec(tweetsStmt, "10", "1", time.Now().UnixNano(), "(#abcdefg) good point", 1); err != nil {
+		t.Fatal(err.Error())
+	}
+
+	parsed, err := db.BackfillTweetSubjects(ctx)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	if parsed != int64(len(populatedDBTweets)+1) {
+		t.Errorf("Expected %d tweets parsed, got %d", len(populatedDBTweets)+1, parsed)
+	}
+
+	conv, err := db.GetConversation(ctx, "abcdefg")
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	if len(conv) != 1 || conv[0].ID != "10" {
+		t.Errorf("Expected tweet 10 in the conversation, got %v", conv)
+	}
+	tags, err := db.GetTags(ctx, 1, 20, StatusVisible)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	if len(tags) != 0 {
+		t.Errorf("Expected the subject not to count as a tag, got %v", tags)
+	}
+}
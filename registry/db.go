@@ -21,34 +21,64 @@ along with getwtxt-ng.  If not, see <https://www.gnu.org/licenses/>.
 */
 
 import (
+	"context"
 	"database/sql"
-	"errors"
 	"fmt"
-	"io/fs"
 	"net/http"
-	"os"
+	"sync"
+	"sync/atomic"
 	"time"
 
 	_ "github.com/mattn/go-sqlite3"
-	log "github.com/sirupsen/logrus"
+
+	"github.com/gbmor/getwtxt-ng/common"
 )
 
+// Logger is the leveled logger the registry writes to. *logrus.Logger satisfies it, as does
+// common.StdLogger for the standard library's logger. A nil Logger discards everything.
+type Logger = common.Logger
+
 // DB contains the database connection pool and associated settings.
 type DB struct {
+	// writeGen counts the changes committed through the DB, see Generation.
+	// It's first so it's 64-bit aligned for atomic access on 32-bit platforms.
+	writeGen uint64
+
 	// EntriesPerPageMin specifies the minimum number of users or tweets to display in a single page.
 	EntriesPerPageMin int
 
 	// EntriesPerPageMax specifies the maximum number of users or tweets to display in a single page.
 	EntriesPerPageMax int
 
-	// Client is the default HTTP client, which has a 5-second timeout.
+	// Client fetches twtxt files. Unless set with WithHTTPClient, it's tuned with WithHTTPTuning.
 	Client *http.Client
 
+	// Hooks are called after operations that change the registry's contents.
+	Hooks Hooks
+
+	// Dedupe decides which tweets InsertTweets considers to be the same. The zero value is DedupeStrict.
+	Dedupe DedupeMode
+
+	queryTimeout time.Duration
+
+	// filter is checked against each tweet InsertTweets stores, see WithContentFilter.
+	filter *ContentFilter
+
+	// collapseMirrors is set by WithCollapseMirrors.
+	collapseMirrors bool
+
 	userCount  uint32
 	tweetCount uint32
 
-	logger *log.Logger
+	logger Logger
 	conn   *sql.DB
+	cache  *readCache
+
+	// writeMu serializes writes, see beginWrite.
+	writeMu sync.Mutex
+
+	stmtsMu sync.Mutex
+	stmts   map[string]*sql.Stmt
 }
 
 type RoundTripperWithHeader struct {
@@ -80,118 +110,199 @@ func (rth RoundTripperWithHeader) RoundTrip(r *http.Request) (*http.Response, er
 	return rth.rt.RoundTrip(r)
 }
 
-// InitSQLite initializes the registry's database, creating the appropriate tables if needed.
-func InitSQLite(dbPath string, maxEntriesPerPage, minEntriesPerPage int, httpClient *http.Client, userAgent string, logger *log.Logger) (*DB, error) {
-	shouldInit := dbPath == ":memory:"
-	if !shouldInit {
-		_, err := os.Stat(dbPath)
-		if errors.Is(err, fs.ErrNotExist) {
-			shouldInit = true
-		}
-	}
-
-	db, err := sql.Open("sqlite3", dbPath)
+// OpenSQLite opens the registry's database without creating tables or applying migrations.
+// Most callers want Open instead. This is useful for tooling that manages the schema itself.
+func OpenSQLite(dbPath string, logger Logger) (*DB, error) {
+	db, err := sql.Open("sqlite3", sqliteDSN(dbPath))
 	if err != nil {
 		return nil, fmt.Errorf("while initializing connection to sqlite3 db at %s :: %w", dbPath, err)
 	}
 
-	if shouldInit {
-		createUserTableStr := `CREATE TABLE IF NOT EXISTS users (
-    		id INTEGER PRIMARY KEY AUTOINCREMENT,
-    		url TEXT NOT NULL UNIQUE,
-    		nick TEXT NOT NULL,
-    		passcode_hash BLOB NOT NULL,
-    		dt_added INTEGER NOT NULL,
-    		last_sync INTEGER NOT NULL
-		)`
-		_, err = db.Exec(createUserTableStr)
-		if err != nil {
-			_ = db.Close()
-			return nil, fmt.Errorf("while creating users table at %s :: %w", dbPath, err)
-		}
+	// Every connection to :memory: gets its own empty database.
+	if dbPath == ":memory:" {
+		db.SetMaxOpenConns(1)
+	}
 
-		createTweetsTableStr := `CREATE TABLE IF NOT EXISTS tweets (
-    		id INTEGER PRIMARY KEY AUTOINCREMENT,
-    		user_id INTEGER NOT NULL,
-    		dt INTEGER NOT NULL,
-    		body TEXT NOT NULL,
-    		contains_mentions INTEGER NOT NULL DEFAULT 0,
-    		contains_tags INTEGER NOT NULL DEFAULT 0,
-    		hidden INTEGER NOT NULL DEFAULT 0,
-    		UNIQUE (user_id, dt, body) ON CONFLICT IGNORE,
-    		FOREIGN KEY(user_id) REFERENCES users(id)
-		)`
-		_, err = db.Exec(createTweetsTableStr)
-		if err != nil {
-			_ = db.Close()
-			return nil, fmt.Errorf("while creating tweets table at %s :: %w", dbPath, err)
-		}
+	if logger == nil {
+		logger = common.NopLogger{}
+	}
 
-		createTweetsViewStr := `CREATE VIEW tweets_users (
-    		id, user_id, nick, url, dt, body, contains_mentions, contains_tags, hidden		
-		) AS 
-    		SELECT 
-    		    tweets.id, tweets.user_id, users.nick, users.url, tweets.dt, tweets.body,
-    		    tweets.contains_mentions, tweets.contains_tags, tweets.hidden
-    		FROM tweets
-    		JOIN users ON users.id = tweets.user_id`
-		_, err = db.Exec(createTweetsViewStr)
-		if err != nil {
-			_ = db.Close()
-			return nil, fmt.Errorf("while creating tweets/users view at %s :: %w", dbPath, err)
-		}
+	dbWrap := DB{
+		conn:   db,
+		logger: logger,
+	}
 
-		createTweetSearchTableStr := `CREATE VIRTUAL TABLE tweets_search USING fts5 (
-    		id, user_id, nick, url, dt, body, contains_mentions, contains_tags, hidden,
-    		content = tweets_users,
-    		content_rowid = id,
-    		columnsize = 0,
-		)`
-		_, err = db.Exec(createTweetSearchTableStr)
-		if err != nil {
-			_ = db.Close()
-			return nil, fmt.Errorf("while creating tweets_search virtual table :: %s", err)
-		}
+	return &dbWrap, nil
+}
 
-		createTweetSearchTableTriggerInsert := `CREATE TRIGGER tweetsInsert AFTER INSERT ON tweets
-    		BEGIN
-				INSERT INTO tweets_search (
-					ROWID, user_id, dt, body, contains_mentions, contains_tags, hidden
-				) SELECT ROWID, user_id, dt, body, contains_mentions, contains_tags, hidden FROM tweets WHERE ROWID = NEW.ROWID;
-			END;`
-		_, err = db.Exec(createTweetSearchTableTriggerInsert)
-		if err != nil {
-			_ = db.Close()
-			return nil, fmt.Errorf("while creating tweets_search virtual table insert trigger :: %s", err)
-		}
+// Open initializes the registry's database, creating the appropriate tables and applying any pending migrations.
+// Without any options, pages hold between 20 and 1000 entries, nothing is logged, nothing is cached,
+// and twtxt files are fetched with a 30-second timeout and no User-Agent.
+func Open(dbPath string, opts ...Option) (*DB, error) {
+	o := options{
+		entriesPerPageMin: defaultEntriesPerPageMin,
+		entriesPerPageMax: defaultEntriesPerPageMax,
+	}
+	for _, opt := range opts {
+		opt(&o)
+	}
 
-		createTweetSearchTableTriggerDelete := `CREATE TRIGGER tweetsDelete AFTER DELETE ON tweets
-    		BEGIN
-    		    DELETE FROM tweets_search WHERE ROWID = OLD.ROWID;
-			END;`
-		_, err = db.Exec(createTweetSearchTableTriggerDelete)
-		if err != nil {
-			_ = db.Close()
-			return nil, fmt.Errorf("while creating tweets_search virtual table delete trigger :: %s", err)
+	dbWrap, err := OpenSQLite(dbPath, o.logger)
+	if err != nil {
+		return nil, err
+	}
+
+	if _, err := dbWrap.MigrateUp(context.Background()); err != nil {
+		_ = dbWrap.conn.Close()
+		return nil, fmt.Errorf("while migrating sqlite3 db at %s :: %w", dbPath, err)
+	}
+	hashed, err := dbWrap.BackfillTweetHashes(context.Background())
+	if err != nil {
+		_ = dbWrap.conn.Close()
+		return nil, fmt.Errorf("while hashing tweets in sqlite3 db at %s :: %w", dbPath, err)
+	}
+	if hashed > 0 {
+		dbWrap.logger.Infof("Computed twt hashes of %d tweets", hashed)
+	}
+	parsed, err := dbWrap.BackfillTweetSubjects(context.Background())
+	if err != nil {
+		_ = dbWrap.conn.Close()
+		return nil, fmt.Errorf("while parsing tweet subjects in sqlite3 db at %s :: %w", dbPath, err)
+	}
+	if parsed > 0 {
+		dbWrap.logger.Infof("Parsed subjects of %d tweets", parsed)
+	}
+	parsed, err = dbWrap.BackfillTweetEntities(context.Background())
+	if err != nil {
+		_ = dbWrap.conn.Close()
+		return nil, fmt.Errorf("while parsing tweet mentions and tags in sqlite3 db at %s :: %w", dbPath, err)
+	}
+	if parsed > 0 {
+		dbWrap.logger.Infof("Parsed mentions and tags of %d tweets", parsed)
+	}
+	tagged, err := dbWrap.BackfillTweetTags(context.Background())
+	if err != nil {
+		_ = dbWrap.conn.Close()
+		return nil, fmt.Errorf("while recording tweet tags in sqlite3 db at %s :: %w", dbPath, err)
+	}
+	if tagged > 0 {
+		dbWrap.logger.Infof("Recorded tags of %d tweets", tagged)
+	}
+	detected, err := dbWrap.BackfillTweetLanguages(context.Background())
+	if err != nil {
+		_ = dbWrap.conn.Close()
+		return nil, fmt.Errorf("while detecting tweet languages in sqlite3 db at %s :: %w", dbPath, err)
+	}
+	if detected > 0 {
+		dbWrap.logger.Infof("Detected languages of %d tweets", detected)
+	}
+	canonicalized, err := dbWrap.BackfillCanonicalURLs(context.Background())
+	if err != nil {
+		_ = dbWrap.conn.Close()
+		return nil, fmt.Errorf("while storing canonical user URLs in sqlite3 db at %s :: %w", dbPath, err)
+	}
+	if canonicalized > 0 {
+		dbWrap.logger.Infof("Stored canonical URLs of %d users", canonicalized)
+	}
+	interrupted, err := dbWrap.missingSearchTriggers(context.Background())
+	if err != nil {
+		_ = dbWrap.conn.Close()
+		return nil, fmt.Errorf("while checking search index of sqlite3 db at %s :: %w", dbPath, err)
+	}
+	if interrupted {
+		dbWrap.logger.Infof("Restoring the search index after an interrupted bulk import")
+		if err := dbWrap.restoreSearchTriggers(context.Background()); err != nil {
+			_ = dbWrap.conn.Close()
+			return nil, fmt.Errorf("while restoring search index of sqlite3 db at %s :: %w", dbPath, err)
 		}
 	}
 
+	httpClient := o.httpClient
 	if httpClient == nil {
-		rt := NewRoundTripperWithHeader(nil)
-		rt.Header.Set("User-Agent", userAgent)
-		httpClient = &http.Client{
-			Timeout:   5 * time.Second,
-			Transport: rt,
+		httpClient = newHTTPClient(o.httpTuning, o.userAgent)
+	}
+
+	dbWrap.EntriesPerPageMin = o.entriesPerPageMin
+	dbWrap.EntriesPerPageMax = o.entriesPerPageMax
+	dbWrap.Client = httpClient
+	dbWrap.cache = newReadCache(o.readCachePages, o.readCacheTTL)
+	dbWrap.queryTimeout = o.queryTimeout
+	dbWrap.filter = o.filter
+	dbWrap.collapseMirrors = o.collapseMirrors
+
+	return dbWrap, nil
+}
+
+// Close releases the cached prepared statements and closes the database connection.
+// The DB can't be used afterwards.
+func (d *DB) Close() error {
+	d.stmtsMu.Lock()
+	defer d.stmtsMu.Unlock()
+
+	for query, stmt := range d.stmts {
+		if err := stmt.Close(); err != nil {
+			d.logger.Debugf("When closing prepared statement %q: %s", query, err)
 		}
 	}
+	d.stmts = nil
 
-	dbWrap := DB{
-		conn:              db,
-		logger:            logger,
-		EntriesPerPageMin: minEntriesPerPage,
-		EntriesPerPageMax: maxEntriesPerPage,
-		Client:            httpClient,
+	if err := d.conn.Close(); err != nil {
+		return fmt.Errorf("when closing database connection: %w", err)
 	}
 
-	return &dbWrap, nil
+	return nil
+}
+
+// Generation returns a number that changes whenever a change to the registry's contents is committed
+// through this DB, or a sync run completes. Anything derived from the registry's contents can be
+// reused for as long as the generation stays the same. Writes made through another process aren't counted.
+func (d *DB) Generation() uint64 {
+	return atomic.LoadUint64(&d.writeGen)
+}
+
+// invalidate drops the read cache and advances the generation after a write is committed.
+func (d *DB) invalidate() {
+	atomic.AddUint64(&d.writeGen, 1)
+	d.cache.invalidate()
+}
+
+// prepared returns a prepared statement for query, preparing it the first time it's requested.
+// database/sql takes care of re-preparing it on whichever pooled connection ends up running it.
+// Statements must be prepared before beginning a transaction, then bound to it with tx.StmtContext,
+// as an in-memory database only has one connection.
+func (d *DB) prepared(ctx context.Context, query string) (*sql.Stmt, error) {
+	d.stmtsMu.Lock()
+	defer d.stmtsMu.Unlock()
+
+	if stmt, ok := d.stmts[query]; ok {
+		return stmt, nil
+	}
+	stmt, err := d.conn.PrepareContext(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+	if d.stmts == nil {
+		d.stmts = make(map[string]*sql.Stmt)
+	}
+	d.stmts[query] = stmt
+
+	return stmt, nil
+}
+
+// withQueryTimeout derives the context a read runs under, applying the timeout set with WithQueryTimeout.
+func (d *DB) withQueryTimeout(ctx context.Context) (context.Context, context.CancelFunc) {
+	if d.queryTimeout <= 0 {
+		return ctx, func() {}
+	}
+	return context.WithTimeout(ctx, d.queryTimeout)
+}
+
+// queryPrepared runs query with the provided arguments using a cached prepared statement.
+func (d *DB) queryPrepared(ctx context.Context, query string, args ...interface{}) (*sql.Rows, error) {
+	stmt, err := d.prepared(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+
+	return stmt.QueryContext(ctx, args...)
 }
@@ -20,13 +20,22 @@ along with getwtxt-ng.  If not, see <https://www.gnu.org/licenses/>.
 */
 
 import (
+	"bytes"
+	"context"
+	"errors"
+	stdlog "log"
+	"net/http"
+	"strings"
 	"testing"
+	"time"
 
 	log "github.com/sirupsen/logrus"
+
+	"github.com/gbmor/getwtxt-ng/common"
 )
 
-func TestInitDB(t *testing.T) {
-	db, err := InitSQLite(":memory:", 20, 1000, nil, log.StandardLogger())
+func TestOpen(t *testing.T) {
+	db, err := Open(":memory:", WithLogger(log.StandardLogger()))
 	if err != nil {
 		t.Error(err.Error())
 	}
@@ -44,16 +53,222 @@ func TestInitDB(t *testing.T) {
 		defer func() {
 			_ = rows.Close()
 		}()
-		tables := make([]string, 0)
+		tables := make(map[string]bool)
 		for rows.Next() {
 			tbl := ""
 			if err := rows.Scan(&tbl); err != nil {
 				t.Error(err.Error())
 			}
-			tables = append(tables, tbl)
+			tables[tbl] = true
+		}
+		for _, want := range []string{"tweets", "users", "tweets_search", "tweet_revisions", "ap_followers", "user_sources", "webmentions", "registries", "hosted_feeds", "row_counts"} {
+			if !tables[want] {
+				t.Errorf("Missing table %s, got: %v", want, tables)
+			}
+		}
+	})
+	t.Run("in-memory, check for timeline indexes", func(t *testing.T) {
+		rows, err := db.conn.Query("SELECT name FROM sqlite_master WHERE type = 'index' ORDER BY name")
+		if err != nil {
+			t.Fatal(err.Error())
+		}
+		defer func() {
+			_ = rows.Close()
+		}()
+		indexes := make(map[string]bool)
+		for rows.Next() {
+			idx := ""
+			if err := rows.Scan(&idx); err != nil {
+				t.Error(err.Error())
+			}
+			indexes[idx] = true
+		}
+		for _, want := range []string{"tweets_dt", "tweets_user_id_dt", "tweets_hidden_dt", "users_dt_added"} {
+			if !indexes[want] {
+				t.Errorf("Missing index %s, got: %v", want, indexes)
+			}
+		}
+	})
+}
+
+func TestOpen_Logger(t *testing.T) {
+	t.Run("nil logger", func(t *testing.T) {
+		db, err := Open(":memory:")
+		if err != nil {
+			t.Fatal(err.Error())
+		}
+		_ = db.conn.Close()
+	})
+	t.Run("capture output", func(t *testing.T) {
+		buf := &bytes.Buffer{}
+		logger := common.StdLogger{
+			Logger: stdlog.New(buf, "", 0),
+			Debug:  true,
+		}
+		db, err := Open(":memory:", WithLogger(logger))
+		if err != nil {
+			t.Fatal(err.Error())
+		}
+		_ = db.conn.Close()
+		if !strings.Contains(buf.String(), "DEBUG: Applied migration 1") {
+			t.Errorf("Expected migration to be logged, got: %s", buf.String())
+		}
+	})
+}
+
+func TestOpen_Options(t *testing.T) {
+	t.Run("defaults", func(t *testing.T) {
+		db, err := Open(":memory:")
+		if err != nil {
+			t.Fatal(err.Error())
+		}
+		defer func() {
+			_ = db.conn.Close()
+		}()
+		if db.EntriesPerPageMin != defaultEntriesPerPageMin || db.EntriesPerPageMax != defaultEntriesPerPageMax {
+			t.Errorf("Expected default page limits, got %d and %d", db.EntriesPerPageMin, db.EntriesPerPageMax)
+		}
+		if db.Client == nil {
+			t.Error("Expected default HTTP client")
+		}
+	})
+	t.Run("page limits and user agent", func(t *testing.T) {
+		db, err := Open(":memory:", WithPageLimits(5, 50), WithUserAgent("getwtxt-ng/test"))
+		if err != nil {
+			t.Fatal(err.Error())
+		}
+		defer func() {
+			_ = db.conn.Close()
+		}()
+		if db.EntriesPerPageMin != 5 || db.EntriesPerPageMax != 50 {
+			t.Errorf("Expected page limits 5 and 50, got %d and %d", db.EntriesPerPageMin, db.EntriesPerPageMax)
+		}
+		rt, ok := db.Client.Transport.(RoundTripperWithHeader)
+		if !ok {
+			t.Fatalf("Unexpected transport type %T", db.Client.Transport)
+		}
+		if ua := rt.Header.Get("User-Agent"); ua != "getwtxt-ng/test" {
+			t.Errorf("Expected User-Agent getwtxt-ng/test, got %s", ua)
+		}
+	})
+	t.Run("http tuning", func(t *testing.T) {
+		db, err := Open(":memory:", WithHTTPTuning(HTTPTuning{Timeout: time.Minute, MaxIdleConnsPerHost: 16}))
+		if err != nil {
+			t.Fatal(err.Error())
+		}
+		defer func() {
+			_ = db.conn.Close()
+		}()
+		if db.Client.Timeout != time.Minute {
+			t.Errorf("Expected timeout of a minute, got %s", db.Client.Timeout)
+		}
+		rt, ok := db.Client.Transport.(RoundTripperWithHeader)
+		if !ok {
+			t.Fatalf("Unexpected transport type %T", db.Client.Transport)
+		}
+		transport, ok := rt.rt.(*http.Transport)
+		if !ok {
+			t.Fatalf("Unexpected round tripper type %T", rt.rt)
+		}
+		if transport.MaxIdleConnsPerHost != 16 {
+			t.Errorf("Expected 16 idle connections per host, got %d", transport.MaxIdleConnsPerHost)
+		}
+		if transport.TLSHandshakeTimeout != defaultFetchTLSHandshakeTimeout {
+			t.Errorf("Expected default TLS handshake timeout, got %s", transport.TLSHandshakeTimeout)
+		}
+		if transport.IdleConnTimeout != defaultFetchIdleConnTimeout || transport.DisableKeepAlives {
+			t.Error("Expected idle connections to be kept for reuse")
 		}
-		if tables[1] != "tweets" && tables[2] != "users" {
-			t.Errorf("Got unexpected table names: %v", tables)
+	})
+	t.Run("http client", func(t *testing.T) {
+		client := &http.Client{}
+		db, err := Open(":memory:", WithHTTPClient(client), WithUserAgent("ignored"))
+		if err != nil {
+			t.Fatal(err.Error())
+		}
+		defer func() {
+			_ = db.conn.Close()
+		}()
+		if db.Client != client {
+			t.Error("Expected provided HTTP client to be used")
 		}
 	})
 }
+
+func TestDB_prepared(t *testing.T) {
+	db, err := Open(":memory:")
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	defer func() {
+		_ = db.conn.Close()
+	}()
+	ctx := context.Background()
+	query := "SELECT count(*) FROM users"
+
+	first, err := db.prepared(ctx, query)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	second, err := db.prepared(ctx, query)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	if first != second {
+		t.Error("Expected the cached statement to be reused")
+	}
+	if _, err := db.prepared(ctx, "SELECT nonsense FROM nowhere"); err == nil {
+		t.Error("Expected error preparing invalid query")
+	}
+	if len(db.stmts) != 1 {
+		t.Errorf("Expected 1 cached statement, got %d", len(db.stmts))
+	}
+}
+
+func TestDB_Close(t *testing.T) {
+	db, err := Open(":memory:")
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	ctx := context.Background()
+	stmt, err := db.prepared(ctx, "SELECT count(*) FROM users")
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+
+	if err := db.Close(); err != nil {
+		t.Fatal(err.Error())
+	}
+	if len(db.stmts) != 0 {
+		t.Errorf("Expected statement cache to be cleared, got %d", len(db.stmts))
+	}
+	if _, err := stmt.QueryContext(ctx); err == nil {
+		t.Error("Expected error using closed statement")
+	}
+	if err := db.conn.PingContext(ctx); err == nil {
+		t.Error("Expected error using closed connection")
+	}
+}
+
+func TestDB_withQueryTimeout(t *testing.T) {
+	db, err := Open(":memory:", WithQueryTimeout(time.Nanosecond))
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	defer func() {
+		_ = db.Close()
+	}()
+	ctx := context.Background()
+
+	if _, err := db.SearchTweets(ctx, 1, 20, "anything", StatusVisible); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("Expected search to be cut off, got: %v", err)
+	}
+	if _, err := db.GetUsers(ctx, 1, 20); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("Expected user listing to be cut off, got: %v", err)
+	}
+
+	db.queryTimeout = 0
+	if _, err := db.SearchTweets(ctx, 1, 20, "anything", StatusVisible); err != nil {
+		t.Errorf("Expected search without a timeout to succeed, got: %s", err)
+	}
+}
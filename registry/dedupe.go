@@ -0,0 +1,276 @@
+package registry
+
+/*
+Copyright 2021 G. Benjamin Morrison
+
+This file is part of getwtxt-ng.
+
+getwtxt-ng is free software: you can redistribute it and/or modify
+it under the terms of the GNU Affero General Public License as
+published by the Free Software Foundation, either version 3 of the
+License, or (at your option) any later version.
+
+getwtxt-ng is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU Affero General Public License for more details.
+
+You should have received a copy of the GNU Affero General Public License
+along with getwtxt-ng.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+)
+
+// DedupeMode decides when a tweet being inserted is the same as one already stored.
+// Feed generators have different quirks, such as rewriting timestamps or editing twts in place.
+type DedupeMode string
+
+const (
+	// DedupeStrict considers tweets the same when their author, timestamp, and body all match.
+	// A new tweet sharing its author and timestamp with exactly one stored tweet is an edit of it,
+	// unless the feed still holds the stored one.
+	DedupeStrict DedupeMode = "strict"
+
+	// DedupeTimestamp considers tweets the same when their author and timestamp match.
+	// A different body is an edit of the first tweet stored with that timestamp, unless the feed still holds it.
+	DedupeTimestamp DedupeMode = "timestamp"
+
+	// DedupeContentHash considers tweets the same when their author and body match, whatever their timestamps.
+	DedupeContentHash DedupeMode = "content-hash"
+)
+
+// ErrInvalidDedupeMode is returned when a deduplication mode other than the known ones is provided.
+var ErrInvalidDedupeMode = errors.New("invalid deduplication mode")
+
+// ParseDedupeMode converts the provided string into a DedupeMode. An empty string is DedupeStrict.
+func ParseDedupeMode(mode string) (DedupeMode, error) {
+	m := DedupeMode(strings.ToLower(strings.TrimSpace(mode)))
+	switch m {
+	case "":
+		return DedupeStrict, nil
+	case DedupeStrict, DedupeTimestamp, DedupeContentHash:
+		return m, nil
+	default:
+		return "", fmt.Errorf("%w: %s", ErrInvalidDedupeMode, mode)
+	}
+}
+
+// dedupeInserted applies the DB's Dedupe mode to tweets that were just inserted, removing the ones that
+// duplicate an earlier tweet and folding edits into the tweets they edit. incoming holds the bodies of
+// every tweet being inserted, as grouped by incomingBodies, so a stored tweet the feed still holds isn't
+// taken to be edited. Returns the tweets that are really new and the tweets that were edited.
+func (d *DB) dedupeInserted(ctx context.Context, tx *sql.Tx, inserted []Tweet, incoming map[string]map[string]bool) ([]Tweet, []Tweet, error) {
+	if len(inserted) == 0 {
+		return inserted, nil, nil
+	}
+
+	switch d.Dedupe {
+	case DedupeTimestamp:
+		return d.dedupeByTimestamp(ctx, tx, inserted, incoming)
+	case DedupeContentHash:
+		kept, err := d.dedupeByContent(ctx, tx, inserted)
+		return kept, nil, err
+	default:
+		return d.foldTweetEdits(ctx, tx, inserted, incoming)
+	}
+}
+
+// timestampKey identifies the tweets of a user sharing a timestamp.
+func timestampKey(userID string, dt time.Time) string {
+	return fmt.Sprintf("%s %d", userID, dt.UnixNano())
+}
+
+// incomingBodies groups the distinct bodies of the tweets being inserted by their timestampKey.
+func incomingBodies(tweets []Tweet) map[string]map[string]bool {
+	incoming := make(map[string]map[string]bool, len(tweets))
+	for _, t := range tweets {
+		key := timestampKey(t.UserID, t.DateTime)
+		if incoming[key] == nil {
+			incoming[key] = make(map[string]bool)
+		}
+		incoming[key][t.Body] = true
+	}
+
+	return incoming
+}
+
+// priorTweet is a stored tweet that an inserted one may duplicate or edit.
+type priorTweet struct {
+	id   string
+	body string
+}
+
+// findPriorTweets runs a query selecting (added.id, prior.id, prior.body) for the inserted tweets,
+// whose IDs are substituted for %s, and groups the priors by the ID of the tweet they were found for.
+func (d *DB) findPriorTweets(ctx context.Context, tx *sql.Tx, query string, inserted []Tweet) (map[string][]priorTweet, error) {
+	args := make([]interface{}, 0, len(inserted))
+	for _, t := range inserted {
+		args = append(args, t.ID)
+	}
+	placeholders := strings.TrimSuffix(strings.Repeat("?,", len(inserted)), ",")
+
+	rows, err := tx.QueryContext(ctx, fmt.Sprintf(query, placeholders), args...)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		_ = rows.Close()
+	}()
+
+	priors := make(map[string][]priorTweet)
+	for rows.Next() {
+		addedID := ""
+		prior := priorTweet{}
+		if err := rows.Scan(&addedID, &prior.id, &prior.body); err != nil {
+			d.logger.Debugf("when scanning prior version of tweet: %s", err)
+			continue
+		}
+		priors[addedID] = append(priors[addedID], prior)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return priors, nil
+}
+
+// foldTweetEdits finds newly inserted tweets that share their author and timestamp with exactly one other tweet,
+// and folds each into that tweet as an edit. Several incoming tweets with the same author and timestamp are
+// separate twts rather than edits of each other, as is a new tweet whose feed still holds the other one.
+func (d *DB) foldTweetEdits(ctx context.Context, tx *sql.Tx, inserted []Tweet, incoming map[string]map[string]bool) ([]Tweet, []Tweet, error) {
+	priors, err := d.findPriorTweets(ctx, tx, `SELECT added.id, prior.id, prior.body
+					FROM tweets AS added JOIN tweets AS prior
+					    ON prior.user_id = added.user_id AND prior.dt = added.dt AND prior.id != added.id
+					WHERE added.id IN (%s)`, inserted)
+	if err != nil || len(priors) == 0 {
+		return inserted, nil, err
+	}
+
+	kept := make([]Tweet, 0, len(inserted))
+	edited := make([]Tweet, 0, len(priors))
+	for _, t := range inserted {
+		prior := priors[t.ID]
+		bodies := incoming[timestampKey(t.UserID, t.DateTime)]
+		if len(prior) != 1 || len(bodies) != 1 || bodies[prior[0].body] {
+			kept = append(kept, t)
+			continue
+		}
+		if err := foldTweetInto(ctx, tx, t, prior[0]); err != nil {
+			return nil, nil, err
+		}
+		t.ID = prior[0].id
+		edited = append(edited, t)
+	}
+
+	return kept, edited, nil
+}
+
+// dedupeByTimestamp treats every inserted tweet sharing its author and timestamp with an earlier tweet as the same twt.
+// If the earliest of them was already stored, the inserted tweet is an edit of it. If it was inserted
+// alongside, or the feed still holds it, the later ones are dropped.
+func (d *DB) dedupeByTimestamp(ctx context.Context, tx *sql.Tx, inserted []Tweet, incoming map[string]map[string]bool) ([]Tweet, []Tweet, error) {
+	priors, err := d.findPriorTweets(ctx, tx, `SELECT added.id, prior.id, prior.body
+					FROM tweets AS added JOIN tweets AS prior
+					    ON prior.user_id = added.user_id AND prior.dt = added.dt AND prior.id < added.id
+					WHERE added.id IN (%s)
+					ORDER BY prior.id ASC`, inserted)
+	if err != nil || len(priors) == 0 {
+		return inserted, nil, err
+	}
+
+	insertedIDs := make(map[string]bool, len(inserted))
+	for _, t := range inserted {
+		insertedIDs[t.ID] = true
+	}
+	// Later tweets in the feed win, so fold in the order they were inserted.
+	sort.SliceStable(inserted, func(i, j int) bool {
+		return inserted[i].Ingested.Before(inserted[j].Ingested)
+	})
+
+	kept := make([]Tweet, 0, len(inserted))
+	edited := make([]Tweet, 0, len(priors))
+	editedIdx := make(map[string]int)
+	for _, t := range inserted {
+		prior, ok := priors[t.ID]
+		if !ok {
+			kept = append(kept, t)
+			continue
+		}
+		first := prior[0]
+		if insertedIDs[first.id] || incoming[timestampKey(t.UserID, t.DateTime)][first.body] {
+			if _, err := tx.ExecContext(ctx, "DELETE FROM tweets WHERE id = ?", t.ID); err != nil {
+				return nil, nil, fmt.Errorf("when dropping tweet %s with the same timestamp as %s: %w", t.ID, first.id, err)
+			}
+			continue
+		}
+
+		// The prior body may already have been replaced by an earlier tweet in this batch.
+		if i, ok := editedIdx[first.id]; ok {
+			first.body = edited[i].Body
+		}
+		if err := foldTweetInto(ctx, tx, t, first); err != nil {
+			return nil, nil, err
+		}
+		t.ID = first.id
+		if i, ok := editedIdx[first.id]; ok {
+			edited[i] = t
+		} else {
+			editedIdx[first.id] = len(edited)
+			edited = append(edited, t)
+		}
+	}
+
+	return kept, edited, nil
+}
+
+// dedupeByContent drops inserted tweets whose author already has a tweet with the same body, keeping the earliest.
+func (d *DB) dedupeByContent(ctx context.Context, tx *sql.Tx, inserted []Tweet) ([]Tweet, error) {
+	priors, err := d.findPriorTweets(ctx, tx, `SELECT added.id, prior.id, prior.body
+					FROM tweets AS added JOIN tweets AS prior
+					    ON prior.user_id = added.user_id AND prior.body = added.body AND prior.id < added.id
+					WHERE added.id IN (%s)`, inserted)
+	if err != nil || len(priors) == 0 {
+		return inserted, err
+	}
+
+	kept := make([]Tweet, 0, len(inserted))
+	for _, t := range inserted {
+		if _, ok := priors[t.ID]; !ok {
+			kept = append(kept, t)
+			continue
+		}
+		if _, err := tx.ExecContext(ctx, "DELETE FROM tweets WHERE id = ?", t.ID); err != nil {
+			return nil, fmt.Errorf("when dropping tweet %s with duplicate content: %w", t.ID, err)
+		}
+	}
+
+	return kept, nil
+}
+
+// foldTweetInto makes the inserted tweet an edit of prior: prior keeps its ID and takes the new body,
+// its old body is stored as a revision, and the inserted row is removed.
+func foldTweetInto(ctx context.Context, tx *sql.Tx, t Tweet, prior priorTweet) error {
+	if _, err := tx.ExecContext(ctx, "INSERT INTO tweet_revisions (tweet_id, body, dt_replaced) VALUES (?, ?, ?)", prior.id, prior.body, time.Now().UnixNano()); err != nil {
+		return fmt.Errorf("when storing revision of tweet %s: %w", prior.id, err)
+	}
+	// The new row has to go first, or the update would collide with it on (user_id, dt, body) and be ignored.
+	if _, err := tx.ExecContext(ctx, "DELETE FROM tweets WHERE id = ?", t.ID); err != nil {
+		return fmt.Errorf("when folding tweet %s into %s: %w", t.ID, prior.id, err)
+	}
+	hasMentions, hasTags := tweetBodyFlags(t.Body)
+	mentions, tags := tweetEntities(t.Body)
+	updateStmt := "UPDATE tweets SET body = ?, contains_mentions = ?, contains_tags = ?, dt_ingested = ?, hash = ?, subject = ?, mentions = ?, tags = ?, lang = ? WHERE id = ?"
+	if _, err := tx.ExecContext(ctx, updateStmt, t.Body, hasMentions, hasTags, t.Ingested.UnixNano(), t.Hash, tweetSubject(t.Body), mentions, tags, t.Lang, prior.id); err != nil {
+		return fmt.Errorf("when folding tweet %s into %s: %w", t.ID, prior.id, err)
+	}
+
+	return nil
+}
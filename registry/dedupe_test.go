@@ -0,0 +1,219 @@
+package registry
+
+/*
+Copyright 2021 G. Benjamin Morrison
+
+This file is part of getwtxt-ng.
+
+getwtxt-ng is free software: you can redistribute it and/or modify
+it under the terms of the GNU Affero General Public License as
+published by the Free Software Foundation, either version 3 of the
+License, or (at your option) any later version.
+
+getwtxt-ng is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU Affero General Public License for more details.
+
+You should have received a copy of the GNU Affero General Public License
+along with getwtxt-ng.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"testing"
+	"time"
+)
+
+func TestParseDedupeMode(t *testing.T) {
+	tests := map[string]DedupeMode{
+		"":             DedupeStrict,
+		"strict":       DedupeStrict,
+		" Timestamp ":  DedupeTimestamp,
+		"content-hash": DedupeContentHash,
+		"CONTENT-HASH": DedupeContentHash,
+	}
+	for in, want := range tests {
+		got, err := ParseDedupeMode(in)
+		if err != nil {
+			t.Errorf("Expected %q to parse, got: %s", in, err)
+		}
+		if got != want {
+			t.Errorf("Parsing %q: expected %s, got %s", in, want, got)
+		}
+	}
+	if _, err := ParseDedupeMode("fuzzy"); !errors.Is(err, ErrInvalidDedupeMode) {
+		t.Errorf("Expected ErrInvalidDedupeMode, got: %v", err)
+	}
+}
+
+func TestDB_InsertTweets_Dedupe(t *testing.T) {
+	ctx := context.Background()
+	original := populatedDBTweets[0]
+	dt := time.Now().UTC().Truncate(time.Second)
+
+	t.Run("strict keeps tweets sharing a new timestamp", func(t *testing.T) {
+		memDB := getPopulatedDB(t)
+		res, err := memDB.InsertTweets(ctx, []Tweet{
+			{UserID: "1", DateTime: dt, Body: "one"},
+			{UserID: "1", DateTime: dt, Body: "two"},
+			{UserID: "1", DateTime: dt.Add(time.Minute), Body: original.Body},
+		})
+		if err != nil {
+			t.Fatal(err.Error())
+		}
+		if res.Inserted != 3 {
+			t.Errorf("Expected 3 new tweets, got: %+v", res)
+		}
+	})
+
+	t.Run("timestamp", func(t *testing.T) {
+		memDB := getPopulatedDB(t)
+		memDB.Dedupe = DedupeTimestamp
+		res, err := memDB.InsertTweets(ctx, []Tweet{
+			{UserID: "1", DateTime: dt, Body: "one"},
+			{UserID: "1", DateTime: dt, Body: "two"},
+			{UserID: original.UserID, DateTime: original.DateTime, Body: "first edit"},
+			{UserID: original.UserID, DateTime: original.DateTime, Body: "second edit"},
+		})
+		if err != nil {
+			t.Fatal(err.Error())
+		}
+		if res.Inserted != 1 || res.Edited != 1 || res.Ignored != 2 {
+			t.Errorf("Expected 1 new, 1 edited, and 2 ignored, got: %+v", res)
+		}
+
+		out, err := memDB.GetTweetsByID(ctx, []string{original.ID})
+		if err != nil {
+			t.Fatal(err.Error())
+		}
+		if len(out) != 1 || out[0].Body != "second edit" {
+			t.Errorf("Expected the last edit to win, got: %+v", out)
+		}
+		revisions, err := memDB.GetTweetRevisions(ctx, original.ID)
+		if err != nil {
+			t.Fatal(err.Error())
+		}
+		if len(revisions) != 2 {
+			t.Errorf("Expected 2 revisions, got %d", len(revisions))
+		}
+		if err := memDB.CheckSearchIndex(ctx); err != nil {
+			t.Error(err.Error())
+		}
+	})
+
+	t.Run("content-hash", func(t *testing.T) {
+		memDB := getPopulatedDB(t)
+		memDB.Dedupe = DedupeContentHash
+		res, err := memDB.InsertTweets(ctx, []Tweet{
+			{UserID: original.UserID, DateTime: dt, Body: original.Body},
+			{UserID: "1", DateTime: dt, Body: "fresh"},
+			{UserID: "1", DateTime: dt.Add(time.Hour), Body: "fresh"},
+			// The same body from someone else isn't a duplicate.
+			{UserID: "2", DateTime: dt, Body: "fresh"},
+		})
+		if err != nil {
+			t.Fatal(err.Error())
+		}
+		if res.Inserted != 2 || res.Ignored != 2 {
+			t.Errorf("Expected 2 new and 2 ignored, got: %+v", res)
+		}
+		if err := memDB.CheckSearchIndex(ctx); err != nil {
+			t.Error(err.Error())
+		}
+	})
+}
+
+func TestDB_InsertTweets_DedupeRepeatedSync(t *testing.T) {
+	ctx := context.Background()
+	dt := time.Now().UTC().Truncate(time.Second)
+	x := Tweet{UserID: "1", DateTime: dt, Body: "same second, first twt"}
+	y := Tweet{UserID: "1", DateTime: dt, Body: "same second, second twt"}
+
+	tests := []struct {
+		mode DedupeMode
+		// syncs are the successive contents of the feed.
+		syncs [][]Tweet
+		// bodies are the ones stored at dt once the feed holds both twts.
+		bodies []string
+	}{
+		{DedupeStrict, [][]Tweet{{x, y}, {x, y}, {x, y}}, []string{x.Body, y.Body}},
+		{DedupeStrict, [][]Tweet{{x}, {x, y}, {x, y}}, []string{x.Body, y.Body}},
+		{DedupeTimestamp, [][]Tweet{{x, y}, {x, y}, {x, y}}, []string{x.Body}},
+		{DedupeTimestamp, [][]Tweet{{x}, {x, y}, {x, y}}, []string{x.Body}},
+		{DedupeContentHash, [][]Tweet{{x, y}, {x, y}, {x, y}}, []string{x.Body, y.Body}},
+		{DedupeContentHash, [][]Tweet{{x}, {x, y}, {x, y}}, []string{x.Body, y.Body}},
+	}
+	for i, tt := range tests {
+		t.Run(fmt.Sprintf("%s %d", tt.mode, i), func(t *testing.T) {
+			memDB := getPopulatedDB(t)
+			memDB.Dedupe = tt.mode
+			for n, feed := range tt.syncs {
+				res, err := memDB.InsertTweets(ctx, feed)
+				if err != nil {
+					t.Fatal(err.Error())
+				}
+				if res.Edited != 0 {
+					t.Errorf("Sync %d: expected no edits, got: %+v", n+1, res)
+				}
+				if n > 0 {
+					assertBodiesAt(t, memDB, "1", dt, tt.bodies)
+				}
+			}
+
+			revisions := 0
+			if err := memDB.conn.QueryRow("SELECT COUNT(*) FROM tweet_revisions").Scan(&revisions); err != nil {
+				t.Fatal(err.Error())
+			}
+			if revisions != 0 {
+				t.Errorf("Expected no revisions, got %d", revisions)
+			}
+		})
+	}
+
+	t.Run("strict counts tweets sharing a timestamp across batches", func(t *testing.T) {
+		memDB := getPopulatedDB(t)
+		feed := make([]Tweet, 0, insertTweetsBatchSize+1)
+		for i := 0; i < insertTweetsBatchSize-1; i++ {
+			feed = append(feed, Tweet{UserID: "1", DateTime: dt.Add(time.Duration(i+1) * time.Second), Body: fmt.Sprintf("filler %d", i)})
+		}
+		// x ends the first batch and y starts the second.
+		feed = append(feed, x, y)
+		res, err := memDB.InsertTweets(ctx, feed)
+		if err != nil {
+			t.Fatal(err.Error())
+		}
+		if res.Inserted != len(feed) || res.Edited != 0 {
+			t.Errorf("Expected %d new tweets, got: %+v", len(feed), res)
+		}
+		assertBodiesAt(t, memDB, "1", dt, []string{x.Body, y.Body})
+	})
+}
+
+// assertBodiesAt checks the bodies of the user's tweets stored with the timestamp.
+func assertBodiesAt(t *testing.T, memDB *DB, userID string, dt time.Time, want []string) {
+	t.Helper()
+	rows, err := memDB.conn.Query("SELECT body FROM tweets WHERE user_id = ? AND dt = ? ORDER BY body ASC", userID, dt.UnixNano())
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	defer func() {
+		_ = rows.Close()
+	}()
+	got := make([]string, 0, len(want))
+	for rows.Next() {
+		body := ""
+		if err := rows.Scan(&body); err != nil {
+			t.Fatal(err.Error())
+		}
+		got = append(got, body)
+	}
+	sorted := append([]string(nil), want...)
+	sort.Strings(sorted)
+	if fmt.Sprint(got) != fmt.Sprint(sorted) {
+		t.Errorf("Expected %q to be stored, got %q", sorted, got)
+	}
+}
@@ -0,0 +1,166 @@
+package registry
+
+/*
+Copyright 2021 G. Benjamin Morrison
+
+This file is part of getwtxt-ng.
+
+getwtxt-ng is free software: you can redistribute it and/or modify
+it under the terms of the GNU Affero General Public License as
+published by the Free Software Foundation, either version 3 of the
+License, or (at your option) any later version.
+
+getwtxt-ng is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU Affero General Public License for more details.
+
+You should have received a copy of the GNU Affero General Public License
+along with getwtxt-ng.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// ErrUserNotPendingDeletion is returned when cancelling the deletion of a user who isn't pending deletion.
+var ErrUserNotPendingDeletion = errors.New("user isn't pending deletion")
+
+// ScheduleUserDeletion hides the user from listings and stops syncing their feed until after, when
+// DeletePendingUsers removes them and their tweets. If they're already pending deletion, the time they were
+// scheduled for is kept. Returns when they'll be deleted.
+func (d *DB) ScheduleUserDeletion(ctx context.Context, u *User, after time.Time) (time.Time, error) {
+	if u == nil || u.ID == "" {
+		return time.Time{}, ErrNoUsersProvided
+	}
+
+	tx, err := d.beginWrite(ctx)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("when beginning tx to schedule deletion of user %s: %w", u.URL, err)
+	}
+	defer func() {
+		_ = tx.Rollback()
+	}()
+
+	stmt := `UPDATE users SET status_before_deletion = status, status = ?, delete_after = ?
+				WHERE id = ? AND status != ?`
+	if _, err := tx.ExecContext(ctx, stmt, UserStatusPendingDeletion, after.UnixNano(), u.ID, UserStatusPendingDeletion); err != nil {
+		return time.Time{}, fmt.Errorf("when scheduling deletion of user %s: %w", u.URL, err)
+	}
+	deleteAfter := int64(0)
+	err = tx.QueryRowContext(ctx, "SELECT delete_after FROM users WHERE id = ?", u.ID).Scan(&deleteAfter)
+	if errors.Is(err, sql.ErrNoRows) {
+		return time.Time{}, fmt.Errorf("when scheduling deletion of user %s: %w", u.URL, ErrUserNotFound)
+	}
+	if err != nil {
+		return time.Time{}, fmt.Errorf("when reading scheduled deletion of user %s: %w", u.URL, err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return time.Time{}, fmt.Errorf("when committing tx to schedule deletion of user %s: %w", u.URL, err)
+	}
+	d.invalidate()
+
+	return time.Unix(0, deleteAfter).UTC(), nil
+}
+
+// CancelUserDeletion keeps a user pending deletion from being deleted, restoring the status they had before.
+// Returns ErrUserNotPendingDeletion if they aren't.
+func (d *DB) CancelUserDeletion(ctx context.Context, u *User) (UserStatus, error) {
+	if u == nil || u.ID == "" {
+		return "", ErrNoUsersProvided
+	}
+
+	tx, err := d.beginWrite(ctx)
+	if err != nil {
+		return "", fmt.Errorf("when beginning tx to cancel deletion of user %s: %w", u.URL, err)
+	}
+	defer func() {
+		_ = tx.Rollback()
+	}()
+
+	stmt := `UPDATE users SET status = CASE status_before_deletion WHEN '' THEN ? ELSE status_before_deletion END,
+				status_before_deletion = '', delete_after = 0
+				WHERE id = ? AND status = ?`
+	res, err := tx.ExecContext(ctx, stmt, UserStatusActive, u.ID, UserStatusPendingDeletion)
+	if err != nil {
+		return "", fmt.Errorf("when cancelling deletion of user %s: %w", u.URL, err)
+	}
+	if n, err := res.RowsAffected(); err != nil {
+		return "", fmt.Errorf("when cancelling deletion of user %s: %w", u.URL, err)
+	} else if n == 0 {
+		return "", fmt.Errorf("when cancelling deletion of user %s: %w", u.URL, ErrUserNotPendingDeletion)
+	}
+	status := UserStatus("")
+	if err := tx.QueryRowContext(ctx, "SELECT status FROM users WHERE id = ?", u.ID).Scan(&status); err != nil {
+		return "", fmt.Errorf("when reading restored status of user %s: %w", u.URL, err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return "", fmt.Errorf("when committing tx to cancel deletion of user %s: %w", u.URL, err)
+	}
+	d.invalidate()
+
+	return status, nil
+}
+
+// DeletePendingUsers removes the users whose deletion was scheduled for before now, along with their tweets.
+// Returns the URLs of the users deleted and the number of tweets.
+func (d *DB) DeletePendingUsers(ctx context.Context, now time.Time) ([]string, int64, error) {
+	tx, err := d.beginWrite(ctx)
+	if err != nil {
+		return nil, 0, fmt.Errorf("when beginning tx to delete users pending deletion: %w", err)
+	}
+	defer func() {
+		_ = tx.Rollback()
+	}()
+
+	rows, err := tx.QueryContext(ctx, "SELECT url FROM users WHERE status = ? AND delete_after <= ?", UserStatusPendingDeletion, now.UnixNano())
+	if err != nil {
+		return nil, 0, fmt.Errorf("when querying for users due for deletion: %w", err)
+	}
+	urls := make([]string, 0)
+	for rows.Next() {
+		userURL := ""
+		if err := rows.Scan(&userURL); err != nil {
+			_ = rows.Close()
+			return nil, 0, fmt.Errorf("when scanning user due for deletion: %w", err)
+		}
+		urls = append(urls, userURL)
+	}
+	err = rows.Err()
+	_ = rows.Close()
+	if err != nil {
+		return nil, 0, fmt.Errorf("when reading users due for deletion: %w", err)
+	}
+	if len(urls) == 0 {
+		return urls, 0, nil
+	}
+
+	where := "status = ? AND delete_after <= ?"
+	res, err := tx.ExecContext(ctx, "DELETE FROM tweets WHERE user_id IN (SELECT id FROM users WHERE "+where+")", UserStatusPendingDeletion, now.UnixNano())
+	if err != nil {
+		return nil, 0, fmt.Errorf("when deleting tweets of %d users due for deletion: %w", len(urls), err)
+	}
+	tweets, err := res.RowsAffected()
+	if err != nil {
+		return nil, 0, fmt.Errorf("when deleting tweets of %d users due for deletion: %w", len(urls), err)
+	}
+	if _, err := tx.ExecContext(ctx, "DELETE FROM users WHERE "+where, UserStatusPendingDeletion, now.UnixNano()); err != nil {
+		return nil, 0, fmt.Errorf("when deleting %d users due for deletion: %w", len(urls), err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, 0, fmt.Errorf("when committing tx to delete %d users due for deletion: %w", len(urls), err)
+	}
+	d.invalidate()
+
+	d.Hooks.usersDeleted(ctx, urls)
+	d.Hooks.tweetsDeleted(ctx, tweets)
+
+	return urls, tweets, nil
+}
@@ -0,0 +1,101 @@
+package registry
+
+/*
+Copyright 2021 G. Benjamin Morrison
+
+This file is part of getwtxt-ng.
+
+getwtxt-ng is free software: you can redistribute it and/or modify
+it under the terms of the GNU Affero General Public License as
+published by the Free Software Foundation, either version 3 of the
+License, or (at your option) any later version.
+
+getwtxt-ng is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU Affero General Public License for more details.
+
+You should have received a copy of the GNU Affero General Public License
+along with getwtxt-ng.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestDB_ScheduleUserDeletion(t *testing.T) {
+	memDB := getPopulatedDB(t)
+	ctx := context.Background()
+	now := time.Date(2022, 10, 19, 0, 0, 0, 0, time.UTC)
+
+	users, err := memDB.GetUsersByID(ctx, []string{"1", "2"})
+	if err != nil || len(users) != 2 {
+		t.Fatalf("Expected both users, got %d, %v", len(users), err)
+	}
+	first, second := &users[0], &users[1]
+	if first.ID != "1" {
+		first, second = second, first
+	}
+	if _, err := memDB.SetUserStatus(ctx, UserStatusSuspended, second.URL); err != nil {
+		t.Fatal(err.Error())
+	}
+
+	deleteAt, err := memDB.ScheduleUserDeletion(ctx, first, now.Add(time.Hour))
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	if !deleteAt.Equal(now.Add(time.Hour)) {
+		t.Errorf("Expected deletion to be scheduled for %s, got %s", now.Add(time.Hour), deleteAt)
+	}
+	// Asking again doesn't push the deletion back.
+	if deleteAt, err := memDB.ScheduleUserDeletion(ctx, first, now.Add(2*time.Hour)); err != nil || !deleteAt.Equal(now.Add(time.Hour)) {
+		t.Errorf("Expected the first schedule to be kept, got %s, %v", deleteAt, err)
+	}
+	if _, err := memDB.ScheduleUserDeletion(ctx, second, now.Add(time.Hour)); err != nil {
+		t.Fatal(err.Error())
+	}
+
+	if listed, err := memDB.GetUsersByID(ctx, []string{"1"}); err != nil || len(listed) != 0 {
+		t.Errorf("Expected a user pending deletion to be left out of listings, got %d, %v", len(listed), err)
+	}
+	due, err := memDB.GetUsersDueForSync(ctx, 0, time.Now())
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	for _, u := range due {
+		if u.ID == first.ID {
+			t.Error("Expected a user pending deletion not to be synced")
+		}
+	}
+
+	status, err := memDB.CancelUserDeletion(ctx, second)
+	if err != nil || status != UserStatusSuspended {
+		t.Errorf("Expected the suspended user to stay suspended, got %s, %v", status, err)
+	}
+	if _, err := memDB.CancelUserDeletion(ctx, second); !errors.Is(err, ErrUserNotPendingDeletion) {
+		t.Errorf("Expected ErrUserNotPendingDeletion, got %v", err)
+	}
+	if _, err := memDB.ScheduleUserDeletion(ctx, &User{ID: "999"}, now); !errors.Is(err, ErrUserNotFound) {
+		t.Errorf("Expected ErrUserNotFound for an unknown user, got %v", err)
+	}
+
+	if urls, tweets, err := memDB.DeletePendingUsers(ctx, now); err != nil || len(urls) != 0 || tweets != 0 {
+		t.Errorf("Expected nobody to be deleted before their time, got %v, %d, %v", urls, tweets, err)
+	}
+	urls, tweets, err := memDB.DeletePendingUsers(ctx, now.Add(time.Hour))
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	if len(urls) != 1 || urls[0] != first.URL || tweets == 0 {
+		t.Errorf("Expected the first user and their tweets to be deleted, got %v, %d", urls, tweets)
+	}
+	if _, err := memDB.GetFullUserByURL(ctx, first.URL); !errors.Is(err, ErrUserNotFound) {
+		t.Errorf("Expected the first user to be gone, got %v", err)
+	}
+	if _, err := memDB.GetFullUserByURL(ctx, second.URL); err != nil {
+		t.Errorf("Expected the second user to be kept, got %v", err)
+	}
+}
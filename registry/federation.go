@@ -0,0 +1,181 @@
+package registry
+
+/*
+Copyright 2021 G. Benjamin Morrison
+
+This file is part of getwtxt-ng.
+
+getwtxt-ng is free software: you can redistribute it and/or modify
+it under the terms of the GNU Affero General Public License as
+published by the Free Software Foundation, either version 3 of the
+License, or (at your option) any later version.
+
+getwtxt-ng is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU Affero General Public License for more details.
+
+You should have received a copy of the GNU Affero General Public License
+along with getwtxt-ng.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+import (
+	"bufio"
+	"context"
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gbmor/getwtxt-ng/common"
+)
+
+// Limits on pulling a peer registry's user list.
+const (
+	peerUsersPerPage = 1000
+	peerMaxPages     = 1000
+	peerMaxPageBytes = 4 << 20
+)
+
+// ParseUsersPlain reads users from the plain user listing format: nickname, URL, and optionally the time added,
+// separated by whitespace. Lines that don't parse are skipped. Users without a time added were added now.
+func ParseUsersPlain(r io.Reader) []User {
+	users := make([]User, 0)
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) < 2 {
+			continue
+		}
+
+		user := User{Nick: fields[0], URL: fields[1], DateTimeAdded: time.Now().UTC()}
+		if len(fields) > 2 {
+			dt, err := time.Parse(time.RFC3339Nano, fields[2])
+			if err != nil {
+				continue
+			}
+			user.DateTimeAdded = dt
+		}
+		users = append(users, user)
+	}
+
+	return users
+}
+
+// FetchPeerUsers retrieves every user listed by the registry at peerURL, page by page, until a page adds nothing new.
+func (d *DB) FetchPeerUsers(ctx context.Context, peerURL string) ([]User, error) {
+	peerURL = strings.TrimSuffix(peerURL, "/")
+
+	users := make([]User, 0)
+	seen := make(map[string]bool)
+	for page := 1; page <= peerMaxPages; page++ {
+		pageURL := fmt.Sprintf("%s/api/plain/users?page=%d&per_page=%d", peerURL, page, peerUsersPerPage)
+		pageUsers, err := d.fetchPeerUsersPage(ctx, pageURL)
+		if err != nil {
+			return nil, err
+		}
+
+		added := 0
+		for _, u := range pageUsers {
+			if seen[u.URL] {
+				continue
+			}
+			seen[u.URL] = true
+			users = append(users, u)
+			added++
+		}
+		// Peers that ignore the page parameter return the same page forever.
+		if added == 0 {
+			break
+		}
+	}
+
+	return users, nil
+}
+
+func (d *DB) fetchPeerUsersPage(ctx context.Context, pageURL string) ([]User, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, pageURL, nil)
+	if err != nil {
+		return nil, fmt.Errorf("couldn't create http request to fetch %s: %w", pageURL, err)
+	}
+	resp, err := d.Client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("error making http request to %s: %w", pageURL, err)
+	}
+	defer func() {
+		_ = resp.Body.Close()
+	}()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("got status code %d from %s", resp.StatusCode, pageURL)
+	}
+
+	return ParseUsersPlain(io.LimitReader(resp.Body, peerMaxPageBytes)), nil
+}
+
+// ImportPeerUsers registers the users listed by another registry, recording source as where they came from.
+// Users whose feeds are already known here in any status, including suspended ones, are skipped,
+// as are ones that fail validation. Imported users have no usable passcode, so only an admin can remove them.
+// Returns the users added.
+func (d *DB) ImportPeerUsers(ctx context.Context, source string, users []User) ([]User, error) {
+	tx, err := d.beginWrite(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("couldn't begin transaction to import users from %s: %w", source, err)
+	}
+	defer func() {
+		_ = tx.Rollback()
+	}()
+
+	now := time.Now().UnixNano()
+	added := make([]User, 0)
+	for _, u := range users {
+		u.ID = ""
+		u.Status = ""
+		u.PasscodeHash = make([]byte, 16)
+		if _, err := rand.Read(u.PasscodeHash); err != nil {
+			return nil, fmt.Errorf("couldn't generate placeholder passcode: %w", err)
+		}
+		if err := validateNewUser(&u); err != nil || !common.IsValidURL(u.URL, d.logger) {
+			d.logger.Debugf("Skipping %s from %s: invalid user info", u.URL, source)
+			continue
+		}
+
+		if err := insertUserTx(ctx, tx.Tx, &u); err != nil {
+			if errors.Is(err, ErrUserExists) || errors.Is(err, ErrUserBanned) {
+				continue
+			}
+			return nil, err
+		}
+		if _, err := tx.ExecContext(ctx, "INSERT INTO user_sources (user_id, source, dt_added) VALUES (?, ?, ?)", u.ID, source, now); err != nil {
+			return nil, fmt.Errorf("when recording source of user %s: %w", u.URL, err)
+		}
+		u.Status = UserStatusActive
+		added = append(added, u)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, fmt.Errorf("error committing tx to import users from %s: %w", source, err)
+	}
+	d.invalidate()
+
+	d.Hooks.usersInserted(ctx, added)
+
+	return added, nil
+}
+
+// GetUserSource returns the peer registry the user with the provided URL was federated from,
+// or an empty string if they registered here.
+func (d *DB) GetUserSource(ctx context.Context, userURL string) (string, error) {
+	ctx, cancel := d.withQueryTimeout(ctx)
+	defer cancel()
+
+	source := ""
+	stmt := "SELECT COALESCE(user_sources.source, '') FROM users LEFT JOIN user_sources ON user_sources.user_id = users.id WHERE users.url = ?"
+	if err := d.conn.QueryRowContext(ctx, stmt, userURL).Scan(&source); err != nil {
+		return "", fmt.Errorf("unable to query for source of user with URL %s: %w", userURL, err)
+	}
+
+	return source, nil
+}
@@ -0,0 +1,149 @@
+package registry
+
+/*
+Copyright 2021 G. Benjamin Morrison
+
+This file is part of getwtxt-ng.
+
+getwtxt-ng is free software: you can redistribute it and/or modify
+it under the terms of the GNU Affero General Public License as
+published by the Free Software Foundation, either version 3 of the
+License, or (at your option) any later version.
+
+getwtxt-ng is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU Affero General Public License for more details.
+
+You should have received a copy of the GNU Affero General Public License
+along with getwtxt-ng.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestParseUsersPlain(t *testing.T) {
+	input := "foo\thttps://foo.example/twtxt.txt\t2021-06-01T00:00:00Z\n" +
+		"bar https://bar.example/twtxt.txt\n" +
+		"baz\thttps://baz.example/twtxt.txt\tyesterday\n" +
+		"lonely\n"
+
+	users := ParseUsersPlain(strings.NewReader(input))
+	if len(users) != 2 {
+		t.Fatalf("Expected 2 users, got %d: %v", len(users), users)
+	}
+	if users[0].Nick != "foo" || users[0].URL != "https://foo.example/twtxt.txt" || users[0].DateTimeAdded.Year() != 2021 {
+		t.Errorf("Unexpected first user: %v", users[0])
+	}
+	if users[1].Nick != "bar" || users[1].DateTimeAdded.IsZero() {
+		t.Errorf("Unexpected second user: %v", users[1])
+	}
+}
+
+func TestDB_FetchPeerUsers(t *testing.T) {
+	db := getPopulatedDB(t)
+	defer func() {
+		_ = db.Close()
+	}()
+
+	pages := map[string]string{
+		"1": "foo\thttps://foo.example/twtxt.txt\t2021-06-01T00:00:00Z\n",
+		"2": "bar\thttps://bar.example/twtxt.txt\t2021-06-02T00:00:00Z\n",
+	}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/api/plain/users" {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		_, _ = fmt.Fprint(w, pages[r.URL.Query().Get("page")])
+	}))
+	defer srv.Close()
+
+	users, err := db.FetchPeerUsers(context.Background(), srv.URL+"/")
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	if len(users) != 2 || users[0].Nick != "foo" || users[1].Nick != "bar" {
+		t.Errorf("Unexpected users: %v", users)
+	}
+
+	if _, err := db.FetchPeerUsers(context.Background(), srv.URL+"/missing"); err == nil {
+		t.Error("Expected error fetching from a peer that 404s")
+	}
+}
+
+func TestDB_ImportPeerUsers(t *testing.T) {
+	db := getPopulatedDB(t)
+	defer func() {
+		_ = db.Close()
+	}()
+	ctx := context.Background()
+	source := "https://peer.example"
+
+	if _, err := db.conn.Exec("UPDATE users SET status = ? WHERE id = ?", UserStatusSuspended, populatedDBUsers[1].ID); err != nil {
+		t.Fatal(err.Error())
+	}
+
+	hooked := 0
+	db.Hooks.UsersInserted = func(ctx context.Context, users []User) {
+		hooked += len(users)
+	}
+
+	users := []User{
+		{Nick: "foo", URL: "https://foo.example/twtxt.txt"},
+		{Nick: "dupe", URL: "http://www.example.com/twtxt.txt"},
+		{Nick: "banned", URL: "https://example.org/twtxt.txt"},
+		{Nick: "notwtxt", URL: "https://bar.example/feed.xml"},
+		{Nick: "!!!", URL: "https://baz.example/twtxt.txt"},
+	}
+	added, err := db.ImportPeerUsers(ctx, source, users)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	if len(added) != 1 || added[0].Nick != "foo" || added[0].ID == "" {
+		t.Fatalf("Expected only foo to be added, got: %v", added)
+	}
+	if hooked != 1 {
+		t.Errorf("Expected hook to see 1 user, got %d", hooked)
+	}
+
+	got, err := db.GetUserSource(ctx, "https://foo.example/twtxt.txt")
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	if got != source {
+		t.Errorf("Expected source %s, got %s", source, got)
+	}
+	got, err = db.GetUserSource(ctx, populatedDBUsers[0].URL)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	if got != "" {
+		t.Errorf("Expected no source for a local user, got %s", got)
+	}
+
+	again, err := db.ImportPeerUsers(ctx, source, users)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	if len(again) != 0 {
+		t.Errorf("Expected nothing added on a second import, got: %v", again)
+	}
+
+	if _, err := db.DeleteUser(ctx, &added[0]); err != nil {
+		t.Fatal(err.Error())
+	}
+	count := 0
+	if err := db.conn.QueryRow("SELECT COUNT(*) FROM user_sources").Scan(&count); err != nil {
+		t.Fatal(err.Error())
+	}
+	if count != 0 {
+		t.Errorf("Expected source to be removed with the user, got %d rows", count)
+	}
+}
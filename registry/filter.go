@@ -0,0 +1,318 @@
+package registry
+
+/*
+Copyright 2021 G. Benjamin Morrison
+
+This file is part of getwtxt-ng.
+
+getwtxt-ng is free software: you can redistribute it and/or modify
+it under the terms of the GNU Affero General Public License as
+published by the Free Software Foundation, either version 3 of the
+License, or (at your option) any later version.
+
+getwtxt-ng is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU Affero General Public License for more details.
+
+You should have received a copy of the GNU Affero General Public License
+along with getwtxt-ng.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"net/url"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// FilterAction is what happens to a new tweet that matches a content filter rule.
+type FilterAction string
+
+const (
+	// FilterHide stores the tweet hidden until it's released.
+	FilterHide FilterAction = "hide"
+
+	// FilterFlag stores the tweet as usual, but lists it for review.
+	FilterFlag FilterAction = "flag"
+)
+
+// ErrInvalidFilterAction is returned when a filter action other than the known ones is provided.
+var ErrInvalidFilterAction = errors.New("invalid filter action")
+
+// ErrEmptyFilterRule is returned for a filter rule without keywords, a pattern, or domains, which would match everything.
+var ErrEmptyFilterRule = errors.New("filter rule needs keywords, a pattern, or domains")
+
+// ParseFilterAction converts the provided string into a FilterAction. An empty string is FilterHide.
+func ParseFilterAction(action string) (FilterAction, error) {
+	a := FilterAction(strings.ToLower(strings.TrimSpace(action)))
+	switch a {
+	case "":
+		return FilterHide, nil
+	case FilterHide, FilterFlag:
+		return a, nil
+	default:
+		return "", fmt.Errorf("%w: %s", ErrInvalidFilterAction, action)
+	}
+}
+
+// FilterRule matches tweets by their content and the domain of their feed.
+type FilterRule struct {
+	Name   string
+	Action FilterAction
+
+	// Keywords match when the body contains any of them, ignoring case.
+	Keywords []string
+
+	// Pattern, if set, matches when it's found in the body.
+	Pattern *regexp.Regexp
+
+	// Domains limit the rule to feeds on them or their subdomains. A rule with only domains matches
+	// every tweet from them.
+	Domains []string
+}
+
+// NewFilterRule builds a rule from its configuration, compiling the pattern and normalizing keywords and domains.
+func NewFilterRule(name, action string, keywords []string, pattern string, domains []string) (FilterRule, error) {
+	rule := FilterRule{Name: strings.TrimSpace(name)}
+
+	var err error
+	rule.Action, err = ParseFilterAction(action)
+	if err != nil {
+		return FilterRule{}, err
+	}
+	for _, k := range keywords {
+		if k = strings.ToLower(strings.TrimSpace(k)); k != "" {
+			rule.Keywords = append(rule.Keywords, k)
+		}
+	}
+	if pattern != "" {
+		rule.Pattern, err = regexp.Compile(pattern)
+		if err != nil {
+			return FilterRule{}, fmt.Errorf("when compiling pattern of filter rule %s: %w", rule.Name, err)
+		}
+	}
+	for _, domain := range domains {
+		if domain = strings.ToLower(strings.Trim(strings.TrimSpace(domain), ".")); domain != "" {
+			rule.Domains = append(rule.Domains, domain)
+		}
+	}
+	if len(rule.Keywords) == 0 && rule.Pattern == nil && len(rule.Domains) == 0 {
+		return FilterRule{}, fmt.Errorf("%w: %s", ErrEmptyFilterRule, rule.Name)
+	}
+
+	return rule, nil
+}
+
+// Matches reports whether the tweet with the provided body, from the feed at feedURL, matches the rule.
+func (r FilterRule) Matches(feedURL, body string) bool {
+	if len(r.Domains) > 0 && !r.matchesDomain(feedURL) {
+		return false
+	}
+	if len(r.Keywords) == 0 && r.Pattern == nil {
+		return true
+	}
+
+	lowerBody := strings.ToLower(body)
+	for _, k := range r.Keywords {
+		if strings.Contains(lowerBody, k) {
+			return true
+		}
+	}
+
+	return r.Pattern != nil && r.Pattern.MatchString(body)
+}
+
+func (r FilterRule) matchesDomain(feedURL string) bool {
+	parsedURL, err := url.Parse(feedURL)
+	if err != nil {
+		return false
+	}
+	host := strings.ToLower(parsedURL.Hostname())
+	for _, domain := range r.Domains {
+		if host == domain || strings.HasSuffix(host, "."+domain) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// ContentFilter holds the rules new tweets are checked against as they're inserted.
+// The first rule a tweet matches decides what happens to it.
+type ContentFilter struct {
+	Rules []FilterRule
+}
+
+// Match returns the first rule the tweet matches.
+func (f *ContentFilter) Match(feedURL, body string) (FilterRule, bool) {
+	if f == nil {
+		return FilterRule{}, false
+	}
+	for _, rule := range f.Rules {
+		if rule.Matches(feedURL, body) {
+			return rule, true
+		}
+	}
+
+	return FilterRule{}, false
+}
+
+// FilteredTweet is a tweet that matched a content filter rule, waiting for review.
+type FilteredTweet struct {
+	Tweet    Tweet        `json:"tweet"`
+	Rule     string       `json:"rule"`
+	Action   FilterAction `json:"action"`
+	Filtered time.Time    `json:"filtered"`
+}
+
+// FilterCount is how many tweets a content filter rule has caught, and how many of those were released.
+type FilterCount struct {
+	Rule     string       `json:"rule"`
+	Action   FilterAction `json:"action"`
+	Pending  int          `json:"pending"`
+	Released int          `json:"released"`
+}
+
+// filterInsertedTx checks newly inserted and edited tweets against the DB's filter, hiding those
+// matching a FilterHide rule and recording every match for review. feedURLs holds the URL of each
+// tweet's feed, keyed by user ID.
+func (d *DB) filterInsertedTx(ctx context.Context, tx *sql.Tx, feedURLs map[string]string, tweets []Tweet) error {
+	if d.filter == nil {
+		return nil
+	}
+
+	now := time.Now().UnixNano()
+	for i, t := range tweets {
+		rule, ok := d.filter.Match(feedURLs[t.UserID], t.Body)
+		if !ok {
+			continue
+		}
+		if rule.Action == FilterHide {
+			if _, err := tx.ExecContext(ctx, "UPDATE tweets SET hidden = ? WHERE id = ?", StatusHidden, t.ID); err != nil {
+				return fmt.Errorf("when hiding tweet %s matching filter rule %s: %w", t.ID, rule.Name, err)
+			}
+			tweets[i].Hidden = StatusHidden
+		}
+		stmt := "INSERT OR REPLACE INTO filtered_tweets (tweet_id, rule, action, dt_filtered, dt_released) VALUES (?, ?, ?, ?, 0)"
+		if _, err := tx.ExecContext(ctx, stmt, t.ID, rule.Name, string(rule.Action), now); err != nil {
+			return fmt.Errorf("when recording tweet %s matching filter rule %s: %w", t.ID, rule.Name, err)
+		}
+	}
+
+	return nil
+}
+
+// GetFilteredTweets returns up to limit tweets caught by the content filter that haven't been released, newest first.
+func (d *DB) GetFilteredTweets(ctx context.Context, limit int) ([]FilteredTweet, error) {
+	ctx, cancel := d.withQueryTimeout(ctx)
+	defer cancel()
+
+	if limit < 1 {
+		limit = d.EntriesPerPageMax
+	}
+	stmt := `SELECT tweets.id, tweets.user_id, users.nick, users.url, tweets.dt, tweets.body, tweets.hidden, tweets.hash, tweets.utc_offset,
+					filtered_tweets.rule, filtered_tweets.action, filtered_tweets.dt_filtered
+				FROM filtered_tweets
+					JOIN tweets ON tweets.id = filtered_tweets.tweet_id
+					JOIN users ON users.id = tweets.user_id
+				WHERE filtered_tweets.dt_released = 0
+				ORDER BY filtered_tweets.dt_filtered DESC, tweets.id DESC
+				LIMIT ?`
+	rows, err := d.conn.QueryContext(ctx, stmt, limit)
+	if err != nil {
+		return nil, fmt.Errorf("when querying for filtered tweets: %w", err)
+	}
+	defer func() {
+		_ = rows.Close()
+	}()
+
+	out := make([]FilteredTweet, 0)
+	for rows.Next() {
+		ft := FilteredTweet{}
+		dt := int64(0)
+		dtFiltered := int64(0)
+		var offset sql.NullInt64
+		if err := rows.Scan(&ft.Tweet.ID, &ft.Tweet.UserID, &ft.Tweet.Nickname, &ft.Tweet.URL, &dt, &ft.Tweet.Body, &ft.Tweet.Hidden,
+			&ft.Tweet.Hash, &offset, &ft.Rule, &ft.Action, &dtFiltered); err != nil {
+			d.logger.Debugf("when scanning filtered tweet: %s", err)
+			continue
+		}
+		ft.Tweet.setDateTime(dt, offset)
+		ft.Filtered = time.Unix(0, dtFiltered).UTC()
+		out = append(out, ft)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("when reading filtered tweets: %w", err)
+	}
+
+	return out, nil
+}
+
+// ReleaseFilteredTweet marks a tweet caught by the content filter as a false positive, making it visible again.
+// Returns sql.ErrNoRows, wrapped, if the tweet isn't waiting for review.
+func (d *DB) ReleaseFilteredTweet(ctx context.Context, tweetID string) error {
+	tx, err := d.beginWrite(ctx)
+	if err != nil {
+		return fmt.Errorf("when beginning tx to release filtered tweet %s: %w", tweetID, err)
+	}
+	defer func() {
+		_ = tx.Rollback()
+	}()
+
+	res, err := tx.ExecContext(ctx, "UPDATE filtered_tweets SET dt_released = ? WHERE tweet_id = ? AND dt_released = 0", time.Now().UnixNano(), tweetID)
+	if err != nil {
+		return fmt.Errorf("when releasing filtered tweet %s: %w", tweetID, err)
+	}
+	if n, err := res.RowsAffected(); err != nil || n == 0 {
+		return fmt.Errorf("no filtered tweet %s to release: %w", tweetID, sql.ErrNoRows)
+	}
+	if _, err := tx.ExecContext(ctx, "UPDATE tweets SET hidden = ? WHERE id = ?", StatusVisible, tweetID); err != nil {
+		return fmt.Errorf("when unhiding filtered tweet %s: %w", tweetID, err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("when committing release of filtered tweet %s: %w", tweetID, err)
+	}
+	d.invalidate()
+
+	return nil
+}
+
+// GetFilterCounts returns how many tweets each content filter rule has caught, by rule name.
+// Tweets that have since been deleted aren't counted.
+func (d *DB) GetFilterCounts(ctx context.Context) ([]FilterCount, error) {
+	ctx, cancel := d.withQueryTimeout(ctx)
+	defer cancel()
+
+	stmt := `SELECT rule, action, SUM(dt_released = 0), SUM(dt_released != 0)
+				FROM filtered_tweets
+				GROUP BY rule, action
+				ORDER BY rule, action`
+	rows, err := d.conn.QueryContext(ctx, stmt)
+	if err != nil {
+		return nil, fmt.Errorf("when querying for filter counts: %w", err)
+	}
+	defer func() {
+		_ = rows.Close()
+	}()
+
+	out := make([]FilterCount, 0)
+	for rows.Next() {
+		fc := FilterCount{}
+		if err := rows.Scan(&fc.Rule, &fc.Action, &fc.Pending, &fc.Released); err != nil {
+			d.logger.Debugf("when scanning filter count: %s", err)
+			continue
+		}
+		out = append(out, fc)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("when reading filter counts: %w", err)
+	}
+
+	return out, nil
+}
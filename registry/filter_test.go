@@ -0,0 +1,136 @@
+package registry
+
+/*
+Copyright 2021 G. Benjamin Morrison
+
+This file is part of getwtxt-ng.
+
+getwtxt-ng is free software: you can redistribute it and/or modify
+it under the terms of the GNU Affero General Public License as
+published by the Free Software Foundation, either version 3 of the
+License, or (at your option) any later version.
+
+getwtxt-ng is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU Affero General Public License for more details.
+
+You should have received a copy of the GNU Affero General Public License
+along with getwtxt-ng.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestNewFilterRule(t *testing.T) {
+	if _, err := NewFilterRule("empty", "", nil, "", nil); !errors.Is(err, ErrEmptyFilterRule) {
+		t.Errorf("Expected ErrEmptyFilterRule, got: %v", err)
+	}
+	if _, err := NewFilterRule("bad action", "delete", []string{"spam"}, "", nil); !errors.Is(err, ErrInvalidFilterAction) {
+		t.Errorf("Expected ErrInvalidFilterAction, got: %v", err)
+	}
+	if _, err := NewFilterRule("bad pattern", "", nil, "(", nil); err == nil {
+		t.Error("Expected an error for an invalid pattern")
+	}
+
+	rule, err := NewFilterRule("casino", "flag", []string{" CASINO "}, `free\s+money`, []string{"Example.com"})
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	tests := []struct {
+		feedURL string
+		body    string
+		want    bool
+	}{
+		{"https://example.com/twtxt.txt", "Visit our Casino", true},
+		{"https://blog.example.com/twtxt.txt", "free   money here", true},
+		{"https://example.com/twtxt.txt", "just a twt", false},
+		{"https://example.org/twtxt.txt", "Visit our casino", false},
+		{"https://notexample.com/twtxt.txt", "Visit our casino", false},
+	}
+	for _, tt := range tests {
+		if got := rule.Matches(tt.feedURL, tt.body); got != tt.want {
+			t.Errorf("Matching %q from %s: expected %v, got %v", tt.body, tt.feedURL, tt.want, got)
+		}
+	}
+}
+
+func TestDB_ContentFilter(t *testing.T) {
+	ctx := context.Background()
+	memDB := getPopulatedDB(t)
+	hide, err := NewFilterRule("spam", "hide", []string{"buy now"}, "", nil)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	flag, err := NewFilterRule("org", "flag", nil, "", []string{"example.org"})
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	memDB.filter = &ContentFilter{Rules: []FilterRule{hide, flag}}
+
+	hooked := 0
+	memDB.Hooks.TweetsInserted = func(ctx context.Context, tweets []Tweet) {
+		hooked += len(tweets)
+	}
+
+	dt := time.Now().UTC().Truncate(time.Second)
+	res, err := memDB.InsertTweets(ctx, []Tweet{
+		{UserID: "1", DateTime: dt, Body: "Buy now, while stocks last"},
+		{UserID: "1", DateTime: dt.Add(time.Second), Body: "a normal twt"},
+		{UserID: "2", DateTime: dt, Body: "from example.org"},
+	})
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	if res.Inserted != 3 || hooked != 2 {
+		t.Errorf("Expected 3 tweets stored and the 2 visible ones passed to hooks, got %+v and %d", res, hooked)
+	}
+
+	filtered, err := memDB.GetFilteredTweets(ctx, 0)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	if len(filtered) != 2 {
+		t.Fatalf("Expected 2 filtered tweets, got %+v", filtered)
+	}
+	var spam FilteredTweet
+	for _, ft := range filtered {
+		if ft.Rule == "spam" {
+			spam = ft
+		}
+	}
+	if spam.Action != FilterHide || spam.Tweet.Hidden != StatusHidden {
+		t.Errorf("Expected the spam tweet to be hidden, got %+v", spam)
+	}
+
+	if err := memDB.ReleaseFilteredTweet(ctx, spam.Tweet.ID); err != nil {
+		t.Fatal(err.Error())
+	}
+	if err := memDB.ReleaseFilteredTweet(ctx, spam.Tweet.ID); !errors.Is(err, sql.ErrNoRows) {
+		t.Errorf("Expected sql.ErrNoRows releasing twice, got: %v", err)
+	}
+	hidden := StatusVisible
+	if err := memDB.conn.QueryRowContext(ctx, "SELECT hidden FROM tweets WHERE id = ?", spam.Tweet.ID).Scan(&hidden); err != nil {
+		t.Fatal(err.Error())
+	}
+	if hidden != StatusVisible {
+		t.Errorf("Expected released tweet to be visible")
+	}
+
+	counts, err := memDB.GetFilterCounts(ctx)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	want := []FilterCount{
+		{Rule: "org", Action: FilterFlag, Pending: 1},
+		{Rule: "spam", Action: FilterHide, Released: 1},
+	}
+	if len(counts) != len(want) || counts[0] != want[0] || counts[1] != want[1] {
+		t.Errorf("Expected %+v, got %+v", want, counts)
+	}
+}
@@ -0,0 +1,127 @@
+package registry
+
+/*
+Copyright 2021 G. Benjamin Morrison
+
+This file is part of getwtxt-ng.
+
+getwtxt-ng is free software: you can redistribute it and/or modify
+it under the terms of the GNU Affero General Public License as
+published by the Free Software Foundation, either version 3 of the
+License, or (at your option) any later version.
+
+getwtxt-ng is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU Affero General Public License for more details.
+
+You should have received a copy of the GNU Affero General Public License
+along with getwtxt-ng.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"time"
+)
+
+// Follower is a remote ActivityPub actor following a user's feed.
+type Follower struct {
+	UserID string    `json:"user_id"`
+	Actor  string    `json:"actor"`
+	Inbox  string    `json:"inbox"`
+	Added  time.Time `json:"added"`
+}
+
+// GetUserByNick retrieves the active user with the provided nickname. Nicknames aren't unique,
+// so when several users share one, the first to register is returned.
+func (d *DB) GetUserByNick(ctx context.Context, nick string) (*User, error) {
+	ctx, cancel := d.withQueryTimeout(ctx)
+	defer cancel()
+
+	if nick == "" {
+		return nil, ErrIncompleteUserInfo
+	}
+
+	user := User{Status: UserStatusActive}
+	dtAdded := int64(0)
+	lastSync := int64(0)
+	stmt := `SELECT id, url, nick, dt_added, last_sync, meta_nick, avatar, description FROM users
+				WHERE nick = ? AND status = 'active'
+				ORDER BY dt_added ASC, id ASC LIMIT 1`
+	err := d.conn.QueryRowContext(ctx, stmt, nick).Scan(&user.ID, &user.URL, &user.Nick, &dtAdded, &lastSync,
+		&user.DeclaredNick, &user.Avatar, &user.Description)
+	if err != nil {
+		return nil, fmt.Errorf("unable to query for user with nick %s: %w", nick, err)
+	}
+	user.DateTimeAdded = time.Unix(0, dtAdded)
+	user.LastSync = time.Unix(0, lastSync)
+
+	return &user, nil
+}
+
+// AddFollower records that actor follows the user, delivering to inbox. Following again updates the inbox.
+func (d *DB) AddFollower(ctx context.Context, userID, actor, inbox string) error {
+	if userID == "" || actor == "" || inbox == "" {
+		return fmt.Errorf("can't add follower: missing user ID, actor, or inbox")
+	}
+
+	stmt := `INSERT INTO ap_followers (user_id, actor, inbox, dt_added) VALUES (?, ?, ?, ?)
+				ON CONFLICT (user_id, actor) DO UPDATE SET inbox = excluded.inbox`
+	if _, err := d.execWrite(ctx, stmt, userID, actor, inbox, time.Now().UnixNano()); err != nil {
+		return fmt.Errorf("when adding follower %s of user %s: %w", actor, userID, err)
+	}
+
+	return nil
+}
+
+// RemoveFollower records that actor no longer follows the user. Returns sql.ErrNoRows, wrapped,
+// if it wasn't following.
+func (d *DB) RemoveFollower(ctx context.Context, userID, actor string) error {
+	res, err := d.execWrite(ctx, "DELETE FROM ap_followers WHERE user_id = ? AND actor = ?", userID, actor)
+	if err != nil {
+		return fmt.Errorf("when removing follower %s of user %s: %w", actor, userID, err)
+	}
+	removed, err := res.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("when removing follower %s of user %s: %w", actor, userID, err)
+	}
+	if removed == 0 {
+		return fmt.Errorf("%s doesn't follow user %s: %w", actor, userID, sql.ErrNoRows)
+	}
+
+	return nil
+}
+
+// GetFollowers retrieves the actors following the user, oldest first.
+func (d *DB) GetFollowers(ctx context.Context, userID string) ([]Follower, error) {
+	ctx, cancel := d.withQueryTimeout(ctx)
+	defer cancel()
+
+	stmt := "SELECT user_id, actor, inbox, dt_added FROM ap_followers WHERE user_id = ? ORDER BY dt_added ASC, id ASC"
+	rows, err := d.conn.QueryContext(ctx, stmt, userID)
+	if err != nil {
+		return nil, fmt.Errorf("when querying for followers of user %s: %w", userID, err)
+	}
+	defer func() {
+		_ = rows.Close()
+	}()
+
+	followers := make([]Follower, 0)
+	for rows.Next() {
+		f := Follower{}
+		added := int64(0)
+		if err := rows.Scan(&f.UserID, &f.Actor, &f.Inbox, &added); err != nil {
+			d.logger.Debugf("when scanning follower of user %s: %s", userID, err)
+			continue
+		}
+		f.Added = time.Unix(0, added)
+		followers = append(followers, f)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("when reading followers of user %s: %w", userID, err)
+	}
+
+	return followers, nil
+}
@@ -0,0 +1,107 @@
+package registry
+
+/*
+Copyright 2021 G. Benjamin Morrison
+
+This file is part of getwtxt-ng.
+
+getwtxt-ng is free software: you can redistribute it and/or modify
+it under the terms of the GNU Affero General Public License as
+published by the Free Software Foundation, either version 3 of the
+License, or (at your option) any later version.
+
+getwtxt-ng is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU Affero General Public License for more details.
+
+You should have received a copy of the GNU Affero General Public License
+along with getwtxt-ng.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"testing"
+)
+
+func TestDB_GetUserByNick(t *testing.T) {
+	db := getPopulatedDB(t)
+	defer func() {
+		_ = db.Close()
+	}()
+	ctx := context.Background()
+
+	user, err := db.GetUserByNick(ctx, populatedDBUsers[0].Nick)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	if user.ID != populatedDBUsers[0].ID || user.URL != populatedDBUsers[0].URL {
+		t.Errorf("Expected user %s, got: %v", populatedDBUsers[0].ID, user)
+	}
+
+	if _, err := db.conn.Exec("UPDATE users SET status = ? WHERE id = ?", UserStatusSuspended, populatedDBUsers[0].ID); err != nil {
+		t.Fatal(err.Error())
+	}
+	if _, err := db.GetUserByNick(ctx, populatedDBUsers[0].Nick); !errors.Is(err, sql.ErrNoRows) {
+		t.Errorf("Expected sql.ErrNoRows for inactive user, got: %v", err)
+	}
+	if _, err := db.GetUserByNick(ctx, "nobody"); !errors.Is(err, sql.ErrNoRows) {
+		t.Errorf("Expected sql.ErrNoRows for unknown nick, got: %v", err)
+	}
+}
+
+func TestDB_Followers(t *testing.T) {
+	db := getPopulatedDB(t)
+	defer func() {
+		_ = db.Close()
+	}()
+	ctx := context.Background()
+	userID := populatedDBUsers[0].ID
+	actor := "https://social.example/users/alice"
+
+	if err := db.AddFollower(ctx, userID, actor, "https://social.example/users/alice/inbox"); err != nil {
+		t.Fatal(err.Error())
+	}
+	if err := db.AddFollower(ctx, userID, actor, "https://social.example/inbox"); err != nil {
+		t.Fatal(err.Error())
+	}
+	if err := db.AddFollower(ctx, userID, "", "https://social.example/inbox"); err == nil {
+		t.Error("Expected error adding follower without an actor")
+	}
+
+	followers, err := db.GetFollowers(ctx, userID)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	if len(followers) != 1 {
+		t.Fatalf("Expected 1 follower, got %d: %v", len(followers), followers)
+	}
+	if followers[0].Actor != actor || followers[0].Inbox != "https://social.example/inbox" {
+		t.Errorf("Expected follower to have updated inbox, got: %v", followers[0])
+	}
+
+	if err := db.RemoveFollower(ctx, userID, actor); err != nil {
+		t.Error(err.Error())
+	}
+	if err := db.RemoveFollower(ctx, userID, actor); !errors.Is(err, sql.ErrNoRows) {
+		t.Errorf("Expected sql.ErrNoRows removing a missing follower, got: %v", err)
+	}
+
+	t.Run("removed with user", func(t *testing.T) {
+		if err := db.AddFollower(ctx, userID, actor, "https://social.example/inbox"); err != nil {
+			t.Fatal(err.Error())
+		}
+		if _, err := db.conn.Exec("DELETE FROM users WHERE id = ?", userID); err != nil {
+			t.Fatal(err.Error())
+		}
+		followers, err := db.GetFollowers(ctx, userID)
+		if err != nil {
+			t.Fatal(err.Error())
+		}
+		if len(followers) != 0 {
+			t.Errorf("Expected followers to be removed with their user, got: %v", followers)
+		}
+	})
+}
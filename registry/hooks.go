@@ -0,0 +1,93 @@
+package registry
+
+/*
+Copyright 2021 G. Benjamin Morrison
+
+This file is part of getwtxt-ng.
+
+getwtxt-ng is free software: you can redistribute it and/or modify
+it under the terms of the GNU Affero General Public License as
+published by the Free Software Foundation, either version 3 of the
+License, or (at your option) any later version.
+
+getwtxt-ng is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU Affero General Public License for more details.
+
+You should have received a copy of the GNU Affero General Public License
+along with getwtxt-ng.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+import "context"
+
+// Hooks are optional callbacks invoked after the registry's contents change, so metrics,
+// webhooks, and streams can follow along without polling. Any of them may be nil.
+// They're called synchronously once the change has been committed, so they should return quickly.
+type Hooks struct {
+	// TweetsInserted receives the tweets newly stored by InsertTweets, with their IDs set.
+	// Tweets that were already present, or that the content filter hid, aren't included.
+	TweetsInserted func(ctx context.Context, tweets []Tweet)
+
+	// TweetsDeleted receives the number of tweets removed, whether directly or along with their users.
+	TweetsDeleted func(ctx context.Context, count int64)
+
+	// UsersInserted receives the users added by InsertUser or InsertUsers.
+	UsersInserted func(ctx context.Context, users []User)
+
+	// UsersApproved receives the users ApproveUsers made active. Users inserted pending approval are passed to
+	// UsersInserted with that status, and their tweets aren't passed to TweetsInserted until they're approved.
+	UsersApproved func(ctx context.Context, users []User)
+
+	// UsersDeleted receives the URLs of removed users, including those merged into another user.
+	UsersDeleted func(ctx context.Context, urls []string)
+
+	// FeedFetched is called after each attempt to fetch a twtxt file with the number
+	// of tweets received, which is zero when the file hasn't changed or err is non-nil.
+	FeedFetched func(feedURL string, tweets int, err error)
+}
+
+func (h Hooks) tweetsInserted(ctx context.Context, tweets []Tweet) {
+	if h.TweetsInserted == nil {
+		return
+	}
+	visible := make([]Tweet, 0, len(tweets))
+	for _, t := range tweets {
+		if t.Hidden == StatusVisible {
+			visible = append(visible, t)
+		}
+	}
+	if len(visible) > 0 {
+		h.TweetsInserted(ctx, visible)
+	}
+}
+
+func (h Hooks) tweetsDeleted(ctx context.Context, count int64) {
+	if h.TweetsDeleted != nil && count > 0 {
+		h.TweetsDeleted(ctx, count)
+	}
+}
+
+func (h Hooks) usersInserted(ctx context.Context, users []User) {
+	if h.UsersInserted != nil && len(users) > 0 {
+		h.UsersInserted(ctx, users)
+	}
+}
+
+func (h Hooks) usersApproved(ctx context.Context, users []User) {
+	if h.UsersApproved != nil && len(users) > 0 {
+		h.UsersApproved(ctx, users)
+	}
+}
+
+func (h Hooks) usersDeleted(ctx context.Context, urls []string) {
+	if h.UsersDeleted != nil && len(urls) > 0 {
+		h.UsersDeleted(ctx, urls)
+	}
+}
+
+func (h Hooks) feedFetched(feedURL string, tweets int, err error) {
+	if h.FeedFetched != nil {
+		h.FeedFetched(feedURL, tweets, err)
+	}
+}
@@ -0,0 +1,96 @@
+package registry
+
+/*
+Copyright 2021 G. Benjamin Morrison
+
+This file is part of getwtxt-ng.
+
+getwtxt-ng is free software: you can redistribute it and/or modify
+it under the terms of the GNU Affero General Public License as
+published by the Free Software Foundation, either version 3 of the
+License, or (at your option) any later version.
+
+getwtxt-ng is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU Affero General Public License for more details.
+
+You should have received a copy of the GNU Affero General Public License
+along with getwtxt-ng.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestDB_Hooks(t *testing.T) {
+	ctx := context.Background()
+	memDB := getPopulatedDB(t)
+
+	var inserted []Tweet
+	deleted := int64(0)
+	var deletedUsers []string
+	fetched := make(map[string]int)
+	memDB.Hooks = Hooks{
+		TweetsInserted: func(_ context.Context, tweets []Tweet) {
+			inserted = append(inserted, tweets...)
+		},
+		TweetsDeleted: func(_ context.Context, count int64) {
+			deleted += count
+		},
+		UsersDeleted: func(_ context.Context, urls []string) {
+			deletedUsers = append(deletedUsers, urls...)
+		},
+		FeedFetched: func(feedURL string, tweets int, _ error) {
+			fetched[feedURL] = tweets
+		},
+	}
+
+	t.Run("only new tweets are reported", func(t *testing.T) {
+		newTweet := Tweet{
+			UserID:   "1",
+			DateTime: time.Now().UTC(),
+			Body:     "brand new",
+		}
+		existing := populatedDBTweets[0]
+		if _, err := memDB.InsertTweets(ctx, []Tweet{existing, newTweet}); err != nil {
+			t.Fatal(err.Error())
+		}
+		if len(inserted) != 1 || inserted[0].Body != newTweet.Body || inserted[0].ID == "" {
+			t.Errorf("Expected only the new tweet with its ID set, got %+v", inserted)
+		}
+		if len(inserted) == 1 && (inserted[0].URL != populatedDBUsers[0].URL || inserted[0].Nickname != populatedDBUsers[0].Nick) {
+			t.Errorf("Expected the new tweet to carry its author's URL and nickname, got %+v", inserted[0])
+		}
+	})
+
+	t.Run("deleting users reports the ones deleted and their tweets", func(t *testing.T) {
+		if _, _, err := memDB.DeleteUsers(ctx, []string{populatedDBUsers[0].URL, "https://unregistered.example/twtxt.txt"}); err != nil {
+			t.Fatal(err.Error())
+		}
+		if len(deletedUsers) != 1 || deletedUsers[0] != populatedDBUsers[0].URL {
+			t.Errorf("Got unexpected deleted users: %v", deletedUsers)
+		}
+		if deleted != 2 {
+			t.Errorf("Got %d deleted tweets, expected 2", deleted)
+		}
+	})
+
+	t.Run("fetches are reported", func(t *testing.T) {
+		srv := httptest.NewServer(http.HandlerFunc(twtxtTestingHandler))
+		defer srv.Close()
+		memDB.Client = srv.Client()
+		feedURL := fmt.Sprintf("%s/twtxt.txt", srv.URL)
+		if _, err := memDB.FetchTwtxt(feedURL, "2", time.Time{}); err != nil {
+			t.Fatal(err.Error())
+		}
+		if fetched[feedURL] != 2 {
+			t.Errorf("Got %d tweets fetched, expected 2", fetched[feedURL])
+		}
+	})
+}
@@ -0,0 +1,194 @@
+package registry
+
+/*
+Copyright 2021 G. Benjamin Morrison
+
+This file is part of getwtxt-ng.
+
+getwtxt-ng is free software: you can redistribute it and/or modify
+it under the terms of the GNU Affero General Public License as
+published by the Free Software Foundation, either version 3 of the
+License, or (at your option) any later version.
+
+getwtxt-ng is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU Affero General Public License for more details.
+
+You should have received a copy of the GNU Affero General Public License
+along with getwtxt-ng.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// ErrUserNotHosted is returned when posting as a user whose feed isn't hosted by the registry.
+var ErrUserNotHosted = errors.New("user's feed is not hosted here")
+
+// ErrInvalidTwtBody is returned when posting a twt that's empty, too long, or spans multiple lines.
+var ErrInvalidTwtBody = errors.New("invalid twt body")
+
+// HostedTwtMaxLength is the longest twt body, in bytes, that can be posted to a hosted feed.
+const HostedTwtMaxLength = 1024
+
+// InsertHostedUser registers a user whose twtxt file is served by the registry rather than fetched,
+// so their feed starts out empty and is never synced. Tweets are added to it with PostHostedTweet.
+func (d *DB) InsertHostedUser(ctx context.Context, u *User) error {
+	if err := validateNewUser(u); err != nil {
+		return err
+	}
+
+	tx, err := d.beginWrite(ctx)
+	if err != nil {
+		return fmt.Errorf("couldn't begin transaction to insert hosted user: %w", err)
+	}
+	defer func() {
+		_ = tx.Rollback()
+	}()
+
+	if err := insertUserTx(ctx, tx.Tx, u); err != nil {
+		return err
+	}
+	if _, err := tx.ExecContext(ctx, "INSERT INTO hosted_feeds (user_id, dt_added) VALUES (?, ?)", u.ID, u.DateTimeAdded.UnixNano()); err != nil {
+		return fmt.Errorf("when marking feed of %s %s as hosted: %w", u.Nick, u.URL, err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("error committing tx to insert hosted user %s %s: %w", u.Nick, u.URL, err)
+	}
+	d.invalidate()
+
+	d.Hooks.usersInserted(ctx, []User{*u})
+
+	return nil
+}
+
+// IsHostedUser reports whether the feed of the user with the provided ID is hosted by the registry.
+func (d *DB) IsHostedUser(ctx context.Context, userID string) (bool, error) {
+	ctx, cancel := d.withQueryTimeout(ctx)
+	defer cancel()
+
+	hosted := 0
+	err := d.conn.QueryRowContext(ctx, "SELECT COUNT(*) FROM hosted_feeds WHERE user_id = ?", userID).Scan(&hosted)
+	if err != nil {
+		return false, fmt.Errorf("when checking whether feed of user %s is hosted: %w", userID, err)
+	}
+
+	return hosted > 0, nil
+}
+
+// PostHostedTweet adds a tweet to the hosted feed of the provided user, timestamped now or just after their latest.
+// The body must be a single line of at most HostedTwtMaxLength bytes.
+func (d *DB) PostHostedTweet(ctx context.Context, u *User, body string) (Tweet, error) {
+	body = strings.TrimSpace(body)
+	if body == "" || len(body) > HostedTwtMaxLength || strings.ContainsAny(body, "\r\n") {
+		return Tweet{}, ErrInvalidTwtBody
+	}
+
+	hosted, err := d.IsHostedUser(ctx, u.ID)
+	if err != nil {
+		return Tweet{}, err
+	}
+	if !hosted {
+		return Tweet{}, ErrUserNotHosted
+	}
+
+	tx, err := d.beginWrite(ctx)
+	if err != nil {
+		return Tweet{}, fmt.Errorf("when beginning tx to post to hosted feed of %s %s: %w", u.Nick, u.URL, err)
+	}
+	defer func() {
+		_ = tx.Rollback()
+	}()
+
+	// Timestamps only have second precision in the file, and a twt with the same one as another would
+	// be taken for an edit of it, so posts in quick succession are spaced a second apart. The latest is
+	// read within the write, so concurrent posts can't both take the same second.
+	dt := time.Now().UTC().Truncate(time.Second)
+	latest := int64(0)
+	if err := tx.QueryRowContext(ctx, "SELECT COALESCE(MAX(dt), 0) FROM tweets WHERE user_id = ?", u.ID).Scan(&latest); err != nil {
+		return Tweet{}, fmt.Errorf("when querying for latest tweet of %s %s: %w", u.Nick, u.URL, err)
+	}
+	if latestDT := time.Unix(0, latest).UTC(); !dt.After(latestDT) {
+		dt = latestDT.Truncate(time.Second).Add(time.Second)
+	}
+
+	tweet := Tweet{
+		UserID:   u.ID,
+		Nickname: u.Nick,
+		URL:      u.URL,
+		DateTime: dt,
+		Body:     body,
+	}
+	inserted, _, err := d.insertTweetsTx(ctx, tx.Tx, nil, []Tweet{tweet})
+	if err != nil {
+		return Tweet{}, fmt.Errorf("when posting to hosted feed of %s %s: %w", u.Nick, u.URL, err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return Tweet{}, fmt.Errorf("error committing tx to post to hosted feed of %s %s: %w", u.Nick, u.URL, err)
+	}
+	d.invalidate()
+
+	if u.Status != UserStatusPendingApproval {
+		d.Hooks.tweetsInserted(ctx, inserted)
+	}
+	if len(inserted) > 0 {
+		tweet.ID = inserted[0].ID
+	}
+	tweet.Hash = TwtHash(tweet.URL, tweet.DateTime, tweet.Body)
+
+	return tweet, nil
+}
+
+// GetHostedFeed retrieves the active user whose hosted feed is at feedURL, along with all of their
+// visible tweets in ascending order by datetime, as they'd appear in their twtxt file.
+// Returns sql.ErrNoRows when no active user's hosted feed is at that URL.
+func (d *DB) GetHostedFeed(ctx context.Context, feedURL string) (*User, []Tweet, error) {
+	ctx, cancel := d.withQueryTimeout(ctx)
+	defer cancel()
+
+	user := User{}
+	err := d.conn.QueryRowContext(ctx, `SELECT users.id, users.nick, users.url FROM users
+				INNER JOIN hosted_feeds ON hosted_feeds.user_id = users.id
+				WHERE users.url = ? AND users.status = ?`, feedURL, UserStatusActive).Scan(&user.ID, &user.Nick, &user.URL)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, nil, fmt.Errorf("no hosted feed at %s: %w", feedURL, err)
+		}
+		return nil, nil, fmt.Errorf("when querying for hosted feed at %s: %w", feedURL, err)
+	}
+
+	tweets, err := d.GetUserFeed(ctx, user.ID)
+	if err != nil {
+		return nil, nil, fmt.Errorf("when querying for tweets of hosted feed at %s: %w", feedURL, err)
+	}
+
+	return &user, tweets, nil
+}
+
+// FormatTwtxtFile renders a user's tweets as the contents of their twtxt.txt file,
+// headed by comments declaring their nick and URL.
+func FormatTwtxtFile(u *User, tweets []Tweet) string {
+	builder := strings.Builder{}
+	builder.Grow(64 + len(tweets)*128)
+	builder.WriteString("# nick = ")
+	builder.WriteString(u.Nick)
+	builder.WriteString("\n# url = ")
+	builder.WriteString(u.URL)
+	builder.WriteString("\n#\n")
+	for _, tweet := range tweets {
+		builder.WriteString(tweet.DateTime.Format(time.RFC3339))
+		builder.WriteString("\t")
+		builder.WriteString(tweet.Body)
+		builder.WriteString("\n")
+	}
+
+	return builder.String()
+}
@@ -0,0 +1,145 @@
+package registry
+
+/*
+Copyright 2021 G. Benjamin Morrison
+
+This file is part of getwtxt-ng.
+
+getwtxt-ng is free software: you can redistribute it and/or modify
+it under the terms of the GNU Affero General Public License as
+published by the Free Software Foundation, either version 3 of the
+License, or (at your option) any later version.
+
+getwtxt-ng is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU Affero General Public License for more details.
+
+You should have received a copy of the GNU Affero General Public License
+along with getwtxt-ng.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestDB_HostedFeeds(t *testing.T) {
+	db := getPopulatedDB(t)
+	defer func() {
+		_ = db.Close()
+	}()
+	ctx := context.Background()
+
+	user := User{Nick: "hosted", URL: "https://registry.example/u/hosted/twtxt.txt", PasscodeHash: []byte("not a real hash")}
+	if err := db.InsertHostedUser(ctx, &user); err != nil {
+		t.Fatal(err.Error())
+	}
+
+	due, err := db.GetUsersDueForSync(ctx, 0, time.Now())
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	for _, u := range due {
+		if u.ID == user.ID {
+			t.Error("Expected hosted user not to be due for sync")
+		}
+	}
+
+	for _, body := range []string{"", "two\nlines", strings.Repeat("a", HostedTwtMaxLength+1)} {
+		if _, err := db.PostHostedTweet(ctx, &user, body); !errors.Is(err, ErrInvalidTwtBody) {
+			t.Errorf("Expected ErrInvalidTwtBody posting %q, got: %v", body, err)
+		}
+	}
+	notHosted := User{ID: populatedDBUsers[0].ID, Nick: populatedDBUsers[0].Nick, URL: populatedDBUsers[0].URL}
+	if _, err := db.PostHostedTweet(ctx, &notHosted, "hi"); !errors.Is(err, ErrUserNotHosted) {
+		t.Errorf("Expected ErrUserNotHosted, got: %v", err)
+	}
+
+	posted, err := db.PostHostedTweet(ctx, &user, "  hello #world  ")
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	if posted.ID == "" || posted.Body != "hello #world" || posted.Hash == "" {
+		t.Errorf("Unexpected posted tweet: %+v", posted)
+	}
+
+	again, err := db.PostHostedTweet(ctx, &user, "hello again")
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	if !again.DateTime.After(posted.DateTime) {
+		t.Errorf("Expected a later timestamp for a twt posted right after another, got %s and %s", posted.DateTime, again.DateTime)
+	}
+	if _, err := db.DeleteTweets(ctx, []string{again.ID}); err != nil {
+		t.Fatal(err.Error())
+	}
+
+	got, tweets, err := db.GetHostedFeed(ctx, user.URL)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	if got.ID != user.ID || len(tweets) != 1 || tweets[0].Body != "hello #world" {
+		t.Fatalf("Unexpected hosted feed: %+v %+v", got, tweets)
+	}
+	file := FormatTwtxtFile(got, tweets)
+	want := "# nick = hosted\n# url = " + user.URL + "\n#\n" + posted.DateTime.Format(time.RFC3339) + "\thello #world\n"
+	if file != want {
+		t.Errorf("Got %q, expected %q", file, want)
+	}
+
+	if _, _, err := db.GetHostedFeed(ctx, populatedDBUsers[0].URL); !errors.Is(err, sql.ErrNoRows) {
+		t.Errorf("Expected sql.ErrNoRows for a feed that isn't hosted, got: %v", err)
+	}
+
+	t.Run("concurrent posts get their own timestamps", func(t *testing.T) {
+		const posts = 5
+		var wg sync.WaitGroup
+		errs := make(chan error, posts)
+		for i := 0; i < posts; i++ {
+			wg.Add(1)
+			go func(i int) {
+				defer wg.Done()
+				_, err := db.PostHostedTweet(ctx, &user, fmt.Sprintf("concurrent %d", i))
+				errs <- err
+			}(i)
+		}
+		wg.Wait()
+		close(errs)
+		for err := range errs {
+			if err != nil {
+				t.Fatal(err.Error())
+			}
+		}
+
+		_, tweets, err := db.GetHostedFeed(ctx, user.URL)
+		if err != nil {
+			t.Fatal(err.Error())
+		}
+		seen := make(map[time.Time]bool, len(tweets))
+		for _, tw := range tweets {
+			if seen[tw.DateTime] {
+				t.Errorf("Expected every twt to have its own timestamp, %s is shared", tw.DateTime)
+			}
+			seen[tw.DateTime] = true
+		}
+		if len(tweets) != posts+1 {
+			t.Errorf("Expected %d twts in the feed, got %d", posts+1, len(tweets))
+		}
+	})
+
+	t.Run("suspended user's feed isn't served", func(t *testing.T) {
+		if _, err := db.SetUserStatus(ctx, UserStatusSuspended, user.URL); err != nil {
+			t.Fatal(err.Error())
+		}
+		if _, _, err := db.GetHostedFeed(ctx, user.URL); !errors.Is(err, sql.ErrNoRows) {
+			t.Errorf("Expected sql.ErrNoRows for a suspended user's feed, got: %v", err)
+		}
+	})
+}
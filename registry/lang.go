@@ -0,0 +1,226 @@
+package registry
+
+/*
+Copyright 2021 G. Benjamin Morrison
+
+This file is part of getwtxt-ng.
+
+getwtxt-ng is free software: you can redistribute it and/or modify
+it under the terms of the GNU Affero General Public License as
+published by the Free Software Foundation, either version 3 of the
+License, or (at your option) any later version.
+
+getwtxt-ng is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU Affero General Public License for more details.
+
+You should have received a copy of the GNU Affero General Public License
+along with getwtxt-ng.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"strings"
+	"unicode"
+)
+
+// RegexLanguageCode matches the two-letter ISO 639-1 codes DetectLanguage returns.
+var RegexLanguageCode = regexp.MustCompile(`^[a-z]{2}$`)
+
+// regexLangNoise matches the parts of a body that say nothing about its language: mentions, subjects, links, and tags.
+var regexLangNoise = regexp.MustCompile(`@<[^>]*>|\(#[^)]*\)|\S+://\S+|#\w+`)
+
+// stopwords are short, frequent words that give away the language of text written in the Latin alphabet.
+var stopwords = map[string][]string{
+	"en": {"the", "and", "is", "are", "was", "of", "to", "it", "that", "this", "with", "for", "you", "have", "not", "on", "be", "my", "but", "just", "what", "at", "i"},
+	"de": {"der", "die", "das", "und", "ist", "nicht", "ich", "ein", "eine", "mit", "auf", "zu", "den", "sich", "auch", "wie", "für", "von", "dem", "im", "sind", "wir", "aber", "noch"},
+	"fr": {"le", "la", "les", "et", "est", "un", "une", "des", "du", "que", "qui", "pas", "pour", "dans", "ce", "je", "il", "sur", "avec", "au", "mais", "ne", "sont", "vous"},
+	"es": {"el", "la", "los", "las", "y", "es", "que", "de", "en", "un", "una", "por", "con", "para", "no", "se", "lo", "del", "pero", "como", "más", "esta", "está", "muy", "yo"},
+	"it": {"il", "lo", "la", "gli", "le", "e", "è", "che", "di", "un", "una", "per", "con", "non", "sono", "del", "della", "ma", "anche", "come", "questo", "ho", "mi", "nel"},
+	"pt": {"o", "a", "os", "as", "e", "é", "que", "de", "um", "uma", "para", "com", "não", "do", "da", "em", "no", "na", "mas", "se", "por", "mais", "eu", "isso", "está"},
+	"nl": {"de", "het", "een", "en", "is", "van", "dat", "niet", "ik", "je", "op", "te", "met", "voor", "zijn", "maar", "ook", "er", "wat", "dit", "aan", "nog", "wel"},
+	"sv": {"och", "att", "det", "är", "en", "ett", "som", "på", "jag", "inte", "med", "för", "har", "av", "till", "den", "om", "men", "var", "så", "kan"},
+	"pl": {"i", "w", "na", "nie", "się", "jest", "to", "że", "z", "do", "co", "jak", "ale", "o", "po", "tak", "mnie", "już", "czy", "są"},
+}
+
+// stopwordLangs maps each stopword to the languages it's common in.
+var stopwordLangs = func() map[string][]string {
+	out := make(map[string][]string)
+	for lang, words := range stopwords {
+		for _, w := range words {
+			out[w] = append(out[w], lang)
+		}
+	}
+	return out
+}()
+
+// letterLangs are letters used by only one of the languages with stopwords.
+var letterLangs = map[rune]string{
+	'ß': "de", 'ñ': "es", 'ã': "pt", 'õ': "pt", 'œ': "fr", 'å': "sv",
+	'ł': "pl", 'ą': "pl", 'ę': "pl", 'ś': "pl", 'ż': "pl", 'ź': "pl", 'ć': "pl", 'ń': "pl",
+}
+
+// DetectLanguage guesses the ISO 639-1 code of the language the body is written in. It knows
+// languages by their script, and tells apart a handful of languages written in the Latin alphabet
+// by their most common words. Returns an empty string when the body is too short or too mixed to tell.
+func DetectLanguage(body string) string {
+	text := regexLangNoise.ReplaceAllString(body, " ")
+
+	scripts := make(map[string]int)
+	letters := 0
+	for _, r := range text {
+		if !unicode.IsLetter(r) {
+			continue
+		}
+		letters++
+		switch {
+		case unicode.Is(unicode.Hiragana, r), unicode.Is(unicode.Katakana, r):
+			scripts["ja"]++
+		case unicode.Is(unicode.Han, r):
+			scripts["zh"]++
+		case unicode.Is(unicode.Hangul, r):
+			scripts["ko"]++
+		case unicode.Is(unicode.Cyrillic, r):
+			scripts["ru"]++
+			if strings.ContainsRune("іїєґІЇЄҐ", r) {
+				scripts["uk"]++
+			}
+		case unicode.Is(unicode.Arabic, r):
+			scripts["ar"]++
+			if strings.ContainsRune("پچژگ", r) {
+				scripts["fa"]++
+			}
+		case unicode.Is(unicode.Hebrew, r):
+			scripts["he"]++
+		case unicode.Is(unicode.Greek, r):
+			scripts["el"]++
+		case unicode.Is(unicode.Thai, r):
+			scripts["th"]++
+		case unicode.Is(unicode.Devanagari, r):
+			scripts["hi"]++
+		case unicode.Is(unicode.Latin, r):
+			scripts["latin"]++
+		}
+	}
+	if letters < 8 {
+		return ""
+	}
+
+	// Japanese mixes kana with kanji, so any kana at all is enough.
+	switch {
+	case scripts["ja"] > 0 && scripts["ja"]+scripts["zh"] > letters/2:
+		return "ja"
+	case scripts["uk"] > 0 && scripts["ru"] > letters/2:
+		return "uk"
+	case scripts["fa"] > 0 && scripts["ar"] > letters/2:
+		return "fa"
+	}
+	for _, lang := range []string{"zh", "ko", "ru", "ar", "he", "el", "th", "hi"} {
+		if scripts[lang] > letters/2 {
+			return lang
+		}
+	}
+	if scripts["latin"] <= letters/2 {
+		return ""
+	}
+
+	return detectLatinLanguage(text)
+}
+
+// detectLatinLanguage scores text written in the Latin alphabet by the stopwords and distinctive letters
+// of each language, returning the best if it's clearly ahead.
+func detectLatinLanguage(text string) string {
+	scores := make(map[string]int)
+	for _, word := range strings.FieldsFunc(strings.ToLower(text), func(r rune) bool {
+		return !unicode.IsLetter(r) && r != '\''
+	}) {
+		for _, lang := range stopwordLangs[word] {
+			scores[lang] += 2
+		}
+		for _, r := range word {
+			if lang, ok := letterLangs[r]; ok {
+				scores[lang]++
+			}
+		}
+	}
+
+	best, bestScore, second := "", 0, 0
+	for lang, score := range scores {
+		if score > bestScore {
+			best, bestScore, second = lang, score, bestScore
+		} else if score > second {
+			second = score
+		}
+	}
+	if bestScore < 4 || bestScore == second {
+		return ""
+	}
+
+	return best
+}
+
+// BackfillTweetLanguages detects and stores the language of tweets inserted before it was stored.
+// Returns the number of tweets updated.
+func (d *DB) BackfillTweetLanguages(ctx context.Context) (int64, error) {
+	detected := int64(0)
+	for {
+		n, err := d.backfillTweetLanguagesBatch(ctx)
+		if err != nil {
+			return detected, err
+		}
+		detected += n
+		if n < backfillTweetsBatchSize {
+			break
+		}
+	}
+	if detected > 0 {
+		d.invalidate()
+	}
+
+	return detected, nil
+}
+
+func (d *DB) backfillTweetLanguagesBatch(ctx context.Context) (int64, error) {
+	tx, err := d.beginWrite(ctx)
+	if err != nil {
+		return 0, fmt.Errorf("when beginning tx to backfill tweet languages: %w", err)
+	}
+	defer func() {
+		_ = tx.Rollback()
+	}()
+
+	rows, err := tx.QueryContext(ctx, "SELECT id, body FROM tweets WHERE lang IS NULL LIMIT ?", backfillTweetsBatchSize)
+	if err != nil {
+		return 0, fmt.Errorf("when querying for tweets without a language: %w", err)
+	}
+	bodies := make(map[string]string, backfillTweetsBatchSize)
+	for rows.Next() {
+		id := ""
+		body := ""
+		if err := rows.Scan(&id, &body); err != nil {
+			d.logger.Debugf("when scanning tweet to detect its language: %s", err)
+			continue
+		}
+		bodies[id] = body
+	}
+	err = rows.Err()
+	_ = rows.Close()
+	if err != nil {
+		return 0, fmt.Errorf("when reading tweets without a language: %w", err)
+	}
+
+	for id, body := range bodies {
+		if _, err := tx.ExecContext(ctx, "UPDATE tweets SET lang = ? WHERE id = ?", DetectLanguage(body), id); err != nil {
+			return 0, fmt.Errorf("when storing language of tweet %s: %w", id, err)
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return 0, fmt.Errorf("when committing tx to backfill tweet languages: %w", err)
+	}
+
+	return int64(len(bodies)), nil
+}
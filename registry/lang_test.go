@@ -0,0 +1,121 @@
+package registry
+
+/*
+Copyright 2021 G. Benjamin Morrison
+
+This file is part of getwtxt-ng.
+
+getwtxt-ng is free software: you can redistribute it and/or modify
+it under the terms of the GNU Affero General Public License as
+published by the Free Software Foundation, either version 3 of the
+License, or (at your option) any later version.
+
+getwtxt-ng is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU Affero General Public License for more details.
+
+You should have received a copy of the GNU Affero General Public License
+along with getwtxt-ng.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestDetectLanguage(t *testing.T) {
+	tests := []struct {
+		body string
+		want string
+	}{
+		{"I just installed getwtxt and it is working for me", "en"},
+		{"Ich habe das nicht gewusst, aber es ist auch gut so", "de"},
+		{"Je ne sais pas ce que vous avez dans la tête", "fr"},
+		{"No sé por qué la gente está tan contenta con esto", "es"},
+		{"Dat is niet wat ik bedoelde, maar het is ook goed", "nl"},
+		{"Jag vet inte vad det är som händer med min dator", "sv"},
+		{"Nie wiem, czy to jest już gotowe, ale się staram", "pl"},
+		{"今日はとても良い天気ですね", "ja"},
+		{"Сегодня очень хорошая погода на улице", "ru"},
+		{"오늘은 날씨가 정말 좋네요 산책하러 가요", "ko"},
+		{"Σήμερα ο καιρός είναι πολύ ωραίος", "el"},
+		{"@<foo https://example.com/twtxt.txt> #getwtxt https://example.com", ""},
+		{"ok", ""},
+		{"getwtxt twtxt registry", ""},
+	}
+	for _, tt := range tests {
+		if got := DetectLanguage(tt.body); got != tt.want {
+			t.Errorf("Detecting the language of %q: expected %q, got %q", tt.body, tt.want, got)
+		}
+	}
+}
+
+func TestDB_TweetsInLanguage(t *testing.T) {
+	ctx := context.Background()
+	memDB := getPopulatedDB(t)
+
+	dt := time.Now().UTC().Truncate(time.Second)
+	_, err := memDB.InsertTweets(ctx, []Tweet{
+		{UserID: "1", DateTime: dt, Body: "Ich habe getwtxt nicht installiert, aber es ist auch gut"},
+		{UserID: "1", DateTime: dt.Add(time.Second), Body: "I have not installed getwtxt but it is good"},
+		{UserID: "2", DateTime: dt, Body: "Je ne sais pas ce que vous avez avec getwtxt"},
+	})
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+
+	tweets, err := memDB.GetTweetsInLanguage(ctx, 1, 20, "de", StatusVisible)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	if len(tweets) != 1 || tweets[0].Lang != "de" {
+		t.Errorf("Expected the German tweet, got %+v", tweets)
+	}
+
+	tweets, err = memDB.SearchTweetsInLanguage(ctx, 1, 20, "getwtxt", "fr", StatusVisible)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	if len(tweets) != 1 || tweets[0].UserID != "2" || tweets[0].Lang != "fr" {
+		t.Errorf("Expected the French tweet, got %+v", tweets)
+	}
+
+	t.Run("edit", func(t *testing.T) {
+		res, err := memDB.InsertTweets(ctx, []Tweet{{UserID: "1", DateTime: dt.Add(time.Second), Body: "Ich habe getwtxt nicht installiert, aber es ist auch gut so"}})
+		if err != nil {
+			t.Fatal(err.Error())
+		}
+		if res.Edited != 1 {
+			t.Fatalf("Expected an edit, got %+v", res)
+		}
+		tweets, err := memDB.GetTweetsInLanguage(ctx, 1, 20, "de", StatusVisible)
+		if err != nil {
+			t.Fatal(err.Error())
+		}
+		if len(tweets) != 2 {
+			t.Errorf("Expected the edited tweet to be detected as German, got %+v", tweets)
+		}
+	})
+
+	t.Run("backfill", func(t *testing.T) {
+		if _, err := memDB.conn.ExecContext(ctx, "UPDATE tweets SET lang = NULL"); err != nil {
+			t.Fatal(err.Error())
+		}
+		detected, err := memDB.BackfillTweetLanguages(ctx)
+		if err != nil {
+			t.Fatal(err.Error())
+		}
+		if detected < 3 {
+			t.Errorf("Expected at least 3 tweets backfilled, got %d", detected)
+		}
+		tweets, err := memDB.GetTweetsInLanguage(ctx, 1, 20, "en", StatusVisible)
+		if err != nil {
+			t.Fatal(err.Error())
+		}
+		if len(tweets) == 0 {
+			t.Error("Expected the English tweet after backfilling, got none")
+		}
+	})
+}
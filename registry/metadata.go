@@ -0,0 +1,152 @@
+package registry
+
+/*
+Copyright 2021 G. Benjamin Morrison
+
+This file is part of getwtxt-ng.
+
+getwtxt-ng is free software: you can redistribute it and/or modify
+it under the terms of the GNU Affero General Public License as
+published by the Free Software Foundation, either version 3 of the
+License, or (at your option) any later version.
+
+getwtxt-ng is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU Affero General Public License for more details.
+
+You should have received a copy of the GNU Affero General Public License
+along with getwtxt-ng.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// Limits on what's kept of a feed's metadata, so a feed can't fill the database with it.
+const (
+	metadataMaxNick        = 64
+	metadataMaxURL         = 2048
+	metadataMaxDescription = 1024
+	metadataMaxRegistries  = 16
+	metadataMaxURLs        = 16
+)
+
+// FeedMetadata is what a feed says about itself in comments such as "# nick = foo".
+// Fields the feed doesn't declare are empty.
+type FeedMetadata struct {
+	Nick        string
+	Avatar      string
+	Description string
+
+	// Prev is where the feed's most recent archived file is, from "# prev = hash url". It may be relative to the feed.
+	// It isn't stored.
+	Prev string
+
+	// Registries are the twtxt registries the feed says it's listed with, from "# registries = url ...".
+	Registries []string
+
+	// URLs are where the feed says it's published, from "# url = url". A feed mirrored elsewhere lists each of them.
+	URLs []string
+}
+
+// parseComment reads a "# key = value" comment line into the metadata. The first value given for each key is kept,
+// as is the convention for fields that can only have one value, except for registries and URLs, which may be given
+// several times. Other comments are ignored.
+func (m *FeedMetadata) parseComment(line string) {
+	line = strings.TrimSpace(strings.TrimPrefix(line, "#"))
+	key, value, ok := strings.Cut(line, "=")
+	if !ok {
+		return
+	}
+	value = strings.TrimSpace(value)
+	if value == "" {
+		return
+	}
+
+	switch strings.ToLower(strings.TrimSpace(key)) {
+	case "nick":
+		if m.Nick == "" && len(value) <= metadataMaxNick {
+			m.Nick = value
+		}
+	case "avatar":
+		if m.Avatar == "" && len(value) <= metadataMaxURL && (strings.HasPrefix(value, "https://") || strings.HasPrefix(value, "http://")) {
+			m.Avatar = value
+		}
+	case "prev":
+		// The hash of the archived file comes first, and is optional in practice.
+		fields := strings.Fields(value)
+		if m.Prev == "" && len(fields[len(fields)-1]) <= metadataMaxURL {
+			m.Prev = fields[len(fields)-1]
+		}
+	case "registries", "registry":
+		for _, reg := range strings.Fields(value) {
+			if len(m.Registries) >= metadataMaxRegistries {
+				break
+			}
+			if len(reg) <= metadataMaxURL && (strings.HasPrefix(reg, "https://") || strings.HasPrefix(reg, "http://")) {
+				m.Registries = append(m.Registries, strings.TrimSuffix(reg, "/"))
+			}
+		}
+	case "url":
+		if len(m.URLs) < metadataMaxURLs && len(value) <= metadataMaxURL && (strings.HasPrefix(value, "https://") || strings.HasPrefix(value, "http://")) {
+			m.URLs = append(m.URLs, value)
+		}
+	case "description":
+		if m.Description == "" {
+			if len(value) > metadataMaxDescription {
+				value = value[:metadataMaxDescription]
+			}
+			m.Description = strings.ToValidUTF8(value, "")
+		}
+	}
+}
+
+// SetFeedMetadata stores the metadata the user's feed declares about itself, replacing what was stored before.
+func (d *DB) SetFeedMetadata(ctx context.Context, userID string, meta FeedMetadata) error {
+	return d.recordFeedMetadata(ctx, map[string]FeedMetadata{userID: meta})
+}
+
+// recordFeedMetadata stores the metadata each feed declared, keyed by user ID, replacing what was stored before.
+func (d *DB) recordFeedMetadata(ctx context.Context, metadata map[string]FeedMetadata) error {
+	if len(metadata) == 0 {
+		return nil
+	}
+
+	tx, err := d.beginWrite(ctx)
+	if err != nil {
+		return fmt.Errorf("when beginning tx to record metadata of %d feeds: %w", len(metadata), err)
+	}
+	defer func() {
+		_ = tx.Rollback()
+	}()
+
+	stmt := "UPDATE users SET meta_nick = ?, avatar = ?, description = ? WHERE id = ?"
+	registryStmt := `INSERT INTO registries (url, discovered_via, dt_discovered, dt_last_seen)
+						SELECT ?, url, ?, ? FROM users WHERE id = ?
+						ON CONFLICT (url) DO UPDATE SET dt_last_seen = excluded.dt_last_seen`
+	now := time.Now().UnixNano()
+	for id, meta := range metadata {
+		if _, err := tx.ExecContext(ctx, stmt, meta.Nick, meta.Avatar, meta.Description, id); err != nil {
+			return fmt.Errorf("when recording metadata of user %s: %w", id, err)
+		}
+		for _, reg := range meta.Registries {
+			if _, err := tx.ExecContext(ctx, registryStmt, reg, now, now, id); err != nil {
+				return fmt.Errorf("when recording registry %s declared by user %s: %w", reg, id, err)
+			}
+		}
+		if err := recordFeedURLsTx(ctx, tx.Tx, id, meta.URLs); err != nil {
+			return err
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("when committing tx to record metadata of %d feeds: %w", len(metadata), err)
+	}
+	d.invalidate()
+
+	return nil
+}
@@ -0,0 +1,112 @@
+package registry
+
+/*
+Copyright 2021 G. Benjamin Morrison
+
+This file is part of getwtxt-ng.
+
+getwtxt-ng is free software: you can redistribute it and/or modify
+it under the terms of the GNU Affero General Public License as
+published by the Free Software Foundation, either version 3 of the
+License, or (at your option) any later version.
+
+getwtxt-ng is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU Affero General Public License for more details.
+
+You should have received a copy of the GNU Affero General Public License
+along with getwtxt-ng.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestFeedMetadata_parseComment(t *testing.T) {
+	meta := FeedMetadata{}
+	for _, line := range []string{
+		"# this is a comment",
+		"# nick = foo",
+		"# nick = bar",
+		"#avatar=https://foo.example/avatar.png",
+		"# description = I post about = signs",
+		"# url = https://foo.example/twtxt.txt",
+		"# url = https://mirror.example/foo.txt",
+		"# url = gopher://foo.example/twtxt.txt",
+		"# prev = abcdefg twtxt-2021.txt",
+		"# registries = https://registry.example/ gopher://registry.example",
+		"# registries = https://registry.example.org",
+	} {
+		meta.parseComment(line)
+	}
+	want := FeedMetadata{
+		Nick:        "foo",
+		Avatar:      "https://foo.example/avatar.png",
+		Description: "I post about = signs",
+		Prev:        "twtxt-2021.txt",
+		Registries:  []string{"https://registry.example", "https://registry.example.org"},
+		URLs:        []string{"https://foo.example/twtxt.txt", "https://mirror.example/foo.txt"},
+	}
+	if !reflect.DeepEqual(meta, want) {
+		t.Errorf("Got %+v, expected %+v", meta, want)
+	}
+
+	meta = FeedMetadata{}
+	meta.parseComment("# avatar = javascript:alert(1)")
+	meta.parseComment("# description = " + strings.Repeat("a", metadataMaxDescription+10))
+	if meta.Avatar != "" {
+		t.Errorf("Expected non-http avatar to be ignored, got %s", meta.Avatar)
+	}
+	if len(meta.Description) != metadataMaxDescription {
+		t.Errorf("Expected description to be truncated to %d, got %d", metadataMaxDescription, len(meta.Description))
+	}
+}
+
+func TestDB_SyncUsers_metadata(t *testing.T) {
+	description := "the first"
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain")
+		_, _ = fmt.Fprintf(w, "# nick = dog\n# avatar = https://example.com/dog.png\n# description = %s\n%s\thallo\n",
+			description, time.Now().UTC().Format(time.RFC3339))
+	}))
+	defer srv.Close()
+	db := getPopulatedDB(t)
+	defer func() {
+		_ = db.Close()
+	}()
+	db.Client = srv.Client()
+	ctx := context.Background()
+
+	users := []User{{ID: populatedDBUsers[0].ID, URL: srv.URL + "/twtxt.txt"}}
+	for _, want := range []string{"the first", "the second"} {
+		description = want
+		if _, err := db.SyncUsers(ctx, users); err != nil {
+			t.Fatal(err.Error())
+		}
+		got, err := db.GetUserByNick(ctx, populatedDBUsers[0].Nick)
+		if err != nil {
+			t.Fatal(err.Error())
+		}
+		if got.DeclaredNick != "dog" || got.Avatar != "https://example.com/dog.png" || got.Description != want {
+			t.Errorf("Unexpected metadata after sync: %+v", got)
+		}
+	}
+
+	listed, err := db.GetUsers(ctx, 1, 20)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	for _, u := range listed {
+		if u.ID == populatedDBUsers[0].ID && u.Avatar == "" {
+			t.Errorf("Expected metadata in user listing, got: %+v", u)
+		}
+	}
+}
@@ -0,0 +1,822 @@
+package registry
+
+/*
+Copyright 2021 G. Benjamin Morrison
+
+This file is part of getwtxt-ng.
+
+getwtxt-ng is free software: you can redistribute it and/or modify
+it under the terms of the GNU Affero General Public License as
+published by the Free Software Foundation, either version 3 of the
+License, or (at your option) any later version.
+
+getwtxt-ng is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU Affero General Public License for more details.
+
+You should have received a copy of the GNU Affero General Public License
+along with getwtxt-ng.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+import (
+	"context"
+	"errors"
+	"fmt"
+)
+
+// ErrNoMigrationsApplied is returned when attempting to roll back a database with no applied migrations.
+var ErrNoMigrationsApplied = errors.New("no migrations have been applied")
+
+// migration is a single, versioned change to the schema.
+// The schema version is tracked with SQLite's user_version pragma.
+type migration struct {
+	version     int
+	description string
+	up          []string
+	down        []string
+}
+
+// MigrationStatus describes a known migration and whether it has been applied to the database.
+type MigrationStatus struct {
+	Version     int    `json:"version"`
+	Description string `json:"description"`
+	Applied     bool   `json:"applied"`
+}
+
+// migrations must remain in ascending order by version. Never edit one that has been released: add a new one instead.
+var migrations = []migration{
+	{
+		version:     1,
+		description: "initial schema",
+		up: []string{
+			`CREATE TABLE IF NOT EXISTS users (
+    			id INTEGER PRIMARY KEY AUTOINCREMENT,
+    			url TEXT NOT NULL UNIQUE,
+    			nick TEXT NOT NULL,
+    			passcode_hash BLOB NOT NULL,
+    			dt_added INTEGER NOT NULL,
+    			last_sync INTEGER NOT NULL
+			)`,
+			`CREATE TABLE IF NOT EXISTS tweets (
+    			id INTEGER PRIMARY KEY AUTOINCREMENT,
+    			user_id INTEGER NOT NULL,
+    			dt INTEGER NOT NULL,
+    			body TEXT NOT NULL,
+    			contains_mentions INTEGER NOT NULL DEFAULT 0,
+    			contains_tags INTEGER NOT NULL DEFAULT 0,
+    			hidden INTEGER NOT NULL DEFAULT 0,
+    			UNIQUE (user_id, dt, body) ON CONFLICT IGNORE,
+    			FOREIGN KEY(user_id) REFERENCES users(id)
+			)`,
+			`CREATE VIEW IF NOT EXISTS tweets_users (
+    			id, user_id, nick, url, dt, body, contains_mentions, contains_tags, hidden
+			) AS
+    			SELECT
+    			    tweets.id, tweets.user_id, users.nick, users.url, tweets.dt, tweets.body,
+    			    tweets.contains_mentions, tweets.contains_tags, tweets.hidden
+    			FROM tweets
+    			JOIN users ON users.id = tweets.user_id`,
+			`CREATE VIRTUAL TABLE IF NOT EXISTS tweets_search USING fts5 (
+    			id, user_id, nick, url, dt, body, contains_mentions, contains_tags, hidden,
+    			content = tweets_users,
+    			content_rowid = id,
+    			columnsize = 0,
+			)`,
+			`CREATE TRIGGER IF NOT EXISTS tweetsInsert AFTER INSERT ON tweets
+    			BEGIN
+					INSERT INTO tweets_search (
+						ROWID, user_id, dt, body, contains_mentions, contains_tags, hidden
+					) SELECT ROWID, user_id, dt, body, contains_mentions, contains_tags, hidden FROM tweets WHERE ROWID = NEW.ROWID;
+				END`,
+			`CREATE TRIGGER IF NOT EXISTS tweetsDelete AFTER DELETE ON tweets
+    			BEGIN
+    			    DELETE FROM tweets_search WHERE ROWID = OLD.ROWID;
+				END`,
+		},
+		down: []string{
+			`DROP TRIGGER IF EXISTS tweetsDelete`,
+			`DROP TRIGGER IF EXISTS tweetsInsert`,
+			`DROP TABLE IF EXISTS tweets_search`,
+			`DROP VIEW IF EXISTS tweets_users`,
+			`DROP TABLE IF EXISTS tweets`,
+			`DROP TABLE IF EXISTS users`,
+		},
+	},
+	{
+		version:     2,
+		description: "Index every column of tweets_users and keep the search index in step with updates and deletes",
+		up: []string{
+			`DROP TRIGGER IF EXISTS tweetsInsert`,
+			`DROP TRIGGER IF EXISTS tweetsDelete`,
+			`CREATE TRIGGER tweetsInsert AFTER INSERT ON tweets
+				BEGIN
+					INSERT INTO tweets_search (
+						ROWID, id, user_id, nick, url, dt, body, contains_mentions, contains_tags, hidden
+					) SELECT id, id, user_id, nick, url, dt, body, contains_mentions, contains_tags, hidden
+					FROM tweets_users WHERE id = NEW.id;
+				END`,
+			// tweets_search is an external content table, so the values being removed have to be supplied.
+			// Tweets must be deleted before their user for this to find the nick and URL.
+			`CREATE TRIGGER tweetsDelete AFTER DELETE ON tweets
+				BEGIN
+					INSERT INTO tweets_search (
+						tweets_search, ROWID, id, user_id, nick, url, dt, body, contains_mentions, contains_tags, hidden
+					) SELECT 'delete', OLD.id, OLD.id, OLD.user_id, users.nick, users.url, OLD.dt, OLD.body,
+						OLD.contains_mentions, OLD.contains_tags, OLD.hidden
+					FROM users WHERE users.id = OLD.user_id;
+				END`,
+			`CREATE TRIGGER tweetsUpdate AFTER UPDATE ON tweets
+				BEGIN
+					INSERT INTO tweets_search (
+						tweets_search, ROWID, id, user_id, nick, url, dt, body, contains_mentions, contains_tags, hidden
+					) SELECT 'delete', OLD.id, OLD.id, OLD.user_id, users.nick, users.url, OLD.dt, OLD.body,
+						OLD.contains_mentions, OLD.contains_tags, OLD.hidden
+					FROM users WHERE users.id = OLD.user_id;
+					INSERT INTO tweets_search (
+						ROWID, id, user_id, nick, url, dt, body, contains_mentions, contains_tags, hidden
+					) SELECT id, id, user_id, nick, url, dt, body, contains_mentions, contains_tags, hidden
+					FROM tweets_users WHERE id = NEW.id;
+				END`,
+			`INSERT INTO tweets_search (tweets_search) VALUES ('rebuild')`,
+		},
+		down: []string{
+			`DROP TRIGGER IF EXISTS tweetsUpdate`,
+			`DROP TRIGGER IF EXISTS tweetsInsert`,
+			`DROP TRIGGER IF EXISTS tweetsDelete`,
+			`CREATE TRIGGER tweetsInsert AFTER INSERT ON tweets
+    			BEGIN
+					INSERT INTO tweets_search (
+						ROWID, user_id, dt, body, contains_mentions, contains_tags, hidden
+					) SELECT ROWID, user_id, dt, body, contains_mentions, contains_tags, hidden FROM tweets WHERE ROWID = NEW.ROWID;
+				END`,
+			`CREATE TRIGGER tweetsDelete AFTER DELETE ON tweets
+    			BEGIN
+    			    DELETE FROM tweets_search WHERE ROWID = OLD.ROWID;
+				END`,
+			`INSERT INTO tweets_search (tweets_search) VALUES ('rebuild')`,
+		},
+	},
+	{
+		version:     3,
+		description: "Record when each tweet was ingested",
+		up: []string{
+			`ALTER TABLE tweets ADD COLUMN dt_ingested INTEGER NOT NULL DEFAULT 0`,
+			// The best available guess for existing tweets.
+			`UPDATE tweets SET dt_ingested = dt`,
+			`CREATE INDEX IF NOT EXISTS tweets_dt_ingested ON tweets (dt_ingested)`,
+		},
+		down: []string{
+			`DROP INDEX IF EXISTS tweets_dt_ingested`,
+			`ALTER TABLE tweets DROP COLUMN dt_ingested`,
+		},
+	},
+	{
+		version:     4,
+		description: "Fold case and strip diacritics in the search index",
+		up: []string{
+			// The triggers refer to tweets_search by name, so they survive it being recreated.
+			`DROP TABLE IF EXISTS tweets_search`,
+			`CREATE VIRTUAL TABLE tweets_search USING fts5 (
+    			id, user_id, nick, url, dt, body, contains_mentions, contains_tags, hidden,
+    			content = tweets_users,
+    			content_rowid = id,
+    			columnsize = 0,
+    			tokenize = 'unicode61 remove_diacritics 2'
+			)`,
+			`INSERT INTO tweets_search (tweets_search) VALUES ('rebuild')`,
+		},
+		down: []string{
+			`DROP TABLE IF EXISTS tweets_search`,
+			`CREATE VIRTUAL TABLE tweets_search USING fts5 (
+    			id, user_id, nick, url, dt, body, contains_mentions, contains_tags, hidden,
+    			content = tweets_users,
+    			content_rowid = id,
+    			columnsize = 0,
+			)`,
+			`INSERT INTO tweets_search (tweets_search) VALUES ('rebuild')`,
+		},
+	},
+	{
+		version:     5,
+		description: "Back off from feeds that fail to sync",
+		up: []string{
+			`ALTER TABLE users ADD COLUMN sync_failures INTEGER NOT NULL DEFAULT 0`,
+			`ALTER TABLE users ADD COLUMN next_sync INTEGER NOT NULL DEFAULT 0`,
+			`CREATE INDEX IF NOT EXISTS users_last_sync ON users (last_sync)`,
+		},
+		down: []string{
+			`DROP INDEX IF EXISTS users_last_sync`,
+			`ALTER TABLE users DROP COLUMN next_sync`,
+			`ALTER TABLE users DROP COLUMN sync_failures`,
+		},
+	},
+	{
+		version:     6,
+		description: "Record the outcome of each user's last fetch",
+		up: []string{
+			`ALTER TABLE users ADD COLUMN last_fetch INTEGER NOT NULL DEFAULT 0`,
+			`ALTER TABLE users ADD COLUMN last_fetch_status INTEGER NOT NULL DEFAULT 0`,
+			`ALTER TABLE users ADD COLUMN last_fetch_error TEXT NOT NULL DEFAULT ''`,
+			`ALTER TABLE users ADD COLUMN last_fetch_success INTEGER NOT NULL DEFAULT 0`,
+		},
+		down: []string{
+			`ALTER TABLE users DROP COLUMN last_fetch_success`,
+			`ALTER TABLE users DROP COLUMN last_fetch_error`,
+			`ALTER TABLE users DROP COLUMN last_fetch_status`,
+			`ALTER TABLE users DROP COLUMN last_fetch`,
+		},
+	},
+	{
+		version:     7,
+		description: "Track where each user is in their lifecycle",
+		up: []string{
+			`ALTER TABLE users ADD COLUMN status TEXT NOT NULL DEFAULT 'active'`,
+			`CREATE INDEX IF NOT EXISTS users_status ON users (status)`,
+		},
+		down: []string{
+			`DROP INDEX IF EXISTS users_status`,
+			`ALTER TABLE users DROP COLUMN status`,
+		},
+	},
+	{
+		version:     8,
+		description: "Keep the earlier versions of edited tweets",
+		up: []string{
+			`CREATE TABLE IF NOT EXISTS tweet_revisions (
+    			id INTEGER PRIMARY KEY AUTOINCREMENT,
+    			tweet_id INTEGER NOT NULL,
+    			body TEXT NOT NULL,
+    			dt_replaced INTEGER NOT NULL,
+    			FOREIGN KEY(tweet_id) REFERENCES tweets(id)
+			)`,
+			`CREATE INDEX IF NOT EXISTS tweet_revisions_tweet_id ON tweet_revisions (tweet_id)`,
+			`CREATE TRIGGER IF NOT EXISTS tweetsDeleteRevisions AFTER DELETE ON tweets
+				BEGIN
+					DELETE FROM tweet_revisions WHERE tweet_id = OLD.id;
+				END`,
+		},
+		down: []string{
+			`DROP TRIGGER IF EXISTS tweetsDeleteRevisions`,
+			`DROP TABLE IF EXISTS tweet_revisions`,
+		},
+	},
+	{
+		version:     9,
+		description: "Store each tweet's twt hash",
+		// Existing tweets are hashed by BackfillTweetHashes, as SQLite can't compute them.
+		up: []string{
+			`ALTER TABLE tweets ADD COLUMN hash TEXT NOT NULL DEFAULT ''`,
+			`CREATE INDEX IF NOT EXISTS tweets_hash ON tweets (hash)`,
+		},
+		down: []string{
+			`DROP INDEX IF EXISTS tweets_hash`,
+			`ALTER TABLE tweets DROP COLUMN hash`,
+		},
+	},
+	{
+		version:     10,
+		description: "Store the subject of each tweet separately from its tags",
+		// Existing tweets are left NULL until BackfillTweetSubjects parses them, which also corrects contains_tags.
+		up: []string{
+			`ALTER TABLE tweets ADD COLUMN subject TEXT`,
+			`CREATE INDEX IF NOT EXISTS tweets_subject ON tweets (subject)`,
+		},
+		down: []string{
+			`DROP INDEX IF EXISTS tweets_subject`,
+			`ALTER TABLE tweets DROP COLUMN subject`,
+		},
+	},
+	{
+		version:     11,
+		description: "Track ActivityPub followers of each user",
+		up: []string{
+			`CREATE TABLE IF NOT EXISTS ap_followers (
+    			id INTEGER PRIMARY KEY AUTOINCREMENT,
+    			user_id INTEGER NOT NULL,
+    			actor TEXT NOT NULL,
+    			inbox TEXT NOT NULL,
+    			dt_added INTEGER NOT NULL,
+    			UNIQUE (user_id, actor),
+    			FOREIGN KEY(user_id) REFERENCES users(id)
+			)`,
+			`CREATE TRIGGER IF NOT EXISTS usersDeleteFollowers AFTER DELETE ON users
+				BEGIN
+					DELETE FROM ap_followers WHERE user_id = OLD.id;
+				END`,
+		},
+		down: []string{
+			`DROP TRIGGER IF EXISTS usersDeleteFollowers`,
+			`DROP TABLE IF EXISTS ap_followers`,
+		},
+	},
+	{
+		version:     12,
+		description: "Record which peer registry federated users came from",
+		up: []string{
+			`CREATE TABLE IF NOT EXISTS user_sources (
+    			user_id INTEGER PRIMARY KEY,
+    			source TEXT NOT NULL,
+    			dt_added INTEGER NOT NULL,
+    			FOREIGN KEY(user_id) REFERENCES users(id)
+			)`,
+			`CREATE INDEX IF NOT EXISTS user_sources_source ON user_sources (source)`,
+			`CREATE TRIGGER IF NOT EXISTS usersDeleteSources AFTER DELETE ON users
+				BEGIN
+					DELETE FROM user_sources WHERE user_id = OLD.id;
+				END`,
+		},
+		down: []string{
+			`DROP TRIGGER IF EXISTS usersDeleteSources`,
+			`DROP INDEX IF EXISTS user_sources_source`,
+			`DROP TABLE IF EXISTS user_sources`,
+		},
+	},
+	{
+		version:     13,
+		description: "Store verified Webmentions of users and their tweets",
+		up: []string{
+			`CREATE TABLE IF NOT EXISTS webmentions (
+    			id INTEGER PRIMARY KEY AUTOINCREMENT,
+    			user_id INTEGER NOT NULL,
+    			twt_hash TEXT NOT NULL DEFAULT '',
+    			source TEXT NOT NULL,
+    			target TEXT NOT NULL,
+    			dt_received INTEGER NOT NULL,
+    			UNIQUE (source, target),
+    			FOREIGN KEY(user_id) REFERENCES users(id)
+			)`,
+			`CREATE INDEX IF NOT EXISTS webmentions_user ON webmentions (user_id, dt_received)`,
+			`CREATE INDEX IF NOT EXISTS webmentions_twt_hash ON webmentions (twt_hash)`,
+			`CREATE TRIGGER IF NOT EXISTS usersDeleteWebmentions AFTER DELETE ON users
+				BEGIN
+					DELETE FROM webmentions WHERE user_id = OLD.id;
+				END`,
+		},
+		down: []string{
+			`DROP TRIGGER IF EXISTS usersDeleteWebmentions`,
+			`DROP INDEX IF EXISTS webmentions_twt_hash`,
+			`DROP INDEX IF EXISTS webmentions_user`,
+			`DROP TABLE IF EXISTS webmentions`,
+		},
+	},
+	{
+		version:     14,
+		description: "Store the nick, avatar, and description feeds declare in their metadata",
+		up: []string{
+			`ALTER TABLE users ADD COLUMN meta_nick TEXT NOT NULL DEFAULT ''`,
+			`ALTER TABLE users ADD COLUMN avatar TEXT NOT NULL DEFAULT ''`,
+			`ALTER TABLE users ADD COLUMN description TEXT NOT NULL DEFAULT ''`,
+		},
+		down: []string{
+			`ALTER TABLE users DROP COLUMN description`,
+			`ALTER TABLE users DROP COLUMN avatar`,
+			`ALTER TABLE users DROP COLUMN meta_nick`,
+		},
+	},
+	{
+		version:     15,
+		description: "Record the registries feeds say they're listed with",
+		up: []string{
+			`CREATE TABLE IF NOT EXISTS registries (
+    			url TEXT PRIMARY KEY,
+    			discovered_via TEXT NOT NULL,
+    			dt_discovered INTEGER NOT NULL,
+    			dt_last_seen INTEGER NOT NULL,
+    			dt_announced INTEGER NOT NULL DEFAULT 0,
+    			dt_announce_attempt INTEGER NOT NULL DEFAULT 0,
+    			announce_error TEXT NOT NULL DEFAULT ''
+			)`,
+		},
+		down: []string{
+			`DROP TABLE IF EXISTS registries`,
+		},
+	},
+	{
+		version:     16,
+		description: "Track users whose feeds are hosted by the registry",
+		up: []string{
+			`CREATE TABLE IF NOT EXISTS hosted_feeds (
+    			user_id INTEGER PRIMARY KEY,
+    			dt_added INTEGER NOT NULL,
+    			FOREIGN KEY(user_id) REFERENCES users(id)
+			)`,
+			`CREATE TRIGGER IF NOT EXISTS usersDeleteHostedFeeds AFTER DELETE ON users
+				BEGIN
+					DELETE FROM hosted_feeds WHERE user_id = OLD.id;
+				END`,
+		},
+		down: []string{
+			`DROP TRIGGER IF EXISTS usersDeleteHostedFeeds`,
+			`DROP TABLE IF EXISTS hosted_feeds`,
+		},
+	},
+	{
+		version:     17,
+		description: "Store the mentions and tags of each tweet so reads don't parse them",
+		up: []string{
+			`ALTER TABLE tweets ADD COLUMN mentions TEXT`,
+			`ALTER TABLE tweets ADD COLUMN tags TEXT`,
+		},
+		down: []string{
+			`ALTER TABLE tweets DROP COLUMN tags`,
+			`ALTER TABLE tweets DROP COLUMN mentions`,
+		},
+	},
+	{
+		version:     18,
+		description: "Keep running counts of users and tweets so they don't have to be counted",
+		up: []string{
+			`CREATE TABLE IF NOT EXISTS row_counts (
+    			name TEXT PRIMARY KEY,
+    			count INTEGER NOT NULL DEFAULT 0
+			)`,
+			`INSERT OR REPLACE INTO row_counts (name, count) SELECT 'users', COUNT(*) FROM users`,
+			`INSERT OR REPLACE INTO row_counts (name, count) SELECT 'tweets', COUNT(*) FROM tweets`,
+			`CREATE TRIGGER IF NOT EXISTS usersCountInsert AFTER INSERT ON users
+				BEGIN
+					UPDATE row_counts SET count = count + 1 WHERE name = 'users';
+				END`,
+			`CREATE TRIGGER IF NOT EXISTS usersCountDelete AFTER DELETE ON users
+				BEGIN
+					UPDATE row_counts SET count = count - 1 WHERE name = 'users';
+				END`,
+			`CREATE TRIGGER IF NOT EXISTS tweetsCountInsert AFTER INSERT ON tweets
+				BEGIN
+					UPDATE row_counts SET count = count + 1 WHERE name = 'tweets';
+				END`,
+			`CREATE TRIGGER IF NOT EXISTS tweetsCountDelete AFTER DELETE ON tweets
+				BEGIN
+					UPDATE row_counts SET count = count - 1 WHERE name = 'tweets';
+				END`,
+		},
+		down: []string{
+			`DROP TRIGGER IF EXISTS tweetsCountDelete`,
+			`DROP TRIGGER IF EXISTS tweetsCountInsert`,
+			`DROP TRIGGER IF EXISTS usersCountDelete`,
+			`DROP TRIGGER IF EXISTS usersCountInsert`,
+			`DROP TABLE IF EXISTS row_counts`,
+		},
+	},
+	{
+		version:     19,
+		description: "Index tweets and users by the columns timelines are ordered by",
+		up: []string{
+			`CREATE INDEX IF NOT EXISTS tweets_dt ON tweets (dt)`,
+			`CREATE INDEX IF NOT EXISTS tweets_user_id_dt ON tweets (user_id, dt)`,
+			`CREATE INDEX IF NOT EXISTS tweets_hidden_dt ON tweets (hidden, dt)`,
+			`CREATE INDEX IF NOT EXISTS users_dt_added ON users (dt_added)`,
+		},
+		down: []string{
+			`DROP INDEX IF EXISTS users_dt_added`,
+			`DROP INDEX IF EXISTS tweets_hidden_dt`,
+			`DROP INDEX IF EXISTS tweets_user_id_dt`,
+			`DROP INDEX IF EXISTS tweets_dt`,
+		},
+	},
+	{
+		version:     20,
+		description: "Store each tweet's original UTC offset",
+		up: []string{
+			// NULL for tweets stored before offsets were, which are shown in the server's local time.
+			`ALTER TABLE tweets ADD COLUMN utc_offset INTEGER`,
+		},
+		down: []string{
+			`ALTER TABLE tweets DROP COLUMN utc_offset`,
+		},
+	},
+	{
+		version:     21,
+		description: "Store the canonical form of each user's URL",
+		// Existing users are left NULL until BackfillCanonicalURLs sets them, skipping any that duplicate another's.
+		up: []string{
+			`ALTER TABLE users ADD COLUMN canonical_url TEXT`,
+			`CREATE UNIQUE INDEX IF NOT EXISTS users_canonical_url ON users (canonical_url)`,
+		},
+		down: []string{
+			`DROP INDEX IF EXISTS users_canonical_url`,
+			`ALTER TABLE users DROP COLUMN canonical_url`,
+		},
+	},
+	{
+		version:     22,
+		description: "Record the tweets caught by the content filter",
+		up: []string{
+			`CREATE TABLE IF NOT EXISTS filtered_tweets (
+    			tweet_id INTEGER PRIMARY KEY,
+    			rule TEXT NOT NULL,
+    			action TEXT NOT NULL,
+    			dt_filtered INTEGER NOT NULL,
+    			dt_released INTEGER NOT NULL DEFAULT 0,
+    			FOREIGN KEY(tweet_id) REFERENCES tweets(id)
+			)`,
+			`CREATE INDEX IF NOT EXISTS filtered_tweets_pending ON filtered_tweets (dt_released, dt_filtered)`,
+			`CREATE TRIGGER IF NOT EXISTS tweetsDeleteFiltered AFTER DELETE ON tweets
+				BEGIN
+					DELETE FROM filtered_tweets WHERE tweet_id = OLD.id;
+				END`,
+		},
+		down: []string{
+			`DROP TRIGGER IF EXISTS tweetsDeleteFiltered`,
+			`DROP INDEX IF EXISTS filtered_tweets_pending`,
+			`DROP TABLE IF EXISTS filtered_tweets`,
+		},
+	},
+	{
+		version:     23,
+		description: "Store the language each tweet is written in",
+		// Existing tweets are left NULL until BackfillTweetLanguages detects theirs.
+		up: []string{
+			`ALTER TABLE tweets ADD COLUMN lang TEXT`,
+			`CREATE INDEX IF NOT EXISTS tweets_lang_dt ON tweets (lang, dt)`,
+		},
+		down: []string{
+			`DROP INDEX IF EXISTS tweets_lang_dt`,
+			`ALTER TABLE tweets DROP COLUMN lang`,
+		},
+	},
+	{
+		version:     24,
+		description: "Keep a snapshot of the registry's size and health for each day",
+		up: []string{
+			`CREATE TABLE IF NOT EXISTS daily_stats (
+    			day INTEGER PRIMARY KEY,
+    			users INTEGER NOT NULL,
+    			tweets INTEGER NOT NULL,
+    			active_feeds INTEGER NOT NULL,
+    			fetch_errors INTEGER NOT NULL,
+    			dt_recorded INTEGER NOT NULL
+			)`,
+		},
+		down: []string{
+			`DROP TABLE IF EXISTS daily_stats`,
+		},
+	},
+	{
+		version:     25,
+		description: "Record the tags each tweet uses",
+		// Existing tweets have theirs recorded by BackfillTweetTags.
+		up: []string{
+			`CREATE TABLE IF NOT EXISTS tweet_tags (
+    			tweet_id INTEGER NOT NULL,
+    			tag TEXT NOT NULL,
+    			PRIMARY KEY (tag, tweet_id),
+    			FOREIGN KEY(tweet_id) REFERENCES tweets(id)
+			)`,
+			`CREATE INDEX IF NOT EXISTS tweet_tags_tweet_id ON tweet_tags (tweet_id)`,
+			`CREATE TRIGGER IF NOT EXISTS tweetsDeleteTags AFTER DELETE ON tweets
+				BEGIN
+					DELETE FROM tweet_tags WHERE tweet_id = OLD.id;
+				END`,
+		},
+		down: []string{
+			`DROP TRIGGER IF EXISTS tweetsDeleteTags`,
+			`DROP INDEX IF EXISTS tweet_tags_tweet_id`,
+			`DROP TABLE IF EXISTS tweet_tags`,
+		},
+	},
+	{
+		version:     26,
+		description: "Record when each tweet was hidden",
+		// Tweets hidden before this have their clock start now, so retention doesn't purge them all at once.
+		up: []string{
+			`ALTER TABLE tweets ADD COLUMN hidden_at INTEGER`,
+			`UPDATE tweets SET hidden_at = CAST((julianday('now') - 2440587.5) * 86400000 AS INTEGER) * 1000000 WHERE hidden != 0`,
+			`CREATE INDEX IF NOT EXISTS tweets_hidden_at ON tweets (hidden_at) WHERE hidden_at IS NOT NULL`,
+			`CREATE TRIGGER IF NOT EXISTS tweetsHiddenAt AFTER UPDATE OF hidden ON tweets
+				WHEN NEW.hidden != OLD.hidden
+				BEGIN
+					UPDATE tweets SET hidden_at = CASE
+						WHEN NEW.hidden = 0 THEN NULL
+						ELSE CAST((julianday('now') - 2440587.5) * 86400000 AS INTEGER) * 1000000
+					END WHERE id = NEW.id;
+				END`,
+		},
+		down: []string{
+			`DROP TRIGGER IF EXISTS tweetsHiddenAt`,
+			`DROP INDEX IF EXISTS tweets_hidden_at`,
+			`ALTER TABLE tweets DROP COLUMN hidden_at`,
+		},
+	},
+	{
+		version:     27,
+		description: "Keep a report of each sync run",
+		up: []string{
+			`CREATE TABLE IF NOT EXISTS sync_runs (
+    			id INTEGER PRIMARY KEY,
+    			dt_started INTEGER NOT NULL,
+    			dt_finished INTEGER NOT NULL,
+    			feeds INTEGER NOT NULL,
+    			updated INTEGER NOT NULL,
+    			not_modified INTEGER NOT NULL,
+    			failed INTEGER NOT NULL,
+    			tweets INTEGER NOT NULL,
+    			errors TEXT NOT NULL DEFAULT '{}',
+    			error TEXT NOT NULL DEFAULT ''
+			)`,
+		},
+		down: []string{
+			`DROP TABLE IF EXISTS sync_runs`,
+		},
+	},
+	{
+		version:     28,
+		description: "Keep users who deleted themselves for a grace period before removing them",
+		up: []string{
+			`ALTER TABLE users ADD COLUMN delete_after INTEGER NOT NULL DEFAULT 0`,
+			`ALTER TABLE users ADD COLUMN status_before_deletion TEXT NOT NULL DEFAULT ''`,
+		},
+		down: []string{
+			`DELETE FROM tweets WHERE user_id IN (SELECT id FROM users WHERE status = 'pending-deletion')`,
+			`DELETE FROM users WHERE status = 'pending-deletion'`,
+			`ALTER TABLE users DROP COLUMN status_before_deletion`,
+			`ALTER TABLE users DROP COLUMN delete_after`,
+		},
+	},
+	{
+		version:     29,
+		description: "Ban domains and feeds, locally or from shared blocklists",
+		up: []string{
+			`CREATE TABLE IF NOT EXISTS bans (
+    			id INTEGER PRIMARY KEY,
+    			pattern TEXT NOT NULL,
+    			source TEXT NOT NULL DEFAULT '',
+    			dt_added INTEGER NOT NULL,
+    			UNIQUE (pattern, source)
+			)`,
+		},
+		down: []string{
+			`DROP TABLE IF EXISTS bans`,
+		},
+	},
+	{
+		version:     30,
+		description: "Record the URLs feeds say they're published at and which tweets duplicate a mirror's",
+		up: []string{
+			`CREATE TABLE IF NOT EXISTS feed_urls (
+    			user_id INTEGER NOT NULL,
+    			canonical_url TEXT NOT NULL,
+    			PRIMARY KEY (user_id, canonical_url),
+    			FOREIGN KEY(user_id) REFERENCES users(id)
+			)`,
+			`CREATE INDEX IF NOT EXISTS feed_urls_canonical_url ON feed_urls (canonical_url)`,
+			`CREATE TRIGGER IF NOT EXISTS usersDeleteFeedURLs AFTER DELETE ON users
+				BEGIN
+					DELETE FROM feed_urls WHERE user_id = OLD.id;
+				END`,
+			`ALTER TABLE tweets ADD COLUMN duplicate_of INTEGER`,
+			`CREATE INDEX IF NOT EXISTS tweets_duplicate_of ON tweets (duplicate_of) WHERE duplicate_of IS NOT NULL`,
+			// A duplicate whose original is deleted is shown again until the next pass finds another original.
+			`CREATE TRIGGER IF NOT EXISTS tweetsDeleteDuplicates AFTER DELETE ON tweets
+				BEGIN
+					UPDATE tweets SET duplicate_of = NULL WHERE duplicate_of = OLD.id;
+				END`,
+		},
+		down: []string{
+			`DROP TRIGGER IF EXISTS tweetsDeleteDuplicates`,
+			`DROP INDEX IF EXISTS tweets_duplicate_of`,
+			`ALTER TABLE tweets DROP COLUMN duplicate_of`,
+			`DROP TRIGGER IF EXISTS usersDeleteFeedURLs`,
+			`DROP INDEX IF EXISTS feed_urls_canonical_url`,
+			`DROP TABLE IF EXISTS feed_urls`,
+		},
+	},
+	{
+		version:     31,
+		description: "Record a hash of each feed as last fetched",
+		up: []string{
+			`ALTER TABLE users ADD COLUMN content_hash TEXT NOT NULL DEFAULT ''`,
+		},
+		down: []string{
+			`ALTER TABLE users DROP COLUMN content_hash`,
+		},
+	},
+	{
+		version:     32,
+		description: "Record the length of each feed as last fetched",
+		up: []string{
+			`ALTER TABLE users ADD COLUMN content_length INTEGER NOT NULL DEFAULT 0`,
+		},
+		down: []string{
+			`ALTER TABLE users DROP COLUMN content_length`,
+		},
+	},
+	{
+		version:     33,
+		description: "Record the end of each feed as last fetched and how many range fetches it's had since a whole one",
+		up: []string{
+			`ALTER TABLE users ADD COLUMN content_tail_hash TEXT NOT NULL DEFAULT ''`,
+			`ALTER TABLE users ADD COLUMN partial_fetches INTEGER NOT NULL DEFAULT 0`,
+		},
+		down: []string{
+			`ALTER TABLE users DROP COLUMN partial_fetches`,
+			`ALTER TABLE users DROP COLUMN content_tail_hash`,
+		},
+	},
+}
+
+// SchemaVersion returns the version of the most recently applied migration.
+func (d *DB) SchemaVersion(ctx context.Context) (int, error) {
+	version := 0
+	if err := d.conn.QueryRowContext(ctx, "PRAGMA user_version").Scan(&version); err != nil {
+		return 0, fmt.Errorf("couldn't query schema version: %w", err)
+	}
+
+	return version, nil
+}
+
+// MigrationStatus lists every known migration and whether it has been applied.
+func (d *DB) MigrationStatus(ctx context.Context) ([]MigrationStatus, error) {
+	version, err := d.SchemaVersion(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	out := make([]MigrationStatus, 0, len(migrations))
+	for _, m := range migrations {
+		out = append(out, MigrationStatus{
+			Version:     m.version,
+			Description: m.description,
+			Applied:     m.version <= version,
+		})
+	}
+
+	return out, nil
+}
+
+// MigrateUp applies all pending migrations in order, each in its own transaction.
+// Returns the number of migrations applied.
+func (d *DB) MigrateUp(ctx context.Context) (int, error) {
+	version, err := d.SchemaVersion(ctx)
+	if err != nil {
+		return 0, err
+	}
+
+	applied := 0
+	for _, m := range migrations {
+		if m.version <= version {
+			continue
+		}
+		if err := d.applyMigration(ctx, m.up, m.version); err != nil {
+			return applied, fmt.Errorf("while applying migration %d (%s): %w", m.version, m.description, err)
+		}
+		d.logger.Debugf("Applied migration %d: %s", m.version, m.description)
+		applied++
+	}
+
+	return applied, nil
+}
+
+// MigrateDown reverts the most recently applied migration.
+func (d *DB) MigrateDown(ctx context.Context) error {
+	version, err := d.SchemaVersion(ctx)
+	if err != nil {
+		return err
+	}
+	if version < 1 {
+		return ErrNoMigrationsApplied
+	}
+
+	for i := len(migrations) - 1; i >= 0; i-- {
+		m := migrations[i]
+		if m.version != version {
+			continue
+		}
+		if err := d.applyMigration(ctx, m.down, m.version-1); err != nil {
+			return fmt.Errorf("while reverting migration %d (%s): %w", m.version, m.description, err)
+		}
+		d.logger.Debugf("Reverted migration %d: %s", m.version, m.description)
+		return nil
+	}
+
+	return fmt.Errorf("schema version %d is unknown to this version of getwtxt-ng", version)
+}
+
+// applyMigration runs the provided statements and sets the schema version in a single transaction.
+func (d *DB) applyMigration(ctx context.Context, stmts []string, newVersion int) error {
+	tx, err := d.beginWrite(ctx)
+	if err != nil {
+		return fmt.Errorf("couldn't begin transaction: %w", err)
+	}
+	defer func() {
+		_ = tx.Rollback()
+	}()
+
+	for _, stmt := range stmts {
+		if _, err := tx.ExecContext(ctx, stmt); err != nil {
+			return err
+		}
+	}
+
+	// PRAGMA statements can't take bound parameters.
+	if _, err := tx.ExecContext(ctx, fmt.Sprintf("PRAGMA user_version = %d", newVersion)); err != nil {
+		return fmt.Errorf("couldn't set schema version to %d: %w", newVersion, err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("couldn't commit transaction: %w", err)
+	}
+	d.invalidate()
+
+	return nil
+}
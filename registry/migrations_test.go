@@ -0,0 +1,107 @@
+package registry
+
+/*
+Copyright 2021 G. Benjamin Morrison
+
+This file is part of getwtxt-ng.
+
+getwtxt-ng is free software: you can redistribute it and/or modify
+it under the terms of the GNU Affero General Public License as
+published by the Free Software Foundation, either version 3 of the
+License, or (at your option) any later version.
+
+getwtxt-ng is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU Affero General Public License for more details.
+
+You should have received a copy of the GNU Affero General Public License
+along with getwtxt-ng.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	log "github.com/sirupsen/logrus"
+)
+
+func TestDB_Migrations(t *testing.T) {
+	ctx := context.Background()
+	db, err := OpenSQLite(":memory:", log.StandardLogger())
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	defer func() {
+		_ = db.conn.Close()
+	}()
+
+	latest := migrations[len(migrations)-1].version
+
+	t.Run("nothing applied", func(t *testing.T) {
+		status, err := db.MigrationStatus(ctx)
+		if err != nil {
+			t.Fatal(err.Error())
+		}
+		if len(status) != len(migrations) {
+			t.Errorf("Expected %d migrations, got %d", len(migrations), len(status))
+		}
+		for _, m := range status {
+			if m.Applied {
+				t.Errorf("Migration %d unexpectedly marked as applied", m.Version)
+			}
+		}
+		if err := db.MigrateDown(ctx); !errors.Is(err, ErrNoMigrationsApplied) {
+			t.Errorf("Expected ErrNoMigrationsApplied, got: %v", err)
+		}
+	})
+
+	t.Run("migrate up", func(t *testing.T) {
+		applied, err := db.MigrateUp(ctx)
+		if err != nil {
+			t.Fatal(err.Error())
+		}
+		if applied != len(migrations) {
+			t.Errorf("Expected %d migrations applied, got %d", len(migrations), applied)
+		}
+		version, err := db.SchemaVersion(ctx)
+		if err != nil {
+			t.Fatal(err.Error())
+		}
+		if version != latest {
+			t.Errorf("Expected schema version %d, got %d", latest, version)
+		}
+		applied, err = db.MigrateUp(ctx)
+		if err != nil {
+			t.Fatal(err.Error())
+		}
+		if applied != 0 {
+			t.Errorf("Expected no migrations applied on second run, got %d", applied)
+		}
+	})
+
+	t.Run("migrate down", func(t *testing.T) {
+		if err := db.MigrateDown(ctx); err != nil {
+			t.Fatal(err.Error())
+		}
+		version, err := db.SchemaVersion(ctx)
+		if err != nil {
+			t.Fatal(err.Error())
+		}
+		if version != latest-1 {
+			t.Errorf("Expected schema version %d, got %d", latest-1, version)
+		}
+		if _, err := db.MigrateUp(ctx); err != nil {
+			t.Fatal(err.Error())
+		}
+	})
+
+	t.Run("canceled context", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+		if _, err := db.MigrateUp(ctx); err == nil {
+			t.Error("expected error, got none")
+		}
+	})
+}
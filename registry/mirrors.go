@@ -0,0 +1,277 @@
+package registry
+
+/*
+Copyright 2021 G. Benjamin Morrison
+
+This file is part of getwtxt-ng.
+
+getwtxt-ng is free software: you can redistribute it and/or modify
+it under the terms of the GNU Affero General Public License as
+published by the Free Software Foundation, either version 3 of the
+License, or (at your option) any later version.
+
+getwtxt-ng is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU Affero General Public License for more details.
+
+You should have received a copy of the GNU Affero General Public License
+along with getwtxt-ng.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// recordFeedURLsTx replaces the URLs the user's feed says it's published at with the canonical forms of urls.
+// URLs that can't be canonicalized are skipped.
+func recordFeedURLsTx(ctx context.Context, tx *sql.Tx, userID string, urls []string) error {
+	if _, err := tx.ExecContext(ctx, "DELETE FROM feed_urls WHERE user_id = ?", userID); err != nil {
+		return fmt.Errorf("when clearing URLs declared by user %s: %w", userID, err)
+	}
+	stmt := "INSERT INTO feed_urls (user_id, canonical_url) VALUES (?, ?) ON CONFLICT (user_id, canonical_url) DO NOTHING"
+	for _, u := range urls {
+		canonical, err := CanonicalURL(u)
+		if err != nil {
+			continue
+		}
+		if _, err := tx.ExecContext(ctx, stmt, userID, canonical); err != nil {
+			return fmt.Errorf("when recording URL %s declared by user %s: %w", u, userID, err)
+		}
+	}
+
+	return nil
+}
+
+// mirrorGroups returns the groups of active users whose feeds mirror each other, each sorted by user ID.
+// Two feeds are mirrors when each lists the other's URL in its metadata, so one feed can't claim another's tweets.
+func mirrorGroups(ctx context.Context, tx *sql.Tx) ([][]int64, error) {
+	stmt := `SELECT a.user_id, b.user_id FROM feed_urls a
+				JOIN users ub ON ub.canonical_url = a.canonical_url AND ub.id != a.user_id
+				JOIN feed_urls b ON b.user_id = ub.id
+				JOIN users ua ON ua.id = a.user_id AND ua.canonical_url = b.canonical_url
+				WHERE a.user_id < b.user_id AND ua.status = 'active' AND ub.status = 'active'`
+	rows, err := tx.QueryContext(ctx, stmt)
+	if err != nil {
+		return nil, fmt.Errorf("when querying for mirrored feeds: %w", err)
+	}
+	defer func() {
+		_ = rows.Close()
+	}()
+
+	parent := make(map[int64]int64)
+	var find func(id int64) int64
+	find = func(id int64) int64 {
+		p, ok := parent[id]
+		if !ok || p == id {
+			parent[id] = id
+			return id
+		}
+		root := find(p)
+		parent[id] = root
+		return root
+	}
+	for rows.Next() {
+		var a, b int64
+		if err := rows.Scan(&a, &b); err != nil {
+			return nil, fmt.Errorf("when scanning mirrored feeds: %w", err)
+		}
+		ra, rb := find(a), find(b)
+		if ra < rb {
+			parent[rb] = ra
+		} else if rb < ra {
+			parent[ra] = rb
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("when reading mirrored feeds: %w", err)
+	}
+
+	byRoot := make(map[int64][]int64)
+	for id := range parent {
+		root := find(id)
+		byRoot[root] = append(byRoot[root], id)
+	}
+	groups := make([][]int64, 0, len(byRoot))
+	for _, group := range byRoot {
+		sort.Slice(group, func(i, j int) bool { return group[i] < group[j] })
+		groups = append(groups, group)
+	}
+	sort.Slice(groups, func(i, j int) bool { return groups[i][0] < groups[j][0] })
+
+	return groups, nil
+}
+
+// mirroredDuplicates returns, for each visible tweet in the group that another feed in it also posted with the
+// same timestamp and body, the ID of the copy it duplicates. The original is the copy from the feed registered first.
+func mirroredDuplicates(ctx context.Context, tx *sql.Tx, group []int64) (map[int64]int64, error) {
+	placeholders := strings.TrimSuffix(strings.Repeat("?,", len(group)), ",")
+	stmt := fmt.Sprintf(`SELECT t.id, t.user_id, t.dt, t.body FROM tweets t
+				WHERE t.user_id IN (%[1]s) AND t.hidden = 0
+				AND EXISTS (SELECT 1 FROM tweets o WHERE o.dt = t.dt AND o.body = t.body AND o.user_id IN (%[1]s)
+					AND o.user_id != t.user_id AND o.hidden = 0)
+				ORDER BY t.user_id ASC, t.id ASC`, placeholders)
+	args := make([]interface{}, 0, len(group)*2)
+	for i := 0; i < 2; i++ {
+		for _, id := range group {
+			args = append(args, id)
+		}
+	}
+	rows, err := tx.QueryContext(ctx, stmt, args...)
+	if err != nil {
+		return nil, fmt.Errorf("when querying for tweets duplicated across mirrored feeds: %w", err)
+	}
+	defer func() {
+		_ = rows.Close()
+	}()
+
+	type twt struct {
+		dt   int64
+		body string
+	}
+	originals := make(map[twt]int64)
+	duplicates := make(map[int64]int64)
+	for rows.Next() {
+		var id, userID, dt int64
+		var body string
+		if err := rows.Scan(&id, &userID, &dt, &body); err != nil {
+			return nil, fmt.Errorf("when scanning duplicated tweet: %w", err)
+		}
+		key := twt{dt: dt, body: body}
+		if original, ok := originals[key]; ok {
+			duplicates[id] = original
+			continue
+		}
+		originals[key] = id
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("when reading duplicated tweets: %w", err)
+	}
+
+	return duplicates, nil
+}
+
+// CollapseMirroredTweets marks the tweets mirrored feeds both posted as duplicates of the original, which is kept
+// from the feed registered first. Lists across feeds leave duplicates out and give the original their copies as
+// alternates. Without WithCollapseMirrors, it clears the marks instead. Returns how many tweets were marked or cleared.
+func (d *DB) CollapseMirroredTweets(ctx context.Context) (int64, error) {
+	tx, err := d.beginWrite(ctx)
+	if err != nil {
+		return 0, fmt.Errorf("when beginning tx to collapse mirrored tweets: %w", err)
+	}
+	defer func() {
+		_ = tx.Rollback()
+	}()
+
+	want := make(map[int64]int64)
+	if d.collapseMirrors {
+		groups, err := mirrorGroups(ctx, tx.Tx)
+		if err != nil {
+			return 0, err
+		}
+		for _, group := range groups {
+			duplicates, err := mirroredDuplicates(ctx, tx.Tx, group)
+			if err != nil {
+				return 0, err
+			}
+			for id, original := range duplicates {
+				want[id] = original
+			}
+		}
+	}
+
+	rows, err := tx.QueryContext(ctx, "SELECT id, duplicate_of FROM tweets WHERE duplicate_of IS NOT NULL")
+	if err != nil {
+		return 0, fmt.Errorf("when querying for collapsed tweets: %w", err)
+	}
+	have := make(map[int64]int64)
+	for rows.Next() {
+		var id, original int64
+		if err := rows.Scan(&id, &original); err != nil {
+			_ = rows.Close()
+			return 0, fmt.Errorf("when scanning collapsed tweet: %w", err)
+		}
+		have[id] = original
+	}
+	if err := rows.Err(); err != nil {
+		_ = rows.Close()
+		return 0, fmt.Errorf("when reading collapsed tweets: %w", err)
+	}
+	_ = rows.Close()
+
+	// Only tweets whose mark changes are written, since every update to a tweet reindexes it for search.
+	changed := int64(0)
+	for id := range have {
+		if _, ok := want[id]; ok {
+			continue
+		}
+		if _, err := tx.ExecContext(ctx, "UPDATE tweets SET duplicate_of = NULL WHERE id = ?", id); err != nil {
+			return 0, fmt.Errorf("when clearing duplicate mark of tweet %d: %w", id, err)
+		}
+		changed++
+	}
+	for id, original := range want {
+		if current, ok := have[id]; ok && current == original {
+			continue
+		}
+		if _, err := tx.ExecContext(ctx, "UPDATE tweets SET duplicate_of = ? WHERE id = ?", original, id); err != nil {
+			return 0, fmt.Errorf("when marking tweet %d as a duplicate of %d: %w", id, original, err)
+		}
+		changed++
+	}
+
+	if err := tx.Commit(); err != nil {
+		return 0, fmt.Errorf("when committing tx to collapse mirrored tweets: %w", err)
+	}
+	if changed > 0 {
+		d.invalidate()
+	}
+
+	return changed, nil
+}
+
+// loadAlternates fills in the copies of each tweet posted by feeds mirroring its author's.
+func (d *DB) loadAlternates(ctx context.Context, tweets []Tweet) error {
+	if !d.collapseMirrors || len(tweets) == 0 {
+		return nil
+	}
+
+	byID := make(map[string]int, len(tweets))
+	args := make([]interface{}, 0, len(tweets))
+	for i, t := range tweets {
+		byID[t.ID] = i
+		args = append(args, t.ID)
+	}
+	placeholders := strings.TrimSuffix(strings.Repeat("?,", len(tweets)), ",")
+	stmt := fmt.Sprintf(`SELECT tweets.duplicate_of, tweets.id, users.nick, users.url, tweets.hash
+				FROM tweets JOIN users ON users.id = tweets.user_id
+				WHERE tweets.duplicate_of IN (%s) AND users.status = 'active'
+				ORDER BY tweets.user_id ASC`, placeholders)
+	rows, err := d.conn.QueryContext(ctx, stmt, args...)
+	if err != nil {
+		return fmt.Errorf("when querying for alternates of %d tweets: %w", len(tweets), err)
+	}
+	defer func() {
+		_ = rows.Close()
+	}()
+
+	for rows.Next() {
+		var original string
+		alt := Alternate{}
+		if err := rows.Scan(&original, &alt.ID, &alt.Nickname, &alt.URL, &alt.Hash); err != nil {
+			return fmt.Errorf("when scanning alternate tweet: %w", err)
+		}
+		if i, ok := byID[original]; ok {
+			tweets[i].Alternates = append(tweets[i].Alternates, alt)
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return fmt.Errorf("when reading alternate tweets: %w", err)
+	}
+
+	return nil
+}
@@ -0,0 +1,124 @@
+package registry
+
+/*
+Copyright 2021 G. Benjamin Morrison
+
+This file is part of getwtxt-ng.
+
+getwtxt-ng is free software: you can redistribute it and/or modify
+it under the terms of the GNU Affero General Public License as
+published by the Free Software Foundation, either version 3 of the
+License, or (at your option) any later version.
+
+getwtxt-ng is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU Affero General Public License for more details.
+
+You should have received a copy of the GNU Affero General Public License
+along with getwtxt-ng.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+func TestDB_CollapseMirroredTweets(t *testing.T) {
+	ctx := context.Background()
+	memDB, err := Open(":memory:", WithLogger(log.StandardLogger()))
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	t.Cleanup(func() {
+		_ = memDB.Close()
+	})
+
+	dt := time.Date(2022, 1, 1, 0, 0, 0, 0, time.UTC)
+	shared := []Tweet{{DateTime: dt, Body: "hello from both places"}, {DateTime: dt.Add(time.Hour), Body: "only here"}}
+	users := []User{
+		{Nick: "foo", URL: "https://foo.example/twtxt.txt", PasscodeHash: []byte("hash")},
+		{Nick: "foo", URL: "https://mirror.example/foo.txt", PasscodeHash: []byte("hash")},
+		{Nick: "copycat", URL: "https://copycat.example/twtxt.txt", PasscodeHash: []byte("hash")},
+	}
+	for i := range users {
+		tweets := shared
+		if i > 0 {
+			tweets = shared[:1]
+		}
+		if _, err := memDB.InsertUserWithTweets(ctx, &users[i], tweets); err != nil {
+			t.Fatal(err.Error())
+		}
+	}
+	// The mirrors list each other. The copycat claims to be a mirror, but isn't listed back.
+	metadata := map[string]FeedMetadata{
+		users[0].ID: {URLs: []string{"https://foo.example/twtxt.txt", "https://www.mirror.example/foo.txt"}},
+		users[1].ID: {URLs: []string{"https://foo.example/twtxt.txt", "https://mirror.example/foo.txt"}},
+		users[2].ID: {URLs: []string{"https://foo.example/twtxt.txt"}},
+	}
+	for id, meta := range metadata {
+		if err := memDB.SetFeedMetadata(ctx, id, meta); err != nil {
+			t.Fatal(err.Error())
+		}
+	}
+
+	if n, err := memDB.CollapseMirroredTweets(ctx); err != nil || n != 0 {
+		t.Fatalf("Expected nothing collapsed while it's off, got %d, %v", n, err)
+	}
+
+	memDB.collapseMirrors = true
+	if n, err := memDB.CollapseMirroredTweets(ctx); err != nil || n != 1 {
+		t.Fatalf("Expected the mirror's copy to be collapsed, got %d, %v", n, err)
+	}
+	if n, err := memDB.CollapseMirroredTweets(ctx); err != nil || n != 0 {
+		t.Errorf("Expected nothing to change collapsing again, got %d, %v", n, err)
+	}
+
+	tweets, err := memDB.GetTweets(ctx, 0, 20, StatusVisible)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	if len(tweets) != 3 {
+		t.Fatalf("Expected the mirror's copy to be left out, got %+v", tweets)
+	}
+	for _, tweet := range tweets {
+		switch tweet.UserID {
+		case users[0].ID:
+			if tweet.Body == shared[0].Body && (len(tweet.Alternates) != 1 || tweet.Alternates[0].URL != users[1].URL) {
+				t.Errorf("Expected the mirror's copy as an alternate, got %+v", tweet.Alternates)
+			}
+		case users[1].ID:
+			t.Errorf("Expected the mirror's copy to be left out, got %+v", tweet)
+		case users[2].ID:
+			if len(tweet.Alternates) != 0 {
+				t.Errorf("Expected no alternates for the copycat, got %+v", tweet.Alternates)
+			}
+		}
+	}
+
+	timeline, err := memDB.GetTimeline(ctx, TimelineQuery{UserID: users[1].ID})
+	if err != nil || len(timeline) != 1 {
+		t.Errorf("Expected the mirror's own timeline to keep its copy, got %+v, %v", timeline, err)
+	}
+
+	memDB.collapseMirrors = false
+	if n, err := memDB.CollapseMirroredTweets(ctx); err != nil || n != 1 {
+		t.Errorf("Expected the mark to be cleared once it's off, got %d, %v", n, err)
+	}
+	memDB.collapseMirrors = true
+	if _, err := memDB.CollapseMirroredTweets(ctx); err != nil {
+		t.Fatal(err.Error())
+	}
+
+	// Deleting the original shows the copy again.
+	if _, err := memDB.DeleteUser(ctx, &users[0]); err != nil {
+		t.Fatal(err.Error())
+	}
+	tweets, err = memDB.GetTweets(ctx, 0, 20, StatusVisible)
+	if err != nil || len(tweets) != 2 {
+		t.Errorf("Expected the copies of the deleted original to be shown, got %+v, %v", tweets, err)
+	}
+}
@@ -0,0 +1,176 @@
+package registry
+
+/*
+Copyright 2021 G. Benjamin Morrison
+
+This file is part of getwtxt-ng.
+
+getwtxt-ng is free software: you can redistribute it and/or modify
+it under the terms of the GNU Affero General Public License as
+published by the Free Software Foundation, either version 3 of the
+License, or (at your option) any later version.
+
+getwtxt-ng is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU Affero General Public License for more details.
+
+You should have received a copy of the GNU Affero General Public License
+along with getwtxt-ng.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+import (
+	"net/http"
+	"time"
+)
+
+const (
+	defaultEntriesPerPageMin = 20
+	defaultEntriesPerPageMax = 1000
+
+	defaultFetchTimeout             = 30 * time.Second
+	defaultFetchTLSHandshakeTimeout = 10 * time.Second
+	defaultFetchMaxIdleConnsPerHost = 4
+	defaultFetchIdleConnTimeout     = 90 * time.Second
+)
+
+// Option configures the DB returned by Open.
+type Option func(*options)
+
+type options struct {
+	entriesPerPageMin int
+	entriesPerPageMax int
+	httpClient        *http.Client
+	userAgent         string
+	httpTuning        HTTPTuning
+	logger            Logger
+	readCachePages    int
+	readCacheTTL      time.Duration
+	queryTimeout      time.Duration
+	filter            *ContentFilter
+	collapseMirrors   bool
+}
+
+// WithHTTPClient sets the client used to fetch twtxt files. When provided, WithUserAgent and WithHTTPTuning have no effect,
+// so any User-Agent needs to be set by the client's transport.
+func WithHTTPClient(client *http.Client) Option {
+	return func(o *options) {
+		o.httpClient = client
+	}
+}
+
+// HTTPTuning configures the transport of the default HTTP client. Zero values keep the defaults.
+type HTTPTuning struct {
+	// Timeout bounds a whole fetch, including reading the body. The default is 30 seconds.
+	Timeout time.Duration
+
+	// TLSHandshakeTimeout bounds the TLS handshake. The default is 10 seconds.
+	TLSHandshakeTimeout time.Duration
+
+	// MaxIdleConnsPerHost is how many idle connections to a single host are kept for reuse.
+	// Many feeds are served from the same host, so the default is 4.
+	MaxIdleConnsPerHost int
+
+	// IdleConnTimeout is how long an idle connection is kept for reuse. The default is 90 seconds.
+	IdleConnTimeout time.Duration
+
+	// DisableKeepAlives opens a new connection for every fetch.
+	DisableKeepAlives bool
+}
+
+// withDefaults fills in zero values with the defaults.
+func (t HTTPTuning) withDefaults() HTTPTuning {
+	if t.Timeout <= 0 {
+		t.Timeout = defaultFetchTimeout
+	}
+	if t.TLSHandshakeTimeout <= 0 {
+		t.TLSHandshakeTimeout = defaultFetchTLSHandshakeTimeout
+	}
+	if t.MaxIdleConnsPerHost <= 0 {
+		t.MaxIdleConnsPerHost = defaultFetchMaxIdleConnsPerHost
+	}
+	if t.IdleConnTimeout <= 0 {
+		t.IdleConnTimeout = defaultFetchIdleConnTimeout
+	}
+	return t
+}
+
+// newHTTPClient builds the default client used to fetch twtxt files.
+func newHTTPClient(tuning HTTPTuning, userAgent string) *http.Client {
+	tuning = tuning.withDefaults()
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.TLSHandshakeTimeout = tuning.TLSHandshakeTimeout
+	transport.MaxIdleConnsPerHost = tuning.MaxIdleConnsPerHost
+	transport.IdleConnTimeout = tuning.IdleConnTimeout
+	transport.DisableKeepAlives = tuning.DisableKeepAlives
+
+	rt := NewRoundTripperWithHeader(transport)
+	rt.Header.Set("User-Agent", userAgent)
+	return &http.Client{
+		Timeout:   tuning.Timeout,
+		Transport: rt,
+	}
+}
+
+// WithHTTPTuning configures the transport of the default HTTP client. It has no effect with WithHTTPClient.
+func WithHTTPTuning(tuning HTTPTuning) Option {
+	return func(o *options) {
+		o.httpTuning = tuning
+	}
+}
+
+// WithLogger sets the logger the registry writes to. A nil logger discards everything.
+func WithLogger(logger Logger) Option {
+	return func(o *options) {
+		o.logger = logger
+	}
+}
+
+// WithPageLimits sets the minimum and maximum number of users or tweets returned in a single page.
+func WithPageLimits(minEntries, maxEntries int) Option {
+	return func(o *options) {
+		o.entriesPerPageMin = minEntries
+		o.entriesPerPageMax = maxEntries
+	}
+}
+
+// WithUserAgent sets the User-Agent header sent when fetching twtxt files with the default HTTP client.
+func WithUserAgent(userAgent string) Option {
+	return func(o *options) {
+		o.userAgent = userAgent
+	}
+}
+
+// WithReadCache keeps the first pages of GetTweets and GetUsers, along with the tweet and user counts,
+// in memory for up to ttl. The cache is dropped whenever a write through this DB is committed,
+// so ttl only bounds how long writes made by other processes can go unnoticed.
+func WithReadCache(pages int, ttl time.Duration) Option {
+	return func(o *options) {
+		o.readCachePages = pages
+		o.readCacheTTL = ttl
+	}
+}
+
+// WithContentFilter checks each tweet InsertTweets stores, including edits, against filter.
+// A nil filter, the default, checks nothing.
+func WithContentFilter(filter *ContentFilter) Option {
+	return func(o *options) {
+		o.filter = filter
+	}
+}
+
+// WithCollapseMirrors has CollapseMirroredTweets mark tweets that mirrored feeds both posted, so lists show them once.
+func WithCollapseMirrors(collapse bool) Option {
+	return func(o *options) {
+		o.collapseMirrors = collapse
+	}
+}
+
+// WithQueryTimeout limits how long each read may take, on top of any deadline the caller's context has,
+// so a pathological search can't hold a connection indefinitely. A read that's cut off returns an error
+// wrapping context.DeadlineExceeded. Writes aren't limited. A timeout of 0, the default, disables the limit.
+func WithQueryTimeout(timeout time.Duration) Option {
+	return func(o *options) {
+		o.queryTimeout = timeout
+	}
+}
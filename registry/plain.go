@@ -0,0 +1,121 @@
+package registry
+
+/*
+Copyright 2021 G. Benjamin Morrison
+
+This file is part of getwtxt-ng.
+
+getwtxt-ng is free software: you can redistribute it and/or modify
+it under the terms of the GNU Affero General Public License as
+published by the Free Software Foundation, either version 3 of the
+License, or (at your option) any later version.
+
+getwtxt-ng is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU Affero General Public License for more details.
+
+You should have received a copy of the GNU Affero General Public License
+along with getwtxt-ng.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// PlainFormat decides how values are written in the plain text listings of tweets, users, and so on.
+// The zero value writes timestamps as they're stored, to the second, and escapes control characters.
+type PlainFormat struct {
+	// ControlChars is how control characters in twt bodies are written. The zero value is ControlCharsEscape.
+	ControlChars ControlCharMode
+
+	// Location is the timezone timestamps are converted to. If nil, they're left in the one they're stored with:
+	// tweets in the offset their feed gave, and everything else in the server's local time.
+	Location *time.Location
+
+	// Nanoseconds writes timestamps with fractional seconds, as RFC3339Nano does, rather than to the second.
+	Nanoseconds bool
+}
+
+// Timestamp writes t as RFC3339 in the format's timezone and precision.
+func (f PlainFormat) Timestamp(t time.Time) string {
+	if f.Location != nil {
+		t = t.In(f.Location)
+	}
+	if f.Nanoseconds {
+		return t.Format(time.RFC3339Nano)
+	}
+	return t.Format(time.RFC3339)
+}
+
+// ControlCharMode decides how control characters in twt bodies are written in plain output,
+// where an embedded tab or newline would otherwise be taken for the end of a field or line.
+type ControlCharMode string
+
+const (
+	// ControlCharsEscape writes tabs, newlines, and carriage returns as \t, \n, and \r,
+	// and other control characters as \xNN.
+	ControlCharsEscape ControlCharMode = "escape"
+
+	// ControlCharsReplace writes each control character as a space.
+	ControlCharsReplace ControlCharMode = "replace"
+)
+
+// ErrInvalidControlCharMode is returned when a control character mode other than the known ones is provided.
+var ErrInvalidControlCharMode = errors.New("invalid control character mode")
+
+// ParseControlCharMode converts the provided string into a ControlCharMode. An empty string is ControlCharsEscape.
+func ParseControlCharMode(mode string) (ControlCharMode, error) {
+	m := ControlCharMode(strings.ToLower(strings.TrimSpace(mode)))
+	switch m {
+	case "":
+		return ControlCharsEscape, nil
+	case ControlCharsEscape, ControlCharsReplace:
+		return m, nil
+	default:
+		return "", fmt.Errorf("%w: %s", ErrInvalidControlCharMode, mode)
+	}
+}
+
+// plainBody returns the body with its control characters written as the mode says.
+func (mode ControlCharMode) plainBody(body string) string {
+	clean := true
+	for i := 0; i < len(body); i++ {
+		if body[i] < 0x20 || body[i] == 0x7f {
+			clean = false
+			break
+		}
+	}
+	if clean {
+		return body
+	}
+
+	builder := strings.Builder{}
+	builder.Grow(len(body) + 8)
+	for i := 0; i < len(body); i++ {
+		c := body[i]
+		if c >= 0x20 && c != 0x7f {
+			builder.WriteByte(c)
+			continue
+		}
+		if mode == ControlCharsReplace {
+			builder.WriteByte(' ')
+			continue
+		}
+		switch c {
+		case '\t':
+			builder.WriteString(`\t`)
+		case '\n':
+			builder.WriteString(`\n`)
+		case '\r':
+			builder.WriteString(`\r`)
+		default:
+			builder.WriteString(fmt.Sprintf(`\x%02x`, c))
+		}
+	}
+
+	return builder.String()
+}
@@ -0,0 +1,52 @@
+package registry
+
+/*
+Copyright 2021 G. Benjamin Morrison
+
+This file is part of getwtxt-ng.
+
+getwtxt-ng is free software: you can redistribute it and/or modify
+it under the terms of the GNU Affero General Public License as
+published by the Free Software Foundation, either version 3 of the
+License, or (at your option) any later version.
+
+getwtxt-ng is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU Affero General Public License for more details.
+
+You should have received a copy of the GNU Affero General Public License
+along with getwtxt-ng.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+import (
+	"testing"
+	"time"
+)
+
+func TestPlainFormat_Timestamp(t *testing.T) {
+	offset := time.FixedZone("", 5*60*60+30*60)
+	dt := time.Date(2021, 1, 1, 12, 0, 0, 123456789, offset)
+	tokyo, err := time.LoadLocation("Asia/Tokyo")
+	if err != nil {
+		t.Skipf("No timezone database: %s", err)
+	}
+
+	cases := []struct {
+		name string
+		f    PlainFormat
+		want string
+	}{
+		{name: "as stored", f: PlainFormat{}, want: "2021-01-01T12:00:00+05:30"},
+		{name: "utc", f: PlainFormat{Location: time.UTC}, want: "2021-01-01T06:30:00Z"},
+		{name: "named zone", f: PlainFormat{Location: tokyo}, want: "2021-01-01T15:30:00+09:00"},
+		{name: "nanoseconds", f: PlainFormat{Location: time.UTC, Nanoseconds: true}, want: "2021-01-01T06:30:00.123456789Z"},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			if got := tc.f.Timestamp(dt); got != tc.want {
+				t.Errorf("Expected %s, got %s", tc.want, got)
+			}
+		})
+	}
+}
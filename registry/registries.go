@@ -0,0 +1,169 @@
+package registry
+
+/*
+Copyright 2021 G. Benjamin Morrison
+
+This file is part of getwtxt-ng.
+
+getwtxt-ng is free software: you can redistribute it and/or modify
+it under the terms of the GNU Affero General Public License as
+published by the Free Software Foundation, either version 3 of the
+License, or (at your option) any later version.
+
+getwtxt-ng is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU Affero General Public License for more details.
+
+You should have received a copy of the GNU Affero General Public License
+along with getwtxt-ng.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// KnownRegistry is another twtxt registry that a feed here says it's listed with.
+type KnownRegistry struct {
+	URL string `json:"url"`
+
+	// DiscoveredVia is the URL of the first feed seen declaring the registry.
+	DiscoveredVia string    `json:"discovered_via"`
+	Discovered    time.Time `json:"discovered"`
+	LastSeen      time.Time `json:"last_seen"`
+
+	// Announced is when this registry was announced there, or zero if it hasn't been.
+	Announced time.Time `json:"announced"`
+
+	// AnnounceError is why the last attempt to announce this registry there failed, or empty if it didn't.
+	AnnounceError string `json:"announce_error,omitempty"`
+}
+
+// FormatRegistriesPlain formats the provided slice of KnownRegistry into plain text, with each LF-terminated line
+// containing the following tab-separated values:
+//   - URL
+//   - URL of the feed it was discovered through
+//   - Timestamp Discovered (RFC3339)
+//   - Timestamp Last Seen (RFC3339)
+func FormatRegistriesPlain(registries []KnownRegistry) string {
+	return FormatRegistriesPlainWith(registries, PlainFormat{})
+}
+
+// FormatRegistriesPlainWith formats registries as FormatRegistriesPlain does, with timestamps written as f says.
+func FormatRegistriesPlainWith(registries []KnownRegistry, f PlainFormat) string {
+	if len(registries) < 1 {
+		return ""
+	}
+
+	builder := strings.Builder{}
+	builder.Grow(len(registries) * 128)
+	for _, reg := range registries {
+		builder.WriteString(reg.URL)
+		builder.WriteString("\t")
+		builder.WriteString(reg.DiscoveredVia)
+		builder.WriteString("\t")
+		builder.WriteString(f.Timestamp(reg.Discovered))
+		builder.WriteString("\t")
+		builder.WriteString(f.Timestamp(reg.LastSeen))
+		builder.WriteString("\n")
+	}
+
+	return builder.String()
+}
+
+// GetKnownRegistries retrieves the registries declared by feeds here, most recently seen first.
+func (d *DB) GetKnownRegistries(ctx context.Context) ([]KnownRegistry, error) {
+	ctx, cancel := d.withQueryTimeout(ctx)
+	defer cancel()
+
+	stmt := `SELECT url, discovered_via, dt_discovered, dt_last_seen, dt_announced, announce_error
+				FROM registries ORDER BY dt_last_seen DESC, url ASC`
+	rows, err := d.conn.QueryContext(ctx, stmt)
+	if err != nil {
+		return nil, fmt.Errorf("when querying for known registries: %w", err)
+	}
+	defer func() {
+		_ = rows.Close()
+	}()
+
+	return d.scanRegistryRows(rows)
+}
+
+// GetUnannouncedRegistries retrieves the known registries that haven't been announced to yet, oldest first.
+// Registries where announcing failed are included again once retryAfter has passed since the attempt.
+func (d *DB) GetUnannouncedRegistries(ctx context.Context, retryAfter time.Duration) ([]KnownRegistry, error) {
+	ctx, cancel := d.withQueryTimeout(ctx)
+	defer cancel()
+
+	stmt := `SELECT url, discovered_via, dt_discovered, dt_last_seen, dt_announced, announce_error
+				FROM registries
+				WHERE dt_announced = 0 AND dt_announce_attempt < ?
+				ORDER BY dt_discovered ASC, url ASC`
+	rows, err := d.conn.QueryContext(ctx, stmt, time.Now().Add(-retryAfter).UnixNano())
+	if err != nil {
+		return nil, fmt.Errorf("when querying for unannounced registries: %w", err)
+	}
+	defer func() {
+		_ = rows.Close()
+	}()
+
+	return d.scanRegistryRows(rows)
+}
+
+// RecordRegistryAnnouncement records the outcome of announcing this registry to the one at registryURL.
+// A nil announceErr means it succeeded.
+func (d *DB) RecordRegistryAnnouncement(ctx context.Context, registryURL string, announceErr error) error {
+	now := time.Now().UnixNano()
+	announced := int64(0)
+	errMsg := ""
+	if announceErr == nil {
+		announced = now
+	} else {
+		errMsg = announceErr.Error()
+	}
+
+	stmt := "UPDATE registries SET dt_announced = ?, dt_announce_attempt = ?, announce_error = ? WHERE url = ?"
+	res, err := d.execWrite(ctx, stmt, announced, now, errMsg, registryURL)
+	if err != nil {
+		return fmt.Errorf("when recording announcement to %s: %w", registryURL, err)
+	}
+	updated, err := res.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("when recording announcement to %s: %w", registryURL, err)
+	}
+	if updated == 0 {
+		return fmt.Errorf("registry %s isn't known: %w", registryURL, sql.ErrNoRows)
+	}
+	d.invalidate()
+
+	return nil
+}
+
+func (d *DB) scanRegistryRows(rows *sql.Rows) ([]KnownRegistry, error) {
+	registries := make([]KnownRegistry, 0)
+	for rows.Next() {
+		reg := KnownRegistry{}
+		discovered := int64(0)
+		lastSeen := int64(0)
+		announced := int64(0)
+		if err := rows.Scan(&reg.URL, &reg.DiscoveredVia, &discovered, &lastSeen, &announced, &reg.AnnounceError); err != nil {
+			d.logger.Debugf("when scanning registry: %s", err)
+			continue
+		}
+		reg.Discovered = time.Unix(0, discovered).UTC()
+		reg.LastSeen = time.Unix(0, lastSeen).UTC()
+		if announced > 0 {
+			reg.Announced = time.Unix(0, announced).UTC()
+		}
+		registries = append(registries, reg)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("when reading registries: %w", err)
+	}
+
+	return registries, nil
+}
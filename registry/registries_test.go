@@ -0,0 +1,95 @@
+package registry
+
+/*
+Copyright 2021 G. Benjamin Morrison
+
+This file is part of getwtxt-ng.
+
+getwtxt-ng is free software: you can redistribute it and/or modify
+it under the terms of the GNU Affero General Public License as
+published by the Free Software Foundation, either version 3 of the
+License, or (at your option) any later version.
+
+getwtxt-ng is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU Affero General Public License for more details.
+
+You should have received a copy of the GNU Affero General Public License
+along with getwtxt-ng.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestDB_KnownRegistries(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain")
+		_, _ = fmt.Fprintf(w, "# registries = https://registry.example https://registry.example.org/\n%s\thallo\n",
+			time.Now().UTC().Format(time.RFC3339))
+	}))
+	defer srv.Close()
+	db := getPopulatedDB(t)
+	defer func() {
+		_ = db.Close()
+	}()
+	db.Client = srv.Client()
+	ctx := context.Background()
+
+	users := []User{{ID: populatedDBUsers[0].ID, URL: srv.URL + "/twtxt.txt"}}
+	for i := 0; i < 2; i++ {
+		if _, err := db.SyncUsers(ctx, users); err != nil {
+			t.Fatal(err.Error())
+		}
+	}
+
+	known, err := db.GetKnownRegistries(ctx)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	if len(known) != 2 {
+		t.Fatalf("Expected 2 known registries, got: %+v", known)
+	}
+	for _, reg := range known {
+		if reg.DiscoveredVia != populatedDBUsers[0].URL || reg.Discovered.IsZero() || reg.LastSeen.Before(reg.Discovered) {
+			t.Errorf("Unexpected registry: %+v", reg)
+		}
+	}
+	if plain := FormatRegistriesPlain(known); strings.Count(plain, "\n") != 2 {
+		t.Errorf("Expected 2 lines, got: %q", plain)
+	}
+
+	if err := db.RecordRegistryAnnouncement(ctx, "https://registry.example", nil); err != nil {
+		t.Fatal(err.Error())
+	}
+	if err := db.RecordRegistryAnnouncement(ctx, "https://registry.example.org", errors.New("nope")); err != nil {
+		t.Fatal(err.Error())
+	}
+	unannounced, err := db.GetUnannouncedRegistries(ctx, time.Hour)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	if len(unannounced) != 0 {
+		t.Errorf("Expected no registries to announce to within the retry delay, got: %+v", unannounced)
+	}
+	unannounced, err = db.GetUnannouncedRegistries(ctx, -time.Hour)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	if len(unannounced) != 1 || unannounced[0].URL != "https://registry.example.org" || unannounced[0].AnnounceError != "nope" {
+		t.Errorf("Expected the failed registry to be retried, got: %+v", unannounced)
+	}
+
+	if err := db.RecordRegistryAnnouncement(ctx, "https://unknown.example", nil); !errors.Is(err, sql.ErrNoRows) {
+		t.Errorf("Expected sql.ErrNoRows for an unknown registry, got: %v", err)
+	}
+}
@@ -0,0 +1,159 @@
+package registry
+
+/*
+Copyright 2021 G. Benjamin Morrison
+
+This file is part of getwtxt-ng.
+
+getwtxt-ng is free software: you can redistribute it and/or modify
+it under the terms of the GNU Affero General Public License as
+published by the Free Software Foundation, either version 3 of the
+License, or (at your option) any later version.
+
+getwtxt-ng is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU Affero General Public License for more details.
+
+You should have received a copy of the GNU Affero General Public License
+along with getwtxt-ng.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+import (
+	"context"
+	"fmt"
+	"time"
+)
+
+// RetentionPolicy bounds how many tweets the registry keeps. A zero value leaves that limit off.
+type RetentionPolicy struct {
+	// MaxTweetAge is how long after they were posted tweets are kept.
+	MaxTweetAge time.Duration
+	// MaxTweetsPerUser is how many of each user's newest tweets are kept.
+	MaxTweetsPerUser int
+	// PurgeHiddenAfter is how long tweets are kept once hidden.
+	PurgeHiddenAfter time.Duration
+}
+
+// Enabled reports whether any of the policy's limits are set.
+func (p RetentionPolicy) Enabled() bool {
+	return p.MaxTweetAge > 0 || p.MaxTweetsPerUser > 0 || p.PurgeHiddenAfter > 0
+}
+
+// RetentionResult is how many tweets each limit of a RetentionPolicy removed, or would remove.
+type RetentionResult struct {
+	Aged   int64 `json:"aged"`
+	Excess int64 `json:"excess"`
+	Hidden int64 `json:"hidden"`
+}
+
+// Total is the number of tweets removed under every limit.
+func (r RetentionResult) Total() int64 {
+	return r.Aged + r.Excess + r.Hidden
+}
+
+const (
+	retentionAgedStmt   = "SELECT id FROM tweets WHERE dt < ?"
+	retentionHiddenStmt = "SELECT id FROM tweets WHERE hidden_at < ?"
+	// Each user's tweets past their newest n.
+	retentionExcessStmt = `SELECT id FROM (
+				SELECT id, ROW_NUMBER() OVER (PARTITION BY user_id ORDER BY dt DESC, id DESC) AS n FROM tweets
+			) WHERE n > ?`
+)
+
+// CountRetention returns how many tweets EnforceRetention would remove as of now, without removing any.
+// A tweet past more than one limit is counted under each.
+func (d *DB) CountRetention(ctx context.Context, policy RetentionPolicy, now time.Time) (RetentionResult, error) {
+	ctx, cancel := d.withQueryTimeout(ctx)
+	defer cancel()
+
+	out := RetentionResult{}
+	count := func(what, stmt string, arg int64, into *int64) error {
+		if err := d.conn.QueryRowContext(ctx, "SELECT count(*) FROM ("+stmt+")", arg).Scan(into); err != nil {
+			return fmt.Errorf("when counting %s tweets: %w", what, err)
+		}
+		return nil
+	}
+	if policy.MaxTweetAge > 0 {
+		if err := count("aged", retentionAgedStmt, now.Add(-policy.MaxTweetAge).UnixNano(), &out.Aged); err != nil {
+			return out, err
+		}
+	}
+	if policy.PurgeHiddenAfter > 0 {
+		if err := count("hidden", retentionHiddenStmt, now.Add(-policy.PurgeHiddenAfter).UnixNano(), &out.Hidden); err != nil {
+			return out, err
+		}
+	}
+	if policy.MaxTweetsPerUser > 0 {
+		if err := count("excess", retentionExcessStmt, int64(policy.MaxTweetsPerUser), &out.Excess); err != nil {
+			return out, err
+		}
+	}
+
+	return out, nil
+}
+
+// EnforceRetention removes the tweets past each limit of the policy as of now: aged tweets first,
+// then those hidden too long, then whatever each user has beyond their newest MaxTweetsPerUser.
+// Tweets are removed batchSize per transaction. Returns how many were removed under each limit,
+// including those removed before an error.
+func (d *DB) EnforceRetention(ctx context.Context, policy RetentionPolicy, now time.Time, batchSize int) (RetentionResult, error) {
+	out := RetentionResult{}
+	var err error
+	if policy.MaxTweetAge > 0 {
+		out.Aged, err = d.DeleteTweetsOlderThan(ctx, now.Add(-policy.MaxTweetAge), batchSize)
+		if err != nil {
+			return out, err
+		}
+	}
+	if policy.PurgeHiddenAfter > 0 {
+		out.Hidden, err = d.deleteTweetsInBatches(ctx, "hidden", retentionHiddenStmt, now.Add(-policy.PurgeHiddenAfter).UnixNano(), batchSize)
+		if err != nil {
+			return out, err
+		}
+	}
+	if policy.MaxTweetsPerUser > 0 {
+		out.Excess, err = d.deleteTweetsInBatches(ctx, "excess", retentionExcessStmt, int64(policy.MaxTweetsPerUser), batchSize)
+		if err != nil {
+			return out, err
+		}
+	}
+
+	return out, nil
+}
+
+// deleteTweetsInBatches removes the tweets whose IDs selectStmt returns for arg, batchSize per transaction,
+// until none are left. Returns the number deleted.
+func (d *DB) deleteTweetsInBatches(ctx context.Context, what, selectStmt string, arg int64, batchSize int) (int64, error) {
+	if batchSize < 1 {
+		batchSize = 500
+	}
+
+	deleteStmt := "DELETE FROM tweets WHERE id IN (" + selectStmt + " LIMIT ?)"
+	deleted := int64(0)
+	for {
+		tx, err := d.beginWrite(ctx)
+		if err != nil {
+			return deleted, fmt.Errorf("when beginning tx to delete %s tweets: %w", what, err)
+		}
+		res, err := tx.ExecContext(ctx, deleteStmt, arg, batchSize)
+		if err != nil {
+			_ = tx.Rollback()
+			return deleted, fmt.Errorf("when deleting %s tweets: %w", what, err)
+		}
+		n, err := res.RowsAffected()
+		if err != nil {
+			_ = tx.Rollback()
+			return deleted, fmt.Errorf("when deleting %s tweets: %w", what, err)
+		}
+		if err := tx.Commit(); err != nil {
+			return deleted, fmt.Errorf("when committing tx to delete %s tweets: %w", what, err)
+		}
+		d.invalidate()
+		deleted += n
+		d.Hooks.tweetsDeleted(ctx, n)
+		if n < int64(batchSize) {
+			return deleted, nil
+		}
+	}
+}
@@ -0,0 +1,97 @@
+package registry
+
+/*
+Copyright 2021 G. Benjamin Morrison
+
+This file is part of getwtxt-ng.
+
+getwtxt-ng is free software: you can redistribute it and/or modify
+it under the terms of the GNU Affero General Public License as
+published by the Free Software Foundation, either version 3 of the
+License, or (at your option) any later version.
+
+getwtxt-ng is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU Affero General Public License for more details.
+
+You should have received a copy of the GNU Affero General Public License
+along with getwtxt-ng.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestDB_Retention(t *testing.T) {
+	ctx := context.Background()
+	memDB := getPopulatedDB(t)
+	if _, err := memDB.conn.ExecContext(ctx, "DELETE FROM tweets"); err != nil {
+		t.Fatal(err.Error())
+	}
+
+	now := time.Now().UTC().Truncate(time.Second)
+	_, err := memDB.InsertTweets(ctx, []Tweet{
+		{UserID: "1", DateTime: now.AddDate(-2, 0, 0), Body: "ancient"},
+		{UserID: "1", DateTime: now.Add(-3 * time.Hour), Body: "third newest"},
+		{UserID: "1", DateTime: now.Add(-2 * time.Hour), Body: "second newest"},
+		{UserID: "1", DateTime: now.Add(-time.Hour), Body: "newest"},
+		{UserID: "2", DateTime: now.Add(-time.Hour), Body: "hidden"},
+		{UserID: "2", DateTime: now, Body: "kept"},
+	})
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	if err := memDB.ToggleTweetHiddenStatus(ctx, "2", now.Add(-time.Hour), StatusHidden); err != nil {
+		t.Fatal(err.Error())
+	}
+
+	policy := RetentionPolicy{MaxTweetAge: 365 * 24 * time.Hour, MaxTweetsPerUser: 2, PurgeHiddenAfter: 24 * time.Hour}
+	if !policy.Enabled() || (RetentionPolicy{}).Enabled() {
+		t.Error("Expected only a policy with limits to be enabled")
+	}
+
+	// The hidden tweet was only just hidden, so it's kept until a day has passed.
+	counted, err := memDB.CountRetention(ctx, policy, now)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	if counted.Aged != 1 || counted.Hidden != 0 {
+		t.Errorf("Expected 1 aged and no hidden tweets counted, got %+v", counted)
+	}
+
+	later := now.Add(25 * time.Hour)
+	removed, err := memDB.EnforceRetention(ctx, policy, later, 1)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	if removed != (RetentionResult{Aged: 1, Hidden: 1, Excess: 1}) {
+		t.Errorf("Expected one tweet removed under each limit, got %+v", removed)
+	}
+
+	left := 0
+	if err := memDB.conn.QueryRowContext(ctx, "SELECT count(*) FROM tweets").Scan(&left); err != nil {
+		t.Fatal(err.Error())
+	}
+	if left != 3 {
+		t.Errorf("Expected 3 tweets left, got %d", left)
+	}
+
+	t.Run("unhidden", func(t *testing.T) {
+		hiddenAt := 0
+		if err := memDB.ToggleTweetHiddenStatus(ctx, "2", now, StatusHidden); err != nil {
+			t.Fatal(err.Error())
+		}
+		if err := memDB.ToggleTweetHiddenStatus(ctx, "2", now, StatusVisible); err != nil {
+			t.Fatal(err.Error())
+		}
+		if err := memDB.conn.QueryRowContext(ctx, "SELECT count(*) FROM tweets WHERE hidden_at IS NOT NULL").Scan(&hiddenAt); err != nil {
+			t.Fatal(err.Error())
+		}
+		if hiddenAt != 0 {
+			t.Errorf("Expected no hidden times left after unhiding, got %d", hiddenAt)
+		}
+	})
+}
@@ -0,0 +1,139 @@
+package registry
+
+/*
+Copyright 2021 G. Benjamin Morrison
+
+This file is part of getwtxt-ng.
+
+getwtxt-ng is free software: you can redistribute it and/or modify
+it under the terms of the GNU Affero General Public License as
+published by the Free Software Foundation, either version 3 of the
+License, or (at your option) any later version.
+
+getwtxt-ng is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU Affero General Public License for more details.
+
+You should have received a copy of the GNU Affero General Public License
+along with getwtxt-ng.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// SchemePolicy decides what happens to feeds registered with a plain http:// URL.
+type SchemePolicy string
+
+const (
+	// SchemeAny registers feeds with whatever URL they're submitted with.
+	SchemeAny SchemePolicy = "any"
+
+	// SchemeUpgrade registers a feed submitted with an http:// URL under its https:// URL instead,
+	// as long as the https:// URL serves the same file.
+	SchemeUpgrade SchemePolicy = "upgrade"
+
+	// SchemeHTTPSOnly refuses feeds submitted with an http:// URL.
+	SchemeHTTPSOnly SchemePolicy = "https-only"
+)
+
+// ErrInvalidSchemePolicy is returned when a scheme policy other than the known ones is provided.
+var ErrInvalidSchemePolicy = errors.New("invalid scheme policy")
+
+// ErrInsecureFeedURL is returned when registering an http:// feed under SchemeHTTPSOnly.
+var ErrInsecureFeedURL = errors.New("feed URL must use https")
+
+// schemeProbeMaxBytes is the most of each copy of a feed read when comparing them.
+// Feeds larger than this are compared by their beginning only.
+const schemeProbeMaxBytes = 8 << 20
+
+// ParseSchemePolicy converts the provided string into a SchemePolicy. An empty string is SchemeAny.
+func ParseSchemePolicy(policy string) (SchemePolicy, error) {
+	p := SchemePolicy(strings.ToLower(strings.TrimSpace(policy)))
+	switch p {
+	case "":
+		return SchemeAny, nil
+	case SchemeAny, SchemeUpgrade, SchemeHTTPSOnly:
+		return p, nil
+	default:
+		return "", fmt.Errorf("%w: %s", ErrInvalidSchemePolicy, policy)
+	}
+}
+
+// ApplySchemePolicy returns the URL a feed submitted with feedURL should be registered under.
+// URLs that aren't http:// are returned as they are. Under SchemeUpgrade, the https:// form of the URL is
+// returned if it serves the same file, and feedURL is returned otherwise. Under SchemeHTTPSOnly,
+// http:// URLs are refused with ErrInsecureFeedURL.
+func (d *DB) ApplySchemePolicy(ctx context.Context, policy SchemePolicy, feedURL string) (string, error) {
+	parsed, err := url.Parse(feedURL)
+	if err != nil || !strings.EqualFold(parsed.Scheme, "http") {
+		return feedURL, nil
+	}
+
+	switch policy {
+	case SchemeHTTPSOnly:
+		return "", fmt.Errorf("%w: %s", ErrInsecureFeedURL, feedURL)
+	case SchemeUpgrade:
+		parsed.Scheme = "https"
+		httpsURL := parsed.String()
+		same, err := d.sameFeed(ctx, feedURL, httpsURL)
+		if err != nil {
+			d.logger.Debugf("Not upgrading %s to https: %s", feedURL, err)
+			return feedURL, nil
+		}
+		if !same {
+			d.logger.Debugf("Not upgrading %s to https: a different file is served", feedURL)
+			return feedURL, nil
+		}
+		return httpsURL, nil
+	default:
+		return feedURL, nil
+	}
+}
+
+// sameFeed reports whether both URLs serve the same file.
+// The https:// copy is fetched first, so one that fails doesn't cost a request to the other.
+func (d *DB) sameFeed(ctx context.Context, httpURL, httpsURL string) (bool, error) {
+	secure, err := d.fetchForProbe(ctx, httpsURL)
+	if err != nil {
+		return false, err
+	}
+	plain, err := d.fetchForProbe(ctx, httpURL)
+	if err != nil {
+		return false, err
+	}
+
+	return bytes.Equal(secure, plain), nil
+}
+
+func (d *DB) fetchForProbe(ctx context.Context, feedURL string) ([]byte, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, feedURL, nil)
+	if err != nil {
+		return nil, fmt.Errorf("error creating http request to %s: %w", feedURL, err)
+	}
+	resp, err := d.Client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("error making http request to %s: %w", feedURL, err)
+	}
+	defer func() {
+		_ = resp.Body.Close()
+	}()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("got status code %d from %s", resp.StatusCode, feedURL)
+	}
+
+	body, err := io.ReadAll(io.LimitReader(resp.Body, schemeProbeMaxBytes))
+	if err != nil {
+		return nil, fmt.Errorf("error reading response from %s: %w", feedURL, err)
+	}
+
+	return body, nil
+}
@@ -0,0 +1,104 @@
+package registry
+
+/*
+Copyright 2021 G. Benjamin Morrison
+
+This file is part of getwtxt-ng.
+
+getwtxt-ng is free software: you can redistribute it and/or modify
+it under the terms of the GNU Affero General Public License as
+published by the Free Software Foundation, either version 3 of the
+License, or (at your option) any later version.
+
+getwtxt-ng is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU Affero General Public License for more details.
+
+You should have received a copy of the GNU Affero General Public License
+along with getwtxt-ng.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/gbmor/getwtxt-ng/common"
+)
+
+// schemeTransport answers requests with the body for their scheme, or a 404 if there isn't one.
+type schemeTransport map[string]string
+
+func (st schemeTransport) RoundTrip(r *http.Request) (*http.Response, error) {
+	body, ok := st[r.URL.Scheme]
+	status := http.StatusOK
+	if !ok {
+		status = http.StatusNotFound
+	}
+	return &http.Response{
+		StatusCode: status,
+		Body:       io.NopCloser(strings.NewReader(body)),
+		Header:     make(http.Header),
+		Request:    r,
+	}, nil
+}
+
+func TestParseSchemePolicy(t *testing.T) {
+	for in, want := range map[string]SchemePolicy{"": SchemeAny, "any": SchemeAny, " Upgrade ": SchemeUpgrade, "https-only": SchemeHTTPSOnly} {
+		got, err := ParseSchemePolicy(in)
+		if err != nil {
+			t.Errorf("Unexpected error parsing %q: %s", in, err)
+		}
+		if got != want {
+			t.Errorf("Expected %q to be %s, got %s", in, want, got)
+		}
+	}
+	if _, err := ParseSchemePolicy("tls"); !errors.Is(err, ErrInvalidSchemePolicy) {
+		t.Errorf("Expected ErrInvalidSchemePolicy, got: %v", err)
+	}
+}
+
+func TestDB_ApplySchemePolicy(t *testing.T) {
+	ctx := context.Background()
+	feed := "2021-01-01T00:00:00Z\thello\n"
+	httpURL := "http://example.com/twtxt.txt"
+	httpsURL := "https://example.com/twtxt.txt"
+
+	cases := []struct {
+		name      string
+		policy    SchemePolicy
+		transport schemeTransport
+		in        string
+		want      string
+		wantErr   error
+	}{
+		{name: "any keeps http", policy: SchemeAny, in: httpURL, want: httpURL},
+		{name: "https untouched", policy: SchemeHTTPSOnly, in: httpsURL, want: httpsURL},
+		{name: "https only refuses http", policy: SchemeHTTPSOnly, in: httpURL, wantErr: ErrInsecureFeedURL},
+		{name: "upgrade when the same file is served", policy: SchemeUpgrade, transport: schemeTransport{"http": feed, "https": feed}, in: httpURL, want: httpsURL},
+		{name: "no upgrade when a different file is served", policy: SchemeUpgrade, transport: schemeTransport{"http": feed, "https": "<html></html>"}, in: httpURL, want: httpURL},
+		{name: "no upgrade without https", policy: SchemeUpgrade, transport: schemeTransport{"http": feed}, in: httpURL, want: httpURL},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			db := DB{Client: &http.Client{Transport: tc.transport}, logger: common.NopLogger{}}
+			got, err := db.ApplySchemePolicy(ctx, tc.policy, tc.in)
+			if tc.wantErr != nil {
+				if !errors.Is(err, tc.wantErr) {
+					t.Errorf("Expected %s, got: %v", tc.wantErr, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err.Error())
+			}
+			if got != tc.want {
+				t.Errorf("Expected %s, got %s", tc.want, got)
+			}
+		})
+	}
+}
@@ -0,0 +1,407 @@
+package registry
+
+/*
+Copyright 2021 G. Benjamin Morrison
+
+This file is part of getwtxt-ng.
+
+getwtxt-ng is free software: you can redistribute it and/or modify
+it under the terms of the GNU Affero General Public License as
+published by the Free Software Foundation, either version 3 of the
+License, or (at your option) any later version.
+
+getwtxt-ng is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU Affero General Public License for more details.
+
+You should have received a copy of the GNU Affero General Public License
+along with getwtxt-ng.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/url"
+	"sort"
+	"strings"
+	"time"
+)
+
+const nanosPerDay = int64(24 * time.Hour)
+
+// DayCount is the number of items falling on a single UTC day.
+type DayCount struct {
+	Day   time.Time `json:"day"`
+	Count int       `json:"count"`
+}
+
+// DomainCount is the number of users whose feeds are hosted on a single domain.
+type DomainCount struct {
+	Domain string `json:"domain"`
+	Count  int    `json:"count"`
+}
+
+// DailyStats is a snapshot of the registry taken once a UTC day has ended. Users and Tweets are the totals
+// stored by the end of the day, ActiveFeeds the number of feeds that posted during it, and FetchErrors
+// the number of active feeds whose latest fetch had failed when the snapshot was taken.
+type DailyStats struct {
+	Day         time.Time `json:"day"`
+	Users       int       `json:"users"`
+	Tweets      int       `json:"tweets"`
+	ActiveFeeds int       `json:"active_feeds"`
+	FetchErrors int       `json:"fetch_errors"`
+}
+
+// GetTweetCountsByDay returns the number of tweets posted on each of the last n UTC days, oldest first.
+// Days without any tweets are included with a count of zero.
+func (d *DB) GetTweetCountsByDay(ctx context.Context, days int) ([]DayCount, error) {
+	ctx, cancel := d.withQueryTimeout(ctx)
+	defer cancel()
+
+	if days < 1 {
+		days = 1
+	}
+	today := time.Now().UTC().Truncate(24 * time.Hour)
+	first := today.AddDate(0, 0, -(days - 1))
+
+	stmt := `SELECT dt / ? AS day, count(*) FROM tweets WHERE dt >= ? GROUP BY day`
+	rows, err := d.conn.QueryContext(ctx, stmt, nanosPerDay, first.UnixNano())
+	if err != nil {
+		return nil, fmt.Errorf("when querying for tweet counts over the last %d days: %w", days, err)
+	}
+	defer func() {
+		_ = rows.Close()
+	}()
+
+	counts := make(map[int64]int)
+	for rows.Next() {
+		day := int64(0)
+		count := 0
+		if err := rows.Scan(&day, &count); err != nil {
+			d.logger.Debugf("when querying for tweet counts over the last %d days: %s", days, err)
+			continue
+		}
+		counts[day] = count
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("when querying for tweet counts over the last %d days: %w", days, err)
+	}
+
+	out := make([]DayCount, 0, days)
+	for i := 0; i < days; i++ {
+		day := first.AddDate(0, 0, i)
+		out = append(out, DayCount{
+			Day:   day,
+			Count: counts[day.UnixNano()/nanosPerDay],
+		})
+	}
+
+	return out, nil
+}
+
+// RegistrationPeriod is the length of time registrations are counted over.
+type RegistrationPeriod string
+
+const (
+	PeriodDay  RegistrationPeriod = "day"
+	PeriodWeek RegistrationPeriod = "week"
+)
+
+// ErrInvalidRegistrationPeriod is returned when a period other than a day or a week is asked for.
+var ErrInvalidRegistrationPeriod = errors.New("invalid registration period")
+
+// ParseRegistrationPeriod reads a period from its name. An empty name is a day.
+func ParseRegistrationPeriod(s string) (RegistrationPeriod, error) {
+	switch RegistrationPeriod(strings.ToLower(strings.TrimSpace(s))) {
+	case "", PeriodDay:
+		return PeriodDay, nil
+	case PeriodWeek:
+		return PeriodWeek, nil
+	default:
+		return "", fmt.Errorf("%w: %s", ErrInvalidRegistrationPeriod, s)
+	}
+}
+
+// GetRegistrationCounts returns the number of users registered in each of the last n periods, oldest first,
+// by the time they were added. Weeks start on Monday, UTC. Periods without any registrations are included
+// with a count of zero, and Day is the start of each period.
+func (d *DB) GetRegistrationCounts(ctx context.Context, period RegistrationPeriod, n int) ([]DayCount, error) {
+	ctx, cancel := d.withQueryTimeout(ctx)
+	defer cancel()
+
+	if n < 1 {
+		n = 1
+	}
+	step := 1
+	current := time.Now().UTC().Truncate(24 * time.Hour)
+	if period == PeriodWeek {
+		step = 7
+		current = current.AddDate(0, 0, -((int(current.Weekday()) + 6) % 7))
+	}
+	first := current.AddDate(0, 0, -(n-1)*step)
+
+	rows, err := d.conn.QueryContext(ctx, "SELECT dt_added FROM users WHERE dt_added >= ?", first.UnixNano())
+	if err != nil {
+		return nil, fmt.Errorf("when querying for registrations over the last %d %ss: %w", n, period, err)
+	}
+	defer func() {
+		_ = rows.Close()
+	}()
+
+	counts := make([]int, n)
+	for rows.Next() {
+		dt := int64(0)
+		if err := rows.Scan(&dt); err != nil {
+			d.logger.Debugf("when querying for registrations over the last %d %ss: %s", n, period, err)
+			continue
+		}
+		i := int((dt - first.UnixNano()) / (nanosPerDay * int64(step)))
+		if i >= 0 && i < n {
+			counts[i]++
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("when querying for registrations over the last %d %ss: %w", n, period, err)
+	}
+
+	out := make([]DayCount, 0, n)
+	for i, count := range counts {
+		out = append(out, DayCount{
+			Day:   first.AddDate(0, 0, i*step),
+			Count: count,
+		})
+	}
+
+	return out, nil
+}
+
+// GetTopDomains returns the domains hosting the most feeds, in descending order by number of feeds.
+func (d *DB) GetTopDomains(ctx context.Context, limit int) ([]DomainCount, error) {
+	ctx, cancel := d.withQueryTimeout(ctx)
+	defer cancel()
+
+	rows, err := d.conn.QueryContext(ctx, "SELECT url FROM users")
+	if err != nil {
+		return nil, fmt.Errorf("when querying for user URLs: %w", err)
+	}
+	defer func() {
+		_ = rows.Close()
+	}()
+
+	counts := make(map[string]int)
+	for rows.Next() {
+		userURL := ""
+		if err := rows.Scan(&userURL); err != nil {
+			d.logger.Debugf("when querying for user URLs: %s", err)
+			continue
+		}
+		parsedURL, err := url.Parse(userURL)
+		if err != nil {
+			continue
+		}
+		counts[strings.ToLower(strings.TrimPrefix(parsedURL.Hostname(), "www."))]++
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("when querying for user URLs: %w", err)
+	}
+
+	out := make([]DomainCount, 0, len(counts))
+	for domain, count := range counts {
+		out = append(out, DomainCount{Domain: domain, Count: count})
+	}
+	sort.Slice(out, func(i, j int) bool {
+		if out[i].Count == out[j].Count {
+			return out[i].Domain < out[j].Domain
+		}
+		return out[i].Count > out[j].Count
+	})
+	if limit > 0 && len(out) > limit {
+		out = out[:limit]
+	}
+
+	return out, nil
+}
+
+// StaleUser is a user that hasn't been synced lately, along with how the last attempt to fetch its feed went.
+type StaleUser struct {
+	User
+	LastFetch FetchStatus
+}
+
+// GetStaleUsers returns the users that haven't been successfully synced since the provided time, least recent first.
+func (d *DB) GetStaleUsers(ctx context.Context, since time.Time) ([]StaleUser, error) {
+	ctx, cancel := d.withQueryTimeout(ctx)
+	defer cancel()
+
+	stmt := `SELECT id, url, nick, dt_added, last_sync, last_fetch_status, last_fetch_error, last_fetch, last_fetch_success
+		FROM users WHERE last_sync < ? ORDER BY last_sync ASC`
+	rows, err := d.conn.QueryContext(ctx, stmt, since.UnixNano())
+	if err != nil {
+		return nil, fmt.Errorf("when querying for users not synced since %s: %w", since, err)
+	}
+	defer func() {
+		_ = rows.Close()
+	}()
+
+	users := make([]StaleUser, 0)
+	for rows.Next() {
+		dt := int64(0)
+		ls := int64(0)
+		attempt := int64(0)
+		success := int64(0)
+		thisUser := StaleUser{}
+		err := rows.Scan(&thisUser.ID, &thisUser.URL, &thisUser.Nick, &dt, &ls,
+			&thisUser.LastFetch.StatusCode, &thisUser.LastFetch.Error, &attempt, &success)
+		if err != nil {
+			d.logger.Debugf("when querying for users not synced since %s: %s", since, err)
+			continue
+		}
+		thisUser.DateTimeAdded = time.Unix(0, dt)
+		thisUser.LastSync = time.Unix(0, ls)
+		thisUser.LastFetch.URL = thisUser.URL
+		if attempt > 0 {
+			thisUser.LastFetch.LastAttempt = time.Unix(0, attempt).UTC()
+		}
+		if success > 0 {
+			thisUser.LastFetch.LastSuccess = time.Unix(0, success).UTC()
+		}
+		users = append(users, thisUser)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("when reading users not synced since %s: %w", since, err)
+	}
+
+	return users, nil
+}
+
+// FormatDailyStatsPlain formats snapshots as tab-separated lines of the day, users, tweets, active feeds, and fetch errors.
+func FormatDailyStatsPlain(stats []DailyStats) string {
+	builder := strings.Builder{}
+	builder.Grow(len(stats) * 48)
+	for _, ds := range stats {
+		builder.WriteString(fmt.Sprintf("%s\t%d\t%d\t%d\t%d\n", ds.Day.Format("2006-01-02"), ds.Users, ds.Tweets, ds.ActiveFeeds, ds.FetchErrors))
+	}
+
+	return builder.String()
+}
+
+// FormatDayCountsPlain formats counts as tab-separated lines of the day and the count.
+func FormatDayCountsPlain(counts []DayCount) string {
+	builder := strings.Builder{}
+	builder.Grow(len(counts) * 24)
+	for _, dc := range counts {
+		builder.WriteString(fmt.Sprintf("%s\t%d\n", dc.Day.Format("2006-01-02"), dc.Count))
+	}
+
+	return builder.String()
+}
+
+// RecordDailyStats takes the snapshot of the UTC day containing day. Days already recorded are left as they were,
+// so the snapshot is the one taken closest to the day's end. Reports whether a snapshot was stored.
+func (d *DB) RecordDailyStats(ctx context.Context, day time.Time) (bool, error) {
+	start := day.UTC().Truncate(24 * time.Hour)
+	end := start.AddDate(0, 0, 1)
+
+	tx, err := d.beginWrite(ctx)
+	if err != nil {
+		return false, fmt.Errorf("when beginning tx to record stats for %s: %w", start.Format("2006-01-02"), err)
+	}
+	defer func() {
+		_ = tx.Rollback()
+	}()
+
+	stmt := `INSERT OR IGNORE INTO daily_stats (day, users, tweets, active_feeds, fetch_errors, dt_recorded)
+				SELECT ?,
+					(SELECT COUNT(*) FROM users WHERE dt_added < ?),
+					(SELECT COUNT(*) FROM tweets WHERE dt_ingested < ?),
+					(SELECT COUNT(DISTINCT user_id) FROM tweets WHERE dt >= ? AND dt < ?),
+					(SELECT COUNT(*) FROM users WHERE status = 'active' AND last_fetch_error != ''),
+					?`
+	res, err := tx.ExecContext(ctx, stmt, start.UnixNano(), end.UnixNano(), end.UnixNano(), start.UnixNano(), end.UnixNano(), time.Now().UnixNano())
+	if err != nil {
+		return false, fmt.Errorf("when recording stats for %s: %w", start.Format("2006-01-02"), err)
+	}
+	recorded, err := res.RowsAffected()
+	if err != nil {
+		return false, fmt.Errorf("when recording stats for %s: %w", start.Format("2006-01-02"), err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return false, fmt.Errorf("when committing stats for %s: %w", start.Format("2006-01-02"), err)
+	}
+	if recorded > 0 {
+		d.invalidate()
+	}
+
+	return recorded > 0, nil
+}
+
+// GetDailyStats returns the snapshots recorded for the last n UTC days, oldest first.
+// Days without a snapshot are left out.
+func (d *DB) GetDailyStats(ctx context.Context, days int) ([]DailyStats, error) {
+	ctx, cancel := d.withQueryTimeout(ctx)
+	defer cancel()
+
+	if days < 1 {
+		days = 1
+	}
+	first := time.Now().UTC().Truncate(24*time.Hour).AddDate(0, 0, -days)
+
+	stmt := `SELECT day, users, tweets, active_feeds, fetch_errors FROM daily_stats WHERE day >= ? ORDER BY day ASC`
+	rows, err := d.conn.QueryContext(ctx, stmt, first.UnixNano())
+	if err != nil {
+		return nil, fmt.Errorf("when querying for stats over the last %d days: %w", days, err)
+	}
+	defer func() {
+		_ = rows.Close()
+	}()
+
+	out := make([]DailyStats, 0, days)
+	for rows.Next() {
+		day := int64(0)
+		ds := DailyStats{}
+		if err := rows.Scan(&day, &ds.Users, &ds.Tweets, &ds.ActiveFeeds, &ds.FetchErrors); err != nil {
+			d.logger.Debugf("when scanning daily stats: %s", err)
+			continue
+		}
+		ds.Day = time.Unix(0, day).UTC()
+		out = append(out, ds)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("when reading stats over the last %d days: %w", days, err)
+	}
+
+	return out, nil
+}
+
+// DeleteDailyStatsOlderThan removes the snapshots of days before cutoff, returning how many were removed.
+func (d *DB) DeleteDailyStatsOlderThan(ctx context.Context, cutoff time.Time) (int64, error) {
+	tx, err := d.beginWrite(ctx)
+	if err != nil {
+		return 0, fmt.Errorf("when beginning tx to delete stats older than %s: %w", cutoff.Format("2006-01-02"), err)
+	}
+	defer func() {
+		_ = tx.Rollback()
+	}()
+
+	res, err := tx.ExecContext(ctx, "DELETE FROM daily_stats WHERE day < ?", cutoff.UnixNano())
+	if err != nil {
+		return 0, fmt.Errorf("when deleting stats older than %s: %w", cutoff.Format("2006-01-02"), err)
+	}
+	deleted, err := res.RowsAffected()
+	if err != nil {
+		return 0, fmt.Errorf("when deleting stats older than %s: %w", cutoff.Format("2006-01-02"), err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return 0, fmt.Errorf("when committing deletion of stats older than %s: %w", cutoff.Format("2006-01-02"), err)
+	}
+	if deleted > 0 {
+		d.invalidate()
+	}
+
+	return deleted, nil
+}
@@ -0,0 +1,214 @@
+package registry
+
+/*
+Copyright 2021 G. Benjamin Morrison
+
+This file is part of getwtxt-ng.
+
+getwtxt-ng is free software: you can redistribute it and/or modify
+it under the terms of the GNU Affero General Public License as
+published by the Free Software Foundation, either version 3 of the
+License, or (at your option) any later version.
+
+getwtxt-ng is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU Affero General Public License for more details.
+
+You should have received a copy of the GNU Affero General Public License
+along with getwtxt-ng.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestDB_GetTweetCountsByDay(t *testing.T) {
+	memDB := getPopulatedDB(t)
+	ctx := context.Background()
+
+	t.Run("last week", func(t *testing.T) {
+		out, err := memDB.GetTweetCountsByDay(ctx, 7)
+		if err != nil {
+			t.Fatal(err.Error())
+		}
+		if len(out) != 7 {
+			t.Fatalf("Expected 7 days, got %d", len(out))
+		}
+		total := 0
+		for i, day := range out {
+			if i > 0 && !day.Day.After(out[i-1].Day) {
+				t.Error("days out of order")
+			}
+			total += day.Count
+		}
+		// two of the populated tweets were posted within the last week
+		if total != 2 {
+			t.Errorf("Expected 2 tweets in the last week, got %d", total)
+		}
+	})
+
+	t.Run("canceled context", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+		if _, err := memDB.GetTweetCountsByDay(ctx, 7); err == nil {
+			t.Error("expected error, got none")
+		}
+	})
+}
+
+func TestDB_GetTopDomains(t *testing.T) {
+	memDB := getPopulatedDB(t)
+	ctx := context.Background()
+
+	out, err := memDB.GetTopDomains(ctx, 1)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	if len(out) != 1 {
+		t.Fatalf("Expected 1 domain, got %d", len(out))
+	}
+	if out[0].Domain != "example.com" || out[0].Count != 1 {
+		t.Errorf("Got unexpected domain count: %v", out[0])
+	}
+}
+
+func TestDB_GetStaleUsers(t *testing.T) {
+	memDB := getPopulatedDB(t)
+	ctx := context.Background()
+
+	stmt := "UPDATE users SET last_fetch_status = 404, last_fetch_error = 'got status code 404', last_fetch = ? WHERE url = ?"
+	attempt := time.Now().UTC()
+	if _, err := memDB.conn.ExecContext(ctx, stmt, attempt.UnixNano(), "https://example.com/twtxt.txt"); err != nil {
+		t.Fatal(err.Error())
+	}
+
+	out, err := memDB.GetStaleUsers(ctx, time.Now().UTC().AddDate(0, 0, -3))
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	if len(out) != 1 || out[0].URL != "https://example.com/twtxt.txt" {
+		t.Fatalf("Got unexpected stale users: %v", out)
+	}
+	fetch := out[0].LastFetch
+	if fetch.StatusCode != 404 || fetch.Error != "got status code 404" || !fetch.LastAttempt.Equal(attempt) || !fetch.LastSuccess.IsZero() {
+		t.Errorf("Got unexpected last fetch status: %+v", fetch)
+	}
+}
+
+func TestDB_RowCounts(t *testing.T) {
+	db := getPopulatedDB(t)
+	defer func() {
+		_ = db.Close()
+	}()
+	ctx := context.Background()
+
+	check := func(wantUsers, wantTweets uint32) {
+		t.Helper()
+		if err := db.SetUserCount(ctx); err != nil {
+			t.Fatal(err.Error())
+		}
+		if err := db.SetTweetCount(ctx); err != nil {
+			t.Fatal(err.Error())
+		}
+		if db.GetUserCount() != wantUsers || db.GetTweetCount() != wantTweets {
+			t.Errorf("Expected %d users and %d tweets, got %d and %d", wantUsers, wantTweets, db.GetUserCount(), db.GetTweetCount())
+		}
+	}
+	check(uint32(len(populatedDBUsers)), uint32(len(populatedDBTweets)))
+
+	user := User{Nick: "counted", URL: "https://counted.example/twtxt.txt", PasscodeHash: []byte("not a real hash")}
+	tweets := []Tweet{{DateTime: time.Now(), Body: "one"}, {DateTime: time.Now().Add(time.Second), Body: "two"}}
+	if _, err := db.InsertUserWithTweets(ctx, &user, tweets); err != nil {
+		t.Fatal(err.Error())
+	}
+	// Inserting the same tweets again is ignored, and so isn't counted.
+	for i := range tweets {
+		tweets[i].UserID = user.ID
+	}
+	if _, err := db.InsertTweets(ctx, tweets); err != nil {
+		t.Fatal(err.Error())
+	}
+	check(uint32(len(populatedDBUsers)+1), uint32(len(populatedDBTweets)+2))
+
+	if _, err := db.DeleteUser(ctx, &user); err != nil {
+		t.Fatal(err.Error())
+	}
+	check(uint32(len(populatedDBUsers)), uint32(len(populatedDBTweets)))
+}
+
+func TestDB_DailyStats(t *testing.T) {
+	memDB := getPopulatedDB(t)
+	ctx := context.Background()
+	yesterday := time.Now().UTC().AddDate(0, 0, -1)
+
+	recorded, err := memDB.RecordDailyStats(ctx, yesterday)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	if !recorded {
+		t.Error("Expected yesterday's snapshot to be recorded")
+	}
+	if recorded, err := memDB.RecordDailyStats(ctx, yesterday); err != nil || recorded {
+		t.Errorf("Expected yesterday's snapshot to be left as it was, got %v, %v", recorded, err)
+	}
+	if _, err := memDB.RecordDailyStats(ctx, yesterday.AddDate(0, 0, -400)); err != nil {
+		t.Fatal(err.Error())
+	}
+
+	out, err := memDB.GetDailyStats(ctx, 7)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	if len(out) != 1 {
+		t.Fatalf("Expected 1 snapshot in the last week, got %+v", out)
+	}
+	if !out[0].Day.Equal(yesterday.Truncate(24*time.Hour)) || out[0].Users != 2 || out[0].Tweets != len(populatedDBTweets) {
+		t.Errorf("Unexpected snapshot: %+v", out[0])
+	}
+
+	deleted, err := memDB.DeleteDailyStatsOlderThan(ctx, yesterday.AddDate(0, 0, -7))
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	if deleted != 1 {
+		t.Errorf("Expected the old snapshot to be deleted, got %d deleted", deleted)
+	}
+}
+
+func TestDB_GetRegistrationCounts(t *testing.T) {
+	memDB := getPopulatedDB(t)
+	ctx := context.Background()
+
+	before, err := memDB.GetRegistrationCounts(ctx, PeriodWeek, 2)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	u := User{Nick: "new", URL: "https://example.net/twtxt.txt", PasscodeHash: []byte("hash"), DateTimeAdded: time.Now().UTC()}
+	if err := memDB.InsertUser(ctx, &u); err != nil {
+		t.Fatal(err.Error())
+	}
+
+	days, err := memDB.GetRegistrationCounts(ctx, PeriodDay, 7)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	if len(days) != 7 || days[6].Count < 1 || !days[6].Day.Equal(time.Now().UTC().Truncate(24*time.Hour)) {
+		t.Errorf("Expected today's registration last of 7 days, got %+v", days)
+	}
+
+	weeks, err := memDB.GetRegistrationCounts(ctx, PeriodWeek, 2)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	if len(weeks) != 2 || weeks[1].Count != before[1].Count+1 || weeks[1].Day.Weekday() != time.Monday {
+		t.Errorf("Expected this week's registration in a week starting Monday, got %+v", weeks)
+	}
+
+	if _, err := ParseRegistrationPeriod("month"); !errors.Is(err, ErrInvalidRegistrationPeriod) {
+		t.Errorf("Expected ErrInvalidRegistrationPeriod, got %v", err)
+	}
+}
@@ -0,0 +1,91 @@
+package registry
+
+/*
+Copyright 2021 G. Benjamin Morrison
+
+This file is part of getwtxt-ng.
+
+getwtxt-ng is free software: you can redistribute it and/or modify
+it under the terms of the GNU Affero General Public License as
+published by the Free Software Foundation, either version 3 of the
+License, or (at your option) any later version.
+
+getwtxt-ng is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU Affero General Public License for more details.
+
+You should have received a copy of the GNU Affero General Public License
+along with getwtxt-ng.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+import (
+	"context"
+	"fmt"
+	"strings"
+)
+
+// Suggestions are for autocompleting as someone types, so only a handful are returned, for prefixes of a sensible length.
+const (
+	suggestionLimit     = 10
+	suggestionMaxPrefix = 100
+)
+
+// Suggestion is a user whose nickname or feed URL starts with what's been typed so far.
+type Suggestion struct {
+	Nickname string `json:"nickname"`
+	URL      string `json:"url"`
+}
+
+// SuggestUsers returns up to 10 active users whose nickname or feed URL starts with prefix, ignoring case.
+// The URL may be typed with or without its scheme and leading www. Nickname matches come first, shortest first.
+// Results are kept in the read cache regardless of how it's configured to cache pages.
+func (d *DB) SuggestUsers(ctx context.Context, prefix string) ([]Suggestion, error) {
+	ctx, cancel := d.withQueryTimeout(ctx)
+	defer cancel()
+
+	prefix = strings.ToLower(strings.TrimSpace(prefix))
+	if len(prefix) > suggestionMaxPrefix {
+		prefix = prefix[:suggestionMaxPrefix]
+	}
+	if prefix == "" {
+		return []Suggestion{}, nil
+	}
+
+	key := suggestionsCacheKey(prefix)
+	cached, gen, ok := d.cache.get(key)
+	if ok {
+		return append([]Suggestion(nil), cached.([]Suggestion)...), nil
+	}
+
+	// Typed % and _ are matched literally rather than as wildcards.
+	pattern := strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`).Replace(prefix) + "%"
+	stmt := `SELECT nick, url FROM users
+				WHERE status = 'active'
+				AND (nick LIKE ? ESCAPE '\' OR url LIKE ? ESCAPE '\' OR canonical_url LIKE ? ESCAPE '\')
+				ORDER BY nick LIKE ? ESCAPE '\' DESC, length(nick), nick
+				LIMIT ?`
+	rows, err := d.queryPrepared(ctx, stmt, pattern, pattern, strings.TrimPrefix(pattern, "www."), pattern, suggestionLimit)
+	if err != nil {
+		return nil, fmt.Errorf("when querying for users starting with %s: %w", prefix, err)
+	}
+	defer func() {
+		_ = rows.Close()
+	}()
+
+	out := make([]Suggestion, 0, suggestionLimit)
+	for rows.Next() {
+		s := Suggestion{}
+		if err := rows.Scan(&s.Nickname, &s.URL); err != nil {
+			d.logger.Debugf("when scanning users starting with %s: %s", prefix, err)
+			continue
+		}
+		out = append(out, s)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("when reading users starting with %s: %w", prefix, err)
+	}
+	d.cache.put(key, gen, append([]Suggestion(nil), out...))
+
+	return out, nil
+}
@@ -0,0 +1,68 @@
+package registry
+
+/*
+Copyright 2021 G. Benjamin Morrison
+
+This file is part of getwtxt-ng.
+
+getwtxt-ng is free software: you can redistribute it and/or modify
+it under the terms of the GNU Affero General Public License as
+published by the Free Software Foundation, either version 3 of the
+License, or (at your option) any later version.
+
+getwtxt-ng is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU Affero General Public License for more details.
+
+You should have received a copy of the GNU Affero General Public License
+along with getwtxt-ng.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+import (
+	"context"
+	"testing"
+)
+
+func TestDB_SuggestUsers(t *testing.T) {
+	memDB := getPopulatedDB(t)
+	ctx := context.Background()
+	extra := User{Nick: "foo", URL: "https://www.foo.example/twtxt.txt", PasscodeHash: []byte("hash")}
+	if err := memDB.InsertUser(ctx, &extra); err != nil {
+		t.Fatal(err.Error())
+	}
+
+	tests := []struct {
+		prefix string
+		want   []string
+	}{
+		{"FOO", []string{"foo", "foobar"}},
+		{"bar", []string{"barfoo"}},
+		{"https://example.org", []string{"barfoo"}},
+		{"example.", []string{"barfoo", "foobar"}},
+		{"www.foo", []string{"foo"}},
+		{"foo.ex", []string{"foo"}},
+		{"%", nil},
+		{"  ", nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.prefix, func(t *testing.T) {
+			out, err := memDB.SuggestUsers(ctx, tt.prefix)
+			if err != nil {
+				t.Fatal(err.Error())
+			}
+			got := make([]string, 0, len(out))
+			for _, s := range out {
+				got = append(got, s.Nickname)
+			}
+			if len(got) != len(tt.want) {
+				t.Fatalf("Expected %v, got %v", tt.want, got)
+			}
+			for i := range got {
+				if got[i] != tt.want[i] {
+					t.Errorf("Expected %v, got %v", tt.want, got)
+				}
+			}
+		})
+	}
+}
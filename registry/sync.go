@@ -0,0 +1,248 @@
+package registry
+
+/*
+Copyright 2021 G. Benjamin Morrison
+
+This file is part of getwtxt-ng.
+
+getwtxt-ng is free software: you can redistribute it and/or modify
+it under the terms of the GNU Affero General Public License as
+published by the Free Software Foundation, either version 3 of the
+License, or (at your option) any later version.
+
+getwtxt-ng is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU Affero General Public License for more details.
+
+You should have received a copy of the GNU Affero General Public License
+along with getwtxt-ng.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+)
+
+const (
+	// syncBackoffBase is how long a feed is skipped after its first failed fetch. It doubles with each further failure.
+	syncBackoffBase = 5 * time.Minute
+
+	// syncBackoffMax caps how long a failing feed is skipped.
+	syncBackoffMax = 24 * time.Hour
+)
+
+// SyncResult summarizes a pass over users' twtxt files.
+type SyncResult struct {
+	Users       int
+	Updated     int
+	NotModified int
+	// Tweets is the number of new tweets stored. Tweets we already had aren't counted.
+	Tweets int
+	// Failed maps the URL of each feed that couldn't be synced to the reason why.
+	Failed map[string]error
+	// Errors counts the failures by their type, one of the SyncError constants.
+	Errors map[string]int
+}
+
+// FetchStatus describes the outcome of the most recent attempt to sync a user's twtxt file.
+type FetchStatus struct {
+	URL string `json:"url"`
+
+	// StatusCode is the HTTP status of the last response, or zero if no response was received.
+	StatusCode int `json:"status_code"`
+
+	// Error is the reason the last attempt failed, or empty if it succeeded.
+	Error string `json:"error,omitempty"`
+
+	LastAttempt time.Time `json:"last_attempt"`
+	LastSuccess time.Time `json:"last_success"`
+
+	// feed is what to remember of the feed for the next fetch, when this one succeeded.
+	feed feedState
+}
+
+// GetFetchStatus retrieves the outcome of the most recent attempt to sync the user with the provided URL.
+// The times are zero if the user has never been synced. Returns ErrUserNotFound if there's no such user.
+func (d *DB) GetFetchStatus(ctx context.Context, userURL string) (*FetchStatus, error) {
+	ctx, cancel := d.withQueryTimeout(ctx)
+	defer cancel()
+
+	if userURL == "" {
+		return nil, ErrIncompleteUserInfo
+	}
+
+	status := FetchStatus{URL: userURL}
+	attempt := int64(0)
+	success := int64(0)
+	stmt := "SELECT last_fetch_status, last_fetch_error, last_fetch, last_fetch_success FROM users WHERE url = ?"
+	err := d.conn.QueryRowContext(ctx, stmt, userURL).Scan(&status.StatusCode, &status.Error, &attempt, &success)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, fmt.Errorf("when querying for fetch status of user with URL %s: %w", userURL, ErrUserNotFound)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("unable to query for fetch status of user with URL %s: %w", userURL, err)
+	}
+	if attempt > 0 {
+		status.LastAttempt = time.Unix(0, attempt).UTC()
+	}
+	if success > 0 {
+		status.LastSuccess = time.Unix(0, success).UTC()
+	}
+
+	return &status, nil
+}
+
+// SyncUsers fetches the twtxt file for each of the provided users, stores any tweets
+// received, and updates the sync time of the users that were reached.
+// Failures for individual feeds are recorded in the result rather than returned.
+func (d *DB) SyncUsers(ctx context.Context, users []User) (SyncResult, error) {
+	result := SyncResult{
+		Users:  len(users),
+		Failed: make(map[string]error),
+		Errors: make(map[string]int),
+	}
+
+	// Fetch statuses and sync times change even when no tweets do.
+	defer d.invalidate()
+
+	usersSynced := make([]User, 0, len(users))
+	usersFailed := make([]User, 0)
+	statuses := make(map[string]FetchStatus, len(users))
+	metadata := make(map[string]FeedMetadata)
+	for i, e := range users {
+		prev := feedState{hash: e.ContentHash, length: e.ContentLength, tail: e.ContentTailHash, partials: e.PartialFetches}
+		tweets, meta, code, feed, err := d.fetchTwtxtStatus(e.URL, e.ID, e.LastSync, prev)
+		status := FetchStatus{URL: e.URL, StatusCode: code, LastAttempt: time.Now().UTC()}
+		if err != nil {
+			d.logger.Errorf("Couldn't get twtxt file for user %s: %s", e.URL, err)
+			result.Failed[e.URL] = err
+			result.Errors[syncErrorType(err, code)]++
+			usersFailed = append(usersFailed, e)
+			status.Error = err.Error()
+			statuses[e.ID] = status
+			continue
+		}
+		if meta != nil {
+			metadata[e.ID] = *meta
+		}
+		if len(tweets) == 0 {
+			result.NotModified++
+		} else {
+			res, err := d.insertTweets(ctx, tweets, e.Status != UserStatusPendingApproval)
+			if err != nil {
+				d.logger.Errorf("couldn't insert tweets for user %s during sync: %s", e.URL, err)
+				result.Failed[e.URL] = err
+				result.Errors[SyncErrorStore]++
+				status.Error = err.Error()
+				statuses[e.ID] = status
+				continue
+			}
+			result.Updated++
+			result.Tweets += res.Inserted
+		}
+		users[i].LastSync = time.Now().UTC()
+		usersSynced = append(usersSynced, users[i])
+		status.LastSuccess = users[i].LastSync
+		status.feed = feed
+		statuses[e.ID] = status
+	}
+
+	if err := d.recordFetchStatuses(ctx, statuses); err != nil {
+		return result, err
+	}
+	if err := d.recordFeedMetadata(ctx, metadata); err != nil {
+		return result, err
+	}
+	if len(usersFailed) > 0 {
+		if err := d.backOffUsers(ctx, usersFailed); err != nil {
+			return result, err
+		}
+	}
+	if len(usersSynced) == 0 {
+		return result, nil
+	}
+	if err := d.UpdateUsersSyncTime(ctx, usersSynced); err != nil {
+		return result, fmt.Errorf("couldn't update users sync time: %w", err)
+	}
+
+	return result, nil
+}
+
+// backOffUsers records a failed fetch for each of the provided users and pushes back when they're next due for sync.
+func (d *DB) backOffUsers(ctx context.Context, users []User) error {
+	tx, err := d.beginWrite(ctx)
+	if err != nil {
+		return fmt.Errorf("when beginning tx to back off %d users: %w", len(users), err)
+	}
+	defer func() {
+		_ = tx.Rollback()
+	}()
+
+	// The shift is capped well below where it would overflow; syncBackoffMax applies long before then.
+	backoffStmt := `UPDATE users SET
+						sync_failures = sync_failures + 1,
+						next_sync = ? + min(?, ? << min(sync_failures, 16))
+					WHERE id = ?`
+	now := time.Now().UnixNano()
+	for _, u := range users {
+		if _, err := tx.ExecContext(ctx, backoffStmt, now, int64(syncBackoffMax), int64(syncBackoffBase), u.ID); err != nil {
+			return fmt.Errorf("when backing off user %s: %w", u.URL, err)
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("when committing tx to back off %d users: %w", len(users), err)
+	}
+	d.invalidate()
+
+	return nil
+}
+
+// recordFetchStatuses stores the outcome of each fetch, keyed by user ID.
+// The time of the last success and what's remembered of the feed are left alone for fetches that failed.
+func (d *DB) recordFetchStatuses(ctx context.Context, statuses map[string]FetchStatus) error {
+	if len(statuses) == 0 {
+		return nil
+	}
+
+	tx, err := d.beginWrite(ctx)
+	if err != nil {
+		return fmt.Errorf("when beginning tx to record %d fetch statuses: %w", len(statuses), err)
+	}
+	defer func() {
+		_ = tx.Rollback()
+	}()
+
+	statusStmt := `UPDATE users SET
+						last_fetch_status = ?,
+						last_fetch_error = ?,
+						last_fetch = ?,
+						last_fetch_success = CASE WHEN ? > 0 THEN ? ELSE last_fetch_success END,
+						content_hash = CASE WHEN ? > 0 THEN ? ELSE content_hash END,
+						content_length = CASE WHEN ? > 0 THEN ? ELSE content_length END,
+						content_tail_hash = CASE WHEN ? > 0 THEN ? ELSE content_tail_hash END,
+						partial_fetches = CASE WHEN ? > 0 THEN ? ELSE partial_fetches END
+					WHERE id = ?`
+	for id, status := range statuses {
+		success := int64(0)
+		if !status.LastSuccess.IsZero() {
+			success = status.LastSuccess.UnixNano()
+		}
+		_, err := tx.ExecContext(ctx, statusStmt, status.StatusCode, status.Error, status.LastAttempt.UnixNano(), success, success,
+			success, status.feed.hash, success, status.feed.length, success, status.feed.tail, success, status.feed.partials, id)
+		if err != nil {
+			return fmt.Errorf("when recording fetch status of user %s: %w", status.URL, err)
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("when committing tx to record %d fetch statuses: %w", len(statuses), err)
+	}
+	d.invalidate()
+
+	return nil
+}
@@ -0,0 +1,260 @@
+package registry
+
+/*
+Copyright 2021 G. Benjamin Morrison
+
+This file is part of getwtxt-ng.
+
+getwtxt-ng is free software: you can redistribute it and/or modify
+it under the terms of the GNU Affero General Public License as
+published by the Free Software Foundation, either version 3 of the
+License, or (at your option) any later version.
+
+getwtxt-ng is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU Affero General Public License for more details.
+
+You should have received a copy of the GNU Affero General Public License
+along with getwtxt-ng.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestDB_SyncUsers(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(twtxtTestingHandler))
+	defer srv.Close()
+	db := getPopulatedDB(t)
+	db.Client = srv.Client()
+	ctx := context.Background()
+
+	users := []User{
+		{ID: "1", URL: fmt.Sprintf("%s/twtxt.txt", srv.URL)},
+		{ID: "2", URL: fmt.Sprintf("%s/twtxt/304", srv.URL)},
+		{ID: "3", URL: fmt.Sprintf("%s/twtxt/404", srv.URL)},
+	}
+	before := time.Now().UTC()
+
+	result, err := db.SyncUsers(ctx, users)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	if result.Users != 3 || result.Updated != 1 || result.NotModified != 1 || len(result.Failed) != 1 {
+		t.Errorf("Unexpected sync result: %+v", result)
+	}
+	if result.Tweets != 2 {
+		t.Errorf("Got %d tweets, expected 2", result.Tweets)
+	}
+	if _, ok := result.Failed[users[2].URL]; !ok {
+		t.Errorf("Expected %s to be recorded as failed", users[2].URL)
+	}
+	if users[0].LastSync.Before(before) || users[1].LastSync.Before(before) {
+		t.Errorf("Expected sync times to be updated for reachable feeds")
+	}
+	if !users[2].LastSync.IsZero() {
+		t.Errorf("Expected sync time of failed feed to be untouched, got %s", users[2].LastSync)
+	}
+
+	t.Run("fetch status recorded", func(t *testing.T) {
+		status, err := db.GetFetchStatus(ctx, populatedDBUsers[0].URL)
+		if err != nil {
+			t.Fatal(err.Error())
+		}
+		if status.StatusCode != http.StatusOK || status.Error != "" || status.LastSuccess.Before(before) {
+			t.Errorf("Unexpected fetch status: %+v", status)
+		}
+		if _, err := db.GetFetchStatus(ctx, "https://example.net/twtxt.txt"); !errors.Is(err, ErrUserNotFound) {
+			t.Errorf("Expected ErrUserNotFound for unknown user, got: %v", err)
+		}
+	})
+
+	t.Run("failed feeds back off", func(t *testing.T) {
+		failing := []User{{ID: "2", URL: fmt.Sprintf("%s/twtxt/404", srv.URL)}}
+		for i := 0; i < 2; i++ {
+			if _, err := db.SyncUsers(ctx, failing); err != nil {
+				t.Fatal(err.Error())
+			}
+		}
+		failures := 0
+		nextSync := int64(0)
+		if err := db.conn.QueryRow("SELECT sync_failures, next_sync FROM users WHERE id = 2").Scan(&failures, &nextSync); err != nil {
+			t.Fatal(err.Error())
+		}
+		if failures != 2 {
+			t.Errorf("Expected 2 recorded failures, got %d", failures)
+		}
+		status, err := db.GetFetchStatus(ctx, populatedDBUsers[1].URL)
+		if err != nil {
+			t.Fatal(err.Error())
+		}
+		if status.StatusCode != http.StatusNotFound || status.Error == "" {
+			t.Errorf("Expected the failure to be recorded, got: %+v", status)
+		}
+		if status.LastSuccess.Before(before) {
+			t.Errorf("Expected the earlier success to be kept, got %s", status.LastSuccess)
+		}
+		wait := time.Until(time.Unix(0, nextSync))
+		if wait <= syncBackoffBase || wait > 2*syncBackoffBase {
+			t.Errorf("Expected the backoff to have doubled, got %s", wait)
+		}
+	})
+
+	t.Run("unchanged body skipped", func(t *testing.T) {
+		// This host ignores If-Modified-Since.
+		body := "2022-10-19T00:00:00Z\tsame as it ever was\n"
+		feed := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Content-Type", "text/plain")
+			_, _ = fmt.Fprint(w, body)
+		}))
+		defer feed.Close()
+		syncFeed := func() (SyncResult, string) {
+			t.Helper()
+			users := []User{{ID: "1", URL: feed.URL + "/twtxt.txt"}}
+			if err := db.conn.QueryRow("SELECT content_hash FROM users WHERE id = 1").Scan(&users[0].ContentHash); err != nil {
+				t.Fatal(err.Error())
+			}
+			res, err := db.SyncUsers(ctx, users)
+			if err != nil {
+				t.Fatal(err.Error())
+			}
+			hash := ""
+			if err := db.conn.QueryRow("SELECT content_hash FROM users WHERE id = 1").Scan(&hash); err != nil {
+				t.Fatal(err.Error())
+			}
+			return res, hash
+		}
+
+		res, first := syncFeed()
+		if res.Updated != 1 || res.Tweets != 1 || first == "" {
+			t.Fatalf("Expected the first fetch stored along with its hash, got %+v and %q", res, first)
+		}
+		res, again := syncFeed()
+		if res.Updated != 0 || res.NotModified != 1 || again != first {
+			t.Errorf("Expected the same body to count as not modified, got %+v and %q", res, again)
+		}
+
+		body += "2022-10-20T00:00:00Z\tsomething new\n"
+		res, changed := syncFeed()
+		if res.Updated != 1 || res.Tweets != 1 || changed == first {
+			t.Errorf("Expected the changed body stored with a new hash, got %+v and %q", res, changed)
+		}
+	})
+}
+
+func TestDB_SyncUsers_range(t *testing.T) {
+	db := getPopulatedDB(t)
+	ctx := context.Background()
+
+	// Long enough that the file is bigger than the overlap re-read by range fetches.
+	header := "# nick = foo\n# description = " + strings.Repeat("a", feedTailOverlap) + "\n"
+	body := header + "2022-10-19T00:00:00Z\tfirst\n"
+	ranges := make([]string, 0)
+	feed := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ranges = append(ranges, r.Header.Get("Range"))
+		http.ServeContent(w, r, "twtxt.txt", time.Time{}, strings.NewReader(body))
+	}))
+	defer feed.Close()
+	db.Client = feed.Client()
+
+	syncFeed := func() SyncResult {
+		t.Helper()
+		ranges = ranges[:0]
+		users := []User{{ID: "1", URL: feed.URL + "/twtxt.txt"}}
+		err := db.conn.QueryRow("SELECT content_hash, content_length, content_tail_hash, partial_fetches FROM users WHERE id = 1").
+			Scan(&users[0].ContentHash, &users[0].ContentLength, &users[0].ContentTailHash, &users[0].PartialFetches)
+		if err != nil {
+			t.Fatal(err.Error())
+		}
+		res, err := db.SyncUsers(ctx, users)
+		if err != nil {
+			t.Fatal(err.Error())
+		}
+		return res
+	}
+	storedLength := func() int {
+		t.Helper()
+		length := 0
+		if err := db.conn.QueryRow("SELECT content_length FROM users WHERE id = 1").Scan(&length); err != nil {
+			t.Fatal(err.Error())
+		}
+		return length
+	}
+	tailRange := func(length int) string {
+		return fmt.Sprintf("bytes=%d-", length-feedTailOverlap)
+	}
+
+	if res := syncFeed(); res.Tweets != 1 || len(ranges) != 1 || ranges[0] != "" || storedLength() != len(body) {
+		t.Fatalf("Expected the first fetch to be whole, got %+v with ranges %q and length %d", res, ranges, storedLength())
+	}
+
+	want := tailRange(len(body))
+	body += "2022-10-20T00:00:00Z\tsecond\n"
+	res := syncFeed()
+	if res.Tweets != 1 || len(ranges) != 1 || ranges[0] != want {
+		t.Errorf("Expected only the appended tweet fetched by range, got %+v with ranges %q", res, ranges)
+	}
+	if storedLength() != len(body) {
+		t.Errorf("Expected the length to follow the append, got %d rather than %d", storedLength(), len(body))
+	}
+	declared := ""
+	if err := db.conn.QueryRow("SELECT meta_nick FROM users WHERE id = 1").Scan(&declared); err != nil {
+		t.Fatal(err.Error())
+	}
+	if declared != "foo" {
+		t.Errorf("Expected the metadata kept through the partial fetch, got %q", declared)
+	}
+
+	if res := syncFeed(); res.NotModified != 1 || res.Tweets != 0 {
+		t.Errorf("Expected nothing appended to count as not modified, got %+v", res)
+	}
+
+	// An edit to a tweet already seen changes the overlap, so it's noticed even though the file grew too.
+	want = tailRange(len(body))
+	body = header + "2022-10-19T00:00:00Z\tfirst, edited\n2022-10-20T00:00:00Z\tsecond\n2022-10-21T00:00:00Z\tthird\n"
+	if res := syncFeed(); res.Tweets != 1 || len(ranges) != 2 || ranges[0] != want || ranges[1] != "" {
+		t.Errorf("Expected a full fetch after an edit, got %+v with ranges %q", res, ranges)
+	}
+	edited := 0
+	if err := db.conn.QueryRow("SELECT COUNT(*) FROM tweets WHERE user_id = 1 AND body = 'first, edited'").Scan(&edited); err != nil {
+		t.Fatal(err.Error())
+	}
+	if edited != 1 {
+		t.Error("Expected the edit to be stored")
+	}
+
+	// Edits further up than the overlap are caught by fetching the file whole every so often.
+	if _, err := db.conn.Exec("UPDATE users SET partial_fetches = ? WHERE id = 1", maxPartialFetches); err != nil {
+		t.Fatal(err.Error())
+	}
+	body += "2022-10-22T00:00:00Z\tfourth\n"
+	if res := syncFeed(); res.Tweets != 1 || len(ranges) != 1 || ranges[0] != "" {
+		t.Errorf("Expected a full fetch after %d range fetches, got %+v with ranges %q", maxPartialFetches, res, ranges)
+	}
+
+	// A shorter file can't be satisfied from the old length.
+	want = tailRange(len(body))
+	body = header + "2022-10-23T00:00:00Z\tfifth\n"
+	if res := syncFeed(); res.Tweets != 1 || len(ranges) != 2 || ranges[0] != want || ranges[1] != "" || storedLength() != len(body) {
+		t.Errorf("Expected a full fetch after the file shrank, got %+v with ranges %q", res, ranges)
+	}
+
+	// A longer file that doesn't continue from where the old one ended was rewritten.
+	body = header + "2022-10-23T00:00:00Z\tfifth, now with a much longer body than before\n"
+	if res := syncFeed(); res.Updated != 1 || len(ranges) != 2 || ranges[1] != "" {
+		t.Errorf("Expected a full fetch after the file was rewritten, got %+v with ranges %q", res, ranges)
+	}
+
+	body += "2022-10-24T00:00:00Z\tno newline"
+	if res := syncFeed(); res.Tweets != 1 || storedLength() != 0 {
+		t.Errorf("Expected the length forgotten when the file doesn't end with a newline, got %+v and %d", res, storedLength())
+	}
+}
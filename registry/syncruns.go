@@ -0,0 +1,242 @@
+package registry
+
+/*
+Copyright 2021 G. Benjamin Morrison
+
+This file is part of getwtxt-ng.
+
+getwtxt-ng is free software: you can redistribute it and/or modify
+it under the terms of the GNU Affero General Public License as
+published by the Free Software Foundation, either version 3 of the
+License, or (at your option) any later version.
+
+getwtxt-ng is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU Affero General Public License for more details.
+
+You should have received a copy of the GNU Affero General Public License
+along with getwtxt-ng.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+)
+
+// syncRunsKept is how many sync reports are kept. Older ones are removed as new ones are recorded.
+const syncRunsKept = 1000
+
+// The types sync failures are counted by in SyncResult.Errors and SyncRun.Errors.
+const (
+	SyncErrorTimeout    = "timeout"
+	SyncErrorDNS        = "dns"
+	SyncErrorTLS        = "tls"
+	SyncErrorConnection = "connection"
+	SyncErrorHTTPStatus = "http_status"
+	SyncErrorContent    = "content"
+	SyncErrorStore      = "store"
+	SyncErrorOther      = "other"
+)
+
+// SyncRun is the report of one pass over the feeds due for sync.
+type SyncRun struct {
+	ID       int64     `json:"id"`
+	Started  time.Time `json:"started"`
+	Finished time.Time `json:"finished"`
+
+	// Feeds is the number of feeds attempted, of which Updated had new content, NotModified didn't,
+	// and Failed couldn't be synced.
+	Feeds       int `json:"feeds"`
+	Updated     int `json:"updated"`
+	NotModified int `json:"not_modified"`
+	Failed      int `json:"failed"`
+
+	// Tweets is the number of new tweets stored.
+	Tweets int `json:"tweets"`
+
+	// Errors counts the failures by their type, one of the SyncError constants.
+	Errors map[string]int `json:"errors"`
+
+	// Error is why the run stopped early, or empty if it didn't.
+	Error string `json:"error,omitempty"`
+}
+
+// NewSyncRun reports on a sync that ran from started to finished with the provided result,
+// and stopped early with err if it isn't nil.
+func NewSyncRun(started, finished time.Time, result SyncResult, err error) SyncRun {
+	run := SyncRun{
+		Started:     started.UTC(),
+		Finished:    finished.UTC(),
+		Feeds:       result.Users,
+		Updated:     result.Updated,
+		NotModified: result.NotModified,
+		Failed:      len(result.Failed),
+		Tweets:      result.Tweets,
+		Errors:      make(map[string]int, len(result.Errors)),
+	}
+	for k, v := range result.Errors {
+		run.Errors[k] = v
+	}
+	if err != nil {
+		run.Error = err.Error()
+	}
+
+	return run
+}
+
+// syncErrorType classifies the reason a feed couldn't be fetched, given the status of the response, if any.
+func syncErrorType(err error, statusCode int) string {
+	var netErr net.Error
+	var dnsErr *net.DNSError
+	var opErr *net.OpError
+	var recordErr tls.RecordHeaderError
+	var authorityErr x509.UnknownAuthorityError
+	var hostnameErr x509.HostnameError
+	var certErr x509.CertificateInvalidError
+
+	switch {
+	case errors.Is(err, context.DeadlineExceeded), errors.As(err, &netErr) && netErr.Timeout():
+		return SyncErrorTimeout
+	case errors.As(err, &dnsErr):
+		return SyncErrorDNS
+	case errors.As(err, &recordErr), errors.As(err, &authorityErr), errors.As(err, &hostnameErr), errors.As(err, &certErr):
+		return SyncErrorTLS
+	case errors.As(err, &opErr):
+		return SyncErrorConnection
+	case statusCode == http.StatusOK:
+		// The response was received but wasn't a twtxt file, or was cut off.
+		return SyncErrorContent
+	case statusCode != 0:
+		return SyncErrorHTTPStatus
+	default:
+		return SyncErrorOther
+	}
+}
+
+// RecordSyncRun stores the report of a sync, setting its ID, and removes the oldest beyond the most recent syncRunsKept.
+func (d *DB) RecordSyncRun(ctx context.Context, run *SyncRun) error {
+	errorsJSON, err := json.Marshal(run.Errors)
+	if err != nil {
+		return fmt.Errorf("when encoding errors of sync run started at %s: %w", run.Started.Format(time.RFC3339), err)
+	}
+
+	tx, err := d.beginWrite(ctx)
+	if err != nil {
+		return fmt.Errorf("when beginning tx to record sync run started at %s: %w", run.Started.Format(time.RFC3339), err)
+	}
+	defer func() {
+		_ = tx.Rollback()
+	}()
+
+	stmt := `INSERT INTO sync_runs (dt_started, dt_finished, feeds, updated, not_modified, failed, tweets, errors, error)
+				VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)`
+	res, err := tx.ExecContext(ctx, stmt, run.Started.UnixNano(), run.Finished.UnixNano(), run.Feeds, run.Updated, run.NotModified,
+		run.Failed, run.Tweets, string(errorsJSON), run.Error)
+	if err != nil {
+		return fmt.Errorf("when recording sync run started at %s: %w", run.Started.Format(time.RFC3339), err)
+	}
+	id, err := res.LastInsertId()
+	if err != nil {
+		return fmt.Errorf("when getting ID of sync run started at %s: %w", run.Started.Format(time.RFC3339), err)
+	}
+
+	pruneStmt := `DELETE FROM sync_runs WHERE id NOT IN (SELECT id FROM sync_runs ORDER BY id DESC LIMIT ?)`
+	if _, err := tx.ExecContext(ctx, pruneStmt, syncRunsKept); err != nil {
+		return fmt.Errorf("when removing old sync runs: %w", err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("when committing sync run started at %s: %w", run.Started.Format(time.RFC3339), err)
+	}
+	d.invalidate()
+	run.ID = id
+
+	return nil
+}
+
+// GetSyncRuns returns the reports of the last limit syncs, newest first.
+func (d *DB) GetSyncRuns(ctx context.Context, limit int) ([]SyncRun, error) {
+	ctx, cancel := d.withQueryTimeout(ctx)
+	defer cancel()
+
+	if limit < 1 {
+		limit = 1
+	}
+	if limit > syncRunsKept {
+		limit = syncRunsKept
+	}
+
+	stmt := `SELECT id, dt_started, dt_finished, feeds, updated, not_modified, failed, tweets, errors, error
+				FROM sync_runs ORDER BY id DESC LIMIT ?`
+	rows, err := d.conn.QueryContext(ctx, stmt, limit)
+	if err != nil {
+		return nil, fmt.Errorf("when querying for the last %d sync runs: %w", limit, err)
+	}
+	defer func() {
+		_ = rows.Close()
+	}()
+
+	out := make([]SyncRun, 0, limit)
+	for rows.Next() {
+		run := SyncRun{}
+		started := int64(0)
+		finished := int64(0)
+		errorsJSON := ""
+		if err := rows.Scan(&run.ID, &started, &finished, &run.Feeds, &run.Updated, &run.NotModified, &run.Failed, &run.Tweets,
+			&errorsJSON, &run.Error); err != nil {
+			d.logger.Debugf("when scanning sync run: %s", err)
+			continue
+		}
+		run.Started = time.Unix(0, started).UTC()
+		run.Finished = time.Unix(0, finished).UTC()
+		if err := json.Unmarshal([]byte(errorsJSON), &run.Errors); err != nil {
+			d.logger.Debugf("when decoding errors of sync run %d: %s", run.ID, err)
+		}
+		if run.Errors == nil {
+			run.Errors = make(map[string]int)
+		}
+		out = append(out, run)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("when reading the last %d sync runs: %w", limit, err)
+	}
+
+	return out, nil
+}
+
+// FormatSyncRunsPlain formats reports as tab-separated lines of the start, the finish, the number of feeds
+// attempted, updated, not modified, and failed, the new tweets, and the failures by type as type=count pairs,
+// or - if there were none.
+func FormatSyncRunsPlain(runs []SyncRun) string {
+	builder := strings.Builder{}
+	builder.Grow(len(runs) * 96)
+	for _, run := range runs {
+		types := make([]string, 0, len(run.Errors))
+		for t := range run.Errors {
+			types = append(types, t)
+		}
+		sort.Strings(types)
+		counts := make([]string, 0, len(types))
+		for _, t := range types {
+			counts = append(counts, fmt.Sprintf("%s=%d", t, run.Errors[t]))
+		}
+		errs := "-"
+		if len(counts) > 0 {
+			errs = strings.Join(counts, ",")
+		}
+		builder.WriteString(fmt.Sprintf("%s\t%s\t%d\t%d\t%d\t%d\t%d\t%s\n", run.Started.Format(time.RFC3339), run.Finished.Format(time.RFC3339),
+			run.Feeds, run.Updated, run.NotModified, run.Failed, run.Tweets, errs))
+	}
+
+	return builder.String()
+}
@@ -0,0 +1,102 @@
+package registry
+
+/*
+Copyright 2021 G. Benjamin Morrison
+
+This file is part of getwtxt-ng.
+
+getwtxt-ng is free software: you can redistribute it and/or modify
+it under the terms of the GNU Affero General Public License as
+published by the Free Software Foundation, either version 3 of the
+License, or (at your option) any later version.
+
+getwtxt-ng is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU Affero General Public License for more details.
+
+You should have received a copy of the GNU Affero General Public License
+along with getwtxt-ng.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestDB_RecordSyncRun(t *testing.T) {
+	memDB := getPopulatedDB(t)
+	ctx := context.Background()
+	start := time.Date(2022, 10, 19, 0, 0, 0, 0, time.UTC)
+
+	result := SyncResult{
+		Users:       4,
+		Updated:     1,
+		NotModified: 1,
+		Tweets:      7,
+		Failed:      map[string]error{"https://a.example/twtxt.txt": errors.New("a"), "https://b.example/twtxt.txt": errors.New("b")},
+		Errors:      map[string]int{SyncErrorTimeout: 1, SyncErrorHTTPStatus: 1},
+	}
+	first := NewSyncRun(start, start.Add(time.Second), result, nil)
+	if err := memDB.RecordSyncRun(ctx, &first); err != nil {
+		t.Fatal(err.Error())
+	}
+	second := NewSyncRun(start.Add(time.Hour), start.Add(time.Hour+time.Second), SyncResult{}, errors.New("couldn't get users due for sync"))
+	if err := memDB.RecordSyncRun(ctx, &second); err != nil {
+		t.Fatal(err.Error())
+	}
+
+	runs, err := memDB.GetSyncRuns(ctx, 10)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	if len(runs) != 2 || runs[0].ID != second.ID || runs[1].ID != first.ID {
+		t.Fatalf("Expected both runs, newest first, got %+v", runs)
+	}
+	got := runs[1]
+	if !got.Started.Equal(first.Started) || got.Feeds != 4 || got.Failed != 2 || got.Tweets != 7 ||
+		got.Errors[SyncErrorTimeout] != 1 || got.Errors[SyncErrorHTTPStatus] != 1 {
+		t.Errorf("Expected the first run to be stored as recorded, got %+v", got)
+	}
+	if runs[0].Error == "" || runs[0].Errors == nil {
+		t.Errorf("Expected the second run's error and an empty error count, got %+v", runs[0])
+	}
+
+	if runs, err := memDB.GetSyncRuns(ctx, 0); err != nil || len(runs) != 1 {
+		t.Errorf("Expected at least one run, got %d, %v", len(runs), err)
+	}
+
+	plain := FormatSyncRunsPlain(runs[1:])
+	if want := "2022-10-19T00:00:00Z\t2022-10-19T00:00:01Z\t4\t1\t1\t2\t7\thttp_status=1,timeout=1\n"; plain != want {
+		t.Errorf("Expected %q, got %q", want, plain)
+	}
+	if plain := FormatSyncRunsPlain(runs[:1]); !strings.HasSuffix(plain, "\t-\n") {
+		t.Errorf("Expected a run without failures to end with -, got %q", plain)
+	}
+}
+
+func Test_syncErrorType(t *testing.T) {
+	cases := []struct {
+		err  error
+		code int
+		want string
+	}{
+		{fmt.Errorf("fetching: %w", context.DeadlineExceeded), 0, SyncErrorTimeout},
+		{&net.DNSError{Err: "no such host", Name: "a.example"}, 0, SyncErrorDNS},
+		{&net.OpError{Op: "dial", Err: errors.New("connection refused")}, 0, SyncErrorConnection},
+		{errors.New("got status code 404"), http.StatusNotFound, SyncErrorHTTPStatus},
+		{errors.New("received non-text/plain content type"), http.StatusOK, SyncErrorContent},
+		{errors.New("invalid URL provided"), 0, SyncErrorOther},
+	}
+	for _, tc := range cases {
+		if got := syncErrorType(tc.err, tc.code); got != tc.want {
+			t.Errorf("%v with status %d: expected %s, got %s", tc.err, tc.code, tc.want, got)
+		}
+	}
+}
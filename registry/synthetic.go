@@ -0,0 +1,81 @@
+package registry
+
+/*
+Copyright 2021 G. Benjamin Morrison
+
+This file is part of getwtxt-ng.
+
+getwtxt-ng is free software: you can redistribute it and/or modify
+it under the terms of the GNU Affero General Public License as
+published by the Free Software Foundation, either version 3 of the
+License, or (at your option) any later version.
+
+getwtxt-ng is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU Affero General Public License for more details.
+
+You should have received a copy of the GNU Affero General Public License
+along with getwtxt-ng.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+import (
+	"context"
+	"crypto/rand"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// SyntheticFeedURL is the URL SeedSynthetic registers its i-th user with.
+func SyntheticFeedURL(i int) string {
+	return fmt.Sprintf("https://user%d.synthetic.invalid/twtxt.txt", i)
+}
+
+// SyntheticTweets returns n tweets for the feed at feedURL, one a minute going back from now, newest first.
+// Every fifth has a tag, and every seventh mentions the feed, so tag and mention queries have something to find.
+func SyntheticTweets(feedURL string, n int) []Tweet {
+	now := time.Now().UTC().Truncate(time.Second)
+	tweets := make([]Tweet, 0, n)
+	for i := 0; i < n; i++ {
+		body := strings.Builder{}
+		body.WriteString(fmt.Sprintf("synthetic tweet %d with some words to search through", i))
+		if i%5 == 0 {
+			body.WriteString(fmt.Sprintf(" #tag%d", i%50))
+		}
+		if i%7 == 0 {
+			body.WriteString(fmt.Sprintf(" @<someone %s>", feedURL))
+		}
+		tweets = append(tweets, Tweet{
+			URL:      feedURL,
+			DateTime: now.Add(time.Duration(-i) * time.Minute),
+			Body:     body.String(),
+		})
+	}
+
+	return tweets
+}
+
+// SeedSynthetic registers users synthetic users, with tweetsPerUser tweets each, for measuring how the registry
+// performs with a given amount of data. Their feed URLs come from SyntheticFeedURL and don't resolve, and their
+// passcode hashes are random bytes that no passcode matches. It's meant for scratch databases only.
+func (d *DB) SeedSynthetic(ctx context.Context, users, tweetsPerUser int) ([]User, error) {
+	seeded := make([]User, 0, users)
+	for i := 0; i < users; i++ {
+		u := User{
+			Nick:          fmt.Sprintf("user%d", i),
+			URL:           SyntheticFeedURL(i),
+			PasscodeHash:  make([]byte, 16),
+			DateTimeAdded: time.Now().UTC(),
+		}
+		if _, err := rand.Read(u.PasscodeHash); err != nil {
+			return seeded, fmt.Errorf("when generating passcode hash for synthetic user %d: %w", i, err)
+		}
+		if _, err := d.InsertUserWithTweets(ctx, &u, SyntheticTweets(u.URL, tweetsPerUser)); err != nil {
+			return seeded, fmt.Errorf("when seeding synthetic user %d: %w", i, err)
+		}
+		seeded = append(seeded, u)
+	}
+
+	return seeded, nil
+}
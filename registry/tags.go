@@ -0,0 +1,166 @@
+package registry
+
+/*
+Copyright 2021 G. Benjamin Morrison
+
+This file is part of getwtxt-ng.
+
+getwtxt-ng is free software: you can redistribute it and/or modify
+it under the terms of the GNU Affero General Public License as
+published by the Free Software Foundation, either version 3 of the
+License, or (at your option) any later version.
+
+getwtxt-ng is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU Affero General Public License for more details.
+
+You should have received a copy of the GNU Affero General Public License
+along with getwtxt-ng.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"strings"
+)
+
+// TagCount is a tag and the number of visible tweets using it.
+type TagCount struct {
+	Tag   string `json:"tag"`
+	Count int    `json:"count"`
+}
+
+// storeTweetTagsTx records the tags of each tweet in tweet_tags, lowercased, replacing any recorded
+// for an earlier version of it.
+func storeTweetTagsTx(ctx context.Context, tx *sql.Tx, tweets []Tweet) error {
+	for _, t := range tweets {
+		if _, err := tx.ExecContext(ctx, "DELETE FROM tweet_tags WHERE tweet_id = ?", t.ID); err != nil {
+			return fmt.Errorf("when clearing tags of tweet %s: %w", t.ID, err)
+		}
+		for _, tag := range tweetTags(t.Body) {
+			if _, err := tx.ExecContext(ctx, "INSERT OR IGNORE INTO tweet_tags (tweet_id, tag) VALUES (?, ?)", t.ID, strings.ToLower(tag)); err != nil {
+				return fmt.Errorf("when storing tags of tweet %s: %w", t.ID, err)
+			}
+		}
+	}
+
+	return nil
+}
+
+// SuggestTags returns up to 10 tags starting with prefix, ignoring case and a leading #, most used first.
+// Only visible tweets are counted. Results are kept in the read cache regardless of how it's configured to cache pages.
+func (d *DB) SuggestTags(ctx context.Context, prefix string) ([]TagCount, error) {
+	ctx, cancel := d.withQueryTimeout(ctx)
+	defer cancel()
+
+	prefix = strings.ToLower(strings.TrimPrefix(strings.TrimSpace(prefix), "#"))
+	if len(prefix) > suggestionMaxPrefix {
+		prefix = prefix[:suggestionMaxPrefix]
+	}
+	if prefix == "" {
+		return []TagCount{}, nil
+	}
+
+	key := tagSuggestionsCacheKey(prefix)
+	cached, gen, ok := d.cache.get(key)
+	if ok {
+		return append([]TagCount(nil), cached.([]TagCount)...), nil
+	}
+
+	pattern := strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`).Replace(prefix) + "%"
+	stmt := `SELECT tweet_tags.tag, COUNT(*) AS uses
+				FROM tweet_tags JOIN tweets ON tweets.id = tweet_tags.tweet_id
+				WHERE tweet_tags.tag LIKE ? ESCAPE '\' AND tweets.hidden = ?
+				GROUP BY tweet_tags.tag
+				ORDER BY uses DESC, tweet_tags.tag
+				LIMIT ?`
+	rows, err := d.queryPrepared(ctx, stmt, pattern, StatusVisible, suggestionLimit)
+	if err != nil {
+		return nil, fmt.Errorf("when querying for tags starting with %s: %w", prefix, err)
+	}
+	defer func() {
+		_ = rows.Close()
+	}()
+
+	out := make([]TagCount, 0, suggestionLimit)
+	for rows.Next() {
+		tc := TagCount{}
+		if err := rows.Scan(&tc.Tag, &tc.Count); err != nil {
+			d.logger.Debugf("when scanning tags starting with %s: %s", prefix, err)
+			continue
+		}
+		out = append(out, tc)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("when reading tags starting with %s: %w", prefix, err)
+	}
+	d.cache.put(key, gen, append([]TagCount(nil), out...))
+
+	return out, nil
+}
+
+// BackfillTweetTags records the tags of tweets stored before tweet_tags was.
+// Returns the number of tweets whose tags were recorded.
+func (d *DB) BackfillTweetTags(ctx context.Context) (int64, error) {
+	recorded := int64(0)
+	for {
+		n, err := d.backfillTweetTagsBatch(ctx)
+		if err != nil {
+			return recorded, err
+		}
+		recorded += n
+		if n < backfillTweetsBatchSize {
+			break
+		}
+	}
+	if recorded > 0 {
+		d.invalidate()
+	}
+
+	return recorded, nil
+}
+
+func (d *DB) backfillTweetTagsBatch(ctx context.Context) (int64, error) {
+	tx, err := d.beginWrite(ctx)
+	if err != nil {
+		return 0, fmt.Errorf("when beginning tx to backfill tweet tags: %w", err)
+	}
+	defer func() {
+		_ = tx.Rollback()
+	}()
+
+	// contains_tags is only set when the body has tags, so each of these gets at least one row and isn't selected again.
+	stmt := `SELECT id, body FROM tweets
+				WHERE contains_tags = 1 AND NOT EXISTS (SELECT 1 FROM tweet_tags WHERE tweet_tags.tweet_id = tweets.id)
+				LIMIT ?`
+	rows, err := tx.QueryContext(ctx, stmt, backfillTweetsBatchSize)
+	if err != nil {
+		return 0, fmt.Errorf("when querying for tweets without recorded tags: %w", err)
+	}
+	tweets := make([]Tweet, 0, backfillTweetsBatchSize)
+	for rows.Next() {
+		t := Tweet{}
+		if err := rows.Scan(&t.ID, &t.Body); err != nil {
+			d.logger.Debugf("when scanning tweet to record its tags: %s", err)
+			continue
+		}
+		tweets = append(tweets, t)
+	}
+	err = rows.Err()
+	_ = rows.Close()
+	if err != nil {
+		return 0, fmt.Errorf("when reading tweets without recorded tags: %w", err)
+	}
+
+	if err := storeTweetTagsTx(ctx, tx.Tx, tweets); err != nil {
+		return 0, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return 0, fmt.Errorf("when committing tx to backfill tweet tags: %w", err)
+	}
+
+	return int64(len(tweets)), nil
+}
@@ -0,0 +1,107 @@
+package registry
+
+/*
+Copyright 2021 G. Benjamin Morrison
+
+This file is part of getwtxt-ng.
+
+getwtxt-ng is free software: you can redistribute it and/or modify
+it under the terms of the GNU Affero General Public License as
+published by the Free Software Foundation, either version 3 of the
+License, or (at your option) any later version.
+
+getwtxt-ng is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU Affero General Public License for more details.
+
+You should have received a copy of the GNU Affero General Public License
+along with getwtxt-ng.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestDB_SuggestTags(t *testing.T) {
+	ctx := context.Background()
+	memDB := getPopulatedDB(t)
+
+	dt := time.Now().UTC().Truncate(time.Second)
+	_, err := memDB.InsertTweets(ctx, []Tweet{
+		{UserID: "1", DateTime: dt, Body: "Trying out #TagTest and #tagtester"},
+		{UserID: "1", DateTime: dt.Add(time.Second), Body: "More #tagtest, again #tagtest"},
+		{UserID: "2", DateTime: dt, Body: "Hidden #tagtest_hidden"},
+	})
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	if err := memDB.ToggleTweetHiddenStatus(ctx, "2", dt, StatusHidden); err != nil {
+		t.Fatal(err.Error())
+	}
+
+	tests := []struct {
+		prefix string
+		want   []TagCount
+	}{
+		{"#TAGT", []TagCount{{"tagtest", 2}, {"tagtester", 1}}},
+		{"tagtest_", nil},
+		{"tagtest%", nil},
+		{"#", nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.prefix, func(t *testing.T) {
+			got, err := memDB.SuggestTags(ctx, tt.prefix)
+			if err != nil {
+				t.Fatal(err.Error())
+			}
+			if len(got) != len(tt.want) {
+				t.Fatalf("Expected %v, got %v", tt.want, got)
+			}
+			for i := range got {
+				if got[i] != tt.want[i] {
+					t.Errorf("Expected %v, got %v", tt.want, got)
+				}
+			}
+		})
+	}
+
+	t.Run("edit", func(t *testing.T) {
+		res, err := memDB.InsertTweets(ctx, []Tweet{{UserID: "1", DateTime: dt.Add(time.Second), Body: "More #tagtested, again #tagtest"}})
+		if err != nil {
+			t.Fatal(err.Error())
+		}
+		if res.Edited != 1 {
+			t.Fatalf("Expected an edit, got %+v", res)
+		}
+		got, err := memDB.SuggestTags(ctx, "tagtested")
+		if err != nil {
+			t.Fatal(err.Error())
+		}
+		if len(got) != 1 || got[0].Count != 1 {
+			t.Errorf("Expected the edited tweet's new tag, got %v", got)
+		}
+	})
+
+	t.Run("backfill", func(t *testing.T) {
+		if _, err := memDB.conn.ExecContext(ctx, "DELETE FROM tweet_tags"); err != nil {
+			t.Fatal(err.Error())
+		}
+		recorded, err := memDB.BackfillTweetTags(ctx)
+		if err != nil {
+			t.Fatal(err.Error())
+		}
+		if recorded < 3 {
+			t.Errorf("Expected at least 3 tweets backfilled, got %d", recorded)
+		}
+		got, err := memDB.SuggestTags(ctx, "tagtester")
+		if err != nil {
+			t.Fatal(err.Error())
+		}
+		if len(got) != 1 {
+			t.Errorf("Expected the tag after backfilling, got %v", got)
+		}
+	})
+}
@@ -0,0 +1,167 @@
+package registry
+
+/*
+Copyright 2021 G. Benjamin Morrison
+
+This file is part of getwtxt-ng.
+
+getwtxt-ng is free software: you can redistribute it and/or modify
+it under the terms of the GNU Affero General Public License as
+published by the Free Software Foundation, either version 3 of the
+License, or (at your option) any later version.
+
+getwtxt-ng is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU Affero General Public License for more details.
+
+You should have received a copy of the GNU Affero General Public License
+along with getwtxt-ng.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// TimelineQuery selects tweets by ID rather than by page, so clients can keep their place as new tweets arrive.
+// Zero fields are ignored.
+type TimelineQuery struct {
+	// UserID restricts the timeline to a single user.
+	UserID string
+	// MaxID returns tweets with IDs lower than it.
+	MaxID int64
+	// SinceID returns tweets with IDs greater than it, starting from the newest.
+	SinceID int64
+	// MinID returns tweets with IDs greater than it, starting from the ones immediately after it.
+	MinID int64
+	// Limit is clamped to the registry's entries per page.
+	Limit int
+}
+
+// GetTimeline retrieves visible tweets from active users matching the query, in descending order by ID.
+func (d *DB) GetTimeline(ctx context.Context, q TimelineQuery) ([]Tweet, error) {
+	ctx, cancel := d.withQueryTimeout(ctx)
+	defer cancel()
+
+	if q.Limit < 1 || q.Limit > d.EntriesPerPageMax {
+		q.Limit = d.EntriesPerPageMax
+	}
+
+	where := []string{"tweets.hidden = ?", "users.status = 'active'"}
+	args := []interface{}{StatusVisible}
+	if q.UserID != "" {
+		where = append(where, "tweets.user_id = ?")
+		args = append(args, q.UserID)
+	} else {
+		where = append(where, "tweets.duplicate_of IS NULL")
+	}
+	if q.MaxID > 0 {
+		where = append(where, "tweets.id < ?")
+		args = append(args, q.MaxID)
+	}
+	if q.SinceID > 0 {
+		where = append(where, "tweets.id > ?")
+		args = append(args, q.SinceID)
+	}
+	order := "DESC"
+	if q.MinID > 0 {
+		where = append(where, "tweets.id > ?")
+		args = append(args, q.MinID)
+		order = "ASC"
+	}
+	args = append(args, q.Limit)
+
+	tweetStmt := fmt.Sprintf(`SELECT tweets.id, tweets.user_id, users.nick, users.url, tweets.dt, tweets.body, tweets.hidden, tweets.hash, tweets.subject, tweets.mentions, tweets.tags, tweets.utc_offset, tweets.lang
+					FROM tweets JOIN users ON users.id = tweets.user_id
+					WHERE %s
+					ORDER BY tweets.id %s
+					LIMIT ?`, strings.Join(where, " AND "), order)
+	rows, err := d.conn.QueryContext(ctx, tweetStmt, args...)
+	if err != nil {
+		return nil, fmt.Errorf("when querying for timeline: %w", err)
+	}
+	defer func() {
+		_ = rows.Close()
+	}()
+
+	tweets, err := d.scanTweetRows(rows)
+	if err != nil {
+		return nil, err
+	}
+	if q.UserID == "" {
+		if err := d.loadAlternates(ctx, tweets); err != nil {
+			return nil, err
+		}
+	}
+	if order == "ASC" {
+		for i, j := 0, len(tweets)-1; i < j; i, j = i+1, j-1 {
+			tweets[i], tweets[j] = tweets[j], tweets[i]
+		}
+	}
+
+	return tweets, nil
+}
+
+// GetUsersByID retrieves the active users with the provided IDs, in ascending order by ID.
+func (d *DB) GetUsersByID(ctx context.Context, ids []string) ([]User, error) {
+	ctx, cancel := d.withQueryTimeout(ctx)
+	defer cancel()
+
+	if len(ids) < 1 {
+		return nil, errors.New("no user IDs provided")
+	}
+
+	args := make([]interface{}, 0, len(ids))
+	for _, id := range ids {
+		args = append(args, id)
+	}
+	placeholders := strings.TrimSuffix(strings.Repeat("?,", len(ids)), ",")
+
+	stmt := fmt.Sprintf(`SELECT id, url, nick, dt_added, last_sync, meta_nick, avatar, description FROM users
+				WHERE id IN (%s) AND status = 'active'
+				ORDER BY id ASC`, placeholders)
+	rows, err := d.conn.QueryContext(ctx, stmt, args...)
+	if err != nil {
+		return nil, fmt.Errorf("when querying for %d users by ID: %w", len(ids), err)
+	}
+	defer func() {
+		_ = rows.Close()
+	}()
+
+	users := make([]User, 0, len(ids))
+	for rows.Next() {
+		user := User{Status: UserStatusActive}
+		dtAdded := int64(0)
+		lastSync := int64(0)
+		if err := rows.Scan(&user.ID, &user.URL, &user.Nick, &dtAdded, &lastSync, &user.DeclaredNick, &user.Avatar, &user.Description); err != nil {
+			d.logger.Debugf("when scanning user row: %s", err)
+			continue
+		}
+		user.DateTimeAdded = time.Unix(0, dtAdded)
+		user.LastSync = time.Unix(0, lastSync)
+		users = append(users, user)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("when reading user rows: %w", err)
+	}
+
+	return users, nil
+}
+
+// CountUserTweets returns the number of visible tweets the user has.
+func (d *DB) CountUserTweets(ctx context.Context, userID string) (int64, error) {
+	ctx, cancel := d.withQueryTimeout(ctx)
+	defer cancel()
+
+	count := int64(0)
+	err := d.conn.QueryRowContext(ctx, "SELECT COUNT(*) FROM tweets WHERE user_id = ? AND hidden = ?", userID, StatusVisible).Scan(&count)
+	if err != nil {
+		return 0, fmt.Errorf("when counting tweets of user %s: %w", userID, err)
+	}
+
+	return count, nil
+}
@@ -0,0 +1,105 @@
+package registry
+
+/*
+Copyright 2021 G. Benjamin Morrison
+
+This file is part of getwtxt-ng.
+
+getwtxt-ng is free software: you can redistribute it and/or modify
+it under the terms of the GNU Affero General Public License as
+published by the Free Software Foundation, either version 3 of the
+License, or (at your option) any later version.
+
+getwtxt-ng is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU Affero General Public License for more details.
+
+You should have received a copy of the GNU Affero General Public License
+along with getwtxt-ng.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+)
+
+func TestDB_GetTimeline(t *testing.T) {
+	db := getPopulatedDB(t)
+	defer func() {
+		_ = db.Close()
+	}()
+	ctx := context.Background()
+
+	now := time.Now().UTC().Truncate(time.Second)
+	tweets := make([]Tweet, 0, 6)
+	for i := 0; i < 6; i++ {
+		tweets = append(tweets, Tweet{UserID: "1", DateTime: now.Add(time.Duration(i) * time.Minute), Body: fmt.Sprintf("twt %d", i)})
+	}
+	res, err := db.InsertTweets(ctx, tweets)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	ids := make([]int64, 0, len(res.IDs))
+	for _, id := range res.IDs {
+		n := int64(0)
+		_, _ = fmt.Sscan(id, &n)
+		ids = append(ids, n)
+	}
+
+	tests := []struct {
+		name  string
+		query TimelineQuery
+		want  []string
+	}{
+		{"newest", TimelineQuery{Limit: 3}, []string{res.IDs[5], res.IDs[4], res.IDs[3]}},
+		{"max_id", TimelineQuery{MaxID: ids[3], Limit: 2}, []string{res.IDs[2], res.IDs[1]}},
+		{"since_id", TimelineQuery{SinceID: ids[2], Limit: 2}, []string{res.IDs[5], res.IDs[4]}},
+		{"min_id", TimelineQuery{MinID: ids[2], Limit: 2}, []string{res.IDs[4], res.IDs[3]}},
+		{"user, skipping hidden", TimelineQuery{UserID: "2"}, []string{"2"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := db.GetTimeline(ctx, tt.query)
+			if err != nil {
+				t.Fatal(err.Error())
+			}
+			gotIDs := make([]string, 0, len(got))
+			for _, tw := range got {
+				gotIDs = append(gotIDs, tw.ID)
+			}
+			if fmt.Sprint(gotIDs) != fmt.Sprint(tt.want) {
+				t.Errorf("Expected tweets %v, got %v", tt.want, gotIDs)
+			}
+		})
+	}
+}
+
+func TestDB_GetUsersByID(t *testing.T) {
+	db := getPopulatedDB(t)
+	defer func() {
+		_ = db.Close()
+	}()
+	ctx := context.Background()
+
+	if _, err := db.GetUsersByID(ctx, nil); err == nil {
+		t.Error("Expected error with no IDs")
+	}
+	users, err := db.GetUsersByID(ctx, []string{"2", "1", "500"})
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	if len(users) != 2 || users[0].URL != populatedDBUsers[0].URL || users[1].URL != populatedDBUsers[1].URL {
+		t.Errorf("Got unexpected users: %v", users)
+	}
+
+	count, err := db.CountUserTweets(ctx, "2")
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	if count != 1 {
+		t.Errorf("Expected 1 visible tweet for user 2, got %d", count)
+	}
+}
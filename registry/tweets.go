@@ -21,12 +21,15 @@ along with getwtxt-ng.  If not, see <https://www.gnu.org/licenses/>.
 
 import (
 	"context"
+	"database/sql"
 	"errors"
 	"fmt"
 	"regexp"
 	"strings"
 	"sync/atomic"
 	"time"
+
+	"golang.org/x/text/unicode/norm"
 )
 
 // Tweet represents a single entry in a User's twtxt.txt file.
@@ -41,6 +44,34 @@ type Tweet struct {
 	Mentions []Mention             `json:"mentions"`
 	Tags     []string              `json:"tags"`
 	Hidden   TweetVisibilityStatus `json:"hidden,omitempty"`
+
+	// Hash is the twt hash Yarn clients use to refer to the tweet, such as in the subject of a reply.
+	Hash string `json:"hash,omitempty"`
+
+	// Subject is the hash of the tweet this one replies to, taken from a (#hash) or (#<hash url>) marker in its body.
+	Subject string `json:"subject,omitempty"`
+
+	// UTCOffset is the offset of the timestamp in the feed, in seconds east of UTC.
+	// DateTime is in the same offset. Tweets stored before offsets were are given the server's.
+	UTCOffset int `json:"utc_offset"`
+
+	// Lang is the ISO 639-1 code of the language the tweet is written in, as DetectLanguage guessed it,
+	// or empty if it couldn't tell.
+	Lang string `json:"lang,omitempty"`
+
+	// Alternates are the copies of the tweet posted by feeds mirroring its author's, when mirrors are collapsed.
+	Alternates []Alternate `json:"alternates,omitempty"`
+
+	// Ingested is when the registry first stored the tweet. It's only populated by InsertTweets and GetTweetsSince.
+	Ingested time.Time `json:"-"`
+}
+
+// Alternate is a copy of a tweet posted by a feed mirroring its author's.
+type Alternate struct {
+	ID       string `json:"id"`
+	Nickname string `json:"nickname"`
+	URL      string `json:"url"`
+	Hash     string `json:"hash,omitempty"`
 }
 
 // Mention represents a single mention of another user within a tweet.
@@ -60,14 +91,54 @@ const (
 var RegexTweetContainsMentions = regexp.MustCompile(`@<(\w+)\s(\S+)>`)
 
 // RegexTweetContainsTags is used to confirm if a tweet contains tags and, if so, extract them.
+// Subject markers should be removed with RegexTweetSubject first, or their hashes are taken for tags.
 var RegexTweetContainsTags = regexp.MustCompile(`#(\w+)`)
 
+// RegexTweetSubject matches the subject markers Yarn clients put in replies, (#hash) and (#<hash url>),
+// extracting the hash from whichever form was used as the first or second submatch.
+var RegexTweetSubject = regexp.MustCompile(`\(#(?:<([a-z2-7]+)\s+\S+>|([a-z2-7]+))\)`)
+
+// tweetSubject returns the hash in the body's first subject marker, or an empty string if it has none.
+func tweetSubject(body string) string {
+	m := RegexTweetSubject.FindStringSubmatch(body)
+	if m == nil {
+		return ""
+	}
+	if m[1] != "" {
+		return m[1]
+	}
+
+	return m[2]
+}
+
+// tweetTags returns the tags in the body, leaving out subject markers.
+func tweetTags(body string) []string {
+	tags := RegexTweetContainsTags.FindAllStringSubmatch(RegexTweetSubject.ReplaceAllString(body, ""), -1)
+	out := make([]string, 0, len(tags))
+	for _, tag := range tags {
+		if len(tag) < 2 {
+			continue
+		}
+		out = append(out, tag[1])
+	}
+
+	return out
+}
+
 // FormatTweetsPlain formats the provided slice of Tweet into plain text, with each LF-terminated line containing the following tab-separated values:
 //   - Nickname
 //   - URL
 //   - Timestamp (RFC3339)
 //   - Body
+//
+// Control characters in bodies are escaped, see FormatTweetsPlainWith.
 func FormatTweetsPlain(tweets []Tweet) string {
+	return FormatTweetsPlainWith(tweets, PlainFormat{})
+}
+
+// FormatTweetsPlainWith formats tweets as FormatTweetsPlain does, with timestamps and the control
+// characters in bodies written as f says, so each tweet stays on a single line of four fields.
+func FormatTweetsPlainWith(tweets []Tweet, f PlainFormat) string {
 	if len(tweets) < 1 {
 		return ""
 	}
@@ -79,58 +150,278 @@ func FormatTweetsPlain(tweets []Tweet) string {
 		builder.WriteString("\t")
 		builder.WriteString(tweet.URL)
 		builder.WriteString("\t")
-		builder.WriteString(tweet.DateTime.Format(time.RFC3339))
+		builder.WriteString(f.Timestamp(tweet.DateTime))
 		builder.WriteString("\t")
-		builder.WriteString(tweet.Body)
+		builder.WriteString(f.ControlChars.plainBody(tweet.Body))
 		builder.WriteString("\n")
 	}
 
 	return builder.String()
 }
 
+// sqliteMaxVariables is the most parameters SQLite binds to one statement.
+const sqliteMaxVariables = 32766
+
+// insertTweetsColumns are the columns InsertTweets sets, taking one bound parameter each per row.
+var insertTweetsColumns = []string{
+	"user_id", "dt", "body", "contains_mentions", "contains_tags", "dt_ingested", "hash", "subject", "mentions", "tags", "utc_offset", "lang",
+}
+
+// insertTweetsBatchSize is the number of rows inserted per statement by InsertTweets.
+// Each row uses len(insertTweetsColumns) of the sqliteMaxVariables bound parameters.
+const insertTweetsBatchSize = 500
+
+// insertTweetsQuery builds a statement inserting the given number of rows and returning the ones that weren't already present.
+func insertTweetsQuery(rows int) string {
+	row := "(" + strings.TrimSuffix(strings.Repeat("?,", len(insertTweetsColumns)), ",") + ")"
+	values := strings.TrimSuffix(strings.Repeat(row+",", rows), ",")
+	return fmt.Sprintf("INSERT OR IGNORE INTO tweets (%s) VALUES %s RETURNING id, user_id, dt, body, dt_ingested, hash, utc_offset, lang",
+		strings.Join(insertTweetsColumns, ", "), values)
+}
+
+// InsertResult describes the outcome of inserting a collection of tweets.
+type InsertResult struct {
+	// Inserted is the number of tweets that were new.
+	Inserted int `json:"inserted"`
+
+	// Edited is the number of tweets that replaced the body of an existing tweet with the same author and timestamp.
+	Edited int `json:"edited"`
+
+	// Ignored is the number of tweets that were already present.
+	Ignored int `json:"ignored"`
+
+	// IDs holds the IDs of the new tweets.
+	IDs []string `json:"ids"`
+}
+
+func newInsertResult(total int, inserted, edited []Tweet) InsertResult {
+	res := InsertResult{
+		Inserted: len(inserted),
+		Edited:   len(edited),
+		Ignored:  total - len(inserted) - len(edited),
+		IDs:      make([]string, 0, len(inserted)),
+	}
+	for _, t := range inserted {
+		res.IDs = append(res.IDs, t.ID)
+	}
+
+	return res
+}
+
 // InsertTweets adds a collection of tweets to the database.
-func (d *DB) InsertTweets(ctx context.Context, tweets []Tweet) error {
+// Tweets that are already present, according to the DB's Dedupe mode, are ignored. Tweets found to be
+// edits of existing ones replace their bodies, and the old bodies are kept as revisions.
+func (d *DB) InsertTweets(ctx context.Context, tweets []Tweet) (InsertResult, error) {
+	return d.insertTweets(ctx, tweets, true)
+}
+
+// insertTweets is InsertTweets, only calling the TweetsInserted hook if notify is set, so the tweets of users
+// who aren't listed yet aren't passed along.
+func (d *DB) insertTweets(ctx context.Context, tweets []Tweet, notify bool) (InsertResult, error) {
 	if len(tweets) == 0 {
-		return errors.New("invalid tweets provided")
+		return InsertResult{}, errors.New("invalid tweets provided")
 	}
 
-	tx, err := d.conn.Begin()
+	batchStmt, err := d.prepareTweetsBatch(ctx, len(tweets))
 	if err != nil {
-		return fmt.Errorf("when beginning tx to insert tweets: %w", err)
+		return InsertResult{}, err
+	}
+
+	tx, err := d.beginWrite(ctx)
+	if err != nil {
+		return InsertResult{}, fmt.Errorf("when beginning tx to insert tweets: %w", err)
 	}
 	defer func() {
 		_ = tx.Rollback()
 	}()
 
-	insertStmt := "INSERT OR IGNORE INTO tweets (user_id, dt, body, contains_mentions, contains_tags) VALUES(?,?,?,?,?)"
-	stmt, err := tx.Prepare(insertStmt)
+	inserted, edited, err := d.insertTweetsTx(ctx, tx.Tx, batchStmt, tweets)
 	if err != nil {
-		return fmt.Errorf("could not prepare statement to insert tweets: %w", err)
+		return InsertResult{}, err
 	}
-	defer func() {
-		_ = stmt.Close()
-	}()
 
-	for _, t := range tweets {
-		hasMentions := 0
-		hasTags := 0
-		if RegexTweetContainsMentions.MatchString(t.Body) {
-			hasMentions = 1
+	if err := tx.Commit(); err != nil {
+		return InsertResult{}, fmt.Errorf("error committing tx to insert tweets: %w", err)
+	}
+	d.invalidate()
+
+	if notify {
+		d.Hooks.tweetsInserted(ctx, inserted)
+	}
+
+	return newInsertResult(len(tweets), inserted, edited), nil
+}
+
+// prepareTweetsBatch returns the cached statement for inserting a full batch of tweets, or nil
+// if count won't fill one. Only full batches share a statement, so the cache doesn't fill up
+// with one for every remainder. This must be called before beginning the transaction.
+func (d *DB) prepareTweetsBatch(ctx context.Context, count int) (*sql.Stmt, error) {
+	if count < insertTweetsBatchSize {
+		return nil, nil
+	}
+	stmt, err := d.prepared(ctx, insertTweetsQuery(insertTweetsBatchSize))
+	if err != nil {
+		return nil, fmt.Errorf("could not prepare statement to insert tweets: %w", err)
+	}
+
+	return stmt, nil
+}
+
+// insertTweetsTx inserts the tweets as part of tx, returning the ones that weren't already present
+// and the existing tweets that were edited.
+func (d *DB) insertTweetsTx(ctx context.Context, tx *sql.Tx, batchStmt *sql.Stmt, tweets []Tweet) ([]Tweet, []Tweet, error) {
+	var txBatchStmt *sql.Stmt
+	if batchStmt != nil {
+		txBatchStmt = tx.StmtContext(ctx, batchStmt)
+		defer func() {
+			_ = txBatchStmt.Close()
+		}()
+	}
+
+	feedURLs, nicknames, err := d.tweetAuthors(ctx, tx, tweets)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	// Tweets sharing a timestamp may land in different batches, so they're grouped across all of them.
+	incoming := incomingBodies(tweets)
+	inserted := make([]Tweet, 0, len(tweets))
+	edited := make([]Tweet, 0)
+	for start := 0; start < len(tweets); start += insertTweetsBatchSize {
+		end := start + insertTweetsBatchSize
+		if end > len(tweets) {
+			end = len(tweets)
 		}
-		if RegexTweetContainsTags.MatchString(t.Body) {
-			hasTags = 1
+		batch := tweets[start:end]
+
+		// Each row gets its own ingestion time so GetTweetsSince never has to split a tie.
+		ingested := time.Now().UnixNano()
+		args := make([]interface{}, 0, len(batch)*len(insertTweetsColumns))
+		for i, t := range batch {
+			hasMentions, hasTags := tweetBodyFlags(t.Body)
+			feedURL := t.URL
+			if feedURL == "" {
+				feedURL = feedURLs[t.UserID]
+			}
+			feedURLs[t.UserID] = feedURL
+			if t.Nickname != "" {
+				nicknames[t.UserID] = t.Nickname
+			}
+			mentions, tags := tweetEntities(t.Body)
+			_, offset := t.DateTime.Zone()
+			args = append(args, t.UserID, t.DateTime.UnixNano(), t.Body, hasMentions, hasTags, ingested+int64(i), TwtHash(feedURL, t.DateTime, t.Body), tweetSubject(t.Body), mentions, tags, offset, DetectLanguage(t.Body))
 		}
 
-		if _, err := stmt.ExecContext(ctx, t.UserID, t.DateTime.UnixNano(), t.Body, hasMentions, hasTags); err != nil {
-			return fmt.Errorf("could not insert tweet for uid %s at %s: %w", t.UserID, t.DateTime, err)
+		var rows *sql.Rows
+		var err error
+		if len(batch) == insertTweetsBatchSize {
+			rows, err = txBatchStmt.QueryContext(ctx, args...)
+		} else {
+			rows, err = tx.QueryContext(ctx, insertTweetsQuery(len(batch)), args...)
+		}
+		if err != nil {
+			return nil, nil, fmt.Errorf("could not insert tweets %d - %d of %d: %w", start+1, end, len(tweets), err)
+		}
+		batchInserted := make([]Tweet, 0, len(batch))
+		for rows.Next() {
+			dt := int64(0)
+			dtIngested := int64(0)
+			var offset sql.NullInt64
+			thisTweet := Tweet{}
+			if err := rows.Scan(&thisTweet.ID, &thisTweet.UserID, &dt, &thisTweet.Body, &dtIngested, &thisTweet.Hash, &offset, &thisTweet.Lang); err != nil {
+				d.logger.Debugf("when scanning inserted tweet: %s", err)
+				continue
+			}
+			thisTweet.setDateTime(dt, offset)
+			thisTweet.Ingested = time.Unix(0, dtIngested)
+			thisTweet.URL = feedURLs[thisTweet.UserID]
+			thisTweet.Nickname = nicknames[thisTweet.UserID]
+			batchInserted = append(batchInserted, thisTweet)
+		}
+		err = rows.Err()
+		_ = rows.Close()
+		if err != nil {
+			return nil, nil, fmt.Errorf("could not insert tweets %d - %d of %d: %w", start+1, end, len(tweets), err)
 		}
+
+		batchInserted, batchEdited, err := d.dedupeInserted(ctx, tx, batchInserted, incoming)
+		if err != nil {
+			return nil, nil, fmt.Errorf("could not check tweets %d - %d of %d for duplicates: %w", start+1, end, len(tweets), err)
+		}
+		if err := d.filterInsertedTx(ctx, tx, feedURLs, batchInserted); err != nil {
+			return nil, nil, err
+		}
+		if err := d.filterInsertedTx(ctx, tx, feedURLs, batchEdited); err != nil {
+			return nil, nil, err
+		}
+		if err := storeTweetTagsTx(ctx, tx, batchInserted); err != nil {
+			return nil, nil, err
+		}
+		if err := storeTweetTagsTx(ctx, tx, batchEdited); err != nil {
+			return nil, nil, err
+		}
+		inserted = append(inserted, batchInserted...)
+		edited = append(edited, batchEdited...)
 	}
 
-	if err := tx.Commit(); err != nil {
-		return fmt.Errorf("error committing tx to insert tweets: %w", err)
+	return inserted, edited, nil
+}
+
+// tweetBodyFlags reports whether the body contains mentions and tags, as stored in contains_mentions and contains_tags.
+func tweetBodyFlags(body string) (int, int) {
+	hasMentions := 0
+	hasTags := 0
+	if RegexTweetContainsMentions.MatchString(body) {
+		hasMentions = 1
+	}
+	if len(tweetTags(body)) > 0 {
+		hasTags = 1
 	}
 
-	return nil
+	return hasMentions, hasTags
+}
+
+// TweetRevision is an earlier version of a tweet's body, replaced when its author edited it.
+type TweetRevision struct {
+	TweetID  string    `json:"tweet_id"`
+	Body     string    `json:"body"`
+	Replaced time.Time `json:"replaced"`
+}
+
+// GetTweetRevisions retrieves the earlier versions of the tweet with the provided ID, most recently replaced first.
+func (d *DB) GetTweetRevisions(ctx context.Context, tweetID string) ([]TweetRevision, error) {
+	ctx, cancel := d.withQueryTimeout(ctx)
+	defer cancel()
+
+	if tweetID == "" {
+		return nil, errors.New("no tweet ID provided")
+	}
+
+	stmt := "SELECT tweet_id, body, dt_replaced FROM tweet_revisions WHERE tweet_id = ? ORDER BY dt_replaced DESC, id DESC"
+	rows, err := d.conn.QueryContext(ctx, stmt, tweetID)
+	if err != nil {
+		return nil, fmt.Errorf("when querying for revisions of tweet %s: %w", tweetID, err)
+	}
+	defer func() {
+		_ = rows.Close()
+	}()
+
+	revisions := make([]TweetRevision, 0)
+	for rows.Next() {
+		dt := int64(0)
+		rev := TweetRevision{}
+		if err := rows.Scan(&rev.TweetID, &rev.Body, &dt); err != nil {
+			d.logger.Debugf("when scanning tweet revision: %s", err)
+			continue
+		}
+		rev.Replaced = time.Unix(0, dt)
+		revisions = append(revisions, rev)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("when reading revisions of tweet %s: %w", tweetID, err)
+	}
+
+	return revisions, nil
 }
 
 // ToggleTweetHiddenStatus changes the provided tweet's hidden status.
@@ -139,7 +430,7 @@ func (d *DB) ToggleTweetHiddenStatus(ctx context.Context, userID string, timesta
 		return errors.New("invalid user ID or tweet timestamp provided")
 	}
 
-	tx, err := d.conn.Begin()
+	tx, err := d.beginWrite(ctx)
 	if err != nil {
 		return fmt.Errorf("when beginning tx to hide tweet by %s at %s: %w", userID, timestamp, err)
 	}
@@ -155,161 +446,281 @@ func (d *DB) ToggleTweetHiddenStatus(ctx context.Context, userID string, timesta
 	if err := tx.Commit(); err != nil {
 		return fmt.Errorf("error committing tx to set hidden status of tweet by user %s at %s to %d: %w", userID, timestamp, status, err)
 	}
+	d.invalidate()
 
 	return nil
 }
 
-// GetTweets retrieves a page's worth of tweets in descending order by datetime.
-func (d *DB) GetTweets(ctx context.Context, page, perPage int, visibilityStatus TweetVisibilityStatus) ([]Tweet, error) {
-	page--
-	if perPage < d.EntriesPerPageMin {
-		perPage = d.EntriesPerPageMin
+// SetUserTweetsVisibility sets the hidden status of every one of the user's tweets at once,
+// returning how many changed. Tweets stored afterward aren't affected.
+func (d *DB) SetUserTweetsVisibility(ctx context.Context, userID string, status TweetVisibilityStatus) (int64, error) {
+	if userID == "" {
+		return 0, errors.New("invalid user ID provided")
 	}
-	if perPage > d.EntriesPerPageMax {
-		perPage = d.EntriesPerPageMax
+
+	tx, err := d.beginWrite(ctx)
+	if err != nil {
+		return 0, fmt.Errorf("when beginning tx to set hidden status of tweets by %s: %w", userID, err)
+	}
+	defer func() {
+		_ = tx.Rollback()
+	}()
+
+	res, err := tx.ExecContext(ctx, "UPDATE tweets SET hidden = ? WHERE user_id = ? AND hidden != ?", status, userID, status)
+	if err != nil {
+		return 0, fmt.Errorf("when setting hidden status of tweets by %s to %d: %w", userID, status, err)
 	}
-	if page < 0 {
-		page = 0
+	changed, err := res.RowsAffected()
+	if err != nil {
+		return 0, fmt.Errorf("when counting tweets by %s whose hidden status was set: %w", userID, err)
 	}
-	idFloor := page * perPage
-	idCeil := idFloor + perPage
 
-	tweetStmt := `SELECT id, user_id, nick, url, dt, body, hidden
-					FROM (SELECT tweets.*, users.nick AS nick, users.url AS url, ROW_NUMBER() OVER (ORDER BY dt DESC) AS set_id
-					      FROM tweets LEFT JOIN users ON users.id = tweets.user_id WHERE tweets.hidden = ?)
-					WHERE set_id > ?
-  					AND set_id <= ?`
-	rows, err := d.conn.QueryContext(ctx, tweetStmt, visibilityStatus, idFloor, idCeil)
+	if err := tx.Commit(); err != nil {
+		return 0, fmt.Errorf("error committing tx to set hidden status of tweets by %s to %d: %w", userID, status, err)
+	}
+	d.invalidate()
+
+	return changed, nil
+}
+
+// SetTweetsVisibility sets the hidden status of the tweets with the provided IDs, returning how many changed.
+// Unlike ToggleTweetHiddenStatus, other tweets sharing an author and timestamp aren't affected.
+func (d *DB) SetTweetsVisibility(ctx context.Context, ids []string, status TweetVisibilityStatus) (int64, error) {
+	if len(ids) < 1 {
+		return 0, errors.New("no tweet IDs provided")
+	}
+
+	tx, err := d.beginWrite(ctx)
 	if err != nil {
-		return nil, fmt.Errorf("when querying for tweets %d - %d: %w", idFloor+1, idCeil+1, err)
+		return 0, fmt.Errorf("when beginning tx to set hidden status of %d tweets: %w", len(ids), err)
 	}
 	defer func() {
-		_ = rows.Close()
+		_ = tx.Rollback()
 	}()
 
-	tweets := make([]Tweet, 0)
-	for rows.Next() {
-		dt := int64(0)
-		thisTweet := Tweet{}
-		err := rows.Scan(&thisTweet.ID, &thisTweet.UserID, &thisTweet.Nickname, &thisTweet.URL, &dt, &thisTweet.Body, &thisTweet.Hidden)
+	stmt, err := tx.Prepare("UPDATE tweets SET hidden = ? WHERE id = ? AND hidden != ?")
+	if err != nil {
+		return 0, fmt.Errorf("when preparing stmt to set hidden status of %d tweets: %w", len(ids), err)
+	}
+	defer func() {
+		_ = stmt.Close()
+	}()
+
+	changed := int64(0)
+	for _, id := range ids {
+		res, err := stmt.ExecContext(ctx, status, id, status)
 		if err != nil {
-			d.logger.Debugf("when querying for tweets %d - %d: %s", idFloor+1, idCeil+1, err)
-			continue
-		}
-		thisTweet.DateTime = time.Unix(0, dt)
-		mentions := RegexTweetContainsMentions.FindAllStringSubmatch(thisTweet.Body, -1)
-		thisTweet.Mentions = make([]Mention, 0, len(mentions))
-		for _, mention := range mentions {
-			if len(mention) < 3 {
-				continue
-			}
-			// first is the whole mention, we want the capture groups
-			thisMention := Mention{
-				Nickname: mention[1],
-				URL:      mention[2],
-			}
-			thisTweet.Mentions = append(thisTweet.Mentions, thisMention)
+			return 0, fmt.Errorf("when setting hidden status of tweet %s to %d: %w", id, status, err)
 		}
-		tags := RegexTweetContainsTags.FindAllStringSubmatch(thisTweet.Body, -1)
-		thisTweet.Tags = make([]string, 0, len(tags))
-		for _, tag := range tags {
-			if len(tag) < 2 {
-				continue
-			}
-			thisTweet.Tags = append(thisTweet.Tags, tag[1])
+		n, err := res.RowsAffected()
+		if err != nil {
+			return 0, fmt.Errorf("when setting hidden status of tweet %s to %d: %w", id, status, err)
 		}
-		tweets = append(tweets, thisTweet)
+		changed += n
 	}
 
-	return tweets, nil
+	if err := tx.Commit(); err != nil {
+		return 0, fmt.Errorf("error committing tx to set hidden status of %d tweets to %d: %w", len(ids), status, err)
+	}
+	d.invalidate()
+
+	return changed, nil
 }
 
-// SearchTweets searches for a given term in tweet bodies and returns a page worth in descending order by datetime.
-func (d *DB) SearchTweets(ctx context.Context, page, perPage int, searchTerm string, visibilityStatus TweetVisibilityStatus) ([]Tweet, error) {
-	page--
-	if perPage < d.EntriesPerPageMin {
-		perPage = d.EntriesPerPageMin
+// GetTweetsByID retrieves the tweets with the provided IDs, regardless of their visibility, in descending order by datetime.
+func (d *DB) GetTweetsByID(ctx context.Context, ids []string) ([]Tweet, error) {
+	ctx, cancel := d.withQueryTimeout(ctx)
+	defer cancel()
+
+	if len(ids) < 1 {
+		return nil, errors.New("no tweet IDs provided")
 	}
-	if perPage > d.EntriesPerPageMax {
-		perPage = d.EntriesPerPageMax
+
+	args := make([]interface{}, 0, len(ids))
+	for _, id := range ids {
+		args = append(args, id)
 	}
-	if page < 0 {
-		page = 0
+	placeholders := strings.TrimSuffix(strings.Repeat("?,", len(ids)), ",")
+
+	tweetStmt := fmt.Sprintf(`SELECT tweets.id, tweets.user_id, users.nick, users.url, tweets.dt, tweets.body, tweets.hidden, tweets.hash, tweets.subject, tweets.mentions, tweets.tags, tweets.utc_offset, tweets.lang
+					FROM tweets LEFT JOIN users ON users.id = tweets.user_id
+					WHERE tweets.id IN (%s)
+					ORDER BY tweets.dt DESC`, placeholders)
+	rows, err := d.conn.QueryContext(ctx, tweetStmt, args...)
+	if err != nil {
+		return nil, fmt.Errorf("when querying for %d tweets by ID: %w", len(ids), err)
 	}
-	idFloor := page * perPage
-	idCeil := idFloor + perPage
+	defer func() {
+		_ = rows.Close()
+	}()
 
-	searchStmt := `SELECT id, user_id, nick, url, dt, body, hidden
-					FROM (SELECT *, ROW_NUMBER() OVER (ORDER BY dt DESC) AS set_id
-					      FROM tweets_search WHERE tweets_search.hidden = ? AND body MATCH ?)
-					WHERE set_id > ? AND set_id <= ?`
-	rows, err := d.conn.QueryContext(ctx, searchStmt, visibilityStatus, searchTerm, idFloor, idCeil)
+	return d.scanTweetRows(rows)
+}
+
+// GetUserTweets retrieves up to limit of a user's most recent visible tweets, in descending order by datetime.
+func (d *DB) GetUserTweets(ctx context.Context, userID string, limit int) ([]Tweet, error) {
+	ctx, cancel := d.withQueryTimeout(ctx)
+	defer cancel()
+
+	if limit < d.EntriesPerPageMin {
+		limit = d.EntriesPerPageMin
+	}
+	if limit > d.EntriesPerPageMax {
+		limit = d.EntriesPerPageMax
+	}
+
+	tweetStmt := `SELECT tweets.id, tweets.user_id, users.nick, users.url, tweets.dt, tweets.body, tweets.hidden, tweets.hash, tweets.subject, tweets.mentions, tweets.tags, tweets.utc_offset, tweets.lang
+					FROM tweets LEFT JOIN users ON users.id = tweets.user_id
+					WHERE tweets.user_id = ? AND tweets.hidden = ?
+					ORDER BY tweets.dt DESC
+					LIMIT ?`
+	rows, err := d.conn.QueryContext(ctx, tweetStmt, userID, StatusVisible, limit)
 	if err != nil {
-		return nil, fmt.Errorf("when querying for tweets containing %s, %d - %d: %w", searchTerm, idFloor+1, idCeil, err)
+		return nil, fmt.Errorf("when querying for tweets of user %s: %w", userID, err)
 	}
 	defer func() {
 		_ = rows.Close()
 	}()
 
-	tweets := make([]Tweet, 0)
-	for rows.Next() {
-		dt := int64(0)
-		thisTweet := Tweet{}
-		err := rows.Scan(&thisTweet.ID, &thisTweet.UserID, &thisTweet.Nickname, &thisTweet.URL, &dt, &thisTweet.Body, &thisTweet.Hidden)
+	return d.scanTweetRows(rows)
+}
+
+// GetUserFeed retrieves all of a user's visible tweets in ascending order by datetime,
+// as they'd appear in their twtxt file.
+func (d *DB) GetUserFeed(ctx context.Context, userID string) ([]Tweet, error) {
+	ctx, cancel := d.withQueryTimeout(ctx)
+	defer cancel()
+
+	tweetStmt := `SELECT tweets.id, tweets.user_id, users.nick, users.url, tweets.dt, tweets.body, tweets.hidden, tweets.hash, tweets.subject, tweets.mentions, tweets.tags, tweets.utc_offset, tweets.lang
+					FROM tweets LEFT JOIN users ON users.id = tweets.user_id
+					WHERE tweets.user_id = ? AND tweets.hidden = ?
+					ORDER BY tweets.dt ASC`
+	rows, err := d.conn.QueryContext(ctx, tweetStmt, userID, StatusVisible)
+	if err != nil {
+		return nil, fmt.Errorf("when querying for feed of user %s: %w", userID, err)
+	}
+	defer func() {
+		_ = rows.Close()
+	}()
+
+	return d.scanTweetRows(rows)
+}
+
+// DeleteTweets removes the tweets with the provided IDs. Returns the number of tweets deleted.
+func (d *DB) DeleteTweets(ctx context.Context, ids []string) (int64, error) {
+	if len(ids) < 1 {
+		return 0, errors.New("no tweet IDs provided")
+	}
+
+	tx, err := d.beginWrite(ctx)
+	if err != nil {
+		return 0, fmt.Errorf("when beginning tx to delete %d tweets: %w", len(ids), err)
+	}
+	defer func() {
+		_ = tx.Rollback()
+	}()
+
+	stmt, err := tx.Prepare("DELETE FROM tweets WHERE id = ?")
+	if err != nil {
+		return 0, fmt.Errorf("when preparing stmt to delete %d tweets: %w", len(ids), err)
+	}
+	defer func() {
+		_ = stmt.Close()
+	}()
+
+	deleted := int64(0)
+	for _, id := range ids {
+		res, err := stmt.ExecContext(ctx, id)
 		if err != nil {
-			d.logger.Debugf("when querying for tweets containing %s, %d - %d: %s", searchTerm, idFloor+1, idCeil+1, err)
-			continue
+			return 0, fmt.Errorf("when deleting tweet %s: %w", id, err)
 		}
-		thisTweet.DateTime = time.Unix(0, dt)
-		mentions := RegexTweetContainsMentions.FindAllStringSubmatch(thisTweet.Body, -1)
-		thisTweet.Mentions = make([]Mention, 0, len(mentions))
-		for _, mention := range mentions {
-			if len(mention) < 3 {
-				continue
-			}
-			// first is the whole mention, we want the capture groups
-			thisMention := Mention{
-				Nickname: mention[1],
-				URL:      mention[2],
-			}
-			thisTweet.Mentions = append(thisTweet.Mentions, thisMention)
-		}
-		tags := RegexTweetContainsTags.FindAllStringSubmatch(thisTweet.Body, -1)
-		thisTweet.Tags = make([]string, 0, len(tags))
-		for _, tag := range tags {
-			if len(tag) < 2 {
-				continue
-			}
-			thisTweet.Tags = append(thisTweet.Tags, tag[1])
+		n, err := res.RowsAffected()
+		if err != nil {
+			return 0, fmt.Errorf("when deleting tweet %s: %w", id, err)
 		}
-		tweets = append(tweets, thisTweet)
+		deleted += n
+	}
+
+	if err := tx.Commit(); err != nil {
+		return 0, fmt.Errorf("when committing tx to delete %d tweets: %w", len(ids), err)
+	}
+	d.invalidate()
+
+	d.Hooks.tweetsDeleted(ctx, deleted)
+
+	return deleted, nil
+}
+
+// GetTweets retrieves a page's worth of tweets in descending order by datetime.
+// The first few pages are served from the read cache when it's enabled.
+func (d *DB) GetTweets(ctx context.Context, page, perPage int, visibilityStatus TweetVisibilityStatus) ([]Tweet, error) {
+	ctx, cancel := d.withQueryTimeout(ctx)
+	defer cancel()
+
+	if !d.cache.cachesPage(page) {
+		return d.getTweets(ctx, page, perPage, visibilityStatus)
 	}
 
+	key := tweetsCacheKey(page, perPage, visibilityStatus)
+	cached, gen, ok := d.cache.get(key)
+	if ok {
+		return append([]Tweet(nil), cached.([]Tweet)...), nil
+	}
+	tweets, err := d.getTweets(ctx, page, perPage, visibilityStatus)
+	if err != nil {
+		return nil, err
+	}
+	d.cache.put(key, gen, append([]Tweet(nil), tweets...))
+
 	return tweets, nil
 }
 
-// GetTags returns the most recent tweets containing tags.
-func (d *DB) GetTags(ctx context.Context, page, perPage int, visibilityStatus TweetVisibilityStatus) ([]Tweet, error) {
-	page--
-	if perPage < d.EntriesPerPageMin {
-		perPage = d.EntriesPerPageMin
+func (d *DB) getTweets(ctx context.Context, page, perPage int, visibilityStatus TweetVisibilityStatus) ([]Tweet, error) {
+	idFloor, idCeil := d.paginationWindow(page, perPage)
+
+	tweetStmt := `SELECT id, user_id, nick, url, dt, body, hidden, hash, subject, mentions, tags, utc_offset, lang
+					FROM (SELECT tweets.*, users.nick AS nick, users.url AS url, ROW_NUMBER() OVER (ORDER BY dt DESC) AS set_id
+					      FROM tweets LEFT JOIN users ON users.id = tweets.user_id WHERE tweets.hidden = ? AND tweets.duplicate_of IS NULL AND users.status = 'active')
+					WHERE set_id > ?
+  					AND set_id <= ?`
+	rows, err := d.queryPrepared(ctx, tweetStmt, visibilityStatus, idFloor, idCeil)
+	if err != nil {
+		return nil, fmt.Errorf("when querying for tweets %d - %d: %w", idFloor+1, idCeil+1, err)
 	}
-	if perPage > d.EntriesPerPageMax {
-		perPage = d.EntriesPerPageMax
+	defer func() {
+		_ = rows.Close()
+	}()
+
+	return d.scanCollapsedTweetRows(ctx, rows)
+}
+
+// GetTweetsSince retrieves up to limit visible tweets ingested after the provided time, in ascending order of ingestion.
+// Passing the Ingested time of the last tweet returned as the next watermark reads the registry incrementally.
+func (d *DB) GetTweetsSince(ctx context.Context, since time.Time, limit int) ([]Tweet, error) {
+	ctx, cancel := d.withQueryTimeout(ctx)
+	defer cancel()
+
+	if limit < d.EntriesPerPageMin {
+		limit = d.EntriesPerPageMin
+	}
+	if limit > d.EntriesPerPageMax {
+		limit = d.EntriesPerPageMax
 	}
-	if page < 0 {
-		page = 0
+	// The zero time's UnixNano is undefined, so treat it as the beginning.
+	sinceNano := int64(-1)
+	if !since.IsZero() {
+		sinceNano = since.UnixNano()
 	}
-	idFloor := page * perPage
-	idCeil := idFloor + perPage
 
-	searchStmt := `SELECT id, user_id, nick, url, dt, body, hidden
-					FROM (SELECT *, ROW_NUMBER() OVER (ORDER BY dt DESC) AS set_id
-					      FROM tweets_users WHERE hidden = ? AND contains_tags = 1)
-					WHERE set_id > ? AND set_id <= ?`
-	rows, err := d.conn.QueryContext(ctx, searchStmt, visibilityStatus, idFloor, idCeil)
+	tweetStmt := `SELECT tweets.id, tweets.user_id, users.nick, users.url, tweets.dt, tweets.body, tweets.hidden, tweets.dt_ingested, tweets.hash,
+						tweets.subject, tweets.mentions, tweets.tags, tweets.utc_offset, tweets.lang
+					FROM tweets LEFT JOIN users ON users.id = tweets.user_id
+					WHERE tweets.hidden = ? AND tweets.dt_ingested > ? AND tweets.duplicate_of IS NULL AND users.status = 'active'
+					ORDER BY tweets.dt_ingested ASC, tweets.id ASC
+					LIMIT ?`
+	rows, err := d.queryPrepared(ctx, tweetStmt, StatusVisible, sinceNano, limit)
 	if err != nil {
-		return nil, fmt.Errorf("when querying for tweets containing tags, %d - %d: %w", idFloor+1, idCeil, err)
+		return nil, fmt.Errorf("when querying for tweets ingested since %s: %w", since.Format(time.RFC3339Nano), err)
 	}
 	defer func() {
 		_ = rows.Close()
@@ -318,124 +729,181 @@ func (d *DB) GetTags(ctx context.Context, page, perPage int, visibilityStatus Tw
 	tweets := make([]Tweet, 0)
 	for rows.Next() {
 		dt := int64(0)
+		dtIngested := int64(0)
+		var subject, mentions, tags, lang sql.NullString
+		var offset sql.NullInt64
 		thisTweet := Tweet{}
-		err := rows.Scan(&thisTweet.ID, &thisTweet.UserID, &thisTweet.Nickname, &thisTweet.URL, &dt, &thisTweet.Body, &thisTweet.Hidden)
+		err := rows.Scan(&thisTweet.ID, &thisTweet.UserID, &thisTweet.Nickname, &thisTweet.URL, &dt, &thisTweet.Body, &thisTweet.Hidden, &dtIngested, &thisTweet.Hash,
+			&subject, &mentions, &tags, &offset, &lang)
 		if err != nil {
-			d.logger.Debugf("when querying for tweets containing tags, %d - %d: %s", idFloor+1, idCeil+1, err)
+			d.logger.Debugf("when scanning tweet row: %s", err)
 			continue
 		}
-		thisTweet.DateTime = time.Unix(0, dt)
-		mentions := RegexTweetContainsMentions.FindAllStringSubmatch(thisTweet.Body, -1)
-		thisTweet.Mentions = make([]Mention, 0, len(mentions))
-		for _, mention := range mentions {
-			if len(mention) < 3 {
-				continue
-			}
-			// first is the whole mention, we want the capture groups
-			thisMention := Mention{
-				Nickname: mention[1],
-				URL:      mention[2],
-			}
-			thisTweet.Mentions = append(thisTweet.Mentions, thisMention)
-		}
-		tags := RegexTweetContainsTags.FindAllStringSubmatch(thisTweet.Body, -1)
-		thisTweet.Tags = make([]string, 0, len(tags))
-		for _, tag := range tags {
-			if len(tag) < 2 {
-				continue
-			}
-			thisTweet.Tags = append(thisTweet.Tags, tag[1])
-		}
+		thisTweet.setDateTime(dt, offset)
+		thisTweet.Ingested = time.Unix(0, dtIngested)
+		thisTweet.loadEntities(subject, mentions, tags)
+		thisTweet.Lang = lang.String
 		tweets = append(tweets, thisTweet)
 	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("when reading tweet rows: %w", err)
+	}
+	if err := d.loadAlternates(ctx, tweets); err != nil {
+		return nil, err
+	}
 
 	return tweets, nil
 }
 
-// SearchTags searches for a given term in tweet bodies and returns a page worth in descending order by datetime.
-func (d *DB) SearchTags(ctx context.Context, page, perPage int, searchTerm string, visibilityStatus TweetVisibilityStatus) ([]Tweet, error) {
-	page--
-	if perPage < d.EntriesPerPageMin {
-		perPage = d.EntriesPerPageMin
+// SearchTweets searches for a given term in tweet bodies and returns a page worth in descending order by datetime.
+func (d *DB) SearchTweets(ctx context.Context, page, perPage int, searchTerm string, visibilityStatus TweetVisibilityStatus) ([]Tweet, error) {
+	ctx, cancel := d.withQueryTimeout(ctx)
+	defer cancel()
+
+	searchTerm = normalizeSearchTerm(searchTerm)
+	idFloor, idCeil := d.paginationWindow(page, perPage)
+
+	searchStmt := `SELECT page.id, page.user_id, page.nick, page.url, page.dt, page.body, page.hidden, tweets.hash, tweets.subject, tweets.mentions, tweets.tags, tweets.utc_offset, tweets.lang
+					FROM (SELECT *, ROW_NUMBER() OVER (ORDER BY dt DESC) AS set_id
+					      FROM tweets_search WHERE tweets_search.hidden = ? AND body MATCH ?
+					      AND user_id IN (SELECT id FROM users WHERE status = 'active')
+					      AND id NOT IN (SELECT id FROM tweets WHERE duplicate_of IS NOT NULL)) AS page
+					JOIN tweets ON tweets.id = page.id
+					WHERE set_id > ? AND set_id <= ?
+					ORDER BY set_id`
+	rows, err := d.queryPrepared(ctx, searchStmt, visibilityStatus, searchTerm, idFloor, idCeil)
+	if err != nil {
+		return nil, fmt.Errorf("when querying for tweets containing %s, %d - %d: %w", searchTerm, idFloor+1, idCeil, err)
 	}
-	if perPage > d.EntriesPerPageMax {
-		perPage = d.EntriesPerPageMax
+	defer func() {
+		_ = rows.Close()
+	}()
+
+	return d.scanCollapsedTweetRows(ctx, rows)
+}
+
+// GetTweetsInLanguage returns a page of tweets detected as being in lang, a two-letter code, in descending order by datetime.
+// Tweets whose language couldn't be detected are never included.
+func (d *DB) GetTweetsInLanguage(ctx context.Context, page, perPage int, lang string, visibilityStatus TweetVisibilityStatus) ([]Tweet, error) {
+	ctx, cancel := d.withQueryTimeout(ctx)
+	defer cancel()
+
+	idFloor, idCeil := d.paginationWindow(page, perPage)
+
+	tweetStmt := `SELECT id, user_id, nick, url, dt, body, hidden, hash, subject, mentions, tags, utc_offset, lang
+					FROM (SELECT tweets.*, users.nick AS nick, users.url AS url, ROW_NUMBER() OVER (ORDER BY dt DESC) AS set_id
+					      FROM tweets LEFT JOIN users ON users.id = tweets.user_id
+					      WHERE tweets.hidden = ? AND tweets.lang = ? AND tweets.duplicate_of IS NULL AND users.status = 'active')
+					WHERE set_id > ?
+  					AND set_id <= ?`
+	rows, err := d.queryPrepared(ctx, tweetStmt, visibilityStatus, lang, idFloor, idCeil)
+	if err != nil {
+		return nil, fmt.Errorf("when querying for tweets in %s, %d - %d: %w", lang, idFloor+1, idCeil, err)
 	}
-	if page < 0 {
-		page = 0
+	defer func() {
+		_ = rows.Close()
+	}()
+
+	return d.scanCollapsedTweetRows(ctx, rows)
+}
+
+// SearchTweetsInLanguage is SearchTweets limited to tweets detected as being in lang, a two-letter code.
+func (d *DB) SearchTweetsInLanguage(ctx context.Context, page, perPage int, searchTerm, lang string, visibilityStatus TweetVisibilityStatus) ([]Tweet, error) {
+	ctx, cancel := d.withQueryTimeout(ctx)
+	defer cancel()
+
+	searchTerm = normalizeSearchTerm(searchTerm)
+	idFloor, idCeil := d.paginationWindow(page, perPage)
+
+	searchStmt := `SELECT page.id, page.user_id, page.nick, page.url, page.dt, page.body, page.hidden, tweets.hash, tweets.subject, tweets.mentions, tweets.tags, tweets.utc_offset, tweets.lang
+					FROM (SELECT *, ROW_NUMBER() OVER (ORDER BY dt DESC) AS set_id
+					      FROM tweets_search WHERE tweets_search.hidden = ? AND body MATCH ?
+					      AND id IN (SELECT id FROM tweets WHERE lang = ?)
+					      AND user_id IN (SELECT id FROM users WHERE status = 'active')
+					      AND id NOT IN (SELECT id FROM tweets WHERE duplicate_of IS NOT NULL)) AS page
+					JOIN tweets ON tweets.id = page.id
+					WHERE set_id > ? AND set_id <= ?
+					ORDER BY set_id`
+	rows, err := d.queryPrepared(ctx, searchStmt, visibilityStatus, searchTerm, lang, idFloor, idCeil)
+	if err != nil {
+		return nil, fmt.Errorf("when querying for tweets in %s containing %s, %d - %d: %w", lang, searchTerm, idFloor+1, idCeil, err)
 	}
-	idFloor := page * perPage
-	idCeil := idFloor + perPage
+	defer func() {
+		_ = rows.Close()
+	}()
 
-	searchStmt := `SELECT id, user_id, nick, url, dt, body, hidden
+	return d.scanCollapsedTweetRows(ctx, rows)
+}
+
+// GetTags returns the most recent tweets containing tags.
+func (d *DB) GetTags(ctx context.Context, page, perPage int, visibilityStatus TweetVisibilityStatus) ([]Tweet, error) {
+	ctx, cancel := d.withQueryTimeout(ctx)
+	defer cancel()
+
+	idFloor, idCeil := d.paginationWindow(page, perPage)
+
+	searchStmt := `SELECT page.id, page.user_id, page.nick, page.url, page.dt, page.body, page.hidden, tweets.hash, tweets.subject, tweets.mentions, tweets.tags, tweets.utc_offset, tweets.lang
 					FROM (SELECT *, ROW_NUMBER() OVER (ORDER BY dt DESC) AS set_id
-					      FROM tweets_search WHERE tweets_search.hidden = ? AND tweets_search.contains_tags = 1 AND body MATCH ?)
-					WHERE set_id > ? AND set_id <= ?`
-	rows, err := d.conn.QueryContext(ctx, searchStmt, visibilityStatus, searchTerm, idFloor, idCeil)
+					      FROM tweets_users WHERE hidden = ? AND contains_tags = 1
+					      AND user_id IN (SELECT id FROM users WHERE status = 'active')
+					      AND id NOT IN (SELECT id FROM tweets WHERE duplicate_of IS NOT NULL)) AS page
+					JOIN tweets ON tweets.id = page.id
+					WHERE set_id > ? AND set_id <= ?
+					ORDER BY set_id`
+	rows, err := d.queryPrepared(ctx, searchStmt, visibilityStatus, idFloor, idCeil)
 	if err != nil {
-		return nil, fmt.Errorf("when querying for tweets containing %s, %d - %d: %w", searchTerm, idFloor+1, idCeil, err)
+		return nil, fmt.Errorf("when querying for tweets containing tags, %d - %d: %w", idFloor+1, idCeil, err)
 	}
 	defer func() {
 		_ = rows.Close()
 	}()
 
-	tweets := make([]Tweet, 0)
-	for rows.Next() {
-		dt := int64(0)
-		thisTweet := Tweet{}
-		err := rows.Scan(&thisTweet.ID, &thisTweet.UserID, &thisTweet.Nickname, &thisTweet.URL, &dt, &thisTweet.Body, &thisTweet.Hidden)
-		if err != nil {
-			d.logger.Debugf("when querying for tweets containing %s, %d - %d: %s", searchTerm, idFloor+1, idCeil+1, err)
-			continue
-		}
-		thisTweet.DateTime = time.Unix(0, dt)
-		mentions := RegexTweetContainsMentions.FindAllStringSubmatch(thisTweet.Body, -1)
-		thisTweet.Mentions = make([]Mention, 0, len(mentions))
-		for _, mention := range mentions {
-			if len(mention) < 3 {
-				continue
-			}
-			// first is the whole mention, we want the capture groups
-			thisMention := Mention{
-				Nickname: mention[1],
-				URL:      mention[2],
-			}
-			thisTweet.Mentions = append(thisTweet.Mentions, thisMention)
-		}
-		tags := RegexTweetContainsTags.FindAllStringSubmatch(thisTweet.Body, -1)
-		thisTweet.Tags = make([]string, 0, len(tags))
-		for _, tag := range tags {
-			if len(tag) < 2 {
-				continue
-			}
-			thisTweet.Tags = append(thisTweet.Tags, tag[1])
-		}
-		tweets = append(tweets, thisTweet)
+	return d.scanCollapsedTweetRows(ctx, rows)
+}
+
+// SearchTags searches for a given term in tweet bodies and returns a page worth in descending order by datetime.
+func (d *DB) SearchTags(ctx context.Context, page, perPage int, searchTerm string, visibilityStatus TweetVisibilityStatus) ([]Tweet, error) {
+	ctx, cancel := d.withQueryTimeout(ctx)
+	defer cancel()
+
+	searchTerm = normalizeSearchTerm(searchTerm)
+	idFloor, idCeil := d.paginationWindow(page, perPage)
+
+	searchStmt := `SELECT page.id, page.user_id, page.nick, page.url, page.dt, page.body, page.hidden, tweets.hash, tweets.subject, tweets.mentions, tweets.tags, tweets.utc_offset, tweets.lang
+					FROM (SELECT *, ROW_NUMBER() OVER (ORDER BY dt DESC) AS set_id
+					      FROM tweets_search WHERE tweets_search.hidden = ? AND tweets_search.contains_tags = 1 AND body MATCH ?
+					      AND user_id IN (SELECT id FROM users WHERE status = 'active')
+					      AND id NOT IN (SELECT id FROM tweets WHERE duplicate_of IS NOT NULL)) AS page
+					JOIN tweets ON tweets.id = page.id
+					WHERE set_id > ? AND set_id <= ?
+					ORDER BY set_id`
+	rows, err := d.queryPrepared(ctx, searchStmt, visibilityStatus, searchTerm, idFloor, idCeil)
+	if err != nil {
+		return nil, fmt.Errorf("when querying for tweets containing %s, %d - %d: %w", searchTerm, idFloor+1, idCeil, err)
 	}
+	defer func() {
+		_ = rows.Close()
+	}()
 
-	return tweets, nil
+	return d.scanCollapsedTweetRows(ctx, rows)
 }
 
 // GetMentions retrieves the most recent tweets containing mentions.
 func (d *DB) GetMentions(ctx context.Context, page, perPage int, visibilityStatus TweetVisibilityStatus) ([]Tweet, error) {
-	page--
-	if perPage < d.EntriesPerPageMin {
-		perPage = d.EntriesPerPageMin
-	}
-	if perPage > d.EntriesPerPageMax {
-		perPage = d.EntriesPerPageMax
-	}
-	if page < 0 {
-		page = 0
-	}
-	idFloor := page * perPage
-	idCeil := idFloor + perPage
+	ctx, cancel := d.withQueryTimeout(ctx)
+	defer cancel()
 
-	searchStmt := `SELECT id, user_id, nick, url, dt, body, hidden
+	idFloor, idCeil := d.paginationWindow(page, perPage)
+
+	searchStmt := `SELECT page.id, page.user_id, page.nick, page.url, page.dt, page.body, page.hidden, tweets.hash, tweets.subject, tweets.mentions, tweets.tags, tweets.utc_offset, tweets.lang
 					FROM (SELECT *, ROW_NUMBER() OVER (ORDER BY dt DESC) AS set_id
-					      FROM tweets_users WHERE hidden = ? AND contains_mentions = 1)
-					WHERE set_id > ? AND set_id <= ?`
-	rows, err := d.conn.QueryContext(ctx, searchStmt, visibilityStatus, idFloor, idCeil)
+					      FROM tweets_users WHERE hidden = ? AND contains_mentions = 1
+					      AND user_id IN (SELECT id FROM users WHERE status = 'active')
+					      AND id NOT IN (SELECT id FROM tweets WHERE duplicate_of IS NOT NULL)) AS page
+					JOIN tweets ON tweets.id = page.id
+					WHERE set_id > ? AND set_id <= ?
+					ORDER BY set_id`
+	rows, err := d.queryPrepared(ctx, searchStmt, visibilityStatus, idFloor, idCeil)
 	if err != nil {
 		return nil, fmt.Errorf("when querying for tweets containing mentions, %d - %d: %w", idFloor+1, idCeil, err)
 	}
@@ -443,63 +911,26 @@ func (d *DB) GetMentions(ctx context.Context, page, perPage int, visibilityStatu
 		_ = rows.Close()
 	}()
 
-	tweets := make([]Tweet, 0)
-	for rows.Next() {
-		dt := int64(0)
-		thisTweet := Tweet{}
-		err := rows.Scan(&thisTweet.ID, &thisTweet.UserID, &thisTweet.Nickname, &thisTweet.URL, &dt, &thisTweet.Body, &thisTweet.Hidden)
-		if err != nil {
-			d.logger.Debugf("when querying for tweets containing mentions, %d - %d: %s", idFloor+1, idCeil+1, err)
-			continue
-		}
-		thisTweet.DateTime = time.Unix(0, dt)
-		mentions := RegexTweetContainsMentions.FindAllStringSubmatch(thisTweet.Body, -1)
-		thisTweet.Mentions = make([]Mention, 0, len(mentions))
-		for _, mention := range mentions {
-			if len(mention) < 3 {
-				continue
-			}
-			// first is the whole mention, we want the capture groups
-			thisMention := Mention{
-				Nickname: mention[1],
-				URL:      mention[2],
-			}
-			thisTweet.Mentions = append(thisTweet.Mentions, thisMention)
-		}
-		tags := RegexTweetContainsTags.FindAllStringSubmatch(thisTweet.Body, -1)
-		thisTweet.Tags = make([]string, 0, len(tags))
-		for _, tag := range tags {
-			if len(tag) < 2 {
-				continue
-			}
-			thisTweet.Tags = append(thisTweet.Tags, tag[1])
-		}
-		tweets = append(tweets, thisTweet)
-	}
-
-	return tweets, nil
+	return d.scanCollapsedTweetRows(ctx, rows)
 }
 
 // SearchMentions searches for a given term in tweet bodies and returns a page worth in descending order by datetime.
 func (d *DB) SearchMentions(ctx context.Context, page, perPage int, searchTerm string, visibilityStatus TweetVisibilityStatus) ([]Tweet, error) {
-	page--
-	if perPage < d.EntriesPerPageMin {
-		perPage = d.EntriesPerPageMin
-	}
-	if perPage > d.EntriesPerPageMax {
-		perPage = d.EntriesPerPageMax
-	}
-	if page < 0 {
-		page = 0
-	}
-	idFloor := page * perPage
-	idCeil := idFloor + perPage
+	ctx, cancel := d.withQueryTimeout(ctx)
+	defer cancel()
+
+	searchTerm = normalizeSearchTerm(searchTerm)
+	idFloor, idCeil := d.paginationWindow(page, perPage)
 
-	searchStmt := `SELECT id, user_id, nick, url, dt, body, hidden
+	searchStmt := `SELECT page.id, page.user_id, page.nick, page.url, page.dt, page.body, page.hidden, tweets.hash, tweets.subject, tweets.mentions, tweets.tags, tweets.utc_offset, tweets.lang
 					FROM (SELECT *, ROW_NUMBER() OVER (ORDER BY dt DESC) AS set_id
-					      FROM tweets_search WHERE tweets_search.hidden = ? AND tweets_search.contains_mentions = 1 AND body MATCH ?)
-					WHERE set_id > ? AND set_id <= ?`
-	rows, err := d.conn.QueryContext(ctx, searchStmt, visibilityStatus, searchTerm, idFloor, idCeil)
+					      FROM tweets_search WHERE tweets_search.hidden = ? AND tweets_search.contains_mentions = 1 AND body MATCH ?
+					      AND user_id IN (SELECT id FROM users WHERE status = 'active')
+					      AND id NOT IN (SELECT id FROM tweets WHERE duplicate_of IS NOT NULL)) AS page
+					JOIN tweets ON tweets.id = page.id
+					WHERE set_id > ? AND set_id <= ?
+					ORDER BY set_id`
+	rows, err := d.queryPrepared(ctx, searchStmt, visibilityStatus, searchTerm, idFloor, idCeil)
 	if err != nil {
 		return nil, fmt.Errorf("when querying for tweets containing %s, %d - %d: %w", searchTerm, idFloor+1, idCeil, err)
 	}
@@ -507,52 +938,70 @@ func (d *DB) SearchMentions(ctx context.Context, page, perPage int, searchTerm s
 		_ = rows.Close()
 	}()
 
-	tweets := make([]Tweet, 0)
-	for rows.Next() {
-		dt := int64(0)
-		thisTweet := Tweet{}
-		err := rows.Scan(&thisTweet.ID, &thisTweet.UserID, &thisTweet.Nickname, &thisTweet.URL, &dt, &thisTweet.Body, &thisTweet.Hidden)
-		if err != nil {
-			d.logger.Debugf("when querying for tweets containing %s, %d - %d: %s", searchTerm, idFloor+1, idCeil+1, err)
-			continue
-		}
-		thisTweet.DateTime = time.Unix(0, dt)
-		mentions := RegexTweetContainsMentions.FindAllStringSubmatch(thisTweet.Body, -1)
-		thisTweet.Mentions = make([]Mention, 0, len(mentions))
-		for _, mention := range mentions {
-			if len(mention) < 3 {
-				continue
-			}
-			// first is the whole mention, we want the capture groups
-			thisMention := Mention{
-				Nickname: mention[1],
-				URL:      mention[2],
-			}
-			thisTweet.Mentions = append(thisTweet.Mentions, thisMention)
-		}
-		tags := RegexTweetContainsTags.FindAllStringSubmatch(thisTweet.Body, -1)
-		thisTweet.Tags = make([]string, 0, len(tags))
-		for _, tag := range tags {
-			if len(tag) < 2 {
-				continue
-			}
-			thisTweet.Tags = append(thisTweet.Tags, tag[1])
-		}
-		tweets = append(tweets, thisTweet)
+	return d.scanCollapsedTweetRows(ctx, rows)
+}
+
+// CountTweetsOlderThan returns the number of tweets posted before the provided time.
+func (d *DB) CountTweetsOlderThan(ctx context.Context, cutoff time.Time) (int64, error) {
+	ctx, cancel := d.withQueryTimeout(ctx)
+	defer cancel()
+
+	count := int64(0)
+	if err := d.conn.QueryRowContext(ctx, "SELECT count(*) FROM tweets WHERE dt < ?", cutoff.UnixNano()).Scan(&count); err != nil {
+		return 0, fmt.Errorf("failed to count tweets older than %s: %w", cutoff, err)
 	}
 
-	return tweets, nil
+	return count, nil
+}
+
+// DeleteTweetsOlderThan removes tweets posted before the provided time, batchSize tweets per transaction,
+// so the database isn't locked for the whole run. Returns the number of tweets deleted.
+func (d *DB) DeleteTweetsOlderThan(ctx context.Context, cutoff time.Time, batchSize int) (int64, error) {
+	return d.deleteTweetsInBatches(ctx, "aged", retentionAgedStmt, cutoff.UnixNano(), batchSize)
+}
+
+// RebuildSearchIndex discards the full-text search index and repopulates it from the tweets and users tables.
+func (d *DB) RebuildSearchIndex(ctx context.Context) error {
+	if _, err := d.execWrite(ctx, "INSERT INTO tweets_search(tweets_search) VALUES('rebuild')"); err != nil {
+		return fmt.Errorf("couldn't rebuild search index: %w", err)
+	}
+	if _, err := d.execWrite(ctx, "INSERT INTO tweets_search(tweets_search) VALUES('optimize')"); err != nil {
+		return fmt.Errorf("couldn't optimize search index: %w", err)
+	}
+
+	return nil
+}
+
+// CheckSearchIndex verifies the full-text search index is consistent with the tweets and users tables.
+// A non-nil error means the index should be rebuilt.
+func (d *DB) CheckSearchIndex(ctx context.Context) error {
+	if _, err := d.execWrite(ctx, "INSERT INTO tweets_search(tweets_search, rank) VALUES('integrity-check', 1)"); err != nil {
+		return fmt.Errorf("search index failed integrity check: %w", err)
+	}
+
+	return nil
 }
 
-// SetTweetCount counts the tweets in the database and stores it in memory.
+// SetTweetCount reads the count of tweets in the database, kept up to date by triggers, and stores it in memory.
+// The count is only queried again once it's been invalidated in the read cache, when that's enabled.
 func (d *DB) SetTweetCount(ctx context.Context) error {
-	stmt := `SELECT count(*) FROM tweets`
+	ctx, cancel := d.withQueryTimeout(ctx)
+	defer cancel()
+
+	cached, gen, ok := d.cache.get(tweetCountCacheKey)
+	if ok {
+		atomic.SwapUint32(&d.tweetCount, cached.(uint32))
+		return nil
+	}
+
+	stmt := `SELECT count FROM row_counts WHERE name = 'tweets'`
 	out := uint32(0)
 	if err := d.conn.QueryRowContext(ctx, stmt).Scan(&out); err != nil {
 		return fmt.Errorf("failed to get tweet count: %w", err)
 	}
 
 	atomic.SwapUint32(&d.tweetCount, out)
+	d.cache.put(tweetCountCacheKey, gen, out)
 
 	return nil
 }
@@ -561,3 +1010,210 @@ func (d *DB) SetTweetCount(ctx context.Context) error {
 func (d *DB) GetTweetCount() uint32 {
 	return atomic.LoadUint32(&d.tweetCount)
 }
+
+// PageBounds returns the 1-indexed page and the page size that listings return when asked for page and perPage:
+// pages before the first are the first, and perPage is clamped to EntriesPerPageMin and EntriesPerPageMax.
+func (d *DB) PageBounds(page, perPage int) (int, int) {
+	if perPage < d.EntriesPerPageMin {
+		perPage = d.EntriesPerPageMin
+	}
+	if perPage > d.EntriesPerPageMax {
+		perPage = d.EntriesPerPageMax
+	}
+	if page < 1 {
+		page = 1
+	}
+
+	return page, perPage
+}
+
+// paginationWindow clamps perPage to the configured limits and returns the
+// bounds of the requested 1-indexed page, for use with ROW_NUMBER() as: floor < set_id <= ceil.
+func (d *DB) paginationWindow(page, perPage int) (int, int) {
+	page, perPage = d.PageBounds(page, perPage)
+	idFloor := (page - 1) * perPage
+
+	return idFloor, idFloor + perPage
+}
+
+// normalizeSearchTerm composes the term to NFC so it matches text regardless of how its accents were encoded.
+// Case and diacritics are folded by the search index's tokenizer.
+func normalizeSearchTerm(term string) string {
+	return strings.TrimSpace(norm.NFC.String(term))
+}
+
+// scanTweetRows reads rows in the form of id, user_id, nick, url, dt, body, hidden, hash, subject, mentions, tags, utc_offset, lang
+// into tweets with their mentions and tags populated. Rows that fail to scan are skipped.
+func (d *DB) scanTweetRows(rows *sql.Rows) ([]Tweet, error) {
+	tweets := make([]Tweet, 0)
+	for rows.Next() {
+		dt := int64(0)
+		var subject, mentions, tags, lang sql.NullString
+		var offset sql.NullInt64
+		thisTweet := Tweet{}
+		err := rows.Scan(&thisTweet.ID, &thisTweet.UserID, &thisTweet.Nickname, &thisTweet.URL, &dt, &thisTweet.Body, &thisTweet.Hidden, &thisTweet.Hash,
+			&subject, &mentions, &tags, &offset, &lang)
+		if err != nil {
+			d.logger.Debugf("when scanning tweet row: %s", err)
+			continue
+		}
+		thisTweet.setDateTime(dt, offset)
+		thisTweet.loadEntities(subject, mentions, tags)
+		thisTweet.Lang = lang.String
+		tweets = append(tweets, thisTweet)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("when reading tweet rows: %w", err)
+	}
+
+	return tweets, nil
+}
+
+// scanCollapsedTweetRows is scanTweetRows for lists across feeds, which leave out tweets duplicating a mirror's
+// and give the rest their alternates.
+func (d *DB) scanCollapsedTweetRows(ctx context.Context, rows *sql.Rows) ([]Tweet, error) {
+	tweets, err := d.scanTweetRows(rows)
+	if err != nil {
+		return nil, err
+	}
+	if err := d.loadAlternates(ctx, tweets); err != nil {
+		return nil, err
+	}
+
+	return tweets, nil
+}
+
+// parseMentionsAndTags fills in the tweet's mentions, tags, and subject from its body.
+func (t *Tweet) parseMentionsAndTags() {
+	mentions := RegexTweetContainsMentions.FindAllStringSubmatch(t.Body, -1)
+	t.Mentions = make([]Mention, 0, len(mentions))
+	for _, mention := range mentions {
+		if len(mention) < 3 {
+			continue
+		}
+		// first is the whole mention, we want the capture groups
+		t.Mentions = append(t.Mentions, Mention{
+			Nickname: mention[1],
+			URL:      mention[2],
+		})
+	}
+	t.Tags = tweetTags(t.Body)
+	t.Subject = tweetSubject(t.Body)
+}
+
+// tweetEntities returns the body's mentions and tags as stored in the mentions and tags columns:
+// one "nick url" pair per line, and space-separated tags.
+func tweetEntities(body string) (string, string) {
+	t := Tweet{Body: body}
+	t.parseMentionsAndTags()
+
+	mentions := make([]string, 0, len(t.Mentions))
+	for _, m := range t.Mentions {
+		mentions = append(mentions, m.Nickname+" "+m.URL)
+	}
+
+	return strings.Join(mentions, "\n"), strings.Join(t.Tags, " ")
+}
+
+// setDateTime sets the tweet's timestamp from the stored nanoseconds since the epoch, in its original UTC offset when that's known.
+func (t *Tweet) setDateTime(dt int64, offset sql.NullInt64) {
+	t.DateTime = time.Unix(0, dt)
+	if offset.Valid {
+		t.DateTime = t.DateTime.In(time.FixedZone("", int(offset.Int64)))
+	}
+	_, t.UTCOffset = t.DateTime.Zone()
+}
+
+// loadEntities fills in the tweet's mentions, tags, and subject from the values stored when it was inserted,
+// only parsing its body if they haven't been stored yet.
+func (t *Tweet) loadEntities(subject, mentions, tags sql.NullString) {
+	if !mentions.Valid || !tags.Valid {
+		t.parseMentionsAndTags()
+		return
+	}
+
+	t.Mentions = make([]Mention, 0)
+	for _, line := range strings.Split(mentions.String, "\n") {
+		nick, mentionURL, ok := strings.Cut(line, " ")
+		if !ok {
+			continue
+		}
+		t.Mentions = append(t.Mentions, Mention{
+			Nickname: nick,
+			URL:      mentionURL,
+		})
+	}
+	t.Tags = strings.Fields(tags.String)
+	if t.Tags == nil {
+		t.Tags = make([]string, 0)
+	}
+	if subject.Valid {
+		t.Subject = subject.String
+	} else {
+		t.Subject = tweetSubject(t.Body)
+	}
+}
+
+// BackfillTweetEntities stores the mentions and tags of tweets inserted before they were stored.
+// Returns the number of tweets updated.
+func (d *DB) BackfillTweetEntities(ctx context.Context) (int64, error) {
+	parsed := int64(0)
+	for {
+		n, err := d.backfillTweetEntitiesBatch(ctx)
+		if err != nil {
+			return parsed, err
+		}
+		parsed += n
+		if n < backfillTweetsBatchSize {
+			break
+		}
+	}
+	if parsed > 0 {
+		d.invalidate()
+	}
+
+	return parsed, nil
+}
+
+func (d *DB) backfillTweetEntitiesBatch(ctx context.Context) (int64, error) {
+	tx, err := d.beginWrite(ctx)
+	if err != nil {
+		return 0, fmt.Errorf("when beginning tx to backfill tweet mentions and tags: %w", err)
+	}
+	defer func() {
+		_ = tx.Rollback()
+	}()
+
+	rows, err := tx.QueryContext(ctx, "SELECT id, body FROM tweets WHERE mentions IS NULL OR tags IS NULL LIMIT ?", backfillTweetsBatchSize)
+	if err != nil {
+		return 0, fmt.Errorf("when querying for tweets without mentions and tags: %w", err)
+	}
+	bodies := make(map[string]string, backfillTweetsBatchSize)
+	for rows.Next() {
+		id := ""
+		body := ""
+		if err := rows.Scan(&id, &body); err != nil {
+			d.logger.Debugf("when scanning tweet to parse mentions and tags: %s", err)
+			continue
+		}
+		bodies[id] = body
+	}
+	err = rows.Err()
+	_ = rows.Close()
+	if err != nil {
+		return 0, fmt.Errorf("when reading tweets without mentions and tags: %w", err)
+	}
+
+	for id, body := range bodies {
+		mentions, tags := tweetEntities(body)
+		if _, err := tx.ExecContext(ctx, "UPDATE tweets SET mentions = ?, tags = ? WHERE id = ?", mentions, tags, id); err != nil {
+			return 0, fmt.Errorf("when storing mentions and tags of tweet %s: %w", id, err)
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return 0, fmt.Errorf("when committing tx to backfill tweet mentions and tags: %w", err)
+	}
+
+	return int64(len(bodies)), nil
+}
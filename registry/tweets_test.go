@@ -22,7 +22,9 @@ along with getwtxt-ng.  If not, see <https://www.gnu.org/licenses/>.
 import (
 	"context"
 	"database/sql"
+	"database/sql/driver"
 	"errors"
+	"fmt"
 	"log"
 	"reflect"
 	"strings"
@@ -36,52 +38,91 @@ func TestDB_InsertTweets(t *testing.T) {
 	memDB := getPopulatedDB(t)
 	mockDB, mock := getDBMocker(t)
 	ctx := context.Background()
-	insertStmt := "INSERT OR IGNORE INTO tweets (user_id, dt, body, contains_mentions, contains_tags) VALUES(?,?,?,?,?)"
 
 	t.Run("no tweets provided", func(t *testing.T) {
-		err := mockDB.InsertTweets(ctx, nil)
+		_, err := mockDB.InsertTweets(ctx, nil)
 		if !strings.Contains(err.Error(), "invalid tweets provided") {
 			t.Errorf("Expected invalid tweets error, got: %s", err)
 		}
 	})
 
+	t.Run("fail to prepare stmt", func(t *testing.T) {
+		batch := make([]Tweet, insertTweetsBatchSize)
+		copy(batch, populatedDBTweets)
+		mock.ExpectPrepare(insertTweetsQuery(insertTweetsBatchSize)).
+			WillReturnError(sql.ErrConnDone)
+		_, err := mockDB.InsertTweets(ctx, batch)
+		if !errors.Is(err, sql.ErrConnDone) {
+			t.Errorf("Expected sql.ErrConnDone, got: %s", err)
+		}
+	})
+
 	t.Run("fail to begin tx", func(t *testing.T) {
 		mock.ExpectBegin().WillReturnError(sql.ErrConnDone)
-		err := mockDB.InsertTweets(ctx, populatedDBTweets)
+		_, err := mockDB.InsertTweets(ctx, populatedDBTweets)
 		if !errors.Is(err, sql.ErrConnDone) {
 			t.Errorf("Expected sql.ErrConnDone, got: %s", err)
 		}
 	})
 
-	t.Run("fail to prepare stmt", func(t *testing.T) {
+	t.Run("fail to insert tweets", func(t *testing.T) {
+		args := make([]driver.Value, 0, len(populatedDBTweets)*12)
+		for _, tw := range populatedDBTweets {
+			_, offset := tw.DateTime.Zone()
+			args = append(args, tw.UserID, tw.DateTime.UnixNano(), tw.Body, 0, 0, sqlmock.AnyArg(), sqlmock.AnyArg(), "", "", "", offset, DetectLanguage(tw.Body))
+		}
 		mock.ExpectBegin()
-		mock.ExpectPrepare(insertStmt).
+		mock.ExpectQuery("SELECT id, url, nick FROM users WHERE id IN (?,?)").
+			WithArgs("1", "2").
+			WillReturnRows(sqlmock.NewRows([]string{"id", "url", "nick"}).
+				AddRow("1", populatedDBUsers[0].URL, populatedDBUsers[0].Nick).
+				AddRow("2", populatedDBUsers[1].URL, populatedDBUsers[1].Nick))
+		mock.ExpectQuery(insertTweetsQuery(len(populatedDBTweets))).
+			WithArgs(args...).
 			WillReturnError(sql.ErrTxDone)
 		mock.ExpectRollback()
-		err := mockDB.InsertTweets(ctx, populatedDBTweets)
+		_, err := mockDB.InsertTweets(ctx, populatedDBTweets)
 		if !errors.Is(err, sql.ErrTxDone) {
 			t.Errorf("Expected sql.ErrTxDone, got: %s", err)
 		}
 	})
 
-	t.Run("fail to insert tweets", func(t *testing.T) {
-		mock.ExpectBegin()
-		stmt := mock.ExpectPrepare(insertStmt)
-		stmt.ExpectExec().
-			WithArgs(populatedDBTweets[0].ID, populatedDBTweets[0].DateTime.UnixNano(), populatedDBTweets[0].Body, 0, 0).
-			WillReturnError(sql.ErrTxDone)
-		mock.ExpectRollback()
-		err := mockDB.InsertTweets(ctx, populatedDBTweets)
-		if !errors.Is(err, sql.ErrTxDone) {
-			t.Errorf("Expected sql.ErrTxDone, got: %s", err)
+	t.Run("insert more than one batch", func(t *testing.T) {
+		memDB := getPopulatedDB(t)
+		now := time.Now().UTC()
+		tweets := make([]Tweet, insertTweetsBatchSize*2+3)
+		for i := range tweets {
+			tweets[i] = Tweet{
+				UserID:   "1",
+				DateTime: now.Add(time.Duration(-i) * time.Second),
+				Body:     fmt.Sprintf("tweet %d", i),
+			}
+		}
+		res, err := memDB.InsertTweets(ctx, tweets)
+		if err != nil {
+			t.Fatal(err.Error())
+		}
+		if res.Inserted != len(tweets) || res.Ignored != 0 || len(res.IDs) != len(tweets) {
+			t.Errorf("Expected all tweets to be inserted, got %d inserted, %d ignored, %d IDs", res.Inserted, res.Ignored, len(res.IDs))
+		}
+		count := 0
+		if err := memDB.conn.QueryRow("SELECT count(*) FROM tweets WHERE user_id = 1").Scan(&count); err != nil {
+			t.Fatal(err.Error())
+		}
+		if count != len(tweets)+1 {
+			t.Errorf("Got %d tweets, expected %d", count, len(tweets)+1)
 		}
 	})
 
 	t.Run("insert tweets", func(t *testing.T) {
-		err := memDB.InsertTweets(ctx, populatedDBTweets)
+		res, err := memDB.InsertTweets(ctx, populatedDBTweets)
 		if err != nil {
 			t.Error(err.Error())
 		}
+		// They're already present in the populated database.
+		if res.Inserted != 0 || res.Ignored != len(populatedDBTweets) || len(res.IDs) != 0 {
+			t.Errorf("Expected all tweets to be ignored, got: %+v", res)
+		}
 		outTweets := make([]Tweet, 0)
 		getAllTweets := "SELECT id, user_id, dt, body, hidden FROM tweets"
 		rows, err := memDB.conn.Query(getAllTweets)
@@ -111,7 +152,7 @@ func TestDB_InsertTweets(t *testing.T) {
 	t.Run("canceled context", func(t *testing.T) {
 		ctx, cancel := context.WithCancel(context.Background())
 		cancel()
-		err := memDB.InsertTweets(ctx, populatedDBTweets)
+		_, err := memDB.InsertTweets(ctx, populatedDBTweets)
 		if err == nil {
 			t.Error("expected error, got none")
 		}
@@ -122,6 +163,102 @@ func TestDB_InsertTweets(t *testing.T) {
 	}
 }
 
+func TestInsertTweetsQuery(t *testing.T) {
+	params := strings.Count(insertTweetsQuery(insertTweetsBatchSize), "?")
+	if params != insertTweetsBatchSize*len(insertTweetsColumns) {
+		t.Errorf("Expected %d parameters per row, got %d in all", len(insertTweetsColumns), params)
+	}
+	if params > sqliteMaxVariables {
+		t.Errorf("A full batch binds %d parameters, more than SQLite's %d", params, sqliteMaxVariables)
+	}
+}
+
+func TestDB_InsertTweets_Edits(t *testing.T) {
+	ctx := context.Background()
+	original := populatedDBTweets[0]
+
+	t.Run("changed body is an edit", func(t *testing.T) {
+		memDB := getPopulatedDB(t)
+		edit := Tweet{UserID: original.UserID, DateTime: original.DateTime, Body: "hallo this is dog, edited"}
+		res, err := memDB.InsertTweets(ctx, []Tweet{edit})
+		if err != nil {
+			t.Fatal(err.Error())
+		}
+		if res.Inserted != 0 || res.Edited != 1 || res.Ignored != 0 {
+			t.Errorf("Expected one edit, got: %+v", res)
+		}
+
+		out, err := memDB.GetTweetsByID(ctx, []string{original.ID})
+		if err != nil {
+			t.Fatal(err.Error())
+		}
+		if len(out) != 1 || out[0].Body != edit.Body {
+			t.Errorf("Expected tweet %s to have the edited body, got: %+v", original.ID, out)
+		}
+		found, err := memDB.SearchTweets(ctx, 1, 20, "edited", StatusVisible)
+		if err != nil {
+			t.Fatal(err.Error())
+		}
+		if len(found) != 1 || found[0].ID != original.ID {
+			t.Errorf("Expected the search index to hold the edit, got: %+v", found)
+		}
+
+		revisions, err := memDB.GetTweetRevisions(ctx, original.ID)
+		if err != nil {
+			t.Fatal(err.Error())
+		}
+		if len(revisions) != 1 || revisions[0].Body != original.Body {
+			t.Errorf("Expected the original body as a revision, got: %+v", revisions)
+		}
+
+		// Seeing the edit again changes nothing.
+		res, err = memDB.InsertTweets(ctx, []Tweet{edit})
+		if err != nil {
+			t.Fatal(err.Error())
+		}
+		if res.Ignored != 1 {
+			t.Errorf("Expected the edit to be ignored the second time, got: %+v", res)
+		}
+		if err := memDB.CheckSearchIndex(ctx); err != nil {
+			t.Error(err.Error())
+		}
+	})
+
+	t.Run("tweets sharing a new timestamp are separate", func(t *testing.T) {
+		memDB := getPopulatedDB(t)
+		dt := time.Now().UTC()
+		tweets := []Tweet{
+			{UserID: "1", DateTime: dt, Body: "first"},
+			{UserID: "1", DateTime: dt, Body: "second"},
+		}
+		res, err := memDB.InsertTweets(ctx, tweets)
+		if err != nil {
+			t.Fatal(err.Error())
+		}
+		if res.Inserted != 2 || res.Edited != 0 {
+			t.Errorf("Expected two new tweets, got: %+v", res)
+		}
+	})
+
+	t.Run("revisions are removed with their tweet", func(t *testing.T) {
+		memDB := getPopulatedDB(t)
+		edit := Tweet{UserID: original.UserID, DateTime: original.DateTime, Body: "edited again"}
+		if _, err := memDB.InsertTweets(ctx, []Tweet{edit}); err != nil {
+			t.Fatal(err.Error())
+		}
+		if _, err := memDB.DeleteTweets(ctx, []string{original.ID}); err != nil {
+			t.Fatal(err.Error())
+		}
+		revisions, err := memDB.GetTweetRevisions(ctx, original.ID)
+		if err != nil {
+			t.Fatal(err.Error())
+		}
+		if len(revisions) != 0 {
+			t.Errorf("Expected no revisions left, got %d", len(revisions))
+		}
+	})
+}
+
 func TestDB_ToggleTweetHiddenStatus(t *testing.T) {
 	memDB := getPopulatedDB(t)
 	mockDB, mock := getDBMocker(t)
@@ -207,6 +344,205 @@ func TestDB_ToggleTweetHiddenStatus(t *testing.T) {
 	}
 }
 
+func TestDB_SetUserTweetsVisibility(t *testing.T) {
+	memDB := getPopulatedDB(t)
+	ctx := context.Background()
+
+	if _, err := memDB.SetUserTweetsVisibility(ctx, "", StatusHidden); err == nil {
+		t.Error("Expected an error without a user ID")
+	}
+
+	countHidden := func(userID string) int64 {
+		t.Helper()
+		n := int64(0)
+		if err := memDB.conn.QueryRow("SELECT count(*) FROM tweets WHERE user_id = ? AND hidden = ?", userID, StatusHidden).Scan(&n); err != nil {
+			t.Fatal(err.Error())
+		}
+		return n
+	}
+
+	hiddenOthers := countHidden("2")
+	changed, err := memDB.SetUserTweetsVisibility(ctx, "1", StatusHidden)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	if changed < 1 || countHidden("1") != changed {
+		t.Errorf("Expected all %d of user 1's tweets to be hidden, %d are", changed, countHidden("1"))
+	}
+	if countHidden("2") != hiddenOthers {
+		t.Errorf("Expected user 2's tweets to be left alone, %d are hidden rather than %d", countHidden("2"), hiddenOthers)
+	}
+
+	again, err := memDB.SetUserTweetsVisibility(ctx, "1", StatusHidden)
+	if err != nil || again != 0 {
+		t.Errorf("Expected hiding again to change nothing, got %d, %v", again, err)
+	}
+
+	shown, err := memDB.SetUserTweetsVisibility(ctx, "1", StatusVisible)
+	if err != nil || shown != changed || countHidden("1") != 0 {
+		t.Errorf("Expected %d tweets to be unhidden, got %d, %v", changed, shown, err)
+	}
+}
+
+func TestDB_SetTweetsVisibility(t *testing.T) {
+	memDB := getPopulatedDB(t)
+	ctx := context.Background()
+	original := populatedDBTweets[0]
+
+	if _, err := memDB.SetTweetsVisibility(ctx, nil, StatusHidden); err == nil {
+		t.Error("Expected an error without tweet IDs")
+	}
+
+	// A second twt with the same author and timestamp.
+	res, err := memDB.InsertTweets(ctx, []Tweet{original, {UserID: original.UserID, DateTime: original.DateTime, Body: "same second"}})
+	if err != nil || len(res.IDs) != 1 {
+		t.Fatalf("Expected a new tweet sharing the timestamp, got %+v, %v", res, err)
+	}
+	sibling := res.IDs[0]
+
+	changed, err := memDB.SetTweetsVisibility(ctx, []string{original.ID, "nonexistent"}, StatusHidden)
+	if err != nil || changed != 1 {
+		t.Errorf("Expected one tweet to be hidden, got %d, %v", changed, err)
+	}
+	out, err := memDB.GetTweetsByID(ctx, []string{original.ID, sibling})
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	for _, tw := range out {
+		if tw.ID == original.ID && tw.Hidden != StatusHidden {
+			t.Errorf("Expected tweet %s to be hidden", tw.ID)
+		}
+		if tw.ID == sibling && tw.Hidden != StatusVisible {
+			t.Errorf("Expected tweet %s with the same timestamp to be left visible", tw.ID)
+		}
+	}
+
+	again, err := memDB.SetTweetsVisibility(ctx, []string{original.ID}, StatusHidden)
+	if err != nil || again != 0 {
+		t.Errorf("Expected hiding again to change nothing, got %d, %v", again, err)
+	}
+}
+
+func TestDB_GetTweetsByID(t *testing.T) {
+	memDB := getPopulatedDB(t)
+	ctx := context.Background()
+
+	t.Run("no IDs", func(t *testing.T) {
+		if _, err := memDB.GetTweetsByID(ctx, nil); err == nil {
+			t.Error("expected error, got none")
+		}
+	})
+
+	t.Run("get tweets", func(t *testing.T) {
+		out, err := memDB.GetTweetsByID(ctx, []string{"1", "3", "500"})
+		if err != nil {
+			t.Fatal(err.Error())
+		}
+		if len(out) != 2 {
+			t.Fatalf("Expected 2 tweets, got %d", len(out))
+		}
+		if out[0].ID != "3" || out[1].ID != "1" {
+			t.Errorf("Got unexpected tweets or order: %s, %s", out[0].ID, out[1].ID)
+		}
+		if out[0].Hidden != StatusHidden {
+			t.Error("Expected hidden tweet to be returned with its hidden status")
+		}
+	})
+}
+
+func TestDB_InsertTweets_UTCOffset(t *testing.T) {
+	memDB := getPopulatedDB(t)
+	ctx := context.Background()
+
+	dt, err := ParseTwtTime("2022-10-19T15:04:05+0530")
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	res, err := memDB.InsertTweets(ctx, []Tweet{{UserID: "1", DateTime: dt, Body: "namaste"}})
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	if len(res.IDs) != 1 {
+		t.Fatalf("Expected one tweet inserted, got %d", len(res.IDs))
+	}
+
+	out, err := memDB.GetTweetsByID(ctx, res.IDs)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	if len(out) != 1 {
+		t.Fatalf("Expected the inserted tweet, got %d tweets", len(out))
+	}
+	if out[0].UTCOffset != 19800 {
+		t.Errorf("Expected an offset of 19800 seconds, got %d", out[0].UTCOffset)
+	}
+	if got := out[0].DateTime.Format(time.RFC3339); got != "2022-10-19T15:04:05+05:30" {
+		t.Errorf("Expected the timestamp in its original offset, got %s", got)
+	}
+}
+
+func TestDB_GetUserTweets(t *testing.T) {
+	memDB := getPopulatedDB(t)
+	ctx := context.Background()
+
+	out, err := memDB.GetUserTweets(ctx, "2", 10)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	if len(out) != 1 || out[0].ID != "2" {
+		t.Errorf("Expected only visible tweet 2, got: %v", out)
+	}
+
+	out, err = memDB.GetUserTweets(ctx, "500", 10)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	if len(out) != 0 {
+		t.Errorf("Expected no tweets for unknown user, got: %v", out)
+	}
+}
+
+func TestDB_DeleteTweets(t *testing.T) {
+	memDB := getPopulatedDB(t)
+	mockDB, mock := getDBMocker(t)
+	ctx := context.Background()
+
+	t.Run("no IDs", func(t *testing.T) {
+		if _, err := memDB.DeleteTweets(ctx, nil); err == nil {
+			t.Error("expected error, got none")
+		}
+	})
+
+	t.Run("fail to begin tx", func(t *testing.T) {
+		mock.ExpectBegin().WillReturnError(sql.ErrConnDone)
+		_, err := mockDB.DeleteTweets(ctx, []string{"1"})
+		if !errors.Is(err, sql.ErrConnDone) {
+			t.Errorf("Expected sql.ErrConnDone, got: %s", err)
+		}
+	})
+
+	t.Run("delete tweets", func(t *testing.T) {
+		n, err := memDB.DeleteTweets(ctx, []string{"1", "2", "500"})
+		if err != nil {
+			t.Fatal(err.Error())
+		}
+		if n != 2 {
+			t.Errorf("Expected 2 tweets deleted, got %d", n)
+		}
+		out, err := memDB.GetTweetsByID(ctx, []string{"1", "2", "3"})
+		if err != nil {
+			t.Fatal(err.Error())
+		}
+		if len(out) != 1 || out[0].ID != "3" {
+			t.Errorf("Got unexpected remaining tweets: %v", out)
+		}
+	})
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Error(err.Error())
+	}
+}
+
 func TestDB_GetTweets(t *testing.T) {
 	memDB := getPopulatedDB(t)
 	mockDB, mock := getDBMocker(t)
@@ -219,13 +555,23 @@ func TestDB_GetTweets(t *testing.T) {
 		}
 	}
 
-	tweetStmt := `SELECT id, user_id, nick, url, dt, body, hidden
+	tweetStmt := `SELECT id, user_id, nick, url, dt, body, hidden, hash, subject, mentions, tags, utc_offset, lang
 					FROM (SELECT tweets.*, users.nick AS nick, users.url AS url, ROW_NUMBER() OVER (ORDER BY dt DESC) AS set_id
-					      FROM tweets LEFT JOIN users ON users.id = tweets.user_id WHERE tweets.hidden = ?)
+					      FROM tweets LEFT JOIN users ON users.id = tweets.user_id WHERE tweets.hidden = ? AND tweets.duplicate_of IS NULL AND users.status = 'active')
 					WHERE set_id > ?
   					AND set_id <= ?`
 
+	t.Run("fail to prepare", func(t *testing.T) {
+		mock.ExpectPrepare(tweetStmt).
+			WillReturnError(sql.ErrConnDone)
+		_, err := mockDB.GetTweets(ctx, 1, 20, StatusVisible)
+		if !errors.Is(err, sql.ErrConnDone) {
+			t.Errorf("Expected sql.ErrConnDone, got: %s", err)
+		}
+	})
+
 	t.Run("error on query", func(t *testing.T) {
+		mock.ExpectPrepare(tweetStmt)
 		mock.ExpectQuery(tweetStmt).
 			WithArgs(StatusVisible, 0, 20).
 			WillReturnError(sql.ErrNoRows)
@@ -274,15 +620,81 @@ func TestDB_GetTweets(t *testing.T) {
 	}
 }
 
+func TestDB_GetTweetsSince(t *testing.T) {
+	memDB := getPopulatedDB(t)
+	ctx := context.Background()
+
+	now := time.Now().UTC()
+	tweets := make([]Tweet, 25)
+	for i := range tweets {
+		tweets[i] = Tweet{
+			UserID:   "1",
+			DateTime: now.Add(time.Duration(-i) * time.Minute),
+			Body:     fmt.Sprintf("incremental %d", i),
+		}
+	}
+	if _, err := memDB.InsertTweets(ctx, tweets); err != nil {
+		t.Fatal(err.Error())
+	}
+	// The populated tweets predate ingestion tracking, and only two of them are visible.
+	total := len(tweets) + 2
+
+	seen := 0
+	since := time.Time{}
+	for page := 0; page < 3; page++ {
+		out, err := memDB.GetTweetsSince(ctx, since, 1)
+		if err != nil {
+			t.Fatal(err.Error())
+		}
+		if len(out) == 0 {
+			break
+		}
+		for i, tw := range out {
+			if tw.Hidden != StatusVisible {
+				t.Errorf("Got hidden tweet %s", tw.ID)
+			}
+			if i > 0 && tw.Ingested.Before(out[i-1].Ingested) {
+				t.Errorf("Tweets out of ingestion order: %s then %s", out[i-1].Ingested, tw.Ingested)
+			}
+		}
+		seen += len(out)
+		since = out[len(out)-1].Ingested
+	}
+	if seen != total {
+		t.Errorf("Expected %d tweets across pages, got %d", total, seen)
+	}
+
+	out, err := memDB.GetTweetsSince(ctx, since, 20)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	if len(out) != 0 {
+		t.Errorf("Expected no tweets after the last watermark, got %d", len(out))
+	}
+
+	t.Run("canceled context", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+		if _, err := memDB.GetTweetsSince(ctx, since, 20); err == nil {
+			t.Error("expected error, got none")
+		}
+	})
+}
+
 func TestDB_SearchTweets(t *testing.T) {
 	mockDB, mock := getDBMocker(t)
 	ctx := context.Background()
-	searchStmt := `SELECT id, user_id, nick, url, dt, body, hidden
+	searchStmt := `SELECT page.id, page.user_id, page.nick, page.url, page.dt, page.body, page.hidden, tweets.hash, tweets.subject, tweets.mentions, tweets.tags, tweets.utc_offset, tweets.lang
 					FROM (SELECT *, ROW_NUMBER() OVER (ORDER BY dt DESC) AS set_id
-					      FROM tweets_search WHERE tweets_search.hidden = ? AND body MATCH ?)
-					WHERE set_id > ? AND set_id <= ?`
+					      FROM tweets_search WHERE tweets_search.hidden = ? AND body MATCH ?
+					      AND user_id IN (SELECT id FROM users WHERE status = 'active')
+					      AND id NOT IN (SELECT id FROM tweets WHERE duplicate_of IS NOT NULL)) AS page
+					JOIN tweets ON tweets.id = page.id
+					WHERE set_id > ? AND set_id <= ?
+					ORDER BY set_id`
 
 	t.Run("fail to query", func(t *testing.T) {
+		mock.ExpectPrepare(searchStmt)
 		mock.ExpectQuery(searchStmt).
 			WithArgs(StatusVisible, "foo", 0, 20).
 			WillReturnError(sql.ErrNoRows)
@@ -327,6 +739,27 @@ func TestDB_SearchTweets(t *testing.T) {
 		}
 	})
 
+	t.Run("case and accent insensitive", func(t *testing.T) {
+		memDB := getPopulatedDB(t)
+		now := time.Now().UTC()
+		tweets := []Tweet{
+			{UserID: "1", DateTime: now, Body: "Meet me at the Café"},
+			{UserID: "1", DateTime: now.Add(-time.Minute), Body: "the cafe\u0301 was closed"},
+		}
+		if _, err := memDB.InsertTweets(ctx, tweets); err != nil {
+			t.Fatal(err.Error())
+		}
+		for _, term := range []string{"café", "CAFÉ", "cafe", "cafe\u0301", " Café "} {
+			out, err := memDB.SearchTweets(ctx, 1, 10, term, StatusVisible)
+			if err != nil {
+				t.Fatal(err.Error())
+			}
+			if len(out) != len(tweets) {
+				t.Errorf("Searching for %q: expected %d tweets, got %d", term, len(tweets), len(out))
+			}
+		}
+	})
+
 	t.Run("canceled context", func(t *testing.T) {
 		ctx, cancel := context.WithCancel(context.Background())
 		cancel()
@@ -343,3 +776,189 @@ func TestDB_SearchTweets(t *testing.T) {
 		t.Error(err.Error())
 	}
 }
+
+func TestDB_RebuildSearchIndex(t *testing.T) {
+	memDB := getPopulatedDB(t)
+	ctx := context.Background()
+
+	if _, err := memDB.conn.Exec("INSERT INTO tweets_search(tweets_search) VALUES('delete-all')"); err != nil {
+		t.Fatal(err.Error())
+	}
+	if err := memDB.CheckSearchIndex(ctx); err == nil {
+		t.Error("Expected emptied search index to fail integrity check")
+	}
+
+	if err := memDB.RebuildSearchIndex(ctx); err != nil {
+		t.Fatal(err.Error())
+	}
+	if err := memDB.CheckSearchIndex(ctx); err != nil {
+		t.Error(err.Error())
+	}
+
+	out, err := memDB.SearchTweets(ctx, 1, 20, "dog", StatusVisible)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	if len(out) != 1 {
+		t.Errorf("Expected 1 tweet after rebuilding index, got %d", len(out))
+	}
+}
+
+func TestDB_DeleteTweetsOlderThan(t *testing.T) {
+	memDB := getPopulatedDB(t)
+	ctx := context.Background()
+	cutoff := time.Now().UTC().AddDate(0, 0, -1)
+
+	count, err := memDB.CountTweetsOlderThan(ctx, cutoff)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	if count != int64(len(populatedDBTweets)) {
+		t.Errorf("Expected %d tweets, got %d", len(populatedDBTweets), count)
+	}
+
+	// A batch size of 1 makes sure we loop until everything is gone.
+	deleted, err := memDB.DeleteTweetsOlderThan(ctx, cutoff, 1)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	if deleted != count {
+		t.Errorf("Expected %d tweets deleted, got %d", count, deleted)
+	}
+
+	count, err = memDB.CountTweetsOlderThan(ctx, cutoff)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	if count != 0 {
+		t.Errorf("Expected no tweets left, got %d", count)
+	}
+}
+
+func TestDB_paginationWindow(t *testing.T) {
+	db := &DB{
+		EntriesPerPageMin: 20,
+		EntriesPerPageMax: 1000,
+	}
+	cases := []struct {
+		name          string
+		page, perPage int
+		floor, ceil   int
+	}{
+		{name: "first page", page: 1, perPage: 50, floor: 0, ceil: 50},
+		{name: "third page", page: 3, perPage: 50, floor: 100, ceil: 150},
+		{name: "page zero", page: 0, perPage: 50, floor: 0, ceil: 50},
+		{name: "below minimum", page: 2, perPage: 5, floor: 20, ceil: 40},
+		{name: "above maximum", page: 1, perPage: 5000, floor: 0, ceil: 1000},
+	}
+	for _, tt := range cases {
+		t.Run(tt.name, func(t *testing.T) {
+			floor, ceil := db.paginationWindow(tt.page, tt.perPage)
+			if floor != tt.floor || ceil != tt.ceil {
+				t.Errorf("Got %d - %d, expected %d - %d", floor, ceil, tt.floor, tt.ceil)
+			}
+		})
+	}
+}
+
+func BenchmarkDB_InsertTweets(b *testing.B) {
+	ctx := context.Background()
+	now := time.Now().UTC()
+	tweets := make([]Tweet, 20000)
+	for i := range tweets {
+		tweets[i] = Tweet{
+			UserID:   "1",
+			DateTime: now.Add(time.Duration(-i) * time.Minute),
+			Body:     fmt.Sprintf("tweet number %d with a #tag and @<foo https://example.com/twtxt.txt>", i),
+		}
+	}
+
+	for i := 0; i < b.N; i++ {
+		b.StopTimer()
+		db, err := Open(":memory:")
+		if err != nil {
+			b.Fatal(err.Error())
+		}
+		b.StartTimer()
+		if _, err := db.InsertTweets(ctx, tweets); err != nil {
+			b.Fatal(err.Error())
+		}
+		b.StopTimer()
+		_ = db.conn.Close()
+	}
+}
+
+func TestDB_BackfillTweetEntities(t *testing.T) {
+	db := getPopulatedDB(t)
+	defer func() {
+		_ = db.Close()
+	}()
+	ctx := context.Background()
+
+	// Stored as it would have been before mentions and tags were.
+	body := "hi @<foo https://foo.example/twtxt.txt> and @<bar https://bar.example/twtxt.txt> #one #two"
+	tweetsStmt := "INSERT INTO tweets (id, user_id, dt, body, contains_mentions, contains_tags) VALUES (?,?,?,?,?,?)"
+	if _, err := db.conn.Exec(tweetsStmt, "10", "1", time.Now().UnixNano(), body, 1, 1); err != nil {
+		t.Fatal(err.Error())
+	}
+
+	parsed, err := db.BackfillTweetEntities(ctx)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	if parsed != int64(len(populatedDBTweets)+1) {
+		t.Errorf("Expected %d tweets parsed, got %d", len(populatedDBTweets)+1, parsed)
+	}
+
+	mentions := ""
+	tags := ""
+	if err := db.conn.QueryRow("SELECT mentions, tags FROM tweets WHERE id = 10").Scan(&mentions, &tags); err != nil {
+		t.Fatal(err.Error())
+	}
+	if mentions != "foo https://foo.example/twtxt.txt\nbar https://bar.example/twtxt.txt" || tags != "one two" {
+		t.Errorf("Unexpected stored mentions %q and tags %q", mentions, tags)
+	}
+
+	got, err := db.GetTweetsByID(ctx, []string{"10"})
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	want := Tweet{Body: body}
+	want.parseMentionsAndTags()
+	if len(got) != 1 || !reflect.DeepEqual(got[0].Mentions, want.Mentions) || !reflect.DeepEqual(got[0].Tags, want.Tags) {
+		t.Errorf("Expected stored mentions and tags to match parsed ones, got %+v", got)
+	}
+
+	again, err := db.BackfillTweetEntities(ctx)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	if again != 0 {
+		t.Errorf("Expected nothing left to backfill, got %d", again)
+	}
+}
+
+func TestFormatTweetsPlainWith(t *testing.T) {
+	dt := time.Date(2021, 1, 1, 0, 0, 0, 0, time.UTC)
+	tweets := []Tweet{{Nickname: "foo", URL: "https://example.com/twtxt.txt", DateTime: dt, Body: "one\ttwo\nthree\r\x1bfour"}}
+	prefix := "foo\thttps://example.com/twtxt.txt\t2021-01-01T00:00:00Z\t"
+
+	cases := []struct {
+		mode ControlCharMode
+		want string
+	}{
+		{mode: ControlCharsEscape, want: prefix + `one\ttwo\nthree\r\x1bfour` + "\n"},
+		{mode: ControlCharsReplace, want: prefix + "one two three  four\n"},
+	}
+	for _, tc := range cases {
+		if got := FormatTweetsPlainWith(tweets, PlainFormat{ControlChars: tc.mode}); got != tc.want {
+			t.Errorf("Expected %q with %s, got %q", tc.want, tc.mode, got)
+		}
+	}
+	if got := FormatTweetsPlain(tweets); got != cases[0].want {
+		t.Errorf("Expected bodies to be escaped by default, got %q", got)
+	}
+	if _, err := ParseControlCharMode("strip"); !errors.Is(err, ErrInvalidControlCharMode) {
+		t.Errorf("Expected ErrInvalidControlCharMode, got: %v", err)
+	}
+}
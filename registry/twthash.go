@@ -0,0 +1,192 @@
+package registry
+
+/*
+Copyright 2021 G. Benjamin Morrison
+
+This file is part of getwtxt-ng.
+
+getwtxt-ng is free software: you can redistribute it and/or modify
+it under the terms of the GNU Affero General Public License as
+published by the Free Software Foundation, either version 3 of the
+License, or (at your option) any later version.
+
+getwtxt-ng is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU Affero General Public License for more details.
+
+You should have received a copy of the GNU Affero General Public License
+along with getwtxt-ng.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+import (
+	"context"
+	"database/sql"
+	"encoding/base32"
+	"fmt"
+	"strings"
+	"time"
+
+	"golang.org/x/crypto/blake2b"
+)
+
+// twtHashLength is the number of characters of the encoded digest kept in a twt hash.
+const twtHashLength = 7
+
+// backfillTweetsBatchSize is the number of tweets updated per transaction when backfilling a column.
+const backfillTweetsBatchSize = 1000
+
+var twtHashEncoding = base32.StdEncoding.WithPadding(base32.NoPadding)
+
+// TwtHash computes the twt hash Yarn uses to identify a twt: the last seven characters of the
+// lowercase, unpadded base32 encoding of the blake2b-256 digest of the feed URL, the timestamp
+// in RFC3339 with seconds precision, and the body, separated by newlines.
+// The timestamp keeps its original offset, so it should be the one parsed from the feed.
+func TwtHash(feedURL string, created time.Time, body string) string {
+	payload := feedURL + "\n" + created.Format(time.RFC3339) + "\n" + body
+	sum := blake2b.Sum256([]byte(payload))
+	hash := strings.ToLower(twtHashEncoding.EncodeToString(sum[:]))
+
+	return hash[len(hash)-twtHashLength:]
+}
+
+// tweetAuthors looks up the URLs and nicknames of the users whose tweets don't carry them, keyed by user ID.
+func (d *DB) tweetAuthors(ctx context.Context, tx *sql.Tx, tweets []Tweet) (map[string]string, map[string]string, error) {
+	urls := make(map[string]string)
+	nicks := make(map[string]string)
+	args := make([]interface{}, 0)
+	for _, t := range tweets {
+		if t.URL != "" && t.Nickname != "" {
+			continue
+		}
+		if _, ok := urls[t.UserID]; ok {
+			continue
+		}
+		urls[t.UserID] = ""
+		args = append(args, t.UserID)
+	}
+	if len(args) == 0 {
+		return urls, nicks, nil
+	}
+
+	placeholders := strings.TrimSuffix(strings.Repeat("?,", len(args)), ",")
+	rows, err := tx.QueryContext(ctx, fmt.Sprintf("SELECT id, url, nick FROM users WHERE id IN (%s)", placeholders), args...)
+	if err != nil {
+		return nil, nil, fmt.Errorf("when looking up URLs of %d users: %w", len(args), err)
+	}
+	defer func() {
+		_ = rows.Close()
+	}()
+
+	for rows.Next() {
+		id := ""
+		url := ""
+		nick := ""
+		if err := rows.Scan(&id, &url, &nick); err != nil {
+			d.logger.Debugf("when scanning user URL: %s", err)
+			continue
+		}
+		urls[id] = url
+		nicks[id] = nick
+	}
+	if err := rows.Err(); err != nil {
+		return nil, nil, fmt.Errorf("when looking up URLs of %d users: %w", len(args), err)
+	}
+
+	return urls, nicks, nil
+}
+
+// GetTweetsByHash retrieves the visible tweets with the provided twt hash, newest first.
+// Hashes are short, so more than one tweet may match.
+func (d *DB) GetTweetsByHash(ctx context.Context, hash string) ([]Tweet, error) {
+	ctx, cancel := d.withQueryTimeout(ctx)
+	defer cancel()
+
+	hash = strings.ToLower(strings.TrimSpace(hash))
+	if hash == "" {
+		return []Tweet{}, nil
+	}
+
+	tweetStmt := `SELECT tweets.id, tweets.user_id, users.nick, users.url, tweets.dt, tweets.body, tweets.hidden, tweets.hash, tweets.subject, tweets.mentions, tweets.tags, tweets.utc_offset, tweets.lang
+					FROM tweets JOIN users ON users.id = tweets.user_id
+					WHERE tweets.hash = ? AND tweets.hidden = ? AND users.status = 'active'
+					ORDER BY tweets.dt DESC`
+	rows, err := d.queryPrepared(ctx, tweetStmt, hash, StatusVisible)
+	if err != nil {
+		return nil, fmt.Errorf("when querying for tweets with hash %s: %w", hash, err)
+	}
+	defer func() {
+		_ = rows.Close()
+	}()
+
+	return d.scanTweetRows(rows)
+}
+
+// BackfillTweetHashes computes the twt hash of stored tweets that don't have one yet, returning how many were hashed.
+// The offset of their original timestamps wasn't kept, so tweets from feeds that don't use UTC may get a
+// different hash than Yarn computes for them.
+func (d *DB) BackfillTweetHashes(ctx context.Context) (int64, error) {
+	selectStmt := `SELECT tweets.id, users.url, tweets.dt, tweets.body
+					FROM tweets JOIN users ON users.id = tweets.user_id
+					WHERE tweets.hash = '' LIMIT ?`
+	hashed := int64(0)
+	for {
+		n, err := d.backfillTweetHashesBatch(ctx, selectStmt)
+		if err != nil {
+			return hashed, err
+		}
+		hashed += n
+		if n < backfillTweetsBatchSize {
+			break
+		}
+	}
+	if hashed > 0 {
+		d.invalidate()
+	}
+
+	return hashed, nil
+}
+
+func (d *DB) backfillTweetHashesBatch(ctx context.Context, selectStmt string) (int64, error) {
+	tx, err := d.beginWrite(ctx)
+	if err != nil {
+		return 0, fmt.Errorf("when beginning tx to backfill tweet hashes: %w", err)
+	}
+	defer func() {
+		_ = tx.Rollback()
+	}()
+
+	rows, err := tx.QueryContext(ctx, selectStmt, backfillTweetsBatchSize)
+	if err != nil {
+		return 0, fmt.Errorf("when querying for tweets without hashes: %w", err)
+	}
+	hashes := make(map[string]string, backfillTweetsBatchSize)
+	for rows.Next() {
+		id := ""
+		url := ""
+		dt := int64(0)
+		body := ""
+		if err := rows.Scan(&id, &url, &dt, &body); err != nil {
+			d.logger.Debugf("when scanning tweet to hash: %s", err)
+			continue
+		}
+		hashes[id] = TwtHash(url, time.Unix(0, dt).UTC(), body)
+	}
+	err = rows.Err()
+	_ = rows.Close()
+	if err != nil {
+		return 0, fmt.Errorf("when reading tweets without hashes: %w", err)
+	}
+
+	for id, hash := range hashes {
+		if _, err := tx.ExecContext(ctx, "UPDATE tweets SET hash = ? WHERE id = ?", hash, id); err != nil {
+			return 0, fmt.Errorf("when storing hash of tweet %s: %w", id, err)
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return 0, fmt.Errorf("when committing tx to backfill tweet hashes: %w", err)
+	}
+
+	return int64(len(hashes)), nil
+}
@@ -0,0 +1,144 @@
+package registry
+
+/*
+Copyright 2021 G. Benjamin Morrison
+
+This file is part of getwtxt-ng.
+
+getwtxt-ng is free software: you can redistribute it and/or modify
+it under the terms of the GNU Affero General Public License as
+published by the Free Software Foundation, either version 3 of the
+License, or (at your option) any later version.
+
+getwtxt-ng is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU Affero General Public License for more details.
+
+You should have received a copy of the GNU Affero General Public License
+along with getwtxt-ng.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+import (
+	"context"
+	"regexp"
+	"testing"
+	"time"
+)
+
+func TestTwtHash(t *testing.T) {
+	created := time.Date(2021, 3, 5, 12, 30, 0, 0, time.UTC)
+	hash := TwtHash("https://example.com/twtxt.txt", created, "hello world")
+	if !regexp.MustCompile(`^[a-z2-7]{7}$`).MatchString(hash) {
+		t.Errorf("Expected 7 lowercase base32 characters, got %q", hash)
+	}
+
+	t.Run("sub-second precision is ignored", func(t *testing.T) {
+		if got := TwtHash("https://example.com/twtxt.txt", created.Add(123*time.Millisecond), "hello world"); got != hash {
+			t.Errorf("Expected %s, got %s", hash, got)
+		}
+	})
+	t.Run("offset is significant", func(t *testing.T) {
+		shifted := created.In(time.FixedZone("", 3600))
+		if got := TwtHash("https://example.com/twtxt.txt", shifted, "hello world"); got == hash {
+			t.Error("Expected a different hash for a different offset")
+		}
+	})
+	t.Run("url and body are significant", func(t *testing.T) {
+		if TwtHash("https://example.org/twtxt.txt", created, "hello world") == hash {
+			t.Error("Expected a different hash for a different URL")
+		}
+		if TwtHash("https://example.com/twtxt.txt", created, "hello world!") == hash {
+			t.Error("Expected a different hash for a different body")
+		}
+	})
+}
+
+func TestDB_TweetHashes(t *testing.T) {
+	db := getPopulatedDB(t)
+	defer func() {
+		_ = db.Close()
+	}()
+	ctx := context.Background()
+
+	t.Run("backfill", func(t *testing.T) {
+		hashed, err := db.BackfillTweetHashes(ctx)
+		if err != nil {
+			t.Fatal(err.Error())
+		}
+		if hashed != int64(len(populatedDBTweets)) {
+			t.Errorf("Expected %d tweets hashed, got %d", len(populatedDBTweets), hashed)
+		}
+		hashed, err = db.BackfillTweetHashes(ctx)
+		if err != nil {
+			t.Fatal(err.Error())
+		}
+		if hashed != 0 {
+			t.Errorf("Expected nothing left to hash, got %d", hashed)
+		}
+
+		tweets, err := db.GetTweetsByID(ctx, []string{"1"})
+		if err != nil {
+			t.Fatal(err.Error())
+		}
+		want := TwtHash(populatedDBUsers[0].URL, populatedDBTweets[0].DateTime, populatedDBTweets[0].Body)
+		if len(tweets) != 1 || tweets[0].Hash != want {
+			t.Errorf("Expected hash %s, got %v", want, tweets)
+		}
+	})
+
+	created := time.Date(2021, 3, 5, 12, 30, 0, 0, time.FixedZone("", -5*3600))
+	t.Run("insert", func(t *testing.T) {
+		res, err := db.InsertTweets(ctx, []Tweet{{UserID: "2", DateTime: created, Body: "a twt with an offset"}})
+		if err != nil {
+			t.Fatal(err.Error())
+		}
+		if res.Inserted != 1 {
+			t.Fatalf("Expected 1 tweet inserted, got %d", res.Inserted)
+		}
+
+		want := TwtHash(populatedDBUsers[1].URL, created, "a twt with an offset")
+		tweets, err := db.GetTweetsByHash(ctx, want)
+		if err != nil {
+			t.Fatal(err.Error())
+		}
+		if len(tweets) != 1 || tweets[0].ID != res.IDs[0] || tweets[0].Hash != want {
+			t.Errorf("Expected tweet %s with hash %s, got %v", res.IDs[0], want, tweets)
+		}
+	})
+
+	t.Run("edit", func(t *testing.T) {
+		res, err := db.InsertTweets(ctx, []Tweet{{UserID: "2", DateTime: created, Body: "a twt with an offset, edited"}})
+		if err != nil {
+			t.Fatal(err.Error())
+		}
+		if res.Edited != 1 {
+			t.Fatalf("Expected 1 tweet edited, got %d", res.Edited)
+		}
+
+		tweets, err := db.GetTweetsByHash(ctx, TwtHash(populatedDBUsers[1].URL, created, "a twt with an offset"))
+		if err != nil {
+			t.Fatal(err.Error())
+		}
+		if len(tweets) != 0 {
+			t.Errorf("Expected the old hash to be gone, got %v", tweets)
+		}
+		tweets, err = db.GetTweetsByHash(ctx, TwtHash(populatedDBUsers[1].URL, created, "a twt with an offset, edited"))
+		if err != nil {
+			t.Fatal(err.Error())
+		}
+		if len(tweets) != 1 {
+			t.Errorf("Expected 1 tweet with the new hash, got %v", tweets)
+		}
+	})
+
+	t.Run("hidden tweets aren't found", func(t *testing.T) {
+		tweets, err := db.GetTweetsByHash(ctx, TwtHash(populatedDBUsers[1].URL, populatedDBTweets[2].DateTime, populatedDBTweets[2].Body))
+		if err != nil {
+			t.Fatal(err.Error())
+		}
+		if len(tweets) != 0 {
+			t.Errorf("Expected no tweets, got %v", tweets)
+		}
+	})
+}
@@ -21,9 +21,13 @@ along with getwtxt-ng.  If not, see <https://www.gnu.org/licenses/>.
 
 import (
 	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
 	"fmt"
 	"io"
 	"net/http"
+	"net/url"
+	"strconv"
 	"strings"
 	"time"
 
@@ -35,71 +39,307 @@ import (
 // Comments and whitespace are stripped from the response.
 // If we receive a 304, return a nil slice and a nil error.
 func (d *DB) FetchTwtxt(twtxtURL, userID string, lastModified time.Time) ([]Tweet, error) {
+	tweets, _, _, _, err := d.fetchTwtxtStatus(twtxtURL, userID, lastModified, feedState{})
+	return tweets, err
+}
+
+// FetchTwtxtWithMetadata is FetchTwtxt, but also returns the metadata the feed declares about itself,
+// which is nil if the file hasn't changed.
+func (d *DB) FetchTwtxtWithMetadata(twtxtURL, userID string, lastModified time.Time) ([]Tweet, *FeedMetadata, error) {
+	tweets, meta, _, _, err := d.fetchTwtxtStatus(twtxtURL, userID, lastModified, feedState{})
+	return tweets, meta, err
+}
+
+// FetchArchivedTwtxt follows the chain of archived files a feed declares with "# prev = hash url" metadata,
+// starting at prevURL. Each link may be relative to the file that declared it. At most depth files are fetched, and none are fetched twice.
+// Their tweets are returned as though they came from the feed itself. If a file can't be fetched, the chain ends
+// there and the tweets gathered so far are returned along with the error.
+func (d *DB) FetchArchivedTwtxt(feedURL, userID, prevURL string, depth int) ([]Tweet, error) {
+	tweets := make([]Tweet, 0)
+	seen := map[string]bool{feedURL: true}
+	base, err := url.Parse(feedURL)
+	if err != nil {
+		return tweets, fmt.Errorf("couldn't parse %s as URL: %w", feedURL, err)
+	}
+
+	for i := 0; i < depth && prevURL != ""; i++ {
+		ref, err := url.Parse(prevURL)
+		if err != nil {
+			return tweets, fmt.Errorf("couldn't parse archive URL %s of %s: %w", prevURL, feedURL, err)
+		}
+		base = base.ResolveReference(ref)
+		archiveURL := base.String()
+		if seen[archiveURL] {
+			break
+		}
+		seen[archiveURL] = true
+
+		archived, meta, _, _, err := d.fetchTwtxt(archiveURL, userID, time.Time{}, feedState{})
+		if err != nil {
+			return tweets, fmt.Errorf("when fetching archive of %s: %w", feedURL, err)
+		}
+		for j := range archived {
+			archived[j].URL = feedURL
+		}
+		tweets = append(tweets, archived...)
+		if meta == nil {
+			break
+		}
+		prevURL = meta.Prev
+	}
+
+	return tweets, nil
+}
+
+// Range fetches re-read the last feedTailOverlap bytes of what they already have, and are only trusted if those
+// bytes hash the same as before, so edits to recent tweets are still seen. Edits further up the file are caught
+// by fetching it whole after maxPartialFetches range fetches in a row.
+const (
+	feedTailOverlap   = 1024
+	maxPartialFetches = 24
+)
+
+// feedState is what's remembered of a feed between syncs, so the next fetch can skip what hasn't changed.
+type feedState struct {
+	// hash is of the body as last fetched whole.
+	hash string
+
+	// length is the size in bytes of the file as last fetched, so the next fetch can ask for only what's been
+	// appended since. It's zero when the file didn't end with a newline, as an append would continue its last line.
+	length int64
+
+	// tail is the hash of the file's last feedTailOverlap bytes as last fetched.
+	tail string
+
+	// partials is how many range fetches there have been since the file was last fetched whole.
+	partials int
+}
+
+// tailHash hashes the last feedTailOverlap bytes of body.
+func tailHash(body []byte) string {
+	if len(body) > feedTailOverlap {
+		body = body[len(body)-feedTailOverlap:]
+	}
+	sum := sha256.Sum256(body)
+	return hex.EncodeToString(sum[:])
+}
+
+// fetchTwtxtStatus is FetchTwtxt, but also returns the metadata the feed declared, which is nil if the file
+// hasn't changed, the HTTP status code of the response, or zero if there wasn't one, and the state of the feed
+// to remember for the next fetch. A body that hashes the same as prev's is treated as unchanged, and when
+// prev has a length, only what was appended after it is fetched, if the host allows it and the end of what
+// was there before hasn't changed.
+func (d *DB) fetchTwtxtStatus(twtxtURL, userID string, lastModified time.Time, prev feedState) ([]Tweet, *FeedMetadata, int, feedState, error) {
+	tweets, meta, status, state, err := d.fetchTwtxt(twtxtURL, userID, lastModified, prev)
+	if d != nil {
+		d.Hooks.feedFetched(twtxtURL, len(tweets), err)
+	}
+
+	return tweets, meta, status, state, err
+}
+
+func (d *DB) fetchTwtxt(twtxtURL, userID string, lastModified time.Time, prev feedState) ([]Tweet, *FeedMetadata, int, feedState, error) {
 	if !common.IsValidURL(twtxtURL, d.logger) {
-		return nil, fmt.Errorf("invalid URL provided: %s", twtxtURL)
+		return nil, nil, 0, prev, fmt.Errorf("invalid URL provided: %s", twtxtURL)
 	}
 	if d == nil || d.Client == nil {
-		return nil, fmt.Errorf("can't fetch twtxt file at %s: have nil receiver or nil HTTP client", twtxtURL)
+		return nil, nil, 0, prev, fmt.Errorf("can't fetch twtxt file at %s: have nil receiver or nil HTTP client", twtxtURL)
+	}
+
+	rangeFrom := int64(0)
+	if prev.length > feedTailOverlap && prev.tail != "" && prev.partials < maxPartialFetches {
+		rangeFrom = prev.length - feedTailOverlap
+	}
+	resp, body, err := d.getTwtxt(twtxtURL, lastModified, rangeFrom)
+	if err == nil && rangeFrom > 0 && !isAppendedTail(resp, body, rangeFrom, prev.tail) {
+		rangeFrom = 0
+		resp, body, err = d.getTwtxt(twtxtURL, lastModified, rangeFrom)
+	}
+	if err != nil {
+		status := 0
+		if resp != nil {
+			status = resp.StatusCode
+		}
+		return nil, nil, status, prev, err
+	}
+	if resp.StatusCode == http.StatusNotModified {
+		return nil, nil, resp.StatusCode, prev, nil
+	}
+	partial := resp.StatusCode == http.StatusPartialContent && rangeFrom > 0
+	if resp.StatusCode != http.StatusOK && !partial {
+		return nil, nil, resp.StatusCode, prev, fmt.Errorf("got status code %d from %s", resp.StatusCode, twtxtURL)
+	}
+
+	contentType := resp.Header.Get("Content-Type")
+	if !strings.Contains(contentType, "text/plain") {
+		return nil, nil, resp.StatusCode, prev, fmt.Errorf("received non-text/plain content type from %s: %s", twtxtURL, contentType)
+	}
+
+	if partial {
+		// The metadata is at the top of the file, so it hasn't changed. The hash is left as it was,
+		// as the whole body isn't at hand to take a new one.
+		state := feedState{hash: prev.hash, partials: prev.partials + 1}
+		if bytes.HasSuffix(body, []byte("\n")) {
+			state.length = rangeFrom + int64(len(body))
+			state.tail = tailHash(body)
+		}
+		tweets, _ := d.parseTwtxt(body[feedTailOverlap:], twtxtURL, userID)
+		return tweets, nil, resp.StatusCode, state, nil
+	}
+
+	// Plenty of hosts ignore If-Modified-Since and send the whole file every time. One that hasn't
+	// changed since the last fetch is caught by its hash instead, sparing it from being parsed and stored again.
+	sum := sha256.Sum256(body)
+	state := feedState{hash: hex.EncodeToString(sum[:])}
+	if bytes.HasSuffix(body, []byte("\n")) {
+		state.length = int64(len(body))
+		state.tail = tailHash(body)
+	}
+	if prev.hash != "" && state.hash == prev.hash {
+		return nil, nil, resp.StatusCode, state, nil
 	}
 
+	tweets, meta := d.parseTwtxt(body, twtxtURL, userID)
+	return tweets, &meta, resp.StatusCode, state, nil
+}
+
+// getTwtxt requests the twtxt file at twtxtURL, only from byte rangeFrom on if it's above zero. The body is
+// read for successful responses, and the response is returned along with any error about it.
+func (d *DB) getTwtxt(twtxtURL string, lastModified time.Time, rangeFrom int64) (*http.Response, []byte, error) {
 	req, err := http.NewRequest("GET", twtxtURL, nil)
 	if err != nil {
-		return nil, fmt.Errorf("couldn't create http request to fetch %s: %w", twtxtURL, err)
+		return nil, nil, fmt.Errorf("couldn't create http request to fetch %s: %w", twtxtURL, err)
 	}
 	req.Header.Set("If-Modified-Since", lastModified.Format(time.RFC1123))
+	if rangeFrom > 0 {
+		req.Header.Set("Range", fmt.Sprintf("bytes=%d-", rangeFrom))
+	}
 
 	resp, err := d.Client.Do(req)
 	if err != nil {
-		return nil, fmt.Errorf("error making http request to %s: %w", twtxtURL, err)
+		return nil, nil, fmt.Errorf("error making http request to %s: %w", twtxtURL, err)
 	}
 	defer func() {
 		_ = resp.Body.Close()
 	}()
-	if resp.StatusCode == http.StatusNotModified {
-		return nil, nil
+	if resp.StatusCode != http.StatusOK && resp.StatusCode != http.StatusPartialContent {
+		return resp, nil, nil
 	}
-	if resp.StatusCode != http.StatusOK {
-		return nil, fmt.Errorf("got status code %d from %s", resp.StatusCode, twtxtURL)
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return resp, nil, fmt.Errorf("unable to read response body from %s: %w", twtxtURL, err)
 	}
 
-	contentType := resp.Header.Get("Content-Type")
-	if !strings.Contains(contentType, "text/plain") {
-		return nil, fmt.Errorf("received non-text/plain content type from %s: %s", twtxtURL, contentType)
+	return resp, body, nil
+}
+
+// isAppendedTail reports whether the response to a request for the file from rangeFrom on can be used as is:
+// either the whole file, or the last feedTailOverlap bytes we already had, unchanged, followed by what was
+// appended since. Anything else, such as the file being too short for the range, means it was changed
+// rather than appended to and has to be fetched whole.
+func isAppendedTail(resp *http.Response, body []byte, rangeFrom int64, prevTail string) bool {
+	switch resp.StatusCode {
+	case http.StatusRequestedRangeNotSatisfiable:
+		return false
+	case http.StatusPartialContent:
+		if contentRangeStart(resp.Header.Get("Content-Range")) != rangeFrom || len(body) < feedTailOverlap {
+			return false
+		}
+		sum := sha256.Sum256(body[:feedTailOverlap])
+		return hex.EncodeToString(sum[:]) == prevTail
+	default:
+		return true
 	}
+}
 
-	body, err := io.ReadAll(resp.Body)
+// contentRangeStart returns the first byte of a Content-Range header such as "bytes 100-199/200", or -1 if it can't be read.
+func contentRangeStart(contentRange string) int64 {
+	spec := strings.TrimPrefix(strings.TrimSpace(contentRange), "bytes ")
+	dash := strings.IndexByte(spec, '-')
+	if dash < 1 {
+		return -1
+	}
+	start, err := strconv.ParseInt(spec[:dash], 10, 64)
 	if err != nil {
-		return nil, fmt.Errorf("unable to read response body from %s: %w", twtxtURL, err)
+		return -1
 	}
+	return start
+}
 
+// parseTwtxt reads the tweets and metadata comments in the body of a twtxt file. Lines whose timestamps
+// can't be parsed are skipped.
+func (d *DB) parseTwtxt(body []byte, twtxtURL, userID string) ([]Tweet, FeedMetadata) {
 	body = bytes.TrimSpace(body)
 	bodySplit := strings.Split(string(body), "\n")
 	tweets := make([]Tweet, 0, 256)
+	meta := FeedMetadata{}
 
 	for _, e := range bodySplit {
 		e = strings.TrimSpace(e)
-		if strings.HasPrefix(e, "#") || e == "" {
+		if strings.HasPrefix(e, "#") {
+			meta.parseComment(e)
+			continue
+		}
+		if e == "" {
 			continue
 		}
 
 		tweetHalves := strings.Split(e, "\t")
 		thisTweet := Tweet{
 			UserID: userID,
+			URL:    twtxtURL,
 			Body:   strings.Join(tweetHalves[1:], "\t"),
 		}
 
-		if strings.Contains(tweetHalves[0], ".") {
-			thisTweet.DateTime, err = time.Parse(time.RFC3339Nano, tweetHalves[0])
-		} else {
-			thisTweet.DateTime, err = time.Parse(time.RFC3339, tweetHalves[0])
-		}
+		var err error
+		thisTweet.DateTime, err = ParseTwtTime(tweetHalves[0])
 		if err != nil {
 			d.logger.Debugf("Error parsing time for tweet at %s from %s: %s", tweetHalves[0], twtxtURL, err)
 			continue
 		}
+		_, thisTweet.UTCOffset = thisTweet.DateTime.Zone()
 
 		tweets = append(tweets, thisTweet)
 	}
 
-	return tweets, nil
+	return tweets, meta
+}
+
+// twtTimeLayouts are the timestamp formats seen in twtxt files, tried in order. Fractional seconds
+// are accepted after the seconds by all of them. The date and time may also be separated by a space.
+var twtTimeLayouts = []string{
+	time.RFC3339,
+	"2006-01-02T15:04:05Z0700",
+	"2006-01-02T15:04:05Z07",
+	"2006-01-02T15:04Z07:00",
+	"2006-01-02T15:04Z0700",
+	"2006-01-02T15:04Z07",
+}
+
+// ParseTwtTime parses the timestamp of a twt, keeping the offset it was written with. Besides RFC3339,
+// it accepts the variants found in the wild: a space instead of the T, missing seconds,
+// and offsets written without a colon or without minutes.
+func ParseTwtTime(s string) (time.Time, error) {
+	s = strings.TrimSpace(s)
+	if len(s) > 10 && s[10] == ' ' {
+		s = s[:10] + "T" + strings.TrimLeft(s[10:], " ")
+	}
+	// Offsets can also be separated from the time by a space.
+	if i := strings.LastIndex(s, " "); i > 10 {
+		s = s[:i] + s[i+1:]
+	}
+
+	var firstErr error
+	for _, layout := range twtTimeLayouts {
+		dt, err := time.Parse(layout, s)
+		if err == nil {
+			return dt, nil
+		}
+		if firstErr == nil {
+			firstErr = err
+		}
+	}
+
+	return time.Time{}, firstErr
 }
@@ -28,6 +28,8 @@ import (
 	"time"
 
 	log "github.com/sirupsen/logrus"
+
+	"github.com/gbmor/getwtxt-ng/common"
 )
 
 func TestDB_FetchTwtxt(t *testing.T) {
@@ -131,3 +133,85 @@ func TestDB_FetchTwtxt(t *testing.T) {
 		})
 	}
 }
+
+func TestDB_FetchArchivedTwtxt(t *testing.T) {
+	files := map[string]string{
+		"/archive/2.txt": "# prev = abcdefg 1.txt\n2021-02-01T00:00:00Z\tsecond archive\n",
+		"/archive/1.txt": "# prev = hijklmn /archive/2.txt\n2021-01-01T00:00:00Z\tfirst archive\n",
+	}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, ok := files[r.URL.Path]
+		if !ok {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		w.Header().Set("Content-Type", common.MimePlain)
+		_, _ = w.Write([]byte(body))
+	}))
+	defer srv.Close()
+	db := getPopulatedDB(t)
+	defer func() {
+		_ = db.Close()
+	}()
+	db.Client = srv.Client()
+	feedURL := srv.URL + "/twtxt.txt"
+
+	t.Run("stops at loops", func(t *testing.T) {
+		tweets, err := db.FetchArchivedTwtxt(feedURL, "1", "archive/2.txt", 10)
+		if err != nil {
+			t.Fatal(err.Error())
+		}
+		if len(tweets) != 2 || tweets[0].Body != "second archive" || tweets[1].Body != "first archive" {
+			t.Fatalf("Unexpected archived tweets: %v", tweets)
+		}
+		if tweets[1].URL != feedURL {
+			t.Errorf("Expected archived tweets to be attributed to %s, got %s", feedURL, tweets[1].URL)
+		}
+	})
+	t.Run("bounded depth", func(t *testing.T) {
+		tweets, err := db.FetchArchivedTwtxt(feedURL, "1", "archive/2.txt", 1)
+		if err != nil {
+			t.Fatal(err.Error())
+		}
+		if len(tweets) != 1 {
+			t.Errorf("Expected 1 archived tweet, got: %v", tweets)
+		}
+	})
+	t.Run("missing archive", func(t *testing.T) {
+		if _, err := db.FetchArchivedTwtxt(feedURL, "1", "archive/0.txt", 10); err == nil {
+			t.Error("Expected error for missing archive")
+		}
+	})
+}
+
+func TestParseTwtTime(t *testing.T) {
+	cases := map[string]string{
+		"2022-10-19T15:04:05Z":          "2022-10-19T15:04:05Z",
+		"2022-10-19T15:04:05.123456Z":   "2022-10-19T15:04:05Z",
+		"2022-10-19T15:04:05+01:00":     "2022-10-19T15:04:05+01:00",
+		"2022-10-19T15:04:05+0100":      "2022-10-19T15:04:05+01:00",
+		"2022-10-19T15:04:05-05":        "2022-10-19T15:04:05-05:00",
+		"2022-10-19 15:04:05+01:00":     "2022-10-19T15:04:05+01:00",
+		"2022-10-19 15:04:05 +0100":     "2022-10-19T15:04:05+01:00",
+		"2022-10-19T15:04+05:30":        "2022-10-19T15:04:00+05:30",
+		"2022-10-19 15:04Z":             "2022-10-19T15:04:00Z",
+		"2022-10-19T15:04:05.5-0700":    "2022-10-19T15:04:05-07:00",
+		"  2022-10-19T15:04:05+01:00  ": "2022-10-19T15:04:05+01:00",
+	}
+	for in, want := range cases {
+		dt, err := ParseTwtTime(in)
+		if err != nil {
+			t.Errorf("Couldn't parse %q: %s", in, err)
+			continue
+		}
+		if got := dt.Format(time.RFC3339); got != want {
+			t.Errorf("Parsing %q: expected %s, got %s", in, want, got)
+		}
+	}
+
+	for _, in := range []string{"", "yesterday", "2022-10-19", "2022-10-19T15:04:05", "19/10/2022 15:04"} {
+		if _, err := ParseTwtTime(in); err == nil {
+			t.Errorf("Expected an error parsing %q", in)
+		}
+	}
+}
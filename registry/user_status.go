@@ -0,0 +1,102 @@
+package registry
+
+/*
+Copyright 2021 G. Benjamin Morrison
+
+This file is part of getwtxt-ng.
+
+getwtxt-ng is free software: you can redistribute it and/or modify
+it under the terms of the GNU Affero General Public License as
+published by the Free Software Foundation, either version 3 of the
+License, or (at your option) any later version.
+
+getwtxt-ng is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU Affero General Public License for more details.
+
+You should have received a copy of the GNU Affero General Public License
+along with getwtxt-ng.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// UserStatus is where a user is in their lifecycle. Only active users appear in public listings and searches.
+type UserStatus string
+
+const (
+	UserStatusActive              UserStatus = "active"
+	UserStatusSuspended           UserStatus = "suspended"
+	UserStatusPendingVerification UserStatus = "pending-verification"
+	UserStatusInactive            UserStatus = "inactive"
+
+	// UserStatusPendingApproval is for users who registered while approval was required. They're synced, but
+	// hidden until ApproveUsers makes them active.
+	UserStatusPendingApproval UserStatus = "pending-approval"
+
+	// UserStatusPendingDeletion is set by ScheduleUserDeletion rather than SetUserStatus, as it needs a time to
+	// delete the user after.
+	UserStatusPendingDeletion UserStatus = "pending-deletion"
+)
+
+// ErrInvalidUserStatus is returned when a status other than the known ones is provided.
+var ErrInvalidUserStatus = errors.New("invalid user status")
+
+// ParseUserStatus converts the provided string into a UserStatus, returning ErrInvalidUserStatus if it isn't one.
+func ParseUserStatus(status string) (UserStatus, error) {
+	s := UserStatus(strings.ToLower(strings.TrimSpace(status)))
+	switch s {
+	case UserStatusActive, UserStatusSuspended, UserStatusPendingVerification, UserStatusInactive, UserStatusPendingApproval:
+		return s, nil
+	default:
+		return "", fmt.Errorf("%w: %s", ErrInvalidUserStatus, status)
+	}
+}
+
+// SetUserStatus moves the users with the provided URLs into the given status.
+// Returns sql.ErrNoRows if none of the URLs belong to a user.
+func (d *DB) SetUserStatus(ctx context.Context, status UserStatus, userURLs ...string) (int64, error) {
+	if len(userURLs) < 1 {
+		return 0, ErrNoUsersProvided
+	}
+	if _, err := ParseUserStatus(string(status)); err != nil {
+		return 0, err
+	}
+
+	tx, err := d.beginWrite(ctx)
+	if err != nil {
+		return 0, fmt.Errorf("when beginning tx to set status of %d users to %s: %w", len(userURLs), status, err)
+	}
+	defer func() {
+		_ = tx.Rollback()
+	}()
+
+	updated := int64(0)
+	for _, userURL := range userURLs {
+		res, err := tx.ExecContext(ctx, "UPDATE users SET status = ? WHERE url = ?", status, strings.TrimSpace(userURL))
+		if err != nil {
+			return 0, fmt.Errorf("when setting status of user %s to %s: %w", userURL, status, err)
+		}
+		affected, err := res.RowsAffected()
+		if err != nil {
+			return 0, fmt.Errorf("when setting status of user %s to %s: %w", userURL, status, err)
+		}
+		updated += affected
+	}
+	if updated == 0 {
+		return 0, fmt.Errorf("when setting status of %d users to %s: %w", len(userURLs), status, sql.ErrNoRows)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return 0, fmt.Errorf("when committing tx to set status of %d users to %s: %w", len(userURLs), status, err)
+	}
+	d.invalidate()
+
+	return updated, nil
+}
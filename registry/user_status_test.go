@@ -0,0 +1,126 @@
+package registry
+
+/*
+Copyright 2021 G. Benjamin Morrison
+
+This file is part of getwtxt-ng.
+
+getwtxt-ng is free software: you can redistribute it and/or modify
+it under the terms of the GNU Affero General Public License as
+published by the Free Software Foundation, either version 3 of the
+License, or (at your option) any later version.
+
+getwtxt-ng is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU Affero General Public License for more details.
+
+You should have received a copy of the GNU Affero General Public License
+along with getwtxt-ng.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestParseUserStatus(t *testing.T) {
+	for _, in := range []string{"active", " Suspended ", "pending-verification", "INACTIVE"} {
+		if _, err := ParseUserStatus(in); err != nil {
+			t.Errorf("Expected %q to parse, got: %s", in, err)
+		}
+	}
+	if _, err := ParseUserStatus("banished"); !errors.Is(err, ErrInvalidUserStatus) {
+		t.Errorf("Expected ErrInvalidUserStatus, got: %v", err)
+	}
+}
+
+func TestDB_SetUserStatus(t *testing.T) {
+	ctx := context.Background()
+	suspended := populatedDBUsers[1]
+
+	t.Run("invalid input", func(t *testing.T) {
+		memDB := getPopulatedDB(t)
+		if _, err := memDB.SetUserStatus(ctx, UserStatusSuspended); !errors.Is(err, ErrNoUsersProvided) {
+			t.Errorf("Expected ErrNoUsersProvided, got: %v", err)
+		}
+		if _, err := memDB.SetUserStatus(ctx, "banished", suspended.URL); !errors.Is(err, ErrInvalidUserStatus) {
+			t.Errorf("Expected ErrInvalidUserStatus, got: %v", err)
+		}
+		if _, err := memDB.SetUserStatus(ctx, UserStatusSuspended, "https://example.net/twtxt.txt"); !errors.Is(err, sql.ErrNoRows) {
+			t.Errorf("Expected sql.ErrNoRows, got: %v", err)
+		}
+	})
+
+	t.Run("suspended users are left out of public queries", func(t *testing.T) {
+		memDB := getPopulatedDB(t)
+		updated, err := memDB.SetUserStatus(ctx, UserStatusSuspended, suspended.URL)
+		if err != nil {
+			t.Fatal(err.Error())
+		}
+		if updated != 1 {
+			t.Errorf("Expected 1 user updated, got %d", updated)
+		}
+
+		user, err := memDB.GetFullUserByURL(ctx, suspended.URL)
+		if err != nil {
+			t.Fatal(err.Error())
+		}
+		if user.Status != UserStatusSuspended {
+			t.Errorf("Expected status %s, got %s", UserStatusSuspended, user.Status)
+		}
+
+		users, err := memDB.GetUsers(ctx, 1, 20)
+		if err != nil {
+			t.Fatal(err.Error())
+		}
+		searched, err := memDB.SearchUsers(ctx, 1, 20, "example")
+		if err != nil {
+			t.Fatal(err.Error())
+		}
+		due, err := memDB.GetUsersDueForSync(ctx, 0, time.Now())
+		if err != nil {
+			t.Fatal(err.Error())
+		}
+		for _, list := range [][]User{users, searched, due} {
+			for _, u := range list {
+				if u.ID == suspended.ID {
+					t.Errorf("Suspended user %s was listed", u.URL)
+				}
+			}
+		}
+
+		tweets, err := memDB.GetTweets(ctx, 1, 20, StatusVisible)
+		if err != nil {
+			t.Fatal(err.Error())
+		}
+		found, err := memDB.SearchTweets(ctx, 1, 20, "oh", StatusVisible)
+		if err != nil {
+			t.Fatal(err.Error())
+		}
+		for _, list := range [][]Tweet{tweets, found} {
+			for _, tw := range list {
+				if tw.UserID == suspended.ID {
+					t.Errorf("Tweet %s by suspended user was listed", tw.ID)
+				}
+			}
+		}
+		if len(tweets) != 1 {
+			t.Errorf("Expected 1 tweet, got %d", len(tweets))
+		}
+
+		if _, err := memDB.SetUserStatus(ctx, UserStatusActive, suspended.URL); err != nil {
+			t.Fatal(err.Error())
+		}
+		tweets, err = memDB.GetTweets(ctx, 1, 20, StatusVisible)
+		if err != nil {
+			t.Fatal(err.Error())
+		}
+		if len(tweets) != 2 {
+			t.Errorf("Expected 2 tweets once reactivated, got %d", len(tweets))
+		}
+	})
+}
@@ -31,8 +31,6 @@ import (
 	"sync/atomic"
 	"time"
 
-	log "github.com/sirupsen/logrus"
-
 	"github.com/gbmor/getwtxt-ng/common"
 )
 
@@ -45,6 +43,10 @@ var ErrIncompleteUserInfo = errors.New("incomplete user info supplied: missing U
 // ErrUserURLIsNotTwtxtFile is returned when the provided user's URL is not a path to a twtxt.txt file.
 var ErrUserURLIsNotTwtxtFile = errors.New("user URL does not point to twtxt.txt")
 
+// ErrUserNotFound is returned when no user is registered at the URL asked about.
+// It wraps sql.ErrNoRows, so checking for that still works.
+var ErrUserNotFound = fmt.Errorf("user not found: %w", sql.ErrNoRows)
+
 // RegexIsAlpha matches `[a-zA-Z0-9_]+`
 var RegexIsAlpha = regexp.MustCompile(`\w+`)
 
@@ -61,6 +63,26 @@ type User struct {
 	PasscodeHash  []byte    `json:"-"`
 	DateTimeAdded time.Time `json:"datetime_added"`
 	LastSync      time.Time `json:"last_sync"`
+
+	// DeclaredNick, Avatar, and Description are what the feed says about itself in its metadata comments,
+	// as of the last sync. DeclaredNick may differ from the nickname the user registered with.
+	DeclaredNick string `json:"declared_nick,omitempty"`
+	Avatar       string `json:"avatar,omitempty"`
+	Description  string `json:"description,omitempty"`
+
+	// Status is only populated by GetFullUserByURL, GetAllUsers, GetUsersDueForSync, and GetPendingApprovals,
+	// since the public listings only include active users. A new user is inserted with it, or as active if it's empty.
+	Status UserStatus `json:"status,omitempty"`
+
+	// ContentHash and ContentLength are the hash of the feed's body as last fetched whole and the file's length
+	// as last fetched, and are only populated by GetUsersDueForSync. Syncing a user with the hash set treats a body
+	// that hashes the same as unchanged, and with the length set asks for only what was appended after it.
+	// ContentTailHash is the hash of the end of the file, which a range fetch has to find unchanged, and
+	// PartialFetches is how many range fetches there have been since the file was fetched whole.
+	ContentHash     string `json:"-"`
+	ContentLength   int64  `json:"-"`
+	ContentTailHash string `json:"-"`
+	PartialFetches  int    `json:"-"`
 }
 
 // FormatUsersPlain formats the provided slice of User into plain text, with each LF-terminated line containing the following tab-separated values:
@@ -69,6 +91,11 @@ type User struct {
 //   - Timestamp Added (RFC3339)
 //   - Last Sync Time (RFC3339)
 func FormatUsersPlain(users []User) string {
+	return FormatUsersPlainWith(users, PlainFormat{})
+}
+
+// FormatUsersPlainWith formats users as FormatUsersPlain does, with timestamps written as f says.
+func FormatUsersPlainWith(users []User, f PlainFormat) string {
 	if len(users) < 1 {
 		return ""
 	}
@@ -80,9 +107,33 @@ func FormatUsersPlain(users []User) string {
 		builder.WriteString("\t")
 		builder.WriteString(user.URL)
 		builder.WriteString("\t")
-		builder.WriteString(user.DateTimeAdded.Format(time.RFC3339))
+		builder.WriteString(f.Timestamp(user.DateTimeAdded))
+		builder.WriteString("\t")
+		builder.WriteString(f.Timestamp(user.LastSync))
+		builder.WriteString("\n")
+	}
+
+	return builder.String()
+}
+
+// FormatUsersList formats the provided slice of User into the user list format consumed by bulk user imports,
+// with each LF-terminated line containing the following tab-separated values:
+//   - Nickname
+//   - URL
+//   - Timestamp Added (RFC3339)
+func FormatUsersList(users []User) string {
+	if len(users) < 1 {
+		return ""
+	}
+
+	builder := strings.Builder{}
+	builder.Grow(len(users) * 96)
+	for _, user := range users {
+		builder.WriteString(user.Nick)
+		builder.WriteString("\t")
+		builder.WriteString(user.URL)
 		builder.WriteString("\t")
-		builder.WriteString(user.LastSync.Format(time.RFC3339))
+		builder.WriteString(user.DateTimeAdded.UTC().Format(time.RFC3339))
 		builder.WriteString("\n")
 	}
 
@@ -108,8 +159,11 @@ func (u *User) GeneratePasscode() (string, error) {
 	return u.Passcode, nil
 }
 
-// GetFullUserByURL returns the user's entire row from the database.
+// GetFullUserByURL returns the user's entire row from the database, or ErrUserNotFound if there's no such user.
 func (d *DB) GetFullUserByURL(ctx context.Context, userURL string) (*User, error) {
+	ctx, cancel := d.withQueryTimeout(ctx)
+	defer cancel()
+
 	userURL = strings.TrimSpace(userURL)
 	if userURL == "" {
 		return nil, ErrNoUsersProvided
@@ -119,8 +173,11 @@ func (d *DB) GetFullUserByURL(ctx context.Context, userURL string) (*User, error
 	dtRaw := int64(0)
 	lsRaw := int64(0)
 
-	stmt := "SELECT * FROM users WHERE url = ?"
-	err := d.conn.QueryRowContext(ctx, stmt, userURL).Scan(&user.ID, &user.URL, &user.Nick, &user.PasscodeHash, &dtRaw, &lsRaw)
+	stmt := "SELECT id, url, nick, passcode_hash, dt_added, last_sync, status FROM users WHERE url = ?"
+	err := d.conn.QueryRowContext(ctx, stmt, userURL).Scan(&user.ID, &user.URL, &user.Nick, &user.PasscodeHash, &dtRaw, &lsRaw, &user.Status)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, fmt.Errorf("when querying for user with URL %s: %w", userURL, ErrUserNotFound)
+	}
 	if err != nil {
 		return nil, fmt.Errorf("unable to query for user with URL %s: %w", userURL, err)
 	}
@@ -134,6 +191,87 @@ func (d *DB) GetFullUserByURL(ctx context.Context, userURL string) (*User, error
 // InsertUser adds a user to the database.
 // The ID field of the provided *User is ignored.
 func (d *DB) InsertUser(ctx context.Context, u *User) error {
+	if err := validateNewUser(u); err != nil {
+		return err
+	}
+
+	tx, err := d.beginWrite(ctx)
+	if err != nil {
+		return fmt.Errorf("couldn't begin transaction to insert user: %w", err)
+	}
+	defer func() {
+		_ = tx.Rollback()
+	}()
+
+	if err := insertUserTx(ctx, tx.Tx, u); err != nil {
+		return err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("error committing tx to insert user %s %s: %w", u.Nick, u.URL, err)
+	}
+	d.invalidate()
+
+	d.Hooks.usersInserted(ctx, []User{*u})
+
+	return nil
+}
+
+// InsertUserWithTweets adds a user and their initial tweets in a single transaction, so
+// either both are stored or neither is. The UserID field of the tweets is ignored.
+func (d *DB) InsertUserWithTweets(ctx context.Context, u *User, tweets []Tweet) (InsertResult, error) {
+	if err := validateNewUser(u); err != nil {
+		return InsertResult{}, err
+	}
+
+	batchStmt, err := d.prepareTweetsBatch(ctx, len(tweets))
+	if err != nil {
+		return InsertResult{}, err
+	}
+
+	tx, err := d.beginWrite(ctx)
+	if err != nil {
+		return InsertResult{}, fmt.Errorf("couldn't begin transaction to insert user with tweets: %w", err)
+	}
+	defer func() {
+		_ = tx.Rollback()
+	}()
+
+	if err := insertUserTx(ctx, tx.Tx, u); err != nil {
+		return InsertResult{}, err
+	}
+
+	var inserted, edited []Tweet
+	if len(tweets) > 0 {
+		userTweets := make([]Tweet, len(tweets))
+		for i, t := range tweets {
+			t.UserID = u.ID
+			t.URL = u.URL
+			t.Nickname = u.Nick
+			userTweets[i] = t
+		}
+		inserted, edited, err = d.insertTweetsTx(ctx, tx.Tx, batchStmt, userTweets)
+		if err != nil {
+			return InsertResult{}, fmt.Errorf("when inserting tweets for new user %s %s: %w", u.Nick, u.URL, err)
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return InsertResult{}, fmt.Errorf("error committing tx to insert user %s %s with tweets: %w", u.Nick, u.URL, err)
+	}
+	d.invalidate()
+
+	d.Hooks.usersInserted(ctx, []User{*u})
+	if u.Status != UserStatusPendingApproval {
+		d.Hooks.tweetsInserted(ctx, inserted)
+	}
+
+	return newInsertResult(len(tweets), inserted, edited), nil
+}
+
+// validateNewUser checks that a user has everything needed to be registered,
+// defaulting the time they were added to now.
+func validateNewUser(u *User) error {
 	if u == nil || u.URL == "" || u.Nick == "" || len(u.PasscodeHash) < 1 ||
 		!RegexIsAlpha.MatchString(u.Nick) {
 		return ErrIncompleteUserInfo
@@ -151,17 +289,33 @@ func (d *DB) InsertUser(ctx context.Context, u *User) error {
 		u.DateTimeAdded = time.Now().UTC()
 	}
 
-	tx, err := d.conn.Begin()
+	return nil
+}
+
+// insertUserTx inserts the user as part of tx and sets their ID.
+// Returns ErrUserExists if the feed is already registered under any form of its URL,
+// and ErrUserBanned if it's banned.
+func insertUserTx(ctx context.Context, tx *sql.Tx, u *User) error {
+	canonical, err := CanonicalURL(u.URL)
 	if err != nil {
-		return fmt.Errorf("couldn't begin transaction to insert user: %w", err)
+		return ErrIncompleteUserInfo
 	}
-	defer func() {
-		_ = tx.Rollback()
-	}()
-
-	res, err := tx.ExecContext(ctx, "INSERT INTO users (url, nick, passcode_hash, dt_added, last_sync) VALUES(?,?,?,?, 0)",
-		u.URL, u.Nick, u.PasscodeHash, u.DateTimeAdded.UnixNano())
+	ban, err := findBan(ctx, tx, u.URL)
 	if err != nil {
+		return err
+	}
+	if ban != nil {
+		return fmt.Errorf("when inserting user %s: %w by %s", u.URL, ErrUserBanned, ban.Pattern)
+	}
+	if u.Status == "" {
+		u.Status = UserStatusActive
+	}
+	res, err := tx.ExecContext(ctx, "INSERT INTO users (url, canonical_url, nick, passcode_hash, dt_added, last_sync, status) VALUES(?,?,?,?,?, 0, ?)",
+		u.URL, canonical, u.Nick, u.PasscodeHash, u.DateTimeAdded.UnixNano(), u.Status)
+	if err != nil {
+		if isUniqueViolation(err) {
+			return fmt.Errorf("when inserting user %s to DB: %w", u.URL, ErrUserExists)
+		}
 		return fmt.Errorf("when inserting user to DB: %w", err)
 	}
 
@@ -172,16 +326,12 @@ func (d *DB) InsertUser(ctx context.Context, u *User) error {
 
 	u.ID = fmt.Sprintf("%d", userID)
 
-	if err := tx.Commit(); err != nil {
-		return fmt.Errorf("error committing tx to insert user %s %s: %w", u.Nick, u.URL, err)
-	}
-
 	return nil
 }
 
 // InsertUsers adds users to the database in bulk.
 func (d *DB) InsertUsers(ctx context.Context, users []User) ([]User, error) {
-	tx, err := d.conn.Begin()
+	tx, err := d.beginWrite(ctx)
 	if err != nil {
 		return nil, fmt.Errorf("couldn't begin transaction for bulk user insert: %w", err)
 	}
@@ -201,14 +351,26 @@ func (d *DB) InsertUsers(ctx context.Context, users []User) ([]User, error) {
 		}
 		parsedURL, urlParseErr := url.Parse(u.URL)
 		if urlParseErr != nil || parsedURL.Scheme == "" {
-			msg := fmt.Sprintf("Skipping %s during bulk add: incomplete info provided", u.URL)
-			log.Info(msg)
+			d.logger.Infof("Skipping %s during bulk add: incomplete info provided", u.URL)
 			continue
 		}
 
 		if !RegexURLIsTwtxtFile.MatchString(u.URL) {
-			msg := fmt.Sprintf("Skipping %s during bulk add: does not appear to be a URL to a twtxt.txt file", u.URL)
-			log.Info(msg)
+			d.logger.Infof("Skipping %s during bulk add: does not appear to be a URL to a twtxt.txt file", u.URL)
+			continue
+		}
+		canonical, err := CanonicalURL(u.URL)
+		if err != nil {
+			d.logger.Infof("Skipping %s during bulk add: incomplete info provided", u.URL)
+			continue
+		}
+
+		ban, err := findBan(ctx, tx, u.URL)
+		if err != nil {
+			return nil, err
+		}
+		if ban != nil {
+			d.logger.Infof("Skipping %s during bulk add: banned by %s", u.URL, ban.Pattern)
 			continue
 		}
 
@@ -216,9 +378,13 @@ func (d *DB) InsertUsers(ctx context.Context, users []User) ([]User, error) {
 			u.DateTimeAdded = time.Now().UTC()
 		}
 
-		res, err := tx.ExecContext(ctx, "INSERT INTO users (url, nick, passcode_hash, dt_added, last_sync) VALUES(?,?,?,?, 0)",
-			u.URL, u.Nick, u.PasscodeHash, u.DateTimeAdded.UnixNano())
+		res, err := tx.ExecContext(ctx, "INSERT INTO users (url, canonical_url, nick, passcode_hash, dt_added, last_sync) VALUES(?,?,?,?,?, 0)",
+			u.URL, canonical, u.Nick, u.PasscodeHash, u.DateTimeAdded.UnixNano())
 		if err != nil {
+			if isUniqueViolation(err) {
+				d.logger.Infof("Skipping %s during bulk add: already registered", u.URL)
+				continue
+			}
 			return nil, fmt.Errorf("when inserting user to DB during bulk insert: %w", err)
 		}
 
@@ -234,6 +400,9 @@ func (d *DB) InsertUsers(ctx context.Context, users []User) ([]User, error) {
 	if err := tx.Commit(); err != nil {
 		return nil, fmt.Errorf("error committing tx for bulk user insert: %w", err)
 	}
+	d.invalidate()
+
+	d.Hooks.usersInserted(ctx, usersAdded)
 
 	return usersAdded, nil
 }
@@ -244,7 +413,7 @@ func (d *DB) DeleteUser(ctx context.Context, u *User) (int64, error) {
 		return 0, ErrNoUsersProvided
 	}
 
-	tx, err := d.conn.Begin()
+	tx, err := d.beginWrite(ctx)
 	if err != nil {
 		return 0, fmt.Errorf("when beginning tx to delete user %s: %w", u.URL, err)
 	}
@@ -267,25 +436,30 @@ func (d *DB) DeleteUser(ctx context.Context, u *User) (int64, error) {
 	if err := tx.Commit(); err != nil {
 		return 0, fmt.Errorf("when committing tx to delete user %s: %w", u.URL, err)
 	}
+	d.invalidate()
 
 	tweetsRemoved, err := res.RowsAffected()
 	if err != nil {
 		d.logger.Debugf("When getting number of tweets deleted when removing user %s: %s", u.URL, err)
 	}
 
+	d.Hooks.usersDeleted(ctx, []string{u.URL})
+	d.Hooks.tweetsDeleted(ctx, tweetsRemoved)
+
 	return tweetsRemoved, nil
 }
 
-// DeleteUsers removes multiple users and their tweets. Returns the total number of tweets deleted.
-func (d *DB) DeleteUsers(ctx context.Context, urls []string) (int64, error) {
+// DeleteUsers removes multiple users and their tweets. Returns the number of users and the total number of tweets deleted.
+// URLs that aren't registered are skipped, and left out of the count and what's passed to the UsersDeleted hook.
+func (d *DB) DeleteUsers(ctx context.Context, urls []string) (int64, int64, error) {
 	userCount := len(urls)
 	if userCount < 1 {
-		return 0, ErrNoUsersProvided
+		return 0, 0, ErrNoUsersProvided
 	}
 
-	tx, err := d.conn.Begin()
+	tx, err := d.beginWrite(ctx)
 	if err != nil {
-		return 0, fmt.Errorf("when beginning tx to delete %d users: %w", userCount, err)
+		return 0, 0, fmt.Errorf("when beginning tx to delete %d users: %w", userCount, err)
 	}
 	defer func() {
 		_ = tx.Rollback()
@@ -295,7 +469,7 @@ func (d *DB) DeleteUsers(ctx context.Context, urls []string) (int64, error) {
 	delTweetsStmtStr := "DELETE FROM tweets WHERE user_id IN (SELECT id FROM users WHERE url = ?)"
 	delTweetsStmt, err := tx.Prepare(delTweetsStmtStr)
 	if err != nil {
-		return 0, fmt.Errorf("when preparing stmt to delete tweets from %d users: %w", userCount, err)
+		return 0, 0, fmt.Errorf("when preparing stmt to delete tweets from %d users: %w", userCount, err)
 	}
 	defer func() {
 		_ = delTweetsStmt.Close()
@@ -304,38 +478,150 @@ func (d *DB) DeleteUsers(ctx context.Context, urls []string) (int64, error) {
 	delUserStmtStr := "DELETE FROM users WHERE url = ?"
 	delUserStmt, err := tx.Prepare(delUserStmtStr)
 	if err != nil {
-		return 0, fmt.Errorf("when preparing stmt to delete %d users: %w", userCount, err)
+		return 0, 0, fmt.Errorf("when preparing stmt to delete %d users: %w", userCount, err)
 	}
 	defer func() {
 		_ = delUserStmt.Close()
 	}()
 
+	deleted := make([]string, 0, userCount)
 	for _, user := range urls {
 		tweetRes, err := delTweetsStmt.ExecContext(ctx, user)
 		if err != nil {
-			return 0, fmt.Errorf("when deleting tweets for user %s: %w", user, err)
+			return 0, 0, fmt.Errorf("when deleting tweets for user %s: %w", user, err)
 		}
 		thisTweetCount, err := tweetRes.RowsAffected()
 		if err != nil {
-			return 0, fmt.Errorf("when deleting tweets for user %s: %w", user, err)
+			return 0, 0, fmt.Errorf("when deleting tweets for user %s: %w", user, err)
 		}
 		tweetCount += thisTweetCount
 
-		_, err = delUserStmt.ExecContext(ctx, user)
+		userRes, err := delUserStmt.ExecContext(ctx, user)
 		if err != nil {
-			return 0, fmt.Errorf("when deleting user %s: %w", user, err)
+			return 0, 0, fmt.Errorf("when deleting user %s: %w", user, err)
+		}
+		if n, err := userRes.RowsAffected(); err != nil {
+			return 0, 0, fmt.Errorf("when deleting user %s: %w", user, err)
+		} else if n > 0 {
+			deleted = append(deleted, user)
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return 0, 0, fmt.Errorf("when committing tx to delete %d users: %w", userCount, err)
+	}
+	d.invalidate()
+
+	d.Hooks.usersDeleted(ctx, deleted)
+	d.Hooks.tweetsDeleted(ctx, tweetCount)
+
+	return int64(len(deleted)), tweetCount, nil
+}
+
+// MergeUsers moves the tweets, followers, webmentions, and other records of the user at loserURL
+// to the user at winnerURL, then deletes the loser.
+// Tweets the winner already has are dropped. Returns the number of tweets moved and the number dropped as duplicates.
+func (d *DB) MergeUsers(ctx context.Context, loserURL, winnerURL string) (int64, int64, error) {
+	if strings.TrimSpace(loserURL) == "" || strings.TrimSpace(winnerURL) == "" {
+		return 0, 0, ErrNoUsersProvided
+	}
+	if loserURL == winnerURL {
+		return 0, 0, fmt.Errorf("can't merge user %s into itself", loserURL)
+	}
+
+	loser, err := d.GetFullUserByURL(ctx, loserURL)
+	if err != nil {
+		return 0, 0, err
+	}
+	winner, err := d.GetFullUserByURL(ctx, winnerURL)
+	if err != nil {
+		return 0, 0, err
+	}
+
+	tx, err := d.beginWrite(ctx)
+	if err != nil {
+		return 0, 0, fmt.Errorf("when beginning tx to merge user %s into %s: %w", loserURL, winnerURL, err)
+	}
+	defer func() {
+		_ = tx.Rollback()
+	}()
+
+	// Tweets the winner already has are dropped; their tags, revisions, and filter records go with them.
+	dupeStmt := `DELETE FROM tweets WHERE user_id = ? AND EXISTS (
+		SELECT 1 FROM tweets AS kept WHERE kept.user_id = ? AND kept.dt = tweets.dt AND kept.body = tweets.body)`
+	dupeRes, err := tx.ExecContext(ctx, dupeStmt, loser.ID, winner.ID)
+	if err != nil {
+		return 0, 0, fmt.Errorf("when deleting tweets of user %s already held by %s: %w", loserURL, winnerURL, err)
+	}
+	dropped, err := dupeRes.RowsAffected()
+	if err != nil {
+		return 0, 0, fmt.Errorf("when deleting tweets of user %s already held by %s: %w", loserURL, winnerURL, err)
+	}
+
+	// Tweet IDs don't change, so everything keyed on them follows, and tweetsUpdate keeps the search index in step.
+	moveRes, err := tx.ExecContext(ctx, "UPDATE tweets SET user_id = ? WHERE user_id = ?", winner.ID, loser.ID)
+	if err != nil {
+		return 0, 0, fmt.Errorf("when moving tweets from user %s to %s: %w", loserURL, winnerURL, err)
+	}
+	moved, err := moveRes.RowsAffected()
+	if err != nil {
+		return 0, 0, fmt.Errorf("when moving tweets from user %s to %s: %w", loserURL, winnerURL, err)
+	}
+
+	// Rows the winner already has an equivalent of stay behind and are removed along with the loser.
+	for _, table := range []string{"feed_urls", "ap_followers", "user_sources", "webmentions", "hosted_feeds"} {
+		stmt := fmt.Sprintf("UPDATE OR IGNORE %s SET user_id = ? WHERE user_id = ?", table)
+		if _, err := tx.ExecContext(ctx, stmt, winner.ID, loser.ID); err != nil {
+			return 0, 0, fmt.Errorf("when moving %s from user %s to %s: %w", table, loserURL, winnerURL, err)
+		}
+	}
+
+	if _, err := tx.ExecContext(ctx, "DELETE FROM users WHERE id = ?", loser.ID); err != nil {
+		return 0, 0, fmt.Errorf("when deleting user %s: %w", loserURL, err)
+	}
+	// The winner may have been left without a canonical URL because the loser already had it.
+	if canonical, err := CanonicalURL(winner.URL); err == nil {
+		stmt := "UPDATE OR IGNORE users SET canonical_url = ? WHERE id = ? AND canonical_url IS NULL"
+		if _, err := tx.ExecContext(ctx, stmt, canonical, winner.ID); err != nil {
+			return 0, 0, fmt.Errorf("when storing canonical URL of user %s: %w", winnerURL, err)
 		}
 	}
 
 	if err := tx.Commit(); err != nil {
-		return 0, fmt.Errorf("when committing tx to delete %d users: %w", userCount, err)
+		return 0, 0, fmt.Errorf("when committing tx to merge user %s into %s: %w", loserURL, winnerURL, err)
 	}
+	d.invalidate()
+
+	d.Hooks.usersDeleted(ctx, []string{loserURL})
 
-	return tweetCount, nil
+	return moved, dropped, nil
 }
 
 // GetUsers gets a page's worth of users.
+// The first few pages are served from the read cache when it's enabled.
 func (d *DB) GetUsers(ctx context.Context, page, perPage int) ([]User, error) {
+	ctx, cancel := d.withQueryTimeout(ctx)
+	defer cancel()
+
+	if !d.cache.cachesPage(page) {
+		return d.getUsers(ctx, page, perPage)
+	}
+
+	key := usersCacheKey(page, perPage)
+	cached, gen, ok := d.cache.get(key)
+	if ok {
+		return append([]User(nil), cached.([]User)...), nil
+	}
+	users, err := d.getUsers(ctx, page, perPage)
+	if err != nil {
+		return nil, err
+	}
+	d.cache.put(key, gen, append([]User(nil), users...))
+
+	return users, nil
+}
+
+func (d *DB) getUsers(ctx context.Context, page, perPage int) ([]User, error) {
 	page--
 	if perPage < d.EntriesPerPageMin {
 		perPage = d.EntriesPerPageMin
@@ -349,11 +635,11 @@ func (d *DB) GetUsers(ctx context.Context, page, perPage int) ([]User, error) {
 	idFloor := page * perPage
 	idCeil := idFloor + perPage
 
-	userStmt := `SELECT id, url, nick, dt_added, last_sync
-					FROM (SELECT *, ROW_NUMBER() OVER (ORDER BY dt_added DESC) AS set_id FROM users)
+	userStmt := `SELECT id, url, nick, dt_added, last_sync, meta_nick, avatar, description
+					FROM (SELECT *, ROW_NUMBER() OVER (ORDER BY dt_added DESC) AS set_id FROM users WHERE status = 'active')
 					WHERE set_id > ?
   					AND set_id <= ?`
-	rows, err := d.conn.QueryContext(ctx, userStmt, idFloor, idCeil)
+	rows, err := d.queryPrepared(ctx, userStmt, idFloor, idCeil)
 	if err != nil {
 		return nil, fmt.Errorf("when querying for users %d - %d: %w", idFloor+1, idCeil+1, err)
 	}
@@ -366,7 +652,7 @@ func (d *DB) GetUsers(ctx context.Context, page, perPage int) ([]User, error) {
 		dt := int64(0)
 		ls := int64(0)
 		thisUser := User{}
-		err := rows.Scan(&thisUser.ID, &thisUser.URL, &thisUser.Nick, &dt, &ls)
+		err := rows.Scan(&thisUser.ID, &thisUser.URL, &thisUser.Nick, &dt, &ls, &thisUser.DeclaredNick, &thisUser.Avatar, &thisUser.Description)
 		if err != nil {
 			d.logger.Debugf("when querying for users %d - %d: %s", idFloor+1, idCeil+1, err)
 			continue
@@ -376,12 +662,19 @@ func (d *DB) GetUsers(ctx context.Context, page, perPage int) ([]User, error) {
 		users = append(users, thisUser)
 	}
 
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("when reading users %d - %d: %w", idFloor+1, idCeil+1, err)
+	}
+
 	return users, nil
 }
 
 // GetAllUsers retrieves all users without pagination.
 func (d *DB) GetAllUsers(ctx context.Context) ([]User, error) {
-	userStmt := `SELECT id, url, nick, dt_added, last_sync FROM users`
+	ctx, cancel := d.withQueryTimeout(ctx)
+	defer cancel()
+
+	userStmt := `SELECT id, url, nick, dt_added, last_sync, status FROM users`
 	rows, err := d.conn.QueryContext(ctx, userStmt)
 	if err != nil {
 		return nil, fmt.Errorf("when querying for all users: %w", err)
@@ -395,7 +688,7 @@ func (d *DB) GetAllUsers(ctx context.Context) ([]User, error) {
 		dt := int64(0)
 		ls := int64(0)
 		thisUser := User{}
-		err := rows.Scan(&thisUser.ID, &thisUser.URL, &thisUser.Nick, &dt, &ls)
+		err := rows.Scan(&thisUser.ID, &thisUser.URL, &thisUser.Nick, &dt, &ls, &thisUser.Status)
 		if err != nil {
 			d.logger.Debugf("when querying for all users: %s", err)
 			continue
@@ -405,11 +698,64 @@ func (d *DB) GetAllUsers(ctx context.Context) ([]User, error) {
 		users = append(users, thisUser)
 	}
 
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("when reading all users: %w", err)
+	}
+
 	return users, nil
 }
 
+// GetUsersDueForSync retrieves up to limit users last synced before olderThan, least recently synced first.
+// Users backing off after failed fetches are left out until their backoff expires, as are suspended and inactive users
+// and those whose feeds are banned.
+// Hosted feeds are never due, as their tweets are already here.
+// A limit below 1 means no limit.
+func (d *DB) GetUsersDueForSync(ctx context.Context, limit int, olderThan time.Time) ([]User, error) {
+	ctx, cancel := d.withQueryTimeout(ctx)
+	defer cancel()
+
+	if limit < 1 {
+		// SQLite treats a negative limit as no limit.
+		limit = -1
+	}
+
+	userStmt := `SELECT id, url, nick, dt_added, last_sync, status, content_hash, content_length, content_tail_hash, partial_fetches FROM users
+					WHERE last_sync < ? AND next_sync <= ? AND status IN ('active', 'pending-verification', 'pending-approval')
+						AND id NOT IN (SELECT user_id FROM hosted_feeds)
+					ORDER BY last_sync ASC, id ASC
+					LIMIT ?`
+	rows, err := d.queryPrepared(ctx, userStmt, olderThan.UnixNano(), time.Now().UnixNano(), limit)
+	if err != nil {
+		return nil, fmt.Errorf("when querying for users due for sync: %w", err)
+	}
+	defer func() {
+		_ = rows.Close()
+	}()
+
+	users := make([]User, 0)
+	for rows.Next() {
+		dt := int64(0)
+		ls := int64(0)
+		thisUser := User{}
+		err := rows.Scan(&thisUser.ID, &thisUser.URL, &thisUser.Nick, &dt, &ls, &thisUser.Status, &thisUser.ContentHash, &thisUser.ContentLength,
+			&thisUser.ContentTailHash, &thisUser.PartialFetches)
+		if err != nil {
+			d.logger.Debugf("when querying for users due for sync: %s", err)
+			continue
+		}
+		thisUser.DateTimeAdded = time.Unix(0, dt)
+		thisUser.LastSync = time.Unix(0, ls)
+		users = append(users, thisUser)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("when reading users due for sync: %w", err)
+	}
+
+	return d.withoutBanned(ctx, users)
+}
+
 func (d *DB) UpdateUsersSyncTime(ctx context.Context, users []User) error {
-	tx, err := d.conn.Begin()
+	tx, err := d.beginWrite(ctx)
 	if err != nil {
 		return err
 	}
@@ -417,7 +763,8 @@ func (d *DB) UpdateUsersSyncTime(ctx context.Context, users []User) error {
 		_ = tx.Rollback()
 	}()
 
-	updateStmtStr := `UPDATE users SET last_sync = ? WHERE id = ?`
+	// Reaching a feed clears any backoff from earlier failures.
+	updateStmtStr := `UPDATE users SET last_sync = ?, sync_failures = 0, next_sync = 0 WHERE id = ?`
 	updateStmt, err := tx.Prepare(updateStmtStr)
 	if err != nil {
 		return err
@@ -436,14 +783,18 @@ func (d *DB) UpdateUsersSyncTime(ctx context.Context, users []User) error {
 	if err := tx.Commit(); err != nil {
 		return fmt.Errorf("failed to update users sync time: %w", err)
 	}
+	d.invalidate()
 
 	return nil
 }
 
 // SearchUsers returns a paginated list of users whose nicknames or URLs match the query.
 func (d *DB) SearchUsers(ctx context.Context, page, perPage int, searchTerm string) ([]User, error) {
+	ctx, cancel := d.withQueryTimeout(ctx)
+	defer cancel()
+
 	// SQLite expects the format %term% for arbitrary characters on either side of the search term.
-	searchTerm = fmt.Sprintf("%%%s%%", searchTerm)
+	searchTerm = fmt.Sprintf("%%%s%%", normalizeSearchTerm(searchTerm))
 	page--
 	if perPage < d.EntriesPerPageMin {
 		perPage = d.EntriesPerPageMin
@@ -457,11 +808,11 @@ func (d *DB) SearchUsers(ctx context.Context, page, perPage int, searchTerm stri
 	idFloor := page * perPage
 	idCeil := idFloor + perPage
 
-	searchStmt := `SELECT id, url, nick, dt_added, last_sync
-					FROM (SELECT *, ROW_NUMBER() OVER (ORDER BY dt_added DESC) AS set_id FROM users WHERE nick LIKE ? OR url LIKE ?)
+	searchStmt := `SELECT id, url, nick, dt_added, last_sync, meta_nick, avatar, description
+					FROM (SELECT *, ROW_NUMBER() OVER (ORDER BY dt_added DESC) AS set_id FROM users WHERE status = 'active' AND (nick LIKE ? OR url LIKE ?))
 					WHERE set_id > ?
   					AND set_id <= ?`
-	rows, err := d.conn.QueryContext(ctx, searchStmt, searchTerm, searchTerm, idFloor, idCeil)
+	rows, err := d.queryPrepared(ctx, searchStmt, searchTerm, searchTerm, idFloor, idCeil)
 	if err != nil {
 		return nil, fmt.Errorf("when querying for users containing %s, %d - %d: %w", searchTerm, idFloor+1, idCeil, err)
 	}
@@ -474,7 +825,7 @@ func (d *DB) SearchUsers(ctx context.Context, page, perPage int, searchTerm stri
 		dt := int64(0)
 		dtSync := int64(0)
 		thisUser := User{}
-		err := rows.Scan(&thisUser.ID, &thisUser.URL, &thisUser.Nick, &dt, &dtSync)
+		err := rows.Scan(&thisUser.ID, &thisUser.URL, &thisUser.Nick, &dt, &dtSync, &thisUser.DeclaredNick, &thisUser.Avatar, &thisUser.Description)
 		if err != nil {
 			d.logger.Debugf("when querying for users containing %s, %d - %d: %s", searchTerm, idFloor+1, idCeil+1, err)
 			continue
@@ -484,18 +835,33 @@ func (d *DB) SearchUsers(ctx context.Context, page, perPage int, searchTerm stri
 		users = append(users, thisUser)
 	}
 
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("when reading users matching %s: %w", searchTerm, err)
+	}
+
 	return users, nil
 }
 
-// SetUserCount counts the users in the database and stores it in memory.
+// SetUserCount reads the count of users in the database, kept up to date by triggers, and stores it in memory.
+// The count is only queried again once it's been invalidated in the read cache, when that's enabled.
 func (d *DB) SetUserCount(ctx context.Context) error {
-	stmt := `SELECT count(*) FROM users`
+	ctx, cancel := d.withQueryTimeout(ctx)
+	defer cancel()
+
+	cached, gen, ok := d.cache.get(userCountCacheKey)
+	if ok {
+		atomic.SwapUint32(&d.userCount, cached.(uint32))
+		return nil
+	}
+
+	stmt := `SELECT count FROM row_counts WHERE name = 'users'`
 	out := uint32(0)
 	if err := d.conn.QueryRowContext(ctx, stmt).Scan(&out); err != nil {
 		return fmt.Errorf("failed to get user count: %w", err)
 	}
 
 	atomic.SwapUint32(&d.userCount, out)
+	d.cache.put(userCountCacheKey, gen, out)
 
 	return nil
 }
@@ -33,6 +33,35 @@ import (
 	"github.com/gbmor/getwtxt-ng/common"
 )
 
+func TestFormatUsersList(t *testing.T) {
+	t.Run("no users", func(t *testing.T) {
+		if out := FormatUsersList(nil); out != "" {
+			t.Errorf("Expected empty string, got: %s", out)
+		}
+	})
+
+	t.Run("users", func(t *testing.T) {
+		out := FormatUsersList(populatedDBUsers)
+		lines := strings.Split(strings.TrimSpace(out), "\n")
+		if len(lines) != len(populatedDBUsers) {
+			t.Fatalf("Expected %d lines, got %d", len(populatedDBUsers), len(lines))
+		}
+		for i, line := range lines {
+			fields := strings.Split(line, "\t")
+			if len(fields) != 3 {
+				t.Errorf("Expected 3 fields, got %d: %s", len(fields), line)
+				continue
+			}
+			if fields[0] != populatedDBUsers[i].Nick || fields[1] != populatedDBUsers[i].URL {
+				t.Errorf("Got unexpected nick/url: %s", line)
+			}
+			if _, err := time.Parse(time.RFC3339, fields[2]); err != nil {
+				t.Errorf("Couldn't parse datetime added: %s", err)
+			}
+		}
+	})
+}
+
 func TestDB_GetUserByURL(t *testing.T) {
 	mockDB, mock := getDBMocker(t)
 	memDB := getPopulatedDB(t)
@@ -56,12 +85,12 @@ func TestDB_GetUserByURL(t *testing.T) {
 	})
 
 	t.Run("couldn't retrieve user", func(t *testing.T) {
-		mock.ExpectQuery("SELECT * FROM users WHERE url = ?").
+		mock.ExpectQuery("SELECT id, url, nick, passcode_hash, dt_added, last_sync, status FROM users WHERE url = ?").
 			WithArgs("https://example.net/twtxt.txt").
 			WillReturnError(sql.ErrNoRows)
 		_, err := mockDB.GetFullUserByURL(ctx, "https://example.net/twtxt.txt")
-		if !errors.Is(err, sql.ErrNoRows) {
-			t.Errorf("Expected sql.ErrNoRows, got: %s", err)
+		if !errors.Is(err, ErrUserNotFound) || !errors.Is(err, sql.ErrNoRows) {
+			t.Errorf("Expected ErrUserNotFound wrapping sql.ErrNoRows, got: %s", err)
 		}
 	})
 
@@ -104,7 +133,7 @@ func TestDB_InsertUser(t *testing.T) {
 		Nick:         "foobaz",
 		PasscodeHash: passcodeHash,
 	}
-	insertStmt := "INSERT INTO users (url, nick, passcode_hash, dt_added, last_sync) VALUES(?,?,?,?, 0)"
+	insertStmt := "INSERT INTO users (url, canonical_url, nick, passcode_hash, dt_added, last_sync, status) VALUES(?,?,?,?,?, 0, ?)"
 
 	t.Run("invalid params provided", func(t *testing.T) {
 		db := DB{}
@@ -147,8 +176,10 @@ func TestDB_InsertUser(t *testing.T) {
 
 	t.Run("fail to insert user, tx done", func(t *testing.T) {
 		mock.ExpectBegin()
+		mock.ExpectQuery(findBanQuery(3)).
+			WillReturnRows(sqlmock.NewRows([]string{"id", "pattern", "source", "dt_added"}))
 		mock.ExpectExec(insertStmt).
-			WithArgs(testUser.URL, testUser.Nick, sqlmock.AnyArg(), sqlmock.AnyArg()).
+			WithArgs(testUser.URL, "example.net/twtxt.txt", testUser.Nick, sqlmock.AnyArg(), sqlmock.AnyArg(), UserStatusActive).
 			WillReturnError(sql.ErrTxDone)
 		mock.ExpectRollback()
 		err := mockDB.InsertUser(ctx, &testUser)
@@ -169,10 +200,10 @@ func TestDB_InsertUser(t *testing.T) {
 		if err != nil {
 			t.Error(err.Error())
 		}
-		getUser := "SELECT * FROM users WHERE url = ?"
+		getUser := "SELECT id, url, nick, passcode_hash, dt_added, last_sync, status FROM users WHERE url = ?"
 		dbUser := User{}
 		dt := int64(0)
-		err = memDB.conn.QueryRow(getUser, testUser.URL).Scan(&dbUser.ID, &dbUser.URL, &dbUser.Nick, &dbUser.PasscodeHash, &dt, &dt)
+		err = memDB.conn.QueryRow(getUser, testUser.URL).Scan(&dbUser.ID, &dbUser.URL, &dbUser.Nick, &dbUser.PasscodeHash, &dt, &dt, &dbUser.Status)
 		if err != nil {
 			t.Error(err.Error())
 		}
@@ -201,6 +232,85 @@ func TestDB_InsertUser(t *testing.T) {
 	}
 }
 
+func TestDB_InsertUserWithTweets(t *testing.T) {
+	ctx := context.Background()
+	passcodeHash, err := common.HashPass("abcdefghij0123456789")
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	newUser := func() User {
+		return User{
+			URL:          "https://example.net/twtxt.txt",
+			Nick:         "foobaz",
+			PasscodeHash: passcodeHash,
+		}
+	}
+	tweets := []Tweet{
+		{DateTime: time.Now().UTC().AddDate(0, 0, -1), Body: "first"},
+		{DateTime: time.Now().UTC(), Body: "second"},
+	}
+
+	t.Run("invalid user", func(t *testing.T) {
+		db := DB{}
+		if _, err := db.InsertUserWithTweets(ctx, nil, tweets); !errors.Is(err, ErrIncompleteUserInfo) {
+			t.Errorf("Expected incomplete user info error, got: %v", err)
+		}
+	})
+
+	t.Run("failed tweet insert rolls back the user", func(t *testing.T) {
+		mockDB, mock := getDBMocker(t)
+		mock.ExpectBegin()
+		mock.ExpectQuery(findBanQuery(3)).
+			WillReturnRows(sqlmock.NewRows([]string{"id", "pattern", "source", "dt_added"}))
+		mock.ExpectExec("INSERT INTO users (url, canonical_url, nick, passcode_hash, dt_added, last_sync, status) VALUES(?,?,?,?,?, 0, ?)").
+			WillReturnResult(sqlmock.NewResult(3, 1))
+		mock.ExpectQuery(insertTweetsQuery(len(tweets))).
+			WillReturnError(sql.ErrTxDone)
+		mock.ExpectRollback()
+		user := newUser()
+		if _, err := mockDB.InsertUserWithTweets(ctx, &user, tweets); !errors.Is(err, sql.ErrTxDone) {
+			t.Errorf("Expected sql.ErrTxDone, got: %v", err)
+		}
+		if err := mock.ExpectationsWereMet(); err != nil {
+			t.Error(err.Error())
+		}
+	})
+
+	t.Run("insert user with tweets", func(t *testing.T) {
+		memDB := getPopulatedDB(t)
+		user := newUser()
+		if _, err := memDB.InsertUserWithTweets(ctx, &user, tweets); err != nil {
+			t.Fatal(err.Error())
+		}
+		if user.ID == "" {
+			t.Error("Expected user ID to be set")
+		}
+		count := 0
+		if err := memDB.conn.QueryRow("SELECT count(*) FROM tweets WHERE user_id = ?", user.ID).Scan(&count); err != nil {
+			t.Fatal(err.Error())
+		}
+		if count != len(tweets) {
+			t.Errorf("Got %d tweets, expected %d", count, len(tweets))
+		}
+	})
+
+	t.Run("duplicate user stores nothing", func(t *testing.T) {
+		memDB := getPopulatedDB(t)
+		user := newUser()
+		user.URL = populatedDBUsers[0].URL
+		if _, err := memDB.InsertUserWithTweets(ctx, &user, tweets); err == nil {
+			t.Fatal("Expected error, got nil")
+		}
+		count := 0
+		if err := memDB.conn.QueryRow("SELECT count(*) FROM tweets WHERE body IN ('first', 'second')").Scan(&count); err != nil {
+			t.Fatal(err.Error())
+		}
+		if count != 0 {
+			t.Errorf("Got %d tweets, expected none", count)
+		}
+	})
+}
+
 func TestDB_DeleteUser(t *testing.T) {
 	memDB := getPopulatedDB(t)
 	mockDB, mock := getDBMocker(t)
@@ -320,7 +430,7 @@ func TestDB_DeleteUsers(t *testing.T) {
 	}
 
 	t.Run("no users provided", func(t *testing.T) {
-		_, err := mockDB.DeleteUsers(ctx, nil)
+		_, _, err := mockDB.DeleteUsers(ctx, nil)
 		if !errors.Is(err, ErrNoUsersProvided) {
 			t.Errorf("Expected ErrNoUsersProvided, got %s", err)
 		}
@@ -328,7 +438,7 @@ func TestDB_DeleteUsers(t *testing.T) {
 
 	t.Run("fail to begin tx", func(t *testing.T) {
 		mock.ExpectBegin().WillReturnError(sql.ErrConnDone)
-		_, err := mockDB.DeleteUsers(ctx, urls)
+		_, _, err := mockDB.DeleteUsers(ctx, urls)
 		if !errors.Is(err, sql.ErrConnDone) {
 			t.Errorf("Expected sql.ErrConnDone, got %s", err)
 		}
@@ -337,7 +447,7 @@ func TestDB_DeleteUsers(t *testing.T) {
 	t.Run("fail to prepare delTweetsStmt", func(t *testing.T) {
 		mock.ExpectBegin()
 		mock.ExpectPrepare(delTweetsStmtStr).WillReturnError(sql.ErrConnDone)
-		_, err := mockDB.DeleteUsers(ctx, urls)
+		_, _, err := mockDB.DeleteUsers(ctx, urls)
 		if !errors.Is(err, sql.ErrConnDone) {
 			t.Errorf("Expected sql.ErrConnDone, got %s", err)
 		}
@@ -347,7 +457,7 @@ func TestDB_DeleteUsers(t *testing.T) {
 		mock.ExpectBegin()
 		mock.ExpectPrepare(delTweetsStmtStr)
 		mock.ExpectPrepare(delUserStmtStr).WillReturnError(sql.ErrConnDone)
-		_, err := mockDB.DeleteUsers(ctx, urls)
+		_, _, err := mockDB.DeleteUsers(ctx, urls)
 		if !errors.Is(err, sql.ErrConnDone) {
 			t.Errorf("Expected sql.ErrConnDone, got %s", err)
 		}
@@ -358,7 +468,7 @@ func TestDB_DeleteUsers(t *testing.T) {
 		delTweets := mock.ExpectPrepare(delTweetsStmtStr)
 		mock.ExpectPrepare(delUserStmtStr)
 		delTweets.ExpectExec().WillReturnError(sql.ErrConnDone)
-		_, err := mockDB.DeleteUsers(ctx, urls)
+		_, _, err := mockDB.DeleteUsers(ctx, urls)
 		if !errors.Is(err, sql.ErrConnDone) {
 			t.Errorf("Expected sql.ErrConnDone, got %s", err)
 		}
@@ -370,7 +480,7 @@ func TestDB_DeleteUsers(t *testing.T) {
 		delUser := mock.ExpectPrepare(delUserStmtStr)
 		delTweets.ExpectExec().WillReturnResult(sqlmock.NewResult(0, 1))
 		delUser.ExpectExec().WillReturnError(sql.ErrConnDone)
-		_, err := mockDB.DeleteUsers(ctx, urls)
+		_, _, err := mockDB.DeleteUsers(ctx, urls)
 		if !errors.Is(err, sql.ErrConnDone) {
 			t.Errorf("Expected sql.ErrConnDone, got %s", err)
 		}
@@ -385,7 +495,7 @@ func TestDB_DeleteUsers(t *testing.T) {
 		delTweets.ExpectExec().WillReturnResult(sqlmock.NewResult(0, 10))
 		delUser.ExpectExec().WillReturnResult(sqlmock.NewResult(0, 1))
 		mock.ExpectCommit().WillReturnError(sql.ErrConnDone)
-		_, err := mockDB.DeleteUsers(ctx, urls)
+		_, _, err := mockDB.DeleteUsers(ctx, urls)
 		if !errors.Is(err, sql.ErrConnDone) {
 			t.Errorf("Expected sql.ErrConnDone, got %s", err)
 		}
@@ -400,20 +510,26 @@ func TestDB_DeleteUsers(t *testing.T) {
 		delTweets.ExpectExec().WillReturnResult(sqlmock.NewResult(0, 10))
 		delUser.ExpectExec().WillReturnResult(sqlmock.NewResult(0, 1))
 		mock.ExpectCommit()
-		nTweets, err := mockDB.DeleteUsers(ctx, urls)
+		nUsers, nTweets, err := mockDB.DeleteUsers(ctx, urls)
 		if err != nil {
 			t.Errorf("Expected no error, got %s", err)
 		}
+		if nUsers != 2 {
+			t.Errorf("Expected 2 users deleted, got %d", nUsers)
+		}
 		if nTweets != 20 {
 			t.Errorf("Expected 20 tweets deleted, got %d", nTweets)
 		}
 	})
 
 	t.Run("successful", func(t *testing.T) {
-		tweets, err := memDB.DeleteUsers(ctx, urls)
+		users, tweets, err := memDB.DeleteUsers(ctx, append(urls, "https://unregistered.example/twtxt.txt"))
 		if err != nil {
 			t.Error(err.Error())
 		}
+		if users != int64(len(urls)) {
+			t.Errorf("Expected %d users removed, leaving out the unregistered URL, got %d removed", len(urls), users)
+		}
 		if tweets != 3 {
 			t.Errorf("Expected 1 tweet removed, got %d removed", tweets)
 		}
@@ -438,7 +554,7 @@ func TestDB_DeleteUsers(t *testing.T) {
 	t.Run("canceled context", func(t *testing.T) {
 		ctx, cancel := context.WithCancel(context.Background())
 		cancel()
-		_, err := memDB.DeleteUsers(ctx, urls)
+		_, _, err := memDB.DeleteUsers(ctx, urls)
 		if err == nil {
 			t.Error("expected error, got none")
 		}
@@ -449,12 +565,13 @@ func TestDB_GetUsers(t *testing.T) {
 	memDB := getPopulatedDB(t)
 	mockDB, mock := getDBMocker(t)
 	ctx := context.Background()
-	userStmt := `SELECT id, url, nick, dt_added, last_sync
-					FROM (SELECT *, ROW_NUMBER() OVER (ORDER BY dt_added DESC) AS set_id FROM users)
+	userStmt := `SELECT id, url, nick, dt_added, last_sync, meta_nick, avatar, description
+					FROM (SELECT *, ROW_NUMBER() OVER (ORDER BY dt_added DESC) AS set_id FROM users WHERE status = 'active')
 					WHERE set_id > ?
   					AND set_id <= ?`
 
 	t.Run("error on query", func(t *testing.T) {
+		mock.ExpectPrepare(userStmt)
 		mock.ExpectQuery(userStmt).
 			WithArgs(0, 20).
 			WillReturnError(sql.ErrNoRows)
@@ -468,8 +585,8 @@ func TestDB_GetUsers(t *testing.T) {
 		mock.ExpectQuery(userStmt).
 			WithArgs(0, 1000).
 			WillReturnRows(
-				sqlmock.NewRows([]string{"id", "url", "nick", "dt_added", "last_sync"}).
-					AddRow("1", "https://example.com", "foobar", "thirty five o'clock", "sync time"))
+				sqlmock.NewRows([]string{"id", "url", "nick", "dt_added", "last_sync", "meta_nick", "avatar", "description"}).
+					AddRow("1", "https://example.com", "foobar", "thirty five o'clock", "sync time", "", "", ""))
 		out, err := mockDB.GetUsers(ctx, 0, 2000)
 		if err != nil {
 			t.Error(err.Error())
@@ -503,16 +620,86 @@ func TestDB_GetUsers(t *testing.T) {
 	}
 }
 
+func TestDB_GetUsersDueForSync(t *testing.T) {
+	memDB := getPopulatedDB(t)
+	ctx := context.Background()
+	now := time.Now().UTC()
+
+	t.Run("most stale first", func(t *testing.T) {
+		out, err := memDB.GetUsersDueForSync(ctx, 0, now)
+		if err != nil {
+			t.Fatal(err.Error())
+		}
+		if len(out) != 2 || out[0].ID != "1" || out[1].ID != "2" {
+			t.Errorf("Expected users 1 then 2, got %+v", out)
+		}
+	})
+
+	t.Run("limit", func(t *testing.T) {
+		out, err := memDB.GetUsersDueForSync(ctx, 1, now)
+		if err != nil {
+			t.Fatal(err.Error())
+		}
+		if len(out) != 1 || out[0].ID != "1" {
+			t.Errorf("Expected only user 1, got %+v", out)
+		}
+	})
+
+	t.Run("older than", func(t *testing.T) {
+		out, err := memDB.GetUsersDueForSync(ctx, 0, now.AddDate(0, 0, -2))
+		if err != nil {
+			t.Fatal(err.Error())
+		}
+		if len(out) != 1 || out[0].ID != "1" {
+			t.Errorf("Expected only user 1, got %+v", out)
+		}
+	})
+
+	t.Run("backed off users are skipped", func(t *testing.T) {
+		if err := memDB.backOffUsers(ctx, []User{populatedDBUsers[0]}); err != nil {
+			t.Fatal(err.Error())
+		}
+		out, err := memDB.GetUsersDueForSync(ctx, 0, now)
+		if err != nil {
+			t.Fatal(err.Error())
+		}
+		if len(out) != 1 || out[0].ID != "2" {
+			t.Errorf("Expected only user 2, got %+v", out)
+		}
+
+		// A successful sync clears the backoff.
+		if err := memDB.UpdateUsersSyncTime(ctx, []User{{ID: "1", LastSync: now.AddDate(0, 0, -20)}}); err != nil {
+			t.Fatal(err.Error())
+		}
+		out, err = memDB.GetUsersDueForSync(ctx, 0, now)
+		if err != nil {
+			t.Fatal(err.Error())
+		}
+		if len(out) != 2 {
+			t.Errorf("Expected both users once the backoff was cleared, got %+v", out)
+		}
+	})
+
+	t.Run("canceled context", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+		if _, err := memDB.GetUsersDueForSync(ctx, 0, now); err == nil {
+			t.Error("expected error, got none")
+		}
+	})
+}
+
 func TestDB_SearchUsers(t *testing.T) {
 	mockDB, mock := getDBMocker(t)
 	ctx := context.Background()
 	searchTerm := "%foo%"
-	searchStmt := `SELECT id, url, nick, dt_added, last_sync
-					FROM (SELECT *, ROW_NUMBER() OVER (ORDER BY dt_added DESC) AS set_id FROM users WHERE nick LIKE ? OR url LIKE ?)
+	searchStmt := `SELECT id, url, nick, dt_added, last_sync, meta_nick, avatar, description
+					FROM (SELECT *, ROW_NUMBER() OVER (ORDER BY dt_added DESC) AS set_id FROM users WHERE status = 'active' AND (nick LIKE ? OR url LIKE ?))
 					WHERE set_id > ?
   					AND set_id <= ?`
 
 	t.Run("error on query", func(t *testing.T) {
+		mock.ExpectPrepare(searchStmt)
 		mock.ExpectQuery(searchStmt).
 			WithArgs(searchTerm, searchTerm, 0, 20).
 			WillReturnError(sql.ErrNoRows)
@@ -525,8 +712,8 @@ func TestDB_SearchUsers(t *testing.T) {
 	t.Run("fail to scan", func(t *testing.T) {
 		mock.ExpectQuery(searchStmt).
 			WithArgs(searchTerm, searchTerm, 0, 1000).
-			WillReturnRows(sqlmock.NewRows([]string{"id", "url", "nick", "dt_added", "last_sync"}).
-				AddRow(5, "https://example.com/twtxt.txt", "foo", "eleventy-three o'clock", 0))
+			WillReturnRows(sqlmock.NewRows([]string{"id", "url", "nick", "dt_added", "last_sync", "meta_nick", "avatar", "description"}).
+				AddRow(5, "https://example.com/twtxt.txt", "foo", "eleventy-three o'clock", 0, "", "", ""))
 		out, err := mockDB.SearchUsers(ctx, 0, 5000, "foo")
 		if err != nil {
 			t.Error(err.Error())
@@ -569,3 +756,112 @@ func TestDB_SearchUsers(t *testing.T) {
 		t.Error(err.Error())
 	}
 }
+
+func TestDB_MergeUsers(t *testing.T) {
+	ctx := context.Background()
+	loser := populatedDBUsers[0]
+	winner := populatedDBUsers[1]
+
+	t.Run("merge into self", func(t *testing.T) {
+		memDB := getPopulatedDB(t)
+		if _, _, err := memDB.MergeUsers(ctx, winner.URL, winner.URL); err == nil {
+			t.Error("expected error, got none")
+		}
+	})
+
+	t.Run("nonexistent user", func(t *testing.T) {
+		memDB := getPopulatedDB(t)
+		if _, _, err := memDB.MergeUsers(ctx, "https://example.net/twtxt.txt", winner.URL); err == nil {
+			t.Error("expected error, got none")
+		}
+	})
+
+	t.Run("merge", func(t *testing.T) {
+		memDB := getPopulatedDB(t)
+		dupe := populatedDBTweets[1]
+		dupe.UserID = loser.ID
+		if _, err := memDB.InsertTweets(ctx, []Tweet{dupe}); err != nil {
+			t.Fatal(err.Error())
+		}
+
+		moved, dropped, err := memDB.MergeUsers(ctx, loser.URL, winner.URL)
+		if err != nil {
+			t.Fatal(err.Error())
+		}
+		if moved != 1 || dropped != 1 {
+			t.Errorf("Got %d moved and %d dropped, expected 1 and 1", moved, dropped)
+		}
+		if _, err := memDB.GetFullUserByURL(ctx, loser.URL); err == nil {
+			t.Errorf("Expected user %s to be deleted", loser.URL)
+		}
+
+		tweets, err := memDB.SearchTweets(ctx, 1, 20, "dog", StatusVisible)
+		if err != nil {
+			t.Fatal(err.Error())
+		}
+		if len(tweets) != 1 || tweets[0].UserID != winner.ID {
+			t.Errorf("Expected moved tweet to belong to %s, got %+v", winner.URL, tweets)
+		}
+		if err := memDB.CheckSearchIndex(ctx); err != nil {
+			t.Errorf("Search index inconsistent after merge: %s", err)
+		}
+	})
+
+	t.Run("records follow the merge", func(t *testing.T) {
+		memDB := getPopulatedDB(t)
+		original := populatedDBTweets[0]
+		edit := Tweet{UserID: loser.ID, DateTime: original.DateTime, Body: "hallo this is dog, edited"}
+		if _, err := memDB.InsertTweets(ctx, []Tweet{edit}); err != nil {
+			t.Fatal(err.Error())
+		}
+		stmt := "INSERT INTO filtered_tweets (tweet_id, rule, action, dt_filtered, dt_released) VALUES (?, 'spam', 'hide', ?, 0)"
+		if _, err := memDB.conn.ExecContext(ctx, stmt, original.ID, time.Now().UnixNano()); err != nil {
+			t.Fatal(err.Error())
+		}
+		for _, f := range []Follower{
+			{UserID: loser.ID, Actor: "https://social.example/users/a", Inbox: "https://social.example/inbox"},
+			{UserID: loser.ID, Actor: "https://social.example/users/b", Inbox: "https://social.example/inbox"},
+			{UserID: winner.ID, Actor: "https://social.example/users/a", Inbox: "https://social.example/inbox"},
+		} {
+			if err := memDB.AddFollower(ctx, f.UserID, f.Actor, f.Inbox); err != nil {
+				t.Fatal(err.Error())
+			}
+		}
+
+		if _, _, err := memDB.MergeUsers(ctx, loser.URL, winner.URL); err != nil {
+			t.Fatal(err.Error())
+		}
+
+		moved, err := memDB.GetTweetsByID(ctx, []string{original.ID})
+		if err != nil {
+			t.Fatal(err.Error())
+		}
+		if len(moved) != 1 || moved[0].UserID != winner.ID {
+			t.Errorf("Expected tweet %s to move to %s in place, got: %+v", original.ID, winner.URL, moved)
+		}
+		revisions, err := memDB.GetTweetRevisions(ctx, original.ID)
+		if err != nil {
+			t.Fatal(err.Error())
+		}
+		if len(revisions) != 1 || revisions[0].Body != original.Body {
+			t.Errorf("Expected the revision to survive the merge, got: %+v", revisions)
+		}
+		filtered, err := memDB.GetFilteredTweets(ctx, 10)
+		if err != nil {
+			t.Fatal(err.Error())
+		}
+		if len(filtered) != 1 || filtered[0].Tweet.ID != original.ID {
+			t.Errorf("Expected the filtered tweet to survive the merge, got: %+v", filtered)
+		}
+		followers, err := memDB.GetFollowers(ctx, winner.ID)
+		if err != nil {
+			t.Fatal(err.Error())
+		}
+		if len(followers) != 2 {
+			t.Errorf("Expected the winner to have 2 followers, got: %+v", followers)
+		}
+		if err := memDB.CheckSearchIndex(ctx); err != nil {
+			t.Errorf("Search index inconsistent after merge: %s", err)
+		}
+	})
+}
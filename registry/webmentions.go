@@ -0,0 +1,176 @@
+package registry
+
+/*
+Copyright 2021 G. Benjamin Morrison
+
+This file is part of getwtxt-ng.
+
+getwtxt-ng is free software: you can redistribute it and/or modify
+it under the terms of the GNU Affero General Public License as
+published by the Free Software Foundation, either version 3 of the
+License, or (at your option) any later version.
+
+getwtxt-ng is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU Affero General Public License for more details.
+
+You should have received a copy of the GNU Affero General Public License
+along with getwtxt-ng.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// Webmention is a page elsewhere on the web that links to a user's feed or one of their tweets.
+type Webmention struct {
+	Source  string `json:"source"`
+	Target  string `json:"target"`
+	UserURL string `json:"user_url"`
+
+	// TwtHash is the hash of the tweet that was mentioned, or empty if the mention is of the feed itself.
+	TwtHash string `json:"twt_hash,omitempty"`
+
+	Received time.Time `json:"received"`
+}
+
+// FormatWebmentionsPlain formats the provided slice of Webmention into plain text, with each LF-terminated line
+// containing the following tab-separated values:
+//   - Source
+//   - Target
+//   - Timestamp Received (RFC3339)
+func FormatWebmentionsPlain(mentions []Webmention) string {
+	return FormatWebmentionsPlainWith(mentions, PlainFormat{})
+}
+
+// FormatWebmentionsPlainWith formats mentions as FormatWebmentionsPlain does, with timestamps written as f says.
+func FormatWebmentionsPlainWith(mentions []Webmention, f PlainFormat) string {
+	if len(mentions) < 1 {
+		return ""
+	}
+
+	builder := strings.Builder{}
+	builder.Grow(len(mentions) * 128)
+	for _, m := range mentions {
+		builder.WriteString(m.Source)
+		builder.WriteString("\t")
+		builder.WriteString(m.Target)
+		builder.WriteString("\t")
+		builder.WriteString(f.Timestamp(m.Received))
+		builder.WriteString("\n")
+	}
+
+	return builder.String()
+}
+
+// AddWebmention records a verified Webmention of the active user with the URL in m.UserURL.
+// Receiving the same source and target again updates when it was received.
+// Returns sql.ErrNoRows, wrapped, if there's no such user.
+func (d *DB) AddWebmention(ctx context.Context, m *Webmention) error {
+	if m.Source == "" || m.Target == "" || m.UserURL == "" {
+		return fmt.Errorf("can't add webmention: missing source, target, or user URL")
+	}
+
+	userID := ""
+	if err := d.conn.QueryRowContext(ctx, "SELECT id FROM users WHERE url = ? AND status = 'active'", m.UserURL).Scan(&userID); err != nil {
+		return fmt.Errorf("unable to query for user with URL %s: %w", m.UserURL, err)
+	}
+
+	m.Received = time.Now().UTC()
+	stmt := `INSERT INTO webmentions (user_id, twt_hash, source, target, dt_received) VALUES (?, ?, ?, ?, ?)
+				ON CONFLICT (source, target) DO UPDATE SET dt_received = excluded.dt_received`
+	if _, err := d.execWrite(ctx, stmt, userID, m.TwtHash, m.Source, m.Target, m.Received.UnixNano()); err != nil {
+		return fmt.Errorf("when adding webmention of %s from %s: %w", m.Target, m.Source, err)
+	}
+	d.invalidate()
+
+	return nil
+}
+
+// RemoveWebmention forgets the Webmention of target from source, as when the source no longer links to it.
+// Returns sql.ErrNoRows, wrapped, if there wasn't one.
+func (d *DB) RemoveWebmention(ctx context.Context, source, target string) error {
+	res, err := d.execWrite(ctx, "DELETE FROM webmentions WHERE source = ? AND target = ?", source, target)
+	if err != nil {
+		return fmt.Errorf("when removing webmention of %s from %s: %w", target, source, err)
+	}
+	removed, err := res.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("when removing webmention of %s from %s: %w", target, source, err)
+	}
+	if removed == 0 {
+		return fmt.Errorf("no webmention of %s from %s: %w", target, source, sql.ErrNoRows)
+	}
+	d.invalidate()
+
+	return nil
+}
+
+// GetUserWebmentions retrieves the Webmentions of the active user with the provided URL and their tweets, newest first.
+func (d *DB) GetUserWebmentions(ctx context.Context, userURL string) ([]Webmention, error) {
+	ctx, cancel := d.withQueryTimeout(ctx)
+	defer cancel()
+
+	stmt := `SELECT webmentions.source, webmentions.target, users.url, webmentions.twt_hash, webmentions.dt_received
+				FROM webmentions JOIN users ON users.id = webmentions.user_id
+				WHERE users.url = ? AND users.status = 'active'
+				ORDER BY webmentions.dt_received DESC, webmentions.id DESC`
+	rows, err := d.conn.QueryContext(ctx, stmt, userURL)
+	if err != nil {
+		return nil, fmt.Errorf("when querying for webmentions of user %s: %w", userURL, err)
+	}
+	defer func() {
+		_ = rows.Close()
+	}()
+
+	return d.scanWebmentionRows(rows)
+}
+
+// GetTwtWebmentions retrieves the Webmentions of tweets with the provided twt hash, newest first.
+func (d *DB) GetTwtWebmentions(ctx context.Context, hash string) ([]Webmention, error) {
+	ctx, cancel := d.withQueryTimeout(ctx)
+	defer cancel()
+
+	hash = strings.ToLower(strings.TrimSpace(hash))
+	if !RegexIsTwtHash.MatchString(hash) {
+		return nil, fmt.Errorf("%w: %s", ErrInvalidTwtHash, hash)
+	}
+
+	stmt := `SELECT webmentions.source, webmentions.target, users.url, webmentions.twt_hash, webmentions.dt_received
+				FROM webmentions JOIN users ON users.id = webmentions.user_id
+				WHERE webmentions.twt_hash = ? AND users.status = 'active'
+				ORDER BY webmentions.dt_received DESC, webmentions.id DESC`
+	rows, err := d.conn.QueryContext(ctx, stmt, hash)
+	if err != nil {
+		return nil, fmt.Errorf("when querying for webmentions of twt %s: %w", hash, err)
+	}
+	defer func() {
+		_ = rows.Close()
+	}()
+
+	return d.scanWebmentionRows(rows)
+}
+
+func (d *DB) scanWebmentionRows(rows *sql.Rows) ([]Webmention, error) {
+	mentions := make([]Webmention, 0)
+	for rows.Next() {
+		m := Webmention{}
+		received := int64(0)
+		if err := rows.Scan(&m.Source, &m.Target, &m.UserURL, &m.TwtHash, &received); err != nil {
+			d.logger.Debugf("when scanning webmention: %s", err)
+			continue
+		}
+		m.Received = time.Unix(0, received).UTC()
+		mentions = append(mentions, m)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("when reading webmentions: %w", err)
+	}
+
+	return mentions, nil
+}
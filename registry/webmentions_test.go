@@ -0,0 +1,90 @@
+package registry
+
+/*
+Copyright 2021 G. Benjamin Morrison
+
+This file is part of getwtxt-ng.
+
+getwtxt-ng is free software: you can redistribute it and/or modify
+it under the terms of the GNU Affero General Public License as
+published by the Free Software Foundation, either version 3 of the
+License, or (at your option) any later version.
+
+getwtxt-ng is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU Affero General Public License for more details.
+
+You should have received a copy of the GNU Affero General Public License
+along with getwtxt-ng.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"testing"
+)
+
+func TestDB_Webmentions(t *testing.T) {
+	db := getPopulatedDB(t)
+	defer func() {
+		_ = db.Close()
+	}()
+	ctx := context.Background()
+	userURL := populatedDBUsers[0].URL
+	hash := "abcdefg"
+
+	feedMention := Webmention{Source: "https://blog.example/post", Target: userURL, UserURL: userURL}
+	if err := db.AddWebmention(ctx, &feedMention); err != nil {
+		t.Fatal(err.Error())
+	}
+	twtMention := Webmention{Source: "https://blog.example/reply", Target: "https://registry.example/api/json/conversations/" + hash, UserURL: userURL, TwtHash: hash}
+	if err := db.AddWebmention(ctx, &twtMention); err != nil {
+		t.Fatal(err.Error())
+	}
+	if err := db.AddWebmention(ctx, &twtMention); err != nil {
+		t.Fatalf("Expected receiving a webmention again to succeed, got: %s", err)
+	}
+	unknown := Webmention{Source: "https://blog.example/post", Target: "https://nobody.example/twtxt.txt", UserURL: "https://nobody.example/twtxt.txt"}
+	if err := db.AddWebmention(ctx, &unknown); !errors.Is(err, sql.ErrNoRows) {
+		t.Errorf("Expected sql.ErrNoRows for unknown user, got: %v", err)
+	}
+
+	mentions, err := db.GetUserWebmentions(ctx, userURL)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	if len(mentions) != 2 || mentions[0].Source != twtMention.Source {
+		t.Errorf("Expected 2 webmentions, newest first, got: %v", mentions)
+	}
+
+	mentions, err = db.GetTwtWebmentions(ctx, hash)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	if len(mentions) != 1 || mentions[0].TwtHash != hash || mentions[0].UserURL != userURL {
+		t.Errorf("Expected the twt's webmention, got: %v", mentions)
+	}
+	if _, err := db.GetTwtWebmentions(ctx, "not a hash"); !errors.Is(err, ErrInvalidTwtHash) {
+		t.Errorf("Expected ErrInvalidTwtHash, got: %v", err)
+	}
+
+	if err := db.RemoveWebmention(ctx, feedMention.Source, feedMention.Target); err != nil {
+		t.Fatal(err.Error())
+	}
+	if err := db.RemoveWebmention(ctx, feedMention.Source, feedMention.Target); !errors.Is(err, sql.ErrNoRows) {
+		t.Errorf("Expected sql.ErrNoRows removing a webmention twice, got: %v", err)
+	}
+
+	if _, err := db.DeleteUser(ctx, &populatedDBUsers[0]); err != nil {
+		t.Fatal(err.Error())
+	}
+	count := 0
+	if err := db.conn.QueryRow("SELECT COUNT(*) FROM webmentions").Scan(&count); err != nil {
+		t.Fatal(err.Error())
+	}
+	if count != 0 {
+		t.Errorf("Expected webmentions to be removed with the user, got %d rows", count)
+	}
+}
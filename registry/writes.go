@@ -0,0 +1,89 @@
+package registry
+
+/*
+Copyright 2021 G. Benjamin Morrison
+
+This file is part of getwtxt-ng.
+
+getwtxt-ng is free software: you can redistribute it and/or modify
+it under the terms of the GNU Affero General Public License as
+published by the Free Software Foundation, either version 3 of the
+License, or (at your option) any later version.
+
+getwtxt-ng is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU Affero General Public License for more details.
+
+You should have received a copy of the GNU Affero General Public License
+along with getwtxt-ng.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"strings"
+	"sync"
+)
+
+// sqliteBusyTimeoutMS is how long a connection waits for another process's write to finish
+// before giving up with "database is locked".
+const sqliteBusyTimeoutMS = 10000
+
+// sqliteDSN adds the connection parameters the registry relies on to the database path.
+// Transactions take the write lock as soon as they begin, so two of them can't both read
+// and then fail to upgrade, and writers wait on each other for up to sqliteBusyTimeoutMS.
+func sqliteDSN(dbPath string) string {
+	sep := "?"
+	if strings.Contains(dbPath, "?") {
+		sep = "&"
+	}
+	return fmt.Sprintf("%s%s_txlock=immediate&_busy_timeout=%d", dbPath, sep, sqliteBusyTimeoutMS)
+}
+
+// writeTx is a transaction holding the DB's write lock.
+// The lock is released when the transaction is committed or rolled back, whichever comes first.
+type writeTx struct {
+	*sql.Tx
+	release func()
+}
+
+// Commit commits the transaction and releases the write lock.
+func (tx *writeTx) Commit() error {
+	defer tx.release()
+	return tx.Tx.Commit()
+}
+
+// Rollback rolls back the transaction and releases the write lock.
+func (tx *writeTx) Rollback() error {
+	defer tx.release()
+	return tx.Tx.Rollback()
+}
+
+// beginWrite waits for any other write through this DB to finish, then begins a transaction.
+// SQLite allows a single writer, so writes are queued here rather than left to fail with
+// "database is locked". Reads don't take the lock, so they carry on concurrently.
+func (d *DB) beginWrite(ctx context.Context) (*writeTx, error) {
+	d.writeMu.Lock()
+	tx, err := d.conn.BeginTx(ctx, nil)
+	if err != nil {
+		d.writeMu.Unlock()
+		return nil, err
+	}
+
+	once := sync.Once{}
+	return &writeTx{
+		Tx: tx,
+		release: func() {
+			once.Do(d.writeMu.Unlock)
+		},
+	}, nil
+}
+
+// execWrite runs a single statement that writes to the database, queued behind any other write.
+func (d *DB) execWrite(ctx context.Context, query string, args ...interface{}) (sql.Result, error) {
+	d.writeMu.Lock()
+	defer d.writeMu.Unlock()
+	return d.conn.ExecContext(ctx, query, args...)
+}
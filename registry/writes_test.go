@@ -0,0 +1,93 @@
+package registry
+
+/*
+Copyright 2021 G. Benjamin Morrison
+
+This file is part of getwtxt-ng.
+
+getwtxt-ng is free software: you can redistribute it and/or modify
+it under the terms of the GNU Affero General Public License as
+published by the Free Software Foundation, either version 3 of the
+License, or (at your option) any later version.
+
+getwtxt-ng is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU Affero General Public License for more details.
+
+You should have received a copy of the GNU Affero General Public License
+along with getwtxt-ng.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+import (
+	"context"
+	"fmt"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+)
+
+func Test_sqliteDSN(t *testing.T) {
+	cases := map[string]string{
+		"getwtxt-ng.db":              "getwtxt-ng.db?_txlock=immediate&_busy_timeout=10000",
+		"file:getwtxt-ng.db?mode=rw": "file:getwtxt-ng.db?mode=rw&_txlock=immediate&_busy_timeout=10000",
+	}
+	for in, want := range cases {
+		if got := sqliteDSN(in); got != want {
+			t.Errorf("Expected %s, got %s", want, got)
+		}
+	}
+}
+
+func TestDB_concurrentWrites(t *testing.T) {
+	db, err := Open(filepath.Join(t.TempDir(), "writes.db"))
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	defer func() {
+		_ = db.Close()
+	}()
+	ctx := context.Background()
+
+	const writers = 20
+	errs := make(chan error, writers*2)
+	wg := sync.WaitGroup{}
+	for i := 0; i < writers; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			u := User{
+				Nick:         fmt.Sprintf("writer%d", i),
+				URL:          fmt.Sprintf("https://example.com/writer%d/twtxt.txt", i),
+				PasscodeHash: []byte("hash"),
+			}
+			if err := db.InsertUser(ctx, &u); err != nil {
+				errs <- err
+				return
+			}
+			tweets := []Tweet{{
+				UserID:   u.ID,
+				Nickname: u.Nick,
+				URL:      u.URL,
+				DateTime: time.Now(),
+				Body:     fmt.Sprintf("hello from writer %d", i),
+			}}
+			if _, err := db.InsertTweets(ctx, tweets); err != nil {
+				errs <- err
+			}
+		}(i)
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		t.Errorf("Concurrent write failed: %s", err)
+	}
+
+	if err := db.SetTweetCount(ctx); err != nil {
+		t.Fatal(err.Error())
+	}
+	if db.GetTweetCount() != writers {
+		t.Errorf("Expected %d tweets, got %d", writers, db.GetTweetCount())
+	}
+}